@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"sso/internal/app"
+	"sso/internal/buildinfo"
 	"sso/internal/config"
 	"syscall"
 )
@@ -18,44 +19,90 @@ const (
 func main() {
 	cfg := config.MustLoad()
 
-	log := setupLogger(cfg.Env)
+	level := new(slog.LevelVar)
+	level.Set(logLevel(cfg))
+	log := setupLogger(cfg.Env, level)
 
-	log.Info("starting application")
+	build := buildinfo.Get()
+	log.Info("starting application",
+		slog.String("version", build.Version),
+		slog.String("commit", build.Commit),
+	)
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL)
+	application := app.New(log, cfg)
+	if err := application.Validate(); err != nil {
+		log.Error("application is incomplete", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	reloader := config.NewReloader(log, cfg)
+	reloader.OnReload(func(cfg *config.Config) { level.Set(logLevel(cfg)) })
+	reloader.OnReload(application.Reload)
 
-	go application.GRPCServer.MustRun()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("reloading config")
+			// Failures are logged by the reloader.
+			_ = reloader.Reload()
+		}
+	}()
 
 	// TODO: implement db application
 
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- application.Run()
+	}()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
-	sysSign := <-stop
+	select {
+	case sysSign := <-stop:
+		log.Info("stopping application", slog.String("signal", sysSign.String()))
+	case err := <-runErr:
+		log.Error("application failed", slog.Any("error", err))
 
-	log.Info("stopping application", slog.String("signal", sysSign.String()))
+		application.Stop()
+		os.Exit(1)
+	}
 
-	application.GRPCServer.Stop()
+	application.Stop()
 
 	log.Info("application stopped")
 }
 
-func setupLogger(env string) *slog.Logger {
+func setupLogger(env string, level slog.Leveler) *slog.Logger {
 	var log *slog.Logger
 	switch env {
 	case envLocal:
 		log = slog.New(
-			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
-		)
-	case envDev:
-		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}),
+			slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
 		)
-	case envProd:
+	case envDev, envProd:
 		log = slog.New(
-			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}),
+			slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
 		)
 	}
 
 	return log
 }
+
+// logLevel returns the configured log level, or the default of the
+// environment: debug everywhere but in prod.
+func logLevel(cfg *config.Config) slog.Level {
+	var level slog.Level
+	if cfg.LogLevel != "" {
+		// Validated when the config was loaded.
+		_ = level.UnmarshalText([]byte(cfg.LogLevel))
+
+		return level
+	}
+	if cfg.Env == envProd {
+		return slog.LevelInfo
+	}
+
+	return slog.LevelDebug
+}
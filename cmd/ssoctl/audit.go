@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/services/auditexport"
+	"sso/internal/storage/sqlite"
+)
+
+const auditReplayPage = 500
+
+// runAuditReplay exports the outbox events created in [-from, -to) to a
+// sink again, as the server exports them, e.g. after the SIEM lost some.
+// The sink is not rotated past what it holds already.
+func runAuditReplay(args []string) error {
+	fs := flag.NewFlagSet("audit-replay", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	from := fs.String("from", "", "first time to export, RFC 3339 or YYYY-MM-DD in UTC")
+	to := fs.String("to", "", "time to export up to, excluded, RFC 3339 or YYYY-MM-DD in UTC; now if empty")
+	sinkName := fs.String("sink", "file", "sink to export to: file or syslog")
+	path := fs.String("path", "", "file sink: path of the file to append to")
+	maxSizeMB := fs.Int("max-size-mb", 100, "file sink: size past which the file is rotated")
+	keep := fs.Int("keep", 5, "file sink: rotated files kept")
+	network := fs.String("syslog-network", "", "syslog sink: udp, tcp or unix, the local daemon if empty")
+	address := fs.String("syslog-address", "", "syslog sink: address of the daemon")
+	tag := fs.String("syslog-tag", "sso", "syslog sink: tag of the messages")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+	start, err := parseReplayTime(*from)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	end := time.Now()
+	if *to != "" {
+		if end, err = parseReplayTime(*to); err != nil {
+			return fmt.Errorf("to: %w", err)
+		}
+	}
+	if !start.Before(end) {
+		return errors.New("from must be before to")
+	}
+
+	var sink auditexport.Sink
+	switch *sinkName {
+	case "file":
+		sink, err = auditexport.NewFileSink(*path, int64(*maxSizeMB)<<20, *keep)
+	case "syslog":
+		sink, err = auditexport.NewSyslogSink(*network, *address, *tag)
+	default:
+		return fmt.Errorf("unknown sink %q, want file or syslog", *sinkName)
+	}
+	if err != nil {
+		return err
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		_ = sink.Close()
+
+		return err
+	}
+	defer storage.Close()
+
+	n, err := replayEvents(context.Background(), storage, sink, start, end)
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("exported %d events before failing: %w", n, err)
+	}
+
+	fmt.Printf("exported %d events\n", n)
+
+	return nil
+}
+
+type eventReader interface {
+	Events(ctx context.Context, afterID int64, limit int) ([]models.Event, error)
+}
+
+// replayEvents writes the events created in [from, to) to sink, oldest
+// first, and returns how many it wrote.
+func replayEvents(ctx context.Context, events eventReader, sink auditexport.Sink, from, to time.Time) (int, error) {
+	n := 0
+	var afterID int64
+	for {
+		page, err := events.Events(ctx, afterID, auditReplayPage)
+		if err != nil {
+			return n, err
+		}
+
+		for _, event := range page {
+			afterID = event.ID
+			if event.CreatedAt.Before(from) || !event.CreatedAt.Before(to) {
+				continue
+			}
+
+			line, err := auditexport.Encode(event)
+			if err != nil {
+				return n, err
+			}
+			if err := sink.Write(line); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if err := sink.Flush(); err != nil {
+			return n, err
+		}
+
+		if len(page) < auditReplayPage {
+			return n, nil
+		}
+	}
+}
+
+func parseReplayTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
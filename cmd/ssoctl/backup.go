@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/storage/sqlite"
+)
+
+// runBackup makes an online backup of a database that may be in use by a
+// running server.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	destPath := fs.String("dest", "", "path of the backup file to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" || *destPath == "" {
+		return errors.New("storage-path and dest cannot be empty")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if err := storage.Backup(context.Background(), *destPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up %s into %s\n", *storagePath, *destPath)
+
+	return nil
+}
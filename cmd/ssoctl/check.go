@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/services/auth"
+	"sso/internal/storage/sqlite"
+)
+
+// runCheckHashes reports the users whose password hash cannot be parsed,
+// e.g. after a bad import. Those users cannot log in until their password
+// is reset.
+func runCheckHashes(args []string) error {
+	fs := flag.NewFlagSet("check-hashes", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	invalid, err := storage.InvalidPassHashes(context.Background(), auth.BcryptHasher{}.Check)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range invalid {
+		fmt.Printf("user %d: invalid password hash\n", id)
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%d users have an invalid password hash", len(invalid))
+	}
+
+	fmt.Println("all password hashes are valid")
+
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/storage/sqlite"
+)
+
+const defaultEmailBatch = 500
+
+// runEmails runs the emails subcommands.
+func runEmails(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "normalize":
+			return runNormalizeEmails(args[1:])
+		case "canonicalize":
+			return runCanonicalizeEmails(args[1:])
+		}
+	}
+
+	return errors.New("usage: ssoctl emails normalize|canonicalize [flags]")
+}
+
+// normalizeReport is the JSON report of emails normalize.
+type normalizeReport struct {
+	Applied bool `json:"applied"`
+	Scanned int  `json:"scanned"`
+	// Pending counts the emails to normalize that have no conflict,
+	// Normalized those actually changed.
+	Pending    int             `json:"pending"`
+	Normalized int             `json:"normalized"`
+	Conflicts  []emailConflict `json:"conflicts"`
+}
+
+// emailConflict is a normalized email shared by several users, all left
+// as they are.
+type emailConflict struct {
+	Email string         `json:"normalized_email"`
+	Users []conflictUser `json:"users"`
+}
+
+type conflictUser struct {
+	ID          int64      `json:"id"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// runNormalizeEmails stores the emails of users registered before emails
+// were normalized in their normalized form. Emails that would collide
+// with another user's are reported, with IDs and last logins, to be
+// resolved by hand.
+//
+// Nothing is written without -apply. Changes are made in transactions of
+// -batch users, so an interrupted run keeps the batches done; running it
+// again picks up the rest, as normalized emails are skipped.
+func runNormalizeEmails(args []string) error {
+	fs := flag.NewFlagSet("emails normalize", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	apply := fs.Bool("apply", false, "normalize the emails instead of only reporting them")
+	batch := fs.Int("batch", defaultEmailBatch, "users read and changed per transaction")
+	reportPath := fs.String("report", "", "write the JSON report to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+	if *batch <= 0 {
+		return errors.New("batch must be positive")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+
+	changes, report, err := planNormalize(ctx, storage, *batch)
+	if err != nil {
+		return err
+	}
+
+	if *apply {
+		report.Applied = true
+		for chunk := range slices.Chunk(changes, *batch) {
+			n, err := storage.ChangeEmails(ctx, chunk)
+			report.Normalized += n
+			if err != nil {
+				// Most likely a user registered with one of the emails
+				// meanwhile; the next run reports it as a conflict.
+				_ = writeReport(*reportPath, report)
+
+				return fmt.Errorf("after %d emails: %w", report.Normalized, err)
+			}
+		}
+	}
+
+	if err := writeReport(*reportPath, report); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d users scanned, %d emails to normalize, %d normalized, %d conflicts\n",
+		report.Scanned, report.Pending, report.Normalized, len(report.Conflicts))
+	if len(report.Conflicts) > 0 {
+		return fmt.Errorf("%d normalized emails are shared by several users and must be resolved by hand", len(report.Conflicts))
+	}
+
+	return nil
+}
+
+// planNormalize scans every user and returns the changes that normalize
+// emails without a conflict.
+func planNormalize(ctx context.Context, storage *sqlite.Storage, batch int) ([]models.EmailChange, normalizeReport, error) {
+	report := normalizeReport{Conflicts: []emailConflict{}}
+	byEmail := make(map[string][]models.UserEmail)
+	var order []string
+
+	var afterID int64
+	for {
+		users, err := storage.UserEmails(ctx, afterID, batch)
+		if err != nil {
+			return nil, normalizeReport{}, err
+		}
+
+		for _, user := range users {
+			email := emailaddr.Normalize(user.Email)
+			if _, ok := byEmail[email]; !ok {
+				order = append(order, email)
+			}
+			byEmail[email] = append(byEmail[email], user)
+		}
+		report.Scanned += len(users)
+
+		if len(users) < batch {
+			break
+		}
+		afterID = users[len(users)-1].ID
+	}
+
+	var changes []models.EmailChange
+	for _, email := range order {
+		users := byEmail[email]
+		if len(users) > 1 {
+			report.Conflicts = append(report.Conflicts, newEmailConflict(email, users))
+			continue
+		}
+		if users[0].Email != email {
+			changes = append(changes, models.EmailChange{UserID: users[0].ID, From: users[0].Email, To: email})
+		}
+	}
+	report.Pending = len(changes)
+
+	return changes, report, nil
+}
+
+func newEmailConflict(email string, users []models.UserEmail) emailConflict {
+	conflict := emailConflict{Email: email}
+	for _, user := range users {
+		u := conflictUser{ID: user.ID, Email: user.Email}
+		if !user.LastLoginAt.IsZero() {
+			at := user.LastLoginAt.UTC()
+			u.LastLoginAt = &at
+		}
+		conflict.Users = append(conflict.Users, u)
+	}
+
+	return conflict
+}
+
+// canonicalReport is the JSON report of emails canonicalize.
+type canonicalReport struct {
+	Applied bool `json:"applied"`
+	Scanned int  `json:"scanned"`
+	// Pending counts the canonical emails to store that have no conflict,
+	// Stored those actually changed.
+	Pending   int                 `json:"pending"`
+	Stored    int                 `json:"stored"`
+	Conflicts []canonicalConflict `json:"conflicts"`
+}
+
+// canonicalConflict is a canonical email shared by several users, none of
+// whom is given it.
+type canonicalConflict struct {
+	Email string         `json:"canonical_email"`
+	Users []conflictUser `json:"users"`
+}
+
+// runCanonicalizeEmails stores the canonical emails of the users
+// registered before registration.canonical_emails was enabled, or with
+// other rules, so that new registrations cannot take an equivalent email.
+// The rules are those of emailaddr.KnownProviders, overridden by -rule.
+// Users sharing a canonical email are reported, with IDs and last logins,
+// to be resolved by hand, and left without one.
+//
+// Nothing is written without -apply. Changes are made in transactions of
+// -batch users; running it again picks up what is left.
+func runCanonicalizeEmails(args []string) error {
+	fs := flag.NewFlagSet("emails canonicalize", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	apply := fs.Bool("apply", false, "store the canonical emails instead of only reporting them")
+	batch := fs.Int("batch", defaultEmailBatch, "users read and changed per transaction")
+	reportPath := fs.String("report", "", "write the JSON report to this file instead of stdout")
+	rules := maps.Clone(emailaddr.KnownProviders)
+	fs.Func("rule", "domain=plus|dots|plus,dots|none, overriding the known providers; repeatable", func(v string) error {
+		domain, raw, ok := strings.Cut(v, "=")
+		if !ok || domain == "" {
+			return errors.New("want domain=rule")
+		}
+		rule, err := emailaddr.ParseRule(raw)
+		if err != nil {
+			return err
+		}
+		rules[emailaddr.Normalize(domain)] = rule
+
+		return nil
+	})
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+	if *batch <= 0 {
+		return errors.New("batch must be positive")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+
+	changes, report, err := planCanonicalize(ctx, storage, emailaddr.NewCanonicalizer(rules), *batch)
+	if err != nil {
+		return err
+	}
+
+	if *apply {
+		report.Applied = true
+		for chunk := range slices.Chunk(changes, *batch) {
+			n, err := storage.SetCanonicalEmails(ctx, chunk)
+			report.Stored += n
+			if err != nil {
+				// Most likely a user registered with an equivalent email
+				// meanwhile; the next run reports it as a conflict.
+				_ = writeReport(*reportPath, report)
+
+				return fmt.Errorf("after %d emails: %w", report.Stored, err)
+			}
+		}
+	}
+
+	if err := writeReport(*reportPath, report); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d users scanned, %d canonical emails to store, %d stored, %d conflicts\n",
+		report.Scanned, report.Pending, report.Stored, len(report.Conflicts))
+	if len(report.Conflicts) > 0 {
+		return fmt.Errorf("%d canonical emails are shared by several users and must be resolved by hand", len(report.Conflicts))
+	}
+
+	return nil
+}
+
+// planCanonicalize scans every user and returns the changes that store
+// canonical emails without a conflict.
+func planCanonicalize(
+	ctx context.Context,
+	storage *sqlite.Storage,
+	canonicalizer *emailaddr.Canonicalizer,
+	batch int,
+) ([]models.EmailChange, canonicalReport, error) {
+	report := canonicalReport{Conflicts: []canonicalConflict{}}
+	byCanonical := make(map[string][]models.UserEmail)
+	var order []string
+
+	var afterID int64
+	for {
+		users, err := storage.UserEmails(ctx, afterID, batch)
+		if err != nil {
+			return nil, canonicalReport{}, err
+		}
+
+		for _, user := range users {
+			canonical := canonicalizer.Canonical(user.Email)
+			if _, ok := byCanonical[canonical]; !ok {
+				order = append(order, canonical)
+			}
+			byCanonical[canonical] = append(byCanonical[canonical], user)
+		}
+		report.Scanned += len(users)
+
+		if len(users) < batch {
+			break
+		}
+		afterID = users[len(users)-1].ID
+	}
+
+	var changes []models.EmailChange
+	for _, canonical := range order {
+		users := byCanonical[canonical]
+		if len(users) > 1 {
+			report.Conflicts = append(report.Conflicts, canonicalConflict{
+				Email: canonical,
+				Users: newEmailConflict(canonical, users).Users,
+			})
+			continue
+		}
+		if users[0].CanonicalEmail != canonical {
+			changes = append(changes, models.EmailChange{UserID: users[0].ID, From: users[0].Email, To: canonical})
+		}
+	}
+	report.Pending = len(changes)
+
+	return changes, report, nil
+}
+
+func writeReport(path string, report any) error {
+	if path == "" {
+		return encodeReport(os.Stdout, report)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeReport(f, report); err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+func encodeReport(w io.Writer, report any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(report)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/storage/sqlite"
+)
+
+// runEncrypt copies a plaintext database into a new encrypted file.
+// The key is read from STORAGE_ENCRYPTION_KEY only, so it never shows up
+// in the process list or shell history.
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the plaintext database")
+	destPath := fs.String("dest", "", "path of the encrypted database to create")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" || *destPath == "" {
+		return errors.New("storage-path and dest cannot be empty")
+	}
+
+	key := os.Getenv("STORAGE_ENCRYPTION_KEY")
+	if key == "" {
+		return errors.New("STORAGE_ENCRYPTION_KEY is not set")
+	}
+
+	if err := sqlite.Encrypt(context.Background(), *storagePath, *destPath, key); err != nil {
+		return err
+	}
+
+	fmt.Printf("encrypted %s into %s\n", *storagePath, *destPath)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/storage/sqlite"
+)
+
+// runHashVersions fills in the hash_version of the users written before
+// it, the migrate step of storage.schema_compat.hash_version, see
+// sqlite.Phase. It is safe to run while old binaries are still serving:
+// pass_hash is left untouched.
+func runHashVersions(args []string) error {
+	fs := flag.NewFlagSet("hash-versions", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	n, err := storage.BackfillHashVersions(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d users backfilled\n", n)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"sso/internal/services/auth"
+	"sso/internal/storage/sqlite"
+)
+
+// runCreateInvite creates an invite and prints its code, which is not
+// stored and cannot be shown again.
+func runCreateInvite(args []string) error {
+	fs := flag.NewFlagSet("create-invite", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	email := fs.String("email", "", "only address the invite registers, any if empty")
+	uses := fs.Int("uses", 1, "number of users the invite registers")
+	ttl := fs.Duration("ttl", 7*24*time.Hour, "lifetime of the invite, 0 for no expiry")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := auth.NewService(log, storage, storage, storage, auth.WithInvites(storage))
+	if err != nil {
+		return err
+	}
+
+	code, invite, err := a.CreateInvite(context.Background(), *email, *uses, *ttl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("invite %d: %s\n", invite.ID, code)
+
+	return nil
+}
@@ -0,0 +1,74 @@
+// Command ssoctl performs operator tasks against the sso storage.
+//
+// Usage:
+//
+//	ssoctl <command> [flags]
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"sso/internal/buildinfo"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "backup", usage: "make an online backup of a live database", run: runBackup},
+	{name: "encrypt", usage: "re-encrypt a plaintext database into a new SQLCipher file", run: runEncrypt},
+	{name: "check-hashes", usage: "report users whose password hash cannot be parsed", run: runCheckHashes},
+	{name: "hash-versions", usage: "fill in the hash version of the users stored before it", run: runHashVersions},
+	{name: "create-invite", usage: "create an invite code for invite-only registration", run: runCreateInvite},
+	{name: "preregister", usage: "pre-register the users of a CSV file for activation", run: runPreRegister},
+	{name: "activation", usage: "reissue or revoke an activation token: activation reissue|revoke", run: runActivation},
+	{name: "audit-replay", usage: "export the outbox events of a time range to an audit sink again", run: runAuditReplay},
+	{name: "emails", usage: "normalize or canonicalize stored emails: emails normalize|canonicalize [-apply]", run: runEmails},
+	{name: "replica", usage: "compare a database with its replica and repair it: replica reconcile [-apply]", run: runReplica},
+	{name: "version", usage: "print the version and commit of this build", run: runVersion},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	name, args := os.Args[1], os.Args[2:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if err := cmd.run(args); err != nil {
+			fmt.Fprintf(os.Stderr, "ssoctl %s: %v\n", name, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "ssoctl: unknown command %q\n", name)
+	printUsage()
+	os.Exit(2)
+}
+
+func runVersion([]string) error {
+	build := buildinfo.Get()
+	fmt.Printf("ssoctl %s (commit %s, %s)\n", build.Version, build.Commit, build.GoVersion)
+
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: ssoctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-13s %s\n", cmd.name, cmd.usage)
+	}
+}
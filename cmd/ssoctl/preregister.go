@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"sso/internal/services/auth"
+	"sso/internal/storage/sqlite"
+)
+
+// runPreRegister pre-registers the users of a CSV file of email,
+// first_name, last_name and middle_name rows, and prints a CSV of their
+// IDs and activation tokens, which are not stored and cannot be shown
+// again. Rows that fail are reported and skipped.
+func runPreRegister(args []string) error {
+	fs := flag.NewFlagSet("preregister", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	file := fs.String("file", "", "CSV file of the users, - for stdin")
+	ttl := fs.Duration("ttl", auth.DefaultActivationTTL, "lifetime of the activation tokens")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+	if *file == "" {
+		return errors.New("file cannot be empty")
+	}
+	if *ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	in := os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := auth.NewService(log, storage, storage, storage,
+		auth.WithActivation(storage, *ttl),
+		auth.WithEvents(storage),
+	)
+	if err != nil {
+		return err
+	}
+
+	return preRegister(context.Background(), a, in, os.Stdout, os.Stderr)
+}
+
+// preRegister pre-registers the users of the CSV rows of in and writes
+// the activations to out. Failed rows are reported to errOut.
+func preRegister(ctx context.Context, a *auth.Auth, in io.Reader, out, errOut io.Writer) error {
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	w := csv.NewWriter(out)
+
+	if err := w.Write([]string{"email", "user_id", "activation_token", "expires_at"}); err != nil {
+		return err
+	}
+
+	failed := 0
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := r.FieldPos(0)
+		if len(row) < 1 || len(row) > 4 {
+			fmt.Fprintf(errOut, "line %d: want email, first_name, last_name and middle_name, got %d fields\n", line, len(row))
+			failed++
+
+			continue
+		}
+		row = append(row, make([]string, 4-len(row))...)
+
+		activation, err := a.PreRegisterUser(ctx, row[0], row[1], row[2], row[3])
+		if err != nil {
+			fmt.Fprintf(errOut, "line %d: %s: %v\n", line, row[0], err)
+			failed++
+
+			continue
+		}
+
+		if err := w.Write([]string{
+			row[0],
+			fmt.Sprint(activation.UserID),
+			activation.Token,
+			activation.ExpiresAt.UTC().Format(time.RFC3339),
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d users not pre-registered", failed)
+	}
+
+	return nil
+}
+
+// runActivation runs the activation subcommands, which reissue or revoke
+// the activation token of a pre-registered user.
+func runActivation(args []string) error {
+	if len(args) == 0 || (args[0] != "reissue" && args[0] != "revoke") {
+		return errors.New("usage: ssoctl activation reissue|revoke -user-id <id> [flags]")
+	}
+
+	fs := flag.NewFlagSet("activation "+args[0], flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	userID := fs.Int64("user-id", 0, "ID of the pre-registered user")
+	ttl := fs.Duration("ttl", auth.DefaultActivationTTL, "lifetime of the reissued token")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *storagePath == "" {
+		return errors.New("storage-path cannot be empty")
+	}
+	if *userID <= 0 {
+		return errors.New("user-id must be positive")
+	}
+	if *ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := auth.NewService(log, storage, storage, storage,
+		auth.WithActivation(storage, *ttl),
+		auth.WithEvents(storage),
+	)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "revoke" {
+		return a.RevokeActivation(context.Background(), *userID)
+	}
+
+	activation, err := a.ReissueActivation(context.Background(), *userID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("user %d: %s (expires %s)\n", activation.UserID, activation.Token, activation.ExpiresAt.UTC().Format(time.RFC3339))
+
+	return nil
+}
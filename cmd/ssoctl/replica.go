@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"sso/internal/storage/sqlite"
+)
+
+// runReplica runs the replica subcommands.
+func runReplica(args []string) error {
+	if len(args) > 0 && args[0] == "reconcile" {
+		return runReconcileReplica(args[1:])
+	}
+
+	return errors.New("usage: ssoctl replica reconcile [flags]")
+}
+
+// runReconcileReplica compares the replicated tables of a database with
+// its replica and, with -apply, repairs the replica. It may run while the
+// server replicates: the copies of the server are idempotent.
+func runReconcileReplica(args []string) error {
+	fs := flag.NewFlagSet("replica reconcile", flag.ContinueOnError)
+	storagePath := fs.String("storage-path", "", "path to the database")
+	replicaPath := fs.String("replica-path", "", "path to its replica")
+	apply := fs.Bool("apply", false, "repair the replica instead of only reporting the differences")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *storagePath == "" || *replicaPath == "" {
+		return errors.New("storage-path and replica-path cannot be empty")
+	}
+
+	storage, err := sqlite.New(*storagePath, sqlite.Options{
+		Key: os.Getenv("STORAGE_ENCRYPTION_KEY"),
+	})
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	diffs, err := storage.ReconcileReplica(context.Background(), *replicaPath, !*apply)
+	if err != nil {
+		return err
+	}
+
+	var copied, deleted int64
+	for _, diff := range diffs {
+		fmt.Printf("%-20s %d differ, %d gone from the storage\n", diff.Table, diff.Copied, diff.Deleted)
+		copied += diff.Copied
+		deleted += diff.Deleted
+	}
+	fmt.Fprintf(os.Stderr, "%d rows differ, %d rows are gone from the storage, applied: %t\n", copied, deleted, *apply)
+
+	return nil
+}
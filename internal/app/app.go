@@ -1,34 +1,777 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	debugapp "sso/internal/app/debug"
 	grpcapp "sso/internal/app/grpc"
+	httpapp "sso/internal/app/http"
+	jobsapp "sso/internal/app/jobs"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	activationgrpc "sso/internal/grpc/activation"
+	admingrpc "sso/internal/grpc/admin"
+	debuggrpc "sso/internal/grpc/debug"
+	"sso/internal/grpc/interceptors"
+	orgunitgrpc "sso/internal/grpc/orgunit"
+	permissiongrpc "sso/internal/grpc/permission"
+	quotagrpc "sso/internal/grpc/quota"
+	sessiongrpc "sso/internal/grpc/session"
+	webhookgrpc "sso/internal/grpc/webhook"
+	"sso/internal/introspect"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/health"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/nonce"
+	"sso/internal/lib/reserved"
+	"sso/internal/oidc"
+	"sso/internal/services/auditexport"
 	"sso/internal/services/auth"
+	"sso/internal/services/keys"
+	"sso/internal/services/webhooks"
+	storagepkg "sso/internal/storage"
 	"sso/internal/storage/sqlite"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+// policies guard the admin RPCs. Destructive ones need a step-up token
+// from Session/ElevatePrivileges on top of the admin role.
+var policies = map[string]interceptors.Policy{
+	sessiongrpc.WhoAmIMethod: {},
+	sessiongrpc.LogoutMethod: {},
+	// Checks the password again, see auth.ElevatePrivileges.
+	sessiongrpc.ElevatePrivilegesMethod: {Role: auth.AdminRole},
+	sessiongrpc.AcceptTermsMethod:       {},
+	// Owners and admins, checked by the handler. CancelDeletion needs no
+	// policy: the reactivation token authenticates it.
+	sessiongrpc.ScheduleDeletionMethod: {},
+	admingrpc.GetServerInfoMethod:      {Role: auth.AdminRole},
+	// Holds up writes for up to storage.maintenance.max_duration.
+	admingrpc.RunMaintenanceMethod:  {Role: auth.AdminRole, Elevated: true},
+	admingrpc.ListFailedTasksMethod: {Role: auth.AdminRole},
+	admingrpc.LookupTokenMethod:     {Role: auth.AdminRole},
+	// Giving roles is a change of privileges.
+	admingrpc.AssignRoleBulkMethod:  {Role: auth.AdminRole, Elevated: true},
+	admingrpc.ListUsersMethod:       {Role: auth.AdminRole},
+	admingrpc.GetStatsMethod:        {Role: auth.AdminRole},
+	admingrpc.StreamUsersMethod:     {Role: auth.AdminRole},
+	admingrpc.UsersExistMethod:      {Role: auth.AdminRole},
+	admingrpc.UserRolesMethod:       {Role: auth.AdminRole},
+	admingrpc.SetUserTokenTTLMethod: {Role: auth.AdminRole},
+	admingrpc.CreateInviteMethod:    {Role: auth.AdminRole},
+
+	// Granting permissions is a change of privileges.
+	permissiongrpc.AttachPermissionMethod:    {Role: auth.AdminRole, Elevated: true},
+	permissiongrpc.DetachPermissionMethod:    {Role: auth.AdminRole, Elevated: true},
+	permissiongrpc.ListRolePermissionsMethod: {Role: auth.AdminRole},
+	permissiongrpc.GetPermissionsMethod:      {},
+
+	// Moving a unit changes the roles held below it.
+	orgunitgrpc.CreateOrgUnitMethod:      {Role: auth.AdminRole, Elevated: true},
+	orgunitgrpc.MoveOrgUnitMethod:        {Role: auth.AdminRole, Elevated: true},
+	orgunitgrpc.ListOrgUnitsMethod:       {Role: auth.AdminRole},
+	orgunitgrpc.GetUserRolesInUnitMethod: {Role: auth.AdminRole},
+
+	activationgrpc.PreRegisterUserMethod:   {Role: auth.AdminRole},
+	activationgrpc.ReissueActivationMethod: {Role: auth.AdminRole},
+	activationgrpc.RevokeActivationMethod:  {Role: auth.AdminRole},
+
+	quotagrpc.SetAppQuotaMethod: {Role: auth.AdminRole},
+	quotagrpc.GetAppQuotaMethod: {Role: auth.AdminRole},
+	quotagrpc.GetAppUsageMethod: {Role: auth.AdminRole},
+
+	webhookgrpc.CreateWebhookMethod: {Role: auth.AdminRole},
+	webhookgrpc.ListWebhooksMethod:  {Role: auth.AdminRole},
+	webhookgrpc.DeleteWebhookMethod: {Role: auth.AdminRole},
+	webhookgrpc.TestWebhookMethod:   {Role: auth.AdminRole},
+}
+
+// cachedMethods keep the users, roles and apps they read for the rest of
+// the call, see auth.WithRequestCache. They must not change them.
+var cachedMethods = []string{
+	"Login",
+	"UserRole",
+	sessiongrpc.WhoAmIMethod,
+	permissiongrpc.GetPermissionsMethod,
+	permissiongrpc.ListRolePermissionsMethod,
+	admingrpc.GetServerInfoMethod,
+	orgunitgrpc.ListOrgUnitsMethod,
+	orgunitgrpc.GetUserRolesInUnitMethod,
+}
+
+const (
+	envLocal             = "local"
+	algHS256             = "HS256"
+	algRS256             = "RS256"
+	debugShutdownTimeout = 5 * time.Second
+	httpShutdownTimeout  = 5 * time.Second
+	usageFlushTimeout    = 5 * time.Second
+	auditFlushTimeout    = 5 * time.Second
 )
 
 type App struct {
 	GRPCServer *grpcapp.App
+	Jobs       *jobsapp.App
+	Health     *health.Probe
+	// Admin serves the admin services on listeners of their own. It is
+	// nil unless grpc.admin.listen is set.
+	Admin *grpcapp.App
+	// Debug is nil unless enabled in config.
+	Debug *debugapp.App
+	// HTTP serves introspection and the OIDC documents. It is nil unless
+	// enabled in config.
+	HTTP *httpapp.App
+
+	log        *slog.Logger
+	auth       *auth.Auth
+	audit      *auditexport.Exporter
+	histograms *histograms
+	storage    *sqlite.Storage
 }
 
+// New wires the application together.
+//
+// If the storage cannot be opened, the error is logged and the process
+// exits with a non-zero code.
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	storagePath string,
-	tokenTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := sqlite.New(storagePath)
+	clk := clock.Real()
+	hists := newHistograms(cfg.Metrics)
+	queryDurations := hists.get("storage_query_duration_seconds")
+
+	storage, err := sqlite.New(cfg.StoragePath, sqlite.Options{
+		ConnectTimeout: cfg.Storage.ConnectTimeout,
+		CreateDir:      cfg.Storage.CreateDir,
+		Observer: storagepkg.NewQueryObserver(
+			log,
+			cfg.Storage.SlowQueryThreshold,
+			queryDurations,
+		),
+		Key:         cfg.Storage.EncryptionKey,
+		ReadConns:   cfg.Storage.ReadConns,
+		Exclusive:   true,
+		AllowShared: cfg.Storage.AllowShared,
+		Replica:     cfg.Storage.Replica.Path,
+		Log:         log,
+		Compat: sqlite.Compat{
+			CanonicalEmail: sqlite.Phase(cfg.Storage.SchemaCompat.CanonicalEmail),
+			HashVersion:    sqlite.Phase(cfg.Storage.SchemaCompat.HashVersion),
+		},
+	})
 	if err != nil {
-		panic(err)
+		log.Error("failed to open storage", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if storage.Shared() {
+		log.Warn("storage file is in use by another instance, leaving backups, maintenance, account deletion and webhooks to it")
+	}
+
+	// With RS256 every instance signs with the newest key in storage and
+	// publishes the keys for verification; HS256 signs with app secrets.
+	var keyManager *keys.Manager
+	var signingKeys *jwt.KeySet
+	switch cfg.JWT.Algorithm {
+	case algRS256:
+		keyManager = keys.New(log, storage, cfg.JWT.KeyRotation, clk)
+		if err := keyManager.Refresh(context.Background()); err != nil {
+			log.Error("failed to load signing keys", slog.Any("error", err))
+			os.Exit(1)
+		}
+		signingKeys = keyManager.Keys()
+	case algHS256:
+	default:
+		log.Error("unsupported jwt.algorithm", slog.String("algorithm", cfg.JWT.Algorithm))
+		os.Exit(1)
+	}
+
+	schema := checkSchema(context.Background(), log, storage, cfg.Storage.MigrationsTable, clk)
+	checks := []health.Check{
+		{Name: "storage", Run: storage.Ping},
+		{Name: "migrations", Run: func(ctx context.Context) error {
+			return storage.CheckSchema(ctx, cfg.Storage.MigrationsTable)
+		}},
+	}
+	// Checked before the probe first runs, so a corrupt file never
+	// reports ready.
+	var integrity *models.IntegrityCheck
+	if mode := cfg.Storage.IntegrityCheck; mode != "off" {
+		integrity = checkIntegrity(context.Background(), log, storage, mode, clk)
+		checks = append(checks, health.Check{Name: "integrity", Run: integrityHealth(integrity)})
+	}
+	if signingKeys != nil {
+		checks = append(checks, health.Check{Name: "signing_keys", Run: func(context.Context) error {
+			if _, ok := signingKeys.Current(); !ok {
+				return errors.New("no signing key loaded")
+			}
+			return nil
+		}})
+	}
+	probe := health.New(log, clk, cfg.Health.MaxAge, checks...)
+	// Not ready is not fatal: the checks are retried until they pass.
+	if err := probe.Run(context.Background()); err != nil {
+		log.Warn("not ready yet", slog.Any("error", err))
 	}
 
-	authService := auth.New(log, storage, storage, storage, tokenTTL)
+	domains, err := emaildomain.New(cfg.Registration.AllowedDomains, cfg.Registration.DeniedDomains)
+	if err != nil {
+		log.Error("invalid registration domains", slog.Any("error", err))
+		os.Exit(1)
+	}
+	reservedList, err := reserved.New(cfg.Registration.Reserved)
+	if err != nil {
+		log.Error("invalid reserved identifiers", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+	issuer := jwt.Issuer{
+		Name:         cfg.JWT.Issuer,
+		Accepted:     cfg.JWT.AcceptedIssuers,
+		AllowMissing: cfg.JWT.AllowMissingIssuer,
+	}
+
+	authOpts := []auth.Option{
+		auth.WithAuthorizations(storage),
+		auth.WithLoginHistory(storage),
+		auth.WithSigningKeys(signingKeys),
+		auth.WithHS256Rollover(cfg.JWT.HS256Until),
+		auth.WithIssuer(issuer),
+		auth.WithTokenTTL(cfg.TokenTTL),
+		auth.WithMaxTokenTTL(cfg.JWT.MaxTokenTTL),
+		auth.WithLeeway(cfg.JWT.Leeway),
+		auth.WithTokenIssuances(storage, cfg.JWT.IssuanceRetention),
+		auth.WithRevocations(storage, cfg.JWT.RevocationCacheTTL),
+		auth.WithClock(clk),
+		auth.WithTerms(cfg.TOS.RequiredVersion, cfg.TOS.EnforceOnLogin),
+		auth.WithInvites(storage),
+		auth.WithIdentities(storage),
+		auth.WithDeletion(storage, cfg.Deletion.GracePeriod),
+		auth.WithChallenges(storage),
+		auth.WithPermissions(storage),
+		auth.WithHasher(auth.BcryptHasher{Cost: cfg.Hashing.BcryptCost}),
+		auth.WithHashDurations(hists.get("password_hash_duration_seconds")),
+		auth.WithOrgUnits(storage),
+		auth.WithRoles(storage),
+		auth.WithRequestCache(),
+		auth.WithActivation(storage, cfg.Registration.ActivationTTL),
+		auth.WithAppQuotas(storage),
+		auth.WithRegistrations(storage),
+		auth.WithRegistrationMode(auth.RegistrationMode(cfg.Registration.Mode)),
+		auth.WithConcealedRegistration(cfg.Registration.ConcealUsers),
+		auth.WithFailureFloor(cfg.Login.FailureFloor, cfg.Login.FailureJitter),
+		auth.WithEmailDomains(domains),
+		auth.WithReservedIdentifiers(reservedList),
+		auth.WithStageTimeouts(auth.StageTimeouts{
+			Storage:  cfg.Dependencies.Storage,
+			Hashing:  cfg.Dependencies.Hashing,
+			Notifier: cfg.Dependencies.Notifier,
+		}),
+	}
+	if cfg.Usage.Enabled {
+		authOpts = append(authOpts, auth.WithAppUsage(storage))
+	}
+	if k := cfg.GRPC.Admin.CursorKey; k != "" {
+		authOpts = append(authOpts, auth.WithCursorKey([]byte(k)))
+	}
+	if c := cfg.Registration.CanonicalEmails; c.Enabled {
+		// Validated by config.Load.
+		rules, _ := c.Rules()
+		authOpts = append(authOpts, auth.WithCanonicalEmails(emailaddr.NewCanonicalizer(rules)))
+	}
+	if r := cfg.Storage.ReadRetry; r.Enabled {
+		authOpts = append(authOpts, auth.WithReadRetry(r.MinBudget))
+	}
+	if t := cfg.Login.Tarpit; t.Enabled {
+		authOpts = append(authOpts, auth.WithTarpit(auth.TarpitConfig{
+			Window:     t.Window,
+			Free:       t.Free,
+			Base:       t.Base,
+			Max:        t.Max,
+			MaxTracked: t.MaxTracked,
+		}))
+	}
+	if a := cfg.Login.Anomaly; a.Enabled {
+		authOpts = append(authOpts, auth.WithLoginAnomalies(auth.AnomalyConfig{
+			Window:          a.Window,
+			SprayThreshold:  a.SprayThreshold,
+			TargetThreshold: a.TargetThreshold,
+			MaxTracked:      a.MaxTracked,
+			History:         a.History,
+			Escalate:        a.Escalate,
+		}))
+		if a.Escalate {
+			authOpts = append(authOpts, auth.WithCaptcha(captcha.SiteVerifier{
+				URL:    a.Captcha.VerifyURL,
+				Secret: a.Captcha.Secret,
+				Client: &http.Client{Timeout: a.Captcha.Timeout},
+			}))
+		}
+	}
+	if cfg.Introspect.Enabled {
+		authOpts = append(authOpts, auth.WithTokenCache(cfg.Introspect.CacheTTL, cfg.Introspect.CacheSize))
+	}
+	// Nothing reads the outbox without webhooks or an audit export, so
+	// nothing is written to it.
+	var auditExporter *auditexport.Exporter
+	if cfg.Audit.Enabled() {
+		auditExporter, err = newAuditExporter(log, cfg.Audit)
+		if err != nil {
+			log.Error("failed to create audit exporter", slog.Any("error", err))
+			os.Exit(1)
+		}
+		authOpts = append(authOpts, auth.WithEvents(auditexport.NewPublisher(storage, auditExporter)))
+	} else if cfg.Webhooks.Enabled {
+		authOpts = append(authOpts, auth.WithEvents(storage))
+	}
+	var webhookService *webhooks.Service
+	if cfg.Webhooks.Enabled {
+		webhookService, err = webhooks.New(log, storage, storage,
+			webhooks.WithClient(&http.Client{Timeout: cfg.Webhooks.Timeout}),
+			webhooks.WithClock(clk),
+			webhooks.WithMaxFailures(cfg.Webhooks.MaxFailures),
+		)
+		if err != nil {
+			log.Error("failed to create webhooks service", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	authService, err := auth.NewService(log, storage, storage, storage, authOpts...)
+	if err != nil {
+		log.Error("failed to create auth service", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	// Local mode is for development: reflection is always on there and
+	// the Debug service is available.
+	var debugService debuggrpc.TokenValidator
+	if cfg.Env == envLocal {
+		debugService = authService
+	}
+
+	info := newServerInfo(clk, storage, cfg)
+	info.info.Integrity = integrity
+	info.info.Schema = schema
+
+	socketMode, err := strconv.ParseUint(cfg.GRPC.SocketMode, 8, 32)
+	if err != nil {
+		log.Error("invalid grpc.socket_mode", slog.String("socket_mode", cfg.GRPC.SocketMode))
+		os.Exit(1)
+	}
+
+	window, err := jobsapp.ParseWindow(cfg.Storage.Maintenance.Window)
+	if err != nil {
+		log.Error("invalid storage.maintenance.window", slog.Any("error", err))
+		os.Exit(1)
+	}
+	maintenance := jobsapp.NewMaintenance(log, storage, window, cfg.Storage.Maintenance.MaxDuration, clk)
+	// Trimmed even when disabled, so that usage counted before goes too.
+	maintenance.TrimUsage(storage, cfg.Usage.Retention)
+	maintenance.PurgeIssuances(storage)
+	maintenance.PurgeRevocations(storage)
+
+	var decisions *interceptors.DecisionLog
+	if cfg.GRPC.DecisionLog.Enabled {
+		decisions = interceptors.NewDecisionLog(log, clk, cfg.GRPC.DecisionLog.PerSecond, auth.AdminRole)
+	}
+
+	// The admin services move to a server of their own if it has
+	// listeners; see config.AdminGRPCConfig.
+	adminServices := []grpcapp.Option{
+		grpcapp.WithAdmin(info),
+		grpcapp.WithMaintenance(maintenance),
+		grpcapp.WithQuotas(authService),
+		grpcapp.WithTokenLookup(authService),
+		grpcapp.WithRoleAssignment(authService),
+		grpcapp.WithUserListing(authService),
+		grpcapp.WithStats(authService),
+		grpcapp.WithUserStreaming(authService),
+		grpcapp.WithUserBatches(authService),
+		grpcapp.WithTokenTTLOverrides(authService),
+		grpcapp.WithInvites(authService),
+	}
+	if webhookService != nil {
+		adminServices = append(adminServices,
+			grpcapp.WithFailedTasks(webhookService),
+			grpcapp.WithWebhooks(webhookService),
+		)
+	}
+	if cfg.Usage.Enabled {
+		adminServices = append(adminServices, grpcapp.WithAppUsage(authService))
+	}
+	splitAdmin := len(cfg.GRPC.Admin.Listen) > 0
+	retryBudget := 0
+	if r := cfg.Storage.ReadRetry; r.Enabled {
+		retryBudget = r.PerRequest
+	}
+	unknownFields := interceptors.UnknownFieldsMode(cfg.GRPC.UnknownFields)
+	if unknownFields == "" {
+		unknownFields = interceptors.UnknownFieldsWarn
+		if cfg.Env == envLocal {
+			unknownFields = interceptors.UnknownFieldsReject
+		}
+	}
+
+	grpcOpts := []grpcapp.Option{
+		grpcapp.WithPort(cfg.GRPC.Port),
+		grpcapp.WithListen(cfg.GRPC.Listen...),
+		grpcapp.WithSocketMode(os.FileMode(socketMode)),
+		grpcapp.WithTimeouts(cfg.GRPC.MethodTimeouts, cfg.GRPC.Timeout),
+		grpcapp.WithKeepalive(
+			keepalive.EnforcementPolicy{
+				MinTime:             cfg.GRPC.Keepalive.MinTime,
+				PermitWithoutStream: cfg.GRPC.Keepalive.PermitWithoutStream,
+			},
+			keepalive.ServerParameters{
+				MaxConnectionIdle:     cfg.GRPC.Keepalive.MaxConnectionIdle,
+				MaxConnectionAge:      cfg.GRPC.Keepalive.MaxConnectionAge,
+				MaxConnectionAgeGrace: cfg.GRPC.Keepalive.MaxConnectionAgeGrace,
+				Time:                  cfg.GRPC.Keepalive.Time,
+				Timeout:               cfg.GRPC.Keepalive.Timeout,
+			},
+		),
+		grpcapp.WithMaxConcurrentStreams(cfg.GRPC.MaxStreams),
+		grpcapp.WithMaxHeaderListSize(cfg.GRPC.MaxHeaderList),
+		grpcapp.WithMaxConnections(cfg.GRPC.MaxConnections),
+		grpcapp.WithBindRetries(cfg.GRPC.BindRetries, cfg.GRPC.BindBackoff),
+		grpcapp.WithReflection(cfg.GRPC.Reflection || cfg.Env == envLocal),
+		grpcapp.WithDebug(debugService),
+		grpcapp.WithSession(authService),
+		// No Identities service until identity providers can be
+		// configured: without one, LinkIdentity only fails with
+		// UNKNOWN_PROVIDER.
+		grpcapp.WithChallenges(authService),
+		grpcapp.WithPermissions(authService),
+		grpcapp.WithOrgUnits(authService),
+		grpcapp.WithActivations(authService),
+		grpcapp.WithAuthorizations(authService),
+		grpcapp.WithPolicies(policies, authService),
+		grpcapp.WithRequestCache(cachedMethods),
+		grpcapp.WithDecisionLog(decisions),
+		grpcapp.WithTrustedProxies(cfg.GRPC.TrustedProxies...),
+		grpcapp.WithAppCredentials(cfg.GRPC.AppAuth, storage, clk),
+		grpcapp.WithNonces(cfg.GRPC.Nonces.Methods, nonce.NewMemory(cfg.GRPC.Nonces.MaxEntries, cfg.GRPC.Nonces.MaxPerClient, clk), cfg.GRPC.Nonces.TTL),
+		grpcapp.WithHealth(probe),
+		// Even redacted, payloads are personal data: never outside local.
+		grpcapp.WithPayloadLogging(cfg.Env == envLocal && cfg.GRPC.LogPayloads),
+		grpcapp.WithVerboseErrors(cfg.Errors.Verbose),
+		grpcapp.WithUnknownFields(unknownFields),
+		grpcapp.WithRetryBudget(retryBudget),
+		grpcapp.WithChain(cfg.GRPC.Interceptors...),
+	}
+	if cfg.TOS.RequiredVersion != "" {
+		grpcOpts = append(grpcOpts, grpcapp.WithTerms(authService))
+	}
+	grpcOpts = append(grpcOpts, grpcapp.WithDeletions(authService))
+	if !splitAdmin {
+		grpcOpts = append(grpcOpts, adminServices...)
+	}
+	grpcApp, err := grpcapp.NewServer(log, authService, grpcOpts...)
+	if err != nil {
+		log.Error("failed to create gRPC server", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	var adminApp *grpcapp.App
+	if splitAdmin {
+		adminOpts := append([]grpcapp.Option{
+			grpcapp.WithListen(cfg.GRPC.Admin.Listen...),
+			grpcapp.WithSocketMode(os.FileMode(socketMode)),
+			grpcapp.WithTimeouts(cfg.GRPC.MethodTimeouts, cfg.GRPC.Timeout),
+			grpcapp.WithBindRetries(cfg.GRPC.BindRetries, cfg.GRPC.BindBackoff),
+			grpcapp.WithReflection(cfg.GRPC.Reflection || cfg.Env == envLocal),
+			grpcapp.WithPolicies(policies, authService),
+			grpcapp.WithDecisionLog(decisions),
+			grpcapp.WithTrustedProxies(cfg.GRPC.TrustedProxies...),
+			grpcapp.WithVerboseErrors(cfg.Errors.Verbose),
+			grpcapp.WithUnknownFields(unknownFields),
+			grpcapp.WithRetryBudget(retryBudget),
+			grpcapp.WithChain(cfg.GRPC.Interceptors...),
+		}, adminServices...)
+		if t := cfg.GRPC.Admin.TLS; t.CertFile != "" {
+			adminOpts = append(adminOpts, grpcapp.WithTLS(t.CertFile, t.KeyFile, t.ClientCAFile))
+		}
+		adminApp, err = grpcapp.NewAdminServer(log, interceptors.Policy{Role: auth.AdminRole}, adminOpts...)
+		if err != nil {
+			log.Error("failed to create admin gRPC server", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	jobs := []jobsapp.Job{{Name: "health", Interval: cfg.Health.Interval, Run: probe.Run}}
+	// A shared storage is kept by the instance holding it; the jobs of
+	// this instance's own state still run.
+	if backup := cfg.Storage.Backup; backup.Enabled && !storage.Shared() {
+		jobs = append(jobs, jobsapp.BackupJob(storage, backup.Dir, backup.Keep, backup.Interval, clk))
+	}
+	if !storage.Shared() {
+		jobs = append(jobs, maintenance.Job(cfg.Storage.Maintenance.Interval))
+		jobs = append(jobs, jobsapp.Job{
+			Name:     "account-deletion",
+			Interval: cfg.Deletion.Interval,
+			Run: func(ctx context.Context) error {
+				_, err := authService.DeleteDueAccounts(ctx)
+
+				return err
+			},
+		})
+	}
+	if cfg.Usage.Enabled {
+		jobs = append(jobs, jobsapp.Job{
+			Name:     "app-usage",
+			Interval: cfg.Usage.FlushInterval,
+			Run:      authService.FlushUsage,
+		})
+	}
+	if webhookService != nil && !storage.Shared() {
+		jobs = append(jobs, jobsapp.Job{
+			Name:     "webhooks",
+			Interval: cfg.Webhooks.Interval,
+			Run:      webhookService.Dispatch,
+		})
+	}
+	if keyManager != nil {
+		jobs = append(jobs, jobsapp.Job{
+			Name:     "signing-keys",
+			Interval: cfg.JWT.KeyRefreshPeriod,
+			Run:      keyManager.Refresh,
+		})
+	}
+
+	var debugApp *debugapp.App
+	if cfg.Debug.Enabled {
+		vars := debugapp.Vars{
+			"storage_pool":                   func() any { return storage.Stats() },
+			"storage_replica":                func() any { return storage.ReplicaStats() },
+			"storage_query_duration_seconds": func() any { return queryDurations.Snapshot() },
+			"password_hash_duration_seconds": func() any { return hists.get("password_hash_duration_seconds").Snapshot() },
+			"grpc_open_connections":          func() any { return grpcApp.Connections() },
+			"grpc_unknown_fields_requests": func() any {
+				n := grpcApp.UnknownFieldRequests()
+				if adminApp != nil {
+					n += adminApp.UnknownFieldRequests()
+				}
+
+				return n
+			},
+			"password_hash_invalid_total": func() any { return authService.InvalidHashes() },
+			"auth_stage_timeouts_total":   func() any { return authService.StageTimeouts() },
+			"storage_maintenance":         func() any { return maintenance.Metrics() },
+			"introspect_cached_tokens":    func() any { return authService.CachedTokens() },
+			"auth_login_anomalies_total":  func() any { return authService.LoginAnomalies() },
+			"auth_tarpit": func() any {
+				delays, skipped := authService.TarpitDelays()
+
+				return map[string]any{"delay_seconds": delays, "skipped_total": skipped}
+			},
+		}
+		if webhookService != nil {
+			vars["webhooks"] = func() any { return webhookService.Metrics() }
+		}
+		if auditExporter != nil {
+			vars["audit_export"] = func() any { return auditExporter.Metrics() }
+		}
+		debugApp = debugapp.New(log, cfg.Debug.Address, config.Redact(cfg), vars)
+		debugApp.Handle("/healthz", probe.Handler(health.Liveness))
+		debugApp.Handle("/readyz", probe.Handler(health.Readiness))
+	}
+
+	// Unlike the debug server, the HTTP server is meant to be reachable
+	// by other services, so it serves nothing else.
+	var httpApp *httpapp.App
+	if cfg.HTTP.Enabled {
+		httpApp = httpapp.New(log, cfg.HTTP.Address)
+		if cfg.Introspect.Enabled {
+			httpApp.Handle(introspect.Path, introspect.Handler(log, authService))
+		}
+		if signingKeys != nil {
+			httpApp.Handle("/.well-known/", oidc.Handler(cfg.JWT.Issuer, signingKeys))
+		}
+	}
+
+	info.info.Listeners = grpcApp.Listeners()
+	if adminApp != nil {
+		info.info.Listeners = append(info.info.Listeners, adminApp.Listeners()...)
+	}
+	if debugApp != nil {
+		info.info.Listeners = append(info.info.Listeners, "http://"+cfg.Debug.Address)
+	}
+	if httpApp != nil {
+		info.info.Listeners = append(info.info.Listeners, "http://"+cfg.HTTP.Address)
+	}
+	info.logBanner(context.Background(), log)
 
 	return &App{
 		GRPCServer: grpcApp,
+		Admin:      adminApp,
+		Jobs:       jobsapp.New(log, clk, jobs...),
+		Health:     probe,
+		Debug:      debugApp,
+		HTTP:       httpApp,
+		log:        log,
+		auth:       authService,
+		audit:      auditExporter,
+		histograms: hists,
+		storage:    storage,
+	}
+}
+
+// Reload applies the reloadable settings of cfg, see config.Reloader.
+func (a *App) Reload(cfg *config.Config) {
+	a.GRPCServer.SetTimeouts(cfg.GRPC.MethodTimeouts, cfg.GRPC.Timeout)
+	if a.Admin != nil {
+		a.Admin.SetTimeouts(cfg.GRPC.MethodTimeouts, cfg.GRPC.Timeout)
+	}
+	a.histograms.reload(a.log, cfg.Metrics)
+	if err := a.auth.SetRegistrationMode(auth.RegistrationMode(cfg.Registration.Mode)); err != nil {
+		a.log.Error("failed to apply registration.mode", slog.Any("error", err))
+	}
+	if list, err := reserved.New(cfg.Registration.Reserved); err != nil {
+		a.log.Error("failed to apply registration.reserved", slog.Any("error", err))
+	} else {
+		a.auth.SetReservedIdentifiers(list)
+	}
+	domains, err := emaildomain.New(cfg.Registration.AllowedDomains, cfg.Registration.DeniedDomains)
+	if err != nil {
+		a.log.Error("failed to apply registration domains", slog.Any("error", err))
+		return
+	}
+	a.auth.SetEmailDomains(domains)
+}
+
+// Validate reports the components a is missing, such as an App built
+// other than by New, so that they fail here rather than as a nil pointer
+// once running. Run validates a first.
+func (a *App) Validate() error {
+	const op = "app.Validate"
+
+	var missing []string
+	for _, c := range []struct {
+		name    string
+		missing bool
+	}{
+		{"grpc server", a.GRPCServer == nil},
+		{"jobs", a.Jobs == nil},
+		{"health probe", a.Health == nil},
+		{"logger", a.log == nil},
+		{"auth service", a.auth == nil},
+		{"histograms", a.histograms == nil},
+		{"storage", a.storage == nil},
+	} {
+		if c.missing {
+			missing = append(missing, c.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: missing %s", op, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// Run starts every component and blocks until a gRPC server stops.
+// The first error reported by any component is returned; the caller is
+// expected to Stop the application afterwards.
+func (a *App) Run() error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
+	errs := make(chan error, 4)
+
+	go a.Jobs.Run()
+
+	if a.Debug != nil {
+		go func() {
+			if err := a.Debug.Run(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	if a.HTTP != nil {
+		go func() {
+			if err := a.HTTP.Run(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	if a.Admin != nil {
+		go func() {
+			errs <- a.Admin.Run()
+		}()
+	}
+
+	go func() {
+		errs <- a.GRPCServer.Run()
+	}()
+
+	return <-errs
+}
+
+// Stop stops every component, the servers first so in-flight requests
+// can still use the rest, and the storage after everything writing to it.
+// Readiness goes down before the servers drain.
+// An App that does not Validate never ran, and Stop does nothing.
+func (a *App) Stop() {
+	if a.Validate() != nil {
+		return
+	}
+
+	a.Health.Drain()
+	a.GRPCServer.Stop()
+	if a.Admin != nil {
+		a.Admin.Stop()
+	}
+	if a.HTTP != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+
+		a.HTTP.Stop(ctx)
+	}
+	a.Jobs.Stop()
+
+	// The usage of the requests drained above is not lost.
+	ctx, cancel := context.WithTimeout(context.Background(), usageFlushTimeout)
+	defer cancel()
+	if err := a.auth.FlushUsage(ctx); err != nil {
+		a.log.Error("failed to flush app usage", slog.Any("error", err))
+	}
+	// The events of the requests drained above are exported too.
+	if a.audit != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+		defer cancel()
+
+		if err := a.audit.Close(ctx); err != nil {
+			a.log.Error("failed to flush audit export", slog.Any("error", err))
+		}
+	}
+
+	if a.Debug != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), debugShutdownTimeout)
+		defer cancel()
+
+		a.Debug.Stop(ctx)
+	}
+
+	// Last, as the flushes above write to it and the probes of the debug
+	// server read it. Closing copies what the replica has not yet and
+	// releases the instance lock.
+	if err := a.storage.Close(); err != nil {
+		a.log.Error("failed to close storage", slog.Any("error", err))
 	}
 }
@@ -0,0 +1,16 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_Validate(t *testing.T) {
+	a := &App{}
+
+	err := a.Validate()
+	assert.EqualError(t, err, "app.Validate: missing grpc server, jobs, health probe, logger, auth service, histograms, storage")
+	assert.EqualError(t, a.Run(), err.Error(), "nothing runs")
+	assert.NotPanics(t, a.Stop)
+}
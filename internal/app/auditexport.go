@@ -0,0 +1,49 @@
+package app
+
+import (
+	"log/slog"
+
+	"sso/internal/config"
+	"sso/internal/services/auditexport"
+)
+
+// newAuditExporter opens the audit sinks enabled in cfg.
+func newAuditExporter(log *slog.Logger, cfg config.AuditConfig) (*auditexport.Exporter, error) {
+	var (
+		opts  = []auditexport.Option{auditexport.WithQueueSize(cfg.QueueSize)}
+		sinks []auditexport.Sink
+	)
+	if f := cfg.File; f.Enabled {
+		sink, err := auditexport.NewFileSink(f.Path, int64(f.MaxSizeMB)<<20, f.Keep)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+		opts = append(opts, auditexport.WithSink("file", sink))
+	}
+	if s := cfg.Syslog; s.Enabled {
+		sink, err := auditexport.NewSyslogSink(s.Network, s.Address, s.Tag)
+		if err != nil {
+			closeSinks(sinks)
+
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+		opts = append(opts, auditexport.WithSink("syslog", sink))
+	}
+
+	exporter, err := auditexport.New(log, opts...)
+	if err != nil {
+		closeSinks(sinks)
+
+		return nil, err
+	}
+
+	return exporter, nil
+}
+
+func closeSinks(sinks []auditexport.Sink) {
+	for _, s := range sinks {
+		_ = s.Close()
+	}
+}
@@ -0,0 +1,129 @@
+package debugapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+const readHeaderTimeout = 5 * time.Second
+
+// App is a private HTTP server for profiling and inspecting a running
+// instance. It must only ever listen on a loopback or otherwise private
+// address.
+type App struct {
+	log     *slog.Logger
+	server  *http.Server
+	mux     *http.ServeMux
+	address string
+}
+
+// Vars are extra values served under /debug/vars next to the standard
+// expvar ones. Each function is called on every request.
+type Vars map[string]func() any
+
+// New returns a debug server listening on address. config is the effective
+// configuration, already redacted, served under /debug/config. A nil log
+// logs to slog.Default().
+func New(log *slog.Logger, address string, config any, vars Vars) *App {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", varsHandler(vars))
+	mux.HandleFunc("/debug/config", jsonHandler(func() any { return config }))
+
+	return &App{
+		log:     log,
+		mux:     mux,
+		address: address,
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+		},
+	}
+}
+
+// Handle registers an extra handler. It must be called before Run.
+func (a *App) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// MustRun runs debug server and panics if any errors occurs
+func (a *App) MustRun() {
+	if err := a.Run(); err != nil {
+		panic(err)
+	}
+}
+
+// Run runs debug server
+func (a *App) Run() error {
+	const op = "debugapp.Run"
+
+	l, err := net.Listen("tcp", a.address)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.Info("debug server is running",
+		slog.String("op", op),
+		slog.String("address", l.Addr().String()),
+	)
+
+	if err := a.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops debug server
+func (a *App) Stop(ctx context.Context) {
+	const op = "debugapp.Stop"
+
+	a.log.Info("stopping debug server", slog.String("op", op))
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.log.Warn("debug server shutdown failed", slog.String("op", op), slog.Any("error", err))
+	}
+}
+
+func varsHandler(vars Vars) http.HandlerFunc {
+	return jsonHandler(func() any {
+		res := make(map[string]any)
+		expvar.Do(func(kv expvar.KeyValue) {
+			res[kv.Key] = json.RawMessage(kv.Value.String())
+		})
+		for name, fn := range vars {
+			res[name] = fn()
+		}
+		return res
+	})
+}
+
+func jsonHandler(value func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(value()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
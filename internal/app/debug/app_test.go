@@ -0,0 +1,65 @@
+package debugapp
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sso/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	cfg := &config.Config{
+		StoragePath: "./storage/sso.db",
+		Storage:     config.StorageConfig{EncryptionKey: "super-secret"},
+	}
+
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0", config.Redact(cfg), Vars{
+		"grpc_open_connections": func() any { return 3 },
+	})
+	srv := httptest.NewServer(a.server.Handler)
+	defer srv.Close()
+
+	t.Run("heap profile", func(t *testing.T) {
+		body := get(t, srv.URL+"/debug/pprof/heap")
+		assert.NotEmpty(t, body)
+	})
+
+	t.Run("vars", func(t *testing.T) {
+		var vars map[string]any
+		require.NoError(t, json.Unmarshal(get(t, srv.URL+"/debug/vars"), &vars))
+		assert.Contains(t, vars, "memstats")
+		assert.EqualValues(t, 3, vars["grpc_open_connections"])
+	})
+
+	t.Run("config is redacted", func(t *testing.T) {
+		body := get(t, srv.URL+"/debug/config")
+		assert.NotContains(t, string(body), "super-secret")
+
+		var dump map[string]any
+		require.NoError(t, json.Unmarshal(body, &dump))
+		assert.Equal(t, "./storage/sso.db", dump["storage_path"])
+		assert.Equal(t, "***", dump["storage"].(map[string]any)["encryption_key"])
+	})
+}
+
+func get(t *testing.T, url string) []byte {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return body
+}
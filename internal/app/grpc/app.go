@@ -1,75 +1,553 @@
 package grpcapp
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
+	"os"
+	"syscall"
+	"time"
 
+	activationgrpc "sso/internal/grpc/activation"
+	admingrpc "sso/internal/grpc/admin"
 	authgrpc "sso/internal/grpc/auth"
+	authorizationgrpc "sso/internal/grpc/authorization"
+	challengegrpc "sso/internal/grpc/challenge"
+	debuggrpc "sso/internal/grpc/debug"
+	"sso/internal/grpc/grpcerr"
+	identitygrpc "sso/internal/grpc/identity"
+	"sso/internal/grpc/interceptors"
+	orgunitgrpc "sso/internal/grpc/orgunit"
+	permissiongrpc "sso/internal/grpc/permission"
+	quotagrpc "sso/internal/grpc/quota"
+	sessiongrpc "sso/internal/grpc/session"
+	webhookgrpc "sso/internal/grpc/webhook"
+	"sso/internal/lib/apiversion"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/deps"
+	"sso/internal/lib/health"
+	"sso/internal/lib/metrics"
 
+	"golang.org/x/net/netutil"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+)
+
+const (
+	defaultMaxConcurrentStreams = 100
+	defaultMaxHeaderListSize    = 16 << 10
+	defaultBindBackoff          = 250 * time.Millisecond
+)
+
+var (
+	defaultEnforcementPolicy = keepalive.EnforcementPolicy{
+		MinTime: 30 * time.Second,
+	}
+	defaultServerParameters = keepalive.ServerParameters{
+		MaxConnectionIdle:     5 * time.Minute,
+		MaxConnectionAge:      30 * time.Minute,
+		MaxConnectionAgeGrace: 10 * time.Second,
+	}
 )
 
 type App struct {
-	log        *slog.Logger
-	gRPCServer *grpc.Server
-	port       int
+	log            *slog.Logger
+	gRPCServer     *grpc.Server
+	listen         []listenSpec
+	socketMode     os.FileMode
+	maxConnections int
+	connections    *metrics.Gauge
+	unknownFields  *metrics.Counter
+	bindRetries    int
+	bindBackoff    time.Duration
+	health         *health.Probe
+	timeouts       *interceptors.Timeouts
 }
 
+// Options configures the gRPC server. Zero values fall back to defaults.
+//
+// Deprecated: use NewServer with the With options.
+type Options struct {
+	// MethodTimeouts bounds handlers per method, keyed by full or bare
+	// method name. Methods without an entry use DefaultTimeout.
+	MethodTimeouts map[string]time.Duration
+	DefaultTimeout time.Duration
+
+	// EnforcementPolicy is how often clients may ping; violators get GOAWAY.
+	EnforcementPolicy *keepalive.EnforcementPolicy
+	// ServerParameters controls idle and aged connection closing.
+	ServerParameters     *keepalive.ServerParameters
+	MaxConcurrentStreams uint32
+	MaxHeaderListSize    uint32
+	// MaxConnections caps simultaneously accepted connections per
+	// listener. Zero means no cap.
+	MaxConnections int
+
+	// Listen lists listener specs like "tcp://:44044" or
+	// "unix:///var/run/sso.sock". Empty means TCP on the given port.
+	Listen []string
+	// SocketMode is the permission of unix socket files. Default 0660.
+	SocketMode os.FileMode
+
+	// BindRetries is how many more times Run tries to listen when the
+	// address is still in use, e.g. during a rolling restart. The delay
+	// starts at BindBackoff and doubles on each attempt.
+	BindRetries int
+	BindBackoff time.Duration
+
+	// Reflection registers the server reflection service.
+	Reflection bool
+	// Debug registers the sso.debug.v1.Debug service backed by the given
+	// validator. Nil leaves it out; it is meant for local mode only.
+	Debug debuggrpc.TokenValidator
+
+	// Policies lists the methods that require a token, keyed by full or
+	// bare method name. Authorizer checks the tokens and must be set when
+	// there are policies.
+	Policies   map[string]interceptors.Policy
+	Authorizer interceptors.Authorizer
+
+	// TrustedProxies lists the CIDRs of reverse proxies whose forwarding
+	// headers tell the client IP.
+	TrustedProxies []string
+}
+
+// New returns a gRPC server configured by opts.
+//
+// Deprecated: use NewServer.
 func New(
 	log *slog.Logger,
 	authService authgrpc.Auth,
 	port int,
-) *App {
-	gRPCServer := grpc.NewServer()
+	opts Options,
+) (*App, error) {
+	return NewServer(log, authService, WithPort(port), func(s *settings) { s.Options = opts })
+}
+
+// NewServer returns a gRPC server serving authService. Missing
+// dependencies and invalid options are reported here rather than when
+// the server runs.
+func NewServer(log *slog.Logger, authService authgrpc.Auth, options ...Option) (*App, error) {
+	const op = "grpcapp.NewServer"
+
+	var opts settings
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if log == nil {
+		return nil, fmt.Errorf("%s: logger is required", op)
+	}
+	if deps.Missing(authService) {
+		return nil, fmt.Errorf("%s: auth service is required", op)
+	}
+
+	return newApp(op, log, opts, func(gRPCServer *grpc.Server) {
+		authgrpc.Register(gRPCServer, authService)
+	})
+}
+
+// NewAdminServer returns a gRPC server serving the admin services of
+// options, such as WithAdmin and WithQuotas, but not the Auth service,
+// to be run on listeners of their own. Methods without a policy get
+// policy rather than being public, so a service added to the admin
+// server is never open by mistake; an authorizer is thus required.
+func NewAdminServer(log *slog.Logger, policy interceptors.Policy, options ...Option) (*App, error) {
+	const op = "grpcapp.NewAdminServer"
+
+	var opts settings
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if log == nil {
+		return nil, fmt.Errorf("%s: logger is required", op)
+	}
+	if deps.Missing(opts.Authorizer) {
+		return nil, fmt.Errorf("%s: authorizer is required", op)
+	}
+
+	// Authorize reads the map when called, so the policies of the
+	// methods registered below are in force before the server runs.
+	policies := maps.Clone(opts.Policies)
+	if policies == nil {
+		policies = make(map[string]interceptors.Policy)
+	}
+	opts.Policies = policies
+
+	a, err := newApp(op, log, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, service := range a.gRPCServer.GetServiceInfo() {
+		if publicServices[name] {
+			continue
+		}
+		for _, m := range service.Methods {
+			_, full := policies["/"+name+"/"+m.Name]
+			_, bare := policies[m.Name]
+			if !full && !bare {
+				policies["/"+name+"/"+m.Name] = policy
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// publicServices are the services an admin server keeps public: probes
+// and tools need no token.
+var publicServices = map[string]bool{
+	healthpb.Health_ServiceDesc.ServiceName:    true,
+	"grpc.reflection.v1.ServerReflection":      true,
+	"grpc.reflection.v1alpha.ServerReflection": true,
+}
+
+// newApp builds the server of opts, on which register registers the
+// services other than the optional ones. Errors are prefixed by op.
+func newApp(op string, log *slog.Logger, opts settings, register func(*grpc.Server)) (*App, error) {
+	specs := opts.Listen
+	if len(specs) == 0 {
+		specs = []string{fmt.Sprintf("tcp://:%d", opts.port)}
+	}
+	listen := make([]listenSpec, 0, len(specs))
+	for _, spec := range specs {
+		l, err := parseListenSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		listen = append(listen, l)
+	}
+
+	socketMode := opts.SocketMode
+	if socketMode == 0 {
+		socketMode = defaultSocketMode
+	}
+	bindBackoff := opts.BindBackoff
+	if bindBackoff <= 0 {
+		bindBackoff = defaultBindBackoff
+	}
+
+	enforcementPolicy := defaultEnforcementPolicy
+	if opts.EnforcementPolicy != nil {
+		enforcementPolicy = *opts.EnforcementPolicy
+	}
+	serverParameters := defaultServerParameters
+	if opts.ServerParameters != nil {
+		serverParameters = *opts.ServerParameters
+	}
+	maxStreams := opts.MaxConcurrentStreams
+	if maxStreams == 0 {
+		maxStreams = defaultMaxConcurrentStreams
+	}
+	maxHeaderListSize := opts.MaxHeaderListSize
+	if maxHeaderListSize == 0 {
+		maxHeaderListSize = defaultMaxHeaderListSize
+	}
+
+	trustedProxies, err := clientip.ParsePrefixes(opts.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(opts.Policies) > 0 && deps.Missing(opts.Authorizer) {
+		return nil, fmt.Errorf("%s: policies are set but authorizer is nil", op)
+	}
+
+	if len(opts.appMethods) > 0 && deps.Missing(opts.apps) {
+		return nil, fmt.Errorf("%s: app credentials are required but apps is nil", op)
+	}
+
+	if len(opts.nonceMethods) > 0 && (deps.Missing(opts.nonces) || opts.nonceTTL <= 0) {
+		return nil, fmt.Errorf("%s: nonces are required but the store or ttl is missing", op)
+	}
+	// The optional services are left nil when not given; given, they must
+	// not be a nil pointer, which would be registered and fail once
+	// called.
+	for _, dep := range []struct {
+		name string
+		v    any
+	}{
+		{"session identifier", opts.session},
+		{"terms acceptor", opts.terms},
+		{"deleter", opts.deletions},
+		{"admin info provider", opts.admin},
+		{"maintainer", opts.maintainer},
+		{"task lister", opts.failedTasks},
+		{"token lookup", opts.tokenLookup},
+		{"role assigner", opts.roleAssigner},
+		{"user lister", opts.userLister},
+		{"stats provider", opts.stats},
+		{"user streamer", opts.userStreamer},
+		{"user batch", opts.userBatch},
+		{"token ttl setter", opts.tokenTTLs},
+		{"invite creator", opts.invites},
+		{"identity linker", opts.identities},
+		{"challenge completer", opts.challenges},
+		{"permission manager", opts.permissions},
+		{"org unit manager", opts.orgUnits},
+		{"activator", opts.activations},
+		{"authorizer", opts.authorizations},
+		{"quotas", opts.quotas},
+		{"app usage reader", opts.appUsage},
+		{"webhook manager", opts.webhooks},
+	} {
+		if dep.v != nil && deps.Missing(dep.v) {
+			return nil, fmt.Errorf("%s: %s is a nil %T", op, dep.name, dep.v)
+		}
+	}
+
+	connections := metrics.NewGauge("grpc_open_connections")
+	unknownFields := metrics.NewCounter("grpc_unknown_fields_requests_total")
+	timeouts := interceptors.NewTimeouts(opts.MethodTimeouts, opts.DefaultTimeout)
+
+	order := opts.chain
+	if len(order) == 0 {
+		order = DefaultChain
+	}
+	if err := validateChain(order); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-	authgrpc.Register(gRPCServer, authService)
+	builtin := map[string]grpc.UnaryServerInterceptor{
+		ClientIPInterceptor:       interceptors.ClientIP(trustedProxies),
+		ClientCertInterceptor:     interceptors.ClientCert(),
+		AuditContextInterceptor:   interceptors.AuditContext(),
+		APIVersionInterceptor:     interceptors.APIVersion(apiversion.Min, apiversion.Max),
+		LocalizeInterceptor:       interceptors.Localize(grpcerr.DefaultCatalog()),
+		DeadlineInterceptor:       interceptors.DeadlineFrom(timeouts),
+		AppCredentialsInterceptor: interceptors.AppCredentials(opts.appMethods, opts.apps, opts.appClock),
+		AuthorizeInterceptor:      interceptors.Authorize(opts.Policies, opts.Authorizer, opts.decisions),
+	}
+	if opts.verboseErrors {
+		builtin[VerboseErrorsInterceptor] = interceptors.VerboseErrors(log)
+	}
+	if opts.payloadLogging {
+		builtin[PayloadLoggingInterceptor] = interceptors.PayloadLogger(log)
+	}
+	if opts.unknownFields != "" && opts.unknownFields != interceptors.UnknownFieldsOff {
+		builtin[UnknownFieldsInterceptor] = interceptors.UnknownFields(log, opts.unknownFields, unknownFields)
+	}
+	if opts.retryBudget > 0 {
+		builtin[RetryBudgetInterceptor] = interceptors.RetryBudget(opts.retryBudget)
+	}
+	if len(opts.cachedMethods) > 0 {
+		builtin[RequestCacheInterceptor] = interceptors.RequestCache(opts.cachedMethods)
+	}
+	if len(opts.nonceMethods) > 0 {
+		builtin[NoncesInterceptor] = interceptors.Nonces(opts.nonceMethods, opts.nonces, opts.nonceTTL)
+	}
+	chain := orderChain(order, builtin)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(enforcementPolicy),
+		grpc.KeepaliveParams(serverParameters),
+		grpc.MaxConcurrentStreams(maxStreams),
+		grpc.MaxHeaderListSize(maxHeaderListSize),
+		grpc.StatsHandler(&connStats{conns: connections}),
+		grpc.ChainUnaryInterceptor(append(chain, opts.interceptors...)...),
+		grpc.ChainStreamInterceptor(orderStreamChain(order, builtin)...),
+	}
+	if opts.tls != nil {
+		creds, err := opts.tls.credentials()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+
+	gRPCServer := grpc.NewServer(serverOpts...)
+
+	if register != nil {
+		register(gRPCServer)
+	}
+	if opts.Debug != nil {
+		debuggrpc.Register(gRPCServer, opts.Debug)
+	}
+	if opts.session != nil {
+		sessiongrpc.Register(gRPCServer, opts.session, opts.terms, opts.deletions)
+	}
+	if opts.admin != nil {
+		admingrpc.Register(gRPCServer, opts.admin, opts.maintainer, opts.failedTasks, opts.tokenLookup, opts.roleAssigner, opts.userLister, opts.stats, opts.userStreamer, opts.userBatch, opts.tokenTTLs, opts.invites)
+	}
+	if opts.identities != nil {
+		identitygrpc.Register(gRPCServer, opts.identities)
+	}
+	if opts.challenges != nil {
+		challengegrpc.Register(gRPCServer, opts.challenges)
+	}
+	if opts.permissions != nil {
+		permissiongrpc.Register(gRPCServer, opts.permissions)
+	}
+	if opts.orgUnits != nil {
+		orgunitgrpc.Register(gRPCServer, opts.orgUnits)
+	}
+	if opts.activations != nil {
+		activationgrpc.Register(gRPCServer, opts.activations)
+	}
+	if opts.authorizations != nil {
+		authorizationgrpc.Register(gRPCServer, opts.authorizations)
+	}
+	if opts.quotas != nil {
+		quotagrpc.Register(gRPCServer, opts.quotas, opts.appUsage)
+	}
+	if opts.webhooks != nil {
+		webhookgrpc.Register(gRPCServer, opts.webhooks)
+	}
+	if opts.Reflection {
+		reflection.Register(gRPCServer)
+	}
+	if opts.health != nil {
+		healthServer := grpchealth.NewServer()
+		healthpb.RegisterHealthServer(gRPCServer, healthServer)
+		opts.health.Watch(func(service string, serving bool) {
+			st := healthpb.HealthCheckResponse_NOT_SERVING
+			if serving {
+				st = healthpb.HealthCheckResponse_SERVING
+			}
+			healthServer.SetServingStatus(service, st)
+		})
+	}
 
 	return &App{
-		log:        log,
-		gRPCServer: gRPCServer,
-		port:       port,
+		log:            log,
+		gRPCServer:     gRPCServer,
+		listen:         listen,
+		socketMode:     socketMode,
+		maxConnections: opts.MaxConnections,
+		connections:    connections,
+		unknownFields:  unknownFields,
+		bindRetries:    opts.BindRetries,
+		bindBackoff:    bindBackoff,
+		health:         opts.health,
+		timeouts:       timeouts,
+	}, nil
+}
+
+// SetTimeouts replaces the per-method timeouts given by WithTimeouts.
+// Calls already running keep their deadline.
+func (a *App) SetTimeouts(methods map[string]time.Duration, defaultTimeout time.Duration) {
+	a.timeouts.Set(methods, defaultTimeout)
+}
+
+// Listeners returns the specs of the addresses the server listens on.
+func (a *App) Listeners() []string {
+	res := make([]string, len(a.listen))
+	for i, l := range a.listen {
+		res[i] = l.String()
 	}
+
+	return res
+}
+
+// Connections returns the number of currently open client connections.
+func (a *App) Connections() int64 {
+	return a.connections.Value()
+}
+
+// UnknownFieldRequests returns the number of requests seen with fields
+// unknown to the server, see WithUnknownFields.
+func (a *App) UnknownFieldRequests() int64 {
+	return a.unknownFields.Value()
 }
 
-// MustRun runs gRPC server and panics if any errors occurs
+// MustRun runs gRPC server and panics if any errors occurs.
+// Prefer Run; MustRun is kept for callers with nothing to clean up.
 func (a *App) MustRun() {
 	if err := a.Run(); err != nil {
 		panic(err)
 	}
 }
 
-// Run runs gRPC server
+// Run runs gRPC server on every configured listener and blocks until
+// Stop is called. The same server handles all listeners.
 func (a *App) Run() error {
 	const op = "grpcapp.Run"
 
-	log := a.log.With(
-		slog.String("op", op),
-		slog.Int("port", a.port),
-	)
+	listeners := make([]net.Listener, 0, len(a.listen))
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", a.port))
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	for _, spec := range a.listen {
+		l, err := a.listenWithRetry(spec)
+		if err != nil {
+			closeAll()
+
+			return fmt.Errorf("%s: %s: %w", op, spec, err)
+		}
+
+		if a.maxConnections > 0 {
+			l = netutil.LimitListener(l, a.maxConnections)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	for i, l := range listeners {
+		a.log.Info("gRPC server is running",
+			slog.String("op", op),
+			slog.String("listener", a.listen[i].String()),
+		)
+
+		go func() {
+			errs <- a.gRPCServer.Serve(l)
+		}()
 	}
 
-	log.Info("gRPC server is running")
+	if a.health != nil {
+		a.health.SetLive(true)
+		defer a.health.SetLive(false)
+	}
 
-	if err := a.gRPCServer.Serve(l); err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	var res error
+	for range listeners {
+		// ErrServerStopped means Stop came before Serve: a clean shutdown.
+		if err := <-errs; err != nil && !errors.Is(err, grpc.ErrServerStopped) && res == nil {
+			res = fmt.Errorf("%s: %w", op, err)
+			// One listener failing takes the others down too.
+			a.gRPCServer.Stop()
+		}
 	}
 
-	return nil
+	return res
 }
 
-// Stop stops gRPC server
+// Stop stops gRPC server. Unix socket files are removed.
 func (a *App) Stop() {
 	const op = "grpcapp.Stop"
 
-	a.log.With(
-		slog.String("op", op),
-		slog.Int("port", a.port),
-	)
+	a.log.Info("stopping gRPC server", slog.String("op", op))
 
 	a.gRPCServer.GracefulStop()
 }
+
+// listenWithRetry opens the listener, retrying with exponential backoff
+// while the address is in use.
+func (a *App) listenWithRetry(spec listenSpec) (net.Listener, error) {
+	backoff := a.bindBackoff
+	for remaining := a.bindRetries; ; remaining-- {
+		l, err := spec.listen(a.socketMode)
+		if err == nil || remaining <= 0 || !errors.Is(err, syscall.EADDRINUSE) {
+			return l, err
+		}
+
+		a.log.Warn("address in use, retrying",
+			slog.String("listener", spec.String()),
+			slog.Int("remaining_attempts", remaining),
+			slog.Duration("backoff", backoff),
+		)
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
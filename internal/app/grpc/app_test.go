@@ -0,0 +1,1464 @@
+package grpcapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	admingrpc "sso/internal/grpc/admin"
+	authgrpc "sso/internal/grpc/auth"
+	authorizationgrpc "sso/internal/grpc/authorization"
+	identitygrpc "sso/internal/grpc/identity"
+	"sso/internal/grpc/interceptors"
+	sessiongrpc "sso/internal/grpc/session"
+	webhookgrpc "sso/internal/grpc/webhook"
+	"sso/internal/lib/apiversion"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/health"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/nonce"
+	"sso/internal/services/auth"
+	"sso/internal/services/webhooks"
+	"sso/internal/storage/memory"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// TestKeepalivePolicyViolation pings the server far more often than the
+// enforcement policy allows and expects a GOAWAY with ENHANCE_YOUR_CALM.
+// A raw HTTP/2 framer is used because grpc-go clamps client keepalive
+// intervals to at least 10s.
+func TestKeepalivePolicyViolation(t *testing.T) {
+	a, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{}, 0, Options{
+		EnforcementPolicy: &keepalive.EnforcementPolicy{MinTime: time.Minute},
+	})
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = a.gRPCServer.Serve(l) }()
+	t.Cleanup(a.gRPCServer.Stop)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+
+	for i := range 5 {
+		require.NoError(t, framer.WritePing(false, [8]byte{byte(i)}))
+	}
+
+	for {
+		frame, err := framer.ReadFrame()
+		require.NoError(t, err, "connection closed without GOAWAY")
+
+		goAway, ok := frame.(*http2.GoAwayFrame)
+		if !ok {
+			continue
+		}
+
+		assert.Equal(t, http2.ErrCodeEnhanceYourCalm, goAway.ErrCode)
+		assert.Equal(t, "too_many_pings", string(goAway.DebugData()))
+
+		return
+	}
+}
+
+func TestConnectionGauge(t *testing.T) {
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{})
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = a.gRPCServer.Serve(l) }()
+	t.Cleanup(a.gRPCServer.Stop)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.NewFramer(conn, conn).WriteSettings())
+
+	assert.Eventually(t, func() bool { return a.Connections() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool { return a.Connections() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRun_UnixAndTCPListeners(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "sso.sock")
+
+	// A stale socket left behind by a crashed process must not block startup.
+	stale, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+		WithListen("unix://"+socketPath, "tcp://127.0.0.1:0"),
+		WithSocketMode(0o600),
+	)
+	require.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- a.Run() }()
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	cc, err := grpc.NewClient("passthrough:///unix",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		}),
+	)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	// Validation rejects the request before the stub service is reached,
+	// which proves the call went through the server.
+	_, err = ssov1.NewAuthClient(cc).UserExists(context.Background(), &ssov1.UserExistsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	a.Stop()
+	require.NoError(t, <-runErr)
+
+	_, err = os.Stat(socketPath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestParseListenSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    listenSpec
+		wantErr bool
+	}{
+		{spec: "tcp://:44044", want: listenSpec{network: "tcp", address: ":44044"}},
+		{spec: ":44044", want: listenSpec{network: "tcp", address: ":44044"}},
+		{spec: "unix:///var/run/sso.sock", want: listenSpec{network: "unix", address: "/var/run/sso.sock"}},
+		{spec: "unix://", wantErr: true},
+		{spec: "udp://:44044", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseListenSpec(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRun_RetriesBusyAddress(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := busy.Addr().String()
+
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+		WithListen("tcp://"+addr),
+		WithBindRetries(5, 50*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	released := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() {
+		_ = busy.Close()
+		close(released)
+	})
+
+	runErr := make(chan error, 1)
+	go func() { runErr <- a.Run() }()
+
+	<-released
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 3*time.Second, 20*time.Millisecond)
+
+	a.Stop()
+	assert.NoError(t, <-runErr)
+}
+
+func TestRun_ReturnsErrorWhenRetriesExhausted(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+		WithListen("tcp://"+busy.Addr().String()),
+		WithBindRetries(2, 10*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.Run(), syscall.EADDRINUSE)
+}
+
+// stubAuth is never reached: the tests only send requests that fail
+// validation.
+type stubAuth struct{ authgrpc.Auth }
+
+type fakeValidator struct{}
+
+func (fakeValidator) ValidateToken(_ context.Context, token string) (jwt.Claims, error) {
+	if token != "good" {
+		return jwt.Claims{}, auth.ErrInvalidToken
+	}
+
+	return jwt.Claims{UserID: 42, Raw: map[string]any{"uid": float64(42), "email": "a@b.c"}}, nil
+}
+
+func serve(t *testing.T, opts ...Option) *grpc.ClientConn {
+	t.Helper()
+
+	return serveAuth(t, stubAuth{}, opts...)
+}
+
+func serveAuth(t *testing.T, auth authgrpc.Auth, opts ...Option) *grpc.ClientConn {
+	t.Helper()
+
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), auth, opts...)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = a.gRPCServer.Serve(l) }()
+	t.Cleanup(a.gRPCServer.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func listServices(t *testing.T, conn *grpc.ClientConn) ([]string, error) {
+	t.Helper()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(t.Context())
+	require.NoError(t, err)
+
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	require.NoError(t, err)
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		names = append(names, s.GetName())
+	}
+
+	return names, nil
+}
+
+func TestReflection(t *testing.T) {
+	conn := serve(t, WithReflection(true), WithDebug(fakeValidator{}))
+
+	names, err := listServices(t, conn)
+	require.NoError(t, err)
+	assert.Contains(t, names, "auth.Auth")
+	assert.Contains(t, names, "sso.debug.v1.Debug")
+
+	// The Debug descriptor must resolve, otherwise grpcurl cannot call it.
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(t.Context())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: "sso.debug.v1.Debug",
+		},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetFileDescriptorResponse().GetFileDescriptorProto())
+}
+
+func TestReflection_DisabledByDefault(t *testing.T) {
+	conn := serve(t)
+
+	_, err := listServices(t, conn)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	err = conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &structpb.Struct{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestDebugWhoAmI(t *testing.T) {
+	conn := serve(t, WithDebug(fakeValidator{}))
+
+	var claims structpb.Struct
+	err := conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &claims)
+	require.NoError(t, err)
+	assert.Equal(t, "a@b.c", claims.GetFields()["email"].GetStringValue())
+
+	// The token may come from the authorization header instead.
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	err = conn.Invoke(ctx, "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String(""), &claims)
+	require.NoError(t, err)
+
+	err = conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("bad"), &claims)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+type fakeIdentifier struct{}
+
+func (fakeIdentifier) WhoAmI(_ context.Context, claims jwt.Claims) (models.Identity, error) {
+	return models.Identity{
+		UserID:    42,
+		Email:     claims.Raw["email"].(string),
+		Roles:     []string{"student"},
+		AppID:     1,
+		AppName:   "journal",
+		ExpiresAt: time.Unix(1700000000, 0),
+	}, nil
+}
+
+func (fakeIdentifier) Logout(context.Context, jwt.Claims) error {
+	return nil
+}
+
+func (fakeIdentifier) ElevatePrivileges(_ context.Context, token, password string) (string, error) {
+	if password != "admin-password" {
+		return "", errs.ErrInvalidCredentials
+	}
+
+	return "elevated-" + token, nil
+}
+
+func TestSessionWhoAmI(t *testing.T) {
+	conn := serve(t,
+		WithSession(fakeIdentifier{}),
+		WithPolicies(map[string]interceptors.Policy{sessiongrpc.WhoAmIMethod: {}}, fakeAuthorizer{}),
+		// The Debug service has a WhoAmI too; it must stay out of the policy.
+		WithDebug(fakeValidator{}),
+	)
+
+	var identity structpb.Struct
+	err := conn.Invoke(t.Context(), sessiongrpc.WhoAmIMethod, &emptypb.Empty{}, &identity)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer bad")
+	err = conn.Invoke(ctx, sessiongrpc.WhoAmIMethod, &emptypb.Empty{}, &identity)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	require.NoError(t, conn.Invoke(ctx, sessiongrpc.WhoAmIMethod, &emptypb.Empty{}, &identity))
+	fields := identity.AsMap()
+	assert.Equal(t, float64(42), fields["user_id"])
+	assert.Equal(t, "a@b.c", fields["email"])
+	assert.Equal(t, []any{"student"}, fields["roles"])
+	assert.Equal(t, "journal", fields["app_name"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", fields["expires_at"])
+	assert.NotContains(t, fields, "issued_at")
+
+	var claims structpb.Struct
+	err = conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &claims)
+	assert.NoError(t, err)
+}
+
+func TestSessionElevatePrivileges(t *testing.T) {
+	conn := serve(t,
+		WithSession(fakeIdentifier{}),
+		WithPolicies(map[string]interceptors.Policy{sessiongrpc.ElevatePrivilegesMethod: {}}, fakeAuthorizer{}),
+	)
+
+	var token wrapperspb.StringValue
+	err := conn.Invoke(t.Context(), sessiongrpc.ElevatePrivilegesMethod, wrapperspb.String("admin-password"), &token)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	err = conn.Invoke(ctx, sessiongrpc.ElevatePrivilegesMethod, wrapperspb.String("wrong"), &token)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	require.NoError(t, conn.Invoke(ctx, sessiongrpc.ElevatePrivilegesMethod, wrapperspb.String("admin-password"), &token))
+	assert.Equal(t, "elevated-good", token.GetValue())
+}
+
+type fakeTerms struct{ accepted map[int64]string }
+
+func (f fakeTerms) AcceptTerms(_ context.Context, userID int64, version string) error {
+	if version != "2024-06" {
+		return errs.ErrTermsVersionMismatch
+	}
+	f.accepted[userID] = version
+
+	return nil
+}
+
+func TestSessionAcceptTerms(t *testing.T) {
+	terms := fakeTerms{accepted: make(map[int64]string)}
+	conn := serve(t,
+		WithSession(fakeIdentifier{}),
+		WithTerms(terms),
+		WithPolicies(map[string]interceptors.Policy{sessiongrpc.AcceptTermsMethod: {}}, fakeAuthorizer{}),
+	)
+
+	var empty emptypb.Empty
+	err := conn.Invoke(t.Context(), sessiongrpc.AcceptTermsMethod, wrapperspb.String("2024-06"), &empty)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	err = conn.Invoke(ctx, sessiongrpc.AcceptTermsMethod, wrapperspb.String("2023-01"), &empty)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, terms.accepted)
+
+	require.NoError(t, conn.Invoke(ctx, sessiongrpc.AcceptTermsMethod, wrapperspb.String("2024-06"), &empty))
+	assert.Equal(t, map[int64]string{42: "2024-06"}, terms.accepted)
+
+	// Without terms the method is there but unimplemented.
+	conn = serve(t,
+		WithSession(fakeIdentifier{}),
+		WithPolicies(map[string]interceptors.Policy{sessiongrpc.AcceptTermsMethod: {}}, fakeAuthorizer{}),
+	)
+	err = conn.Invoke(ctx, sessiongrpc.AcceptTermsMethod, wrapperspb.String("2024-06"), &empty)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+type fakeDeleter struct{ scheduled map[int64]bool }
+
+func (f fakeDeleter) ScheduleDeletion(ctx context.Context, userID int64) (models.Deletion, error) {
+	if caller, _ := authctx.CallerFromContext(ctx); caller.UserID != userID {
+		return models.Deletion{}, errs.ErrNotAdmin
+	}
+	f.scheduled[userID] = true
+
+	return models.Deletion{UserID: userID, ReactivationToken: "reactivate", DeleteAfter: time.Unix(1700000000, 0)}, nil
+}
+
+func (f fakeDeleter) CancelDeletion(_ context.Context, userID int64, token string) error {
+	if !f.scheduled[userID] || token != "reactivate" {
+		return errs.ErrInvalidReactivationToken
+	}
+	delete(f.scheduled, userID)
+
+	return nil
+}
+
+func TestSessionDeletion(t *testing.T) {
+	deleter := fakeDeleter{scheduled: make(map[int64]bool)}
+	conn := serve(t,
+		WithSession(fakeIdentifier{}),
+		WithDeletions(deleter),
+		WithPolicies(map[string]interceptors.Policy{sessiongrpc.ScheduleDeletionMethod: {}}, fakeAuthorizer{}),
+	)
+
+	var resp structpb.Struct
+	err := conn.Invoke(t.Context(), sessiongrpc.ScheduleDeletionMethod, &structpb.Struct{}, &resp)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	other, err := structpb.NewStruct(map[string]any{"user_id": 7})
+	require.NoError(t, err)
+	err = conn.Invoke(ctx, sessiongrpc.ScheduleDeletionMethod, other, &resp)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	require.NoError(t, conn.Invoke(ctx, sessiongrpc.ScheduleDeletionMethod, &structpb.Struct{}, &resp))
+	assert.Equal(t, map[string]any{
+		"user_id":            float64(42),
+		"delete_after":       "2023-11-14T22:13:20Z",
+		"reactivation_token": "reactivate",
+	}, resp.AsMap())
+
+	// Cancelled without a token, which ScheduleDeletion revoked.
+	var empty emptypb.Empty
+	wrong, err := structpb.NewStruct(map[string]any{"user_id": 42, "reactivation_token": "wrong"})
+	require.NoError(t, err)
+	err = conn.Invoke(t.Context(), sessiongrpc.CancelDeletionMethod, wrong, &empty)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	cancel, err := structpb.NewStruct(map[string]any{"user_id": 42, "reactivation_token": "reactivate"})
+	require.NoError(t, err)
+	require.NoError(t, conn.Invoke(t.Context(), sessiongrpc.CancelDeletionMethod, cancel, &empty))
+	assert.Empty(t, deleter.scheduled)
+
+	// Without deletions the methods are there but unimplemented.
+	conn = serve(t, WithSession(fakeIdentifier{}))
+	err = conn.Invoke(t.Context(), sessiongrpc.CancelDeletionMethod, cancel, &empty)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+type fakeInfo struct{}
+
+func (fakeInfo) ServerInfo(context.Context) (models.ServerInfo, error) {
+	return models.ServerInfo{
+		Version:       "v1.2.0",
+		Commit:        "abc123",
+		StorageDriver: "sqlite",
+		SchemaVersion: 10,
+		Features:      []string{"webhooks"},
+		Listeners:     []string{"tcp://:44044"},
+		MinAPIVersion: 1,
+		MaxAPIVersion: 2,
+		StartedAt:     time.Unix(1700000000, 0),
+		Uptime:        90 * time.Second,
+		Runtime:       models.RuntimeStats{GoVersion: "go1.24.0", Goroutines: 7},
+	}, nil
+}
+
+func TestAdminGetServerInfo(t *testing.T) {
+	conn := serve(t,
+		WithAdmin(fakeInfo{}),
+		WithPolicies(map[string]interceptors.Policy{admingrpc.GetServerInfoMethod: {Role: auth.AdminRole}}, fakeAuthorizer{}),
+	)
+
+	var info structpb.Struct
+	err := conn.Invoke(t.Context(), admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// fakeAuthorizer gives every user no role.
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	err = conn.Invoke(ctx, admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	conn = serve(t,
+		WithAdmin(fakeInfo{}),
+		WithPolicies(map[string]interceptors.Policy{admingrpc.GetServerInfoMethod: {}}, fakeAuthorizer{}),
+	)
+	require.NoError(t, conn.Invoke(ctx, admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info))
+	fields := info.AsMap()
+	assert.Equal(t, "v1.2.0", fields["version"])
+	assert.Equal(t, float64(10), fields["schema_version"])
+	assert.Equal(t, []any{"webhooks"}, fields["features"])
+	assert.Equal(t, []any{"tcp://:44044"}, fields["listeners"])
+	assert.Equal(t, map[string]any{"min": float64(1), "max": float64(2)}, fields["api_versions"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", fields["started_at"])
+	assert.Equal(t, float64(90), fields["uptime_seconds"])
+	assert.Equal(t, float64(7), fields["runtime"].(map[string]any)["goroutines"])
+}
+
+// fakeRoleAssigner reports every outcome in turn, the error one with the
+// cause it must not leak.
+type fakeRoleAssigner struct{}
+
+func (fakeRoleAssigner) AssignRoleBulk(_ context.Context, userIDs []int64, _ string) ([]models.RoleAssignment, error) {
+	outcomes := []models.RoleOutcome{models.RoleAssigned, models.RoleAlreadyHad, models.RoleUserNotFound, models.RoleError}
+	res := make([]models.RoleAssignment, len(userIDs))
+	for i, id := range userIDs {
+		res[i] = models.RoleAssignment{UserID: id, Outcome: outcomes[i%len(outcomes)]}
+		if res[i].Outcome == models.RoleError {
+			res[i].Err = errors.New("disk I/O error at /var/lib/sso/sso.db")
+		}
+	}
+
+	return res, nil
+}
+
+func TestAdminAssignRoleBulk(t *testing.T) {
+	policies := map[string]interceptors.Policy{admingrpc.AssignRoleBulkMethod: {}}
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	req, err := structpb.NewStruct(map[string]any{"role": "teacher", "user_ids": []any{1, 2, 3, 4, 5}})
+	require.NoError(t, err)
+
+	var resp structpb.Struct
+	conn := serve(t, WithAdmin(fakeInfo{}), WithPolicies(policies, fakeAuthorizer{}))
+	err = conn.Invoke(ctx, admingrpc.AssignRoleBulkMethod, req, &resp)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	conn = serve(t, WithAdmin(fakeInfo{}), WithRoleAssignment(fakeRoleAssigner{}), WithPolicies(policies, fakeAuthorizer{}))
+	require.NoError(t, conn.Invoke(ctx, admingrpc.AssignRoleBulkMethod, req, &resp))
+	fields := resp.AsMap()
+	assert.Equal(t, []any{
+		map[string]any{"user_id": float64(1), "outcome": "assigned"},
+		map[string]any{"user_id": float64(2), "outcome": "already_had"},
+		map[string]any{"user_id": float64(3), "outcome": "user_not_found"},
+		map[string]any{"user_id": float64(4), "outcome": "error"},
+		map[string]any{"user_id": float64(5), "outcome": "assigned"},
+	}, fields["results"])
+	assert.Equal(t, map[string]any{
+		"assigned":       float64(2),
+		"already_had":    float64(1),
+		"user_not_found": float64(1),
+		"error":          float64(1),
+	}, fields["counts"])
+	assert.NotContains(t, resp.String(), "disk I/O")
+
+	for _, ids := range [][]any{{1, 0}, {1.5}, {"1"}, {-1}} {
+		req, err := structpb.NewStruct(map[string]any{"role": "teacher", "user_ids": ids})
+		require.NoError(t, err)
+		err = conn.Invoke(ctx, admingrpc.AssignRoleBulkMethod, req, &resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), ids)
+	}
+}
+
+// fakeUserLister lists users 1 and 2 on the first page, and 3 on the page
+// of token "next".
+type fakeUserLister struct {
+	snapshots *[]bool
+}
+
+func (l fakeUserLister) ListUsers(_ context.Context, pageToken string, pageSize int, snapshot bool) (models.UserPage, error) {
+	*l.snapshots = append(*l.snapshots, snapshot)
+	switch {
+	case pageSize > auth.MaxUsersPageSize:
+		return models.UserPage{}, errs.New(errs.InvalidArgument, "page_size is too large")
+	case pageToken == "":
+		return models.UserPage{Users: []models.UserRecord{
+			{ID: 1, Email: "one@example.com", FirstName: "John", LastName: "Doe", CreatedAt: time.Unix(1700000000, 0)},
+			{ID: 2, Email: "two@example.com", FirstName: "Jane", LastName: "Doe", MiddleName: "Ann"},
+		}, NextPageToken: "next"}, nil
+	case pageToken == "next":
+		return models.UserPage{Users: []models.UserRecord{{ID: 3, Email: "three@example.com"}}}, nil
+	}
+
+	return models.UserPage{}, errs.New(errs.InvalidArgument, "invalid page_token")
+}
+
+func TestAdminListUsers(t *testing.T) {
+	var snapshots []bool
+	conn := serve(t,
+		WithAdmin(fakeInfo{}),
+		WithUserListing(fakeUserLister{snapshots: &snapshots}),
+		WithPolicies(map[string]interceptors.Policy{admingrpc.ListUsersMethod: {}}, fakeAuthorizer{}),
+	)
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	list := func(fields map[string]any) (map[string]any, error) {
+		t.Helper()
+
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+		var resp structpb.Struct
+		err = conn.Invoke(ctx, admingrpc.ListUsersMethod, req, &resp)
+
+		return resp.AsMap(), err
+	}
+
+	first, err := list(map[string]any{"page_size": 2, "snapshot": true})
+	require.NoError(t, err)
+	assert.Equal(t, []any{
+		map[string]any{
+			"id": float64(1), "email": "one@example.com", "first_name": "John", "last_name": "Doe",
+			"created_at": "2023-11-14T22:13:20Z",
+		},
+		map[string]any{
+			"id": float64(2), "email": "two@example.com", "first_name": "Jane", "last_name": "Doe", "middle_name": "Ann",
+		},
+	}, first["users"])
+	assert.Equal(t, "next", first["next_page_token"])
+
+	last, err := list(map[string]any{"page_token": "next"})
+	require.NoError(t, err)
+	assert.Len(t, last["users"], 1)
+	assert.NotContains(t, last, "next_page_token", "the last page has no next one")
+	assert.Equal(t, []bool{true, false}, snapshots)
+
+	_, err = list(map[string]any{"page_token": "forged"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, size := range []any{-1, 1.5, "10"} {
+		_, err = list(map[string]any{"page_size": size})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), size)
+	}
+}
+
+func TestAdminStreamUsers(t *testing.T) {
+	storage := memory.New()
+	// More than the flow control window of the stream holds, so the
+	// export is still running when the client cancels.
+	const total = 20*auth.StreamBatchSize + 50
+	for i := range total {
+		_, err := storage.SaveUser(t.Context(), fmt.Sprintf("user%d@example.com", i), []byte("hash"), "John", "Doe", "")
+		require.NoError(t, err)
+	}
+	users, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage)
+	require.NoError(t, err)
+	conn := serve(t,
+		WithAdmin(fakeInfo{}),
+		WithUserStreaming(users),
+		WithPolicies(map[string]interceptors.Policy{admingrpc.StreamUsersMethod: {}}, fakeAuthorizer{}),
+	)
+	desc := &grpc.StreamDesc{StreamName: "StreamUsers", ServerStreams: true}
+	open := func(ctx context.Context, fields map[string]any) grpc.ClientStream {
+		t.Helper()
+
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+		stream, err := conn.NewStream(ctx, desc, admingrpc.StreamUsersMethod)
+		require.NoError(t, err)
+		require.NoError(t, stream.SendMsg(req))
+		require.NoError(t, stream.CloseSend())
+
+		return stream
+	}
+	// recv returns the IDs of the next batch.
+	recv := func(stream grpc.ClientStream) ([]int64, error) {
+		var batch structpb.Struct
+		if err := stream.RecvMsg(&batch); err != nil {
+			return nil, err
+		}
+		var ids []int64
+		for _, user := range batch.AsMap()["users"].([]any) {
+			ids = append(ids, int64(user.(map[string]any)["id"].(float64)))
+		}
+
+		return ids, nil
+	}
+
+	// The stream is authorized as a call is.
+	_, err = recv(open(t.Context(), nil))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good"))
+	defer cancel()
+	stream := open(ctx, nil)
+	received, err := recv(stream)
+	require.NoError(t, err)
+	require.Len(t, received, auth.StreamBatchSize)
+	cancel()
+	for {
+		// Batches already on the way may still come.
+		ids, err := recv(stream)
+		if err != nil {
+			assert.Equal(t, codes.Canceled, status.Code(err))
+			break
+		}
+		received = append(received, ids...)
+	}
+	require.Less(t, len(received), total)
+
+	// Resuming after the last ID received sends the rest, once each.
+	ctx = metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	stream = open(ctx, map[string]any{"after_id": received[len(received)-1]})
+	for {
+		ids, err := recv(stream)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		received = append(received, ids...)
+	}
+	require.Len(t, received, total)
+	for i, id := range received {
+		assert.Equal(t, int64(i+1), id)
+	}
+
+	_, err = recv(open(ctx, map[string]any{"after_id": -1}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = recv(open(ctx, map[string]any{"updated_since": "yesterday"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// fakeStats counts a day of usage, and refuses longer ranges than
+// auth.MaxStatsRange as the auth service does.
+type fakeStats struct{}
+
+func (fakeStats) Stats(_ context.Context, from, to time.Time) (models.Stats, error) {
+	if to.Sub(from) > auth.MaxStatsRange {
+		return models.Stats{}, errs.ErrRangeTooLarge
+	}
+
+	return models.Stats{From: from, To: to, TotalUsers: 10, Registrations: 2, SuccessfulLogins: 7, FailedLogins: 1, DailyActive: 3, WeeklyActive: 5}, nil
+}
+
+func TestAdminUserBatches(t *testing.T) {
+	storage := memory.New()
+	teacher, err := storage.SaveUser(t.Context(), "teacher@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	storage.SetUserRole(teacher, "teacher")
+	student, err := storage.SaveUser(t.Context(), "student@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+	users, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage)
+	require.NoError(t, err)
+	conn := serve(t, WithAdmin(fakeInfo{}), WithUserBatches(users))
+	request := func(ids ...int64) *structpb.Struct {
+		list := make([]any, len(ids))
+		for i, id := range ids {
+			list[i] = id
+		}
+		req, err := structpb.NewStruct(map[string]any{"user_ids": list})
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var resp structpb.Struct
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.UsersExistMethod, request(student, 999, teacher), &resp))
+	assert.Equal(t, []any{
+		map[string]any{"user_id": float64(student), "exists": true},
+		map[string]any{"user_id": float64(999), "exists": false},
+		map[string]any{"user_id": float64(teacher), "exists": true},
+	}, resp.AsMap()["users"])
+
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.UserRolesMethod, request(teacher, 999), &resp))
+	assert.Equal(t, []any{
+		map[string]any{"user_id": float64(teacher), "role": "teacher"},
+		map[string]any{"user_id": float64(999), "role": ""},
+	}, resp.AsMap()["users"])
+
+	tooMany := make([]int64, auth.MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = int64(i + 1)
+	}
+	for _, method := range []string{admingrpc.UsersExistMethod, admingrpc.UserRolesMethod} {
+		require.NoError(t, conn.Invoke(t.Context(), method, request(tooMany[:auth.MaxBatchSize]...), &resp), method)
+
+		err := conn.Invoke(t.Context(), method, request(tooMany...), &resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), method)
+		err = conn.Invoke(t.Context(), method, request(0), &resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), method)
+	}
+}
+
+func TestAdminSetUserTokenTTL(t *testing.T) {
+	storage := memory.New()
+	userID, err := storage.SaveUser(t.Context(), "kiosk@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	users, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage)
+	require.NoError(t, err)
+	conn := serve(t, WithAdmin(fakeInfo{}), WithTokenTTLOverrides(users))
+	request := func(fields map[string]any) *structpb.Struct {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var resp structpb.Struct
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.SetUserTokenTTLMethod,
+		request(map[string]any{"user_id": userID, "ttl_seconds": 28800}), &resp))
+	assert.Equal(t, float64(28800), resp.AsMap()["ttl_seconds"])
+	user, err := storage.UserByID(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Equal(t, 8*time.Hour, user.TokenTTL)
+
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.SetUserTokenTTLMethod,
+		request(map[string]any{"user_id": userID}), &resp))
+	user, err = storage.UserByID(t.Context(), userID)
+	require.NoError(t, err)
+	assert.Zero(t, user.TokenTTL)
+
+	err = conn.Invoke(t.Context(), admingrpc.SetUserTokenTTLMethod,
+		request(map[string]any{"user_id": 999, "ttl_seconds": 60}), &resp)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = conn.Invoke(t.Context(), admingrpc.SetUserTokenTTLMethod,
+		request(map[string]any{"user_id": userID, "ttl_seconds": -1}), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAdminCreateInvite(t *testing.T) {
+	storage := memory.New()
+	invites, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		auth.WithInvites(storage))
+	require.NoError(t, err)
+	conn := serve(t, WithAdmin(fakeInfo{}), WithInvites(invites))
+	request := func(fields map[string]any) *structpb.Struct {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var resp structpb.Struct
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.CreateInviteMethod,
+		request(map[string]any{"email": "student@example.com", "uses": 2, "ttl_seconds": 3600}), &resp))
+	fields := resp.AsMap()
+	assert.NotEmpty(t, fields["code"])
+	assert.Equal(t, "student@example.com", fields["email"])
+	assert.Equal(t, float64(2), fields["uses_remaining"])
+	assert.NotEmpty(t, fields["expires_at"])
+	assert.Positive(t, fields["id"])
+
+	// One use, no expiry by default.
+	require.NoError(t, conn.Invoke(t.Context(), admingrpc.CreateInviteMethod, request(map[string]any{}), &resp))
+	assert.Equal(t, float64(1), resp.AsMap()["uses_remaining"])
+	assert.NotContains(t, resp.AsMap(), "expires_at")
+
+	err = conn.Invoke(t.Context(), admingrpc.CreateInviteMethod, request(map[string]any{"uses": 0}), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Without invites the method is there but unimplemented.
+	conn = serve(t, WithAdmin(fakeInfo{}))
+	err = conn.Invoke(t.Context(), admingrpc.CreateInviteMethod, request(map[string]any{}), &resp)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestAdminGetStats(t *testing.T) {
+	conn := serve(t,
+		WithAdmin(fakeInfo{}),
+		WithStats(fakeStats{}),
+		WithPolicies(map[string]interceptors.Policy{admingrpc.GetStatsMethod: {}}, fakeAuthorizer{}),
+	)
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	stats := func(fields map[string]any) (map[string]any, error) {
+		t.Helper()
+
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+		var resp structpb.Struct
+		err = conn.Invoke(ctx, admingrpc.GetStatsMethod, req, &resp)
+
+		return resp.AsMap(), err
+	}
+
+	resp, err := stats(map[string]any{"from": "2024-03-01T00:00:00+03:00", "to": "2024-03-02T00:00:00Z"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"from":              "2024-02-29T21:00:00Z",
+		"to":                "2024-03-02T00:00:00Z",
+		"total_users":       float64(10),
+		"registrations":     float64(2),
+		"successful_logins": float64(7),
+		"failed_logins":     float64(1),
+		"daily_active":      float64(3),
+		"weekly_active":     float64(5),
+	}, resp)
+
+	_, err = stats(map[string]any{"from": "2020-01-01T00:00:00Z", "to": "2024-01-01T00:00:00Z"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	for _, bounds := range []map[string]any{
+		{"to": "2024-03-02T00:00:00Z"},
+		{"from": "2024-03-01", "to": "2024-03-02T00:00:00Z"},
+		{"from": "2024-03-01T00:00:00Z", "to": 1709337600},
+	} {
+		_, err = stats(bounds)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), bounds)
+	}
+}
+
+// fakeLinker lets users act on their own account only.
+type fakeLinker struct {
+	token *string
+}
+
+func (l fakeLinker) LinkIdentity(_ context.Context, token, provider, _ string) (models.LinkedIdentity, error) {
+	*l.token = token
+
+	return models.LinkedIdentity{Provider: provider, Subject: "g-1", LinkedAt: time.Unix(1700000000, 0)}, nil
+}
+
+func (fakeLinker) UnlinkIdentity(context.Context, int64, string) error {
+	return nil
+}
+
+func (fakeLinker) ListIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error) {
+	if caller, ok := authctx.CallerFromContext(ctx); !ok || caller.UserID != userID {
+		return nil, auth.ErrNotAdmin
+	}
+
+	return []models.LinkedIdentity{{Provider: "google", Subject: "g-1", LinkedAt: time.Unix(1700000000, 0)}}, nil
+}
+
+func TestIdentities(t *testing.T) {
+	var token string
+	conn := serve(t,
+		WithIdentities(fakeLinker{token: &token}),
+		WithPolicies(map[string]interceptors.Policy{
+			identitygrpc.LinkIdentityMethod:   {},
+			identitygrpc.UnlinkIdentityMethod: {},
+			identitygrpc.ListIdentitiesMethod: {},
+		}, fakeAuthorizer{}),
+	)
+	request := func(fields map[string]any) *structpb.Struct {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var list structpb.Struct
+	err := conn.Invoke(t.Context(), identitygrpc.ListIdentitiesMethod, request(nil), &list)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	require.NoError(t, conn.Invoke(ctx, identitygrpc.ListIdentitiesMethod, request(nil), &list))
+	assert.Equal(t, []any{map[string]any{
+		"provider":  "google",
+		"subject":   "g-1",
+		"email":     "",
+		"linked_at": "2023-11-14T22:13:20Z",
+	}}, list.AsMap()["identities"])
+
+	// The fake token belongs to user 0.
+	err = conn.Invoke(ctx, identitygrpc.ListIdentitiesMethod, request(map[string]any{"user_id": 7}), &list)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	for _, userID := range []any{-1, 0, 1.5, "7"} {
+		err = conn.Invoke(ctx, identitygrpc.ListIdentitiesMethod, request(map[string]any{"user_id": userID}), &list)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "user_id %v", userID)
+	}
+
+	var empty emptypb.Empty
+	err = conn.Invoke(ctx, identitygrpc.UnlinkIdentityMethod, request(nil), &empty)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.NoError(t, conn.Invoke(ctx, identitygrpc.UnlinkIdentityMethod, request(map[string]any{"provider": "google"}), &empty))
+
+	var identity structpb.Struct
+	err = conn.Invoke(ctx, identitygrpc.LinkIdentityMethod, request(map[string]any{"provider": "google"}), &identity)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.NoError(t, conn.Invoke(ctx, identitygrpc.LinkIdentityMethod,
+		request(map[string]any{"provider": "google", "code": "c"}), &identity))
+	assert.Equal(t, "google", identity.AsMap()["provider"])
+	assert.Equal(t, "good", token)
+}
+
+// fakeAuthorizations logs john@example.com in with the CAPTCHA solved
+// only.
+type fakeAuthorizations struct{}
+
+func (fakeAuthorizations) StartAuthorization(context.Context, int32, string, string) (string, error) {
+	return "authz-1", nil
+}
+
+func (fakeAuthorizations) LoginAuthorization(ctx context.Context, id, _, _ string) (string, error) {
+	if captcha.FromContext(ctx) != "solved" {
+		return "", errs.ErrCaptchaRequired
+	}
+
+	return "https://lms.example.com/callback?code=c&state=" + id, nil
+}
+
+func (fakeAuthorizations) ExchangeAuthorizationCode(_ context.Context, code string, _ int32, secret, _ string) (string, error) {
+	if secret != "lms-secret" {
+		return "", errs.ErrInvalidCredentials
+	}
+
+	return "token-" + code, nil
+}
+
+func TestAuthorizations(t *testing.T) {
+	conn := serve(t, WithAuthorizations(fakeAuthorizations{}))
+	request := func(fields map[string]any) *structpb.Struct {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var resp structpb.Struct
+	err := conn.Invoke(t.Context(), authorizationgrpc.StartAuthorizationMethod,
+		request(map[string]any{"app_id": 2}), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.NoError(t, conn.Invoke(t.Context(), authorizationgrpc.StartAuthorizationMethod,
+		request(map[string]any{"app_id": 2, "redirect_uri": "https://lms.example.com/callback"}), &resp))
+	assert.Equal(t, "authz-1", resp.AsMap()["authorization_id"])
+
+	login := map[string]any{"authorization_id": "authz-1", "email": "john@example.com", "password": "password"}
+	err = conn.Invoke(t.Context(), authorizationgrpc.LoginAuthorizationMethod, request(login), &resp)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	login["captcha_response"] = "solved"
+	require.NoError(t, conn.Invoke(t.Context(), authorizationgrpc.LoginAuthorizationMethod, request(login), &resp))
+	assert.Equal(t, "https://lms.example.com/callback?code=c&state=authz-1", resp.AsMap()["redirect_uri"])
+
+	exchange := map[string]any{"code": "c", "app_id": 2, "app_secret": "wrong", "redirect_uri": "https://lms.example.com/callback"}
+	err = conn.Invoke(t.Context(), authorizationgrpc.ExchangeAuthorizationCodeMethod, request(exchange), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	exchange["app_secret"] = "lms-secret"
+	require.NoError(t, conn.Invoke(t.Context(), authorizationgrpc.ExchangeAuthorizationCodeMethod, request(exchange), &resp))
+	assert.Equal(t, "token-c", resp.AsMap()["token"])
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(receiver.Close)
+
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 2, Name: "lms", Secret: "lms-secret"})
+	service, err := webhooks.New(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage)
+	require.NoError(t, err)
+	conn := serve(t, WithWebhooks(service))
+	request := func(fields map[string]any) *structpb.Struct {
+		req, err := structpb.NewStruct(fields)
+		require.NoError(t, err)
+
+		return req
+	}
+
+	var resp structpb.Struct
+	err = conn.Invoke(t.Context(), webhookgrpc.CreateWebhookMethod,
+		request(map[string]any{"app_id": 2, "url": receiver.URL, "event_types": []any{"no.such.event"}}), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.NoError(t, conn.Invoke(t.Context(), webhookgrpc.CreateWebhookMethod,
+		request(map[string]any{"app_id": 2, "url": receiver.URL, "event_types": []any{models.EventUserRegistered}}), &resp))
+	created := resp.AsMap()
+	assert.NotEmpty(t, created["secret"])
+	assert.Equal(t, []any{models.EventUserRegistered}, created["event_types"])
+	id := created["id"]
+
+	require.NoError(t, conn.Invoke(t.Context(), webhookgrpc.ListWebhooksMethod, request(map[string]any{"app_id": 2}), &resp))
+	list := resp.AsMap()["webhooks"].([]any)
+	require.Len(t, list, 1)
+	assert.Equal(t, id, list[0].(map[string]any)["id"])
+	assert.NotContains(t, list[0], "secret")
+	assert.Equal(t, true, list[0].(map[string]any)["active"])
+
+	require.NoError(t, conn.Invoke(t.Context(), webhookgrpc.TestWebhookMethod, request(map[string]any{"id": id}), &resp))
+	assert.Equal(t, float64(http.StatusAccepted), resp.AsMap()["status"])
+
+	require.NoError(t, conn.Invoke(t.Context(), webhookgrpc.DeleteWebhookMethod, request(map[string]any{"id": id}), &resp))
+	err = conn.Invoke(t.Context(), webhookgrpc.TestWebhookMethod, request(map[string]any{"id": id}), &resp)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	err = conn.Invoke(t.Context(), webhookgrpc.DeleteWebhookMethod, request(map[string]any{"id": "1"}), &resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestListeners(t *testing.T) {
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+		WithListen("tcp://:44044", "unix:///var/run/sso.sock"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp://:44044", "unix:///var/run/sso.sock"}, a.Listeners())
+}
+
+func TestHealth(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	probe := health.New(log, clock.NewFake(time.Now()), time.Minute,
+		health.Check{Name: "storage", Run: func(context.Context) error { return nil }},
+	)
+	client := healthpb.NewHealthClient(serve(t, WithHealth(probe)))
+
+	statusOf := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+
+		resp, err := client.Check(t.Context(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+
+		return resp.GetStatus()
+	}
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(health.Liveness))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(health.Readiness))
+
+	// Live, but nothing is checked yet.
+	probe.SetLive(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(health.Liveness))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(health.Readiness))
+
+	require.NoError(t, probe.Run(t.Context()))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(health.Readiness))
+
+	probe.Drain()
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(health.Liveness))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(health.Readiness))
+}
+
+func serveAdmin(t *testing.T, opts ...Option) *grpc.ClientConn {
+	t.Helper()
+
+	a, err := NewAdminServer(slog.New(slog.NewTextHandler(io.Discard, nil)), interceptors.Policy{Role: auth.AdminRole}, opts...)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = a.gRPCServer.Serve(l) }()
+	t.Cleanup(a.gRPCServer.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+type adminAuthorizer struct{ fakeValidator }
+
+func (adminAuthorizer) UserRole(context.Context, int64) (string, error) {
+	return auth.AdminRole, nil
+}
+
+func TestNewAdminServer(t *testing.T) {
+	good := metadata.AppendToOutgoingContext(t.Context(), "authorization", "Bearer good")
+	var info structpb.Struct
+
+	// No policy is given, yet the method is not public.
+	conn := serveAdmin(t, WithAdmin(fakeInfo{}), WithReflection(true), WithPolicies(nil, fakeAuthorizer{}))
+	err := conn.Invoke(t.Context(), admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	err = conn.Invoke(good, admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The Auth service stays on the public server.
+	_, err = ssov1.NewAuthClient(conn).Login(good, &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: 1})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// Reflection needs no token.
+	names, err := listServices(t, conn)
+	require.NoError(t, err)
+	assert.Contains(t, names, "sso.admin.v1.Admin")
+	assert.NotContains(t, names, "auth.Auth")
+
+	conn = serveAdmin(t, WithAdmin(fakeInfo{}), WithPolicies(nil, adminAuthorizer{}))
+	require.NoError(t, conn.Invoke(good, admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info))
+	assert.Equal(t, "v1.2.0", info.AsMap()["version"])
+
+	// Policies given are kept, by full or bare name.
+	for _, method := range []string{admingrpc.GetServerInfoMethod, "GetServerInfo"} {
+		conn = serveAdmin(t, WithAdmin(fakeInfo{}), WithPolicies(map[string]interceptors.Policy{method: {}}, fakeAuthorizer{}))
+		assert.NoError(t, conn.Invoke(good, admingrpc.GetServerInfoMethod, &emptypb.Empty{}, &info), method)
+	}
+}
+
+func TestNewAdminServer_MissingDependencies(t *testing.T) {
+	policy := interceptors.Policy{Role: auth.AdminRole}
+
+	_, err := NewAdminServer(nil, policy, WithPolicies(nil, fakeAuthorizer{}))
+	assert.ErrorContains(t, err, "logger is required")
+
+	_, err = NewAdminServer(slog.New(slog.NewTextHandler(io.Discard, nil)), policy, WithAdmin(fakeInfo{}))
+	assert.ErrorContains(t, err, "authorizer is required")
+}
+
+func TestAPIVersionHeaders(t *testing.T) {
+	conn := serve(t, WithDebug(fakeValidator{}))
+	oldest, newest := strconv.Itoa(apiversion.Min), strconv.Itoa(apiversion.Max)
+
+	var header metadata.MD
+	err := conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &structpb.Struct{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest}, header.Get(interceptors.APIVersionHeader))
+	assert.Equal(t, []string{oldest}, header.Get(interceptors.APIVersionMinHeader))
+	assert.Equal(t, []string{newest}, header.Get(interceptors.APIVersionMaxHeader))
+
+	// A rejected call still tells the versions supported.
+	header = nil
+	ctx := metadata.AppendToOutgoingContext(t.Context(), interceptors.APIVersionHeader, strconv.Itoa(apiversion.Max+1))
+	err = conn.Invoke(ctx, "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &structpb.Struct{}, grpc.Header(&header))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, []string{newest}, header.Get(interceptors.APIVersionMaxHeader))
+	assert.Empty(t, header.Get(interceptors.APIVersionHeader))
+}
+
+func TestNewServer_MissingDependencies(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := NewServer(nil, stubAuth{})
+	assert.ErrorContains(t, err, "logger is required")
+
+	_, err = NewServer(log, nil)
+	assert.ErrorContains(t, err, "auth service is required")
+
+	_, err = NewServer(log, (*stubAuth)(nil))
+	assert.ErrorContains(t, err, "auth service is required")
+
+	_, err = NewServer(log, stubAuth{}, WithRoleAssignment((*fakeRoleAssigner)(nil)))
+	assert.ErrorContains(t, err, "role assigner is a nil *grpcapp.fakeRoleAssigner")
+
+	_, err = NewServer(log, stubAuth{}, WithPolicies(map[string]interceptors.Policy{"DeleteApp": {}}, nil))
+	assert.ErrorContains(t, err, "authorizer is nil")
+
+	_, err = NewServer(log, stubAuth{}, WithAppCredentials([]string{"Register"}, nil, nil))
+	assert.ErrorContains(t, err, "apps is nil")
+
+	_, err = NewServer(log, stubAuth{}, WithNonces([]string{"Login"}, nil, time.Minute))
+	assert.ErrorContains(t, err, "store or ttl is missing")
+
+	_, err = NewServer(log, stubAuth{}, WithTrustedProxies("not-a-cidr"))
+	assert.Error(t, err)
+}
+
+func TestNonces(t *testing.T) {
+	conn := serve(t, WithNonces([]string{"UserExists"}, nonce.NewMemory(10, 10, clock.NewFake(time.Now())), time.Minute))
+	client := ssov1.NewAuthClient(conn)
+
+	// Validation rejects the request, so a call that got past the nonce
+	// check is InvalidArgument.
+	ctx := metadata.AppendToOutgoingContext(t.Context(), interceptors.NonceHeader, "n1")
+	_, err := client.UserExists(ctx, &ssov1.UserExistsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.UserExists(ctx, &ssov1.UserExistsRequest{})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
+
+// idAuth records the IDs that got past validation.
+type idAuth struct {
+	authgrpc.Auth
+	appID  *int32
+	userID *int64
+}
+
+func (a idAuth) ResolveApp(_ context.Context, appID int32, _ string) (int32, error) {
+	return appID, nil
+}
+
+func (a idAuth) Login(_ context.Context, _, _ string, appID int32) (string, error) {
+	*a.appID = appID
+
+	return "token", nil
+}
+
+func (a idAuth) RegisterNewUser(_ context.Context, _, _, _, _, _, _, _ string, appID int32) (models.NewUser, error) {
+	*a.appID = appID
+
+	return models.NewUser{User: models.User{ID: 1}}, nil
+}
+
+func (a idAuth) UserRole(_ context.Context, userID int64) (string, error) {
+	*a.userID = userID
+
+	return "", nil
+}
+
+func (a idAuth) UserExists(_ context.Context, userID int64) (bool, error) {
+	*a.userID = userID
+
+	return false, nil
+}
+
+// longNameAuth refuses every name as too long, as the auth service does
+// names past auth.MaxNameRunes.
+type longNameAuth struct{ authgrpc.Auth }
+
+func (longNameAuth) RegisterNewUser(context.Context, string, string, string, string, string, string, string, int32) (models.NewUser, error) {
+	return models.NewUser{}, errs.Wrap("auth.RegisterNewUser", errs.New(errs.InvalidArgument, "first_name must be at most 100 characters"))
+}
+
+func TestInvalidArgumentMessage(t *testing.T) {
+	client := ssov1.NewAuthClient(serveAuth(t, longNameAuth{}))
+	req := &ssov1.RegisterRequest{Email: "user@example.com", Password: "password", FirstName: "John", LastName: "Doe"}
+
+	// The client learns which field is wrong, in whatever language it
+	// asks for.
+	for _, lang := range []string{"", "ru"} {
+		ctx := metadata.AppendToOutgoingContext(t.Context(), "accept-language", lang)
+		_, err := client.Register(ctx, req)
+		st := status.Convert(err)
+		assert.Equal(t, codes.InvalidArgument, st.Code(), lang)
+		assert.Equal(t, "first_name must be at most 100 characters", st.Message(), lang)
+	}
+}
+
+func TestIDBounds(t *testing.T) {
+	var appID int32
+	var userID int64
+	client := ssov1.NewAuthClient(serveAuth(t, idAuth{appID: &appID, userID: &userID}))
+	ctx := t.Context()
+
+	for _, id := range []int32{-1, 0} {
+		_, err := client.Login(ctx, &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: id})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "app_id %d", id)
+	}
+	for _, id := range []int64{-1, 0} {
+		_, err := client.UserRole(ctx, &ssov1.UserRoleRequest{UserId: id})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "user_id %d", id)
+		_, err = client.UserExists(ctx, &ssov1.UserExistsRequest{UserId: id})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "user_id %d", id)
+	}
+
+	_, err := client.Login(ctx, &ssov1.LoginRequest{Email: "user@example.com", Password: "password", AppId: math.MaxInt32})
+	require.NoError(t, err)
+	assert.Equal(t, int32(math.MaxInt32), appID)
+	_, err = client.UserRole(ctx, &ssov1.UserRoleRequest{UserId: math.MaxInt32})
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt32), userID)
+	_, err = client.UserExists(ctx, &ssov1.UserExistsRequest{UserId: math.MaxInt64})
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), userID)
+}
+
+func TestRegisterApp(t *testing.T) {
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 1, Name: "web", Secret: "web-secret"})
+	storage.SaveApp(models.App{ID: 2, Name: "mobile", Secret: "mobile-secret"})
+	var appID int32
+	auth := idAuth{appID: &appID}
+	req := &ssov1.RegisterRequest{Email: "user@example.com", Password: "password", FirstName: "John", LastName: "Doe"}
+
+	// A bare x-app-id header attributes nothing, so it cannot use up the
+	// user quota of another app.
+	client := ssov1.NewAuthClient(serveAuth(t, auth))
+	ctx := metadata.AppendToOutgoingContext(t.Context(), interceptors.AppIDHeader, "2")
+	_, err := client.Register(ctx, req)
+	require.NoError(t, err)
+	assert.Zero(t, appID)
+
+	// App credentials do.
+	client = ssov1.NewAuthClient(serveAuth(t, auth, WithAppCredentials([]string{"Register"}, storage, nil)))
+	ctx = metadata.AppendToOutgoingContext(t.Context(),
+		interceptors.AppIDHeader, "1", interceptors.AppSecretHeader, "web-secret")
+	_, err = client.Register(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), appID)
+}
+
+func TestNewServer_FullyLoaded(t *testing.T) {
+	var intercepted []string
+	record := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		intercepted = append(intercepted, info.FullMethod)
+		return handler(ctx, req)
+	}
+
+	conn := serve(t,
+		WithPort(0),
+		WithTimeouts(map[string]time.Duration{"WhoAmI": time.Second}, 5*time.Second),
+		WithKeepalive(keepalive.EnforcementPolicy{MinTime: time.Minute}, keepalive.ServerParameters{}),
+		WithMaxConcurrentStreams(10),
+		WithMaxHeaderListSize(8<<10),
+		WithMaxConnections(10),
+		WithBindRetries(1, time.Millisecond),
+		WithReflection(true),
+		WithDebug(fakeValidator{}),
+		WithPolicies(map[string]interceptors.Policy{"DeleteApp": {}}, fakeAuthorizer{}),
+		WithTrustedProxies("10.0.0.0/8"),
+		WithInterceptors(record),
+	)
+
+	var claims structpb.Struct
+	err := conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &claims)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/sso.debug.v1.Debug/WhoAmI"}, intercepted)
+}
+
+type fakeAuthorizer struct{ fakeValidator }
+
+func (fakeAuthorizer) UserRole(context.Context, int64) (string, error) {
+	return "", nil
+}
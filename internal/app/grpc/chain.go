@@ -0,0 +1,155 @@
+package grpcapp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sso/internal/grpc/interceptors"
+
+	"google.golang.org/grpc"
+)
+
+// Names of the built-in interceptors, as listed by WithChain.
+const (
+	ClientIPInterceptor       = "client_ip"
+	ClientCertInterceptor     = "client_cert"
+	AuditContextInterceptor   = "audit_context"
+	APIVersionInterceptor     = "api_version"
+	LocalizeInterceptor       = "localize"
+	VerboseErrorsInterceptor  = "verbose_errors"
+	PayloadLoggingInterceptor = "payload_logging"
+	UnknownFieldsInterceptor  = "unknown_fields"
+	RetryBudgetInterceptor    = "retry_budget"
+	RequestCacheInterceptor   = "request_cache"
+	DeadlineInterceptor       = "deadline"
+	AppCredentialsInterceptor = "app_credentials"
+	AuthorizeInterceptor      = "authorize"
+	NoncesInterceptor         = "nonces"
+)
+
+// DefaultChain is the order of the built-in interceptors, outermost
+// first, when WithChain is not given.
+//
+// Payloads are logged before anything can reject the call, so that
+// failing requests are visible too. Errors of every interceptor after
+// Localize are translated.
+var DefaultChain = []string{
+	ClientIPInterceptor,
+	ClientCertInterceptor,
+	AuditContextInterceptor,
+	APIVersionInterceptor,
+	LocalizeInterceptor,
+	VerboseErrorsInterceptor,
+	PayloadLoggingInterceptor,
+	UnknownFieldsInterceptor,
+	RetryBudgetInterceptor,
+	RequestCacheInterceptor,
+	DeadlineInterceptor,
+	AppCredentialsInterceptor,
+	AuthorizeInterceptor,
+	NoncesInterceptor,
+}
+
+// requiredInterceptors may not be left out of a chain, by why: without
+// them, methods would be served that must not be.
+var requiredInterceptors = map[string]string{
+	ClientIPInterceptor:       "the client IP is logged and audited by everything after it",
+	DeadlineInterceptor:       "handlers would run unbounded",
+	AppCredentialsInterceptor: "methods requiring app credentials would be open",
+	AuthorizeInterceptor:      "methods with a policy would be open",
+	NoncesInterceptor:         "methods requiring a nonce could be replayed",
+}
+
+// unaryOnly are the interceptors streams go without, by why. The others
+// run on streams as well, see interceptors.Stream.
+var unaryOnly = map[string]string{
+	PayloadLoggingInterceptor: "the request is read after the interceptors ran",
+	UnknownFieldsInterceptor:  "the request is read after the interceptors ran",
+	// Streams end with the deadline of the client instead.
+	DeadlineInterceptor: "it returns on timeout while the handler may still send",
+}
+
+// chainOrder lists the interceptors that must run before others, by why:
+// mostly, the later ones read what the earlier ones put in the context.
+var chainOrder = []struct {
+	before, after, why string
+}{
+	{ClientIPInterceptor, AuditContextInterceptor, "the audit context records the client IP"},
+	{ClientIPInterceptor, AuthorizeInterceptor, "the authorizer logs the client IP"},
+	{ClientCertInterceptor, AuthorizeInterceptor, "tokens bound to a certificate are checked against it"},
+	{AuditContextInterceptor, VerboseErrorsInterceptor, "verbose errors carry the request ID of the audit context"},
+	{RequestCacheInterceptor, AuthorizeInterceptor, "the role Authorize checks is kept for the handler"},
+	{AppCredentialsInterceptor, AuthorizeInterceptor, "tokens are checked against the app of the credentials"},
+	{AuthorizeInterceptor, NoncesInterceptor, "only calls that would otherwise be served spend their nonce"},
+	{ClientIPInterceptor, NoncesInterceptor, "nonces are counted by the client IP"},
+}
+
+// validateChain reports the names of chain that are unknown or repeated,
+// the required interceptors it leaves out and those it misorders.
+func validateChain(chain []string) error {
+	position := make(map[string]int, len(chain))
+	for i, name := range chain {
+		if !slices.Contains(DefaultChain, name) {
+			return fmt.Errorf("unknown interceptor %q, expected one of %s", name, strings.Join(DefaultChain, ", "))
+		}
+		if _, ok := position[name]; ok {
+			return fmt.Errorf("interceptor %q is listed twice", name)
+		}
+		position[name] = i
+	}
+
+	// In the order of DefaultChain, so the error is the same every time.
+	for _, name := range DefaultChain {
+		if why, ok := requiredInterceptors[name]; ok {
+			if _, listed := position[name]; !listed {
+				return fmt.Errorf("interceptor %q is required: without it %s", name, why)
+			}
+		}
+	}
+
+	for _, rule := range chainOrder {
+		before, ok := position[rule.before]
+		if !ok {
+			continue
+		}
+		after, ok := position[rule.after]
+		if !ok {
+			continue
+		}
+		if before > after {
+			return fmt.Errorf("interceptor %q must run before %q: %s", rule.before, rule.after, rule.why)
+		}
+	}
+
+	return nil
+}
+
+// orderChain returns the interceptors of chain from builtin, in order,
+// leaving out those builtin has none for as their feature is off.
+func orderChain(chain []string, builtin map[string]grpc.UnaryServerInterceptor) []grpc.UnaryServerInterceptor {
+	ordered := make([]grpc.UnaryServerInterceptor, 0, len(chain))
+	for _, name := range chain {
+		if interceptor := builtin[name]; interceptor != nil {
+			ordered = append(ordered, interceptor)
+		}
+	}
+
+	return ordered
+}
+
+// orderStreamChain is orderChain for streams, leaving out the unaryOnly
+// interceptors as well.
+func orderStreamChain(chain []string, builtin map[string]grpc.UnaryServerInterceptor) []grpc.StreamServerInterceptor {
+	ordered := make([]grpc.StreamServerInterceptor, 0, len(chain))
+	for _, name := range chain {
+		if _, ok := unaryOnly[name]; ok {
+			continue
+		}
+		if interceptor := builtin[name]; interceptor != nil {
+			ordered = append(ordered, interceptors.Stream(interceptor))
+		}
+	}
+
+	return ordered
+}
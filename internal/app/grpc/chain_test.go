@@ -0,0 +1,178 @@
+package grpcapp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"testing"
+
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/apiversion"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// recording returns an interceptor appending name to calls on the way in.
+func recording(name string, calls *[]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		*calls = append(*calls, name)
+
+		return handler(ctx, req)
+	}
+}
+
+// run calls chain around a handler doing nothing, as grpc would.
+func run(t *testing.T, chain []grpc.UnaryServerInterceptor) {
+	t.Helper()
+
+	handler := grpc.UnaryHandler(func(context.Context, any) (any, error) { return nil, nil })
+	for _, interceptor := range slices.Backward(chain) {
+		next := handler
+		handler = func(ctx context.Context, req any) (any, error) {
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{}, next)
+		}
+	}
+	_, err := handler(t.Context(), nil)
+	require.NoError(t, err)
+}
+
+func TestOrderChain(t *testing.T) {
+	var calls []string
+	builtin := make(map[string]grpc.UnaryServerInterceptor)
+	for _, name := range DefaultChain {
+		builtin[name] = recording(name, &calls)
+	}
+	// Its feature is off.
+	delete(builtin, RetryBudgetInterceptor)
+
+	run(t, orderChain(DefaultChain, builtin))
+	assert.Equal(t, slices.DeleteFunc(slices.Clone(DefaultChain), func(name string) bool {
+		return name == RetryBudgetInterceptor
+	}), calls)
+
+	calls = nil
+	chain := []string{
+		ClientIPInterceptor,
+		DeadlineInterceptor,
+		PayloadLoggingInterceptor,
+		AppCredentialsInterceptor,
+		AuthorizeInterceptor,
+		NoncesInterceptor,
+		LocalizeInterceptor,
+	}
+	require.NoError(t, validateChain(chain))
+	run(t, orderChain(chain, builtin))
+	assert.Equal(t, chain, calls)
+}
+
+func TestOrderStreamChain(t *testing.T) {
+	var calls []string
+	builtin := make(map[string]grpc.UnaryServerInterceptor)
+	for _, name := range DefaultChain {
+		builtin[name] = recording(name, &calls)
+	}
+
+	handler := grpc.StreamHandler(func(any, grpc.ServerStream) error { return nil })
+	for _, interceptor := range slices.Backward(orderStreamChain(DefaultChain, builtin)) {
+		next := handler
+		handler = func(srv any, ss grpc.ServerStream) error {
+			return interceptor(srv, ss, &grpc.StreamServerInfo{}, next)
+		}
+	}
+	require.NoError(t, handler(nil, contextStream{ctx: t.Context()}))
+
+	assert.Equal(t, slices.DeleteFunc(slices.Clone(DefaultChain), func(name string) bool {
+		_, ok := unaryOnly[name]
+		return ok
+	}), calls)
+	assert.Contains(t, calls, AuthorizeInterceptor)
+}
+
+// contextStream is a stream with a context only.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestValidateChain(t *testing.T) {
+	assert.NoError(t, validateChain(DefaultChain))
+
+	without := func(name string) []string {
+		return slices.DeleteFunc(slices.Clone(DefaultChain), func(n string) bool { return n == name })
+	}
+	swapped := func(a, b string) []string {
+		chain := slices.Clone(DefaultChain)
+		i, j := slices.Index(chain, a), slices.Index(chain, b)
+		chain[i], chain[j] = chain[j], chain[i]
+
+		return chain
+	}
+
+	tests := []struct {
+		name  string
+		chain []string
+		want  string
+	}{
+		{"unknown", append(slices.Clone(DefaultChain), "recovery"), `unknown interceptor "recovery"`},
+		{"twice", append(slices.Clone(DefaultChain), LocalizeInterceptor), `"localize" is listed twice`},
+		{"authorize left out", without(AuthorizeInterceptor), `"authorize" is required`},
+		{"nonces left out", without(NoncesInterceptor), `"nonces" is required`},
+		{
+			"nonces before authorize",
+			swapped(AuthorizeInterceptor, NoncesInterceptor),
+			`"authorize" must run before "nonces"`,
+		},
+		{
+			"audit before client ip",
+			swapped(ClientIPInterceptor, AuditContextInterceptor),
+			`"client_ip" must run before "audit_context"`,
+		},
+		{
+			"request cache after authorize",
+			swapped(RequestCacheInterceptor, AuthorizeInterceptor),
+			`"request_cache" must run before "authorize"`,
+		},
+		{
+			"app credentials after authorize",
+			swapped(AppCredentialsInterceptor, AuthorizeInterceptor),
+			`"app_credentials" must run before "authorize"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorContains(t, validateChain(tt.chain), tt.want)
+		})
+	}
+
+	// Optional ones may go, and those they depend on with them.
+	assert.NoError(t, validateChain(without(VerboseErrorsInterceptor)))
+	assert.NoError(t, validateChain(without(AuditContextInterceptor)))
+}
+
+func TestWithChain(t *testing.T) {
+	_, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+		WithChain(AuthorizeInterceptor, ClientIPInterceptor, DeadlineInterceptor, AppCredentialsInterceptor, NoncesInterceptor),
+	)
+	assert.ErrorContains(t, err, `"client_ip" must run before "authorize"`)
+
+	// Without api_version, a client ahead of the server is served.
+	conn := serve(t, WithDebug(fakeValidator{}), WithChain(slices.DeleteFunc(slices.Clone(DefaultChain), func(name string) bool {
+		return name == APIVersionInterceptor
+	})...))
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(t.Context(), interceptors.APIVersionHeader, strconv.Itoa(apiversion.Max+1))
+	err = conn.Invoke(ctx, "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &structpb.Struct{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, header.Get(interceptors.APIVersionMaxHeader))
+}
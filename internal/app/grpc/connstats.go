@@ -0,0 +1,33 @@
+package grpcapp
+
+import (
+	"context"
+
+	"sso/internal/lib/metrics"
+
+	"google.golang.org/grpc/stats"
+)
+
+// connStats is a stats handler tracking the number of open connections.
+type connStats struct {
+	conns *metrics.Gauge
+}
+
+func (h *connStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *connStats) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		h.conns.Inc()
+	case *stats.ConnEnd:
+		h.conns.Dec()
+	}
+}
+
+func (h *connStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *connStats) HandleRPC(context.Context, stats.RPCStats) {}
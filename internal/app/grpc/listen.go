@@ -0,0 +1,99 @@
+package grpcapp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	schemeTCP  = "tcp://"
+	schemeUnix = "unix://"
+
+	defaultSocketMode os.FileMode = 0o660
+)
+
+// listenSpec is a parsed listener address such as "tcp://:44044" or
+// "unix:///var/run/sso.sock". An address without a scheme is TCP.
+type listenSpec struct {
+	network string
+	address string
+}
+
+func parseListenSpec(spec string) (listenSpec, error) {
+	switch {
+	case strings.HasPrefix(spec, schemeUnix):
+		path := strings.TrimPrefix(spec, schemeUnix)
+		if path == "" {
+			return listenSpec{}, fmt.Errorf("listener %q: empty socket path", spec)
+		}
+
+		return listenSpec{network: "unix", address: path}, nil
+	case strings.HasPrefix(spec, schemeTCP):
+		return listenSpec{network: "tcp", address: strings.TrimPrefix(spec, schemeTCP)}, nil
+	case strings.Contains(spec, "://"):
+		return listenSpec{}, fmt.Errorf("listener %q: unsupported scheme", spec)
+	default:
+		return listenSpec{network: "tcp", address: spec}, nil
+	}
+}
+
+func (s listenSpec) String() string {
+	return s.network + "://" + s.address
+}
+
+// listen opens the listener. For unix sockets a stale socket file left by
+// a previous run is removed first and the new one gets mode.
+func (s listenSpec) listen(mode os.FileMode) (net.Listener, error) {
+	if s.network != "unix" {
+		return net.Listen(s.network, s.address)
+	}
+
+	if err := removeStaleSocket(s.address); err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", s.address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server removes the socket file itself on Stop.
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+
+	if err := os.Chmod(s.address, mode); err != nil {
+		_ = l.Close()
+
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return l, nil
+}
+
+// removeStaleSocket removes path if it is a socket nobody listens on.
+// Regular files are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
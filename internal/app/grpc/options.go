@@ -0,0 +1,401 @@
+package grpcapp
+
+import (
+	"os"
+	"time"
+
+	activationgrpc "sso/internal/grpc/activation"
+	admingrpc "sso/internal/grpc/admin"
+	authorizationgrpc "sso/internal/grpc/authorization"
+	challengegrpc "sso/internal/grpc/challenge"
+	debuggrpc "sso/internal/grpc/debug"
+	identitygrpc "sso/internal/grpc/identity"
+	"sso/internal/grpc/interceptors"
+	orgunitgrpc "sso/internal/grpc/orgunit"
+	permissiongrpc "sso/internal/grpc/permission"
+	quotagrpc "sso/internal/grpc/quota"
+	sessiongrpc "sso/internal/grpc/session"
+	webhookgrpc "sso/internal/grpc/webhook"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/health"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// settings are collected from the options of NewServer.
+type settings struct {
+	Options
+	port           int
+	payloadLogging bool
+	verboseErrors  bool
+	unknownFields  interceptors.UnknownFieldsMode
+	retryBudget    int
+	tls            *tlsFiles
+	appMethods     map[string]bool
+	apps           interceptors.AppProvider
+	appClock       clock.Clock
+	nonceMethods   map[string]bool
+	cachedMethods  map[string]bool
+	nonces         interceptors.NonceStore
+	nonceTTL       time.Duration
+	health         *health.Probe
+	session        sessiongrpc.Identifier
+	terms          sessiongrpc.TermsAcceptor
+	deletions      sessiongrpc.Deleter
+	admin          admingrpc.InfoProvider
+	maintainer     admingrpc.Maintainer
+	failedTasks    admingrpc.TaskLister
+	tokenLookup    admingrpc.TokenLookup
+	roleAssigner   admingrpc.RoleAssigner
+	userLister     admingrpc.UserLister
+	stats          admingrpc.StatsProvider
+	userStreamer   admingrpc.UserStreamer
+	userBatch      admingrpc.UserBatch
+	tokenTTLs      admingrpc.TokenTTLSetter
+	invites        admingrpc.InviteCreator
+	identities     identitygrpc.Linker
+	challenges     challengegrpc.Completer
+	permissions    permissiongrpc.Manager
+	orgUnits       orgunitgrpc.Manager
+	activations    activationgrpc.Activator
+	authorizations authorizationgrpc.Authorizer
+	quotas         quotagrpc.Quotas
+	appUsage       quotagrpc.UsageReader
+	webhooks       webhookgrpc.Manager
+	decisions      *interceptors.DecisionLog
+	interceptors   []grpc.UnaryServerInterceptor
+	chain          []string
+}
+
+// Option configures the gRPC server, see NewServer.
+type Option func(*settings)
+
+// WithPort listens on TCP port when WithListen is not given.
+func WithPort(port int) Option {
+	return func(s *settings) { s.port = port }
+}
+
+// WithListen sets listener specs like "tcp://:44044" or
+// "unix:///var/run/sso.sock", replacing WithPort.
+func WithListen(specs ...string) Option {
+	return func(s *settings) { s.Listen = specs }
+}
+
+// WithSocketMode sets the permission of unix socket files. Default 0660.
+func WithSocketMode(mode os.FileMode) Option {
+	return func(s *settings) { s.SocketMode = mode }
+}
+
+// WithTimeouts bounds handlers per method, keyed by full or bare method
+// name. Methods without an entry use defaultTimeout.
+func WithTimeouts(methods map[string]time.Duration, defaultTimeout time.Duration) Option {
+	return func(s *settings) {
+		s.MethodTimeouts = methods
+		s.DefaultTimeout = defaultTimeout
+	}
+}
+
+// WithKeepalive sets how often clients may ping and when idle and aged
+// connections are closed.
+func WithKeepalive(policy keepalive.EnforcementPolicy, params keepalive.ServerParameters) Option {
+	return func(s *settings) {
+		s.EnforcementPolicy = &policy
+		s.ServerParameters = &params
+	}
+}
+
+// WithMaxConcurrentStreams caps streams per connection. Default 100.
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(s *settings) { s.MaxConcurrentStreams = n }
+}
+
+// WithMaxHeaderListSize caps the size of request headers. Default 16KiB.
+func WithMaxHeaderListSize(n uint32) Option {
+	return func(s *settings) { s.MaxHeaderListSize = n }
+}
+
+// WithMaxConnections caps simultaneously accepted connections per
+// listener. Zero means no cap.
+func WithMaxConnections(n int) Option {
+	return func(s *settings) { s.MaxConnections = n }
+}
+
+// WithBindRetries retries listening while the address is still in use,
+// starting with backoff and doubling it on each attempt.
+func WithBindRetries(retries int, backoff time.Duration) Option {
+	return func(s *settings) {
+		s.BindRetries = retries
+		s.BindBackoff = backoff
+	}
+}
+
+// WithReflection registers the server reflection service when enabled.
+func WithReflection(enabled bool) Option {
+	return func(s *settings) { s.Reflection = enabled }
+}
+
+// WithDebug registers the sso.debug.v1.Debug service backed by validator.
+// It is meant for local mode only.
+func WithDebug(validator debuggrpc.TokenValidator) Option {
+	return func(s *settings) { s.Debug = validator }
+}
+
+// WithPolicies requires tokens for the methods in policies, keyed by full
+// or bare method name, checked by authorizer.
+func WithPolicies(policies map[string]interceptors.Policy, authorizer interceptors.Authorizer) Option {
+	return func(s *settings) {
+		s.Policies = policies
+		s.Authorizer = authorizer
+	}
+}
+
+// WithTrustedProxies lists the CIDRs of reverse proxies whose forwarding
+// headers tell the client IP.
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(s *settings) { s.TrustedProxies = cidrs }
+}
+
+// WithPayloadLogging logs every request and response at debug level with
+// sensitive fields masked, see interceptors.PayloadLogger. It is meant for
+// local mode only.
+func WithPayloadLogging(enabled bool) Option {
+	return func(s *settings) { s.payloadLogging = enabled }
+}
+
+// WithTLS serves TLS with the certificate and key of the PEM files. With
+// clientCAFile, clients must present a certificate signed by one of its
+// CAs. The files are read by NewServer.
+func WithTLS(certFile, keyFile, clientCAFile string) Option {
+	return func(s *settings) {
+		s.tls = &tlsFiles{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	}
+}
+
+// WithVerboseErrors adds the request ID to every error and the op labels
+// of their cause to internal ones, see interceptors.VerboseErrors. It is
+// meant for staging.
+func WithVerboseErrors(enabled bool) Option {
+	return func(s *settings) { s.verboseErrors = enabled }
+}
+
+// WithUnknownFields warns of or rejects the requests with fields the
+// server does not know, see interceptors.UnknownFields. Off by default.
+func WithUnknownFields(mode interceptors.UnknownFieldsMode) Option {
+	return func(s *settings) { s.unknownFields = mode }
+}
+
+// WithRetryBudget allows every call n retries of failed dependency calls
+// in all, see interceptors.RetryBudget. Zero leaves them uncapped.
+func WithRetryBudget(n int) Option {
+	return func(s *settings) { s.retryBudget = n }
+}
+
+// WithDecisionLog logs every decision of the policy interceptor and
+// explains denials to the callers that ask, see interceptors.DecisionLog.
+// nil logs nothing.
+func WithDecisionLog(decisions *interceptors.DecisionLog) Option {
+	return func(s *settings) { s.decisions = decisions }
+}
+
+// WithAppCredentials requires the credentials of a registered app client
+// for methods, given by full or bare name, checked against apps and cached
+// by clk. See interceptors.AppCredentials.
+func WithAppCredentials(methods []string, apps interceptors.AppProvider, clk clock.Clock) Option {
+	return func(s *settings) {
+		s.appMethods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			s.appMethods[m] = true
+		}
+		s.apps = apps
+		s.appClock = clk
+	}
+}
+
+// WithNonces requires a one-time x-request-nonce header for methods,
+// given by full or bare name, remembered by store for ttl. See
+// interceptors.Nonces.
+func WithNonces(methods []string, store interceptors.NonceStore, ttl time.Duration) Option {
+	return func(s *settings) {
+		s.nonceMethods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			s.nonceMethods[m] = true
+		}
+		s.nonces = store
+		s.nonceTTL = ttl
+	}
+}
+
+// WithRequestCache gives the calls of methods, given by full or bare
+// name, a cache of the reads they repeat. See interceptors.RequestCache.
+func WithRequestCache(methods []string) Option {
+	return func(s *settings) {
+		s.cachedMethods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			s.cachedMethods[m] = true
+		}
+	}
+}
+
+// WithHealth registers the gRPC health service reporting the liveness and
+// readiness of probe. The server marks itself live while it runs.
+func WithHealth(probe *health.Probe) Option {
+	return func(s *settings) { s.health = probe }
+}
+
+// WithSession registers the sso.session.v1.Session service backed by
+// identifier. Its WhoAmI and Logout methods need policies, see
+// WithPolicies.
+func WithSession(identifier sessiongrpc.Identifier) Option {
+	return func(s *settings) { s.session = identifier }
+}
+
+// WithTerms backs AcceptTerms of the Session service, see WithSession,
+// with terms.
+func WithTerms(terms sessiongrpc.TermsAcceptor) Option {
+	return func(s *settings) { s.terms = terms }
+}
+
+// WithDeletions backs ScheduleDeletion and CancelDeletion of the Session
+// service, see WithSession, with deletions.
+func WithDeletions(deletions sessiongrpc.Deleter) Option {
+	return func(s *settings) { s.deletions = deletions }
+}
+
+// WithAdmin registers the sso.admin.v1.Admin service backed by info. Its
+// methods need policies, see WithPolicies.
+func WithAdmin(info admingrpc.InfoProvider) Option {
+	return func(s *settings) { s.admin = info }
+}
+
+// WithMaintenance backs RunMaintenance of the Admin service, see
+// WithAdmin, with maintainer.
+func WithMaintenance(maintainer admingrpc.Maintainer) Option {
+	return func(s *settings) { s.maintainer = maintainer }
+}
+
+// WithFailedTasks backs ListFailedTasks of the Admin service, see
+// WithAdmin, with tasks.
+func WithFailedTasks(tasks admingrpc.TaskLister) Option {
+	return func(s *settings) { s.failedTasks = tasks }
+}
+
+// WithTokenLookup backs LookupToken of the Admin service, see WithAdmin,
+// with lookup.
+func WithTokenLookup(lookup admingrpc.TokenLookup) Option {
+	return func(s *settings) { s.tokenLookup = lookup }
+}
+
+// WithRoleAssignment backs AssignRoleBulk of the Admin service, see
+// WithAdmin, with assigner.
+func WithRoleAssignment(assigner admingrpc.RoleAssigner) Option {
+	return func(s *settings) { s.roleAssigner = assigner }
+}
+
+// WithUserListing backs ListUsers of the Admin service, see WithAdmin,
+// with lister.
+func WithUserListing(lister admingrpc.UserLister) Option {
+	return func(s *settings) { s.userLister = lister }
+}
+
+// WithStats backs GetStats of the Admin service, see WithAdmin, with
+// stats.
+func WithStats(stats admingrpc.StatsProvider) Option {
+	return func(s *settings) { s.stats = stats }
+}
+
+// WithUserStreaming backs StreamUsers of the Admin service, see WithAdmin,
+// with streamer.
+func WithUserStreaming(streamer admingrpc.UserStreamer) Option {
+	return func(s *settings) { s.userStreamer = streamer }
+}
+
+// WithUserBatches backs UsersExist and UserRoles of the Admin service, see
+// WithAdmin, with batch.
+func WithUserBatches(batch admingrpc.UserBatch) Option {
+	return func(s *settings) { s.userBatch = batch }
+}
+
+// WithTokenTTLOverrides backs SetUserTokenTTL of the Admin service, see
+// WithAdmin, with setter.
+func WithTokenTTLOverrides(setter admingrpc.TokenTTLSetter) Option {
+	return func(s *settings) { s.tokenTTLs = setter }
+}
+
+// WithInvites backs CreateInvite of the Admin service, see WithAdmin, with
+// invites.
+func WithInvites(invites admingrpc.InviteCreator) Option {
+	return func(s *settings) { s.invites = invites }
+}
+
+// WithIdentities registers the sso.identity.v1.Identities service backed
+// by linker. Its methods need policies, see WithPolicies.
+func WithIdentities(linker identitygrpc.Linker) Option {
+	return func(s *settings) { s.identities = linker }
+}
+
+// WithChallenges registers the sso.challenge.v1.Challenges service backed
+// by completer. Its method needs no policy.
+func WithChallenges(completer challengegrpc.Completer) Option {
+	return func(s *settings) { s.challenges = completer }
+}
+
+// WithPermissions registers the sso.permission.v1.Permissions service
+// backed by manager. Its methods need policies, see WithPolicies.
+func WithPermissions(manager permissiongrpc.Manager) Option {
+	return func(s *settings) { s.permissions = manager }
+}
+
+// WithOrgUnits registers the sso.orgunit.v1.OrgUnits service backed by
+// manager. Its methods need policies, see WithPolicies.
+func WithOrgUnits(manager orgunitgrpc.Manager) Option {
+	return func(s *settings) { s.orgUnits = manager }
+}
+
+// WithActivations registers the sso.activation.v1.Activations service
+// backed by activator. Its methods but ActivateAccount need policies, see
+// WithPolicies.
+func WithActivations(activator activationgrpc.Activator) Option {
+	return func(s *settings) { s.activations = activator }
+}
+
+// WithAuthorizations registers the sso.authorization.v1.Authorizations
+// service backed by authorizer. Its methods need no policies.
+func WithAuthorizations(authorizer authorizationgrpc.Authorizer) Option {
+	return func(s *settings) { s.authorizations = authorizer }
+}
+
+// WithQuotas registers the sso.quota.v1.Quotas service backed by quotas.
+// Its methods need policies, see WithPolicies.
+func WithQuotas(quotas quotagrpc.Quotas) Option {
+	return func(s *settings) { s.quotas = quotas }
+}
+
+// WithAppUsage backs GetAppUsage of the Quotas service, see WithQuotas.
+// Without it, the method is unimplemented.
+func WithAppUsage(usage quotagrpc.UsageReader) Option {
+	return func(s *settings) { s.appUsage = usage }
+}
+
+// WithWebhooks registers the sso.webhook.v1.Webhooks service backed by
+// webhooks. Its methods need policies, see WithPolicies.
+func WithWebhooks(webhooks webhookgrpc.Manager) Option {
+	return func(s *settings) { s.webhooks = webhooks }
+}
+
+// WithChain orders the built-in interceptors, outermost first, by the
+// names of chain.go such as ClientIPInterceptor. Those left out are off;
+// those listed run only if their feature is on, e.g. retry_budget with
+// WithRetryBudget. Empty keeps DefaultChain. NewServer rejects a chain
+// that leaves out a required interceptor or runs one before another it
+// depends on.
+func WithChain(names ...string) Option {
+	return func(s *settings) { s.chain = names }
+}
+
+// WithInterceptors appends unary interceptors. They run after the built-in
+// ones, so the client IP, the deadline and the caller's token are already
+// settled. Streams go without them.
+func WithInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(s *settings) { s.interceptors = append(s.interceptors, interceptors...) }
+}
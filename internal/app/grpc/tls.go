@@ -0,0 +1,50 @@
+package grpcapp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// tlsFiles are the PEM files of WithTLS.
+type tlsFiles struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+// credentials loads the files. With a client CA, clients must present a
+// certificate it signed.
+func (f *tlsFiles) credentials() (credentials.TransportCredentials, error) {
+	if f.certFile == "" || f.keyFile == "" {
+		return nil, errors.New("tls: cert and key files are required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if f.clientCAFile != "" {
+		pem, err := os.ReadFile(f.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: no certificates in %s", f.clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(cfg), nil
+}
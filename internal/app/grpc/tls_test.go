@@ -0,0 +1,240 @@
+package grpcapp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientcert"
+	"sso/internal/services/auth"
+	"sso/internal/storage/memory"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issue returns a certificate for 127.0.0.1 signed by parent, or
+// self-signed if parent is nil.
+func issue(t *testing.T, name string, parent *testCert, ca bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+// write writes the certificate and key of c to dir, and returns their
+// paths.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func TestWithTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, "server", ca, false).write(t, dir, "server")
+	client := issue(t, "client", ca, false)
+	stranger := issue(t, "stranger", issue(t, "other-ca", nil, true), false)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	serveTLS := func(clientCAFile string) string {
+		a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), stubAuth{},
+			WithDebug(fakeValidator{}),
+			WithTLS(certFile, keyFile, clientCAFile),
+		)
+		require.NoError(t, err)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go func() { _ = a.gRPCServer.Serve(l) }()
+		t.Cleanup(a.gRPCServer.Stop)
+
+		return l.Addr().String()
+	}
+	call := func(addr string, certs ...tls.Certificate) error {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		})))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		return conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String("good"), &structpb.Struct{})
+	}
+
+	addr := serveTLS("")
+	assert.NoError(t, call(addr))
+
+	addr = serveTLS(caFile)
+	assert.Error(t, call(addr), "no client certificate")
+	assert.Error(t, call(addr, stranger.tls()), "certificate of another CA")
+	assert.NoError(t, call(addr, client.tls()))
+}
+
+func TestWithTLS_InvalidFiles(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+	certFile, keyFile := issue(t, "server", nil, false).write(t, dir, "server")
+
+	_, err := NewServer(log, stubAuth{}, WithTLS("", "", ""))
+	assert.ErrorContains(t, err, "cert and key files are required")
+
+	_, err = NewServer(log, stubAuth{}, WithTLS(filepath.Join(dir, "missing.crt"), keyFile, ""))
+	assert.Error(t, err)
+
+	// A key is not a CA bundle.
+	_, err = NewServer(log, stubAuth{}, WithTLS(certFile, keyFile, keyFile))
+	assert.ErrorContains(t, err, "no certificates")
+
+	_, err = NewServer(log, stubAuth{}, WithTLS(certFile, keyFile, ""))
+	assert.NoError(t, err)
+}
+
+func TestWithTLS_TokenBinding(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := issue(t, "server", ca, false).write(t, dir, "server")
+	alice, bob := issue(t, "alice", ca, false), issue(t, "bob", ca, false)
+
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 1, Name: "bound", Secret: "test-secret", BindTokens: true})
+	storage.SaveApp(models.App{ID: 2, Name: "unbound", Secret: "test-secret"})
+	authService, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		auth.WithHasher(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+	)
+	require.NoError(t, err)
+	_, err = authService.RegisterNewUser(t.Context(), "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	a, err := NewServer(slog.New(slog.NewTextHandler(io.Discard, nil)), authService,
+		WithDebug(authService),
+		WithTLS(certFile, keyFile, caFile),
+	)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = a.gRPCServer.Serve(l) }()
+	t.Cleanup(a.gRPCServer.Stop)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dial := func(cert *testCert) *grpc.ClientConn {
+		conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{cert.tls()},
+		})))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return conn
+	}
+	login := func(conn *grpc.ClientConn, appID int32) string {
+		resp, err := ssov1.NewAuthClient(conn).Login(t.Context(), &ssov1.LoginRequest{
+			Email:    "user@example.com",
+			Password: "correct-password",
+			AppId:    appID,
+		})
+		require.NoError(t, err)
+
+		return resp.GetToken()
+	}
+	whoAmI := func(conn *grpc.ClientConn, token string) (*structpb.Struct, error) {
+		var claims structpb.Struct
+		err := conn.Invoke(t.Context(), "/sso.debug.v1.Debug/WhoAmI", wrapperspb.String(token), &claims)
+
+		return &claims, err
+	}
+	aliceConn, bobConn := dial(alice), dial(bob)
+
+	token := login(aliceConn, 1)
+	claims, err := whoAmI(aliceConn, token)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"x5t#S256": clientcert.Thumbprint(alice.cert)}, claims.AsMap()["cnf"])
+
+	_, err = whoAmI(bobConn, token)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, string(errs.TokenBindingMismatch), errorReason(err))
+
+	// Apps that did not opt in are unaffected.
+	token = login(aliceConn, 2)
+	claims, err = whoAmI(bobConn, token)
+	require.NoError(t, err)
+	assert.NotContains(t, claims.AsMap(), "cnf")
+}
+
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"log/slog"
+	"slices"
+
+	"sso/internal/config"
+	"sso/internal/lib/metrics"
+)
+
+// defaultBuckets are the buckets of config.Histograms not set in
+// metrics.buckets.
+var defaultBuckets = map[string][]float64{
+	"storage_query_duration_seconds": metrics.StorageBuckets,
+	"password_hash_duration_seconds": metrics.HashingBuckets,
+}
+
+// histograms holds the histograms of config.Histograms, by name, with the
+// buckets they were given.
+type histograms struct {
+	vecs    map[string]*metrics.HistogramVec
+	buckets map[string][]float64
+}
+
+// newHistograms returns the histograms of config.Histograms, labelled by
+// operation, with the buckets of cfg.
+func newHistograms(cfg config.MetricsConfig) *histograms {
+	h := &histograms{
+		vecs:    make(map[string]*metrics.HistogramVec, len(config.Histograms)),
+		buckets: make(map[string][]float64, len(config.Histograms)),
+	}
+	for _, name := range config.Histograms {
+		buckets := bucketsOf(cfg, name)
+		vec := metrics.NewHistogramVec(name, "op", buckets)
+		vec.Exemplars = cfg.Exemplars
+		h.vecs[name] = vec
+		h.buckets[name] = buckets
+	}
+
+	return h
+}
+
+func (h *histograms) get(name string) *metrics.HistogramVec {
+	return h.vecs[name]
+}
+
+// reload gives the histograms whose buckets changed in cfg the new ones.
+// They start over: the vecs are kept, so nothing holding them needs to
+// register them again. Reloads come one at a time, see config.Reloader.
+func (h *histograms) reload(log *slog.Logger, cfg config.MetricsConfig) {
+	for _, name := range config.Histograms {
+		buckets := bucketsOf(cfg, name)
+		if slices.Equal(buckets, h.buckets[name]) {
+			continue
+		}
+
+		h.vecs[name].SetBuckets(buckets)
+		h.buckets[name] = buckets
+		log.Info("histogram buckets changed, histogram restarted",
+			slog.String("histogram", name),
+			slog.Any("buckets", buckets),
+		)
+	}
+}
+
+func bucketsOf(cfg config.MetricsConfig, name string) []float64 {
+	if buckets, ok := cfg.Buckets[name]; ok {
+		return buckets
+	}
+
+	return defaultBuckets[name]
+}
@@ -0,0 +1,82 @@
+package httpapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const readHeaderTimeout = 5 * time.Second
+
+// App is the public HTTP server, for the endpoints meant for other
+// services such as token introspection. Unlike the debug server, it may
+// listen on any address: it serves only the handlers registered with
+// Handle.
+type App struct {
+	log     *slog.Logger
+	server  *http.Server
+	mux     *http.ServeMux
+	address string
+}
+
+// New returns an HTTP server listening on address. A nil log logs to
+// slog.Default().
+func New(log *slog.Logger, address string) *App {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	mux := http.NewServeMux()
+
+	return &App{
+		log:     log,
+		mux:     mux,
+		address: address,
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: readHeaderTimeout,
+		},
+	}
+}
+
+// Handle registers a handler. It must be called before Run.
+func (a *App) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// Run runs HTTP server
+func (a *App) Run() error {
+	const op = "httpapp.Run"
+
+	l, err := net.Listen("tcp", a.address)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.log.Info("http server is running",
+		slog.String("op", op),
+		slog.String("address", l.Addr().String()),
+	)
+
+	if err := a.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops HTTP server
+func (a *App) Stop(ctx context.Context) {
+	const op = "httpapp.Stop"
+
+	a.log.Info("stopping http server", slog.String("op", op))
+
+	if err := a.server.Shutdown(ctx); err != nil {
+		a.log.Warn("http server shutdown failed", slog.String("op", op), slog.Any("error", err))
+	}
+}
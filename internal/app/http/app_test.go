@@ -0,0 +1,37 @@
+package httpapp
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlers(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), "127.0.0.1:0")
+	a.Handle("/v1/introspect", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `{"active":false}`)
+	}))
+	srv := httptest.NewServer(a.server.Handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/introspect")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"active":false}`, string(body))
+
+	// Nothing of the debug server is served.
+	for _, path := range []string{"/debug/pprof/", "/debug/vars", "/debug/config", "/healthz"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+)
+
+type integrityChecker interface {
+	CheckIntegrity(ctx context.Context, quick bool) ([]string, error)
+}
+
+// checkIntegrity runs the storage integrity check of mode, "quick" or
+// "full", and logs what it found.
+func checkIntegrity(
+	ctx context.Context,
+	log *slog.Logger,
+	storage integrityChecker,
+	mode string,
+	clk clock.Clock,
+) *models.IntegrityCheck {
+	log = log.With(slog.String("mode", mode))
+	log.Info("checking storage integrity")
+
+	start := clk.Now()
+	problems, err := storage.CheckIntegrity(ctx, mode == "quick")
+	res := &models.IntegrityCheck{
+		Mode:      mode,
+		CheckedAt: start,
+		Duration:  clk.Now().Sub(start),
+		Problems:  problems,
+		Err:       err,
+	}
+
+	switch {
+	case err != nil:
+		log.Error("failed to check storage integrity", slog.Any("error", err))
+	case len(problems) > 0:
+		log.Error("storage is corrupt", slog.Any("problems", problems))
+	default:
+		log.Info("storage integrity checked", slog.Duration("duration", res.Duration))
+	}
+
+	return res
+}
+
+// integrityHealth fails readiness for as long as the instance runs if the
+// integrity check on startup failed: the file does not mend itself.
+func integrityHealth(res *models.IntegrityCheck) func(context.Context) error {
+	return func(context.Context) error {
+		switch {
+		case res.Err != nil:
+			return fmt.Errorf("%s check failed: %w", res.Mode, res.Err)
+		case len(res.Problems) > 0:
+			return fmt.Errorf("%s check found %d problem(s), first: %s", res.Mode, len(res.Problems), res.Problems[0])
+		}
+
+		return nil
+	}
+}
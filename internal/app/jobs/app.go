@@ -0,0 +1,107 @@
+package jobsapp
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sso/internal/lib/clock"
+)
+
+// Job is a task run periodically in the background.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type App struct {
+	log   *slog.Logger
+	clock clock.Clock
+	jobs  []Job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns an App running jobs on the ticks of clk. A nil log logs to
+// slog.Default() and a nil clk is the real clock.
+func New(log *slog.Logger, clk clock.Clock, jobs ...Job) *App {
+	if log == nil {
+		log = slog.Default()
+	}
+	if clk == nil {
+		clk = clock.Real()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &App{
+		log:    log,
+		clock:  clk,
+		jobs:   jobs,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Run runs every job on its interval until Stop is called.
+// A job never overlaps with itself; failures are logged and retried on the
+// next tick.
+func (a *App) Run() {
+	const op = "jobsapp.Run"
+
+	for _, job := range a.jobs {
+		a.wg.Add(1)
+
+		go func() {
+			defer a.wg.Done()
+
+			a.loop(job)
+		}()
+	}
+
+	a.log.Info("background jobs are running", slog.String("op", op), slog.Int("jobs", len(a.jobs)))
+
+	a.wg.Wait()
+}
+
+// Stop cancels running jobs and waits for them to return.
+func (a *App) Stop() {
+	const op = "jobsapp.Stop"
+
+	a.log.With(slog.String("op", op)).Info("stopping background jobs")
+
+	a.cancel()
+	a.wg.Wait()
+}
+
+func (a *App) loop(job Job) {
+	log := a.log.With(slog.String("job", job.Name))
+
+	if job.Interval <= 0 {
+		log.Error("job disabled: interval must be positive", slog.Duration("interval", job.Interval))
+		return
+	}
+
+	ticker := a.clock.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		start := a.clock.Now()
+		if err := job.Run(a.ctx); err != nil {
+			log.Error("job failed", slog.Any("error", err))
+			continue
+		}
+
+		log.Debug("job finished", slog.Duration("duration", a.clock.Now().Sub(start)))
+	}
+}
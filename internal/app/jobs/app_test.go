@@ -0,0 +1,48 @@
+package jobsapp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_OnClockTicks(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	runs := make(chan time.Time, 10)
+
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), clk, Job{
+		Name:     "test",
+		Interval: time.Hour,
+		Run: func(context.Context) error {
+			runs <- clk.Now()
+			return nil
+		},
+	})
+	go a.Run()
+	t.Cleanup(a.Stop)
+
+	require.Eventually(t, func() bool { return clk.Tickers() == 1 }, time.Second, time.Millisecond)
+
+	select {
+	case <-runs:
+		require.Fail(t, "job ran before its interval")
+	default:
+	}
+
+	clk.Advance(time.Hour)
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		require.Fail(t, "job did not run on the tick")
+	}
+
+	a.Stop()
+	assert.Equal(t, 0, clk.Tickers(), "ticker is stopped with the job")
+}
@@ -0,0 +1,76 @@
+package jobsapp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/lib/clock"
+)
+
+const (
+	backupPrefix     = "sso-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+type Backuper interface {
+	Backup(ctx context.Context, destPath string) error
+}
+
+// BackupJob returns a job writing a backup into dir, named after the time
+// on clk, and keeping only the newest keep backups there.
+func BackupJob(b Backuper, dir string, keep int, interval time.Duration, clk clock.Clock) Job {
+	return Job{
+		Name:     "backup",
+		Interval: interval,
+		Run: func(ctx context.Context) error {
+			const op = "jobsapp.BackupJob"
+
+			if err := os.MkdirAll(dir, 0o750); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			name := backupPrefix + clk.Now().UTC().Format(backupTimeLayout) + backupSuffix
+			if err := b.Backup(ctx, filepath.Join(dir, name)); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			if err := pruneBackups(dir, keep); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+
+			return nil
+		},
+	}
+}
+
+// pruneBackups removes all but the newest keep backups in dir.
+// Timestamped names sort chronologically.
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasPrefix(e.Name(), backupPrefix) && strings.HasSuffix(e.Name(), backupSuffix) {
+			backups = append(backups, e.Name())
+		}
+	}
+	slices.Sort(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package jobsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/metrics"
+)
+
+type Maintainer interface {
+	Optimize(ctx context.Context) error
+	Vacuum(ctx context.Context) (models.MaintenanceResult, error)
+}
+
+// UsageTrimmer deletes the app usage of the days before a horizon.
+type UsageTrimmer interface {
+	TrimAppUsage(ctx context.Context, before time.Time) (int64, error)
+}
+
+// IssuancePurger deletes the token issuances retained until before a time.
+type IssuancePurger interface {
+	PurgeTokenIssuances(ctx context.Context, now time.Time) (int64, error)
+}
+
+// RevocationPurger deletes the revoked tokens expired before a time.
+type RevocationPurger interface {
+	PurgeRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Window is a daily span of time of day in UTC, from Start up to End. It
+// may wrap past midnight. The zero Window is empty.
+type Window struct {
+	Start, End time.Duration
+}
+
+// ParseWindow parses a window like "02:00-05:00". An empty string is the
+// empty window.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return Window{}, nil
+	}
+
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("window %q: want HH:MM-HH:MM", s)
+	}
+	var w Window
+	for _, b := range []struct {
+		s string
+		d *time.Duration
+	}{{from, &w.Start}, {to, &w.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(b.s))
+		if err != nil {
+			return Window{}, fmt.Errorf("window %q: want HH:MM-HH:MM", s)
+		}
+		*b.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("window %q is empty", s)
+	}
+
+	return w, nil
+}
+
+// opening returns when the window t is in opened, or false if t is
+// outside of it.
+func (w Window) opening(t time.Time) (time.Time, bool) {
+	if w.Start == w.End {
+		return time.Time{}, false
+	}
+
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+
+	switch {
+	case w.Start < w.End:
+		if sinceMidnight >= w.Start && sinceMidnight < w.End {
+			return midnight.Add(w.Start), true
+		}
+	case sinceMidnight >= w.Start:
+		return midnight.Add(w.Start), true
+	case sinceMidnight < w.End:
+		// Opened the day before.
+		return midnight.AddDate(0, 0, -1).Add(w.Start), true
+	}
+
+	return time.Time{}, false
+}
+
+// Maintenance keeps the storage file compact and its query plans fresh.
+// Its job runs PRAGMA optimize on every tick and, once per low-traffic
+// window, a vacuum, which holds the writer for its duration: every run is
+// bounded by maxDuration and, when cut short, retried on the next tick
+// in the window.
+type Maintenance struct {
+	log         *slog.Logger
+	m           Maintainer
+	window      Window
+	maxDuration time.Duration
+	clock       clock.Clock
+
+	durations *metrics.HistogramVec
+	reclaimed *metrics.Counter
+	aborted   *metrics.Counter
+
+	usage          UsageTrimmer
+	usageRetention time.Duration
+	issuances      IssuancePurger
+	revocations    RevocationPurger
+
+	mu sync.Mutex
+	// vacuumed is the opening of the window a vacuum last completed in.
+	vacuumed time.Time
+}
+
+// NewMaintenance returns the maintenance of m, vacuuming in window for at
+// most maxDuration at a time.
+func NewMaintenance(
+	log *slog.Logger,
+	m Maintainer,
+	window Window,
+	maxDuration time.Duration,
+	clk clock.Clock,
+) *Maintenance {
+	return &Maintenance{
+		log:         log.With(slog.String("job", "storage-maintenance")),
+		m:           m,
+		window:      window,
+		maxDuration: maxDuration,
+		clock:       clk,
+		durations:   metrics.NewHistogramVec("storage_maintenance_duration_seconds", "task", nil),
+		reclaimed:   metrics.NewCounter("storage_maintenance_reclaimed_pages_total"),
+		aborted:     metrics.NewCounter("storage_maintenance_aborted_total"),
+	}
+}
+
+// TrimUsage makes every tick delete the app usage older than retention
+// from usage. Call it before the job runs.
+func (m *Maintenance) TrimUsage(usage UsageTrimmer, retention time.Duration) {
+	m.usage = usage
+	m.usageRetention = retention
+}
+
+// PurgeIssuances makes every tick delete the token issuances of issuances
+// past their retention. Call it before the job runs.
+func (m *Maintenance) PurgeIssuances(issuances IssuancePurger) {
+	m.issuances = issuances
+}
+
+// PurgeRevocations makes every tick delete the revoked tokens of
+// revocations that have expired, and so are rejected anyway. Call it
+// before the job runs.
+func (m *Maintenance) PurgeRevocations(revocations RevocationPurger) {
+	m.revocations = revocations
+}
+
+// Job returns the job running the maintenance every interval.
+func (m *Maintenance) Job(interval time.Duration) Job {
+	return Job{
+		Name:     "storage-maintenance",
+		Interval: interval,
+		Run:      m.tick,
+	}
+}
+
+func (m *Maintenance) tick(ctx context.Context) error {
+	const op = "jobsapp.Maintenance"
+
+	start := m.clock.Now()
+	optimizeCtx, cancel := context.WithTimeout(ctx, m.maxDuration)
+	err := m.m.Optimize(optimizeCtx)
+	err = m.abortedErr(optimizeCtx, err)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	m.durations.With("optimize").Observe(m.clock.Now().Sub(start).Seconds())
+
+	if err := m.trimUsage(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := m.purgeIssuances(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := m.purgeRevocations(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	opening, ok := m.window.opening(m.clock.Now())
+	if !ok {
+		return nil
+	}
+	m.mu.Lock()
+	done := m.vacuumed.Equal(opening)
+	m.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	res, err := m.RunMaintenance(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !res.Skipped {
+		m.mu.Lock()
+		m.vacuumed = opening
+		m.mu.Unlock()
+	}
+
+	return nil
+}
+
+// RunMaintenance vacuums the storage now, whatever the window, for at most the
+// ceiling. A backup in progress skips it.
+func (m *Maintenance) RunMaintenance(ctx context.Context) (models.MaintenanceResult, error) {
+	const op = "jobsapp.Maintenance.RunMaintenance"
+
+	ctx, cancel := context.WithTimeout(ctx, m.maxDuration)
+	defer cancel()
+
+	res, err := m.m.Vacuum(ctx)
+	if err != nil {
+		return models.MaintenanceResult{}, fmt.Errorf("%s: %w", op, m.abortedErr(ctx, err))
+	}
+	if res.Skipped {
+		m.log.Info("vacuum skipped: backup or vacuum in progress")
+
+		return res, nil
+	}
+
+	m.durations.With("vacuum").Observe(res.Duration.Seconds())
+	m.reclaimed.Add(res.ReclaimedPages())
+	m.log.Info("storage vacuumed",
+		slog.Duration("duration", res.Duration),
+		slog.Int64("reclaimed_pages", res.ReclaimedPages()),
+		slog.Int64("reclaimed_bytes", res.ReclaimedPages()*res.PageSize),
+	)
+
+	return res, nil
+}
+
+// trimUsage deletes the app usage of the days that started more than the
+// retention ago, if trimming is on.
+func (m *Maintenance) trimUsage(ctx context.Context) error {
+	if m.usage == nil || m.usageRetention <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.maxDuration)
+	defer cancel()
+
+	n, err := m.usage.TrimAppUsage(ctx, m.clock.Now().Add(-m.usageRetention))
+	if err != nil {
+		return m.abortedErr(ctx, err)
+	}
+	if n > 0 {
+		m.log.Info("app usage trimmed", slog.Int64("app_days", n), slog.Duration("retention", m.usageRetention))
+	}
+
+	return nil
+}
+
+// purgeIssuances deletes the token issuances past their retention, if
+// purging is on.
+func (m *Maintenance) purgeIssuances(ctx context.Context) error {
+	if m.issuances == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.maxDuration)
+	defer cancel()
+
+	n, err := m.issuances.PurgeTokenIssuances(ctx, m.clock.Now())
+	if err != nil {
+		return m.abortedErr(ctx, err)
+	}
+	if n > 0 {
+		m.log.Info("token issuances purged", slog.Int64("issuances", n))
+	}
+
+	return nil
+}
+
+// purgeRevocations deletes the expired revoked tokens, if purging is on.
+func (m *Maintenance) purgeRevocations(ctx context.Context) error {
+	if m.revocations == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.maxDuration)
+	defer cancel()
+
+	n, err := m.revocations.PurgeRevokedTokens(ctx, m.clock.Now())
+	if err != nil {
+		return m.abortedErr(ctx, err)
+	}
+	if n > 0 {
+		m.log.Info("revoked tokens purged", slog.Int64("tokens", n))
+	}
+
+	return nil
+}
+
+// Metrics returns the metrics of the maintenance by name.
+func (m *Maintenance) Metrics() map[string]any {
+	return map[string]any{
+		m.durations.Name: m.durations.Snapshot(),
+		m.reclaimed.Name: m.reclaimed.Value(),
+		m.aborted.Name:   m.aborted.Value(),
+	}
+}
+
+// abortedErr counts err if the ceiling of ctx cut the run short and says
+// so. The driver reports the interruption as an error of its own, hence
+// the check of ctx.
+func (m *Maintenance) abortedErr(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	m.aborted.Inc()
+
+	return fmt.Errorf("aborted after %s, retried on the next tick: %w", m.maxDuration, err)
+}
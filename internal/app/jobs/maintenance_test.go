@@ -0,0 +1,231 @@
+package jobsapp
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMaintainer struct {
+	optimized int
+	vacuumed  int
+	skip      bool
+	// block makes Vacuum wait for its context, as an interrupted one does.
+	block bool
+}
+
+func (f *fakeMaintainer) Optimize(context.Context) error {
+	f.optimized++
+
+	return nil
+}
+
+func (f *fakeMaintainer) Vacuum(ctx context.Context) (models.MaintenanceResult, error) {
+	if f.skip {
+		return models.MaintenanceResult{Skipped: true}, nil
+	}
+	if f.block {
+		<-ctx.Done()
+
+		return models.MaintenanceResult{}, context.Cause(ctx)
+	}
+	f.vacuumed++
+
+	return models.MaintenanceResult{Duration: time.Second, PageSize: 4096, PagesBefore: 100, PagesAfter: 40}, nil
+}
+
+type fakeTrimmer struct {
+	before []time.Time
+}
+
+func (f *fakeTrimmer) TrimAppUsage(_ context.Context, before time.Time) (int64, error) {
+	f.before = append(f.before, before)
+
+	return 3, nil
+}
+
+type fakePurger struct {
+	now []time.Time
+}
+
+func (f *fakePurger) PurgeTokenIssuances(_ context.Context, now time.Time) (int64, error) {
+	f.now = append(f.now, now)
+
+	return 2, nil
+}
+
+func (f *fakePurger) PurgeRevokedTokens(_ context.Context, now time.Time) (int64, error) {
+	f.now = append(f.now, now)
+
+	return 1, nil
+}
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("02:30-05:00")
+	require.NoError(t, err)
+	assert.Equal(t, Window{Start: 2*time.Hour + 30*time.Minute, End: 5 * time.Hour}, w)
+
+	w, err = ParseWindow("")
+	require.NoError(t, err)
+	assert.Equal(t, Window{}, w)
+
+	for _, s := range []string{"02:00", "25:00-03:00", "02:00-02:00", "2am-5am"} {
+		_, err := ParseWindow(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestWindow_Opening(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+
+	w := Window{Start: 2 * time.Hour, End: 5 * time.Hour}
+	opening, ok := w.opening(at(3, 0))
+	assert.True(t, ok)
+	assert.Equal(t, at(2, 0), opening)
+	_, ok = w.opening(at(5, 0))
+	assert.False(t, ok)
+
+	// Past midnight.
+	w = Window{Start: 23 * time.Hour, End: 1 * time.Hour}
+	opening, ok = w.opening(at(0, 30))
+	assert.True(t, ok)
+	assert.Equal(t, at(-1, 0), opening)
+	opening, ok = w.opening(at(23, 30))
+	assert.True(t, ok)
+	assert.Equal(t, at(23, 0), opening)
+	_, ok = w.opening(at(12, 0))
+	assert.False(t, ok)
+
+	_, ok = Window{}.opening(at(3, 0))
+	assert.False(t, ok)
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 6, 1, 1, 0, 0, 0, time.UTC))
+	f := &fakeMaintainer{}
+	m := NewMaintenance(slog.New(slog.NewTextHandler(io.Discard, nil)), f,
+		Window{Start: 2 * time.Hour, End: 4 * time.Hour}, time.Minute, clk)
+	tick := m.Job(time.Hour).Run
+
+	// Outside the window only the planner statistics are refreshed.
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, 1, f.optimized)
+	assert.Zero(t, f.vacuumed)
+
+	// A backup in progress puts the vacuum off to the next tick.
+	clk.Advance(time.Hour)
+	f.skip = true
+	require.NoError(t, tick(ctx))
+	assert.Zero(t, f.vacuumed)
+
+	f.skip = false
+	clk.Advance(30 * time.Minute)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, 1, f.vacuumed)
+
+	// Once per window.
+	clk.Advance(30 * time.Minute)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, 1, f.vacuumed)
+
+	clk.Advance(24 * time.Hour)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, 2, f.vacuumed)
+	assert.Equal(t, 5, f.optimized)
+
+	metrics := m.Metrics()
+	assert.Equal(t, int64(120), metrics["storage_maintenance_reclaimed_pages_total"])
+
+	// On demand, whatever the window.
+	clk.Advance(12 * time.Hour)
+	res, err := m.RunMaintenance(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), res.ReclaimedPages())
+}
+
+func TestMaintenance_Ceiling(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC))
+	f := &fakeMaintainer{block: true}
+	m := NewMaintenance(slog.New(slog.NewTextHandler(io.Discard, nil)), f,
+		Window{Start: 2 * time.Hour, End: 4 * time.Hour}, 10*time.Millisecond, clk)
+	tick := m.Job(time.Hour).Run
+
+	assert.ErrorContains(t, tick(ctx), "aborted")
+	assert.Equal(t, int64(1), m.Metrics()["storage_maintenance_aborted_total"])
+
+	// Retried on the next tick in the window.
+	f.block = false
+	clk.Advance(10 * time.Minute)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, 1, f.vacuumed)
+}
+
+func TestMaintenance_TrimUsage(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	f := &fakeTrimmer{}
+	m := NewMaintenance(slog.New(slog.NewTextHandler(io.Discard, nil)), &fakeMaintainer{}, Window{}, time.Minute, clk)
+	tick := m.Job(time.Hour).Run
+
+	// Off by default.
+	require.NoError(t, tick(ctx))
+
+	m.TrimUsage(f, 30*24*time.Hour)
+	require.NoError(t, tick(ctx))
+	clk.Advance(time.Hour)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, []time.Time{now.AddDate(0, 0, -30), now.AddDate(0, 0, -30).Add(time.Hour)}, f.before)
+
+	// Zero retention keeps everything.
+	m.TrimUsage(f, 0)
+	require.NoError(t, tick(ctx))
+	assert.Len(t, f.before, 2)
+}
+
+func TestMaintenance_PurgeIssuances(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	f := &fakePurger{}
+	m := NewMaintenance(slog.New(slog.NewTextHandler(io.Discard, nil)), &fakeMaintainer{}, Window{}, time.Minute, clk)
+	tick := m.Job(time.Hour).Run
+
+	// Off by default.
+	require.NoError(t, tick(ctx))
+
+	m.PurgeIssuances(f)
+	require.NoError(t, tick(ctx))
+	clk.Advance(time.Hour)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, []time.Time{now, now.Add(time.Hour)}, f.now)
+}
+
+func TestMaintenance_PurgeRevocations(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	f := &fakePurger{}
+	m := NewMaintenance(slog.New(slog.NewTextHandler(io.Discard, nil)), &fakeMaintainer{}, Window{}, time.Minute, clk)
+	tick := m.Job(time.Hour).Run
+
+	// Off by default.
+	require.NoError(t, tick(ctx))
+
+	m.PurgeRevocations(f)
+	require.NoError(t, tick(ctx))
+	clk.Advance(time.Hour)
+	require.NoError(t, tick(ctx))
+	assert.Equal(t, []time.Time{now, now.Add(time.Hour)}, f.now)
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+)
+
+type schemaVerifier interface {
+	VerifySchema(ctx context.Context, table string) (models.SchemaCheck, error)
+}
+
+// checkSchema compares the storage schema with what the code relies on and
+// logs how they differ, so that a migration applied halfway is told on
+// startup rather than by the first query it breaks. Readiness keeps
+// failing for as long as they do, see the migrations check.
+func checkSchema(
+	ctx context.Context,
+	log *slog.Logger,
+	storage schemaVerifier,
+	table string,
+	clk clock.Clock,
+) *models.SchemaCheck {
+	res, err := storage.VerifySchema(ctx, table)
+	res.CheckedAt = clk.Now()
+	res.Err = err
+
+	switch {
+	case err != nil:
+		log.Error("failed to check storage schema", slog.Any("error", err))
+	case !res.OK():
+		log.Error("storage schema does not match the code",
+			slog.Int("version", res.Version),
+			slog.Int("want_version", res.Want),
+			slog.Bool("dirty", res.Dirty),
+			slog.Any("missing", res.Missing),
+		)
+	}
+
+	return &res
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+
+	"sso/internal/buildinfo"
+	"sso/internal/config"
+	"sso/internal/domain/models"
+	"sso/internal/lib/apiversion"
+	"sso/internal/lib/clock"
+)
+
+const storageDriver = "sqlite"
+
+type migrationVersioner interface {
+	MigrationVersion(ctx context.Context, table string) (version int, dirty bool, err error)
+}
+
+// serverInfo answers GetServerInfo. Only the schema version, the uptime
+// and the runtime stats change while the instance runs. The listeners are
+// filled in once the servers are built, before any of them runs.
+type serverInfo struct {
+	clk     clock.Clock
+	storage migrationVersioner
+	table   string
+	info    models.ServerInfo
+}
+
+func newServerInfo(
+	clk clock.Clock,
+	storage migrationVersioner,
+	cfg *config.Config,
+) *serverInfo {
+	build := buildinfo.Get()
+
+	return &serverInfo{
+		clk:     clk,
+		storage: storage,
+		table:   cfg.Storage.MigrationsTable,
+		info: models.ServerInfo{
+			Version:       build.Version,
+			Commit:        build.Commit,
+			StorageDriver: storageDriver,
+			Features:      features(cfg),
+			Clamped:       clamped(cfg),
+			MinAPIVersion: apiversion.Min,
+			MaxAPIVersion: apiversion.Max,
+			StartedAt:     clk.Now(),
+		},
+	}
+}
+
+func (s *serverInfo) ServerInfo(ctx context.Context) (models.ServerInfo, error) {
+	const op = "app.ServerInfo"
+
+	version, _, err := s.storage.MigrationVersion(ctx, s.table)
+	if err != nil {
+		return models.ServerInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	info := s.info
+	info.SchemaVersion = version
+	info.Uptime = s.clk.Now().Sub(info.StartedAt)
+	info.Runtime = models.RuntimeStats{
+		GoVersion:      runtime.Version(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		NumGC:          mem.NumGC,
+	}
+
+	return info, nil
+}
+
+// logBanner logs what the instance starts with. A schema version that
+// cannot be read is left out; the migrations health check reports it.
+func (s *serverInfo) logBanner(ctx context.Context, log *slog.Logger) {
+	info := s.info
+	attrs := []any{
+		slog.String("version", info.Version),
+		slog.String("commit", info.Commit),
+		slog.String("go_version", runtime.Version()),
+		slog.String("storage_driver", info.StorageDriver),
+		slog.Any("features", info.Features),
+		slog.Any("listeners", info.Listeners),
+	}
+	if version, _, err := s.storage.MigrationVersion(ctx, s.table); err == nil {
+		attrs = append(attrs, slog.Int("schema_version", version))
+	}
+
+	log.Info("server info", attrs...)
+	for _, c := range info.Clamped {
+		log.Warn("setting replaced with its default",
+			slog.String("setting", c.Path),
+			slog.String("value", c.Value),
+			slog.String("default", c.Default),
+		)
+	}
+}
+
+// clamped returns the settings cfg runs with the defaults of.
+func clamped(cfg *config.Config) []models.ClampedSetting {
+	var res []models.ClampedSetting
+	for _, c := range cfg.Clamped() {
+		res = append(res, models.ClampedSetting{Path: c.Path, Value: c.Value, Default: c.Default})
+	}
+
+	return res
+}
+
+// features names the optional features cfg enables, sorted. Only names
+// are listed, never the values behind them.
+func features(cfg *config.Config) []string {
+	enabled := map[string]bool{
+		"app_credentials":         len(cfg.GRPC.AppAuth) > 0,
+		"backups":                 cfg.Storage.Backup.Enabled,
+		"concealed_registration":  cfg.Registration.ConcealUsers,
+		"debug_server":            cfg.Debug.Enabled,
+		"email_domains":           len(cfg.Registration.AllowedDomains) > 0 || len(cfg.Registration.DeniedDomains) > 0,
+		"introspection":           cfg.Introspect.Enabled,
+		"login_failure_floor":     cfg.Login.FailureFloor > 0,
+		"payload_logging":         cfg.Env == envLocal && cfg.GRPC.LogPayloads,
+		"reflection":              cfg.GRPC.Reflection || cfg.Env == envLocal,
+		"request_nonces":          len(cfg.GRPC.Nonces.Methods) > 0,
+		"signing_key_rotation":    cfg.JWT.Algorithm == algRS256,
+		"storage_encryption":      cfg.Storage.EncryptionKey != "",
+		"storage_integrity_check": cfg.Storage.IntegrityCheck != "off",
+		"terms_of_service":        cfg.TOS.RequiredVersion != "",
+		"verbose_errors":          cfg.Errors.Verbose,
+		"webhooks":                cfg.Webhooks.Enabled,
+	}
+
+	res := make([]string, 0, len(enabled))
+	for name, on := range enabled {
+		if on {
+			res = append(res, name)
+		}
+	}
+	slices.Sort(res)
+
+	return res
+}
@@ -0,0 +1,49 @@
+// Package buildinfo tells which build of sso is running. Version and
+// Commit are set at link time:
+//
+//	go build -ldflags "-X sso/internal/buildinfo.Version=v1.2.0 -X sso/internal/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/sso
+//
+// A binary built without them falls back to what the Go toolchain
+// recorded, if anything.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Version is the release of the build, "dev" when not set.
+	Version = "dev"
+	// Commit is the VCS revision the build was made from.
+	Commit = ""
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string
+	Commit    string
+	GoVersion string
+}
+
+// Get returns the build of the running binary.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit != "" {
+		return info
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "vcs.revision" {
+				info.Commit = s.Value
+			}
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,20 @@
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	version, commit := Version, Commit
+	t.Cleanup(func() { Version, Commit = version, commit })
+
+	Version, Commit = "v1.2.0", "abc123"
+	assert.Equal(t, Info{Version: "v1.2.0", Commit: "abc123", GoVersion: runtime.Version()}, Get())
+
+	// Test binaries carry no VCS revision to fall back to.
+	Version, Commit = "dev", ""
+	assert.Equal(t, "dev", Get().Version)
+}
@@ -2,31 +2,423 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"maps"
 	"os"
 	"time"
 
+	"sso/internal/lib/emailaddr"
+
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        GRPCConfig    `yaml:"grpc"`
+	Path         string             `yaml:"-"`
+	Env          string             `yaml:"env" env-default:"local"`
+	LogLevel     string             `yaml:"log_level" env:"LOG_LEVEL"`
+	StoragePath  string             `yaml:"storage_path" env-required:"true"`
+	TokenTTL     time.Duration      `yaml:"token_ttl" env-required:"true"`
+	Storage      StorageConfig      `yaml:"storage"`
+	GRPC         GRPCConfig         `yaml:"grpc"`
+	Debug        DebugConfig        `yaml:"debug"`
+	HTTP         HTTPConfig         `yaml:"http"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+	Introspect   IntrospectConfig   `yaml:"introspect"`
+	JWT          JWTConfig          `yaml:"jwt"`
+	TOS          TOSConfig          `yaml:"tos"`
+	Webhooks     WebhookConfig      `yaml:"webhooks"`
+	Audit        AuditConfig        `yaml:"audit"`
+	Health       HealthConfig       `yaml:"health"`
+	Registration RegistrationConfig `yaml:"registration"`
+	Login        LoginConfig        `yaml:"login"`
+	Deletion     DeletionConfig     `yaml:"deletion"`
+	Usage        UsageConfig        `yaml:"usage"`
+	Dependencies DependencyConfig   `yaml:"dependency_timeouts"`
+	Errors       ErrorsConfig       `yaml:"errors"`
+	Hashing      HashingConfig      `yaml:"hashing"`
+
+	// clamped are the settings Load replaced with their defaults.
+	clamped []Clamp
+}
+
+type StorageConfig struct {
+	ConnectTimeout     time.Duration     `yaml:"connect_timeout" env-default:"10s"`
+	CreateDir          bool              `yaml:"create_dir" env-default:"false"`
+	SlowQueryThreshold time.Duration     `yaml:"slow_query_threshold" env-default:"200ms"`
+	EncryptionKey      string            `yaml:"encryption_key" env:"STORAGE_ENCRYPTION_KEY" secret:"true"`
+	ReadConns          int               `yaml:"read_conns" env-default:"4"`
+	MigrationsTable    string            `yaml:"migrations_table" env-default:"migrations"`
+	IntegrityCheck     string            `yaml:"integrity_check" env-default:"off"`
+	Backup             BackupConfig      `yaml:"backup"`
+	Maintenance        MaintenanceConfig `yaml:"maintenance"`
+	ReadRetry          ReadRetryConfig   `yaml:"read_retry"`
+	Replica            ReplicaConfig     `yaml:"replica"`
+	// AllowShared starts the server on a storage file another instance
+	// holds, without the background jobs that write. Also set by the
+	// --allow-shared-sqlite flag.
+	AllowShared bool `yaml:"allow_shared" env:"ALLOW_SHARED_SQLITE" env-default:"false"`
+	// SchemaCompat is the phase, expand or contract, of each change of
+	// columns in progress, see sqlite.Phase. It is flipped to contract
+	// once every instance runs a binary that knows the new columns and
+	// they are backfilled.
+	SchemaCompat SchemaCompatConfig `yaml:"schema_compat"`
+}
+
+// SchemaCompatConfig is the phase of each change of columns, see
+// sqlite.Compat. Canonical emails contract by default, as they did before
+// the phases: expand them only while binaries older than migration 018
+// still serve.
+type SchemaCompatConfig struct {
+	CanonicalEmail string `yaml:"canonical_email" env-default:"contract"`
+	HashVersion    string `yaml:"hash_version" env-default:"expand"`
+}
+
+// ReadRetryConfig retries once the storage reads safe to repeat that fail
+// in a way worth retrying, if the request has MinBudget left before its
+// deadline. One request retries PerRequest reads at most.
+type ReadRetryConfig struct {
+	Enabled    bool          `yaml:"enabled" env-default:"false"`
+	MinBudget  time.Duration `yaml:"min_budget" env-default:"250ms"`
+	PerRequest int           `yaml:"per_request" env-default:"2"`
+}
+
+// ReplicaConfig copies the writes to the users, apps, roles, permissions
+// and signing keys to a secondary SQLite file at Path right after they
+// commit, as a warm standby. Empty Path disables it.
+//
+// Replication is best effort and no substitute for backups: a failed copy
+// is logged and counted but never fails the write, and changes not yet
+// copied when the process stops are lost. Seed the replica with ssoctl
+// backup and repair it with ssoctl replica reconcile.
+type ReplicaConfig struct {
+	Path string `yaml:"path"`
+}
+
+type BackupConfig struct {
+	Enabled  bool          `yaml:"enabled" env-default:"false"`
+	Dir      string        `yaml:"dir" env-default:"./storage/backups"`
+	Interval time.Duration `yaml:"interval" env-default:"24h"`
+	Keep     int           `yaml:"keep" env-default:"7"`
+}
+
+type MaintenanceConfig struct {
+	Interval    time.Duration `yaml:"interval" env-default:"1h"`
+	Window      string        `yaml:"window" env-default:"03:00-05:00"`
+	MaxDuration time.Duration `yaml:"max_duration" env-default:"1m"`
+}
+
+type JWTConfig struct {
+	Algorithm          string        `yaml:"algorithm" env-default:"HS256"`
+	Issuer             string        `yaml:"issuer"`
+	AcceptedIssuers    []string      `yaml:"accepted_issuers"`
+	AllowMissingIssuer bool          `yaml:"allow_missing_issuer" env-default:"false"`
+	Leeway             time.Duration `yaml:"leeway" env-default:"30s"`
+	MaxTokenTTL        time.Duration `yaml:"max_token_ttl" env-default:"168h"`
+	KeyRotation        time.Duration `yaml:"key_rotation" env-default:"720h"`
+	KeyRefreshPeriod   time.Duration `yaml:"key_refresh_period" env-default:"1m"`
+	// HS256Until, with algorithm RS256, keeps accepting HS256 tokens until
+	// then, for the switch from HS256. Zero rejects them outright.
+	HS256Until time.Time `yaml:"hs256_until"`
+	// IssuanceRetention is how long the fingerprints of issued tokens are
+	// kept after the tokens expire, for auth.LookupToken.
+	IssuanceRetention time.Duration `yaml:"issuance_retention" env-default:"720h"`
+	// RevocationCacheTTL is how long an instance trusts its answer on
+	// whether a token was logged out: the longest a token logged out on
+	// another instance is still accepted here.
+	RevocationCacheTTL time.Duration `yaml:"revocation_cache_ttl" env-default:"5s"`
+}
+
+type TOSConfig struct {
+	RequiredVersion string `yaml:"required_version"`
+	EnforceOnLogin  bool   `yaml:"enforce_on_login" env-default:"false"`
+}
+
+type WebhookConfig struct {
+	Enabled     bool          `yaml:"enabled" env-default:"false"`
+	Interval    time.Duration `yaml:"interval" env-default:"10s"`
+	Timeout     time.Duration `yaml:"timeout" env-default:"5s"`
+	MaxFailures int           `yaml:"max_failures" env-default:"10"`
+}
+
+// AuditConfig exports the lifecycle events recorded in the outbox to the
+// sinks enabled, as they happen. A sink may lag QueueSize events behind
+// before it misses some.
+type AuditConfig struct {
+	QueueSize int               `yaml:"queue_size" env-default:"1024"`
+	File      AuditFileConfig   `yaml:"file"`
+	Syslog    AuditSyslogConfig `yaml:"syslog"`
+}
+
+// AuditFileConfig writes a JSON object per line to Path, rotated past
+// MaxSizeMB, Keep rotated files being kept.
+type AuditFileConfig struct {
+	Enabled   bool   `yaml:"enabled" env-default:"false"`
+	Path      string `yaml:"path" env-default:"./storage/audit.jsonl"`
+	MaxSizeMB int    `yaml:"max_size_mb" env-default:"100"`
+	Keep      int    `yaml:"keep" env-default:"5"`
+}
+
+// AuditSyslogConfig sends a JSON object per message to the syslog daemon
+// at Address over Network, or to the local one if Network is empty.
+type AuditSyslogConfig struct {
+	Enabled bool   `yaml:"enabled" env-default:"false"`
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag" env-default:"sso"`
+}
+
+// Enabled reports whether any sink is.
+func (c AuditConfig) Enabled() bool {
+	return c.File.Enabled || c.Syslog.Enabled
+}
+
+type RegistrationConfig struct {
+	Mode           string   `yaml:"mode" env-default:"open"`
+	AllowedDomains []string `yaml:"allowed_domains"`
+	DeniedDomains  []string `yaml:"denied_domains"`
+	// Reserved are the email local parts nobody may register with, see
+	// reserved.New for the syntax.
+	Reserved     []string `yaml:"reserved" env-default:"admin*,root,support*,help*,noreply,postmaster,hostmaster,webmaster,abuse,security"`
+	ConcealUsers bool     `yaml:"conceal_existing_users" env-default:"false"`
+	// ActivationTTL is how long the activation tokens of pre-registered
+	// accounts are valid.
+	ActivationTTL   time.Duration        `yaml:"activation_ttl" env-default:"720h"`
+	CanonicalEmails CanonicalEmailConfig `yaml:"canonical_emails"`
+}
+
+// CanonicalEmailConfig refuses registrations with an email whose
+// canonical form is another user's. The rules of emailaddr.KnownProviders
+// apply unless Domains overrides them: per domain "plus" strips the plus
+// suffixes, "dots" ignores the dots, "plus,dots" does both and "none"
+// neither.
+type CanonicalEmailConfig struct {
+	Enabled bool              `yaml:"enabled" env-default:"false"`
+	Domains map[string]string `yaml:"domains"`
+}
+
+// Rules returns the canonicalization rules by domain.
+func (c CanonicalEmailConfig) Rules() (map[string]emailaddr.Rule, error) {
+	rules := maps.Clone(emailaddr.KnownProviders)
+	for domain, raw := range c.Domains {
+		rule, err := emailaddr.ParseRule(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", domain, err)
+		}
+		rules[emailaddr.Normalize(domain)] = rule
+	}
+
+	return rules, nil
+}
+
+type LoginConfig struct {
+	FailureFloor  time.Duration `yaml:"failure_floor" env-default:"0s"`
+	FailureJitter time.Duration `yaml:"failure_jitter" env-default:"0s"`
+	Anomaly       AnomalyConfig `yaml:"anomaly"`
+	Tarpit        TarpitConfig  `yaml:"tarpit"`
+}
+
+// TarpitConfig delays the logins refused for their credentials: the first
+// Free failures of an email or IP within Window are not, the next by Base,
+// doubling up to Max.
+type TarpitConfig struct {
+	Enabled    bool          `yaml:"enabled" env-default:"false"`
+	Window     time.Duration `yaml:"window" env-default:"15m"`
+	Free       int           `yaml:"free" env-default:"2"`
+	Base       time.Duration `yaml:"base" env-default:"1s"`
+	Max        time.Duration `yaml:"max" env-default:"10s"`
+	MaxTracked int           `yaml:"max_tracked" env-default:"10000"`
+}
+
+// AnomalyConfig flags login sprays, one IP failing against SprayThreshold
+// emails within Window, and targeted attacks, one email attempted from
+// TargetThreshold IPs. With Escalate the flagged accounts must pass the
+// CAPTCHA until they log in.
+type AnomalyConfig struct {
+	Enabled         bool          `yaml:"enabled" env-default:"false"`
+	Window          time.Duration `yaml:"window" env-default:"10m"`
+	SprayThreshold  int           `yaml:"spray_threshold" env-default:"10"`
+	TargetThreshold int           `yaml:"target_threshold" env-default:"5"`
+	MaxTracked      int           `yaml:"max_tracked" env-default:"10000"`
+	History         int           `yaml:"history" env-default:"32"`
+	Escalate        bool          `yaml:"escalate" env-default:"false"`
+	Captcha         CaptchaConfig `yaml:"captcha"`
+}
+
+// CaptchaConfig is the siteverify endpoint of the CAPTCHA provider.
+type CaptchaConfig struct {
+	VerifyURL string        `yaml:"verify_url"`
+	Secret    string        `yaml:"secret" env:"CAPTCHA_SECRET" secret:"true"`
+	Timeout   time.Duration `yaml:"timeout" env-default:"3s"`
+}
+
+type DeletionConfig struct {
+	GracePeriod time.Duration `yaml:"grace_period" env-default:"336h"`
+	Interval    time.Duration `yaml:"interval" env-default:"1h"`
+}
+
+// UsageConfig counts the logins and tokens of every app by day, see the
+// GetAppUsage admin method. The counts are written every FlushInterval
+// and on shutdown. The storage maintenance trims the days older than
+// Retention; zero keeps them all.
+type UsageConfig struct {
+	Enabled       bool          `yaml:"enabled" env-default:"true"`
+	FlushInterval time.Duration `yaml:"flush_interval" env-default:"10s"`
+	Retention     time.Duration `yaml:"retention" env-default:"9600h"`
+}
+
+// HashingConfig tunes the password hashes of new passwords. Existing
+// hashes keep verifying at the cost they were made with.
+type HashingConfig struct {
+	BcryptCost int `yaml:"bcrypt_cost" env-default:"10"`
+}
+
+type DependencyConfig struct {
+	Storage  time.Duration `yaml:"storage" env-default:"2s"`
+	Hashing  time.Duration `yaml:"hashing" env-default:"3s"`
+	Notifier time.Duration `yaml:"notifier" env-default:"5s"`
+}
+
+type HealthConfig struct {
+	Interval time.Duration `yaml:"interval" env-default:"5s"`
+	MaxAge   time.Duration `yaml:"max_age" env-default:"15s"`
+}
+
+type DebugConfig struct {
+	Enabled bool   `yaml:"enabled" env-default:"false"`
+	Address string `yaml:"address" env-default:"127.0.0.1:6060"`
+}
+
+// HTTPConfig is the public HTTP server of /v1/introspect and the OIDC
+// discovery documents, apart from the debug server so that they can be
+// exposed without pprof and the configuration.
+type HTTPConfig struct {
+	Enabled bool   `yaml:"enabled" env-default:"false"`
+	Address string `yaml:"address" env-default:":8080"`
+}
+
+// Histograms are the histograms whose buckets MetricsConfig can set.
+var Histograms = []string{
+	"storage_query_duration_seconds",
+	"password_hash_duration_seconds",
+}
+
+// MetricsConfig tunes the histograms served on the debug server. Buckets
+// sets the upper bounds, in seconds, of the histograms named, see
+// Histograms; the others keep tuned defaults. Exemplars keeps the request
+// ID of an observation per bucket, so a slow one can be looked up in the
+// logs.
+type MetricsConfig struct {
+	Buckets   map[string][]float64 `yaml:"buckets"`
+	Exemplars bool                 `yaml:"exemplars" env-default:"false"`
+}
+
+// IntrospectConfig serves GET /v1/introspect on the HTTP server, for
+// edge proxies checking tokens over HTTP. Valid tokens are cached for
+// CacheTTL, which also bounds how long a revocation made by another
+// instance takes to show; zero disables the cache.
+type IntrospectConfig struct {
+	Enabled   bool          `yaml:"enabled" env-default:"false"`
+	CacheTTL  time.Duration `yaml:"cache_ttl" env-default:"10s"`
+	CacheSize int           `yaml:"cache_size" env-default:"10000"`
+}
+
+// ErrorsConfig shapes the errors clients get. Verbose adds the request ID
+// to every error and the sanitized op labels of the cause to internal
+// ones; it is meant for staging, never production.
+type ErrorsConfig struct {
+	Verbose bool `yaml:"verbose" env-default:"false"`
 }
 
 type GRPCConfig struct {
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Port           int                      `yaml:"port"`
+	Timeout        time.Duration            `yaml:"timeout"`
+	MethodTimeouts map[string]time.Duration `yaml:"method_timeouts" env-default:"Login:5s,Register:5s,UserRole:1s,UserExists:1s"`
+	Keepalive      KeepaliveConfig          `yaml:"keepalive"`
+	MaxStreams     uint32                   `yaml:"max_concurrent_streams" env-default:"100"`
+	MaxHeaderList  uint32                   `yaml:"max_header_list_size" env-default:"16384"`
+	MaxConnections int                      `yaml:"max_connections" env-default:"1024"`
+	Listen         []string                 `yaml:"listen"`
+	SocketMode     string                   `yaml:"socket_mode" env-default:"0660"`
+	BindRetries    int                      `yaml:"bind_retries" env-default:"5"`
+	BindBackoff    time.Duration            `yaml:"bind_backoff" env-default:"250ms"`
+	Reflection     bool                     `yaml:"reflection"`
+	TrustedProxies []string                 `yaml:"trusted_proxies"`
+	LogPayloads    bool                     `yaml:"log_payloads" env-default:"false"`
+	// UnknownFields is off, warn or reject, see
+	// interceptors.UnknownFieldsMode. Empty rejects in local, where a
+	// client ahead of the server is a mistake to fix, and warns
+	// elsewhere, where it is a rollout in progress.
+	UnknownFields string            `yaml:"unknown_fields"`
+	AppAuth       []string          `yaml:"app_auth"`
+	Nonces        NonceConfig       `yaml:"nonces"`
+	DecisionLog   DecisionLogConfig `yaml:"decision_log"`
+	Admin         AdminGRPCConfig   `yaml:"admin"`
+	// Interceptors orders the built-in interceptors, outermost first, and
+	// leaves out those not listed, see grpcapp.WithChain. Empty keeps
+	// grpcapp.DefaultChain.
+	Interceptors []string `yaml:"interceptors"`
+}
+
+// AdminGRPCConfig moves the admin services, sso.admin.v1.Admin and
+// sso.quota.v1.Quotas, off the public server onto Listen, specs like
+// those of grpc.listen. Empty keeps them on the public server. There,
+// their methods require the admin role even without a policy, and TLS
+// may require client certificates.
+type AdminGRPCConfig struct {
+	Listen []string      `yaml:"listen"`
+	TLS    GRPCTLSConfig `yaml:"tls"`
+	// CursorKey signs the page tokens of ListUsers, at least 16 bytes.
+	// Instances serving the same storage share it; empty makes a random
+	// one per start, so tokens work only on the instance that made them.
+	CursorKey string `yaml:"cursor_key" env:"ADMIN_CURSOR_KEY" secret:"true"`
+}
+
+// GRPCTLSConfig are PEM files. ClientCAFile, if set, requires clients to
+// present a certificate it signed.
+type GRPCTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// DecisionLogConfig logs the decisions of the method policies at debug
+// level, at most PerSecond a second, and explains denials to admins who
+// send the x-explain-authorization header.
+type DecisionLogConfig struct {
+	Enabled   bool `yaml:"enabled" env-default:"false"`
+	PerSecond int  `yaml:"per_second" env-default:"10"`
+}
+
+// NonceConfig requires one-time nonces for Methods. The instance keeps at
+// most MaxEntries of them, MaxPerClient of each client IP, so that a
+// single client cannot lock the others out.
+type NonceConfig struct {
+	Methods      []string      `yaml:"methods"`
+	TTL          time.Duration `yaml:"ttl" env-default:"10m"`
+	MaxEntries   int           `yaml:"max_entries" env-default:"100000"`
+	MaxPerClient int           `yaml:"max_per_client" env-default:"1000"`
+}
+
+type KeepaliveConfig struct {
+	MinTime               time.Duration `yaml:"min_time" env-default:"30s"`
+	PermitWithoutStream   bool          `yaml:"permit_without_stream" env-default:"false"`
+	MaxConnectionIdle     time.Duration `yaml:"max_connection_idle" env-default:"5m"`
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age" env-default:"30m"`
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace" env-default:"10s"`
+	Time                  time.Duration `yaml:"time" env-default:"2h"`
+	Timeout               time.Duration `yaml:"timeout" env-default:"20s"`
 }
 
 func MustLoad() *Config {
-	path := fetchConfigPath()
+	path, allowShared := fetchFlags()
 	if path == "" {
 		panic("config path is empty")
 	}
 
-	return MustLoadByPath(path)
+	cfg := MustLoadByPath(path)
+	cfg.Storage.AllowShared = cfg.Storage.AllowShared || allowShared
+
+	return cfg
 }
 
 func MustLoadByPath(configPath string) *Config {
@@ -34,27 +426,45 @@ func MustLoadByPath(configPath string) *Config {
 		panic("config file does not exist: " + configPath)
 	}
 
-	var cfg Config
+	cfg, err := Load(configPath)
+	if err != nil {
+		panic("failed to load config: " + err.Error())
+	}
+
+	return cfg
+}
+
+// Load reads and validates the config file at configPath. Every setting
+// that is invalid is reported in the one error, see validate.
+func Load(configPath string) (*Config, error) {
+	cfg := Config{Path: configPath}
 
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
-		panic("failed to load config: " + err.Error())
+		return nil, err
+	}
+	clamp(&cfg)
+	if err := validate(&cfg); err != nil {
+		return nil, err
 	}
 
-	return &cfg
+	return &cfg, nil
 }
 
-// fetchConfigPath fetches config path from command line flag or environment variable
+// fetchFlags fetches config path from command line flag or environment
+// variable, and the --allow-shared-sqlite flag.
 // Priority: flag > env > default
 // Default value: is empty string
-func fetchConfigPath() string {
+func fetchFlags() (string, bool) {
 	var res string
+	var allowShared bool
 
 	// --config="path/to/config.yaml"
 	flag.StringVar(&res, "config", "", "path to config file")
+	flag.BoolVar(&allowShared, "allow-shared-sqlite", false, "start even if another instance holds the storage file")
 	flag.Parse()
 
 	if res == "" {
 		res = os.Getenv("CONFIG_PATH")
 	}
-	return res
+	return res, allowShared
 }
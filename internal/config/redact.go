@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "***"
+
+// Redact returns v as a tree of maps keyed by yaml field names, with every
+// non-empty field tagged `secret:"true"` replaced by "***". It is meant for
+// dumping the effective configuration to logs and debug endpoints.
+func Redact(v any) any {
+	return redactValue(reflect.ValueOf(v))
+}
+
+func redactValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redactValue(v.Elem())
+	case reflect.Struct:
+		res := make(map[string]any, v.NumField())
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				name = field.Name
+			}
+
+			if field.Tag.Get("secret") == "true" {
+				if v.Field(i).IsZero() {
+					res[name] = ""
+				} else {
+					res[name] = redacted
+				}
+				continue
+			}
+
+			res[name] = redactValue(v.Field(i))
+		}
+		return res
+	case reflect.Map:
+		res := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			res[iter.Key().String()] = redactValue(iter.Value())
+		}
+		return res
+	case reflect.Slice, reflect.Array:
+		res := make([]any, v.Len())
+		for i := range v.Len() {
+			res[i] = redactValue(v.Index(i))
+		}
+		return res
+	case reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			return time.Duration(v.Int()).String()
+		}
+		return v.Interface()
+	default:
+		return v.Interface()
+	}
+}
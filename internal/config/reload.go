@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// reloadable lists the settings, by yaml path, that a running process can
+// pick up. A path covers everything below it. Keep in sync with
+// applyReloadable.
+var reloadable = []string{
+	"log_level",
+	"grpc.timeout",
+	"grpc.method_timeouts",
+	"registration.mode",
+	"registration.allowed_domains",
+	"registration.denied_domains",
+	"registration.reserved",
+	"metrics.buckets",
+}
+
+// applyReloadable returns a copy of cur with the reloadable settings of
+// next.
+func applyReloadable(cur, next *Config) *Config {
+	res := *cur
+	res.LogLevel = next.LogLevel
+	res.GRPC.Timeout = next.GRPC.Timeout
+	res.GRPC.MethodTimeouts = maps.Clone(next.GRPC.MethodTimeouts)
+	res.Registration.Mode = next.Registration.Mode
+	res.Registration.AllowedDomains = slices.Clone(next.Registration.AllowedDomains)
+	res.Registration.DeniedDomains = slices.Clone(next.Registration.DeniedDomains)
+	res.Registration.Reserved = slices.Clone(next.Registration.Reserved)
+	res.Metrics.Buckets = maps.Clone(next.Metrics.Buckets)
+
+	return &res
+}
+
+// Change is a setting that differs between two configurations. Secret
+// values are redacted.
+type Change struct {
+	Path string
+	Old  any
+	New  any
+}
+
+// Diff returns the settings that differ between a and b, sorted by path.
+func Diff(a, b *Config) []Change {
+	old, cur := make(map[string]any), make(map[string]any)
+	flatten("", Redact(a), old)
+	flatten("", Redact(b), cur)
+
+	var res []Change
+	for _, path := range slices.Sorted(maps.Keys(union(old, cur))) {
+		if !reflect.DeepEqual(old[path], cur[path]) {
+			res = append(res, Change{Path: path, Old: old[path], New: cur[path]})
+		}
+	}
+
+	return res
+}
+
+func flatten(prefix string, v any, res map[string]any) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		res[prefix] = v
+		return
+	}
+
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		flatten(k, v, res)
+	}
+}
+
+func union(a, b map[string]any) map[string]any {
+	res := maps.Clone(a)
+	maps.Copy(res, b)
+
+	return res
+}
+
+// Reloadable reports whether the setting at path can change without a
+// restart.
+func Reloadable(path string) bool {
+	for _, r := range reloadable {
+		if path == r || strings.HasPrefix(path, r+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reloader re-reads the config file of a running process and hands the
+// reloadable settings to the registered hooks.
+type Reloader struct {
+	log *slog.Logger
+
+	mu      sync.Mutex
+	current *Config
+	hooks   []func(cfg *Config)
+}
+
+// NewReloader returns a reloader of current, which must have been loaded
+// from a file.
+func NewReloader(log *slog.Logger, current *Config) *Reloader {
+	return &Reloader{log: log, current: current}
+}
+
+// OnReload registers hook, called with the new configuration on every
+// reload. Hooks only see reloadable settings change; they are called one
+// at a time.
+func (r *Reloader) OnReload(hook func(cfg *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// Reload re-reads the config file and applies the reloadable settings
+// that changed. Changes to other settings are logged and ignored until
+// the next restart. An invalid file leaves the running configuration
+// untouched. Concurrent reloads run one after another.
+func (r *Reloader) Reload() error {
+	const op = "config.Reload"
+
+	log := r.log.With(slog.String("op", op))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load(r.current.Path)
+	if err != nil {
+		log.Error("failed to reload config, keeping the running one", slog.Any("error", err))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	// --allow-shared-sqlite is not in the file.
+	next.Storage.AllowShared = next.Storage.AllowShared || r.current.Storage.AllowShared
+
+	applied := 0
+	for _, c := range Diff(r.current, next) {
+		if !Reloadable(c.Path) {
+			log.Warn("setting cannot be reloaded, restart to apply it",
+				slog.String("setting", c.Path),
+				slog.Any("running", c.Old),
+				slog.Any("file", c.New),
+			)
+			continue
+		}
+
+		log.Info("setting reloaded",
+			slog.String("setting", c.Path),
+			slog.Any("old", c.Old),
+			slog.Any("new", c.New),
+		)
+		applied++
+	}
+	if applied == 0 {
+		log.Info("config reloaded, nothing to apply")
+
+		return nil
+	}
+
+	r.current = applyReloadable(r.current, next)
+	for _, hook := range r.hooks {
+		hook(r.current)
+	}
+
+	return nil
+}
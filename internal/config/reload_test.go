@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseConfig = `
+env: prod
+storage_path: ./storage/sso.db
+token_ttl: 1h
+storage:
+  encryption_key: old-key
+grpc:
+  port: 44044
+  timeout: 10s
+  method_timeouts:
+    Login: 5s
+`
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, baseConfig)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "open", cfg.Registration.Mode)
+
+	var logs bytes.Buffer
+	r := NewReloader(slog.New(slog.NewTextHandler(&logs, nil)), cfg)
+
+	var got []*Config
+	r.OnReload(func(cfg *Config) { got = append(got, cfg) })
+
+	// Nothing changed: no hook runs.
+	require.NoError(t, r.Reload())
+	assert.Empty(t, got)
+
+	writeConfig(t, path, `
+env: prod
+log_level: warn
+storage_path: ./storage/other.db
+token_ttl: 1h
+storage:
+  encryption_key: new-key
+grpc:
+  port: 50051
+  timeout: 3s
+  method_timeouts:
+    Login: 2s
+    Register: 4s
+registration:
+  mode: invite
+  allowed_domains: [university.edu, "*.university.edu"]
+  reserved: [admin*, root]
+metrics:
+  buckets:
+    password_hash_duration_seconds: [0.05, 0.1, 0.2]
+`)
+	require.NoError(t, r.Reload())
+	require.Len(t, got, 1)
+
+	applied := got[0]
+	assert.Equal(t, "warn", applied.LogLevel)
+	assert.Equal(t, 3*time.Second, applied.GRPC.Timeout)
+	assert.Equal(t, map[string]time.Duration{"Login": 2 * time.Second, "Register": 4 * time.Second}, applied.GRPC.MethodTimeouts)
+	assert.Equal(t, "invite", applied.Registration.Mode)
+	assert.Equal(t, []string{"university.edu", "*.university.edu"}, applied.Registration.AllowedDomains)
+	assert.Equal(t, []string{"admin*", "root"}, applied.Registration.Reserved)
+	assert.Equal(t, []float64{0.05, 0.1, 0.2}, applied.Metrics.Buckets["password_hash_duration_seconds"])
+	// Immutable settings keep their running values.
+	assert.Equal(t, 44044, applied.GRPC.Port)
+	assert.Equal(t, "./storage/sso.db", applied.StoragePath)
+	assert.Equal(t, "old-key", applied.Storage.EncryptionKey)
+	assert.Same(t, applied, r.Current())
+
+	assert.Contains(t, logs.String(), "setting=grpc.port")
+	assert.Contains(t, logs.String(), "setting=storage_path")
+	assert.NotContains(t, logs.String(), "new-key")
+
+	// A broken file keeps the running config.
+	writeConfig(t, path, "log_level: loud\n"+baseConfig)
+	assert.Error(t, r.Reload())
+	assert.Len(t, got, 1)
+	assert.Same(t, applied, r.Current())
+
+	writeConfig(t, path, baseConfig+"registration:\n  mode: pilot\n")
+	assert.Error(t, r.Reload())
+	writeConfig(t, path, baseConfig+"registration:\n  denied_domains: [\"*.\"]\n")
+	assert.Error(t, r.Reload())
+	writeConfig(t, path, baseConfig+"registration:\n  reserved: [\"ad*min\"]\n")
+	assert.Error(t, r.Reload())
+	writeConfig(t, path, baseConfig+"metrics:\n  buckets:\n    password_hash_duration_seconds: [1, 0.5]\n")
+	assert.Error(t, r.Reload())
+	writeConfig(t, path, baseConfig+"metrics:\n  buckets:\n    rpc_duration_seconds: [1]\n")
+	assert.Error(t, r.Reload())
+	assert.Same(t, applied, r.Current())
+}
+
+func TestReloader_Serialized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, baseConfig)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	writeConfig(t, path, "log_level: error\n"+baseConfig)
+
+	r := NewReloader(slog.New(slog.DiscardHandler), cfg)
+
+	running, calls := 0, 0
+	r.OnReload(func(*Config) {
+		running++
+		assert.Equal(t, 1, running, "hooks overlap")
+		time.Sleep(time.Millisecond)
+		calls++
+		running--
+	})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = r.Reload()
+		}()
+	}
+	wg.Wait()
+
+	// Only the first reload finds something to apply.
+	assert.Equal(t, 1, calls)
+}
+
+func TestApplyReloadable_CoversReloadable(t *testing.T) {
+	cur := &Config{}
+	next := &Config{
+		LogLevel: "info",
+		GRPC: GRPCConfig{
+			Timeout:        time.Second,
+			MethodTimeouts: map[string]time.Duration{"Login": time.Second},
+		},
+		Registration: RegistrationConfig{
+			Mode:           "closed",
+			AllowedDomains: []string{"university.edu"},
+			DeniedDomains:  []string{"mailinator.com"},
+		},
+		Metrics: MetricsConfig{
+			Buckets: map[string][]float64{"password_hash_duration_seconds": {0.1, 1}},
+		},
+	}
+
+	for _, c := range Diff(applyReloadable(cur, next), next) {
+		assert.False(t, Reloadable(c.Path), "%s is reloadable but not applied", c.Path)
+	}
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/reserved"
+)
+
+const (
+	// MinTokenTTL and MaxTokenTTL bound token_ttl. A token shorter lived
+	// expires before clients can use it, and a longer lived one outlives
+	// any reasonable session.
+	MinTokenTTL = time.Minute
+	MaxTokenTTL = 30 * 24 * time.Hour
+	// MinBcryptCost and MaxBcryptCost bound hashing.bcrypt_cost: below,
+	// hashes are cheap to crack; above, a login takes seconds.
+	MinBcryptCost = 10
+	MaxBcryptCost = 15
+)
+
+// Clamp is a setting whose value meant nothing and that Load replaced with
+// its default, such as a negative timeout. Values are formatted as
+// durations are in the config file.
+type Clamp struct {
+	Path    string
+	Value   string
+	Default string
+}
+
+// Clamped returns the settings Load replaced with their defaults.
+func (c *Config) Clamped() []Clamp {
+	return slices.Clone(c.clamped)
+}
+
+// clamp replaces the negative durations of cfg that have a default with
+// it, as they would be a zero one, so that they show in Clamped rather than
+// being fixed up silently further down. Zero ones get their default from
+// cleanenv already.
+func clamp(cfg *Config) {
+	for _, d := range []struct {
+		path string
+		v    *time.Duration
+		def  time.Duration
+	}{
+		{"storage.connect_timeout", &cfg.Storage.ConnectTimeout, 10 * time.Second},
+		{"storage.slow_query_threshold", &cfg.Storage.SlowQueryThreshold, 200 * time.Millisecond},
+		{"grpc.bind_backoff", &cfg.GRPC.BindBackoff, 250 * time.Millisecond},
+		{"health.interval", &cfg.Health.Interval, 5 * time.Second},
+		{"health.max_age", &cfg.Health.MaxAge, 15 * time.Second},
+		{"dependency_timeouts.storage", &cfg.Dependencies.Storage, 2 * time.Second},
+		{"dependency_timeouts.hashing", &cfg.Dependencies.Hashing, 3 * time.Second},
+		{"dependency_timeouts.notifier", &cfg.Dependencies.Notifier, 5 * time.Second},
+	} {
+		if *d.v < 0 {
+			cfg.clamped = append(cfg.clamped, Clamp{Path: d.path, Value: d.v.String(), Default: d.def.String()})
+			*d.v = d.def
+		}
+	}
+}
+
+// validate returns every invalid setting of cfg, joined, so that a config
+// is fixed in one go rather than one restart per mistake.
+func validate(cfg *Config) error {
+	var problems []error
+	add := func(err error) {
+		problems = append(problems, err)
+	}
+
+	if cfg.LogLevel != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			add(fmt.Errorf("log_level: %w", err))
+		}
+	}
+	if cfg.TokenTTL < MinTokenTTL || cfg.TokenTTL > MaxTokenTTL {
+		add(fmt.Errorf("token_ttl must be between %s and %s, got %s", MinTokenTTL, MaxTokenTTL, cfg.TokenTTL))
+	}
+	if m := cfg.JWT.MaxTokenTTL; m < 0 {
+		add(errors.New("jwt.max_token_ttl must not be negative"))
+	} else if m > 0 && cfg.TokenTTL > m {
+		add(fmt.Errorf("token_ttl %s exceeds jwt.max_token_ttl %s", cfg.TokenTTL, m))
+	}
+	if cfg.JWT.Leeway < 0 {
+		add(errors.New("jwt.leeway must not be negative"))
+	}
+	if !cfg.JWT.HS256Until.IsZero() && cfg.JWT.Algorithm != "RS256" {
+		add(errors.New("jwt.hs256_until only applies to algorithm RS256"))
+	}
+	if c := cfg.Hashing.BcryptCost; c < MinBcryptCost || c > MaxBcryptCost {
+		add(fmt.Errorf("hashing.bcrypt_cost must be between %d and %d, got %d", MinBcryptCost, MaxBcryptCost, c))
+	}
+
+	switch cfg.Registration.Mode {
+	case "open", "closed", "invite":
+	default:
+		add(fmt.Errorf("registration.mode: must be open, closed or invite, got %q", cfg.Registration.Mode))
+	}
+	if _, err := emaildomain.New(cfg.Registration.AllowedDomains, cfg.Registration.DeniedDomains); err != nil {
+		add(fmt.Errorf("registration: %w", err))
+	}
+	if _, err := reserved.New(cfg.Registration.Reserved); err != nil {
+		add(fmt.Errorf("registration.reserved: %w", err))
+	}
+	if _, err := cfg.Registration.CanonicalEmails.Rules(); err != nil {
+		add(fmt.Errorf("registration.canonical_emails.domains: %w", err))
+	}
+	if cfg.Registration.ActivationTTL <= 0 {
+		add(errors.New("registration.activation_ttl must be positive"))
+	}
+
+	for _, c := range []struct{ name, phase string }{
+		{"canonical_email", cfg.Storage.SchemaCompat.CanonicalEmail},
+		{"hash_version", cfg.Storage.SchemaCompat.HashVersion},
+	} {
+		switch c.phase {
+		case "expand", "contract":
+		default:
+			add(fmt.Errorf("storage.schema_compat.%s: must be expand or contract, got %q", c.name, c.phase))
+		}
+	}
+	switch cfg.Storage.IntegrityCheck {
+	case "off", "quick", "full":
+	default:
+		add(fmt.Errorf("storage.integrity_check: must be off, quick or full, got %q", cfg.Storage.IntegrityCheck))
+	}
+	if cfg.Storage.ReadConns < 0 {
+		add(errors.New("storage.read_conns must not be negative"))
+	}
+	if r := cfg.Storage.Replica.Path; r != "" && r == cfg.StoragePath {
+		add(errors.New("storage.replica.path: the replica must not be the storage itself"))
+	}
+	if b := cfg.Storage.Backup; b.Enabled && (b.Dir == "" || b.Interval <= 0 || b.Keep <= 0) {
+		add(errors.New("storage.backup: dir is required, interval and keep must be positive"))
+	}
+	if r := cfg.Storage.ReadRetry; r.Enabled && (r.MinBudget < 0 || r.PerRequest <= 0) {
+		add(errors.New("storage.read_retry: min_budget must not be negative and per_request must be positive"))
+	}
+	if m := cfg.Storage.Maintenance; m.Interval <= 0 || m.MaxDuration <= 0 {
+		add(errors.New("storage.maintenance: interval and max_duration must be positive"))
+	}
+
+	if p := cfg.GRPC.Port; p < 0 || p > 65535 || p == 0 && len(cfg.GRPC.Listen) == 0 {
+		add(fmt.Errorf("grpc.port must be between 1 and 65535, got %d", p))
+	}
+	switch cfg.GRPC.UnknownFields {
+	case "", "off", "warn", "reject":
+	default:
+		add(fmt.Errorf("grpc.unknown_fields: must be off, warn or reject, got %q", cfg.GRPC.UnknownFields))
+	}
+	if _, err := strconv.ParseUint(cfg.GRPC.SocketMode, 8, 32); err != nil {
+		add(fmt.Errorf("grpc.socket_mode: must be octal, got %q", cfg.GRPC.SocketMode))
+	}
+	if cfg.GRPC.MaxConnections < 0 || cfg.GRPC.BindRetries < 0 {
+		add(errors.New("grpc: max_connections and bind_retries must not be negative"))
+	}
+	if d := cfg.GRPC.DecisionLog; d.Enabled && d.PerSecond <= 0 {
+		add(errors.New("grpc.decision_log.per_second must be positive"))
+	}
+	if n := cfg.GRPC.Nonces; len(n.Methods) > 0 && (n.TTL <= 0 || n.MaxEntries <= 0 || n.MaxPerClient <= 0) {
+		add(errors.New("grpc.nonces: ttl, max_entries and max_per_client must be positive"))
+	}
+	if a := cfg.GRPC.Admin; a.TLS != (GRPCTLSConfig{}) {
+		switch {
+		case len(a.Listen) == 0:
+			add(errors.New("grpc.admin.tls: the admin services have no listener of their own"))
+		case a.TLS.CertFile == "" || a.TLS.KeyFile == "":
+			add(errors.New("grpc.admin.tls: cert_file and key_file are required"))
+		}
+		for _, f := range []struct{ name, path string }{
+			{"cert_file", a.TLS.CertFile},
+			{"key_file", a.TLS.KeyFile},
+			{"client_ca_file", a.TLS.ClientCAFile},
+		} {
+			if f.path == "" {
+				continue
+			}
+			if err := readable(f.path); err != nil {
+				add(fmt.Errorf("grpc.admin.tls.%s: %w", f.name, err))
+			}
+		}
+	}
+	if k := cfg.GRPC.Admin.CursorKey; k != "" && len(k) < 16 {
+		add(errors.New("grpc.admin.cursor_key must be at least 16 bytes"))
+	}
+
+	if cfg.Debug.Enabled {
+		if err := validAddress(cfg.Debug.Address); err != nil {
+			add(fmt.Errorf("debug.address: %w", err))
+		}
+	}
+	if cfg.HTTP.Enabled {
+		if err := validAddress(cfg.HTTP.Address); err != nil {
+			add(fmt.Errorf("http.address: %w", err))
+		}
+	}
+	if i := cfg.Introspect; i.Enabled {
+		switch {
+		case !cfg.HTTP.Enabled:
+			add(errors.New("introspect: served on the http server, which is not enabled"))
+		case i.CacheTTL < 0 || i.CacheTTL > 0 && i.CacheSize <= 0:
+			add(errors.New("introspect: cache_ttl must not be negative and cache_size must be positive"))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Metrics.Buckets)) {
+		if !slices.Contains(Histograms, name) {
+			add(fmt.Errorf("metrics.buckets: unknown histogram %q", name))
+			continue
+		}
+		if err := metrics.ValidateBuckets(cfg.Metrics.Buckets[name]); err != nil {
+			add(fmt.Errorf("metrics.buckets.%s: %w", name, err))
+		}
+	}
+
+	if w := cfg.Webhooks; w.Enabled && (w.Interval <= 0 || w.Timeout <= 0 || w.MaxFailures <= 0) {
+		add(errors.New("webhooks: interval, timeout and max_failures must be positive"))
+	}
+	if d := cfg.Deletion; d.GracePeriod <= 0 || d.Interval <= 0 {
+		add(errors.New("deletion: grace_period and interval must be positive"))
+	}
+	if u := cfg.Usage; u.Enabled && (u.FlushInterval <= 0 || u.Retention < 0) {
+		add(errors.New("usage: flush_interval must be positive and retention must not be negative"))
+	}
+	if a := cfg.Login.Anomaly; a.Enabled && (a.Window <= 0 || a.SprayThreshold <= 0 || a.TargetThreshold <= 0 || a.MaxTracked <= 0 || a.History <= 0) {
+		add(errors.New("login.anomaly: window, thresholds, max_tracked and history must be positive"))
+	}
+	if t := cfg.Login.Tarpit; t.Enabled && (t.Window <= 0 || t.Free < 0 || t.Base <= 0 || t.Max < t.Base || t.MaxTracked <= 0) {
+		add(errors.New("login.tarpit: window, base and max_tracked must be positive, free must not be negative and max must be at least base"))
+	}
+	if a := cfg.Login.Anomaly; a.Enabled && a.Escalate && (a.Captcha.VerifyURL == "" || a.Captcha.Secret == "" || a.Captcha.Timeout <= 0) {
+		add(errors.New("login.anomaly.captcha: verify_url and secret are required to escalate, and timeout must be positive"))
+	}
+	if a := cfg.Audit; a.Enabled() && a.QueueSize <= 0 {
+		add(errors.New("audit.queue_size must be positive"))
+	}
+	if f := cfg.Audit.File; f.Enabled && (f.Path == "" || f.MaxSizeMB <= 0 || f.Keep < 0) {
+		add(errors.New("audit.file: path is required, max_size_mb must be positive and keep must not be negative"))
+	}
+
+	return errors.Join(problems...)
+}
+
+// readable reports why the file at path cannot be read, if it cannot.
+func readable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// validAddress reports whether addr is a host:port with a port in range.
+func validAddress(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, got %q", port)
+	}
+
+	return nil
+}
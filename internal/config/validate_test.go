@@ -0,0 +1,186 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadBase(t *testing.T) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, baseConfig)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "cert.pem")
+	writeConfig(t, cert, "cert")
+
+	tests := []struct {
+		name   string
+		mutate func(cfg *Config)
+		want   string
+	}{
+		{
+			name:   "zero token ttl",
+			mutate: func(cfg *Config) { cfg.TokenTTL = 0 },
+			want:   "token_ttl must be between 1m0s and 720h0m0s, got 0s",
+		},
+		{
+			name:   "token ttl too long",
+			mutate: func(cfg *Config) { cfg.TokenTTL = 31 * 24 * time.Hour; cfg.JWT.MaxTokenTTL = 0 },
+			want:   "token_ttl must be between",
+		},
+		{
+			name:   "token ttl over the max",
+			mutate: func(cfg *Config) { cfg.TokenTTL = 200 * time.Hour },
+			want:   "token_ttl 200h0m0s exceeds jwt.max_token_ttl 168h0m0s",
+		},
+		{
+			name:   "negative leeway",
+			mutate: func(cfg *Config) { cfg.JWT.Leeway = -time.Second },
+			want:   "jwt.leeway must not be negative",
+		},
+		{
+			name:   "hs256 rollover with hs256",
+			mutate: func(cfg *Config) { cfg.JWT.HS256Until = time.Now().Add(time.Hour) },
+			want:   "jwt.hs256_until only applies to algorithm RS256",
+		},
+		{
+			name:   "bcrypt cost too low",
+			mutate: func(cfg *Config) { cfg.Hashing.BcryptCost = 4 },
+			want:   "hashing.bcrypt_cost must be between 10 and 15, got 4",
+		},
+		{
+			name:   "bcrypt cost too high",
+			mutate: func(cfg *Config) { cfg.Hashing.BcryptCost = 16 },
+			want:   "hashing.bcrypt_cost must be between 10 and 15, got 16",
+		},
+		{
+			name:   "port out of range",
+			mutate: func(cfg *Config) { cfg.GRPC.Port = 70000 },
+			want:   "grpc.port must be between 1 and 65535, got 70000",
+		},
+		{
+			name:   "no port nor listener",
+			mutate: func(cfg *Config) { cfg.GRPC.Port = 0 },
+			want:   "grpc.port must be between 1 and 65535, got 0",
+		},
+		{
+			name:   "debug port out of range",
+			mutate: func(cfg *Config) { cfg.Debug = DebugConfig{Enabled: true, Address: "127.0.0.1:99999"} },
+			want:   `debug.address: port must be between 0 and 65535, got "99999"`,
+		},
+		{
+			name:   "http port out of range",
+			mutate: func(cfg *Config) { cfg.HTTP = HTTPConfig{Enabled: true, Address: ":99999"} },
+			want:   `http.address: port must be between 0 and 65535, got "99999"`,
+		},
+		{
+			name:   "socket mode",
+			mutate: func(cfg *Config) { cfg.GRPC.SocketMode = "rw" },
+			want:   `grpc.socket_mode: must be octal, got "rw"`,
+		},
+		{
+			name:   "negative connection limit",
+			mutate: func(cfg *Config) { cfg.GRPC.MaxConnections = -1 },
+			want:   "grpc: max_connections and bind_retries must not be negative",
+		},
+		{
+			name:   "decision log rate",
+			mutate: func(cfg *Config) { cfg.GRPC.DecisionLog = DecisionLogConfig{Enabled: true} },
+			want:   "grpc.decision_log.per_second must be positive",
+		},
+		{
+			name:   "webhook limits",
+			mutate: func(cfg *Config) { cfg.Webhooks.Enabled = true; cfg.Webhooks.MaxFailures = 0 },
+			want:   "webhooks: interval, timeout and max_failures must be positive",
+		},
+		{
+			name: "unreadable tls file",
+			mutate: func(cfg *Config) {
+				cfg.GRPC.Admin.Listen = []string{"tcp://:44045"}
+				cfg.GRPC.Admin.TLS = GRPCTLSConfig{CertFile: cert, KeyFile: filepath.Join(dir, "key.pem")}
+			},
+			want: "grpc.admin.tls.key_file: open " + filepath.Join(dir, "key.pem"),
+		},
+		{
+			name:   "replica on the storage",
+			mutate: func(cfg *Config) { cfg.Storage.Replica.Path = cfg.StoragePath },
+			want:   "storage.replica.path: the replica must not be the storage itself",
+		},
+		{
+			name:   "backups kept",
+			mutate: func(cfg *Config) { cfg.Storage.Backup.Enabled = true; cfg.Storage.Backup.Keep = 0 },
+			want:   "storage.backup: dir is required, interval and keep must be positive",
+		},
+		{
+			name:   "introspection without http server",
+			mutate: func(cfg *Config) { cfg.Introspect.Enabled = true; cfg.Debug.Enabled = true },
+			want:   "introspect: served on the http server, which is not enabled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadBase(t)
+			require.NoError(t, validate(cfg))
+
+			tt.mutate(cfg)
+			assert.ErrorContains(t, validate(cfg), tt.want)
+		})
+	}
+}
+
+func TestLoad_AllViolations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+storage_path: ./storage/sso.db
+token_ttl: 30s
+hashing:
+  bcrypt_cost: 4
+grpc:
+  port: 44044
+  decision_log:
+    enabled: true
+    per_second: -1
+`)
+
+	_, err := Load(path)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "token_ttl must be between")
+	assert.ErrorContains(t, err, "hashing.bcrypt_cost must be between")
+	assert.ErrorContains(t, err, "grpc.decision_log.per_second must be positive")
+}
+
+func TestLoad_Clamped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, `
+storage_path: ./storage/sso.db
+token_ttl: 1h
+grpc:
+  port: 44044
+  bind_backoff: -1s
+health:
+  max_age: -15s
+`)
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, cfg.GRPC.BindBackoff)
+	assert.Equal(t, 15*time.Second, cfg.Health.MaxAge)
+	assert.Equal(t, []Clamp{
+		{Path: "grpc.bind_backoff", Value: "-1s", Default: "250ms"},
+		{Path: "health.max_age", Value: "-15s", Default: "15s"},
+	}, cfg.Clamped())
+
+	assert.Empty(t, loadBase(t).Clamped())
+}
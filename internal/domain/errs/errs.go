@@ -0,0 +1,254 @@
+// Package errs is the error type shared by storage, services and
+// transports. Every failure a caller may act on has a stable Code; the
+// layers only add the operation on the way up, and transports map the
+// code once, see grpc/grpcerr.
+package errs
+
+import (
+	"errors"
+	"strings"
+)
+
+// Code identifies a kind of failure. Codes are stable: they are logged and
+// may be shown to clients.
+type Code string
+
+const (
+	Internal           Code = "INTERNAL"
+	InvalidArgument    Code = "INVALID_ARGUMENT"
+	UserNotFound       Code = "USER_NOT_FOUND"
+	UserExists         Code = "USER_EXISTS"
+	RoleNotFound       Code = "ROLE_NOT_FOUND"
+	InvalidCredentials Code = "INVALID_CREDENTIALS"
+	AppNotFound        Code = "APP_NOT_FOUND"
+	InvalidToken       Code = "INVALID_TOKEN"
+	// TokenBindingMismatch is a token bound to a client certificate, see
+	// jwt.CertBinding, presented over a connection without it.
+	TokenBindingMismatch Code = "TOKEN_BINDING_MISMATCH"
+	NotAdmin             Code = "NOT_ADMIN"
+	// NoCaller is a call a service method requires the authenticated
+	// caller of, see authctx, that has none.
+	NoCaller      Code = "NO_CALLER"
+	Locked        Code = "LOCKED"
+	BatchTooLarge Code = "BATCH_TOO_LARGE"
+	RangeTooLarge Code = "RANGE_TOO_LARGE"
+
+	TermsVersionMismatch  Code = "TOS_VERSION_MISMATCH"
+	TermsReacceptRequired Code = "TOS_REACCEPT_REQUIRED"
+
+	RedirectURINotAllowed Code = "REDIRECT_URI_NOT_ALLOWED"
+	RedirectURIMismatch   Code = "REDIRECT_URI_MISMATCH"
+	AuthorizationNotFound Code = "AUTHORIZATION_NOT_FOUND"
+	AuthorizationExpired  Code = "AUTHORIZATION_EXPIRED"
+	AuthorizationUsed     Code = "AUTHORIZATION_USED"
+	InvalidCode           Code = "INVALID_CODE"
+	CodeExpired           Code = "CODE_EXPIRED"
+
+	WebhookNotFound Code = "WEBHOOK_NOT_FOUND"
+
+	RegistrationDisabled Code = "REGISTRATION_DISABLED"
+	InviteRequired       Code = "INVITE_REQUIRED"
+	InvalidInvite        Code = "INVALID_INVITE"
+	InviteExpired        Code = "INVITE_EXPIRED"
+	InviteNotFound       Code = "INVITE_NOT_FOUND"
+	InviteUsedUp         Code = "INVITE_USED_UP"
+
+	EmailDomainNotAllowed Code = "EMAIL_DOMAIN_NOT_ALLOWED"
+	// CanonicalEmailExists is an email whose canonical form, see
+	// auth.WithCanonicalEmails, is another user's, as opposed to
+	// UserExists for the same email.
+	CanonicalEmailExists Code = "CANONICAL_EMAIL_EXISTS"
+	// ReservedIdentifier is an email whose local part is reserved, see
+	// auth.WithReservedIdentifiers.
+	ReservedIdentifier Code = "RESERVED_IDENTIFIER"
+
+	UnknownProvider  Code = "UNKNOWN_PROVIDER"
+	IdentityLinked   Code = "IDENTITY_LINKED"
+	IdentityNotFound Code = "IDENTITY_NOT_FOUND"
+	LastLoginMethod  Code = "LAST_LOGIN_METHOD"
+
+	// AccountPendingDeletion carries the deletion deadline in the
+	// delete_after metadata, RFC 3339.
+	AccountPendingDeletion   Code = "ACCOUNT_PENDING_DELETION"
+	InvalidReactivationToken Code = "INVALID_REACTIVATION_TOKEN"
+
+	// ChallengeRequired carries the challenge a login has to pass in the
+	// challenge_type, challenge_token and expires_at metadata, plus what
+	// the type needs, see models.ChallengeType.
+	ChallengeRequired Code = "CHALLENGE_REQUIRED"
+	InvalidChallenge  Code = "INVALID_CHALLENGE"
+
+	PermissionNotFound Code = "PERMISSION_NOT_FOUND"
+
+	// TokenNotIssued is a token or fingerprint no issuance was recorded
+	// for, or whose record was purged, see auth.WithTokenIssuances.
+	TokenNotIssued Code = "TOKEN_NOT_ISSUED"
+
+	OrgUnitNotFound Code = "ORG_UNIT_NOT_FOUND"
+	OrgUnitExists   Code = "ORG_UNIT_EXISTS"
+	// OrgUnitCycle is a move of an org unit under itself or one of its
+	// descendants.
+	OrgUnitCycle Code = "ORG_UNIT_CYCLE"
+
+	AccountNotActivated    Code = "ACCOUNT_NOT_ACTIVATED"
+	AccountActivated       Code = "ACCOUNT_ALREADY_ACTIVATED"
+	InvalidActivationToken Code = "INVALID_ACTIVATION_TOKEN"
+
+	// QuotaExceeded is a registration for an app whose max_users are all
+	// taken. The metadata carries the max_users.
+	QuotaExceeded Code = "QUOTA_EXCEEDED"
+
+	// CaptchaRequired is a login of an account flagged as under attack
+	// without a CAPTCHA response, or with one that did not pass.
+	CaptchaRequired Code = "CAPTCHA_REQUIRED"
+
+	// DependencyTimeout is a dependency of a service that did not answer
+	// in time, as opposed to the deadline of the request expiring.
+	DependencyTimeout Code = "DEPENDENCY_TIMEOUT"
+	// StorageUnavailable is a storage failure that may pass when retried,
+	// such as a busy database, see storage.Classify.
+	StorageUnavailable Code = "STORAGE_UNAVAILABLE"
+	// SchemaDrift is a storage whose schema is not the one the code relies
+	// on, as after a migration that failed halfway.
+	SchemaDrift Code = "SCHEMA_DRIFT"
+)
+
+// Error is an error with a code. Op is the operation that failed, Message
+// a human-readable description and Err the underlying error, any of which
+// may be empty.
+type Error struct {
+	Code    Code
+	Op      string
+	Message string
+	Err     error
+	// Metadata are details clients may act on, such as a deadline.
+	// Transports pass them on, and the message of InvalidArgument
+	// errors only, see MetadataOf.
+	Metadata map[string]string
+}
+
+// New returns a sentinel error: one without an operation or a cause.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap records that op failed with err, keeping the code of err. Errors
+// without a code become Internal. A nil err gives nil.
+func Wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Code: CodeOf(err), Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, 0, 3)
+	if e.Op != "" {
+		parts = append(parts, e.Op)
+	}
+	if e.Message != "" {
+		parts = append(parts, e.Message)
+	}
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	if len(parts) == 0 {
+		return string(e.Code)
+	}
+
+	return strings.Join(parts, ": ")
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a sentinel with the same code, so equal
+// sentinels declared in different packages match each other.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t.Op != "" || t.Err != nil {
+		return false
+	}
+
+	return t.Code == e.Code
+}
+
+// CodeOf returns the code of the outermost Error in the chain of err, or
+// Internal if there is none.
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return Internal
+}
+
+// MetadataOf returns the metadata of the first Error in the chain of err
+// that has any, or nil.
+func MetadataOf(err error) map[string]string {
+	for err != nil {
+		if e, ok := err.(*Error); ok && len(e.Metadata) > 0 {
+			return e.Metadata
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return nil
+}
+
+// Sentinels shared by every layer.
+var (
+	ErrUserNotFound             = New(UserNotFound, "user not found")
+	ErrUserExists               = New(UserExists, "user already exists")
+	ErrRoleNotFound             = New(RoleNotFound, "role not found")
+	ErrInvalidCredentials       = New(InvalidCredentials, "invalid credentials")
+	ErrAppNotFound              = New(AppNotFound, "app not found")
+	ErrInvalidToken             = New(InvalidToken, "invalid token")
+	ErrTokenBindingMismatch     = New(TokenBindingMismatch, "token is bound to another client certificate")
+	ErrNotAdmin                 = New(NotAdmin, "user is not an admin")
+	ErrNoCaller                 = New(NoCaller, "call has no authenticated caller")
+	ErrLocked                   = New(Locked, "account is locked")
+	ErrBatchTooLarge            = New(BatchTooLarge, "too many user ids in one request")
+	ErrRangeTooLarge            = New(RangeTooLarge, "time range is too large")
+	ErrTermsVersionMismatch     = New(TermsVersionMismatch, "terms of service version is not the required one")
+	ErrTermsReacceptRequired    = New(TermsReacceptRequired, "terms of service must be accepted again")
+	ErrRedirectURINotAllowed    = New(RedirectURINotAllowed, "redirect uri is not allowed for the app")
+	ErrRedirectURIMismatch      = New(RedirectURIMismatch, "redirect uri does not match the authorization")
+	ErrAuthorizationNotFound    = New(AuthorizationNotFound, "authorization not found")
+	ErrAuthorizationExpired     = New(AuthorizationExpired, "authorization expired")
+	ErrAuthorizationUsed        = New(AuthorizationUsed, "authorization code already used")
+	ErrInvalidCode              = New(InvalidCode, "invalid authorization code")
+	ErrCodeExpired              = New(CodeExpired, "authorization code expired")
+	ErrWebhookNotFound          = New(WebhookNotFound, "webhook not found")
+	ErrRegistrationDisabled     = New(RegistrationDisabled, "registration is disabled")
+	ErrInviteRequired           = New(InviteRequired, "invite code is required")
+	ErrInvalidInvite            = New(InvalidInvite, "invalid invite code")
+	ErrInviteExpired            = New(InviteExpired, "invite code expired")
+	ErrInviteNotFound           = New(InviteNotFound, "invite not found")
+	ErrInviteUsedUp             = New(InviteUsedUp, "invite has no uses left")
+	ErrEmailDomainNotAllowed    = New(EmailDomainNotAllowed, "email domain is not allowed")
+	ErrReservedIdentifier       = New(ReservedIdentifier, "email is reserved")
+	ErrCanonicalEmailExists     = New(CanonicalEmailExists, "user with an equivalent email already exists")
+	ErrUnknownProvider          = New(UnknownProvider, "unknown identity provider")
+	ErrIdentityLinked           = New(IdentityLinked, "identity is linked to another account")
+	ErrIdentityNotFound         = New(IdentityNotFound, "identity not found")
+	ErrLastLoginMethod          = New(LastLoginMethod, "cannot remove the last login method")
+	ErrAccountPendingDeletion   = New(AccountPendingDeletion, "account is pending deletion")
+	ErrInvalidReactivationToken = New(InvalidReactivationToken, "invalid reactivation token")
+	ErrChallengeRequired        = New(ChallengeRequired, "login challenge must be completed")
+	ErrInvalidChallenge         = New(InvalidChallenge, "invalid or expired login challenge")
+	ErrPermissionNotFound       = New(PermissionNotFound, "permission not found")
+	ErrTokenNotIssued           = New(TokenNotIssued, "no issuance recorded for the token")
+	ErrOrgUnitNotFound          = New(OrgUnitNotFound, "org unit not found")
+	ErrOrgUnitExists            = New(OrgUnitExists, "org unit with this name already exists under the parent")
+	ErrOrgUnitCycle             = New(OrgUnitCycle, "org unit cannot be moved under itself")
+	ErrAccountNotActivated      = New(AccountNotActivated, "account is not activated")
+	ErrAccountActivated         = New(AccountActivated, "account is already activated")
+	ErrInvalidActivationToken   = New(InvalidActivationToken, "invalid or expired activation token")
+	ErrQuotaExceeded            = New(QuotaExceeded, "app user quota exceeded")
+	ErrCaptchaRequired          = New(CaptchaRequired, "captcha must be solved")
+	ErrSchemaDrift              = New(SchemaDrift, "storage schema does not match the code")
+)
@@ -0,0 +1,69 @@
+package errs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/services/auth"
+	"sso/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrap(t *testing.T) {
+	err := errs.Wrap("auth.Login", errs.Wrap("storage.sqlite.User", errs.ErrUserNotFound))
+
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+	assert.NotErrorIs(t, err, errs.ErrUserExists)
+	assert.Equal(t, errs.UserNotFound, errs.CodeOf(err))
+	assert.Equal(t, "auth.Login: storage.sqlite.User: user not found", err.Error())
+
+	var e *errs.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "auth.Login", e.Op)
+}
+
+func TestWrap_Nil(t *testing.T) {
+	assert.NoError(t, errs.Wrap("op", nil))
+}
+
+func TestWrap_PlainError(t *testing.T) {
+	cause := errors.New("disk I/O error")
+	err := errs.Wrap("storage.sqlite.User", cause)
+
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, errs.Internal, errs.CodeOf(err))
+	assert.Equal(t, errs.Internal, errs.CodeOf(cause))
+}
+
+func TestIs_MatchesSentinelsByCode(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &errs.Error{Code: errs.InvalidToken, Op: "op"})
+
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+	assert.ErrorIs(t, err, errs.New(errs.InvalidToken, "other message"))
+	// Only sentinels match by code, not errors carrying an operation.
+	assert.NotErrorIs(t, errs.ErrInvalidToken, &errs.Error{Code: errs.InvalidToken, Op: "op"})
+}
+
+func TestMetadataOf(t *testing.T) {
+	pending := &errs.Error{
+		Code:     errs.AccountPendingDeletion,
+		Metadata: map[string]string{"delete_after": "2026-01-02T03:04:05Z"},
+	}
+
+	assert.Equal(t, pending.Metadata, errs.MetadataOf(errs.Wrap("auth.Login", pending)))
+	assert.Nil(t, errs.MetadataOf(errs.Wrap("auth.Login", errs.ErrInvalidCredentials)))
+	assert.Nil(t, errs.MetadataOf(nil))
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	err := errs.Wrap("op", errs.ErrUserNotFound)
+
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	assert.ErrorIs(t, err, auth.ErrUserNotFound)
+	assert.ErrorIs(t, errs.Wrap("op", storage.ErrAppNotFound), auth.ErrInvalidAppID)
+	assert.ErrorIs(t, errs.Wrap("op", storage.ErrAuthorizationUsed), auth.ErrCodeReused)
+}
@@ -1,7 +1,28 @@
 package models
 
+import "time"
+
 type App struct {
-	ID     int
+	ID     int32
 	Name   string
 	Secret string
+	// TokenTTL overrides the lifetime of tokens issued for the app. Zero
+	// means no override.
+	TokenTTL time.Duration
+	// MaxUsers caps the users registered through the app. Zero means no
+	// quota.
+	MaxUsers int
+	// BindTokens binds the tokens issued over mTLS to the client
+	// certificate of the login, see jwt.CertBinding.
+	BindTokens bool
+}
+
+// AppQuota is the user quota of an app and how much of it is taken.
+type AppQuota struct {
+	AppID int32
+	// MaxUsers is zero for an app without quota.
+	MaxUsers int
+	// Users are those registered through the app, counted with or
+	// without a quota.
+	Users int
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Authorization is a pending or completed authorization-code request.
+// UserID and CodeHash are set once the user has logged in.
+type Authorization struct {
+	ID          string
+	AppID       int32
+	RedirectURI string
+	State       string
+	UserID      int64
+	CodeHash    string
+	ExpiresAt   time.Time
+	// UsedAt is zero until the code is exchanged.
+	UsedAt time.Time
+}
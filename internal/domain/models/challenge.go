@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// ChallengeType is a step a login has to pass before tokens are issued.
+// Each type names the metadata it comes with and the payload completing
+// it takes.
+type ChallengeType string
+
+const (
+	// ChallengeTOSAccept is raised while the user has yet to accept the
+	// terms of service enforced on login. It comes with the required
+	// tos_version, which the payload must give back.
+	ChallengeTOSAccept ChallengeType = "TOS_ACCEPT"
+)
+
+// Challenge is a pending step of a login, identified by the hash of the
+// opaque token the client completes it with.
+type Challenge struct {
+	TokenHash string
+	Type      ChallengeType
+	UserID    int64
+	AppID     int32
+	ExpiresAt time.Time
+	// UsedAt is zero until the challenge is completed.
+	UsedAt time.Time
+}
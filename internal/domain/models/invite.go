@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Invite lets its holder register while registration is invite-only.
+// Only the hash of the code is stored.
+type Invite struct {
+	ID       int64
+	CodeHash string
+	// Email, when set, is the only address the invite registers.
+	Email         string
+	UsesRemaining int
+	// ExpiresAt is zero for an invite that never expires.
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// InviteUse records the user who registered with an invite.
+type InviteUse struct {
+	InviteID int64
+	UserID   int64
+	UsedAt   time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// LinkedIdentity is an account of a federated identity provider, such as
+// Google, linked to a user so the user can sign in with it.
+type LinkedIdentity struct {
+	UserID   int64
+	Provider string
+	// Subject identifies the account at the provider. A provider account
+	// is linked to one user at most.
+	Subject  string
+	Email    string
+	LinkedAt time.Time
+}
+
+// ProviderIdentity is the account of an identity provider an
+// authorization code was issued for.
+type ProviderIdentity struct {
+	Subject string
+	Email   string
+}
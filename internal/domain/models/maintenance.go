@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// MaintenanceResult is what a run of storage maintenance did.
+type MaintenanceResult struct {
+	// Skipped is set when the run gave way to a backup or another run.
+	Skipped  bool
+	Duration time.Duration
+	// PageSize is in bytes; the page counts are of the whole file.
+	PageSize    int64
+	PagesBefore int64
+	PagesAfter  int64
+}
+
+// ReclaimedPages returns how many pages the run gave back.
+func (r MaintenanceResult) ReclaimedPages() int64 {
+	return max(r.PagesBefore-r.PagesAfter, 0)
+}
+
+// ReplicaDiff is how a table of the replica differed from the storage:
+// Copied rows were missing or not the same there, Deleted rows were gone
+// from the storage.
+type ReplicaDiff struct {
+	Table   string
+	Copied  int64
+	Deleted int64
+}
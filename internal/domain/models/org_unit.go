@@ -0,0 +1,14 @@
+package models
+
+// OrgUnit is a unit of the organization, such as a faculty or a
+// department. Units form a tree: roles given to a user in a unit hold in
+// every unit below it too.
+type OrgUnit struct {
+	ID int64
+	// ParentID is zero for a root unit.
+	ParentID int64
+	Name     string
+	// Children are the units right below, when the unit is part of a
+	// tree, see auth.Auth.ListOrgUnits.
+	Children []OrgUnit
+}
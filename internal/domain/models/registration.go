@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Registration is a self-service registration with what has to be stored
+// along with the user, in one transaction.
+type Registration struct {
+	Email string
+	// CanonicalEmail is the canonical form of Email, unique among users,
+	// none if empty. Email is kept as given, for correspondence.
+	CanonicalEmail string
+	PassHash       []byte
+	FirstName      string
+	LastName       string
+	MiddleName     string
+	// AppID is the app the user registers through, none if zero.
+	AppID int32
+	// InviteID is the invite registered with, none if zero. One of its
+	// uses is taken.
+	InviteID int64
+	// TermsVersion is the version of the terms of service accepted, none
+	// if empty.
+	TermsVersion string
+	// RegisteredAt is when the invite was used and the terms accepted.
+	RegisteredAt time.Time
+	// Event is published to the outbox for the new user, none if its Type
+	// is empty. Its UserID is set by the storage.
+	Event Event
+}
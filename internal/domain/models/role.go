@@ -0,0 +1,26 @@
+package models
+
+// RoleOutcome is what assigning a role did to a user.
+type RoleOutcome string
+
+const (
+	// RoleAssigned is a user given the role, in place of the one they had.
+	RoleAssigned RoleOutcome = "assigned"
+	// RoleAlreadyHad is a user who had the role already and was left as
+	// is, so assigning it again is safe.
+	RoleAlreadyHad RoleOutcome = "already_had"
+	// RoleUserNotFound is a user who does not exist.
+	RoleUserNotFound RoleOutcome = "user_not_found"
+	// RoleError is a user whose assignment failed; assigning the role to
+	// them again may succeed.
+	RoleError RoleOutcome = "error"
+)
+
+// RoleAssignment is the outcome of assigning a role to one user, see
+// auth.Auth.AssignRoleBulk.
+type RoleAssignment struct {
+	UserID  int64
+	Outcome RoleOutcome
+	// Err is why the assignment failed, set for RoleError only.
+	Err error
+}
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// ServerInfo is what a running instance is configured with, as shown to
+// operators. It never holds secrets.
+type ServerInfo struct {
+	Version string
+	Commit  string
+	// StorageDriver is the database the instance stores its data in.
+	StorageDriver string
+	// SchemaVersion is the version of the last migration applied.
+	SchemaVersion int
+	// Features lists the optional features that are enabled, sorted.
+	Features []string
+	// Listeners lists the addresses served, like "tcp://:44044".
+	Listeners []string
+	// MinAPIVersion and MaxAPIVersion bound the API versions clients may
+	// declare, see apiversion.
+	MinAPIVersion int
+	MaxAPIVersion int
+	StartedAt     time.Time
+	Uptime        time.Duration
+	Runtime       RuntimeStats
+	// Integrity is the storage integrity check run on startup, nil if
+	// none was.
+	Integrity *IntegrityCheck
+	// Schema is the storage schema check run on startup, nil if none was.
+	Schema *SchemaCheck
+	// Clamped lists the settings whose values were replaced with their
+	// defaults when the config was loaded.
+	Clamped []ClampedSetting
+}
+
+// ClampedSetting is a setting of the config file, by yaml path, that was
+// given Value and runs with Default.
+type ClampedSetting struct {
+	Path    string
+	Value   string
+	Default string
+}
+
+// IntegrityCheck is the result of a storage integrity check.
+type IntegrityCheck struct {
+	// Mode is "quick" or "full".
+	Mode      string
+	CheckedAt time.Time
+	Duration  time.Duration
+	// Problems lists what the check found, none if the storage is sound.
+	// Err is set instead if the check could not run.
+	Problems []string
+	Err      error
+}
+
+// RuntimeStats are a few numbers of the Go runtime.
+type RuntimeStats struct {
+	GoVersion      string
+	GOMAXPROCS     int
+	Goroutines     int
+	HeapAllocBytes uint64
+	NumGC          uint32
+}
+
+// SchemaCheck is the result of comparing the storage schema with what the
+// code relies on.
+type SchemaCheck struct {
+	CheckedAt time.Time
+	// Version is the version of the last migration recorded, Dirty
+	// whether it failed halfway, and Want the version the code relies on.
+	Version int
+	Dirty   bool
+	Want    int
+	// Missing lists the tables and columns of the schema at Want that the
+	// storage lacks, as "table users" or "column users.middle_name".
+	Missing []string
+	// Err is set if the check could not run.
+	Err error
+}
+
+// OK reports whether the schema is the one the code relies on.
+func (c SchemaCheck) OK() bool {
+	return c.Err == nil && !c.Dirty && c.Version >= c.Want && len(c.Missing) == 0
+}
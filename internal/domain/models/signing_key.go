@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SigningKey is a stored RS256 signing key.
+type SigningKey struct {
+	ID string
+	// PrivateKey is the PKCS #8 DER encoding of the private key.
+	PrivateKey []byte
+	CreatedAt  time.Time
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// LoginAttempt is an entry of the login history. UserID is zero when the
+// email did not match any user.
+type LoginAttempt struct {
+	UserID  int64
+	AppID   int32
+	Success bool
+	At      time.Time
+}
+
+// Stats are usage numbers over the range [From, To). DailyActive and
+// WeeklyActive count the users with a successful login in the day and
+// the week ending at To.
+type Stats struct {
+	From             time.Time
+	To               time.Time
+	TotalUsers       int64
+	Registrations    int64
+	SuccessfulLogins int64
+	FailedLogins     int64
+	DailyActive      int64
+	WeeklyActive     int64
+}
+
+// AppUsage is what an app was used for on a day, Day being its midnight
+// in UTC: successful logins, tokens issued, those of logins included, and
+// the tokens reissued for tokens already held, such as elevated ones.
+type AppUsage struct {
+	AppID        int32
+	Day          time.Time
+	Logins       int64
+	TokensIssued int64
+	Refreshes    int64
+}
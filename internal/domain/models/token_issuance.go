@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// TokenKind is how a token came to be issued.
+type TokenKind string
+
+const (
+	// TokenLogin is issued by a login with a password.
+	TokenLogin TokenKind = "login"
+	// TokenExchange is issued for an authorization code.
+	TokenExchange TokenKind = "exchange"
+	// TokenElevation is a step-up token issued for an admin token.
+	TokenElevation TokenKind = "elevation"
+)
+
+// TokenIssuance records that a token was issued, and to whom, so incident
+// response can tell whether a token is ours. The token itself is never
+// kept: only its fingerprint, see jwt.Fingerprint.
+type TokenIssuance struct {
+	Fingerprint string
+	// JTI is the jti claim of the token.
+	JTI       string
+	Kind      TokenKind
+	UserID    int64
+	AppID     int32
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// RetainUntil is when the record may be purged: ExpiresAt plus the
+	// retention margin.
+	RetainUntil time.Time
+	// Device labels the client the token was issued to, such as "Chrome
+	// on Windows", see useragent.Label. Empty if not known.
+	Device string
+}
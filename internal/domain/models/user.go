@@ -1,10 +1,121 @@
 package models
 
+import "time"
+
 type User struct {
+	ID        int64
+	Email     string
+	PassHash  []byte
+	FirstName string
+	LastName  string
+	// MiddleName is optional: empty means the user has none. A middle
+	// name cannot be set to the empty string on purpose.
+	MiddleName string
+	// TokenTTL overrides the lifetime of the user's tokens. Zero means no
+	// override.
+	TokenTTL time.Duration
+	// TermsVersion is the version of the terms of service the user
+	// accepted last, at TermsAcceptedAt. Empty if none.
+	TermsVersion    string
+	TermsAcceptedAt time.Time
+	// CreatedAt and UpdatedAt are zero for users registered before they
+	// were recorded.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// DeleteAfter is when the account is anonymized, zero unless its
+	// deletion is scheduled.
+	DeleteAfter time.Time
+	// SessionsRevokedAt invalidates the tokens issued up to then. Zero if
+	// they never were.
+	SessionsRevokedAt time.Time
+	// ActivationPending is true for users pre-registered by an admin who
+	// have not set their password yet. Such users have no password hash.
+	ActivationPending bool
+}
+
+// NewUser is the profile of a user RegisterNewUser created: the user as
+// stored, without its password hash, and its role, empty if none.
+type NewUser struct {
+	User
+	Role string
+}
+
+// Activation is the activation token of a pre-registered user, valid
+// until ExpiresAt. The token is not stored and cannot be shown again.
+type Activation struct {
+	UserID    int64
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Deletion is a scheduled deletion of the account of a user, cancellable
+// with ReactivationToken until DeleteAfter. The token is not stored and
+// cannot be shown again.
+type Deletion struct {
+	UserID            int64
+	ReactivationToken string
+	DeleteAfter       time.Time
+}
+
+// UserRecord is the part of a user that is safe to export: no password
+// hash and no per-user settings.
+type UserRecord struct {
 	ID         int64
 	Email      string
-	PassHash   []byte
 	FirstName  string
 	LastName   string
 	MiddleName string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// UserFilter selects the users to export. Zero fields match every user.
+type UserFilter struct {
+	// AfterID resumes an export after the last ID received.
+	AfterID int64
+	// UpdatedSince skips users not changed since then.
+	UpdatedSince time.Time
+	// UpToID skips the users with a greater ID, such as those saved after
+	// a snapshot was pinned. IDs are never reused, so saved users only
+	// come after the others.
+	UpToID int64
+}
+
+// UserPage is a page of users, see auth.Auth.ListUsers.
+type UserPage struct {
+	Users []UserRecord
+	// NextPageToken is the token of the next page, empty after the last.
+	NextPageToken string
+}
+
+// Identity is who a token belongs to, as shown to the token holder.
+type Identity struct {
+	UserID     int64
+	Email      string
+	FirstName  string
+	LastName   string
+	MiddleName string
+	Roles      []string
+	AppID      int32
+	AppName    string
+	// IssuedAt is zero for tokens issued before it was recorded.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// UserEmail is the email of a user as stored, with the time of the last
+// successful login, zero if none, to tell which of two accounts is used.
+type UserEmail struct {
+	ID    int64
+	Email string
+	// CanonicalEmail is the canonical form stored, none if empty.
+	CanonicalEmail string
+	LastLoginAt    time.Time
+}
+
+// EmailChange changes the email of a user from From to To.
+type EmailChange struct {
+	UserID int64
+	From   string
+	To     string
 }
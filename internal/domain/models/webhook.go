@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// Webhook is a subscription of an app to lifecycle events.
+type Webhook struct {
+	ID    int64
+	AppID int32
+	URL   string
+	// Secret signs every delivery, see the webhooks package.
+	Secret     string
+	EventTypes []string
+	CreatedAt  time.Time
+	WebhookState
+}
+
+// WebhookState is the delivery progress of a webhook.
+type WebhookState struct {
+	Active bool
+	// LastEventID is the last event delivered or skipped.
+	LastEventID int64
+	// Failures counts the consecutive failed deliveries; the next attempt
+	// is not made before NextAttemptAt.
+	Failures      int
+	NextAttemptAt time.Time
+}
+
+// FailedTask is the delivery of an outbox event a consumer is stuck at
+// after failing it: retried at NextAttemptAt or, once Disabled, not any
+// more.
+type FailedTask struct {
+	// Consumer is the kind of consumer, "webhook" for the only kind there
+	// is.
+	Consumer  string
+	WebhookID int64
+	AppID     int32
+	// EventID and EventType are zero if the event is no longer in the
+	// outbox.
+	EventID       int64
+	EventType     string
+	Failures      int
+	NextAttemptAt time.Time
+	Disabled      bool
+}
+
+// Event types published to the outbox.
+const (
+	EventUserRegistered = "user.registered"
+	EventUserLoggedIn   = "user.logged_in"
+	// EventRegistrationAttempted is published for the existing user when
+	// someone registers with its email under concealed registration.
+	EventRegistrationAttempted = "user.registration_attempted"
+	// EventDeletionScheduled carries the deadline of the deletion, not the
+	// reactivation token that cancels it.
+	EventDeletionScheduled = "user.deletion_scheduled"
+	EventDeletionCancelled = "user.deletion_cancelled"
+	EventUserDeleted       = "user.deleted"
+	// EventActivationIssued carries the expiry of the activation token of
+	// a pre-registered user, not the token.
+	EventActivationIssued = "user.activation_issued"
+	EventUserActivated    = "user.activated"
+)
+
+// Event is an entry of the outbox. AppID is zero for events that concern
+// every app, such as registrations.
+type Event struct {
+	ID        int64
+	Type      string
+	UserID    int64
+	AppID     int32
+	Payload   []byte
+	CreatedAt time.Time
+}
@@ -0,0 +1,276 @@
+// Package activation implements sso.activation.v1.Activations, with which
+// admins pre-register accounts and their users activate them by setting a
+// password.
+//
+// The service is not part of course-work-protos yet, so, like the
+// Identities service, its descriptor is built here from well-known types.
+// Requests and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"email": "student@example.com", "first_name": "Jane", "last_name": "Doe"}' \
+//		localhost:44044 sso.activation.v1.Activations/PreRegisterUser
+//	grpcurl -plaintext -d '{"activation_token": "...", "password": "..."}' \
+//		localhost:44044 sso.activation.v1.Activations/ActivateAccount
+package activation
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.activation.v1.Activations"
+	fileName    = "sso/activation.proto"
+
+	// The full names of the methods that manage pre-registered accounts.
+	// Each must have a policy requiring the admin role, see
+	// interceptors.Authorize.
+	PreRegisterUserMethod   = "/" + serviceName + "/PreRegisterUser"
+	ReissueActivationMethod = "/" + serviceName + "/ReissueActivation"
+	RevokeActivationMethod  = "/" + serviceName + "/RevokeActivation"
+	// ActivateAccountMethod is the full name of ActivateAccount. It is
+	// called by users who have no token yet, so it needs no policy.
+	ActivateAccountMethod = "/" + serviceName + "/ActivateAccount"
+
+	// maxUserID is the largest user_id a Struct number carries exactly.
+	maxUserID = 1 << 53
+)
+
+type Activator interface {
+	PreRegisterUser(ctx context.Context, email, firstName, lastName, middleName string) (models.Activation, error)
+	ReissueActivation(ctx context.Context, userID int64) (models.Activation, error)
+	RevokeActivation(ctx context.Context, userID int64) error
+	ActivateAccount(ctx context.Context, token string, password string) (int64, error)
+}
+
+// Server is the handler interface of the Activations service.
+type Server interface {
+	PreRegisterUser(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	ReissueActivation(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	RevokeActivation(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	ActivateAccount(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	activator Activator
+}
+
+func Register(gRPC *grpc.Server, activator Activator) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{activator: activator})
+}
+
+// PreRegisterUser creates an account for email without a password and
+// returns its activation token, shown only once.
+func (s *serverAPI) PreRegisterUser(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if stringField(req, "email") == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	activation, err := s.activator.PreRegisterUser(ctx,
+		stringField(req, "email"),
+		stringField(req, "first_name"),
+		stringField(req, "last_name"),
+		stringField(req, "middle_name"),
+	)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return activationResponse(activation)
+}
+
+// ReissueActivation replaces the activation token of user_id, which must
+// not be activated yet.
+func (s *serverAPI) ReissueActivation(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	userID, err := userIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	activation, err := s.activator.ReissueActivation(ctx, userID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return activationResponse(activation)
+}
+
+// RevokeActivation revokes the activation token of user_id.
+func (s *serverAPI) RevokeActivation(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	userID, err := userIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.activator.RevokeActivation(ctx, userID); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ActivateAccount sets the password of the account activation_token was
+// issued for.
+func (s *serverAPI) ActivateAccount(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if stringField(req, "activation_token") == "" {
+		return nil, status.Error(codes.InvalidArgument, "activation_token is required")
+	}
+	if stringField(req, "password") == "" {
+		return nil, status.Error(codes.InvalidArgument, "password is required")
+	}
+
+	userID, err := s.activator.ActivateAccount(ctx, stringField(req, "activation_token"), stringField(req, "password"))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+func stringField(req *structpb.Struct, name string) string {
+	return req.GetFields()[name].GetStringValue()
+}
+
+func userIDField(req *structpb.Struct) (int64, error) {
+	n, ok := req.GetFields()["user_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > maxUserID {
+		return 0, status.Error(codes.InvalidArgument, "user_id must be a positive integer")
+	}
+
+	return int64(n.NumberValue), nil
+}
+
+func activationResponse(activation models.Activation) (*structpb.Struct, error) {
+	resp, err := structpb.NewStruct(map[string]any{
+		"user_id":          activation.UserID,
+		"activation_token": activation.Token,
+		"expires_at":       activation.ExpiresAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PreRegisterUser",
+			Handler: handler(PreRegisterUserMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.PreRegisterUser(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ReissueActivation",
+			Handler: handler(ReissueActivationMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ReissueActivation(ctx, req)
+			}),
+		},
+		{
+			MethodName: "RevokeActivation",
+			Handler: handler(RevokeActivationMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.RevokeActivation(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ActivateAccount",
+			Handler: handler(ActivateAccountMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ActivateAccount(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the response.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(output),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.activation.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Activations"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("PreRegisterUser", ".google.protobuf.Struct"),
+				method("ReissueActivation", ".google.protobuf.Struct"),
+				method("RevokeActivation", ".google.protobuf.Empty"),
+				method("ActivateAccount", ".google.protobuf.Struct"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,956 @@
+// Package admin implements sso.admin.v1.Admin, which lets operators see
+// what a running instance is configured with and run its storage
+// maintenance, lists the outbox deliveries that failed, tells whether a
+// token was issued here, assigns a role to many users at once, pages
+// through or streams the users, checks many users at once, overrides the
+// token lifetime of a user, creates registration invites and reports usage
+// numbers.
+//
+// The service is not part of course-work-protos yet, so, like the Session
+// service, its descriptor is built here from well-known types:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' localhost:44044 sso.admin.v1.Admin/GetServerInfo
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' localhost:44044 sso.admin.v1.Admin/RunMaintenance
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' localhost:44044 sso.admin.v1.Admin/ListFailedTasks
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '"<token or fingerprint>"' localhost:44044 sso.admin.v1.Admin/LookupToken
+//	grpcurl -plaintext -H 'authorization: Bearer <elevated token>' -d '{"role": "teacher", "user_ids": [1, 2]}' localhost:44044 sso.admin.v1.Admin/AssignRoleBulk
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"page_size": 100, "snapshot": true}' localhost:44044 sso.admin.v1.Admin/ListUsers
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"from": "2024-03-01T00:00:00Z", "to": "2024-04-01T00:00:00Z"}' localhost:44044 sso.admin.v1.Admin/GetStats
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"after_id": 0, "updated_since": "2024-03-01T00:00:00Z"}' localhost:44044 sso.admin.v1.Admin/StreamUsers
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"user_ids": [1, 2]}' localhost:44044 sso.admin.v1.Admin/UsersExist
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"user_ids": [1, 2]}' localhost:44044 sso.admin.v1.Admin/UserRoles
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"user_id": 1, "ttl_seconds": 28800}' localhost:44044 sso.admin.v1.Admin/SetUserTokenTTL
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '{"email": "student@example.com", "uses": 1, "ttl_seconds": 604800}' localhost:44044 sso.admin.v1.Admin/CreateInvite
+package admin
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	serviceName = "sso.admin.v1.Admin"
+	fileName    = "sso/admin.proto"
+
+	// GetServerInfoMethod is the full name of GetServerInfo. It must have
+	// a policy requiring the admin role, see interceptors.Authorize.
+	GetServerInfoMethod = "/" + serviceName + "/GetServerInfo"
+	// RunMaintenanceMethod is the full name of RunMaintenance. It must
+	// have a policy requiring the admin role, see interceptors.Authorize.
+	RunMaintenanceMethod = "/" + serviceName + "/RunMaintenance"
+	// ListFailedTasksMethod is the full name of ListFailedTasks. It must
+	// have a policy requiring the admin role, see interceptors.Authorize.
+	ListFailedTasksMethod = "/" + serviceName + "/ListFailedTasks"
+	// LookupTokenMethod is the full name of LookupToken. It must have a
+	// policy requiring the admin role, see interceptors.Authorize.
+	LookupTokenMethod = "/" + serviceName + "/LookupToken"
+	// AssignRoleBulkMethod is the full name of AssignRoleBulk. It must
+	// have a policy requiring the admin role and elevation, see
+	// interceptors.Authorize.
+	AssignRoleBulkMethod = "/" + serviceName + "/AssignRoleBulk"
+	// ListUsersMethod is the full name of ListUsers. It must have a
+	// policy requiring the admin role, see interceptors.Authorize.
+	ListUsersMethod = "/" + serviceName + "/ListUsers"
+	// GetStatsMethod is the full name of GetStats. It must have a policy
+	// requiring the admin role, see interceptors.Authorize.
+	GetStatsMethod = "/" + serviceName + "/GetStats"
+	// StreamUsersMethod is the full name of StreamUsers. It must have a
+	// policy requiring the admin role, see interceptors.Authorize.
+	StreamUsersMethod = "/" + serviceName + "/StreamUsers"
+	// UsersExistMethod and UserRolesMethod are the full names of
+	// UsersExist and UserRoles. They must have policies requiring the
+	// admin role, see interceptors.Authorize.
+	UsersExistMethod = "/" + serviceName + "/UsersExist"
+	UserRolesMethod  = "/" + serviceName + "/UserRoles"
+	// SetUserTokenTTLMethod is the full name of SetUserTokenTTL. It must
+	// have a policy requiring the admin role, see interceptors.Authorize.
+	SetUserTokenTTLMethod = "/" + serviceName + "/SetUserTokenTTL"
+	// CreateInviteMethod is the full name of CreateInvite. It must have a
+	// policy requiring the admin role, see interceptors.Authorize.
+	CreateInviteMethod = "/" + serviceName + "/CreateInvite"
+
+	// maxID is the largest ID a Struct number carries exactly.
+	maxID = 1 << 53
+)
+
+type InfoProvider interface {
+	ServerInfo(ctx context.Context) (models.ServerInfo, error)
+}
+
+type Maintainer interface {
+	RunMaintenance(ctx context.Context) (models.MaintenanceResult, error)
+}
+
+type TaskLister interface {
+	ListFailedTasks(ctx context.Context) ([]models.FailedTask, error)
+}
+
+type TokenLookup interface {
+	LookupToken(ctx context.Context, tokenOrFingerprint string) (models.TokenIssuance, error)
+}
+
+type RoleAssigner interface {
+	AssignRoleBulk(ctx context.Context, userIDs []int64, role string) ([]models.RoleAssignment, error)
+}
+
+type UserLister interface {
+	ListUsers(ctx context.Context, pageToken string, pageSize int, snapshot bool) (models.UserPage, error)
+}
+
+type StatsProvider interface {
+	Stats(ctx context.Context, from, to time.Time) (models.Stats, error)
+}
+
+type UserStreamer interface {
+	StreamUsers(ctx context.Context, filter models.UserFilter, send func(batch []models.UserRecord) error) error
+}
+
+type UserBatch interface {
+	UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+	UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error)
+}
+
+type TokenTTLSetter interface {
+	SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error
+}
+
+type InviteCreator interface {
+	CreateInvite(ctx context.Context, email string, uses int, ttl time.Duration) (string, models.Invite, error)
+}
+
+// Server is the handler interface of the Admin service.
+type Server interface {
+	GetServerInfo(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	RunMaintenance(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	ListFailedTasks(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	LookupToken(ctx context.Context, req *wrapperspb.StringValue) (*structpb.Struct, error)
+	AssignRoleBulk(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	ListUsers(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetStats(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	StreamUsers(req *structpb.Struct, stream grpc.ServerStreamingServer[structpb.Struct]) error
+	UsersExist(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	UserRoles(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	SetUserTokenTTL(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	CreateInvite(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	info       InfoProvider
+	maintainer Maintainer
+	tasks      TaskLister
+	tokens     TokenLookup
+	roles      RoleAssigner
+	users      UserLister
+	stats      StatsProvider
+	streamer   UserStreamer
+	batch      UserBatch
+	tokenTTLs  TokenTTLSetter
+	invites    InviteCreator
+}
+
+// Register registers the service. A nil maintainer leaves RunMaintenance
+// unimplemented, nil tasks ListFailedTasks, nil tokens LookupToken, nil
+// roles AssignRoleBulk, nil users ListUsers, nil stats GetStats, nil
+// streamer StreamUsers, nil batch UsersExist and UserRoles, nil tokenTTLs
+// SetUserTokenTTL and nil invites CreateInvite.
+func Register(
+	gRPC *grpc.Server,
+	info InfoProvider,
+	maintainer Maintainer,
+	tasks TaskLister,
+	tokens TokenLookup,
+	roles RoleAssigner,
+	users UserLister,
+	stats StatsProvider,
+	streamer UserStreamer,
+	batch UserBatch,
+	tokenTTLs TokenTTLSetter,
+	invites InviteCreator,
+) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{
+		info:       info,
+		maintainer: maintainer,
+		tasks:      tasks,
+		tokens:     tokens,
+		roles:      roles,
+		users:      users,
+		stats:      stats,
+		streamer:   streamer,
+		batch:      batch,
+		tokenTTLs:  tokenTTLs,
+		invites:    invites,
+	})
+}
+
+// GetServerInfo describes the build, configuration and runtime of the
+// instance. The caller has already been authorized by the Authorize
+// interceptor.
+func (s *serverAPI) GetServerInfo(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	info, err := s.info.ServerInfo(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	fields := map[string]any{
+		"version":        info.Version,
+		"commit":         info.Commit,
+		"storage_driver": info.StorageDriver,
+		"schema_version": info.SchemaVersion,
+		"features":       toList(info.Features),
+		"listeners":      toList(info.Listeners),
+		"api_versions": map[string]any{
+			"min": info.MinAPIVersion,
+			"max": info.MaxAPIVersion,
+		},
+		"started_at":     info.StartedAt.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(info.Uptime.Seconds()),
+		"runtime": map[string]any{
+			"go_version":       info.Runtime.GoVersion,
+			"gomaxprocs":       info.Runtime.GOMAXPROCS,
+			"goroutines":       info.Runtime.Goroutines,
+			"heap_alloc_bytes": info.Runtime.HeapAllocBytes,
+			"num_gc":           info.Runtime.NumGC,
+		},
+	}
+	if len(info.Clamped) > 0 {
+		clamped := make([]any, len(info.Clamped))
+		for i, c := range info.Clamped {
+			clamped[i] = map[string]any{"path": c.Path, "value": c.Value, "default": c.Default}
+		}
+		fields["clamped_config"] = clamped
+	}
+	if c := info.Integrity; c != nil {
+		integrity := map[string]any{
+			"mode":             c.Mode,
+			"checked_at":       c.CheckedAt.UTC().Format(time.RFC3339),
+			"duration_seconds": c.Duration.Seconds(),
+			"ok":               c.Err == nil && len(c.Problems) == 0,
+			"problems":         toList(c.Problems),
+		}
+		if c.Err != nil {
+			integrity["error"] = c.Err.Error()
+		}
+		fields["integrity"] = integrity
+	}
+	if c := info.Schema; c != nil {
+		schema := map[string]any{
+			"checked_at":   c.CheckedAt.UTC().Format(time.RFC3339),
+			"version":      c.Version,
+			"want_version": c.Want,
+			"dirty":        c.Dirty,
+			"ok":           c.OK(),
+			"missing":      toList(c.Missing),
+		}
+		if c.Err != nil {
+			schema["error"] = c.Err.Error()
+		}
+		fields["schema"] = schema
+	}
+
+	resp, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode server info")
+	}
+
+	return resp, nil
+}
+
+// RunMaintenance vacuums the storage now rather than in the maintenance
+// window, bounded by the same ceiling. Writes wait while it runs.
+func (s *serverAPI) RunMaintenance(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if s.maintainer == nil {
+		return nil, status.Error(codes.Unimplemented, "storage maintenance is not enabled")
+	}
+
+	res, err := s.maintainer.RunMaintenance(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"skipped":          res.Skipped,
+		"duration_seconds": res.Duration.Seconds(),
+		"page_size":        res.PageSize,
+		"pages_before":     res.PagesBefore,
+		"pages_after":      res.PagesAfter,
+		"reclaimed_pages":  res.ReclaimedPages(),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode maintenance result")
+	}
+
+	return resp, nil
+}
+
+// ListFailedTasks lists the outbox deliveries that failed, with when they
+// are retried or whether their consumer gave up on them.
+func (s *serverAPI) ListFailedTasks(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if s.tasks == nil {
+		return nil, status.Error(codes.Unimplemented, "outbox consumers are not enabled")
+	}
+
+	tasks, err := s.tasks.ListFailedTasks(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	list := make([]any, len(tasks))
+	for i, task := range tasks {
+		fields := map[string]any{
+			"consumer":   task.Consumer,
+			"webhook_id": task.WebhookID,
+			"app_id":     task.AppID,
+			"event_id":   task.EventID,
+			"event_type": task.EventType,
+			"failures":   task.Failures,
+			"disabled":   task.Disabled,
+		}
+		if !task.NextAttemptAt.IsZero() {
+			fields["next_attempt_at"] = task.NextAttemptAt.UTC().Format(time.RFC3339)
+		}
+		list[i] = fields
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"tasks": list})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode failed tasks")
+	}
+
+	return resp, nil
+}
+
+// LookupToken tells whether the token, or the fingerprint of one, in req
+// was issued here, and to whom. The token is never logged: payload logging
+// masks string values whole.
+func (s *serverAPI) LookupToken(ctx context.Context, req *wrapperspb.StringValue) (*structpb.Struct, error) {
+	if s.tokens == nil {
+		return nil, status.Error(codes.Unimplemented, "token issuances are not recorded")
+	}
+
+	issuance, err := s.tokens.LookupToken(ctx, req.GetValue())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"fingerprint":  issuance.Fingerprint,
+		"jti":          issuance.JTI,
+		"kind":         string(issuance.Kind),
+		"user_id":      issuance.UserID,
+		"app_id":       issuance.AppID,
+		"issued_at":    issuance.IssuedAt.UTC().Format(time.RFC3339),
+		"expires_at":   issuance.ExpiresAt.UTC().Format(time.RFC3339),
+		"retain_until": issuance.RetainUntil.UTC().Format(time.RFC3339),
+		"device":       issuance.Device,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode token issuance")
+	}
+
+	return resp, nil
+}
+
+// AssignRoleBulk makes role the role of every user of user_ids, at most
+// auth.MaxAssignRoleBatch of them, and lists what it did to each in
+// "results", with how many got each outcome in "counts". Users left with
+// the "error" outcome can be sent again: users who have the role already
+// are left as they are.
+func (s *serverAPI) AssignRoleBulk(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.roles == nil {
+		return nil, status.Error(codes.Unimplemented, "role assignment is not enabled")
+	}
+
+	userIDs, err := idList(req, "user_ids")
+	if err != nil {
+		return nil, err
+	}
+
+	assignments, err := s.roles.AssignRoleBulk(ctx, userIDs, req.GetFields()["role"].GetStringValue())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	// The errors of failed users are internal; their outcome is enough
+	// to retry them.
+	results := make([]any, len(assignments))
+	counts := make(map[models.RoleOutcome]int, 4)
+	for i, assignment := range assignments {
+		results[i] = map[string]any{
+			"user_id": assignment.UserID,
+			"outcome": string(assignment.Outcome),
+		}
+		counts[assignment.Outcome]++
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"results": results,
+		"counts": map[string]any{
+			string(models.RoleAssigned):     counts[models.RoleAssigned],
+			string(models.RoleAlreadyHad):   counts[models.RoleAlreadyHad],
+			string(models.RoleUserNotFound): counts[models.RoleUserNotFound],
+			string(models.RoleError):        counts[models.RoleError],
+		},
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode role assignments")
+	}
+
+	return resp, nil
+}
+
+// ListUsers returns a page of at most page_size users in ID order, as
+// "users", and the page_token of the next page as "next_page_token",
+// absent after the last page. With snapshot on the first page, the later
+// pages leave out the users registered after it; without, they come on
+// the last page. Either way no user is listed twice or skipped.
+func (s *serverAPI) ListUsers(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.users == nil {
+		return nil, status.Error(codes.Unimplemented, "user listing is not enabled")
+	}
+
+	fields := req.GetFields()
+	var pageSize int
+	if v, ok := fields["page_size"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > math.MaxInt32 {
+			return nil, status.Error(codes.InvalidArgument, "page_size must be a non-negative integer")
+		}
+		pageSize = int(n.NumberValue)
+	}
+
+	page, err := s.users.ListUsers(ctx, fields["page_token"].GetStringValue(), pageSize, fields["snapshot"].GetBoolValue())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp := map[string]any{"users": userRecords(page.Users)}
+	if page.NextPageToken != "" {
+		resp["next_page_token"] = page.NextPageToken
+	}
+
+	out, err := structpb.NewStruct(resp)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode users")
+	}
+
+	return out, nil
+}
+
+// StreamUsers sends the users in ID order, in batches of at most
+// auth.StreamBatchSize, each as the "users" of a message. Only users
+// changed since updated_since, an RFC3339 timestamp, are sent if it is
+// given. An export cut short, as by the client canceling, resumes with
+// after_id set to the last ID received.
+func (s *serverAPI) StreamUsers(req *structpb.Struct, stream grpc.ServerStreamingServer[structpb.Struct]) error {
+	if s.streamer == nil {
+		return status.Error(codes.Unimplemented, "user streaming is not enabled")
+	}
+
+	var filter models.UserFilter
+	fields := req.GetFields()
+	if v, ok := fields["after_id"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > maxID {
+			return status.Error(codes.InvalidArgument, "after_id must be a non-negative integer")
+		}
+		filter.AfterID = int64(n.NumberValue)
+	}
+	if _, ok := fields["updated_since"]; ok {
+		since, err := timeField(req, "updated_since")
+		if err != nil {
+			return err
+		}
+		filter.UpdatedSince = since
+	}
+
+	err := s.streamer.StreamUsers(stream.Context(), filter, func(batch []models.UserRecord) error {
+		msg, err := structpb.NewStruct(map[string]any{"users": userRecords(batch)})
+		if err != nil {
+			return status.Error(codes.Internal, "failed to encode users")
+		}
+
+		return stream.Send(msg)
+	})
+	if err != nil {
+		return grpcerr.Status(err)
+	}
+
+	return nil
+}
+
+// userRecords converts users for structpb, leaving out the fields they
+// have not got.
+func userRecords(users []models.UserRecord) []any {
+	records := make([]any, len(users))
+	for i, user := range users {
+		record := map[string]any{
+			"id":         user.ID,
+			"email":      user.Email,
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+		}
+		if user.MiddleName != "" {
+			record["middle_name"] = user.MiddleName
+		}
+		if !user.CreatedAt.IsZero() {
+			record["created_at"] = user.CreatedAt.UTC().Format(time.RFC3339)
+		}
+		if !user.UpdatedAt.IsZero() {
+			record["updated_at"] = user.UpdatedAt.UTC().Format(time.RFC3339)
+		}
+		records[i] = record
+	}
+
+	return records
+}
+
+// GetStats returns the usage numbers over [from, to), both RFC3339
+// timestamps, at most auth.MaxStatsRange apart: the total and new users,
+// the successful and failed logins and the daily and weekly active users
+// as of to.
+func (s *serverAPI) GetStats(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.stats == nil {
+		return nil, status.Error(codes.Unimplemented, "login history is not recorded")
+	}
+
+	from, err := timeField(req, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := timeField(req, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.stats.Stats(ctx, from, to)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"from":              stats.From.UTC().Format(time.RFC3339),
+		"to":                stats.To.UTC().Format(time.RFC3339),
+		"total_users":       stats.TotalUsers,
+		"registrations":     stats.Registrations,
+		"successful_logins": stats.SuccessfulLogins,
+		"failed_logins":     stats.FailedLogins,
+		"daily_active":      stats.DailyActive,
+		"weekly_active":     stats.WeeklyActive,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode stats")
+	}
+
+	return resp, nil
+}
+
+// timeField returns the RFC3339 timestamp of the field name, which is
+// required.
+// UsersExist tells for every user of user_ids, at most
+// auth.MaxBatchSize of them, whether the user exists. The "users" of the
+// response are in the order of user_ids.
+func (s *serverAPI) UsersExist(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.batch == nil {
+		return nil, status.Error(codes.Unimplemented, "user batches are not enabled")
+	}
+
+	userIDs, err := idList(req, "user_ids")
+	if err != nil {
+		return nil, err
+	}
+
+	exist, err := s.batch.UsersExist(ctx, userIDs)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	users := make([]any, len(userIDs))
+	for i, id := range userIDs {
+		users[i] = map[string]any{"user_id": id, "exists": exist[id]}
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"users": users})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode users")
+	}
+
+	return resp, nil
+}
+
+// UserRoles returns the role of every user of user_ids, at most
+// auth.MaxBatchSize of them, empty for users that do not exist or have
+// none. The "users" of the response are in the order of user_ids.
+func (s *serverAPI) UserRoles(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.batch == nil {
+		return nil, status.Error(codes.Unimplemented, "user batches are not enabled")
+	}
+
+	userIDs, err := idList(req, "user_ids")
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := s.batch.UserRoles(ctx, userIDs)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	users := make([]any, len(userIDs))
+	for i, id := range userIDs {
+		users[i] = map[string]any{"user_id": id, "role": roles[id]}
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"users": users})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode user roles")
+	}
+
+	return resp, nil
+}
+
+// SetUserTokenTTL makes the tokens of user_id last ttl_seconds, zero or
+// absent to fall back to the lifetime of the app. It returns the override
+// set.
+func (s *serverAPI) SetUserTokenTTL(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.tokenTTLs == nil {
+		return nil, status.Error(codes.Unimplemented, "token ttl overrides are not enabled")
+	}
+
+	fields := req.GetFields()
+	n, ok := fields["user_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue <= 0 || n.NumberValue > maxID {
+		return nil, status.Error(codes.InvalidArgument, "user_id must be a positive integer")
+	}
+	userID := int64(n.NumberValue)
+	var ttl time.Duration
+	if v, ok := fields["ttl_seconds"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > math.MaxInt32 {
+			return nil, status.Error(codes.InvalidArgument, "ttl_seconds must be a non-negative integer")
+		}
+		ttl = time.Duration(n.NumberValue) * time.Second
+	}
+
+	if err := s.tokenTTLs.SetUserTokenTTL(ctx, userID, ttl); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"user_id":     userID,
+		"ttl_seconds": int64(ttl.Seconds()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode token ttl")
+	}
+
+	return resp, nil
+}
+
+// CreateInvite creates an invite registering up to uses users, one when
+// absent, within ttl_seconds, zero or absent for no expiry. A non-empty
+// email restricts it to that address. The code is returned once: only
+// its hash is stored.
+func (s *serverAPI) CreateInvite(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.invites == nil {
+		return nil, status.Error(codes.Unimplemented, "invites are not enabled")
+	}
+
+	fields := req.GetFields()
+	uses := 1
+	if v, ok := fields["uses"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > math.MaxInt32 {
+			return nil, status.Error(codes.InvalidArgument, "uses must be a positive integer")
+		}
+		uses = int(n.NumberValue)
+	}
+	var ttl time.Duration
+	if v, ok := fields["ttl_seconds"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > math.MaxInt32 {
+			return nil, status.Error(codes.InvalidArgument, "ttl_seconds must be a non-negative integer")
+		}
+		ttl = time.Duration(n.NumberValue) * time.Second
+	}
+
+	code, invite, err := s.invites.CreateInvite(ctx, fields["email"].GetStringValue(), uses, ttl)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	res := map[string]any{
+		"id":             invite.ID,
+		"code":           code,
+		"email":          invite.Email,
+		"uses_remaining": invite.UsesRemaining,
+	}
+	if !invite.ExpiresAt.IsZero() {
+		res["expires_at"] = invite.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	resp, err := structpb.NewStruct(res)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode invite")
+	}
+
+	return resp, nil
+}
+
+func timeField(req *structpb.Struct, name string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, req.GetFields()[name].GetStringValue())
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%s must be an RFC3339 timestamp", name)
+	}
+
+	return t, nil
+}
+
+// idList returns the list of positive integers in field name.
+func idList(req *structpb.Struct, name string) ([]int64, error) {
+	values := req.GetFields()[name].GetListValue().GetValues()
+	ids := make([]int64, len(values))
+	for i, v := range values {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue <= 0 || n.NumberValue > maxID {
+			return nil, status.Errorf(codes.InvalidArgument, "%s must be positive integers", name)
+		}
+		ids[i] = int64(n.NumberValue)
+	}
+
+	return ids, nil
+}
+
+// toList converts ss for structpb, which takes []any only.
+func toList(ss []string) []any {
+	res := make([]any, len(ss))
+	for i, s := range ss {
+		res[i] = s
+	}
+
+	return res
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetServerInfo",
+			Handler: handler(GetServerInfoMethod, func(srv Server, ctx context.Context, req *emptypb.Empty) (any, error) {
+				return srv.GetServerInfo(ctx, req)
+			}),
+		},
+		{
+			MethodName: "RunMaintenance",
+			Handler: handler(RunMaintenanceMethod, func(srv Server, ctx context.Context, req *emptypb.Empty) (any, error) {
+				return srv.RunMaintenance(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListFailedTasks",
+			Handler: handler(ListFailedTasksMethod, func(srv Server, ctx context.Context, req *emptypb.Empty) (any, error) {
+				return srv.ListFailedTasks(ctx, req)
+			}),
+		},
+		{
+			MethodName: "LookupToken",
+			Handler: handler(LookupTokenMethod, func(srv Server, ctx context.Context, req *wrapperspb.StringValue) (any, error) {
+				return srv.LookupToken(ctx, req)
+			}),
+		},
+		{
+			MethodName: "AssignRoleBulk",
+			Handler: handler(AssignRoleBulkMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.AssignRoleBulk(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListUsers",
+			Handler: handler(ListUsersMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ListUsers(ctx, req)
+			}),
+		},
+		{
+			MethodName: "GetStats",
+			Handler: handler(GetStatsMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.GetStats(ctx, req)
+			}),
+		},
+		{
+			MethodName: "UsersExist",
+			Handler: handler(UsersExistMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.UsersExist(ctx, req)
+			}),
+		},
+		{
+			MethodName: "UserRoles",
+			Handler: handler(UserRolesMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.UserRoles(ctx, req)
+			}),
+		},
+		{
+			MethodName: "SetUserTokenTTL",
+			Handler: handler(SetUserTokenTTLMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.SetUserTokenTTL(ctx, req)
+			}),
+		},
+		{
+			MethodName: "CreateInvite",
+			Handler: handler(CreateInviteMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.CreateInvite(ctx, req)
+			}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUsers",
+			Handler:       streamUsersHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: fileName,
+}
+
+func streamUsersHandler(srv any, stream grpc.ServerStream) error {
+	in := new(structpb.Struct)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+
+	return srv.(Server).StreamUsers(in, &grpc.GenericServerStream[structpb.Struct, structpb.Struct]{ServerStream: stream})
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the request and response.
+func handler[Req any](
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *Req) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*Req))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.admin.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+			"google/protobuf/wrappers.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Admin"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("GetServerInfo"),
+					InputType:  proto.String(".google.protobuf.Empty"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("RunMaintenance"),
+					InputType:  proto.String(".google.protobuf.Empty"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("ListFailedTasks"),
+					InputType:  proto.String(".google.protobuf.Empty"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("LookupToken"),
+					InputType:  proto.String(".google.protobuf.StringValue"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("AssignRoleBulk"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("ListUsers"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("GetStats"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:            proto.String("StreamUsers"),
+					InputType:       proto.String(".google.protobuf.Struct"),
+					OutputType:      proto.String(".google.protobuf.Struct"),
+					ServerStreaming: proto.Bool(true),
+				},
+				{
+					Name:       proto.String("UsersExist"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("UserRoles"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("SetUserTokenTTL"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("CreateInvite"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -2,18 +2,48 @@ package auth
 
 import (
 	"context"
-	"errors"
-	"sso/internal/services/auth"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/captcha"
 
 	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
 	emptyValue = 0
+	// tosVersionHeader carries the accepted terms of service version of
+	// Register until RegisterRequest has a field for it.
+	tosVersionHeader = "x-tos-version"
+	// appNameHeader names the app of Login as an alternative to app_id
+	// until LoginRequest has a field for it.
+	appNameHeader = "x-app-name"
+	// inviteCodeHeader carries the invite of Register while registration
+	// is invite-only, until RegisterRequest has a field for it.
+	inviteCodeHeader = "x-invite-code"
+	// registrationStateHeader is set on a Register response with no user
+	// ID, which concealed registration gives, until RegisterResponse has a
+	// field for it.
+	registrationStateHeader = "x-registration-state"
+	registrationPending     = "pending"
+	// userProfileHeader is set on a Register response that created a
+	// user to its profile, a google.protobuf.Struct in protobuf wire
+	// format, until RegisterResponse has fields for it. See
+	// profileHeader.
+	userProfileHeader = "x-user-profile-bin"
+	// captchaHeader carries the CAPTCHA response Login requires of the
+	// accounts flagged as under attack, until LoginRequest has a field for
+	// it.
+	captchaHeader = "x-captcha-response"
 )
 
 type Auth interface {
@@ -21,8 +51,9 @@ type Auth interface {
 		ctx context.Context,
 		email string,
 		password string,
-		appID int,
+		appID int32,
 	) (token string, err error)
+	ResolveApp(ctx context.Context, appID int32, appName string) (int32, error)
 	RegisterNewUser(
 		ctx context.Context,
 		email string,
@@ -30,7 +61,10 @@ type Auth interface {
 		firstName string,
 		lastName string,
 		middleName string,
-	) (userID int64, err error)
+		tosVersion string,
+		inviteCode string,
+		appID int32,
+	) (models.NewUser, error)
 	UserRole(ctx context.Context, userID int64) (string, error)
 	UserExists(ctx context.Context, userID int64) (bool, error)
 }
@@ -45,16 +79,26 @@ func Register(gRPC *grpc.Server, auth Auth) {
 }
 
 func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.LoginResponse, error) {
-	if err := validateLogin(req); err != nil {
+	appName := header(ctx, appNameHeader)
+	if err := validateLogin(req, appName); err != nil {
 		return nil, err
 	}
 
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
+	appID, err := s.auth.ResolveApp(ctx, req.GetAppId(), appName)
 	if err != nil {
-		if errors.Is(err, auth.ErrInvalidCredentials) {
-			return nil, status.Error(codes.InvalidArgument, "invalid email or password")
-		}
-		return nil, status.Error(codes.Internal, "failed to login")
+		return nil, grpcerr.Status(err)
+	}
+	// App credentials bind the call to their app however it is named.
+	if bound, ok := interceptors.AppFromContext(ctx); ok && bound != appID {
+		return nil, status.Error(codes.PermissionDenied, "app does not match the app credentials")
+	}
+
+	if response := header(ctx, captchaHeader); response != "" {
+		ctx = captcha.NewContext(ctx, response)
+	}
+	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), appID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
 	}
 
 	return &ssov1.LoginResponse{
@@ -66,29 +110,70 @@ func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 	if err := validateRegister(req); err != nil {
 		return nil, err
 	}
+	// Only app credentials attribute a registration, and so use up the
+	// user quota of an app: a bare x-app-id header proves nothing.
+	appID, _ := interceptors.AppFromContext(ctx)
 
-	userID, err := s.auth.RegisterNewUser(
+	user, err := s.auth.RegisterNewUser(
 		ctx,
 		req.GetEmail(),
 		req.GetPassword(),
 		req.GetFirstName(),
 		req.GetLastName(),
 		req.GetMiddleName(),
+		header(ctx, tosVersionHeader),
+		header(ctx, inviteCodeHeader),
+		appID,
 	)
 
 	if err != nil {
-		if errors.Is(err, auth.ErrUserExists) {
-			return nil, status.Error(codes.AlreadyExists, "user already exists")
-		}
+		return nil, grpcerr.Status(err)
+	}
 
-		return nil, status.Error(codes.Internal, "internal error")
+	// The outcome goes to the owner of the email, not to the caller.
+	md := metadata.Pairs(registrationStateHeader, registrationPending)
+	if user.ID != 0 {
+		if md, err = profileHeader(user); err != nil {
+			return nil, status.Error(codes.Internal, "failed to encode user profile")
+		}
+	}
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		return nil, status.Error(codes.Internal, "failed to set response header")
 	}
 
 	return &ssov1.RegisterResponse{
-		UserId: userID,
+		UserId: user.ID,
 	}, nil
 }
 
+// profileHeader returns the userProfileHeader of user, with the fields
+// user_id, email, first_name, last_name, middle_name, created_at
+// (RFC 3339, omitted if unknown) and role (empty if none).
+func profileHeader(user models.NewUser) (metadata.MD, error) {
+	fields := map[string]any{
+		"user_id":     user.ID,
+		"email":       user.Email,
+		"first_name":  user.FirstName,
+		"last_name":   user.LastName,
+		"middle_name": user.MiddleName,
+		"role":        user.Role,
+	}
+	if !user.CreatedAt.IsZero() {
+		fields["created_at"] = user.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	profile, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+	b, err := proto.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.Pairs(userProfileHeader, string(b)), nil
+}
+
 func (s *serverAPI) UserRole(ctx context.Context, req *ssov1.UserRoleRequest) (*ssov1.UserRoleResponse, error) {
 	if err := validateUserRole(req); err != nil {
 		return nil, err
@@ -96,10 +181,7 @@ func (s *serverAPI) UserRole(ctx context.Context, req *ssov1.UserRoleRequest) (*
 
 	userRole, err := s.auth.UserRole(ctx, req.GetUserId())
 	if err != nil {
-		if errors.Is(err, auth.ErrUserNotFound) {
-			return nil, status.Error(codes.NotFound, "user not found")
-		}
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, grpcerr.Status(err)
 	}
 
 	return &ssov1.UserRoleResponse{
@@ -114,10 +196,7 @@ func (s *serverAPI) UserExists(ctx context.Context, req *ssov1.UserExistsRequest
 
 	isExists, err := s.auth.UserExists(ctx, req.GetUserId())
 	if err != nil {
-		if errors.Is(err, auth.ErrUserNotFound) {
-			return nil, status.Error(codes.NotFound, "user not found")
-		}
-		return nil, status.Error(codes.Internal, "internal error")
+		return nil, grpcerr.Status(err)
 	}
 
 	return &ssov1.UserExistsResponse{
@@ -125,7 +204,17 @@ func (s *serverAPI) UserExists(ctx context.Context, req *ssov1.UserExistsRequest
 	}, nil
 }
 
-func validateLogin(req *ssov1.LoginRequest) error {
+// header returns the first value of the metadata key, or an empty string.
+func header(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func validateLogin(req *ssov1.LoginRequest, appName string) error {
 	if req.GetEmail() == "" {
 		return status.Error(codes.InvalidArgument, "email is required")
 	}
@@ -134,10 +223,14 @@ func validateLogin(req *ssov1.LoginRequest) error {
 		return status.Error(codes.InvalidArgument, "password is required")
 	}
 
-	if req.GetAppId() == emptyValue {
+	if req.GetAppId() == emptyValue && appName == "" {
 		return status.Error(codes.InvalidArgument, "app_id is required")
 	}
 
+	if req.GetAppId() < 0 {
+		return status.Error(codes.InvalidArgument, "app_id must be positive")
+	}
+
 	return nil
 }
 
@@ -166,6 +259,10 @@ func validateUserRole(req *ssov1.UserRoleRequest) error {
 		return status.Error(codes.InvalidArgument, "user_id is required")
 	}
 
+	if req.GetUserId() < 0 {
+		return status.Error(codes.InvalidArgument, "user_id must be positive")
+	}
+
 	return nil
 }
 
@@ -174,5 +271,9 @@ func validateUserExists(req *ssov1.UserExistsRequest) error {
 		return status.Error(codes.InvalidArgument, "user_id is required")
 	}
 
+	if req.GetUserId() < 0 {
+		return status.Error(codes.InvalidArgument, "user_id must be positive")
+	}
+
 	return nil
 }
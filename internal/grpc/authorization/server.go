@@ -0,0 +1,257 @@
+// Package authorization implements sso.authorization.v1.Authorizations,
+// the authorization-code flow: an app starts an authorization, its login
+// page logs the user in for a code and the app exchanges the code for a
+// token.
+//
+// The service is not part of course-work-protos yet, so, like the Quotas
+// service, its descriptor is built here from well-known types. Requests
+// and responses are Structs:
+//
+//	grpcurl -plaintext -d '{"app_id": 2, "redirect_uri": "https://lms.example.com/callback", "state": "xyz"}' \
+//		localhost:44044 sso.authorization.v1.Authorizations/StartAuthorization
+//	grpcurl -plaintext -d '{"authorization_id": "...", "email": "...", "password": "..."}' \
+//		localhost:44044 sso.authorization.v1.Authorizations/LoginAuthorization
+//	grpcurl -plaintext -d '{"code": "...", "app_id": 2, "app_secret": "...", "redirect_uri": "https://lms.example.com/callback"}' \
+//		localhost:44044 sso.authorization.v1.Authorizations/ExchangeAuthorizationCode
+package authorization
+
+import (
+	"context"
+	"math"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/captcha"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.authorization.v1.Authorizations"
+	fileName    = "sso/authorization.proto"
+
+	// The full names of the methods. They are called before the user has
+	// a token, so they need no policy.
+	StartAuthorizationMethod        = "/" + serviceName + "/StartAuthorization"
+	LoginAuthorizationMethod        = "/" + serviceName + "/LoginAuthorization"
+	ExchangeAuthorizationCodeMethod = "/" + serviceName + "/ExchangeAuthorizationCode"
+)
+
+type Authorizer interface {
+	StartAuthorization(ctx context.Context, appID int32, redirectURI string, state string) (string, error)
+	LoginAuthorization(ctx context.Context, authorizationID string, email string, password string) (string, error)
+	ExchangeAuthorizationCode(
+		ctx context.Context,
+		code string,
+		appID int32,
+		appSecret string,
+		redirectURI string,
+	) (string, error)
+}
+
+// Server is the handler interface of the Authorizations service.
+type Server interface {
+	StartAuthorization(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	LoginAuthorization(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	ExchangeAuthorizationCode(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	authorizer Authorizer
+}
+
+func Register(gRPC *grpc.Server, authorizer Authorizer) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{authorizer: authorizer})
+}
+
+// StartAuthorization starts an authorization of app_id that redirects to
+// redirect_uri with state, and returns its authorization_id.
+func (s *serverAPI) StartAuthorization(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	if stringField(req, "redirect_uri") == "" {
+		return nil, status.Error(codes.InvalidArgument, "redirect_uri is required")
+	}
+
+	id, err := s.authorizer.StartAuthorization(ctx, appID, stringField(req, "redirect_uri"), stringField(req, "state"))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return response(map[string]any{"authorization_id": id})
+}
+
+// LoginAuthorization logs the user in for authorization_id and returns
+// the redirect_uri with the code. captcha_response carries the CAPTCHA
+// response of accounts flagged as under attack, as x-captcha-response does
+// for Login.
+func (s *serverAPI) LoginAuthorization(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	for _, name := range []string{"authorization_id", "email", "password"} {
+		if stringField(req, name) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is required", name)
+		}
+	}
+
+	if response := stringField(req, "captcha_response"); response != "" {
+		ctx = captcha.NewContext(ctx, response)
+	}
+	redirect, err := s.authorizer.LoginAuthorization(ctx,
+		stringField(req, "authorization_id"),
+		stringField(req, "email"),
+		stringField(req, "password"),
+	)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return response(map[string]any{"redirect_uri": redirect})
+}
+
+// ExchangeAuthorizationCode exchanges code for a token of app_id, which
+// authenticates with app_secret and the redirect_uri it started with.
+func (s *serverAPI) ExchangeAuthorizationCode(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"code", "app_secret", "redirect_uri"} {
+		if stringField(req, name) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is required", name)
+		}
+	}
+
+	token, err := s.authorizer.ExchangeAuthorizationCode(ctx,
+		stringField(req, "code"),
+		appID,
+		stringField(req, "app_secret"),
+		stringField(req, "redirect_uri"),
+	)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return response(map[string]any{"token": token})
+}
+
+func stringField(req *structpb.Struct, name string) string {
+	return req.GetFields()[name].GetStringValue()
+}
+
+func appIDField(req *structpb.Struct) (int32, error) {
+	n, ok := req.GetFields()["app_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > math.MaxInt32 {
+		return 0, status.Error(codes.InvalidArgument, "app_id must be a positive integer")
+	}
+
+	return int32(n.NumberValue), nil
+}
+
+func response(fields map[string]any) (*structpb.Struct, error) {
+	resp, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartAuthorization",
+			Handler: handler(StartAuthorizationMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.StartAuthorization(ctx, req)
+			}),
+		},
+		{
+			MethodName: "LoginAuthorization",
+			Handler: handler(LoginAuthorizationMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.LoginAuthorization(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ExchangeAuthorizationCode",
+			Handler: handler(ExchangeAuthorizationCodeMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ExchangeAuthorizationCode(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(".google.protobuf.Struct"),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fileName),
+		Package:    proto.String("sso.authorization.v1"),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Authorizations"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("StartAuthorization"),
+				method("LoginAuthorization"),
+				method("ExchangeAuthorizationCode"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,161 @@
+// Package challenge implements sso.challenge.v1.Challenges, which
+// completes the challenges a login has to pass before tokens are issued.
+//
+// Login gives the first challenge in the metadata of a CHALLENGE_REQUIRED
+// error until LoginResponse has a field for it. CompleteChallenge answers
+// with either the token or the next challenge, with the same fields:
+//
+//	grpcurl -plaintext -d '{"challenge_token": "...", "payload": {"tos_version": "2024-06"}}' \
+//		localhost:44044 sso.challenge.v1.Challenges/CompleteChallenge
+//
+// The service is not part of course-work-protos yet, so, like the
+// Identities service, its descriptor is built here from well-known types.
+package challenge
+
+import (
+	"context"
+	"errors"
+
+	"sso/internal/domain/errs"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.challenge.v1.Challenges"
+	fileName    = "sso/challenge.proto"
+
+	// CompleteChallengeMethod is the full name of CompleteChallenge. It
+	// needs no token: the challenge token stands for the login.
+	CompleteChallengeMethod = "/" + serviceName + "/CompleteChallenge"
+)
+
+type Completer interface {
+	CompleteChallenge(ctx context.Context, token string, payload map[string]string) (string, error)
+}
+
+// Server is the handler interface of the Challenges service.
+type Server interface {
+	CompleteChallenge(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	completer Completer
+}
+
+func Register(gRPC *grpc.Server, completer Completer) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{completer: completer})
+}
+
+// CompleteChallenge completes the challenge of challenge_token with the
+// string fields of payload. The response has either token, once the
+// login is done, or challenge, the next one.
+func (s *serverAPI) CompleteChallenge(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	token := req.GetFields()["challenge_token"].GetStringValue()
+	if token == "" {
+		return nil, status.Error(codes.InvalidArgument, "challenge_token is required")
+	}
+
+	payload := make(map[string]string)
+	for name, v := range req.GetFields()["payload"].GetStructValue().GetFields() {
+		str, ok := v.GetKind().(*structpb.Value_StringValue)
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "payload.%s must be a string", name)
+		}
+		payload[name] = str.StringValue
+	}
+
+	var fields map[string]any
+	loginToken, err := s.completer.CompleteChallenge(ctx, token, payload)
+	switch {
+	case errors.Is(err, errs.ErrChallengeRequired):
+		next := make(map[string]any)
+		for k, v := range errs.MetadataOf(err) {
+			next[k] = v
+		}
+		fields = map[string]any{"challenge": next}
+	case err != nil:
+		return nil, grpcerr.Status(err)
+	default:
+		fields = map[string]any{"token": loginToken}
+	}
+
+	resp, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CompleteChallenge",
+			Handler:    completeChallengeHandler,
+		},
+	},
+	Metadata: fileName,
+}
+
+func completeChallengeHandler(
+	srv any,
+	ctx context.Context,
+	dec func(any) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (any, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).CompleteChallenge(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CompleteChallengeMethod,
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(Server).CompleteChallenge(ctx, req.(*structpb.Struct))
+	}
+
+	return interceptor(ctx, in, info, handler)
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fileName),
+		Package:    proto.String("sso.challenge.v1"),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Challenges"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("CompleteChallenge"),
+				InputType:  proto.String(".google.protobuf.Struct"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
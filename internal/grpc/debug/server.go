@@ -0,0 +1,140 @@
+// Package debug implements sso.debug.v1.Debug, a development-only service
+// that is never registered outside of local mode.
+//
+// The service is not part of course-work-protos, so its descriptor is built
+// here from well-known types. That is enough for server reflection and
+// tools like grpcurl:
+//
+//	grpcurl -plaintext -d '"<token>"' localhost:44044 sso.debug.v1.Debug/WhoAmI
+package debug
+
+import (
+	"context"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/jwt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	serviceName = "sso.debug.v1.Debug"
+	fileName    = "sso/debug.proto"
+)
+
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (jwt.Claims, error)
+}
+
+// Server is the handler interface of the Debug service.
+type Server interface {
+	WhoAmI(ctx context.Context, req *wrapperspb.StringValue) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	validator TokenValidator
+}
+
+func Register(gRPC *grpc.Server, validator TokenValidator) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{validator: validator})
+}
+
+// WhoAmI echoes the claims of a valid token. The token is taken from the
+// request or, when it is empty, from the "authorization: Bearer" header.
+func (s *serverAPI) WhoAmI(ctx context.Context, req *wrapperspb.StringValue) (*structpb.Struct, error) {
+	token := req.GetValue()
+	if token == "" {
+		token = interceptors.BearerToken(ctx)
+	}
+	if token == "" {
+		return nil, status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	claims, err := s.validator.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(claims.Raw)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode claims")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WhoAmI",
+			Handler:    whoAmIHandler,
+		},
+	},
+	Metadata: fileName,
+}
+
+func whoAmIHandler(
+	srv any,
+	ctx context.Context,
+	dec func(any) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).WhoAmI(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/WhoAmI",
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(Server).WhoAmI(ctx, req.(*wrapperspb.StringValue))
+	}
+
+	return interceptor(ctx, in, info, handler)
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it. Registering the descriptor does not expose the service.
+func init() {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.debug.v1"),
+		Dependency: []string{
+			"google/protobuf/wrappers.proto",
+			"google/protobuf/struct.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Debug"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("WhoAmI"),
+				InputType:  proto.String(".google.protobuf.StringValue"),
+				OutputType: proto.String(".google.protobuf.Struct"),
+			}},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
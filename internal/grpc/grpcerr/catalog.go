@@ -0,0 +1,163 @@
+package grpcerr
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"sso/internal/domain/errs"
+
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// AcceptLanguageHeader carries the languages the client prefers, in the
+// syntax of the HTTP header, see Catalog.Localize.
+const AcceptLanguageHeader = "accept-language"
+
+// maxAcceptLanguageLen bounds the header parsed for every failed call.
+const maxAcceptLanguageLen = 256
+
+// messageFiles are the translations built into the server, one
+// messages/<BCP 47 tag>.json per language, each an object from reasons to
+// messages. Adding a language is adding a file.
+//
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+// Catalog translates the public messages of Status. English is the
+// language of mappings and needs no file; a reason missing from the file
+// of another language is left in English.
+type Catalog struct {
+	// tags are the languages, English first, as the fallback of matcher.
+	tags    []language.Tag
+	matcher language.Matcher
+	// messages are the messages of tags by reason, nil for English.
+	messages []map[string]string
+}
+
+var defaultCatalog = func() *Catalog {
+	c, err := NewCatalog(messageFiles, "messages")
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}()
+
+// DefaultCatalog returns the catalog of the translations built in.
+func DefaultCatalog() *Catalog {
+	return defaultCatalog
+}
+
+// NewCatalog reads the *.json translations in dir of fsys. A file named
+// after an invalid tag, or translating a reason Status never returns, is
+// an error, so typos are caught when the catalog is built.
+func NewCatalog(fsys fs.FS, dir string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{
+		tags:     []language.Tag{language.English},
+		messages: []map[string]string{nil},
+	}
+	for _, file := range files {
+		tag, err := language.Parse(strings.TrimSuffix(path.Base(file), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if tag == language.English {
+			return nil, fmt.Errorf("%s: english messages are those of the mappings", file)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for reason, message := range messages {
+			if _, ok := mappings[errs.Code(reason)]; !ok {
+				return nil, fmt.Errorf("%s: unknown reason %q", file, reason)
+			}
+			if message == "" {
+				return nil, fmt.Errorf("%s: empty message for %q", file, reason)
+			}
+		}
+
+		c.tags = append(c.tags, tag)
+		c.messages = append(c.messages, messages)
+	}
+	c.matcher = language.NewMatcher(c.tags)
+
+	return c, nil
+}
+
+// Languages returns the languages of the catalog, English first.
+func (c *Catalog) Languages() []language.Tag {
+	return append([]language.Tag(nil), c.tags...)
+}
+
+// Message returns the message of reason in the language acceptLanguage
+// prefers most among those of the catalog. ok is false if that is
+// English or the language has no translation of reason: the message of
+// the mappings stands then.
+func (c *Catalog) Message(acceptLanguage, reason string) (msg string, ok bool) {
+	if acceptLanguage == "" || len(acceptLanguage) > maxAcceptLanguageLen {
+		return "", false
+	}
+
+	preferred, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(preferred) == 0 {
+		return "", false
+	}
+	_, i, confidence := c.matcher.Match(preferred...)
+	if confidence == language.No {
+		return "", false
+	}
+
+	msg, ok = c.messages[i][reason]
+
+	return msg, ok
+}
+
+// Localize returns err with its message in the language acceptLanguage
+// prefers, if err is a status of Status with a translated reason. The
+// code and the details, the reason included, are kept, so clients can
+// still match on them. Other errors are returned as they are, as are
+// InvalidArgument ones with a message of their own, which a translation
+// of the reason would make less specific.
+func (c *Catalog) Localize(err error, acceptLanguage string) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	reason := ""
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == Domain {
+			reason = info.GetReason()
+		}
+	}
+	if m, ok := mappings[errs.Code(reason)]; reason == "" || !ok || st.Message() != m.message {
+		return err
+	}
+
+	msg, ok := c.Message(acceptLanguage, reason)
+	if !ok {
+		return err
+	}
+
+	p := st.Proto()
+	p.Message = msg
+
+	return status.FromProto(p).Err()
+}
@@ -0,0 +1,135 @@
+package grpcerr
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCatalog_Localize(t *testing.T) {
+	catalog := DefaultCatalog()
+
+	tests := []struct {
+		name   string
+		err    error
+		wantEn string
+		wantRu string
+	}{
+		{
+			name:   "invalid credentials",
+			err:    errs.ErrInvalidCredentials,
+			wantEn: "invalid email or password",
+			wantRu: "неверный email или пароль",
+		},
+		{
+			name:   "user exists",
+			err:    errs.ErrUserExists,
+			wantEn: "user already exists",
+			wantRu: "пользователь уже существует",
+		},
+		{
+			name:   "invalid token",
+			err:    errs.ErrInvalidToken,
+			wantEn: "invalid token",
+			wantRu: "недействительный токен",
+		},
+		{
+			name:   "registration disabled",
+			err:    errs.ErrRegistrationDisabled,
+			wantEn: "registration is disabled",
+			wantRu: "регистрация отключена",
+		},
+		{
+			name:   "pending deletion",
+			err:    errs.ErrAccountPendingDeletion,
+			wantEn: "account is pending deletion",
+			wantRu: "учётная запись ожидает удаления",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Status(errs.Wrap("auth.Login", tt.err))
+
+			for accept, want := range map[string]string{
+				"":                        tt.wantEn,
+				"en-US":                   tt.wantEn,
+				"ru":                      tt.wantRu,
+				"ru-RU,ru;q=0.9,en;q=0.8": tt.wantRu,
+				"en;q=0.5, ru":            tt.wantRu,
+				"fr-FR":                   tt.wantEn,
+				"not a language tag!":     tt.wantEn,
+			} {
+				st := status.Convert(catalog.Localize(err, accept))
+				assert.Equal(t, want, st.Message(), "accept-language %q", accept)
+				// Only the message changes.
+				assert.Equal(t, status.Code(err), st.Code())
+				assert.Equal(t, reason(status.Convert(err)), reason(st))
+			}
+		})
+	}
+}
+
+func TestCatalog_LeavesOtherErrors(t *testing.T) {
+	catalog := DefaultCatalog()
+
+	validation := status.Error(codes.InvalidArgument, "email is required")
+	assert.Equal(t, validation, catalog.Localize(validation, "ru"))
+
+	// A message of its own is kept over the translation of the reason.
+	specific := Status(errs.New(errs.InvalidArgument, "uses must be positive"))
+	assert.Equal(t, "uses must be positive", status.Convert(catalog.Localize(specific, "ru")).Message())
+	generic := Status(&errs.Error{Code: errs.InvalidArgument, Err: errors.New("bad")})
+	assert.Equal(t, "некорректный запрос", status.Convert(catalog.Localize(generic, "ru")).Message())
+
+	internal := Status(errors.New("database is locked"))
+	assert.Equal(t, "internal error", status.Convert(catalog.Localize(internal, "ru")).Message())
+
+	plain := errors.New("not a status")
+	assert.Equal(t, plain, catalog.Localize(plain, "ru"))
+}
+
+func TestCatalog_FallsBackToEnglish(t *testing.T) {
+	catalog, err := NewCatalog(fstest.MapFS{
+		"messages/ru.json": {Data: []byte(`{"USER_NOT_FOUND": "пользователь не найден"}`)},
+	}, "messages")
+	require.NoError(t, err)
+
+	st := status.Convert(catalog.Localize(Status(errs.ErrUserNotFound), "ru"))
+	assert.Equal(t, "пользователь не найден", st.Message())
+	st = status.Convert(catalog.Localize(Status(errs.ErrUserExists), "ru"))
+	assert.Equal(t, "user already exists", st.Message())
+}
+
+func TestNewCatalog_Rejects(t *testing.T) {
+	for name, file := range map[string]string{
+		"messages/ru.json":     `{"NO_SUCH_REASON": "нет"}`,
+		"messages/de.json":     `{"USER_NOT_FOUND": ""}`,
+		"messages/xx-!!.json":  `{}`,
+		"messages/en.json":     `{"USER_NOT_FOUND": "user not found"}`,
+		"messages/broken.json": `{`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewCatalog(fstest.MapFS{name: {Data: []byte(file)}}, "messages")
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestDefaultCatalog_Complete keeps the built-in translations in step
+// with the mappings, so new codes do not go untranslated unnoticed.
+func TestDefaultCatalog_Complete(t *testing.T) {
+	catalog := DefaultCatalog()
+	require.Len(t, catalog.Languages(), 2)
+
+	for code := range mappings {
+		_, ok := catalog.Message("ru", string(code))
+		assert.True(t, ok, "no russian message for %s", code)
+	}
+}
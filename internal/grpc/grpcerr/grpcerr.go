@@ -0,0 +1,177 @@
+// Package grpcerr maps errs codes to gRPC statuses. Every handler returns
+// service errors through Status so clients see the same code and message
+// for the same failure whichever RPC they call.
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// Domain is the ErrorInfo domain of statuses returned by the server.
+	Domain = "sso"
+	// RetryDelay is the RetryInfo delay of Unavailable statuses: failures
+	// that may pass when retried, though not at once.
+	RetryDelay = time.Second
+)
+
+type mapping struct {
+	code    codes.Code
+	message string
+}
+
+// mappings hold the public message of every code. Codes missing here are
+// reported as Internal without details.
+var mappings = map[errs.Code]mapping{
+	errs.InvalidArgument:          {codes.InvalidArgument, "invalid argument"},
+	errs.UserNotFound:             {codes.NotFound, "user not found"},
+	errs.UserExists:               {codes.AlreadyExists, "user already exists"},
+	errs.InvalidCredentials:       {codes.InvalidArgument, "invalid email or password"},
+	errs.AppNotFound:              {codes.InvalidArgument, "invalid app_id"},
+	errs.InvalidToken:             {codes.Unauthenticated, "invalid token"},
+	errs.TokenBindingMismatch:     {codes.Unauthenticated, "token is bound to another client certificate"},
+	errs.NotAdmin:                 {codes.PermissionDenied, "permission denied"},
+	errs.NoCaller:                 {codes.PermissionDenied, "permission denied"},
+	errs.Locked:                   {codes.PermissionDenied, "account is locked"},
+	errs.BatchTooLarge:            {codes.InvalidArgument, "too many user ids in one request"},
+	errs.RangeTooLarge:            {codes.InvalidArgument, "time range is too large"},
+	errs.TermsVersionMismatch:     {codes.InvalidArgument, "tos_version is not the required version"},
+	errs.TermsReacceptRequired:    {codes.FailedPrecondition, "terms of service must be accepted again"},
+	errs.RedirectURINotAllowed:    {codes.InvalidArgument, "redirect_uri is not allowed"},
+	errs.RedirectURIMismatch:      {codes.InvalidArgument, "redirect_uri does not match"},
+	errs.AuthorizationNotFound:    {codes.NotFound, "authorization not found"},
+	errs.AuthorizationExpired:     {codes.FailedPrecondition, "authorization expired"},
+	errs.AuthorizationUsed:        {codes.InvalidArgument, "invalid code"},
+	errs.InvalidCode:              {codes.InvalidArgument, "invalid code"},
+	errs.CodeExpired:              {codes.InvalidArgument, "invalid code"},
+	errs.WebhookNotFound:          {codes.NotFound, "webhook not found"},
+	errs.RegistrationDisabled:     {codes.FailedPrecondition, "registration is disabled"},
+	errs.InviteRequired:           {codes.InvalidArgument, "invite code is required"},
+	errs.InvalidInvite:            {codes.InvalidArgument, "invalid invite code"},
+	errs.InviteExpired:            {codes.InvalidArgument, "invite code expired"},
+	errs.EmailDomainNotAllowed:    {codes.InvalidArgument, "email domain is not allowed"},
+	errs.ReservedIdentifier:       {codes.InvalidArgument, "email is reserved"},
+	errs.CanonicalEmailExists:     {codes.AlreadyExists, "user with an equivalent email already exists"},
+	errs.UnknownProvider:          {codes.InvalidArgument, "unknown identity provider"},
+	errs.IdentityLinked:           {codes.AlreadyExists, "identity is linked to another account"},
+	errs.IdentityNotFound:         {codes.NotFound, "identity not found"},
+	errs.LastLoginMethod:          {codes.FailedPrecondition, "cannot remove the last login method"},
+	errs.AccountPendingDeletion:   {codes.FailedPrecondition, "account is pending deletion"},
+	errs.InvalidReactivationToken: {codes.InvalidArgument, "invalid reactivation token"},
+	errs.ChallengeRequired:        {codes.FailedPrecondition, "login challenge must be completed"},
+	errs.InvalidChallenge:         {codes.InvalidArgument, "invalid or expired login challenge"},
+	errs.PermissionNotFound:       {codes.NotFound, "permission not found"},
+	errs.TokenNotIssued:           {codes.NotFound, "no issuance recorded for the token"},
+	errs.OrgUnitNotFound:          {codes.NotFound, "org unit not found"},
+	errs.OrgUnitExists:            {codes.AlreadyExists, "org unit with this name already exists under the parent"},
+	errs.OrgUnitCycle:             {codes.FailedPrecondition, "org unit cannot be moved under itself"},
+	errs.AccountNotActivated:      {codes.FailedPrecondition, "account is not activated"},
+	errs.AccountActivated:         {codes.FailedPrecondition, "account is already activated"},
+	errs.InvalidActivationToken:   {codes.InvalidArgument, "invalid or expired activation token"},
+	errs.QuotaExceeded:            {codes.FailedPrecondition, "app user quota exceeded"},
+	errs.CaptchaRequired:          {codes.FailedPrecondition, "captcha must be solved"},
+	errs.DependencyTimeout:        {codes.Unavailable, "service temporarily unavailable"},
+	errs.StorageUnavailable:       {codes.Unavailable, "service temporarily unavailable"},
+	errs.SchemaDrift:              {codes.Unavailable, "service temporarily unavailable"},
+}
+
+// Status returns the gRPC status error for err. Statuses pass through
+// unchanged and context errors become Canceled or DeadlineExceeded. Known
+// codes have the message of their mapping, InvalidArgument ones that of
+// err if it has one, and carry an ErrorInfo whose reason is the errs code and whose
+// metadata is errs.MetadataOf(err), and Unavailable ones a RetryInfo of
+// RetryDelay. Errors with a retry_after metadata, a time.Duration, carry a
+// RetryInfo of it whatever their code. Anything else is Internal, so causes never leak to clients
+// unless made Verbose. A nil err gives nil.
+func Status(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, "canceled")
+	}
+
+	code := errs.CodeOf(err)
+	m, ok := mappings[code]
+	if !ok {
+		return &internalError{cause: err}
+	}
+
+	metadata := errs.MetadataOf(err)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   string(code),
+		Domain:   Domain,
+		Metadata: metadata,
+	}}
+	if delay, err := time.ParseDuration(metadata["retry_after"]); err == nil && delay > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(delay)})
+	} else if m.code == codes.Unavailable {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(RetryDelay)})
+	}
+	message := m.message
+	if code == errs.InvalidArgument {
+		message = invalidArgument(err, m.message)
+	}
+	st, detailErr := status.New(m.code, message).WithDetails(details...)
+	if detailErr != nil {
+		return status.Error(m.code, message)
+	}
+
+	return st.Err()
+}
+
+// invalidArgument returns the message of the first Error in the chain of
+// err that has one, which says what is wrong with the request, or
+// fallback. Only InvalidArgument errors are reported so: their messages
+// are written for clients, while those of other codes may not be.
+func invalidArgument(err error, fallback string) string {
+	for err != nil {
+		if e, ok := err.(*errs.Error); ok && e.Message != "" {
+			return e.Message
+		}
+		err = errors.Unwrap(err)
+	}
+
+	return fallback
+}
+
+// internalError is the Internal status of Status, which keeps its cause
+// for Verbose.
+type internalError struct {
+	cause error
+}
+
+func (e *internalError) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+func (e *internalError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, "internal error")
+}
+
+// Cause returns the error an Internal status of Status was made from, or
+// nil for any other error.
+func Cause(err error) error {
+	var internal *internalError
+	if errors.As(err, &internal) {
+		return internal.cause
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package grpcerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    codes.Code
+		wantMessage string
+		wantReason  string
+	}{
+		{
+			name:        "user not found",
+			err:         errs.Wrap("auth.UserRole", errs.ErrUserNotFound),
+			wantCode:    codes.NotFound,
+			wantMessage: "user not found",
+			wantReason:  "USER_NOT_FOUND",
+		},
+		{
+			name:        "invalid credentials",
+			err:         errs.Wrap("auth.Login", errs.ErrInvalidCredentials),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "invalid email or password",
+			wantReason:  "INVALID_CREDENTIALS",
+		},
+		{
+			name:        "app not found",
+			err:         errs.Wrap("auth.Login", errs.ErrAppNotFound),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "invalid app_id",
+			wantReason:  "APP_NOT_FOUND",
+		},
+		{
+			name:        "user exists",
+			err:         errs.Wrap("auth.RegisterNewUser", errs.ErrUserExists),
+			wantCode:    codes.AlreadyExists,
+			wantMessage: "user already exists",
+			wantReason:  "USER_EXISTS",
+		},
+		{
+			name:        "locked",
+			err:         errs.Wrap("auth.Login", errs.ErrLocked),
+			wantCode:    codes.PermissionDenied,
+			wantMessage: "account is locked",
+			wantReason:  "LOCKED",
+		},
+		{
+			name:        "registration disabled",
+			err:         errs.Wrap("auth.RegisterNewUser", errs.ErrRegistrationDisabled),
+			wantCode:    codes.FailedPrecondition,
+			wantMessage: "registration is disabled",
+			wantReason:  "REGISTRATION_DISABLED",
+		},
+		{
+			name:        "invite expired",
+			err:         errs.Wrap("auth.RegisterNewUser", errs.ErrInviteExpired),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "invite code expired",
+			wantReason:  "INVITE_EXPIRED",
+		},
+		{
+			name:        "email domain not allowed",
+			err:         errs.Wrap("auth.RegisterNewUser", errs.ErrEmailDomainNotAllowed),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "email domain is not allowed",
+			wantReason:  "EMAIL_DOMAIN_NOT_ALLOWED",
+		},
+		{
+			name:        "invalid argument carries its message",
+			err:         errs.Wrap("auth.CreateInvite", errs.New(errs.InvalidArgument, "uses must be positive")),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "uses must be positive",
+			wantReason:  "INVALID_ARGUMENT",
+		},
+		{
+			name:        "invalid argument without a message",
+			err:         errs.Wrap("auth.CreateInvite", &errs.Error{Code: errs.InvalidArgument, Err: errors.New("bad")}),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "invalid argument",
+			wantReason:  "INVALID_ARGUMENT",
+		},
+		{
+			name:        "other codes keep the public message",
+			err:         errs.Wrap("auth.Login", &errs.Error{Code: errs.Locked, Message: "locked by admin 7"}),
+			wantCode:    codes.PermissionDenied,
+			wantMessage: "account is locked",
+			wantReason:  "LOCKED",
+		},
+		{
+			name:        "internal",
+			err:         errs.Wrap("storage.sqlite.User", errors.New("database is locked")),
+			wantCode:    codes.Internal,
+			wantMessage: "internal error",
+		},
+		{
+			name:        "role not found is not public",
+			err:         errs.ErrRoleNotFound,
+			wantCode:    codes.Internal,
+			wantMessage: "internal error",
+		},
+		{
+			name:        "dependency timeout",
+			err:         errs.Wrap("auth.Login", &errs.Error{Code: errs.DependencyTimeout, Err: errors.New("storage timed out after 2s")}),
+			wantCode:    codes.Unavailable,
+			wantMessage: "service temporarily unavailable",
+			wantReason:  "DEPENDENCY_TIMEOUT",
+		},
+		{
+			name:        "deadline",
+			err:         errs.Wrap("auth.Login", context.DeadlineExceeded),
+			wantCode:    codes.DeadlineExceeded,
+			wantMessage: "deadline exceeded",
+		},
+		{
+			name:        "status passes through",
+			err:         status.Error(codes.InvalidArgument, "email is required"),
+			wantCode:    codes.InvalidArgument,
+			wantMessage: "email is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(Status(tt.err))
+			require.True(t, ok)
+
+			assert.Equal(t, tt.wantCode, st.Code())
+			assert.Equal(t, tt.wantMessage, st.Message())
+			assert.Equal(t, tt.wantReason, reason(st))
+		})
+	}
+}
+
+func TestStatus_Metadata(t *testing.T) {
+	err := errs.Wrap("auth.Login", &errs.Error{
+		Code:     errs.AccountPendingDeletion,
+		Message:  "account is pending deletion",
+		Err:      errors.New("scheduled by user 42"),
+		Metadata: map[string]string{"delete_after": "2026-01-02T03:04:05Z"},
+	})
+
+	st, ok := status.FromError(Status(err))
+	require.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Equal(t, "account is pending deletion", st.Message())
+
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	require.NotNil(t, info)
+	assert.Equal(t, "ACCOUNT_PENDING_DELETION", info.GetReason())
+	assert.Equal(t, map[string]string{"delete_after": "2026-01-02T03:04:05Z"}, info.GetMetadata())
+}
+
+func TestStatus_RetryInfo(t *testing.T) {
+	for _, code := range []errs.Code{errs.StorageUnavailable, errs.DependencyTimeout} {
+		st, ok := status.FromError(Status(errs.Wrap("auth.Login", &errs.Error{Code: code, Err: errors.New("database is locked")})))
+		require.True(t, ok)
+		assert.Equal(t, codes.Unavailable, st.Code(), code)
+		assert.Equal(t, "service temporarily unavailable", st.Message(), code)
+		assert.Equal(t, string(code), reason(st))
+
+		info := retryInfo(st)
+		require.NotNil(t, info, code)
+		assert.Equal(t, RetryDelay, info.GetRetryDelay().AsDuration())
+	}
+
+	// A retry_after says how long to wait, whatever the code.
+	st, _ := status.FromError(Status(errs.Wrap("auth.Login", &errs.Error{
+		Code:     errs.InvalidCredentials,
+		Err:      errs.ErrInvalidCredentials,
+		Metadata: map[string]string{"retry_after": "4s"},
+	})))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.NotNil(t, retryInfo(st))
+	assert.Equal(t, 4*time.Second, retryInfo(st).GetRetryDelay().AsDuration())
+
+	// Failures that fail again are not to be retried.
+	st, _ = status.FromError(Status(errs.Wrap("auth.Login", errs.ErrUserNotFound)))
+	assert.Nil(t, retryInfo(st))
+	st, _ = status.FromError(Status(errs.Wrap("storage.sqlite.SaveUser", errors.New("UNIQUE constraint failed: apps.secret"))))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Nil(t, retryInfo(st))
+}
+
+func retryInfo(st *status.Status) *errdetails.RetryInfo {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			return info
+		}
+	}
+
+	return nil
+}
+
+func TestStatus_DoesNotLeakCauses(t *testing.T) {
+	err := Status(fmt.Errorf("storage.sqlite.User: %w", errors.New("no such table: users")))
+
+	assert.NotContains(t, err.Error(), "users")
+}
+
+func TestStatus_Nil(t *testing.T) {
+	assert.NoError(t, Status(nil))
+}
+
+func reason(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+
+	return ""
+}
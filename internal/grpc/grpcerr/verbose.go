@@ -0,0 +1,89 @@
+package grpcerr
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"sso/internal/domain/errs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// redacted replaces what sanitize strips.
+const redacted = "[redacted]"
+
+// secretPatterns match what must not reach a client even from a verbose
+// server: emails, password hashes, and hex or base64 runs long enough to
+// be digests, keys or tokens.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[^\s@:]+@[^\s@:]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\$2[abxy]?\$\d{2}\$[./A-Za-z0-9]{53}`),
+	regexp.MustCompile(`\$argon2(?:id|i|d)\$[^\s:]+`),
+	regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`),
+}
+
+// Verbose returns err, an error of Status, with what helps find its cause
+// in the logs: every status gets a RequestInfo of requestID, and Internal
+// ones made by Status have the op labels of their cause appended to the
+// message, see Chain. Only the labels are shown, never the messages of
+// the wrapped errors, which may quote SQL or user input. Other errors are
+// returned as they are.
+func Verbose(err error, requestID string) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	p := st.Proto()
+	if cause := Cause(err); cause != nil {
+		if chain := Chain(cause); len(chain) > 0 {
+			p.Message += ": " + strings.Join(chain, ": ")
+		}
+	}
+	st = status.FromProto(p)
+
+	if requestID == "" {
+		return st.Err()
+	}
+	details := make([]protoadapt.MessageV1, 0, len(st.Details())+1)
+	for _, d := range st.Details() {
+		if m, ok := d.(protoadapt.MessageV1); ok {
+			details = append(details, m)
+		}
+	}
+	details = append(details, &errdetails.RequestInfo{RequestId: requestID})
+	withID, detailErr := status.New(st.Code(), st.Message()).WithDetails(details...)
+	if detailErr != nil {
+		return st.Err()
+	}
+
+	return withID.Err()
+}
+
+// Chain returns the sanitized op labels of the errs errors in the chain
+// of err, outermost first.
+func Chain(err error) []string {
+	var ops []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*errs.Error); ok && e.Op != "" {
+			ops = append(ops, sanitize(e.Op))
+		}
+	}
+
+	return ops
+}
+
+// sanitize returns s with everything matching secretPatterns redacted.
+func sanitize(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+
+	return s
+}
@@ -0,0 +1,77 @@
+package grpcerr
+
+import (
+	"errors"
+	"testing"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerbose_Internal(t *testing.T) {
+	cause := errs.Wrap("services.auth.Login",
+		errs.Wrap("storage.sqlite.User", errors.New(`no such column: pass_hash in "SELECT * FROM users WHERE email = 'jane@example.com'"`)))
+	err := Status(cause)
+
+	// Terse: nothing but the code.
+	st := status.Convert(err)
+	assert.Equal(t, "internal error", st.Message())
+	assert.Empty(t, st.Details())
+	assert.Equal(t, cause, Cause(err))
+
+	st = status.Convert(Verbose(err, "req-1"))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "internal error: services.auth.Login: storage.sqlite.User", st.Message())
+	assert.Equal(t, "req-1", requestID(st))
+}
+
+func TestVerbose_KnownCodes(t *testing.T) {
+	err := Verbose(Status(errs.Wrap("services.auth.Login", errs.ErrLocked)), "req-1")
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.PermissionDenied, st.Code())
+	assert.Equal(t, "account is locked", st.Message())
+	assert.Equal(t, string(errs.Locked), reason(st))
+	assert.Equal(t, "req-1", requestID(st))
+	assert.Nil(t, Cause(err))
+}
+
+func TestVerbose_OtherErrors(t *testing.T) {
+	assert.NoError(t, Verbose(nil, "req-1"))
+
+	st := status.Convert(Verbose(status.Error(codes.Internal, "failed to encode response"), ""))
+	assert.Equal(t, "failed to encode response", st.Message())
+	assert.Empty(t, st.Details())
+
+	plain := errors.New("not a status")
+	assert.Equal(t, plain, Verbose(plain, "req-1"))
+}
+
+func TestChain_Sanitized(t *testing.T) {
+	err := errs.Wrap("services.auth.Login jane.doe+lms@example.com",
+		errs.Wrap("storage.sqlite.SaveUser $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy",
+			errs.Wrap("storage.sqlite.Token 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+				errs.Wrap("storage.sqlite.Key c2VjcmV0LWtleS10aGF0LWlzLWxvbmctZW5vdWdo", errors.New("cause")))))
+
+	assert.Equal(t, []string{
+		"services.auth.Login " + redacted,
+		"storage.sqlite.SaveUser " + redacted,
+		"storage.sqlite.Token " + redacted,
+		"storage.sqlite.Key " + redacted,
+	}, Chain(err))
+	assert.Empty(t, Chain(errors.New("cause")))
+}
+
+func requestID(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RequestInfo); ok {
+			return info.GetRequestId()
+		}
+	}
+
+	return ""
+}
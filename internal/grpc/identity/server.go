@@ -0,0 +1,273 @@
+// Package identity implements sso.identity.v1.Identities, which links
+// accounts of federated identity providers to users.
+//
+// The service is not part of course-work-protos yet, so, like the Session
+// service, its descriptor is built here from well-known types. Requests
+// and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' -d '{"provider": "google", "code": "..."}' \
+//		localhost:44044 sso.identity.v1.Identities/LinkIdentity
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' -d '{"user_id": 42}' \
+//		localhost:44044 sso.identity.v1.Identities/ListIdentities
+package identity
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/authctx"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.identity.v1.Identities"
+	fileName    = "sso/identity.proto"
+
+	// The full names of the methods. Each must have a policy requiring a
+	// token, see interceptors.Authorize.
+	LinkIdentityMethod   = "/" + serviceName + "/LinkIdentity"
+	UnlinkIdentityMethod = "/" + serviceName + "/UnlinkIdentity"
+	ListIdentitiesMethod = "/" + serviceName + "/ListIdentities"
+
+	// maxUserID is the largest user_id a Struct number carries exactly.
+	maxUserID = 1 << 53
+)
+
+// Linker links identities. UnlinkIdentity and ListIdentities check that
+// the caller, see authctx, may act for the user.
+type Linker interface {
+	LinkIdentity(ctx context.Context, token, provider, code string) (models.LinkedIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID int64, provider string) error
+	ListIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error)
+}
+
+// Server is the handler interface of the Identities service.
+type Server interface {
+	LinkIdentity(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	UnlinkIdentity(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	ListIdentities(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	linker Linker
+}
+
+func Register(gRPC *grpc.Server, linker Linker) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{linker: linker})
+}
+
+// LinkIdentity links the provider account the code of the request was
+// issued for to the holder of the bearer token. Only one's own account
+// can be linked: the code comes from signing in to the provider.
+func (s *serverAPI) LinkIdentity(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if _, ok := interceptors.ClaimsFromContext(ctx); !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	provider, code := stringField(req, "provider"), stringField(req, "code")
+	if provider == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider is required")
+	}
+	if code == "" {
+		return nil, status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	identity, err := s.linker.LinkIdentity(ctx, interceptors.BearerToken(ctx), provider, code)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(identityFields(identity))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode identity")
+	}
+
+	return resp, nil
+}
+
+// UnlinkIdentity unlinks the account of provider from user_id, the
+// caller by default. Only the user and admins may.
+func (s *serverAPI) UnlinkIdentity(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	userID, err := s.owner(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := stringField(req, "provider")
+	if provider == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider is required")
+	}
+
+	if err := s.linker.UnlinkIdentity(ctx, userID, provider); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ListIdentities lists the accounts linked to user_id, the caller by
+// default. Only the user and admins may.
+func (s *serverAPI) ListIdentities(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	userID, err := s.owner(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := s.linker.ListIdentities(ctx, userID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	list := make([]any, len(identities))
+	for i, identity := range identities {
+		list[i] = identityFields(identity)
+	}
+	resp, err := structpb.NewStruct(map[string]any{"identities": list})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode identities")
+	}
+
+	return resp, nil
+}
+
+// owner returns the user_id of req, or the caller's if it has none.
+// Whether the caller may act for that user is up to the service.
+func (s *serverAPI) owner(ctx context.Context, req *structpb.Struct) (int64, error) {
+	caller, ok := authctx.CallerFromContext(ctx)
+	if !ok {
+		return 0, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	userID := caller.UserID
+	if v, ok := req.GetFields()["user_id"]; ok {
+		n, ok := v.GetKind().(*structpb.Value_NumberValue)
+		if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > maxUserID {
+			return 0, status.Error(codes.InvalidArgument, "user_id must be a positive integer")
+		}
+		userID = int64(n.NumberValue)
+	}
+
+	return userID, nil
+}
+
+func stringField(req *structpb.Struct, name string) string {
+	return req.GetFields()[name].GetStringValue()
+}
+
+func identityFields(identity models.LinkedIdentity) map[string]any {
+	return map[string]any{
+		"provider":  identity.Provider,
+		"subject":   identity.Subject,
+		"email":     identity.Email,
+		"linked_at": identity.LinkedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LinkIdentity",
+			Handler: handler(LinkIdentityMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.LinkIdentity(ctx, req)
+			}),
+		},
+		{
+			MethodName: "UnlinkIdentity",
+			Handler: handler(UnlinkIdentityMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.UnlinkIdentity(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListIdentities",
+			Handler: handler(ListIdentitiesMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ListIdentities(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the response.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(output),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.identity.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Identities"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("LinkIdentity", ".google.protobuf.Struct"),
+				method("UnlinkIdentity", ".google.protobuf.Empty"),
+				method("ListIdentities", ".google.protobuf.Struct"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
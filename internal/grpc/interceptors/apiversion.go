@@ -0,0 +1,87 @@
+package interceptors
+
+import (
+	"context"
+	"strconv"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/apiversion"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// APIVersionHeader carries the API version the client speaks, a
+	// positive integer. Calls without it are served as the oldest version
+	// supported. The response headers carry the version the call is
+	// served as, and APIVersionMinHeader and APIVersionMaxHeader the
+	// range supported.
+	APIVersionHeader    = "x-api-version"
+	APIVersionMinHeader = "x-api-version-min"
+	APIVersionMaxHeader = "x-api-version-max"
+
+	// ReasonUpgradeRequired is the ErrorInfo reason of calls declaring a
+	// version outside the range supported. Its metadata tell the range.
+	ReasonUpgradeRequired = "UPGRADE_REQUIRED"
+)
+
+// APIVersion returns an interceptor negotiating the API version of every
+// call against the versions from minVersion to maxVersion, see
+// apiversion.FromContext. Calls declaring another version, or one that is
+// not a positive integer, fail with FailedPrecondition and
+// ReasonUpgradeRequired.
+func APIVersion(minVersion, maxVersion int) grpc.UnaryServerInterceptor {
+	supported := metadata.Pairs(
+		APIVersionMinHeader, strconv.Itoa(minVersion),
+		APIVersionMaxHeader, strconv.Itoa(maxVersion),
+	)
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		header := supported
+		version := minVersion
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		if declared := first(md, APIVersionHeader); declared != "" {
+			v, err := strconv.Atoi(declared)
+			if err != nil || v < minVersion || v > maxVersion {
+				// Fails only outside a real call, as in tests.
+				_ = grpc.SetHeader(ctx, header)
+
+				return nil, upgradeRequired(printable(declared, maxRequestIDLen), minVersion, maxVersion)
+			}
+			version = v
+		}
+
+		header = metadata.Join(header, metadata.Pairs(APIVersionHeader, strconv.Itoa(version)))
+		_ = grpc.SetHeader(ctx, header)
+
+		return handler(apiversion.NewContext(ctx, version), req)
+	}
+}
+
+func upgradeRequired(declared string, minVersion, maxVersion int) error {
+	msg := "api version " + strconv.Quote(declared) + " is not supported"
+	st, err := status.New(codes.FailedPrecondition, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: ReasonUpgradeRequired,
+		Domain: grpcerr.Domain,
+		Metadata: map[string]string{
+			"requested_version": declared,
+			"min_version":       strconv.Itoa(minVersion),
+			"max_version":       strconv.Itoa(maxVersion),
+		},
+	})
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+
+	return st.Err()
+}
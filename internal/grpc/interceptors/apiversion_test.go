@@ -0,0 +1,73 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/apiversion"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIVersion(t *testing.T) {
+	interceptor := APIVersion(2, 4)
+	call := func(declared ...string) (int, error) {
+		ctx := context.Background()
+		if len(declared) > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(APIVersionHeader, declared[0]))
+		}
+
+		var version int
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: loginMethod}, func(ctx context.Context, _ any) (any, error) {
+			version = apiversion.FromContext(ctx)
+
+			return "ok", nil
+		})
+
+		return version, err
+	}
+
+	tests := []struct {
+		name     string
+		declared []string
+		want     int
+	}{
+		{name: "undeclared is the oldest", want: 2},
+		{name: "oldest", declared: []string{"2"}, want: 2},
+		{name: "between", declared: []string{"3"}, want: 3},
+		{name: "newest", declared: []string{"4"}, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := call(tt.declared...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, version)
+		})
+	}
+
+	for _, declared := range []string{"1", "5", "0", "-3", "v2", "2.0", "99999999999999999999"} {
+		t.Run("rejects "+declared, func(t *testing.T) {
+			version, err := call(declared)
+			assert.Zero(t, version, "the handler must not run")
+
+			st := status.Convert(err)
+			assert.Equal(t, codes.FailedPrecondition, st.Code())
+			require.Len(t, st.Details(), 1)
+			info := st.Details()[0].(*errdetails.ErrorInfo)
+			assert.Equal(t, ReasonUpgradeRequired, info.GetReason())
+			assert.Equal(t, grpcerr.Domain, info.GetDomain())
+			assert.Equal(t, map[string]string{
+				"requested_version": declared,
+				"min_version":       "2",
+				"max_version":       "4",
+			}, info.GetMetadata())
+		})
+	}
+}
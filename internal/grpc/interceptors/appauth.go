@@ -0,0 +1,145 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/clock"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AppIDHeader and AppSecretHeader carry the credentials of the app
+	// client, see AppCredentials.
+	AppIDHeader     = "x-app-id"
+	AppSecretHeader = "x-app-secret"
+
+	// AppCacheTTL is how long a looked up app is trusted, so a rotated
+	// secret takes effect within it.
+	AppCacheTTL = 30 * time.Second
+
+	appCacheSize = 256
+)
+
+type AppProvider interface {
+	App(ctx context.Context, appID int32) (models.App, error)
+}
+
+// AppFromContext returns the ID of the app client authenticated by
+// AppCredentials. ok is false when the method does not require one.
+func AppFromContext(ctx context.Context) (appID int32, ok bool) {
+	return authctx.AppFromContext(ctx)
+}
+
+// AppCredentials returns an interceptor requiring the x-app-id and
+// x-app-secret headers of a registered app for the methods in methods,
+// keyed like Deadline timeouts. Other methods are left alone.
+//
+// The call is bound to that app: a request carrying an app_id of another
+// app is rejected with PermissionDenied, and handlers can compare other
+// ways of naming the app with AppFromContext. Tokens the call carries
+// must be for that app too, see auth.ValidateToken. Missing or wrong
+// credentials are Unauthenticated.
+//
+// Apps are cached for AppCacheTTL by clk. Nil means the system clock.
+func AppCredentials(methods map[string]bool, apps AppProvider, clk clock.Clock) grpc.UnaryServerInterceptor {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	cache := newAppCache(AppCacheTTL, clk)
+
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if required, _ := methodEntry(methods, info.FullMethod); !required {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		ids, secrets := md.Get(AppIDHeader), md.Get(AppSecretHeader)
+		if len(ids) != 1 || len(secrets) != 1 || secrets[0] == "" {
+			return nil, status.Error(codes.Unauthenticated, "app credentials are required")
+		}
+		id, err := strconv.ParseInt(ids[0], 10, 32)
+		if err != nil || id <= 0 {
+			return nil, status.Error(codes.Unauthenticated, "invalid app credentials")
+		}
+		appID := int32(id)
+
+		app, err := cache.app(ctx, apps, appID)
+		if err != nil {
+			if errors.Is(err, errs.ErrAppNotFound) {
+				return nil, status.Error(codes.Unauthenticated, "invalid app credentials")
+			}
+			return nil, grpcerr.Status(err)
+		}
+		// An app without a secret cannot authenticate at all.
+		if app.Secret == "" || subtle.ConstantTimeCompare([]byte(secrets[0]), []byte(app.Secret)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid app credentials")
+		}
+
+		// Without an app_id the handler resolves the app and checks it
+		// against AppFromContext itself.
+		if r, ok := req.(interface{ GetAppId() int32 }); ok && r.GetAppId() != 0 && r.GetAppId() != appID {
+			return nil, status.Error(codes.PermissionDenied, "app_id does not match the app credentials")
+		}
+
+		return handler(authctx.WithApp(ctx, appID), req)
+	}
+}
+
+// appCache keeps recently used apps for ttl. It only holds a handful of
+// entries and is simply emptied when full.
+type appCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	clock clock.Clock
+	items map[int32]cachedApp
+}
+
+type cachedApp struct {
+	app     models.App
+	expires time.Time
+}
+
+func newAppCache(ttl time.Duration, clk clock.Clock) *appCache {
+	return &appCache{ttl: ttl, clock: clk, items: make(map[int32]cachedApp)}
+}
+
+func (c *appCache) app(ctx context.Context, apps AppProvider, appID int32) (models.App, error) {
+	c.mu.Lock()
+	item, ok := c.items[appID]
+	c.mu.Unlock()
+	if ok && c.clock.Now().Before(item.expires) {
+		return item.app, nil
+	}
+
+	app, err := apps.App(ctx, appID)
+	if err != nil {
+		return models.App{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= appCacheSize {
+		clear(c.items)
+	}
+	c.items[appID] = cachedApp{app: app, expires: c.clock.Now().Add(c.ttl)}
+
+	return app, nil
+}
@@ -0,0 +1,164 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const registerMethod = "/auth.Auth/Register"
+
+type fakeApps struct {
+	apps  map[int32]models.App
+	calls int
+}
+
+func (f *fakeApps) App(_ context.Context, appID int32) (models.App, error) {
+	f.calls++
+	app, ok := f.apps[appID]
+	if !ok {
+		return models.App{}, errs.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func newFakeApps() *fakeApps {
+	return &fakeApps{apps: map[int32]models.App{
+		1: {ID: 1, Name: "web", Secret: "web-secret"},
+		2: {ID: 2, Name: "mobile", Secret: "mobile-secret"},
+		3: {ID: 3, Name: "legacy"},
+	}}
+}
+
+func callWithApp(interceptor grpc.UnaryServerInterceptor, method string, req any, kv ...string) (int32, error) {
+	ctx := context.Background()
+	if len(kv) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+	}
+
+	var bound int32
+	_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ any) (any, error) {
+		bound, _ = AppFromContext(ctx)
+		return "ok", nil
+	})
+
+	return bound, err
+}
+
+func TestAppCredentials(t *testing.T) {
+	interceptor := AppCredentials(map[string]bool{"Register": true, "Login": true}, newFakeApps(), nil)
+
+	tests := []struct {
+		name     string
+		method   string
+		req      any
+		md       []string
+		wantCode codes.Code
+		wantApp  int32
+	}{
+		{name: "method without policy", method: "/auth.Auth/UserRole", wantCode: codes.OK},
+		{name: "missing credentials", method: registerMethod, wantCode: codes.Unauthenticated},
+		{
+			name:     "missing secret",
+			method:   registerMethod,
+			md:       []string{AppIDHeader, "1"},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "malformed app id",
+			method:   registerMethod,
+			md:       []string{AppIDHeader, "web", AppSecretHeader, "web-secret"},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unknown app",
+			method:   registerMethod,
+			md:       []string{AppIDHeader, "42", AppSecretHeader, "web-secret"},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "wrong secret",
+			method:   registerMethod,
+			md:       []string{AppIDHeader, "1", AppSecretHeader, "mobile-secret"},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "app without secret",
+			method:   registerMethod,
+			md:       []string{AppIDHeader, "3", AppSecretHeader, " "},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "valid credentials",
+			method:   registerMethod,
+			req:      &ssov1.RegisterRequest{},
+			md:       []string{AppIDHeader, "1", AppSecretHeader, "web-secret"},
+			wantCode: codes.OK,
+			wantApp:  1,
+		},
+		{
+			name:     "login for the same app",
+			method:   loginMethod,
+			req:      &ssov1.LoginRequest{AppId: 2},
+			md:       []string{AppIDHeader, "2", AppSecretHeader, "mobile-secret"},
+			wantCode: codes.OK,
+			wantApp:  2,
+		},
+		{
+			name:     "login for another app",
+			method:   loginMethod,
+			req:      &ssov1.LoginRequest{AppId: 1},
+			md:       []string{AppIDHeader, "2", AppSecretHeader, "mobile-secret"},
+			wantCode: codes.PermissionDenied,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound, err := callWithApp(interceptor, tt.method, tt.req, tt.md...)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantApp, bound)
+		})
+	}
+}
+
+func TestAppCache(t *testing.T) {
+	ctx := context.Background()
+	apps := newFakeApps()
+	clk := clock.NewFake(time.Now())
+	cache := newAppCache(time.Minute, clk)
+
+	for range 3 {
+		app, err := cache.app(ctx, apps, 1)
+		require.NoError(t, err)
+		assert.Equal(t, "web-secret", app.Secret)
+	}
+	assert.Equal(t, 1, apps.calls)
+
+	// A rotated secret is picked up once the entry expires.
+	apps.apps[1] = models.App{ID: 1, Name: "web", Secret: "rotated"}
+	clk.Advance(time.Minute)
+	app, err := cache.app(ctx, apps, 1)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", app.Secret)
+	assert.Equal(t, 2, apps.calls)
+
+	// Unknown apps are not cached.
+	_, err = cache.app(ctx, apps, 42)
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+	_, err = cache.app(ctx, apps, 42)
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+	assert.Equal(t, 4, apps.calls)
+}
@@ -0,0 +1,92 @@
+package interceptors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"sso/internal/lib/audit"
+	"sso/internal/lib/clientip"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RequestIDHeader carries the ID of a call. One sent by the client is
+	// kept, if sane; otherwise one is generated. Either way it is sent
+	// back in the response headers.
+	RequestIDHeader = "x-request-id"
+	// ClientVersionHeader carries the version of the app making a call,
+	// such as "2.3", for the device labels of the tokens it is issued.
+	ClientVersionHeader = "x-client-version"
+
+	maxRequestIDLen     = 128
+	maxUserAgentLen     = 256
+	maxClientVersionLen = 64
+)
+
+// AuditContext returns an interceptor storing the envelope of audit
+// entries in the context, see audit.FromContext. It must run after
+// ClientIP, whose address it records.
+func AuditContext() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		e := audit.Envelope{
+			Method:        info.FullMethod,
+			RequestID:     requestID(md),
+			UserAgent:     printable(first(md, "user-agent"), maxUserAgentLen),
+			ClientVersion: printable(first(md, ClientVersionHeader), maxClientVersionLen),
+		}
+		if ip, ok := clientip.FromContext(ctx); ok {
+			e.ClientIP = ip.String()
+		}
+		// Fails only outside a real call, as in tests; the ID is still
+		// logged.
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, e.RequestID))
+
+		return handler(audit.NewContext(ctx, e), req)
+	}
+}
+
+// requestID returns the request ID sent by the client, or a new one if it
+// sent none or one that does not fit in a log line.
+func requestID(md metadata.MD) string {
+	if id := first(md, RequestIDHeader); id != "" && len(id) <= maxRequestIDLen && printable(id, maxRequestIDLen) == id {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// printable returns s cut to n bytes, without anything but printable
+// ASCII, so that clients cannot forge log lines.
+func printable(s string, n int) string {
+	b := make([]byte, 0, min(len(s), n))
+	for i := 0; i < len(s) && len(b) < n; i++ {
+		if s[i] >= 0x20 && s[i] < 0x7f {
+			b = append(b, s[i])
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,70 @@
+package interceptors
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"sso/internal/lib/audit"
+	"sso/internal/lib/clientip"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func envelopeOf(t *testing.T, ctx context.Context) audit.Envelope {
+	t.Helper()
+
+	var got audit.Envelope
+	_, err := AuditContext()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: loginMethod}, func(ctx context.Context, _ any) (any, error) {
+		got = audit.FromContext(ctx)
+
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	return got
+}
+
+func TestAuditContext(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		RequestIDHeader, "req-1",
+		"user-agent", "lms/2.1 grpc-go/1.70.0",
+		ClientVersionHeader, "2.1",
+	))
+	ctx = clientip.NewContext(ctx, netip.MustParseAddr("198.51.100.4"))
+
+	assert.Equal(t, audit.Envelope{
+		Method:        loginMethod,
+		RequestID:     "req-1",
+		ClientIP:      "198.51.100.4",
+		UserAgent:     "lms/2.1 grpc-go/1.70.0",
+		ClientVersion: "2.1",
+	}, envelopeOf(t, ctx))
+}
+
+func TestAuditContext_Unknown(t *testing.T) {
+	got := envelopeOf(t, context.Background())
+
+	assert.Equal(t, loginMethod, got.Method)
+	assert.Len(t, got.RequestID, 32, "generated")
+	assert.Equal(t, audit.Unknown, got.ClientIP)
+	assert.Equal(t, audit.Unknown, got.UserAgent)
+	assert.Equal(t, audit.Unknown, got.ClientVersion)
+}
+
+func TestAuditContext_Sanitized(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		RequestIDHeader, strings.Repeat("a", maxRequestIDLen+1),
+		"user-agent", "evil\nlevel=ERROR msg=forged",
+		ClientVersionHeader, strings.Repeat("9", maxClientVersionLen+1),
+	))
+
+	got := envelopeOf(t, ctx)
+	assert.Len(t, got.RequestID, 32, "replaced")
+	assert.Equal(t, "evillevel=ERROR msg=forged", got.UserAgent)
+	assert.Len(t, got.ClientVersion, maxClientVersionLen, "cut")
+}
@@ -0,0 +1,169 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"sso/internal/domain/errs"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/jwt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ReasonStepUpRequired is the ErrorInfo reason of calls that need an
+	// elevated token, so UIs can ask for the password and retry.
+	ReasonStepUpRequired = "STEP_UP_REQUIRED"
+)
+
+// Policy is what a method requires of the caller's token.
+type Policy struct {
+	// Role the caller must have. Empty means any valid token.
+	Role string
+	// Elevated requires a step-up token, see auth.ElevatePrivileges and
+	// Session/ElevatePrivileges.
+	Elevated bool
+}
+
+type Authorizer interface {
+	ValidateToken(ctx context.Context, token string) (jwt.Claims, error)
+	UserRole(ctx context.Context, userID int64) (string, error)
+}
+
+// Authorize returns an interceptor enforcing the policy of every method
+// in policies, keyed like Deadline timeouts. Methods without a policy are
+// public. The token is read from the "authorization: Bearer" header, and
+// its holder handed to the handler in the context, see ClaimsFromContext
+// and authctx.CallerFromContext.
+//
+// Every decision is given to decisions, which may be nil, to be logged
+// and, for the callers that ask for it, explained.
+//
+// The token is checked once, before the handler runs: an operation started
+// with a valid elevated token completes even if the token expires while it
+// runs.
+func Authorize(policies map[string]Policy, authorizer Authorizer, decisions *DecisionLog) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		policy, ok := methodEntry(policies, info.FullMethod)
+		if !ok {
+			decisions.record(ctx, Decision{Method: info.FullMethod, Allowed: true, Reason: DecisionNoPolicy})
+
+			return handler(ctx, req)
+		}
+
+		d := Decision{Method: info.FullMethod, Policy: policy}
+		explains := decisions.explains(ctx)
+		claims, err := authorize(ctx, authorizer, &d, explains)
+		decisions.record(ctx, d)
+		if err != nil {
+			if explains && d.Role == decisions.explainRole {
+				return nil, explain(err, d)
+			}
+
+			return nil, err
+		}
+
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+		caller := authctx.Caller{UserID: claims.UserID, AppID: claims.AppID, Elevated: claims.Elevated}
+		if d.Role != "" {
+			caller.Roles = []string{d.Role}
+		}
+
+		return handler(authctx.WithCaller(ctx, caller), req)
+	}
+}
+
+// authorize checks the caller's token against d.Policy, recording the
+// caller and the decision in d. The role is looked up for policies that
+// require one, and for any if explains is true, to tell whether the
+// caller may be given the explanation.
+func authorize(ctx context.Context, authorizer Authorizer, d *Decision, explains bool) (jwt.Claims, error) {
+	policy := d.Policy
+	d.Reason = DecisionTokenMissing
+	token := BearerToken(ctx)
+	if token == "" {
+		return jwt.Claims{}, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	d.Reason = DecisionTokenInvalid
+	claims, err := authorizer.ValidateToken(ctx, token)
+	if err != nil {
+		return jwt.Claims{}, grpcerr.Status(err)
+	}
+	d.UserID, d.Elevated = claims.UserID, claims.Elevated
+
+	if policy.Role != "" || explains {
+		role, err := authorizer.UserRole(ctx, claims.UserID)
+		if err != nil {
+			d.Reason = DecisionRoleLookupFailed
+			// The token outlived its user.
+			if errors.Is(err, errs.ErrUserNotFound) {
+				return jwt.Claims{}, grpcerr.Status(errs.ErrInvalidToken)
+			}
+			return jwt.Claims{}, grpcerr.Status(err)
+		}
+		d.Role = role
+		if policy.Role != "" && role != policy.Role {
+			d.Reason = DecisionRoleMismatch
+
+			return jwt.Claims{}, status.Error(codes.PermissionDenied, "permission denied")
+		}
+	}
+
+	if policy.Elevated && !claims.Elevated {
+		d.Reason = DecisionElevationRequired
+
+		return jwt.Claims{}, stepUpRequired()
+	}
+
+	d.Allowed, d.Reason = true, DecisionPolicySatisfied
+
+	return claims, nil
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token checked by Authorize.
+// ok is false for methods without a policy.
+func ClaimsFromContext(ctx context.Context) (claims jwt.Claims, ok bool) {
+	claims, ok = ctx.Value(claimsKey{}).(jwt.Claims)
+
+	return claims, ok
+}
+
+// BearerToken returns the token of the "authorization: Bearer" header, or
+// an empty string.
+func BearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return token
+		}
+	}
+
+	return ""
+}
+
+func stepUpRequired() error {
+	st, err := status.New(codes.PermissionDenied, "elevated token required").WithDetails(&errdetails.ErrorInfo{
+		Reason: ReasonStepUpRequired,
+		Domain: grpcerr.Domain,
+	})
+	if err != nil {
+		return status.Error(codes.PermissionDenied, "elevated token required")
+	}
+
+	return st.Err()
+}
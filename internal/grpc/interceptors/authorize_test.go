@@ -0,0 +1,175 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const deleteAppMethod = "/auth.Auth/DeleteApp"
+
+// fakeAuthorizer knows a fixed set of tokens and checks their expiry
+// against a clock the test controls.
+type fakeAuthorizer struct {
+	now    time.Time
+	tokens map[string]jwt.Claims
+	roles  map[int64]string
+}
+
+func (f *fakeAuthorizer) ValidateToken(_ context.Context, token string) (jwt.Claims, error) {
+	claims, ok := f.tokens[token]
+	if !ok || !f.now.Before(claims.ExpiresAt) {
+		return jwt.Claims{}, auth.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (f *fakeAuthorizer) UserRole(_ context.Context, userID int64) (string, error) {
+	return f.roles[userID], nil
+}
+
+func newFakeAuthorizer() *fakeAuthorizer {
+	now := time.Now()
+
+	return &fakeAuthorizer{
+		now: now,
+		tokens: map[string]jwt.Claims{
+			"admin":    {UserID: 1, ExpiresAt: now.Add(time.Hour)},
+			"elevated": {UserID: 1, ExpiresAt: now.Add(auth.ElevatedTokenTTL), Elevated: true},
+			"student":  {UserID: 2, ExpiresAt: now.Add(time.Hour)},
+		},
+		roles: map[int64]string{1: auth.AdminRole, 2: "student"},
+	}
+}
+
+func callWithToken(interceptor grpc.UnaryServerInterceptor, method, token string, handler grpc.UnaryHandler) error {
+	ctx := context.Background()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+
+	return err
+}
+
+func okHandler(context.Context, any) (any, error) { return "ok", nil }
+
+func TestAuthorize(t *testing.T) {
+	authorizer := newFakeAuthorizer()
+	interceptor := Authorize(map[string]Policy{
+		"DeleteApp": {Role: auth.AdminRole, Elevated: true},
+	}, authorizer, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{name: "public method", method: loginMethod, wantCode: codes.OK},
+		{name: "missing token", method: deleteAppMethod, wantCode: codes.Unauthenticated},
+		{
+			name:       "unknown token",
+			method:     deleteAppMethod,
+			token:      "forged",
+			wantCode:   codes.Unauthenticated,
+			wantReason: string(errs.InvalidToken),
+		},
+		{name: "not an admin", method: deleteAppMethod, token: "student", wantCode: codes.PermissionDenied},
+		{
+			name:       "admin without elevation",
+			method:     deleteAppMethod,
+			token:      "admin",
+			wantCode:   codes.PermissionDenied,
+			wantReason: ReasonStepUpRequired,
+		},
+		{name: "elevated admin", method: deleteAppMethod, token: "elevated", wantCode: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callWithToken(interceptor, tt.method, tt.token, okHandler)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantReason, errorReason(err))
+		})
+	}
+}
+
+func TestAuthorize_Caller(t *testing.T) {
+	interceptor := Authorize(map[string]Policy{
+		"DeleteApp": {Role: auth.AdminRole, Elevated: true},
+		"Login":     {},
+	}, newFakeAuthorizer(), nil)
+
+	var got []authctx.Caller
+	record := func(ctx context.Context, _ any) (any, error) {
+		if caller, ok := authctx.CallerFromContext(ctx); ok {
+			got = append(got, caller)
+		}
+
+		return "ok", nil
+	}
+
+	require.NoError(t, callWithToken(interceptor, deleteAppMethod, "elevated", record))
+	// The role is only known when the policy asks for one.
+	require.NoError(t, callWithToken(interceptor, loginMethod, "student", record))
+	require.Equal(t, []authctx.Caller{
+		{UserID: 1, Roles: []string{auth.AdminRole}, Elevated: true},
+		{UserID: 2},
+	}, got)
+	assert.True(t, got[0].HasRole(auth.AdminRole))
+
+	// Nor is there a caller for public methods.
+	interceptor = Authorize(nil, newFakeAuthorizer(), nil)
+	require.NoError(t, callWithToken(interceptor, loginMethod, "student", record))
+	assert.Len(t, got, 2)
+}
+
+func TestAuthorize_ElevationExpiresMidOperation(t *testing.T) {
+	authorizer := newFakeAuthorizer()
+	interceptor := Authorize(map[string]Policy{
+		"DeleteApp": {Role: auth.AdminRole, Elevated: true},
+	}, authorizer, nil)
+
+	// The token expires while the first call runs; the call still
+	// completes because it was authorized when it started.
+	slow := func(context.Context, any) (any, error) {
+		authorizer.now = authorizer.now.Add(auth.ElevatedTokenTTL + time.Second)
+		return "ok", nil
+	}
+	require.NoError(t, callWithToken(interceptor, deleteAppMethod, "elevated", slow))
+
+	// The next step of the operation has to elevate again.
+	err := callWithToken(interceptor, deleteAppMethod, "elevated", okHandler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The normal admin token is still valid but not enough.
+	err = callWithToken(interceptor, deleteAppMethod, "admin", okHandler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, ReasonStepUpRequired, errorReason(err))
+}
+
+func errorReason(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,36 @@
+package interceptors
+
+import (
+	"context"
+
+	"sso/internal/lib/clientcert"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientCert returns an interceptor storing the thumbprint of the client
+// certificate in the context for the service layer, see clientcert. Only
+// certificates the TLS handshake verified count: without a client CA the
+// server asks for none.
+func ClientCert() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+		if ok && len(tlsInfo.State.VerifiedChains) > 0 && len(tlsInfo.State.PeerCertificates) > 0 {
+			ctx = clientcert.NewContext(ctx, clientcert.Thumbprint(tlsInfo.State.PeerCertificates[0]))
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,36 @@
+package interceptors
+
+import (
+	"context"
+	"net/netip"
+
+	"sso/internal/lib/clientip"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientIP returns an interceptor storing the client IP in the context for
+// the service layer, see clientip.Extract. Forwarding headers are only
+// honored for peers within trusted.
+func ClientIP(trusted []netip.Prefix) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		if ip := clientip.Extract(p.Addr, md, trusted); ip.IsValid() {
+			ctx = clientip.NewContext(ctx, ip)
+		}
+
+		return handler(ctx, req)
+	}
+}
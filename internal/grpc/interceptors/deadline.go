@@ -0,0 +1,117 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Deadline returns an interceptor bounding every call by the timeout
+// configured for its method, so clients that never set a deadline cannot
+// hold a handler forever.
+//
+// timeouts is keyed by either the full method ("/auth.Auth/Login") or its
+// bare name ("Login"); methods without an entry use def. An earlier client
+// deadline is kept. A non-positive timeout disables the bound for that
+// method.
+//
+// When the deadline fires the call returns DeadlineExceeded right away,
+// even if the handler ignores its context and is still running.
+func Deadline(timeouts map[string]time.Duration, def time.Duration) grpc.UnaryServerInterceptor {
+	return DeadlineFrom(NewTimeouts(timeouts, def))
+}
+
+// Timeouts are the bounds of Deadline. They can be replaced while the
+// server runs; calls already started keep their deadline.
+type Timeouts struct {
+	v atomic.Pointer[timeoutSet]
+}
+
+type timeoutSet struct {
+	methods map[string]time.Duration
+	def     time.Duration
+}
+
+// NewTimeouts returns timeouts keyed like those of Deadline.
+func NewTimeouts(methods map[string]time.Duration, def time.Duration) *Timeouts {
+	t := &Timeouts{}
+	t.Set(methods, def)
+
+	return t
+}
+
+// Set replaces the timeouts. methods is copied.
+func (t *Timeouts) Set(methods map[string]time.Duration, def time.Duration) {
+	t.v.Store(&timeoutSet{methods: maps.Clone(methods), def: def})
+}
+
+// DeadlineFrom is Deadline with timeouts that may change, see Timeouts.
+func DeadlineFrom(timeouts *Timeouts) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		set := timeouts.v.Load()
+		timeout := methodTimeout(set.methods, set.def, info.FullMethod)
+		if timeout <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type result struct {
+			resp any
+			err  error
+		}
+		done := make(chan result, 1)
+
+		go func() {
+			resp, err := handler(ctx, req)
+			done <- result{resp: resp, err: err}
+		}()
+
+		select {
+		case res := <-done:
+			if res.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded")
+			}
+
+			return res.resp, res.err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded")
+			}
+
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func methodTimeout(timeouts map[string]time.Duration, def time.Duration, fullMethod string) time.Duration {
+	if timeout, ok := methodEntry(timeouts, fullMethod); ok {
+		return timeout
+	}
+
+	return def
+}
+
+// methodEntry looks fullMethod up in m, first as is and then by its bare
+// name.
+func methodEntry[V any](m map[string]V, fullMethod string) (V, bool) {
+	if v, ok := m[fullMethod]; ok {
+		return v, true
+	}
+	v, ok := m[path.Base(fullMethod)]
+
+	return v, ok
+}
@@ -0,0 +1,110 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const loginMethod = "/auth.Auth/Login"
+
+func sleepingHandler(d time.Duration) grpc.UnaryHandler {
+	return func(ctx context.Context, req any) (any, error) {
+		time.Sleep(d) // deliberately ignores ctx
+		return "ok", nil
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeouts   map[string]time.Duration
+		def        time.Duration
+		clientTTL  time.Duration
+		handlerDur time.Duration
+		wantCode   codes.Code
+		maxElapsed time.Duration
+	}{
+		{
+			name:       "method timeout fires on slow handler",
+			timeouts:   map[string]time.Duration{"Login": 50 * time.Millisecond},
+			def:        time.Hour,
+			handlerDur: time.Second,
+			wantCode:   codes.DeadlineExceeded,
+			maxElapsed: 500 * time.Millisecond,
+		},
+		{
+			name:       "full method name takes precedence",
+			timeouts:   map[string]time.Duration{"Login": time.Hour, loginMethod: 50 * time.Millisecond},
+			def:        time.Hour,
+			handlerDur: time.Second,
+			wantCode:   codes.DeadlineExceeded,
+			maxElapsed: 500 * time.Millisecond,
+		},
+		{
+			name:       "default timeout applies to unlisted method",
+			timeouts:   map[string]time.Duration{"Register": time.Hour},
+			def:        50 * time.Millisecond,
+			handlerDur: time.Second,
+			wantCode:   codes.DeadlineExceeded,
+			maxElapsed: 500 * time.Millisecond,
+		},
+		{
+			name:       "shorter client deadline is kept",
+			timeouts:   map[string]time.Duration{"Login": time.Hour},
+			clientTTL:  50 * time.Millisecond,
+			handlerDur: time.Second,
+			wantCode:   codes.DeadlineExceeded,
+			maxElapsed: 500 * time.Millisecond,
+		},
+		{
+			name:       "fast handler passes through",
+			timeouts:   map[string]time.Duration{"Login": time.Second},
+			handlerDur: 0,
+			wantCode:   codes.OK,
+			maxElapsed: 500 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.clientTTL > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.clientTTL)
+				defer cancel()
+			}
+
+			interceptor := Deadline(tt.timeouts, tt.def)
+
+			start := time.Now()
+			resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: loginMethod}, sleepingHandler(tt.handlerDur))
+			elapsed := time.Since(start)
+
+			assert.Less(t, elapsed, tt.maxElapsed)
+			require.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantCode == codes.OK {
+				assert.Equal(t, "ok", resp)
+			}
+		})
+	}
+}
+
+func TestDeadlineFrom_Set(t *testing.T) {
+	timeouts := NewTimeouts(map[string]time.Duration{"Login": 20 * time.Millisecond}, time.Second)
+	interceptor := DeadlineFrom(timeouts)
+	info := &grpc.UnaryServerInfo{FullMethod: loginMethod}
+
+	_, err := interceptor(context.Background(), nil, info, sleepingHandler(100*time.Millisecond))
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	// Without an entry for Login, the raised default applies.
+	timeouts.Set(nil, time.Second)
+	_, err = interceptor(context.Background(), nil, info, sleepingHandler(100*time.Millisecond))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,200 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/clock"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+const (
+	// ExplainHeader is the metadata key with which a caller of the role
+	// given to NewDecisionLog asks for the reason of a denial. Any
+	// non-empty value but "0" and "false" turns the explanation on.
+	ExplainHeader = "x-explain-authorization"
+
+	// ReasonPolicyDenied is the ErrorInfo reason of explained denials
+	// other than those that need an elevated token.
+	ReasonPolicyDenied = "POLICY_DENIED"
+)
+
+// The reasons of the decisions of Authorize.
+const (
+	DecisionNoPolicy          = "no_policy"
+	DecisionTokenMissing      = "token_missing"
+	DecisionTokenInvalid      = "token_invalid"
+	DecisionRoleLookupFailed  = "role_lookup_failed"
+	DecisionRoleMismatch      = "role_mismatch"
+	DecisionElevationRequired = "elevation_required"
+	DecisionPolicySatisfied   = "policy_satisfied"
+)
+
+// Decision is an allow or deny of Authorize.
+type Decision struct {
+	Method string
+	Policy Policy
+	// UserID, Role and Elevated describe the caller as far as it was
+	// known when the decision was made: UserID is zero without a valid
+	// token and Role empty if it was not looked up.
+	UserID   int64
+	Role     string
+	Elevated bool
+	Allowed  bool
+	// Reason is the condition that decided, one of the Decision
+	// constants.
+	Reason string
+}
+
+// DecisionLog logs the decisions of Authorize at debug level, at most
+// perSecond a second: the others are counted and reported with the next
+// decision logged. It also explains denials to the callers that ask with
+// ExplainHeader, if they have its explain role.
+type DecisionLog struct {
+	log         *slog.Logger
+	clock       clock.Clock
+	perSecond   int
+	explainRole string
+
+	mu         sync.Mutex
+	window     time.Time
+	logged     int
+	suppressed int
+}
+
+// NewDecisionLog returns a DecisionLog logging to log at most perSecond
+// decisions a second and explaining denials to callers of explainRole,
+// none if empty.
+func NewDecisionLog(log *slog.Logger, clk clock.Clock, perSecond int, explainRole string) *DecisionLog {
+	return &DecisionLog{
+		log:         log,
+		clock:       clk,
+		perSecond:   max(perSecond, 1),
+		explainRole: explainRole,
+	}
+}
+
+// record logs d unless the rate is exceeded. A nil DecisionLog logs
+// nothing.
+func (l *DecisionLog) record(ctx context.Context, d Decision) {
+	if l == nil || !l.log.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	suppressed, ok := l.take()
+	if !ok {
+		return
+	}
+
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	attrs := []slog.Attr{
+		slog.String("method", d.Method),
+		slog.String("required_role", d.Policy.Role),
+		slog.Bool("required_elevation", d.Policy.Elevated),
+		slog.Int64("user_id", d.UserID),
+		slog.String("role", d.Role),
+		slog.Bool("elevated", d.Elevated),
+		slog.String("decision", decision),
+		slog.String("reason", d.Reason),
+	}
+	if suppressed > 0 {
+		attrs = append(attrs, slog.Int("suppressed", suppressed))
+	}
+
+	l.log.LogAttrs(ctx, slog.LevelDebug, "authorization decision", attrs...)
+}
+
+// take reports whether a decision may be logged now, and how many were
+// not since the last one that was.
+func (l *DecisionLog) take() (suppressed int, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.window) >= time.Second {
+		l.window = now
+		l.logged = 0
+	}
+	if l.logged >= l.perSecond {
+		l.suppressed++
+
+		return 0, false
+	}
+
+	l.logged++
+	suppressed, l.suppressed = l.suppressed, 0
+
+	return suppressed, true
+}
+
+// explains reports whether the caller asked for its denials to be
+// explained. Whether it may is up to its role.
+func (l *DecisionLog) explains(ctx context.Context) bool {
+	if l == nil || l.explainRole == "" {
+		return false
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(ExplainHeader) {
+		if v != "" && v != "0" && v != "false" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// explain adds to the denial err of d an ErrorInfo telling the failing
+// condition, or fills in the metadata of the one it has.
+func explain(err error, d Decision) error {
+	st := status.Convert(err)
+	explanation := map[string]string{
+		"method":             d.Method,
+		"condition":          d.Reason,
+		"required_role":      d.Policy.Role,
+		"required_elevation": strconv.FormatBool(d.Policy.Elevated),
+		"caller_role":        d.Role,
+		"caller_elevated":    strconv.FormatBool(d.Elevated),
+	}
+
+	details := make([]protoadapt.MessageV1, 0, len(st.Details())+1)
+	explained := false
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && !explained {
+			if info.Metadata == nil {
+				info.Metadata = make(map[string]string, len(explanation))
+			}
+			maps.Copy(info.Metadata, explanation)
+			explained = true
+		}
+		if m, ok := detail.(protoadapt.MessageV1); ok {
+			details = append(details, m)
+		}
+	}
+	if !explained {
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   ReasonPolicyDenied,
+			Domain:   grpcerr.Domain,
+			Metadata: explanation,
+		})
+	}
+
+	explainedSt, detailErr := status.New(st.Code(), st.Message()).WithDetails(details...)
+	if detailErr != nil {
+		return err
+	}
+
+	return explainedSt.Err()
+}
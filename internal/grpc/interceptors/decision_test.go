@@ -0,0 +1,186 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+	"sso/internal/services/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	gradeMethod    = "/lms.Grades/SetGrade"
+	reauthMethod   = "/sso.Account/ChangeEmail"
+	wipeUserMethod = "/auth.Auth/EraseUser"
+)
+
+func explained(err error) (reason string, md map[string]string) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.GetReason(), info.GetMetadata()
+		}
+	}
+
+	return "", nil
+}
+
+func callExplained(interceptor grpc.UnaryServerInterceptor, method, token string) error {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+token,
+		ExplainHeader, "1",
+	))
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, okHandler)
+
+	return err
+}
+
+func TestAuthorize_Explain(t *testing.T) {
+	decisions := NewDecisionLog(slog.New(slog.NewTextHandler(io.Discard, nil)), clock.Real(), 10, auth.AdminRole)
+	interceptor := Authorize(map[string]Policy{
+		"SetGrade":    {Role: "teacher"},
+		"ChangeEmail": {Elevated: true},
+		"EraseUser":   {Role: auth.AdminRole, Elevated: true},
+	}, newFakeAuthorizer(), decisions)
+
+	tests := []struct {
+		name       string
+		method     string
+		wantCode   codes.Code
+		wantReason string
+		want       map[string]string
+	}{
+		{
+			name:       "role",
+			method:     gradeMethod,
+			wantCode:   codes.PermissionDenied,
+			wantReason: ReasonPolicyDenied,
+			want: map[string]string{
+				"method":             gradeMethod,
+				"condition":          DecisionRoleMismatch,
+				"required_role":      "teacher",
+				"required_elevation": "false",
+				"caller_role":        auth.AdminRole,
+				"caller_elevated":    "false",
+			},
+		},
+		{
+			name:       "elevation",
+			method:     reauthMethod,
+			wantCode:   codes.PermissionDenied,
+			wantReason: ReasonStepUpRequired,
+			want: map[string]string{
+				"method":             reauthMethod,
+				"condition":          DecisionElevationRequired,
+				"required_role":      "",
+				"required_elevation": "true",
+				"caller_role":        auth.AdminRole,
+				"caller_elevated":    "false",
+			},
+		},
+		{
+			name:       "role and elevation",
+			method:     wipeUserMethod,
+			wantCode:   codes.PermissionDenied,
+			wantReason: ReasonStepUpRequired,
+			want: map[string]string{
+				"method":             wipeUserMethod,
+				"condition":          DecisionElevationRequired,
+				"required_role":      auth.AdminRole,
+				"required_elevation": "true",
+				"caller_role":        auth.AdminRole,
+				"caller_elevated":    "false",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callExplained(interceptor, tt.method, "admin")
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			reason, md := explained(err)
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Equal(t, tt.want, md)
+		})
+	}
+}
+
+func TestAuthorize_ExplainOnlyToAdmins(t *testing.T) {
+	decisions := NewDecisionLog(slog.New(slog.NewTextHandler(io.Discard, nil)), clock.Real(), 10, auth.AdminRole)
+	interceptor := Authorize(map[string]Policy{
+		"EraseUser": {Role: auth.AdminRole, Elevated: true},
+	}, newFakeAuthorizer(), decisions)
+
+	// Not an admin: the denial stays as it is.
+	err := callExplained(interceptor, wipeUserMethod, "student")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	reason, _ := explained(err)
+	assert.Empty(t, reason)
+
+	// An admin that did not ask.
+	err = callWithToken(interceptor, wipeUserMethod, "admin", okHandler)
+	reason, md := explained(err)
+	assert.Equal(t, ReasonStepUpRequired, reason)
+	assert.Empty(t, md)
+
+	// Nor without a token to tell who asks.
+	err = callExplained(interceptor, wipeUserMethod, "forged")
+	_, md = explained(err)
+	assert.NotContains(t, md, "condition")
+
+	// Without a decision log there is no explanation.
+	interceptor = Authorize(map[string]Policy{"EraseUser": {Role: auth.AdminRole, Elevated: true}}, newFakeAuthorizer(), nil)
+	_, md = explained(callExplained(interceptor, wipeUserMethod, "admin"))
+	assert.Empty(t, md)
+}
+
+func TestDecisionLog_RateLimited(t *testing.T) {
+	var buf bytes.Buffer
+	clk := clock.NewFake(time.Now())
+	decisions := NewDecisionLog(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), clk, 2, "")
+	interceptor := Authorize(map[string]Policy{
+		"EraseUser": {Role: auth.AdminRole, Elevated: true},
+	}, newFakeAuthorizer(), decisions)
+
+	require.NoError(t, callWithToken(interceptor, wipeUserMethod, "elevated", okHandler))
+	_ = callWithToken(interceptor, wipeUserMethod, "student", okHandler)
+	for range 3 {
+		_ = callWithToken(interceptor, wipeUserMethod, "", okHandler)
+	}
+	clk.Advance(time.Second)
+	require.NoError(t, callWithToken(interceptor, loginMethod, "", okHandler))
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "allow", entries[0]["decision"])
+	assert.Equal(t, DecisionPolicySatisfied, entries[0]["reason"])
+	assert.Equal(t, float64(1), entries[0]["user_id"])
+	assert.Equal(t, true, entries[0]["elevated"])
+
+	assert.Equal(t, "deny", entries[1]["decision"])
+	assert.Equal(t, DecisionRoleMismatch, entries[1]["reason"])
+	assert.Equal(t, "student", entries[1]["role"])
+	assert.Equal(t, auth.AdminRole, entries[1]["required_role"])
+
+	assert.Equal(t, DecisionNoPolicy, entries[2]["reason"])
+	assert.Equal(t, float64(3), entries[2]["suppressed"])
+}
@@ -0,0 +1,37 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Localize returns an interceptor translating the message of the errors
+// returned by grpcerr.Status into the language of the accept-language
+// header, see grpcerr.Catalog.Localize. Without the header, or for a
+// language the catalog lacks, errors are left in English.
+func Localize(catalog *grpcerr.Catalog) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		accept := strings.Join(md.Get(grpcerr.AcceptLanguageHeader), ",")
+		if accept == "" {
+			return resp, err
+		}
+
+		return resp, catalog.Localize(err, accept)
+	}
+}
@@ -0,0 +1,40 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/grpc/grpcerr"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocalize(t *testing.T) {
+	interceptor := Localize(grpcerr.DefaultCatalog())
+	call := func(err error, kv ...string) error {
+		ctx := context.Background()
+		if len(kv) > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+		}
+		_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Login"}, func(context.Context, any) (any, error) {
+			return nil, err
+		})
+
+		return err
+	}
+	locked := grpcerr.Status(errs.ErrLocked)
+
+	assert.Equal(t, "account is locked", status.Convert(call(locked)).Message())
+	assert.Equal(t, "account is locked", status.Convert(call(locked, grpcerr.AcceptLanguageHeader, "en")).Message())
+
+	st := status.Convert(call(locked, grpcerr.AcceptLanguageHeader, "ru-RU"))
+	assert.Equal(t, "учётная запись заблокирована", st.Message())
+	assert.Equal(t, codes.PermissionDenied, st.Code())
+
+	assert.NoError(t, call(nil, grpcerr.AcceptLanguageHeader, "ru"))
+}
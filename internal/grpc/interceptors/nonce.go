@@ -0,0 +1,79 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/nonce"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// NonceHeader carries the one-time nonce of a call, see Nonces.
+	NonceHeader = "x-request-nonce"
+
+	// maxNonceLen bounds what a single nonce can cost the store.
+	maxNonceLen = 128
+)
+
+type NonceStore interface {
+	// Use records nonce, sent by client, for ttl. fresh is false if nonce
+	// was already used within its ttl.
+	Use(ctx context.Context, client, nonce string, ttl time.Duration) (fresh bool, err error)
+}
+
+// Nonces returns an interceptor requiring an x-request-nonce header not
+// seen in the last ttl for the methods in methods, keyed like Deadline
+// timeouts. Other methods are left alone.
+//
+// A missing or oversized nonce is InvalidArgument and a nonce used before
+// Aborted. The nonces are counted by the client IP, see clientip, so a
+// client holding as many as the store lets it is ResourceExhausted while
+// the others go on. A store that cannot take the nonce otherwise fails
+// the call with Unavailable, so a request is never let through unchecked.
+func Nonces(methods map[string]bool, store NonceStore, ttl time.Duration) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if required, _ := methodEntry(methods, info.FullMethod); !required {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		nonces := md.Get(NonceHeader)
+		if len(nonces) != 1 || nonces[0] == "" {
+			return nil, status.Error(codes.InvalidArgument, "request nonce is required")
+		}
+		if len(nonces[0]) > maxNonceLen {
+			return nil, status.Error(codes.InvalidArgument, "request nonce is too long")
+		}
+
+		// Calls without a client IP, as over a unix socket, share a count.
+		var client string
+		if ip, ok := clientip.FromContext(ctx); ok {
+			client = ip.String()
+		}
+		fresh, err := store.Use(ctx, client, nonces[0], ttl)
+		if err != nil {
+			if errors.Is(err, nonce.ErrClientFull) {
+				return nil, status.Error(codes.ResourceExhausted, "too many request nonces in use")
+			}
+
+			return nil, status.Error(codes.Unavailable, "failed to check request nonce")
+		}
+		if !fresh {
+			return nil, status.Error(codes.Aborted, "request nonce was already used")
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,95 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/nonce"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type failingNonces struct{}
+
+func (failingNonces) Use(context.Context, string, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func callWithNonce(interceptor grpc.UnaryServerInterceptor, method string, kv ...string) error {
+	return callFrom(context.Background(), interceptor, method, kv...)
+}
+
+func callFrom(ctx context.Context, interceptor grpc.UnaryServerInterceptor, method string, kv ...string) error {
+	if len(kv) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(kv...))
+	}
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(context.Context, any) (any, error) {
+		return "ok", nil
+	})
+
+	return err
+}
+
+func TestNonces(t *testing.T) {
+	store := nonce.NewMemory(10, 10, clock.NewFake(time.Now()))
+	interceptor := Nonces(map[string]bool{"Login": true}, store, time.Minute)
+
+	assert.NoError(t, callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, "n1"))
+	err := callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, "n1")
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.NoError(t, callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, "n2"))
+
+	err = callWithNonce(interceptor, "/auth.Auth/Login")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, "a", NonceHeader, "b")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, strings.Repeat("n", maxNonceLen+1))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Other methods need no nonce and do not spend one.
+	assert.NoError(t, callWithNonce(interceptor, registerMethod))
+	assert.NoError(t, callWithNonce(interceptor, registerMethod, NonceHeader, "n1"))
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestNonces_Flood(t *testing.T) {
+	store := nonce.NewMemory(100, 5, clock.NewFake(time.Now()))
+	interceptor := Nonces(map[string]bool{"Register": true}, store, time.Minute)
+	attacker := clientip.NewContext(context.Background(), netip.MustParseAddr("203.0.113.7"))
+	user := clientip.NewContext(context.Background(), netip.MustParseAddr("198.51.100.1"))
+
+	// Unauthenticated calls with fresh nonces fill only the share of
+	// their client.
+	var exhausted int
+	for i := range 50 {
+		err := callFrom(attacker, interceptor, registerMethod, NonceHeader, fmt.Sprintf("flood-%d", i))
+		if status.Code(err) == codes.ResourceExhausted {
+			exhausted++
+		}
+	}
+	assert.Equal(t, 45, exhausted)
+	assert.Equal(t, 5, store.Len())
+
+	assert.NoError(t, callFrom(user, interceptor, registerMethod, NonceHeader, "n1"))
+	err := callFrom(user, interceptor, registerMethod, NonceHeader, "flood-0")
+	assert.Equal(t, codes.Aborted, status.Code(err), "replays are caught across clients")
+}
+
+func TestNonces_StoreFailure(t *testing.T) {
+	interceptor := Nonces(map[string]bool{"Login": true}, failingNonces{}, time.Minute)
+
+	err := callWithNonce(interceptor, "/auth.Auth/Login", NonceHeader, "n1")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,57 @@
+package interceptors
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"sso/internal/lib/redact"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// PayloadLogger returns an interceptor logging every request and response
+// message as JSON at debug level, with sensitive fields masked by
+// redact.Message. It is meant for local mode only.
+func PayloadLogger(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		log := log.With(slog.String("method", info.FullMethod))
+
+		log.DebugContext(ctx, "grpc request", slog.Any("request", payload(req)))
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			log.DebugContext(ctx, "grpc error", slog.String("code", status.Code(err).String()))
+
+			return resp, err
+		}
+
+		log.DebugContext(ctx, "grpc response", slog.Any("response", payload(resp)))
+
+		return resp, nil
+	}
+}
+
+// payload returns the redacted JSON of a message. Anything that is not a
+// protobuf message is left out rather than risk logging it unredacted.
+func payload(v any) json.RawMessage {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return json.RawMessage(`"<not a protobuf message>"`)
+	}
+
+	b, err := protojson.Marshal(redact.Message(m))
+	if err != nil {
+		return json.RawMessage(`"<invalid message>"`)
+	}
+
+	return b
+}
@@ -0,0 +1,71 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPayloadLogger(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	interceptor := PayloadLogger(log)
+
+	req := &ssov1.LoginRequest{Email: "user@example.com", Password: "hunter2", AppId: 1}
+	resp, err := interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: loginMethod},
+		func(ctx context.Context, req any) (any, error) {
+			assert.Equal(t, "hunter2", req.(*ssov1.LoginRequest).GetPassword(), "the handler sees the real request")
+			return &ssov1.LoginResponse{Token: "eyJhbGciOi.secret.sig"}, nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, "eyJhbGciOi.secret.sig", resp.(*ssov1.LoginResponse).GetToken(), "the client gets the real response")
+
+	out := logs.String()
+	assert.NotContains(t, out, "hunter2")
+	assert.NotContains(t, out, "eyJhbGciOi")
+
+	dec := json.NewDecoder(&logs)
+	var entries []map[string]any
+	for dec.More() {
+		var entry map[string]any
+		require.NoError(t, dec.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, loginMethod, entries[0]["method"])
+	assert.Equal(t, map[string]any{"email": "user@example.com", "password": "***", "appId": float64(1)}, entries[0]["request"])
+	assert.Equal(t, map[string]any{"token": "***"}, entries[1]["response"])
+}
+
+func TestPayloadLogger_Error(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := PayloadLogger(log)(context.Background(), &ssov1.LoginRequest{Password: "hunter2"},
+		&grpc.UnaryServerInfo{FullMethod: loginMethod},
+		func(context.Context, any) (any, error) {
+			return nil, status.Error(codes.InvalidArgument, "invalid email or password")
+		})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, logs.String(), "code=InvalidArgument")
+	assert.NotContains(t, logs.String(), "hunter2")
+}
+
+func TestPayloadLogger_NotAMessage(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	_, err := PayloadLogger(log)(context.Background(), "password=hunter2", &grpc.UnaryServerInfo{FullMethod: loginMethod},
+		func(context.Context, any) (any, error) { return "ok", nil })
+	require.NoError(t, err)
+	assert.NotContains(t, logs.String(), "hunter2")
+}
@@ -0,0 +1,28 @@
+package interceptors
+
+import (
+	"context"
+
+	"sso/internal/lib/reqcache"
+
+	"google.golang.org/grpc"
+)
+
+// RequestCache returns an interceptor giving the calls of methods, by
+// full or bare name, a cache of the storage reads they repeat, see
+// reqcache. Other methods read through: list only those that do not
+// change the users, roles or apps they read.
+func RequestCache(methods map[string]bool) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if cached, _ := methodEntry(methods, info.FullMethod); !cached {
+			return handler(ctx, req)
+		}
+
+		return handler(reqcache.NewContext(ctx), req)
+	}
+}
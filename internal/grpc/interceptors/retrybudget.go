@@ -0,0 +1,22 @@
+package interceptors
+
+import (
+	"context"
+
+	"sso/internal/lib/retrybudget"
+
+	"google.golang.org/grpc"
+)
+
+// RetryBudget returns an interceptor allowing every call n retries of
+// failed dependency calls in all, see retrybudget.
+func RetryBudget(n int) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		_ *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		return handler(retrybudget.NewContext(ctx, n), req)
+	}
+}
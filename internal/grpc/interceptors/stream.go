@@ -0,0 +1,40 @@
+package interceptors
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Stream adapts unary to streams: the stream handler runs as the handler
+// of unary, on a stream whose context is the one unary passed on. The
+// request is read by the stream handler only after unary has run, so
+// unary sees a nil request and a nil response; it must not need them.
+func Stream(unary grpc.UnaryServerInterceptor) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		unaryInfo := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: info.FullMethod,
+		}
+		_, err := unary(ss.Context(), nil, unaryInfo, func(ctx context.Context, _ any) (any, error) {
+			return nil, handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		})
+
+		return err
+	}
+}
+
+// contextStream is a stream with the context of an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,55 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/lib/authctx"
+	"sso/internal/services/auth"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStream is a stream with a context only.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStream(t *testing.T) {
+	interceptor := Stream(Authorize(map[string]Policy{
+		"DeleteApp": {Role: auth.AdminRole},
+	}, newFakeAuthorizer(), nil))
+	call := func(token string) (authctx.Caller, bool, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		var caller authctx.Caller
+		var served bool
+		err := interceptor(nil, fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: deleteAppMethod},
+			func(_ any, ss grpc.ServerStream) error {
+				caller, _ = authctx.CallerFromContext(ss.Context())
+				served = true
+
+				return nil
+			})
+
+		return caller, served, err
+	}
+
+	_, served, err := call("student")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.False(t, served, "the stream is not served")
+
+	// The handler sees the context of the interceptor.
+	caller, served, err := call("admin")
+	assert.NoError(t, err)
+	assert.True(t, served)
+	assert.Equal(t, int64(1), caller.UserID)
+}
@@ -0,0 +1,143 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/metrics"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// UnknownFieldsMode is what UnknownFields does with requests carrying
+// fields the server does not know, as those of a newer client.
+type UnknownFieldsMode string
+
+const (
+	// UnknownFieldsOff ignores unknown fields, as protobuf does.
+	UnknownFieldsOff UnknownFieldsMode = "off"
+	// UnknownFieldsWarn logs the requests with unknown fields and counts
+	// them, but serves them.
+	UnknownFieldsWarn UnknownFieldsMode = "warn"
+	// UnknownFieldsReject fails the requests with unknown fields with
+	// InvalidArgument and ReasonUnknownFields.
+	UnknownFieldsReject UnknownFieldsMode = "reject"
+
+	// ReasonUnknownFields is the ErrorInfo reason of rejected requests.
+	// Its "fields" metadata lists the unknown fields, see UnknownFields.
+	ReasonUnknownFields = "UNKNOWN_FIELDS"
+)
+
+// UnknownFields returns an interceptor looking for unknown fields in the
+// request messages, nested ones included, and warning of or rejecting
+// the requests that have them according to mode. seen counts those
+// requests in either mode. Unknown fields are listed by number, after
+// the names of the known fields they are nested in: "7" or
+// "profile.7".
+func UnknownFields(log *slog.Logger, mode UnknownFieldsMode, seen *metrics.Counter) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		m, ok := req.(proto.Message)
+		if mode == UnknownFieldsOff || !ok {
+			return handler(ctx, req)
+		}
+
+		fields := unknownFields(m.ProtoReflect(), "")
+		if len(fields) == 0 {
+			return handler(ctx, req)
+		}
+		// Fields are ranged over in no particular order.
+		slices.Sort(fields)
+		fields = slices.Compact(fields)
+		seen.Inc()
+
+		if mode == UnknownFieldsReject {
+			return nil, unknownFieldsError(fields)
+		}
+
+		log.WarnContext(ctx, "request has unknown fields",
+			slog.String("method", info.FullMethod),
+			slog.Any("fields", fields),
+		)
+
+		return handler(ctx, req)
+	}
+}
+
+// unknownFields returns the unknown fields of m and of the messages it
+// holds, each prefixed by the path of known fields leading to it. A field
+// repeated on the wire is listed as many times.
+func unknownFields(m protoreflect.Message, path string) []string {
+	var fields []string
+	for b := m.GetUnknown(); len(b) > 0; {
+		num, _, n := protowire.ConsumeField(b)
+		if n < 0 {
+			// Malformed bytes cannot be told apart; report them once.
+			fields = append(fields, path+"?")
+
+			break
+		}
+		b = b[n:]
+
+		fields = append(fields, path+strconv.Itoa(int(num)))
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := path + string(fd.Name())
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() == nil {
+				return true
+			}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				fields = append(fields, unknownFields(v.Message(), fmt.Sprintf("%s[%s].", name, k))...)
+
+				return true
+			})
+		case fd.IsList():
+			if fd.Message() == nil {
+				return true
+			}
+			list := v.List()
+			for i := range list.Len() {
+				fields = append(fields, unknownFields(list.Get(i).Message(), fmt.Sprintf("%s[%d].", name, i))...)
+			}
+		case fd.Message() != nil:
+			fields = append(fields, unknownFields(v.Message(), name+".")...)
+		}
+
+		return true
+	})
+
+	return fields
+}
+
+func unknownFieldsError(fields []string) error {
+	list := strings.Join(fields, ",")
+	msg := "request has fields unknown to this server: " + list
+	st, err := status.New(codes.InvalidArgument, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   ReasonUnknownFields,
+		Domain:   grpcerr.Domain,
+		Metadata: map[string]string{"fields": list},
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, msg)
+	}
+
+	return st.Err()
+}
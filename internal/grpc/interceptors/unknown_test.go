@@ -0,0 +1,170 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"sso/internal/lib/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// requestDescriptor builds a RegisterRequest of pkg with a nested Profile,
+// each with the extra fields given: extra[0] on the request, extra[1] on
+// the profile. It stands for the same message at two versions of the API.
+func requestDescriptor(t *testing.T, pkg string, extra ...[]*descriptorpb.FieldDescriptorProto) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     typ.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	profile := field("profile", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	profile.TypeName = proto.String("." + pkg + ".Profile")
+	contacts := field("contacts", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	contacts.TypeName = proto.String("." + pkg + ".Profile")
+	contacts.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	req := &descriptorpb.DescriptorProto{
+		Name:  proto.String("RegisterRequest"),
+		Field: []*descriptorpb.FieldDescriptorProto{field("email", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING), profile, contacts},
+	}
+	prof := &descriptorpb.DescriptorProto{
+		Name:  proto.String("Profile"),
+		Field: []*descriptorpb.FieldDescriptorProto{field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING)},
+	}
+	if len(extra) > 0 {
+		req.Field = append(req.Field, extra[0]...)
+	}
+	if len(extra) > 1 {
+		prof.Field = append(prof.Field, extra[1]...)
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String(pkg + "/register.proto"),
+		Package:     proto.String(pkg),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{req, prof},
+	}, nil)
+	require.NoError(t, err)
+
+	return fd.Messages().ByName("RegisterRequest")
+}
+
+// newerRequest returns a request as a newer client sends it, decoded by
+// a server that knows the older RegisterRequest only.
+func newerRequest(t *testing.T) proto.Message {
+	t.Helper()
+
+	str := func(name string, num int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	newer := requestDescriptor(t, "v2",
+		[]*descriptorpb.FieldDescriptorProto{str("username", 99)},
+		[]*descriptorpb.FieldDescriptorProto{str("nickname", 7)},
+	)
+
+	profileFields := newer.Fields().ByName("profile").Message().Fields()
+	req := dynamicpb.NewMessage(newer)
+	req.Set(newer.Fields().ByName("email"), protoreflect.ValueOfString("user@example.com"))
+	req.Set(newer.Fields().ByName("username"), protoreflect.ValueOfString("jane"))
+	profile := req.Mutable(newer.Fields().ByName("profile")).Message()
+	profile.Set(profileFields.ByName("nickname"), protoreflect.ValueOfString("jd"))
+	contacts := req.Mutable(newer.Fields().ByName("contacts")).List()
+	contact := contacts.NewElement()
+	contact.Message().Set(profileFields.ByName("name"), protoreflect.ValueOfString("John"))
+	contacts.Append(contact)
+	contact = contacts.NewElement()
+	contact.Message().Set(profileFields.ByName("nickname"), protoreflect.ValueOfString("jo"))
+	contacts.Append(contact)
+
+	b, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	older := dynamicpb.NewMessage(requestDescriptor(t, "v1"))
+	require.NoError(t, proto.Unmarshal(b, older))
+
+	return older
+}
+
+func TestUnknownFields(t *testing.T) {
+	req := newerRequest(t)
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Register"}
+
+	t.Run("warn", func(t *testing.T) {
+		var logs bytes.Buffer
+		seen := metrics.NewCounter("unknown")
+		interceptor := UnknownFields(slog.New(slog.NewTextHandler(&logs, nil)), UnknownFieldsWarn, seen)
+
+		_, err := interceptor(context.Background(), req, info, okHandler)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), seen.Value())
+		assert.Contains(t, logs.String(), "request has unknown fields")
+		assert.Contains(t, logs.String(), "method=/auth.Auth/Register")
+		assert.Contains(t, logs.String(), "fields=\"[99 contacts[1].7 profile.7]\"")
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		seen := metrics.NewCounter("unknown")
+		interceptor := UnknownFields(slog.New(slog.NewTextHandler(io.Discard, nil)), UnknownFieldsReject, seen)
+
+		_, err := interceptor(context.Background(), req, info, func(context.Context, any) (any, error) {
+			t.Fatal("the handler must not be called")
+			return nil, nil
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "99,contacts[1].7,profile.7")
+		reason, md := explained(err)
+		assert.Equal(t, ReasonUnknownFields, reason)
+		assert.Equal(t, map[string]string{"fields": "99,contacts[1].7,profile.7"}, md)
+		assert.Equal(t, int64(1), seen.Value())
+	})
+
+	t.Run("off", func(t *testing.T) {
+		seen := metrics.NewCounter("unknown")
+		interceptor := UnknownFields(slog.New(slog.NewTextHandler(io.Discard, nil)), UnknownFieldsOff, seen)
+
+		_, err := interceptor(context.Background(), req, info, okHandler)
+		require.NoError(t, err)
+		assert.Zero(t, seen.Value())
+	})
+}
+
+func TestUnknownFields_Known(t *testing.T) {
+	older := requestDescriptor(t, "v1")
+	req := dynamicpb.NewMessage(older)
+	req.Set(older.Fields().ByName("email"), protoreflect.ValueOfString("user@example.com"))
+	req.Mutable(older.Fields().ByName("profile"))
+
+	seen := metrics.NewCounter("unknown")
+	interceptor := UnknownFields(slog.New(slog.NewTextHandler(io.Discard, nil)), UnknownFieldsReject, seen)
+
+	_, err := interceptor(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Register"}, okHandler)
+	require.NoError(t, err)
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/Register"}, okHandler)
+	require.NoError(t, err, "non-proto requests pass")
+	assert.Zero(t, seen.Value())
+}
@@ -0,0 +1,46 @@
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/lib/audit"
+
+	"google.golang.org/grpc"
+)
+
+// VerboseErrors returns an interceptor making the errors of the calls
+// verbose, see grpcerr.Verbose, with the request ID of AuditContext, which
+// it must run after. Internal errors are also logged with their cause and
+// the request ID, so the line of a failure a client reports is found by
+// the ID. It is meant for staging: even sanitized, the op labels tell
+// clients how the server is built.
+func VerboseErrors(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		e := audit.FromContext(ctx)
+		if cause := grpcerr.Cause(err); cause != nil {
+			log.ErrorContext(ctx, "call failed",
+				slog.Any("request", e),
+				slog.Any("error", cause),
+			)
+		}
+
+		requestID := e.RequestID
+		if requestID == audit.Unknown {
+			requestID = ""
+		}
+
+		return resp, grpcerr.Verbose(err, requestID)
+	}
+}
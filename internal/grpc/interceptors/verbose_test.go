@@ -0,0 +1,63 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/grpc/grpcerr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerboseErrors(t *testing.T) {
+	var buf bytes.Buffer
+	verbose := VerboseErrors(slog.New(slog.NewTextHandler(&buf, nil)))
+	cause := errs.Wrap("services.auth.Login", errs.Wrap("storage.sqlite.User", errors.New("database is locked")))
+	failing := func(context.Context, any) (any, error) {
+		return nil, grpcerr.Status(cause)
+	}
+
+	// AuditContext runs first, as on the server.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "req-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: loginMethod}
+	_, err := AuditContext()(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return verbose(ctx, req, info, failing)
+	})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "internal error: services.auth.Login: storage.sqlite.User", st.Message())
+	require.Len(t, st.Details(), 1)
+	assert.Equal(t, "req-1", st.Details()[0].(*errdetails.RequestInfo).GetRequestId())
+	assert.Contains(t, buf.String(), "req-1")
+	assert.Contains(t, buf.String(), "database is locked")
+
+	// Terse, without the interceptor.
+	_, err = AuditContext()(ctx, nil, info, failing)
+	st = status.Convert(err)
+	assert.Equal(t, "internal error", st.Message())
+	assert.Empty(t, st.Details())
+
+	// Without an envelope there is no ID to add.
+	buf.Reset()
+	_, err = verbose(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, grpcerr.Status(errs.ErrLocked)
+	})
+	st = status.Convert(err)
+	assert.Equal(t, "account is locked", st.Message())
+	require.Len(t, st.Details(), 1)
+	assert.Empty(t, buf.String(), "only internal errors are logged")
+
+	_, err = verbose(ctx, nil, info, okHandler)
+	assert.NoError(t, err)
+}
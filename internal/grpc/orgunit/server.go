@@ -0,0 +1,300 @@
+// Package orgunit implements sso.orgunit.v1.OrgUnits, which manages the
+// tree of org units, such as faculties and departments, and tells the
+// roles users hold in them.
+//
+// The service is not part of course-work-protos yet, so, like the
+// Permissions service, its descriptor is built here from well-known types.
+// Requests and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <elevated admin token>' \
+//		-d '{"parent_id": 1, "name": "Optics"}' \
+//		localhost:44044 sso.orgunit.v1.OrgUnits/CreateOrgUnit
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"user_id": 7, "unit_id": 3}' \
+//		localhost:44044 sso.orgunit.v1.OrgUnits/GetUserRolesInUnit
+package orgunit
+
+import (
+	"context"
+	"math"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.orgunit.v1.OrgUnits"
+	fileName    = "sso/orgunit.proto"
+
+	// maxID is the largest ID a Struct number carries exactly.
+	maxID = 1 << 53
+
+	// The full names of the methods of the service. Each must have a
+	// policy requiring the admin role, see interceptors.Authorize.
+	CreateOrgUnitMethod      = "/" + serviceName + "/CreateOrgUnit"
+	MoveOrgUnitMethod        = "/" + serviceName + "/MoveOrgUnit"
+	ListOrgUnitsMethod       = "/" + serviceName + "/ListOrgUnits"
+	GetUserRolesInUnitMethod = "/" + serviceName + "/GetUserRolesInUnit"
+)
+
+type Manager interface {
+	CreateOrgUnit(ctx context.Context, parentID int64, name string) (models.OrgUnit, error)
+	MoveOrgUnit(ctx context.Context, id, parentID int64) error
+	ListOrgUnits(ctx context.Context) ([]models.OrgUnit, error)
+	UserRolesInUnit(ctx context.Context, userID, unitID int64) ([]string, error)
+}
+
+// Server is the handler interface of the OrgUnits service.
+type Server interface {
+	CreateOrgUnit(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	MoveOrgUnit(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	ListOrgUnits(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetUserRolesInUnit(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	manager Manager
+}
+
+func Register(gRPC *grpc.Server, manager Manager) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{manager: manager})
+}
+
+// CreateOrgUnit creates a unit named name under parent_id, a root unit if
+// it is absent or zero.
+func (s *serverAPI) CreateOrgUnit(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	parentID, err := idField(req, "parent_id", true)
+	if err != nil {
+		return nil, err
+	}
+
+	unit, err := s.manager.CreateOrgUnit(ctx, parentID, req.GetFields()["name"].GetStringValue())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(unitFields(unit))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode org unit")
+	}
+
+	return resp, nil
+}
+
+// MoveOrgUnit moves unit id under parent_id, to the root if it is absent
+// or zero, with everything below it.
+func (s *serverAPI) MoveOrgUnit(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	id, err := idField(req, "id", false)
+	if err != nil {
+		return nil, err
+	}
+	parentID, err := idField(req, "parent_id", true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.manager.MoveOrgUnit(ctx, id, parentID); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ListOrgUnits returns the tree of units as "units", the roots with the
+// units below them nested in "children".
+func (s *serverAPI) ListOrgUnits(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	units, err := s.manager.ListOrgUnits(ctx)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"units": unitList(units)})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode org units")
+	}
+
+	return resp, nil
+}
+
+// GetUserRolesInUnit lists the roles user_id holds in unit_id, inherited
+// from the units above it and global ones included.
+func (s *serverAPI) GetUserRolesInUnit(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	userID, err := idField(req, "user_id", false)
+	if err != nil {
+		return nil, err
+	}
+	unitID, err := idField(req, "unit_id", false)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := s.manager.UserRolesInUnit(ctx, userID, unitID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	list := make([]any, len(roles))
+	for i, r := range roles {
+		list[i] = r
+	}
+	resp, err := structpb.NewStruct(map[string]any{"roles": list})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode roles")
+	}
+
+	return resp, nil
+}
+
+// idField returns the positive integer field name. If optional, the field
+// may be absent or zero, which reads as zero.
+func idField(req *structpb.Struct, name string, optional bool) (int64, error) {
+	v, ok := req.GetFields()[name]
+	if !ok && optional {
+		return 0, nil
+	}
+
+	n, ok := v.GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > maxID {
+		return 0, status.Errorf(codes.InvalidArgument, "%s must be a positive integer", name)
+	}
+	if n.NumberValue == 0 && !optional {
+		return 0, status.Errorf(codes.InvalidArgument, "%s must be a positive integer", name)
+	}
+
+	return int64(n.NumberValue), nil
+}
+
+func unitList(units []models.OrgUnit) []any {
+	list := make([]any, len(units))
+	for i, unit := range units {
+		list[i] = unitFields(unit)
+	}
+
+	return list
+}
+
+func unitFields(unit models.OrgUnit) map[string]any {
+	fields := map[string]any{
+		"id":       unit.ID,
+		"name":     unit.Name,
+		"children": unitList(unit.Children),
+	}
+	if unit.ParentID != 0 {
+		fields["parent_id"] = unit.ParentID
+	}
+
+	return fields
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateOrgUnit",
+			Handler: handler(CreateOrgUnitMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.CreateOrgUnit(ctx, req)
+			}),
+		},
+		{
+			MethodName: "MoveOrgUnit",
+			Handler: handler(MoveOrgUnitMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.MoveOrgUnit(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListOrgUnits",
+			Handler: handler(ListOrgUnitsMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ListOrgUnits(ctx, req)
+			}),
+		},
+		{
+			MethodName: "GetUserRolesInUnit",
+			Handler: handler(GetUserRolesInUnitMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.GetUserRolesInUnit(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the response.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(output),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.orgunit.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("OrgUnits"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("CreateOrgUnit", ".google.protobuf.Struct"),
+				method("MoveOrgUnit", ".google.protobuf.Empty"),
+				method("ListOrgUnits", ".google.protobuf.Struct"),
+				method("GetUserRolesInUnit", ".google.protobuf.Struct"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
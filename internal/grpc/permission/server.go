@@ -0,0 +1,260 @@
+// Package permission implements sso.permission.v1.Permissions, which
+// manages the permissions roles grant in an app and tells callers theirs.
+//
+// The service is not part of course-work-protos yet, so, like the
+// Identities service, its descriptor is built here from well-known types.
+// Requests and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <elevated admin token>' \
+//		-d '{"role": "teacher", "app_id": 1, "permission": "can_grade"}' \
+//		localhost:44044 sso.permission.v1.Permissions/AttachPermission
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' localhost:44044 sso.permission.v1.Permissions/GetPermissions
+package permission
+
+import (
+	"context"
+	"math"
+
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.permission.v1.Permissions"
+	fileName    = "sso/permission.proto"
+
+	// The full names of the methods that change or list the permissions
+	// of roles. Each must have a policy requiring the admin role, see
+	// interceptors.Authorize.
+	AttachPermissionMethod    = "/" + serviceName + "/AttachPermission"
+	DetachPermissionMethod    = "/" + serviceName + "/DetachPermission"
+	ListRolePermissionsMethod = "/" + serviceName + "/ListRolePermissions"
+	// GetPermissionsMethod is the full name of GetPermissions. It must
+	// have a policy requiring a token.
+	GetPermissionsMethod = "/" + serviceName + "/GetPermissions"
+)
+
+type Manager interface {
+	AttachPermission(ctx context.Context, role string, appID int32, permission string) error
+	DetachPermission(ctx context.Context, role string, appID int32, permission string) error
+	RolePermissions(ctx context.Context, role string, appID int32) ([]string, error)
+	UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error)
+}
+
+// Server is the handler interface of the Permissions service.
+type Server interface {
+	AttachPermission(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	DetachPermission(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+	ListRolePermissions(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetPermissions(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	manager Manager
+}
+
+func Register(gRPC *grpc.Server, manager Manager) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{manager: manager})
+}
+
+// AttachPermission grants permission of app_id to every user of role.
+func (s *serverAPI) AttachPermission(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.manager.AttachPermission(ctx, stringField(req, "role"), appID, stringField(req, "permission"))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// DetachPermission takes permission of app_id back from role. Tokens
+// already issued carry it until they expire.
+func (s *serverAPI) DetachPermission(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.manager.DetachPermission(ctx, stringField(req, "role"), appID, stringField(req, "permission"))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ListRolePermissions lists the permissions of app_id attached to role.
+func (s *serverAPI) ListRolePermissions(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.manager.RolePermissions(ctx, stringField(req, "role"), appID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return permissionsResponse(permissions)
+}
+
+// GetPermissions lists the permissions of the caller in the app of its
+// token, for tokens whose perms claim was omitted for size.
+func (s *serverAPI) GetPermissions(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	claims, ok := interceptors.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	permissions, err := s.manager.UserPermissions(ctx, claims.UserID, claims.AppID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return permissionsResponse(permissions)
+}
+
+func stringField(req *structpb.Struct, name string) string {
+	return req.GetFields()[name].GetStringValue()
+}
+
+func appIDField(req *structpb.Struct) (int32, error) {
+	n, ok := req.GetFields()["app_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > math.MaxInt32 {
+		return 0, status.Error(codes.InvalidArgument, "app_id must be a positive integer")
+	}
+
+	return int32(n.NumberValue), nil
+}
+
+func permissionsResponse(permissions []string) (*structpb.Struct, error) {
+	list := make([]any, len(permissions))
+	for i, p := range permissions {
+		list[i] = p
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{"permissions": list})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode permissions")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AttachPermission",
+			Handler: handler(AttachPermissionMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.AttachPermission(ctx, req)
+			}),
+		},
+		{
+			MethodName: "DetachPermission",
+			Handler: handler(DetachPermissionMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.DetachPermission(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListRolePermissions",
+			Handler: handler(ListRolePermissionsMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ListRolePermissions(ctx, req)
+			}),
+		},
+		{
+			MethodName: "GetPermissions",
+			Handler: handler(GetPermissionsMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.GetPermissions(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the response.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(output),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.permission.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Permissions"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("AttachPermission", ".google.protobuf.Empty"),
+				method("DetachPermission", ".google.protobuf.Empty"),
+				method("ListRolePermissions", ".google.protobuf.Struct"),
+				method("GetPermissions", ".google.protobuf.Struct"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,303 @@
+// Package quota implements sso.quota.v1.Quotas, with which admins cap the
+// users that can register through an app and see how much each app is
+// used.
+//
+// The service is not part of course-work-protos yet, so, like the
+// Activations service, its descriptor is built here from well-known
+// types. Requests and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"app_id": 2, "max_users": 200}' \
+//		localhost:44044 sso.quota.v1.Quotas/SetAppQuota
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"app_id": 2}' localhost:44044 sso.quota.v1.Quotas/GetAppQuota
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"app_id": 2, "from": "2024-03-01", "to": "2024-03-31"}' \
+//		localhost:44044 sso.quota.v1.Quotas/GetAppUsage
+package quota
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.quota.v1.Quotas"
+	fileName    = "sso/quota.proto"
+
+	// The full names of the methods. Each must have a policy requiring
+	// the admin role, see interceptors.Authorize.
+	SetAppQuotaMethod = "/" + serviceName + "/SetAppQuota"
+	GetAppQuotaMethod = "/" + serviceName + "/GetAppQuota"
+	GetAppUsageMethod = "/" + serviceName + "/GetAppUsage"
+
+	// dateLayout is the layout of the days of GetAppUsage, in UTC.
+	dateLayout = time.DateOnly
+)
+
+type Quotas interface {
+	SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error
+	AppQuota(ctx context.Context, appID int32) (models.AppQuota, error)
+}
+
+type UsageReader interface {
+	AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error)
+}
+
+// Server is the handler interface of the Quotas service.
+type Server interface {
+	SetAppQuota(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetAppQuota(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	GetAppUsage(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	quotas Quotas
+	usage  UsageReader
+}
+
+// Register registers the service. A nil usage leaves GetAppUsage
+// unimplemented.
+func Register(gRPC *grpc.Server, quotas Quotas, usage UsageReader) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{quotas: quotas, usage: usage})
+}
+
+// SetAppQuota sets the max_users of app_id, zero or absent to clear it,
+// and returns the quota in effect.
+func (s *serverAPI) SetAppQuota(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	maxUsers, err := maxUsersField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.quotas.SetAppMaxUsers(ctx, appID, maxUsers); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return s.GetAppQuota(ctx, req)
+}
+
+// GetAppQuota returns the max_users of app_id, zero for none, and the
+// users registered through it.
+func (s *serverAPI) GetAppQuota(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	quota, err := s.quotas.AppQuota(ctx, appID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"app_id":    quota.AppID,
+		"max_users": quota.MaxUsers,
+		"users":     quota.Users,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+// GetAppUsage returns the logins, tokens issued and refreshes of app_id
+// on every day from from up to to, both YYYY-MM-DD in UTC and included.
+// to defaults to today. Usage is written in batches, so that of the last
+// seconds may be missing.
+func (s *serverAPI) GetAppUsage(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.usage == nil {
+		return nil, status.Error(codes.Unimplemented, "app usage is not enabled")
+	}
+
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	from, err := dateField(req, "from")
+	if err != nil {
+		return nil, err
+	}
+	if from.IsZero() {
+		return nil, status.Error(codes.InvalidArgument, "from is required")
+	}
+	to, err := dateField(req, "to")
+	if err != nil {
+		return nil, err
+	}
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+
+	usage, err := s.usage.AppUsage(ctx, appID, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	days := make([]any, len(usage))
+	for i, u := range usage {
+		days[i] = map[string]any{
+			"date":          u.Day.UTC().Format(dateLayout),
+			"logins":        u.Logins,
+			"tokens_issued": u.TokensIssued,
+			"refreshes":     u.Refreshes,
+		}
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"app_id": appID,
+		"days":   days,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+func appIDField(req *structpb.Struct) (int32, error) {
+	n, ok := req.GetFields()["app_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > math.MaxInt32 {
+		return 0, status.Error(codes.InvalidArgument, "app_id must be a positive integer")
+	}
+
+	return int32(n.NumberValue), nil
+}
+
+func maxUsersField(req *structpb.Struct) (int, error) {
+	v, ok := req.GetFields()["max_users"]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 0 || n.NumberValue > math.MaxInt32 {
+		return 0, status.Error(codes.InvalidArgument, "max_users must be a non-negative integer")
+	}
+
+	return int(n.NumberValue), nil
+}
+
+// dateField returns the day of the field name, zero if absent.
+func dateField(req *structpb.Struct, name string) (time.Time, error) {
+	v, ok := req.GetFields()[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+	day, err := time.Parse(dateLayout, v.GetStringValue())
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%s must be a date like 2006-01-02", name)
+	}
+
+	return day, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SetAppQuota",
+			Handler: handler(SetAppQuotaMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.SetAppQuota(ctx, req)
+			}),
+		},
+		{
+			MethodName: "GetAppQuota",
+			Handler: handler(GetAppQuotaMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.GetAppQuota(ctx, req)
+			}),
+		},
+		{
+			MethodName: "GetAppUsage",
+			Handler: handler(GetAppUsageMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.GetAppUsage(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(".google.protobuf.Struct"),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fileName),
+		Package:    proto.String("sso.quota.v1"),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Quotas"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("SetAppQuota"),
+				method("GetAppQuota"),
+				method("GetAppUsage"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,401 @@
+// Package session implements sso.session.v1.Session, which lets the holder
+// of a token see who it belongs to without decoding it, revoke it, accept
+// the terms of service, schedule the deletion of their account and, for
+// admins, trade it and their password for an elevated token.
+//
+// The service is not part of course-work-protos yet, so, like the Debug
+// service, its descriptor is built here from well-known types:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' localhost:44044 sso.session.v1.Session/WhoAmI
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' localhost:44044 sso.session.v1.Session/Logout
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' -d '"<password>"' localhost:44044 sso.session.v1.Session/ElevatePrivileges
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' -d '"2024-06"' localhost:44044 sso.session.v1.Session/AcceptTerms
+//	grpcurl -plaintext -H 'authorization: Bearer <token>' -d '{}' localhost:44044 sso.session.v1.Session/ScheduleDeletion
+//	grpcurl -plaintext -d '{"user_id": 42, "reactivation_token": "..."}' localhost:44044 sso.session.v1.Session/CancelDeletion
+package session
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/jwt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	serviceName = "sso.session.v1.Session"
+	fileName    = "sso/session.proto"
+
+	// WhoAmIMethod is the full name of WhoAmI. It must have a policy
+	// requiring a token, see interceptors.Authorize.
+	WhoAmIMethod = "/" + serviceName + "/WhoAmI"
+	// LogoutMethod is the full name of Logout. It must have a policy
+	// requiring a token, see interceptors.Authorize.
+	LogoutMethod = "/" + serviceName + "/Logout"
+	// ElevatePrivilegesMethod is the full name of ElevatePrivileges. It
+	// must have a policy requiring the admin role, see
+	// interceptors.Authorize.
+	ElevatePrivilegesMethod = "/" + serviceName + "/ElevatePrivileges"
+	// AcceptTermsMethod is the full name of AcceptTerms. It must have a
+	// policy requiring a token, see interceptors.Authorize.
+	AcceptTermsMethod = "/" + serviceName + "/AcceptTerms"
+	// ScheduleDeletionMethod is the full name of ScheduleDeletion. It must
+	// have a policy requiring a token, see interceptors.Authorize.
+	ScheduleDeletionMethod = "/" + serviceName + "/ScheduleDeletion"
+	// CancelDeletionMethod is the full name of CancelDeletion. It needs no
+	// policy: the tokens of the user are revoked while the deletion is
+	// pending, and the reactivation token proves the caller owns the
+	// account.
+	CancelDeletionMethod = "/" + serviceName + "/CancelDeletion"
+
+	// maxUserID is the largest user_id a Struct number carries exactly.
+	maxUserID = 1 << 53
+)
+
+// Identifier describes and revokes the tokens of callers.
+type Identifier interface {
+	WhoAmI(ctx context.Context, claims jwt.Claims) (models.Identity, error)
+	Logout(ctx context.Context, claims jwt.Claims) error
+	ElevatePrivileges(ctx context.Context, token string, password string) (string, error)
+}
+
+// TermsAcceptor records the terms of service accepted by callers.
+type TermsAcceptor interface {
+	AcceptTerms(ctx context.Context, userID int64, version string) error
+}
+
+// Deleter schedules and cancels account deletions. ScheduleDeletion
+// checks that the caller, see authctx, may act for the user.
+type Deleter interface {
+	ScheduleDeletion(ctx context.Context, userID int64) (models.Deletion, error)
+	CancelDeletion(ctx context.Context, userID int64, token string) error
+}
+
+// Server is the handler interface of the Session service.
+type Server interface {
+	WhoAmI(ctx context.Context, req *emptypb.Empty) (*structpb.Struct, error)
+	Logout(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error)
+	ElevatePrivileges(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	AcceptTerms(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error)
+	ScheduleDeletion(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	CancelDeletion(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error)
+}
+
+type serverAPI struct {
+	identifier Identifier
+	terms      TermsAcceptor
+	deletions  Deleter
+}
+
+// Register registers the service. A nil terms leaves AcceptTerms
+// unimplemented, a nil deletions ScheduleDeletion and CancelDeletion.
+func Register(gRPC *grpc.Server, identifier Identifier, terms TermsAcceptor, deletions Deleter) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{identifier: identifier, terms: terms, deletions: deletions})
+}
+
+// WhoAmI describes the holder of the bearer token. The token has already
+// been validated by the Authorize interceptor.
+func (s *serverAPI) WhoAmI(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	claims, ok := interceptors.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	identity, err := s.identifier.WhoAmI(ctx, claims)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	roles := make([]any, len(identity.Roles))
+	for i, role := range identity.Roles {
+		roles[i] = role
+	}
+	fields := map[string]any{
+		"user_id":     identity.UserID,
+		"email":       identity.Email,
+		"first_name":  identity.FirstName,
+		"last_name":   identity.LastName,
+		"middle_name": identity.MiddleName,
+		"roles":       roles,
+		"app_id":      identity.AppID,
+		"app_name":    identity.AppName,
+		"expires_at":  identity.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if !identity.IssuedAt.IsZero() {
+		fields["issued_at"] = identity.IssuedAt.UTC().Format(time.RFC3339)
+	}
+
+	resp, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode identity")
+	}
+
+	return resp, nil
+}
+
+// Logout revokes the bearer token, on every instance sharing the storage.
+// The token has already been validated by the Authorize interceptor.
+func (s *serverAPI) Logout(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	claims, ok := interceptors.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	if err := s.identifier.Logout(ctx, claims); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ElevatePrivileges returns a step-up token of the caller, valid for
+// auth.ElevatedTokenTTL, for the password in req. The password is never
+// logged: payload logging masks string values whole.
+func (s *serverAPI) ElevatePrivileges(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	if _, ok := interceptors.ClaimsFromContext(ctx); !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	token, err := s.identifier.ElevatePrivileges(ctx, interceptors.BearerToken(ctx), req.GetValue())
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return wrapperspb.String(token), nil
+}
+
+// AcceptTerms records that the caller accepted the version of the terms
+// of service in req, which must be the required one.
+func (s *serverAPI) AcceptTerms(ctx context.Context, req *wrapperspb.StringValue) (*emptypb.Empty, error) {
+	if s.terms == nil {
+		return nil, status.Error(codes.Unimplemented, "terms of service are not enabled")
+	}
+	claims, ok := interceptors.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	if err := s.terms.AcceptTerms(ctx, claims.UserID, req.GetValue()); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ScheduleDeletion schedules the deletion of the account of user_id, the
+// caller by default, and returns its delete_after and the
+// reactivation_token that cancels it, which is not shown again. Only the
+// user and admins may.
+func (s *serverAPI) ScheduleDeletion(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if s.deletions == nil {
+		return nil, status.Error(codes.Unimplemented, "account deletion is not enabled")
+	}
+	caller, ok := authctx.CallerFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "token is required")
+	}
+
+	userID := caller.UserID
+	if _, ok := req.GetFields()["user_id"]; ok {
+		var err error
+		if userID, err = userIDField(req); err != nil {
+			return nil, err
+		}
+	}
+
+	deletion, err := s.deletions.ScheduleDeletion(ctx, userID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	resp, err := structpb.NewStruct(map[string]any{
+		"user_id":            deletion.UserID,
+		"delete_after":       deletion.DeleteAfter.UTC().Format(time.RFC3339),
+		"reactivation_token": deletion.ReactivationToken,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode deletion")
+	}
+
+	return resp, nil
+}
+
+// CancelDeletion cancels the scheduled deletion of the account of user_id
+// with the reactivation_token ScheduleDeletion returned.
+func (s *serverAPI) CancelDeletion(ctx context.Context, req *structpb.Struct) (*emptypb.Empty, error) {
+	if s.deletions == nil {
+		return nil, status.Error(codes.Unimplemented, "account deletion is not enabled")
+	}
+	userID, err := userIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	token := req.GetFields()["reactivation_token"].GetStringValue()
+	if token == "" {
+		return nil, status.Error(codes.InvalidArgument, "reactivation_token is required")
+	}
+
+	if err := s.deletions.CancelDeletion(ctx, userID, token); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func userIDField(req *structpb.Struct) (int64, error) {
+	n, ok := req.GetFields()["user_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > maxUserID {
+		return 0, status.Error(codes.InvalidArgument, "user_id must be a positive integer")
+	}
+
+	return int64(n.NumberValue), nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WhoAmI",
+			Handler: handler(WhoAmIMethod, func(srv Server, ctx context.Context, req *emptypb.Empty) (any, error) {
+				return srv.WhoAmI(ctx, req)
+			}),
+		},
+		{
+			MethodName: "Logout",
+			Handler: handler(LogoutMethod, func(srv Server, ctx context.Context, req *emptypb.Empty) (any, error) {
+				return srv.Logout(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ElevatePrivileges",
+			Handler: handler(ElevatePrivilegesMethod, func(srv Server, ctx context.Context, req *wrapperspb.StringValue) (any, error) {
+				return srv.ElevatePrivileges(ctx, req)
+			}),
+		},
+		{
+			MethodName: "AcceptTerms",
+			Handler: handler(AcceptTermsMethod, func(srv Server, ctx context.Context, req *wrapperspb.StringValue) (any, error) {
+				return srv.AcceptTerms(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ScheduleDeletion",
+			Handler: handler(ScheduleDeletionMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ScheduleDeletion(ctx, req)
+			}),
+		},
+		{
+			MethodName: "CancelDeletion",
+			Handler: handler(CancelDeletionMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.CancelDeletion(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of, bar the request and response.
+func handler[Req any](
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *Req) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(Req)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*Req))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(fileName),
+		Package: proto.String("sso.session.v1"),
+		Dependency: []string{
+			"google/protobuf/empty.proto",
+			"google/protobuf/struct.proto",
+			"google/protobuf/wrappers.proto",
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Session"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("WhoAmI"),
+					InputType:  proto.String(".google.protobuf.Empty"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("Logout"),
+					InputType:  proto.String(".google.protobuf.Empty"),
+					OutputType: proto.String(".google.protobuf.Empty"),
+				},
+				{
+					Name:       proto.String("ElevatePrivileges"),
+					InputType:  proto.String(".google.protobuf.StringValue"),
+					OutputType: proto.String(".google.protobuf.StringValue"),
+				},
+				{
+					Name:       proto.String("AcceptTerms"),
+					InputType:  proto.String(".google.protobuf.StringValue"),
+					OutputType: proto.String(".google.protobuf.Empty"),
+				},
+				{
+					Name:       proto.String("ScheduleDeletion"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Struct"),
+				},
+				{
+					Name:       proto.String("CancelDeletion"),
+					InputType:  proto.String(".google.protobuf.Struct"),
+					OutputType: proto.String(".google.protobuf.Empty"),
+				},
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,316 @@
+// Package webhook implements sso.webhook.v1.Webhooks, with which admins
+// subscribe apps to lifecycle events, see the webhooks service.
+//
+// The service is not part of course-work-protos yet, so, like the Quotas
+// service, its descriptor is built here from well-known types. Requests
+// and responses are Structs:
+//
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"app_id": 2, "url": "https://lms.example.com/hooks", "event_types": ["user.registered"]}' \
+//		localhost:44044 sso.webhook.v1.Webhooks/CreateWebhook
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"app_id": 2}' localhost:44044 sso.webhook.v1.Webhooks/ListWebhooks
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"id": 1}' localhost:44044 sso.webhook.v1.Webhooks/TestWebhook
+//	grpcurl -plaintext -H 'authorization: Bearer <admin token>' \
+//		-d '{"id": 1}' localhost:44044 sso.webhook.v1.Webhooks/DeleteWebhook
+package webhook
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/grpc/grpcerr"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	serviceName = "sso.webhook.v1.Webhooks"
+	fileName    = "sso/webhook.proto"
+
+	// The full names of the methods. Each must have a policy requiring
+	// the admin role, see interceptors.Authorize.
+	CreateWebhookMethod = "/" + serviceName + "/CreateWebhook"
+	ListWebhooksMethod  = "/" + serviceName + "/ListWebhooks"
+	DeleteWebhookMethod = "/" + serviceName + "/DeleteWebhook"
+	TestWebhookMethod   = "/" + serviceName + "/TestWebhook"
+
+	// maxID is the largest ID a Struct number carries exactly.
+	maxID = 1 << 53
+)
+
+type Manager interface {
+	CreateWebhook(ctx context.Context, appID int32, rawURL string, eventTypes []string) (models.Webhook, error)
+	ListWebhooks(ctx context.Context, appID int32) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	TestWebhook(ctx context.Context, id int64) (int, error)
+}
+
+// Server is the handler interface of the Webhooks service.
+type Server interface {
+	CreateWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	ListWebhooks(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	DeleteWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+	TestWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+type serverAPI struct {
+	webhooks Manager
+}
+
+func Register(gRPC *grpc.Server, webhooks Manager) {
+	gRPC.RegisterService(&serviceDesc, &serverAPI{webhooks: webhooks})
+}
+
+// CreateWebhook subscribes app_id to event_types at url and returns the
+// webhook with its secret, which is not shown again.
+func (s *serverAPI) CreateWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes, err := eventTypesField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.webhooks.CreateWebhook(ctx, appID, req.GetFields()["url"].GetStringValue(), eventTypes)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	fields := webhookFields(webhook)
+	fields["secret"] = webhook.Secret
+
+	return response(fields)
+}
+
+// ListWebhooks returns the webhooks of app_id, without their secrets, and
+// their delivery state.
+func (s *serverAPI) ListWebhooks(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	appID, err := appIDField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := s.webhooks.ListWebhooks(ctx, appID)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	list := make([]any, len(webhooks))
+	for i, w := range webhooks {
+		list[i] = webhookFields(w)
+	}
+
+	return response(map[string]any{"webhooks": list})
+}
+
+// DeleteWebhook removes the webhook id.
+func (s *serverAPI) DeleteWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	id, err := idField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.webhooks.DeleteWebhook(ctx, id); err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return &structpb.Struct{}, nil
+}
+
+// TestWebhook sends a ping to the webhook id and returns the HTTP status
+// its url answered with.
+func (s *serverAPI) TestWebhook(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	id, err := idField(req)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := s.webhooks.TestWebhook(ctx, id)
+	if err != nil {
+		return nil, grpcerr.Status(err)
+	}
+
+	return response(map[string]any{"status": code})
+}
+
+func webhookFields(w models.Webhook) map[string]any {
+	fields := map[string]any{
+		"id":            w.ID,
+		"app_id":        w.AppID,
+		"url":           w.URL,
+		"event_types":   toList(w.EventTypes),
+		"created_at":    w.CreatedAt.UTC().Format(time.RFC3339),
+		"active":        w.Active,
+		"last_event_id": w.LastEventID,
+		"failures":      w.Failures,
+	}
+	if !w.NextAttemptAt.IsZero() {
+		fields["next_attempt_at"] = w.NextAttemptAt.UTC().Format(time.RFC3339)
+	}
+
+	return fields
+}
+
+func appIDField(req *structpb.Struct) (int32, error) {
+	n, ok := req.GetFields()["app_id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > math.MaxInt32 {
+		return 0, status.Error(codes.InvalidArgument, "app_id must be a positive integer")
+	}
+
+	return int32(n.NumberValue), nil
+}
+
+func idField(req *structpb.Struct) (int64, error) {
+	n, ok := req.GetFields()["id"].GetKind().(*structpb.Value_NumberValue)
+	if !ok || n.NumberValue != math.Trunc(n.NumberValue) || n.NumberValue < 1 || n.NumberValue > maxID {
+		return 0, status.Error(codes.InvalidArgument, "id must be a positive integer")
+	}
+
+	return int64(n.NumberValue), nil
+}
+
+func eventTypesField(req *structpb.Struct) ([]string, error) {
+	values := req.GetFields()["event_types"].GetListValue().GetValues()
+	eventTypes := make([]string, len(values))
+	for i, v := range values {
+		t, ok := v.GetKind().(*structpb.Value_StringValue)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "event_types must be a list of strings")
+		}
+		eventTypes[i] = t.StringValue
+	}
+
+	return eventTypes, nil
+}
+
+// toList converts ss for structpb, which takes []any only.
+func toList(ss []string) []any {
+	res := make([]any, len(ss))
+	for i, s := range ss {
+		res[i] = s
+	}
+
+	return res
+}
+
+func response(fields map[string]any) (*structpb.Struct, error) {
+	resp, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encode response")
+	}
+
+	return resp, nil
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateWebhook",
+			Handler: handler(CreateWebhookMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.CreateWebhook(ctx, req)
+			}),
+		},
+		{
+			MethodName: "ListWebhooks",
+			Handler: handler(ListWebhooksMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.ListWebhooks(ctx, req)
+			}),
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler: handler(DeleteWebhookMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.DeleteWebhook(ctx, req)
+			}),
+		},
+		{
+			MethodName: "TestWebhook",
+			Handler: handler(TestWebhookMethod, func(srv Server, ctx context.Context, req *structpb.Struct) (any, error) {
+				return srv.TestWebhook(ctx, req)
+			}),
+		},
+	},
+	Metadata: fileName,
+}
+
+// handler returns the method handler of fullMethod, which every method of
+// the service has the same shape of.
+func handler(
+	fullMethod string,
+	call func(srv Server, ctx context.Context, req *structpb.Struct) (any, error),
+) grpc.MethodHandler {
+	return func(
+		srv any,
+		ctx context.Context,
+		dec func(any) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (any, error) {
+		in := new(structpb.Struct)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(Server), ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod,
+		}
+		handler := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(Server), ctx, req.(*structpb.Struct))
+		}
+
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// init registers the descriptor of the service, so reflection can describe
+// it.
+func init() {
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".google.protobuf.Struct"),
+			OutputType: proto.String(".google.protobuf.Struct"),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String(fileName),
+		Package:    proto.String("sso.webhook.v1"),
+		Dependency: []string{"google/protobuf/struct.proto"},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Webhooks"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("CreateWebhook"),
+				method("ListWebhooks"),
+				method("DeleteWebhook"),
+				method("TestWebhook"),
+			},
+		}},
+		Syntax: proto.String("proto3"),
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package introspect serves a plain HTTP token check for edge proxies,
+// such as nginx auth_request or Envoy ext_authz, that cannot speak gRPC.
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/jwt"
+)
+
+const (
+	Path = "/v1/introspect"
+
+	// UserIDHeader and AppIDHeader repeat the claims of a valid token for
+	// proxies that only look at the headers of the response.
+	UserIDHeader = "X-User-Id"
+	AppIDHeader  = "X-App-Id"
+)
+
+// Validator validates tokens, see auth.Auth.IntrospectToken.
+type Validator interface {
+	IntrospectToken(ctx context.Context, token string) (jwt.Claims, error)
+}
+
+// Response is the body of a 200: the claims a proxy needs to pass on,
+// nothing it does not.
+type Response struct {
+	Active    bool  `json:"active"`
+	UserID    int64 `json:"user_id"`
+	AppID     int32 `json:"app_id"`
+	ExpiresAt int64 `json:"exp"`
+	Elevated  bool  `json:"elevated,omitempty"`
+}
+
+// Handler serves GET Path. The token is the bearer token of the
+// Authorization header: a valid one gets a 200 with a Response, any other
+// a 401 without body. A failure to validate is a 503, so that proxies do
+// not take an outage of the storage for a forged token.
+func Handler(log *slog.Logger, validator Validator) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+Path, func(w http.ResponseWriter, r *http.Request) {
+		const op = "introspect.Handler"
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		claims, err := validator.IntrospectToken(r.Context(), token)
+		if errors.Is(err, errs.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		if err != nil {
+			log.Error("failed to introspect token", slog.String("op", op), slog.Any("error", err))
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Header().Set(UserIDHeader, strconv.FormatInt(claims.UserID, 10))
+		w.Header().Set(AppIDHeader, strconv.FormatInt(int64(claims.AppID), 10))
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(Response{
+			Active:    true,
+			UserID:    claims.UserID,
+			AppID:     claims.AppID,
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			Elevated:  claims.Elevated,
+		}); err != nil {
+			log.Warn("failed to write introspection", slog.String("op", op), slog.Any("error", err))
+		}
+	})
+
+	return mux
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+
+	return token, token != ""
+}
@@ -0,0 +1,120 @@
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/services/auth"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeValidator map[string]jwt.Claims
+
+func (v fakeValidator) IntrospectToken(_ context.Context, token string) (jwt.Claims, error) {
+	if token == "broken" {
+		return jwt.Claims{}, errors.New("storage is down")
+	}
+	claims, ok := v[token]
+	if !ok {
+		return jwt.Claims{}, errs.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func introspect(handler http.Handler, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, Path, nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	expires := time.Unix(1_900_000_000, 0)
+	handler := Handler(slog.New(slog.NewTextHandler(io.Discard, nil)), fakeValidator{
+		"valid": {UserID: 42, AppID: 7, ExpiresAt: expires},
+	})
+
+	rec := introspect(handler, "Bearer valid")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "42", rec.Header().Get(UserIDHeader))
+	assert.Equal(t, "7", rec.Header().Get(AppIDHeader))
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	var resp Response
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, Response{Active: true, UserID: 42, AppID: 7, ExpiresAt: expires.Unix()}, resp)
+
+	rec = introspect(handler, "Bearer forged")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get("WWW-Authenticate"))
+	assert.Empty(t, rec.Body.String())
+	assert.Empty(t, rec.Header().Get(UserIDHeader))
+
+	for _, authorization := range []string{"", "Basic dXNlcjpwYXNz", "Bearer "} {
+		rec = introspect(handler, authorization)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, authorization)
+		assert.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"), authorization)
+	}
+
+	rec = introspect(handler, "Bearer broken")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	req := httptest.NewRequest(http.MethodPost, Path, nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// BenchmarkHandler_Cached reports the p99 of validations served from the
+// cache of auth.Auth.IntrospectToken, which should stay well under a
+// millisecond.
+func BenchmarkHandler_Cached(b *testing.B) {
+	ctx := context.Background()
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 1, Name: "lms", Secret: "test-secret"})
+	a, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		auth.WithHasher(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+		auth.WithTokenCache(time.Hour, 10),
+	)
+	require.NoError(b, err)
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(b, err)
+	token, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(b, err)
+
+	handler := Handler(slog.New(slog.NewTextHandler(io.Discard, nil)), a)
+	require.Equal(b, http.StatusOK, introspect(handler, "Bearer "+token).Code)
+
+	durations := make([]time.Duration, 0, b.N)
+	b.ResetTimer()
+	for range b.N {
+		start := time.Now()
+		if rec := introspect(handler, "Bearer "+token); rec.Code != http.StatusOK {
+			b.Fatalf("got %d", rec.Code)
+		}
+		durations = append(durations, time.Since(start))
+	}
+	b.StopTimer()
+
+	slices.Sort(durations)
+	b.ReportMetric(float64(durations[len(durations)*99/100])/float64(time.Microsecond), "p99-µs")
+}
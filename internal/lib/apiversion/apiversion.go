@@ -0,0 +1,36 @@
+// Package apiversion carries the API version negotiated for a call in the
+// context, so handlers can keep the old behavior for clients that have
+// not moved to a breaking change yet.
+//
+// Versions are positive integers. A server speaks every version from Min
+// to Max; a client declares the one it speaks in the x-api-version
+// header, and one that declares none is taken to speak Min.
+package apiversion
+
+import "context"
+
+const (
+	// Min is the oldest version the server speaks. Raising it drops the
+	// clients of the versions below.
+	Min = 1
+	// Max is the newest version the server speaks. A breaking change
+	// raises it and keeps the old behavior for FromContext(ctx) < Max.
+	Max = 1
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying version.
+func NewContext(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, ctxKey{}, version)
+}
+
+// FromContext returns the version stored by NewContext, or Min if there
+// is none.
+func FromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(ctxKey{}).(int); ok {
+		return v
+	}
+
+	return Min
+}
@@ -0,0 +1,13 @@
+package apiversion
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Min, FromContext(context.Background()))
+	assert.Equal(t, 3, FromContext(NewContext(context.Background(), 3)))
+}
@@ -0,0 +1,77 @@
+// Package audit carries the envelope of audit entries, which call made
+// them and from where, in the context, so that every entry gets the same
+// one without the call sites passing it along.
+package audit
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Unknown stands for the values of the envelope that are not known, such
+// as the method of an entry logged by a background job.
+const Unknown = "unknown"
+
+// Envelope describes the call an audit entry was made in.
+type Envelope struct {
+	Method    string
+	RequestID string
+	// ClientIP is the address of the client behind trusted proxies, see
+	// clientip.Extract.
+	ClientIP  string
+	UserAgent string
+	// ClientVersion is the version an app declares, see
+	// interceptors.ClientVersionHeader.
+	ClientVersion string
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying e.
+func NewContext(ctx context.Context, e Envelope) context.Context {
+	return context.WithValue(ctx, ctxKey{}, e)
+}
+
+// FromContext returns the envelope stored by NewContext, with Unknown for
+// every value it lacks. Without one, every value is Unknown.
+func FromContext(ctx context.Context) Envelope {
+	e, _ := ctx.Value(ctxKey{}).(Envelope)
+
+	return Envelope{
+		Method:        orUnknown(e.Method),
+		RequestID:     orUnknown(e.RequestID),
+		ClientIP:      orUnknown(e.ClientIP),
+		UserAgent:     orUnknown(e.UserAgent),
+		ClientVersion: orUnknown(e.ClientVersion),
+	}
+}
+
+// LogValue logs e as a group.
+func (e Envelope) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("method", e.Method),
+		slog.String("id", e.RequestID),
+		slog.String("client_ip", e.ClientIP),
+		slog.String("user_agent", e.UserAgent),
+		slog.String("client_version", e.ClientVersion),
+	)
+}
+
+// Log logs an audit entry of event to log, tagged with audit=event and
+// carrying the envelope of ctx under request.
+func Log(ctx context.Context, log *slog.Logger, level slog.Level, msg, event string, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{
+		slog.String("audit", event),
+		slog.Any("request", FromContext(ctx)),
+	}, attrs...)
+
+	log.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func orUnknown(v string) string {
+	if v == "" {
+		return Unknown
+	}
+
+	return v
+}
@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Envelope{
+		Method:        Unknown,
+		RequestID:     Unknown,
+		ClientIP:      Unknown,
+		UserAgent:     Unknown,
+		ClientVersion: Unknown,
+	}, FromContext(context.Background()))
+
+	ctx := NewContext(context.Background(), Envelope{Method: "/auth.Auth/Login", RequestID: "req-1"})
+	assert.Equal(t, Envelope{
+		Method:        "/auth.Auth/Login",
+		RequestID:     "req-1",
+		ClientIP:      Unknown,
+		UserAgent:     Unknown,
+		ClientVersion: Unknown,
+	}, FromContext(ctx))
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+	ctx := NewContext(context.Background(), Envelope{
+		Method:        "/auth.Auth/CreateInvite",
+		RequestID:     "req-1",
+		ClientIP:      "198.51.100.4",
+		UserAgent:     "grpc-go/1.70.0",
+		ClientVersion: "2.3",
+	})
+
+	Log(ctx, log, slog.LevelInfo, "invite created", "invite_created", slog.Int64("invite_id", 7))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "invite created", entry["msg"])
+	assert.Equal(t, "invite_created", entry["audit"])
+	assert.Equal(t, float64(7), entry["invite_id"])
+	assert.Equal(t, map[string]any{
+		"method":         "/auth.Auth/CreateInvite",
+		"id":             "req-1",
+		"client_ip":      "198.51.100.4",
+		"user_agent":     "grpc-go/1.70.0",
+		"client_version": "2.3",
+	}, entry["request"])
+}
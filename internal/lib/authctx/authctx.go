@@ -0,0 +1,58 @@
+// Package authctx carries the authenticated caller of a call in the
+// context, from the interceptor that checked its token down to the
+// services that decide what the caller may do.
+package authctx
+
+import (
+	"context"
+	"slices"
+)
+
+// Caller is the holder of the token a call was authenticated with.
+type Caller struct {
+	UserID int64
+	// Roles are those of the user the interceptor looked up, none if it
+	// did not need them. Services must not take a missing role as proof
+	// the user does not have it.
+	Roles []string
+	// AppID is the app the token was issued for.
+	AppID int32
+	// Elevated marks a step-up token.
+	Elevated bool
+}
+
+// HasRole reports whether role is among the known roles of c.
+func (c Caller) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+type ctxKey struct{}
+
+// WithCaller returns a copy of ctx carrying caller.
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, ctxKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored by WithCaller. ok is false
+// for unauthenticated calls.
+func CallerFromContext(ctx context.Context) (caller Caller, ok bool) {
+	caller, ok = ctx.Value(ctxKey{}).(Caller)
+
+	return caller, ok
+}
+
+type appKey struct{}
+
+// WithApp returns a copy of ctx carrying the ID of the app client the call
+// was authenticated as, by its credentials rather than a token.
+func WithApp(ctx context.Context, appID int32) context.Context {
+	return context.WithValue(ctx, appKey{}, appID)
+}
+
+// AppFromContext returns the app stored by WithApp. ok is false for calls
+// made without app credentials.
+func AppFromContext(ctx context.Context) (appID int32, ok bool) {
+	appID, ok = ctx.Value(appKey{}).(int32)
+
+	return appID, ok
+}
@@ -0,0 +1,87 @@
+// Package captcha verifies CAPTCHA responses with a siteverify endpoint,
+// the protocol reCAPTCHA, hCaptcha and Turnstile share, and carries the
+// response a client sent with a call in the context.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrFailed is a response the provider did not accept.
+var ErrFailed = errors.New("captcha not passed")
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the CAPTCHA response of the
+// call.
+func NewContext(ctx context.Context, response string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, response)
+}
+
+// FromContext returns the response stored by NewContext, empty if there
+// is none.
+func FromContext(ctx context.Context) string {
+	response, _ := ctx.Value(ctxKey{}).(string)
+
+	return response
+}
+
+// SiteVerifier asks the siteverify endpoint at URL whether a response is
+// valid, authenticated with Secret.
+type SiteVerifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+// Verify returns ErrFailed unless the provider accepts response, solved by
+// the client at remoteIP, which may be empty. Other errors are failures to
+// ask.
+func (v SiteVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	const op = "captcha.Verify"
+
+	if response == "" {
+		return ErrFailed
+	}
+
+	form := url.Values{"secret": {v.Secret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: siteverify answered %s", op, resp.Status)
+	}
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !result.Success {
+		return ErrFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package captcha
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteVerifier(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "secret", r.PostForm.Get("secret"))
+		switch r.PostForm.Get("response") {
+		case "solved":
+			assert.Equal(t, "203.0.113.7", r.PostForm.Get("remoteip"))
+			_, _ = w.Write([]byte(`{"success": true}`))
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+		}
+	}))
+	defer srv.Close()
+
+	v := SiteVerifier{URL: srv.URL, Secret: "secret", Client: srv.Client()}
+	ctx := context.Background()
+
+	require.NoError(t, v.Verify(ctx, "solved", "203.0.113.7"))
+	assert.ErrorIs(t, v.Verify(ctx, "wrong", ""), ErrFailed)
+	assert.ErrorIs(t, v.Verify(ctx, "", ""), ErrFailed)
+
+	err := v.Verify(ctx, "broken", "")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrFailed)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Empty(t, FromContext(context.Background()))
+	assert.Equal(t, "solved", FromContext(NewContext(context.Background(), "solved")))
+}
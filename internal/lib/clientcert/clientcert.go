@@ -0,0 +1,34 @@
+// Package clientcert carries the thumbprint of the client certificate a
+// call was made with in the context, for binding tokens to the mTLS
+// channel they were issued on (RFC 8705).
+package clientcert
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+)
+
+// Thumbprint returns the x5t#S256 thumbprint of cert: the unpadded
+// base64url SHA-256 of its DER encoding.
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying thumbprint.
+func NewContext(ctx context.Context, thumbprint string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, thumbprint)
+}
+
+// FromContext returns the thumbprint stored by NewContext. ok is false for
+// calls without a verified client certificate.
+func FromContext(ctx context.Context) (thumbprint string, ok bool) {
+	thumbprint, ok = ctx.Value(ctxKey{}).(string)
+
+	return thumbprint, ok && thumbprint != ""
+}
@@ -0,0 +1,127 @@
+// Package clientip finds the address of the client behind trusted
+// reverse proxies and carries it in the context.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying ip.
+func NewContext(ctx context.Context, ip netip.Addr) context.Context {
+	return context.WithValue(ctx, ctxKey{}, ip)
+}
+
+// FromContext returns the client IP stored by NewContext. ok is false when
+// there is none, e.g. for calls over a unix socket.
+func FromContext(ctx context.Context) (ip netip.Addr, ok bool) {
+	ip, ok = ctx.Value(ctxKey{}).(netip.Addr)
+
+	return ip, ok && ip.IsValid()
+}
+
+// ParsePrefixes parses CIDRs like "10.0.0.0/8". A bare address is taken as
+// a single-host prefix.
+func ParsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Extract returns the client IP of a call from peer with metadata md.
+//
+// Forwarding headers are only believed when peer is a trusted proxy;
+// anyone else could put anything there. x-forwarded-for is walked from the
+// right, skipping trusted hops, and the first untrusted address is the
+// client. x-real-ip is used when there is no x-forwarded-for. The zero
+// Addr is returned for peers without an IP address.
+func Extract(peer net.Addr, md metadata.MD, trusted []netip.Prefix) netip.Addr {
+	tcp, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return netip.Addr{}
+	}
+	peerIP, ok := netip.AddrFromSlice(tcp.IP)
+	if !ok {
+		return netip.Addr{}
+	}
+	peerIP = peerIP.Unmap()
+
+	if !isTrusted(peerIP, trusted) {
+		return peerIP
+	}
+
+	var hops []string
+	for _, v := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := parseHop(hops[i])
+		if err != nil {
+			// A garbled hop means the chain cannot be trusted past it.
+			break
+		}
+		if !isTrusted(ip, trusted) || i == 0 {
+			return ip
+		}
+	}
+
+	if len(hops) == 0 {
+		if ip, err := parseHop(md.Get("x-real-ip")...); err == nil {
+			return ip
+		}
+	}
+
+	return peerIP
+}
+
+func parseHop(values ...string) (netip.Addr, error) {
+	if len(values) == 0 {
+		return netip.Addr{}, fmt.Errorf("empty hop")
+	}
+
+	hop := strings.TrimSpace(values[0])
+	// Some proxies append the port; IPv6 with a port comes in brackets.
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+
+	ip, err := netip.ParseAddr(strings.Trim(hop, "[]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return ip.Unmap(), nil
+}
+
+func isTrusted(ip netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,128 @@
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestExtract(t *testing.T) {
+	trusted, err := ParsePrefixes([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		peer string
+		md   metadata.MD
+		want string
+	}{
+		{name: "untrusted peer without headers", peer: "203.0.113.7", want: "203.0.113.7"},
+		{
+			name: "untrusted peer spoofing headers",
+			peer: "203.0.113.7",
+			md:   metadata.Pairs("x-forwarded-for", "1.2.3.4", "x-real-ip", "5.6.7.8"),
+			want: "203.0.113.7",
+		},
+		{
+			name: "trusted ipv4 proxy",
+			peer: "10.1.2.3",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.4"),
+			want: "198.51.100.4",
+		},
+		{
+			name: "trusted single-host proxy",
+			peer: "192.0.2.1",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.4"),
+			want: "198.51.100.4",
+		},
+		{
+			name: "trusted ipv6 proxy",
+			peer: "fd00::1",
+			md:   metadata.Pairs("x-forwarded-for", "2001:db8::42"),
+			want: "2001:db8::42",
+		},
+		{
+			name: "multiple hops skip trusted ones from the right",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "1.1.1.1, 198.51.100.4, 10.0.0.2"),
+			want: "198.51.100.4",
+		},
+		{
+			name: "hops in several headers",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.4", "x-forwarded-for", "10.0.0.2"),
+			want: "198.51.100.4",
+		},
+		{
+			name: "client forging a leftmost hop",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "10.9.9.9, 203.0.113.9"),
+			want: "203.0.113.9",
+		},
+		{
+			name: "all hops trusted",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "10.0.0.3, 10.0.0.2"),
+			want: "10.0.0.3",
+		},
+		{
+			name: "hop with port",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "[2001:db8::42]:443"),
+			want: "2001:db8::42",
+		},
+		{
+			name: "garbled hop stops at the proxy",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.4, not-an-ip"),
+			want: "10.0.0.1",
+		},
+		{
+			name: "x-real-ip without x-forwarded-for",
+			peer: "10.0.0.1",
+			md:   metadata.Pairs("x-real-ip", "198.51.100.4"),
+			want: "198.51.100.4",
+		},
+		{
+			name: "ipv4-mapped ipv6 peer",
+			peer: "::ffff:10.0.0.1",
+			md:   metadata.Pairs("x-forwarded-for", "198.51.100.4"),
+			want: "198.51.100.4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 50000}
+
+			got := Extract(peer, tt.md, trusted)
+			assert.Equal(t, netip.MustParseAddr(tt.want), got)
+		})
+	}
+}
+
+func TestExtract_NonIPPeer(t *testing.T) {
+	got := Extract(&net.UnixAddr{Name: "/run/sso.sock", Net: "unix"}, metadata.Pairs("x-forwarded-for", "1.2.3.4"), nil)
+	assert.False(t, got.IsValid())
+}
+
+func TestParsePrefixes_Invalid(t *testing.T) {
+	_, err := ParsePrefixes([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParsePrefixes([]string{"proxy.internal"})
+	assert.Error(t, err)
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	ip := netip.MustParseAddr("198.51.100.4")
+	got, ok := FromContext(NewContext(context.Background(), ip))
+	assert.True(t, ok)
+	assert.Equal(t, ip, got)
+}
@@ -0,0 +1,113 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFake_Advance(t *testing.T) {
+	f := NewFake(epoch)
+	assert.Equal(t, epoch, f.Now())
+
+	f.Advance(time.Minute)
+	assert.Equal(t, epoch.Add(time.Minute), f.Now())
+
+	f.Set(epoch)
+	assert.Equal(t, epoch, f.Now())
+}
+
+func TestFake_Ticker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Minute)
+	assert.Equal(t, 1, f.Tickers())
+
+	f.Advance(59 * time.Second)
+	assertNoTick(t, ticker)
+
+	f.Advance(time.Second)
+	assert.Equal(t, epoch.Add(time.Minute), <-ticker.C())
+
+	// Ticks nobody reads are dropped rather than queued.
+	f.Advance(3 * time.Minute)
+	assert.Equal(t, epoch.Add(2*time.Minute), <-ticker.C())
+	assertNoTick(t, ticker)
+
+	ticker.Stop()
+	assert.Equal(t, 0, f.Tickers())
+	f.Advance(time.Minute)
+	assertNoTick(t, ticker)
+}
+
+func TestFake_Concurrent(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				f.Advance(time.Millisecond)
+				_ = f.Now()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, epoch.Add(800*time.Millisecond), f.Now())
+}
+
+func TestSleep(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan error, 1)
+	go func() { done <- Sleep(context.Background(), f, time.Minute) }()
+
+	require.Eventually(t, func() bool { return f.Tickers() == 1 }, time.Second, time.Millisecond)
+	f.Advance(59 * time.Second)
+	select {
+	case <-done:
+		require.Fail(t, "sleep returned early")
+	default:
+	}
+	f.Advance(time.Second)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 0, f.Tickers())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, Sleep(ctx, f, time.Minute), context.Canceled)
+	assert.NoError(t, Sleep(ctx, f, 0))
+}
+
+func TestReal(t *testing.T) {
+	c := Real()
+	assert.WithinDuration(t, time.Now(), c.Now(), time.Second)
+
+	ticker := c.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		require.Fail(t, "real ticker did not fire")
+	}
+}
+
+func assertNoTick(t *testing.T, ticker Ticker) {
+	t.Helper()
+
+	select {
+	case tick := <-ticker.C():
+		assert.Fail(t, "unexpected tick", "at %s", tick)
+	default:
+	}
+}
@@ -0,0 +1,23 @@
+// Package deps checks the dependencies constructors are given.
+//
+// A nil pointer stored in an interface is not a nil interface, so
+// "dep == nil" lets a (*Storage)(nil) through, and the constructor
+// succeeds only for the first call on it to panic. Missing catches both.
+package deps
+
+import "reflect"
+
+// Missing reports whether v is nil, or holds a nil pointer, map, slice,
+// channel or func.
+func Missing(v any) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
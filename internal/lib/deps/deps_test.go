@@ -0,0 +1,33 @@
+package deps
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type saver interface{ Save() }
+
+type storage struct{}
+
+func (*storage) Save() {}
+
+func TestMissing(t *testing.T) {
+	var nilStorage *storage
+	var nilSaver saver = nilStorage
+	var nilFunc func()
+	var nilLog *slog.Logger
+
+	assert.True(t, Missing(nil))
+	assert.True(t, Missing(nilSaver), "a nil pointer in an interface")
+	assert.True(t, Missing(nilFunc))
+	assert.True(t, Missing(nilLog))
+	assert.True(t, Missing(map[string]int(nil)))
+
+	assert.False(t, Missing(saver(&storage{})))
+	assert.False(t, Missing(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	assert.False(t, Missing(storage{}), "a struct is never missing")
+	assert.False(t, Missing(0))
+}
@@ -0,0 +1,97 @@
+package emailaddr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule is how the local parts of the emails of a domain are put in their
+// canonical form, the one the addresses delivered to the same mailbox
+// share.
+type Rule struct {
+	// StripPlus drops the "+" suffix of the local part: name+x is name.
+	StripPlus bool
+	// IgnoreDots drops the dots of the local part: na.me is name.
+	IgnoreDots bool
+	// Domain, if not empty, replaces the domain: the mailboxes of
+	// googlemail.com are those of gmail.com.
+	Domain string
+}
+
+// KnownProviders are the rules of the mail providers known to deliver
+// plus-addressed mail, and for Gmail mail with dots, to the same mailbox.
+var KnownProviders = map[string]Rule{
+	"gmail.com":      {StripPlus: true, IgnoreDots: true},
+	"googlemail.com": {StripPlus: true, IgnoreDots: true, Domain: "gmail.com"},
+	"outlook.com":    {StripPlus: true},
+	"hotmail.com":    {StripPlus: true},
+	"live.com":       {StripPlus: true},
+	"icloud.com":     {StripPlus: true},
+	"proton.me":      {StripPlus: true},
+	"protonmail.com": {StripPlus: true},
+	"fastmail.com":   {StripPlus: true},
+	"yandex.ru":      {StripPlus: true},
+}
+
+// ParseRule parses a rule written as "plus", "dots", "plus,dots" or
+// "none".
+func ParseRule(s string) (Rule, error) {
+	var rule Rule
+	for _, part := range strings.Split(s, ",") {
+		switch strings.TrimSpace(part) {
+		case "plus":
+			rule.StripPlus = true
+		case "dots":
+			rule.IgnoreDots = true
+		case "none":
+		default:
+			return Rule{}, fmt.Errorf("invalid rule %q: want plus, dots or none", s)
+		}
+	}
+
+	return rule, nil
+}
+
+// Canonicalizer puts emails in their canonical form by the rule of their
+// domain.
+type Canonicalizer struct {
+	rules map[string]Rule
+}
+
+// NewCanonicalizer returns a canonicalizer of rules, keyed by domain in
+// lower case. Domains without a rule are only normalized.
+func NewCanonicalizer(rules map[string]Rule) *Canonicalizer {
+	return &Canonicalizer{rules: rules}
+}
+
+// Canonical returns the normalized email with the rule of its domain
+// applied. An email without "@" is only normalized.
+func (c *Canonicalizer) Canonical(email string) string {
+	email = Normalize(email)
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+	rule, ok := c.rules[domain]
+	if !ok {
+		return email
+	}
+	if rule.StripPlus {
+		// A local part starting with "+" is left whole.
+		if plus := strings.IndexByte(local, '+'); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if rule.IgnoreDots {
+		if stripped := strings.ReplaceAll(local, ".", ""); stripped != "" {
+			local = stripped
+		}
+	}
+	if rule.Domain != "" {
+		domain = rule.Domain
+	}
+
+	return local + "@" + domain
+}
@@ -0,0 +1,58 @@
+package emailaddr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonical(t *testing.T) {
+	c := NewCanonicalizer(KnownProviders)
+
+	tests := map[string]string{
+		"name@gmail.com":         "name@gmail.com",
+		"Na.Me@Gmail.com":        "name@gmail.com",
+		"name+trial2@gmail.com":  "name@gmail.com",
+		"n.a.m.e+x+y@gmail.com":  "name@gmail.com",
+		"name@googlemail.com":    "name@gmail.com",
+		"name+x@outlook.com":     "name@outlook.com",
+		"na.me@outlook.com":      "na.me@outlook.com",
+		"na.me+x@university.edu": "na.me+x@university.edu",
+		" Name+x@Hotmail.com ":   "name@hotmail.com",
+		"+name@gmail.com":        "+name@gmail.com",
+		"...@gmail.com":          "...@gmail.com",
+		"name":                   "name",
+		"name+x@sub.gmail.com":   "name+x@sub.gmail.com",
+	}
+	for email, want := range tests {
+		assert.Equal(t, want, c.Canonical(email), email)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	rule, err := ParseRule("plus, dots")
+	require.NoError(t, err)
+	assert.Equal(t, Rule{StripPlus: true, IgnoreDots: true}, rule)
+
+	rule, err = ParseRule("none")
+	require.NoError(t, err)
+	assert.Equal(t, Rule{}, rule)
+
+	_, err = ParseRule("plus,tags")
+	assert.Error(t, err)
+	_, err = ParseRule("")
+	assert.Error(t, err)
+}
+
+func FuzzCanonical(f *testing.F) {
+	for _, seed := range []string{"Na.Me+Trial@GoogleMail.com", "+x@gmail.com", ".+x@gmail.com", "name@example.com", "no-at-sign", "@"} {
+		f.Add(seed)
+	}
+	c := NewCanonicalizer(KnownProviders)
+
+	f.Fuzz(func(t *testing.T, email string) {
+		canonical := c.Canonical(email)
+		assert.Equal(t, canonical, c.Canonical(canonical), "canonicalizing twice changes %q", email)
+	})
+}
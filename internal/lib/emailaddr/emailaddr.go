@@ -0,0 +1,13 @@
+// Package emailaddr puts email addresses in the form they are stored in,
+// so that addresses differing only in case or surrounding space belong to
+// the same user.
+package emailaddr
+
+import "strings"
+
+// Normalize returns email without surrounding space and in lower case.
+// The local part is lowered too: no mail provider users register with
+// tells addresses apart by case.
+func Normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
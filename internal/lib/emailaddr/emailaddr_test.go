@@ -0,0 +1,26 @@
+package emailaddr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "john.doe@example.com", Normalize(" John.Doe@Example.COM\t"))
+	assert.Equal(t, "иван@пример.рф", Normalize("Иван@Пример.РФ"))
+	assert.Equal(t, "john@example.com", Normalize("john@example.com"))
+}
+
+func FuzzNormalize(f *testing.F) {
+	for _, seed := range []string{"", " John.Doe@Example.COM\t", "Иван@Пример.РФ", "\xff@example.com"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, email string) {
+		normalized := Normalize(email)
+		assert.Equal(t, normalized, Normalize(normalized), "normalizing twice changes %q", email)
+		assert.Equal(t, strings.TrimSpace(normalized), normalized)
+	})
+}
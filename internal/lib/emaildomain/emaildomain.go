@@ -0,0 +1,137 @@
+// Package emaildomain decides which email domains may be used to
+// register.
+//
+// A pattern is either a domain, matching exactly that domain, or a domain
+// prefixed with "*.", matching its subdomains at any depth but not the
+// domain itself:
+//
+//	university.edu      matches a@university.edu only
+//	*.university.edu    matches a@cs.university.edu, not a@university.edu
+//
+// Domains and patterns are compared in lower case punycode, so an IDN
+// matches whether it is written in Unicode or as xn-- labels.
+package emaildomain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const wildcardPrefix = "*."
+
+// Policy is a pair of allow and deny lists. A domain is allowed when it
+// matches no deny pattern and, if the allow list is not empty, some allow
+// pattern. The zero Policy allows everything.
+type Policy struct {
+	allow []pattern
+	deny  []pattern
+}
+
+type pattern struct {
+	domain string
+	// wildcard matches the subdomains of domain.
+	wildcard bool
+}
+
+// New returns the policy of the allow and deny patterns. A pattern that is
+// not a valid domain is an error.
+func New(allow, deny []string) (*Policy, error) {
+	p := &Policy{}
+
+	var err error
+	if p.allow, err = parsePatterns(allow); err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	if p.deny, err = parsePatterns(deny); err != nil {
+		return nil, fmt.Errorf("deny list: %w", err)
+	}
+
+	return p, nil
+}
+
+func parsePatterns(raw []string) ([]pattern, error) {
+	res := make([]pattern, 0, len(raw))
+	for _, r := range raw {
+		var pat pattern
+		r = strings.TrimSpace(r)
+		if rest, ok := strings.CutPrefix(r, wildcardPrefix); ok {
+			r, pat.wildcard = rest, true
+		}
+
+		domain, err := normalize(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", r, err)
+		}
+		pat.domain = domain
+		res = append(res, pat)
+	}
+
+	return res, nil
+}
+
+// Allowed reports whether the domain of email is allowed. An email whose
+// domain cannot be parsed is allowed only by a policy with no lists.
+func (p *Policy) Allowed(email string) bool {
+	if p == nil || len(p.allow) == 0 && len(p.deny) == 0 {
+		return true
+	}
+
+	domain, err := normalize(Of(email))
+	if err != nil {
+		return false
+	}
+
+	for _, pat := range p.deny {
+		if pat.matches(domain) {
+			return false
+		}
+	}
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, pat := range p.allow {
+		if pat.matches(domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Of returns the domain of email as written, or an empty string if email
+// has no "@".
+func Of(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+
+	return email[at+1:]
+}
+
+func (pat pattern) matches(domain string) bool {
+	if pat.wildcard {
+		return strings.HasSuffix(domain, "."+pat.domain)
+	}
+
+	return domain == pat.domain
+}
+
+// normalize returns the lower case punycode form of domain, without a
+// trailing dot.
+func normalize(domain string) (string, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return "", errors.New("empty domain")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(ascii), nil
+}
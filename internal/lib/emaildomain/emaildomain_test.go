@@ -0,0 +1,72 @@
+package emaildomain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Allowed(t *testing.T) {
+	p, err := New(
+		[]string{"university.edu", "*.university.edu", "bücher.example", "*.xn--80ak6aa92e.com"},
+		[]string{"*.spam.university.edu", "mailinator.com"},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{email: "john@university.edu", want: true},
+		{email: "john@cs.university.edu", want: true},
+		{email: "john@a.b.university.edu", want: true},
+		{email: "john@UNIVERSITY.EDU", want: true},
+		{email: "john@Cs.University.Edu.", want: true},
+		{email: "john@notuniversity.edu", want: false},
+		{email: "john@university.edu.evil.com", want: false},
+		{email: "john@x.spam.university.edu", want: false},
+		{email: "john@mailinator.com", want: false},
+		{email: "john@gmail.com", want: false},
+		// The same IDN in Unicode and in punycode.
+		{email: "john@bücher.example", want: true},
+		{email: "john@BÜCHER.example", want: true},
+		{email: "john@xn--bcher-kva.example", want: true},
+		{email: "john@mail.аррӏе.com", want: true},
+		{email: "john@аррӏе.com", want: false},
+		{email: "no-at-sign", want: false},
+		{email: "john@", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Allowed(tt.email))
+		})
+	}
+}
+
+func TestPolicy_DenyOnly(t *testing.T) {
+	p, err := New(nil, []string{"*.mailinator.com", "mailinator.com"})
+	require.NoError(t, err)
+
+	assert.True(t, p.Allowed("john@gmail.com"))
+	assert.False(t, p.Allowed("john@MAILINATOR.com"))
+	assert.False(t, p.Allowed("john@eu.mailinator.com"))
+}
+
+func TestPolicy_Empty(t *testing.T) {
+	var nilPolicy *Policy
+	assert.True(t, nilPolicy.Allowed("john@example.com"))
+
+	p, err := New(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, p.Allowed("not an email"))
+}
+
+func TestNew_InvalidPattern(t *testing.T) {
+	_, err := New([]string{""}, nil)
+	assert.Error(t, err)
+	_, err = New(nil, []string{"*."})
+	assert.Error(t, err)
+	_, err = New([]string{"bad domain.com"}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+// Package health tells apart a process that is alive from one that is
+// ready to serve. An orchestrator restarts the former and only routes
+// traffic to the latter, so waiting for the database does not get the
+// process killed.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"sso/internal/lib/clock"
+)
+
+// Liveness and Readiness are the service names of the two probes in the
+// gRPC health service.
+const (
+	Liveness  = "liveness"
+	Readiness = "readiness"
+)
+
+// Check is a readiness condition, run periodically by Probe.Run.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Probe keeps the liveness and readiness of the process.
+//
+// The process is live while the gRPC server is running. It is ready when
+// it is live, is not draining and every check passed within maxAge.
+type Probe struct {
+	log    *slog.Logger
+	clock  clock.Clock
+	maxAge time.Duration
+	checks []Check
+
+	mu       sync.Mutex
+	live     bool
+	draining bool
+	passed   map[string]time.Time
+	failures map[string]error
+	ready    bool
+	watchers []func(service string, serving bool)
+}
+
+// New returns a probe that is neither live nor ready. A check counts for
+// maxAge after it last passed. A nil log logs to slog.Default() and a nil
+// clk is the real clock.
+func New(log *slog.Logger, clk clock.Clock, maxAge time.Duration, checks ...Check) *Probe {
+	if log == nil {
+		log = slog.Default()
+	}
+	if clk == nil {
+		clk = clock.Real()
+	}
+
+	return &Probe{
+		log:      log.With(slog.String("component", "health")),
+		clock:    clk,
+		maxAge:   maxAge,
+		checks:   checks,
+		passed:   make(map[string]time.Time),
+		failures: make(map[string]error),
+	}
+}
+
+// Run runs every check once and updates the readiness. It returns the
+// failures, so it can be run as a job.
+func (p *Probe) Run(ctx context.Context) error {
+	var failed []error
+	for _, check := range p.checks {
+		err := check.Run(ctx)
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", check.Name, err))
+		}
+
+		p.mu.Lock()
+		if err == nil {
+			p.passed[check.Name] = p.clock.Now()
+			delete(p.failures, check.Name)
+		} else {
+			p.failures[check.Name] = err
+		}
+		p.mu.Unlock()
+	}
+
+	p.mu.Lock()
+	p.update()
+	p.mu.Unlock()
+
+	return errors.Join(failed...)
+}
+
+// SetLive marks the process live or not. Readiness depends on it.
+func (p *Probe) SetLive(live bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.live == live {
+		return
+	}
+	p.live = live
+	p.log.Info("liveness changed", slog.Bool("live", live))
+	p.notify(Liveness, live)
+	p.update()
+}
+
+// Drain makes the process not ready for good, so that traffic moves away
+// before the server stops.
+func (p *Probe) Drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.draining = true
+	p.update()
+}
+
+// Live reports whether the process is live.
+func (p *Probe) Live() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.live
+}
+
+// Ready reports whether the process is ready to serve.
+func (p *Probe) Ready() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.update()
+
+	return p.ready
+}
+
+// Watch calls fn with the current state of both probes and then on every
+// change. fn is called with the probe locked and must not call it back.
+func (p *Probe) Watch(fn func(service string, serving bool)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.watchers = append(p.watchers, fn)
+	fn(Liveness, p.live)
+	fn(Readiness, p.ready)
+}
+
+// Handler serves the state of service, Liveness or Readiness: 200 when
+// serving and 503 otherwise.
+func (p *Probe) Handler(service string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		serving := p.Live()
+		if service == Readiness {
+			serving = p.Ready()
+		}
+
+		if !serving {
+			http.Error(w, "not serving", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// update recomputes the readiness and reports a change. p.mu must be held.
+func (p *Probe) update() {
+	reason := p.notReadyReason()
+	ready := reason == ""
+	if ready == p.ready {
+		return
+	}
+	p.ready = ready
+
+	if ready {
+		p.log.Info("readiness changed", slog.Bool("ready", true))
+	} else {
+		p.log.Warn("readiness changed", slog.Bool("ready", false), slog.String("reason", reason))
+	}
+	p.notify(Readiness, ready)
+}
+
+// notReadyReason returns why the process is not ready, or an empty string.
+func (p *Probe) notReadyReason() string {
+	switch {
+	case !p.live:
+		return "not live"
+	case p.draining:
+		return "draining"
+	}
+
+	now := p.clock.Now()
+	for _, check := range p.checks {
+		if err, ok := p.failures[check.Name]; ok {
+			return fmt.Sprintf("%s: %v", check.Name, err)
+		}
+		passed, ok := p.passed[check.Name]
+		if !ok {
+			return check.Name + ": not checked yet"
+		}
+		if now.Sub(passed) > p.maxAge {
+			return check.Name + ": last passed " + now.Sub(passed).Round(time.Second).String() + " ago"
+		}
+	}
+
+	return ""
+}
+
+func (p *Probe) notify(service string, serving bool) {
+	for _, fn := range p.watchers {
+		fn(service, serving)
+	}
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	fake := clock.NewFake(time.Now())
+
+	pingErr := errors.New("database is locked")
+	var storageErr error
+	p := New(slog.New(slog.NewTextHandler(&logs, nil)), fake, 15*time.Second,
+		Check{Name: "storage", Run: func(context.Context) error { return storageErr }},
+		Check{Name: "migrations", Run: func(context.Context) error { return nil }},
+	)
+
+	var changes []string
+	p.Watch(func(service string, serving bool) {
+		if serving {
+			changes = append(changes, service+" up")
+		} else {
+			changes = append(changes, service+" down")
+		}
+	})
+	assert.Equal(t, []string{"liveness down", "readiness down"}, changes)
+	changes = nil
+
+	// Checks pass, but a process that is not live is never ready.
+	require.NoError(t, p.Run(ctx))
+	assert.False(t, p.Ready())
+
+	p.SetLive(true)
+	assert.True(t, p.Live())
+	assert.True(t, p.Ready())
+	assert.Equal(t, []string{"liveness up", "readiness up"}, changes)
+
+	// A failing check takes readiness down, not liveness.
+	storageErr = pingErr
+	assert.ErrorIs(t, p.Run(ctx), pingErr)
+	assert.True(t, p.Live())
+	assert.False(t, p.Ready())
+	assert.Contains(t, logs.String(), "database is locked")
+
+	storageErr = nil
+	require.NoError(t, p.Run(ctx))
+	assert.True(t, p.Ready())
+
+	// A check that has not passed for too long no longer counts.
+	fake.Advance(16 * time.Second)
+	assert.False(t, p.Ready())
+	assert.Contains(t, logs.String(), "last passed 16s ago")
+
+	require.NoError(t, p.Run(ctx))
+	assert.True(t, p.Ready())
+
+	p.Drain()
+	assert.False(t, p.Ready())
+	require.NoError(t, p.Run(ctx))
+	assert.False(t, p.Ready(), "draining is final")
+	assert.True(t, p.Live())
+
+	assert.Equal(t, []string{
+		"liveness up", "readiness up",
+		"readiness down", "readiness up",
+		"readiness down", "readiness up",
+		"readiness down",
+	}, changes)
+}
+
+func TestHandler(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	p := New(slog.New(slog.DiscardHandler), fake, time.Minute,
+		Check{Name: "storage", Run: func(context.Context) error { return nil }},
+	)
+
+	code := func(service string) int {
+		rec := httptest.NewRecorder()
+		p.Handler(service).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	p.SetLive(true)
+	assert.Equal(t, http.StatusOK, code(Liveness))
+	assert.Equal(t, http.StatusServiceUnavailable, code(Readiness))
+
+	require.NoError(t, p.Run(context.Background()))
+	assert.Equal(t, http.StatusOK, code(Readiness))
+}
@@ -1,21 +1,180 @@
 package jwt
 
 import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"time"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func GenerateNewToken(user models.User, app models.App, duration time.Duration) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrNotYetValid   = errors.New("token not valid yet")
+	ErrWrongIssuer   = errors.New("token issued by a different issuer")
+	ErrWrongAudience = errors.New("token issued for a different audience")
+)
+
+// Issuer identifies this SSO in tokens.
+type Issuer struct {
+	// Name is stamped into the iss claim. Empty leaves tokens without iss
+	// and disables issuer checks.
+	Name string
+	// Accepted lists other issuers whose tokens are accepted too, e.g.
+	// while moving to a new issuer name.
+	Accepted []string
+	// AllowMissing accepts tokens without iss and aud, issued before they
+	// were stamped.
+	AllowMissing bool
+}
+
+// Audience returns the aud claim of tokens issued for the app.
+func Audience(appID int32) string {
+	return strconv.FormatInt(int64(appID), 10)
+}
+
+// Claims are the claims of a token issued by GenerateNewToken.
+type Claims struct {
+	UserID    int64
+	Email     string
+	AppID     int32
+	ExpiresAt time.Time
+	// IssuedAt is zero for tokens without an iat claim.
+	IssuedAt time.Time
+	// Elevated marks a short-lived step-up token, see Elevated.
+	Elevated bool
+	// Permissions are those of the user in the app, see Permissions.
+	Permissions []string
+	// PermissionsOmitted is set when they did not fit in the token.
+	PermissionsOmitted bool
+	// CertThumbprint is the client certificate the token is bound to,
+	// see CertBinding. Empty for unbound tokens.
+	CertThumbprint string
+	// ID is the jti claim, empty for tokens issued without one.
+	ID string
+	// Raw holds every claim as decoded from the token.
+	Raw map[string]any
+}
+
+// HasPermission reports whether the token grants permission. A token
+// whose permissions were omitted grants none: ask the issuer for them.
+func (c Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
+
+// TokenOption adds claims to a generated token.
+type TokenOption func(claims jwt.MapClaims)
+
+// Elevated marks the token as a step-up token for destructive admin
+// operations.
+func Elevated() TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["elevated"] = true
+	}
+}
+
+// CertBinding binds the token to the client certificate of thumbprint,
+// see clientcert.Thumbprint, in the cnf claim of RFC 8705. Verifiers must
+// refuse it over connections without that certificate.
+func CertBinding(thumbprint string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["cnf"] = map[string]any{"x5t#S256": thumbprint}
+	}
+}
+
+// ID sets the jti claim, which tells the token apart from every other.
+func ID(jti string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["jti"] = jti
+	}
+}
+
+// TTLSource records where the lifetime of the token came from, for
+// debugging.
+func TTLSource(source string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["ttl_src"] = source
+	}
+}
+
+// TermsReacceptRequired tells the client the user has to accept the
+// current terms of service again.
+func TermsReacceptRequired() TokenOption {
+	return func(claims jwt.MapClaims) {
+		claims["tos_reaccept_required"] = true
+	}
+}
+
+// MaxPermissionsSize bounds the encoded size of the perms claim, so tokens
+// still fit in request headers.
+const MaxPermissionsSize = 2048
+
+// Permissions lists the permissions of the user in the app in the perms
+// claim. If they take more than MaxPermissionsSize, perms_omitted is set
+// instead.
+func Permissions(permissions []string) TokenOption {
+	return func(claims jwt.MapClaims) {
+		size := len(`"perms":[]`)
+		for _, p := range permissions {
+			// Quoted, with a comma.
+			size += len(p) + 3
+		}
+		if size > MaxPermissionsSize {
+			claims["perms_omitted"] = true
+
+			return
+		}
+
+		claims["perms"] = permissions
+	}
+}
+
+// IssuedAt issues the token at now instead of the current time of the
+// clock, keeping its lifetime.
+func IssuedAt(now time.Time) TokenOption {
+	return func(claims jwt.MapClaims) {
+		ttl := claims["exp"].(int64) - claims["iat"].(int64)
+
+		claims["iat"] = now.Unix()
+		claims["nbf"] = now.Unix()
+		claims["exp"] = now.Unix() + ttl
+	}
+}
+
+// FingerprintSize is the size of a token fingerprint in bytes, before
+// hex encoding.
+const FingerprintSize = 16
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
-	claims["app_id"] = app.ID
+// Fingerprint returns the truncated SHA-256 of tokenString, hex encoded.
+// It identifies the token without revealing it, so it can be stored and
+// logged where the token must not be.
+func Fingerprint(tokenString string) string {
+	sum := sha256.Sum256([]byte(tokenString))
+
+	return hex.EncodeToString(sum[:FingerprintSize])
+}
+
+// GenerateNewToken returns an HS256 token signed with the app secret,
+// issued at the current time of clk. Nil means the system clock.
+func GenerateNewToken(
+	clk clock.Clock,
+	user models.User,
+	app models.App,
+	duration time.Duration,
+	issuer string,
+	opts ...TokenOption,
+) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims(clk, user, app, duration, issuer, opts))
 
 	tokenString, err := token.SignedString([]byte(app.Secret))
 	if err != nil {
@@ -24,3 +183,299 @@ func GenerateNewToken(user models.User, app models.App, duration time.Duration)
 
 	return tokenString, nil
 }
+
+// GenerateNewRS256Token returns an RS256 token signed with key. Anyone can
+// verify it with the public keys published in the JWKS. Like
+// GenerateNewToken, it is issued at the current time of clk.
+func GenerateNewRS256Token(
+	clk clock.Clock,
+	user models.User,
+	app models.App,
+	duration time.Duration,
+	issuer string,
+	key Key,
+	opts ...TokenOption,
+) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, newClaims(clk, user, app, duration, issuer, opts))
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+func newClaims(
+	clk clock.Clock,
+	user models.User,
+	app models.App,
+	duration time.Duration,
+	issuer string,
+	opts []TokenOption,
+) jwt.MapClaims {
+	if clk == nil {
+		clk = clock.Real()
+	}
+	now := clk.Now()
+
+	claims := jwt.MapClaims{
+		"uid":    user.ID,
+		"email":  user.Email,
+		"iat":    now.Unix(),
+		"nbf":    now.Unix(),
+		"exp":    now.Add(duration).Unix(),
+		"app_id": app.ID,
+		"aud":    Audience(app.ID),
+	}
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+	for _, opt := range opts {
+		opt(claims)
+	}
+
+	return claims
+}
+
+// ParseOptions tells ParseToken how to find the verification key.
+type ParseOptions struct {
+	// AppSecret returns the secret of the app an HS256 token was issued
+	// for. Its errors are returned as is, so it decides whether an unknown
+	// app makes the token invalid. Nil rejects HS256 tokens.
+	AppSecret func(appID int32) (string, error)
+	// Keys verifies RS256 tokens. Nil rejects them, unless PublicKey is
+	// set.
+	Keys *KeySet
+	// PublicKey returns the RS256 verification key of the kid, when Keys
+	// is nil: for verifiers holding public keys only, such as those of a
+	// JWKS.
+	PublicKey func(kid string) (*rsa.PublicKey, bool)
+	// Issuer is the iss policy.
+	Issuer Issuer
+	// AppID, when set, is the app the token must be for: its app_id must
+	// be AppID and, unless Audience is set, aud must name it.
+	AppID int32
+	// Audience, when set, must be in aud. Without it and AppID, any
+	// audience is accepted, as long as there is one.
+	Audience string
+	// Leeway is the clock skew tolerated in exp and nbf checks.
+	Leeway time.Duration
+	// Clock tells the current time. Nil means the system clock.
+	Clock clock.Clock
+}
+
+// ParseToken verifies tokenString and returns its claims.
+//
+// HS256 tokens are signed with the secret of the app they were issued for,
+// so opts.AppSecret is asked for the secret matching the (not yet trusted)
+// app_id claim before the signature is checked. RS256 tokens are checked
+// against the key named by their kid header.
+func ParseToken(tokenString string, opts ParseOptions) (Claims, error) {
+	var secretErr error
+
+	// Time-based claims are checked by verifyTimes, which unlike the
+	// library supports leeway.
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			kid, _ := token.Header["kid"].(string)
+			publicKey := opts.PublicKey
+			if opts.Keys != nil {
+				publicKey = opts.Keys.PublicKey
+			}
+			if publicKey == nil {
+				return nil, errors.New("rs256 tokens are not accepted")
+			}
+			key, ok := publicKey(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown key %q", kid)
+			}
+
+			return key, nil
+		case *jwt.SigningMethodHMAC:
+			if opts.AppSecret == nil {
+				return nil, errors.New("hs256 tokens are not accepted")
+			}
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, errors.New("unexpected claims type")
+		}
+		appID, ok := appIDClaim(claims)
+		if !ok {
+			return nil, errors.New("app_id claim is missing or invalid")
+		}
+
+		secret, err := opts.AppSecret(appID)
+		if err != nil {
+			secretErr = err
+
+			return nil, err
+		}
+
+		return []byte(secret), nil
+	})
+	if secretErr != nil {
+		return Claims{}, secretErr
+	}
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	mapClaims := token.Claims.(jwt.MapClaims)
+
+	appID, ok := appIDClaim(mapClaims)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: app_id claim is missing or invalid", ErrInvalidToken)
+	}
+
+	if err := verifyTimes(mapClaims, opts); err != nil {
+		return Claims{}, err
+	}
+
+	if err := verifyIssuer(mapClaims, opts.Issuer); err != nil {
+		return Claims{}, err
+	}
+	if err := verifyAudience(mapClaims, opts); err != nil {
+		return Claims{}, err
+	}
+
+	uid, _ := mapClaims["uid"].(float64)
+	email, _ := mapClaims["email"].(string)
+	exp, _ := mapClaims["exp"].(float64)
+	elevated, _ := mapClaims["elevated"].(bool)
+	jti, _ := mapClaims["jti"].(string)
+	permsOmitted, _ := mapClaims["perms_omitted"].(bool)
+
+	var permissions []string
+	if perms, ok := mapClaims["perms"].([]any); ok {
+		for _, p := range perms {
+			if s, ok := p.(string); ok {
+				permissions = append(permissions, s)
+			}
+		}
+	}
+
+	var thumbprint string
+	if cnf, ok := mapClaims["cnf"].(map[string]any); ok {
+		thumbprint, _ = cnf["x5t#S256"].(string)
+	}
+
+	var issuedAt time.Time
+	if iat, ok := mapClaims["iat"].(float64); ok {
+		issuedAt = time.Unix(int64(iat), 0)
+	}
+
+	return Claims{
+		UserID:    int64(uid),
+		Email:     email,
+		AppID:     appID,
+		ExpiresAt: time.Unix(int64(exp), 0),
+		IssuedAt:  issuedAt,
+		Elevated:  elevated,
+		ID:        jti,
+		Raw:       mapClaims,
+
+		Permissions:        permissions,
+		PermissionsOmitted: permsOmitted,
+		CertThumbprint:     thumbprint,
+	}, nil
+}
+
+// appIDClaim returns the app_id claim, which JSON decodes as a float64.
+// ok is false unless it is a whole number of the range of app IDs.
+func appIDClaim(claims jwt.MapClaims) (appID int32, ok bool) {
+	v, ok := claims["app_id"].(float64)
+	if !ok || v != math.Trunc(v) || v < 1 || v > math.MaxInt32 {
+		return 0, false
+	}
+
+	return int32(v), true
+}
+
+func verifyTimes(claims jwt.MapClaims, opts ParseOptions) error {
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.Real()
+	}
+	now := clk.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: exp claim is missing", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(opts.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return ErrNotYetValid
+	}
+
+	return nil
+}
+
+func verifyIssuer(claims jwt.MapClaims, issuer Issuer) error {
+	if issuer.Name == "" {
+		return nil
+	}
+
+	iss, ok := claims["iss"].(string)
+	if !ok {
+		if issuer.AllowMissing {
+			return nil
+		}
+
+		return fmt.Errorf("%w: iss claim is missing", ErrWrongIssuer)
+	}
+	if iss != issuer.Name && !slices.Contains(issuer.Accepted, iss) {
+		return fmt.Errorf("%w: %q", ErrWrongIssuer, iss)
+	}
+
+	return nil
+}
+
+func verifyAudience(claims jwt.MapClaims, opts ParseOptions) error {
+	if opts.AppID != 0 {
+		if appID, _ := appIDClaim(claims); appID != opts.AppID {
+			return fmt.Errorf("%w: issued for app %d, want %d", ErrWrongAudience, appID, opts.AppID)
+		}
+	}
+
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+
+	if len(aud) == 0 {
+		if opts.Issuer.AllowMissing {
+			return nil
+		}
+
+		return fmt.Errorf("%w: aud claim is missing", ErrWrongAudience)
+	}
+
+	want := opts.Audience
+	if want == "" && opts.AppID != 0 {
+		want = Audience(opts.AppID)
+	}
+	if want != "" && !slices.Contains(aud, want) {
+		return fmt.Errorf("%w: want %q", ErrWrongAudience, want)
+	}
+
+	return nil
+}
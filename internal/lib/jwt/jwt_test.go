@@ -0,0 +1,309 @@
+package jwt
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret"
+
+var (
+	testUser = models.User{ID: 42, Email: "user@example.com"}
+	testApp  = models.App{ID: 1, Secret: testSecret}
+)
+
+func parseOptions(issuer Issuer) ParseOptions {
+	return ParseOptions{
+		AppSecret: func(int32) (string, error) { return testSecret, nil },
+		Issuer:    issuer,
+	}
+}
+
+// legacyToken is a token as issued before iss and aud were stamped.
+func legacyToken(t *testing.T) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid":    testUser.ID,
+		"email":  testUser.Email,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"app_id": testApp.ID,
+	}).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestParseToken_Issuer(t *testing.T) {
+	prod, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "https://sso.example.com")
+	require.NoError(t, err)
+	staging, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "https://sso.staging.example.com")
+	require.NoError(t, err)
+	old, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "https://old-sso.example.com")
+	require.NoError(t, err)
+
+	issuer := Issuer{Name: "https://sso.example.com", Accepted: []string{"https://old-sso.example.com"}}
+
+	tests := []struct {
+		name    string
+		token   string
+		issuer  Issuer
+		wantErr error
+	}{
+		{name: "own issuer", token: prod, issuer: issuer},
+		{name: "accepted issuer", token: old, issuer: issuer},
+		{name: "other issuer", token: staging, issuer: issuer, wantErr: ErrWrongIssuer},
+		{name: "missing issuer", token: legacyToken(t), issuer: issuer, wantErr: ErrWrongIssuer},
+		{
+			name:   "missing issuer with grace",
+			token:  legacyToken(t),
+			issuer: Issuer{Name: issuer.Name, AllowMissing: true},
+		},
+		{name: "no issuer configured", token: staging},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseToken(tt.token, parseOptions(tt.issuer))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseToken_Audience(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.Equal(t, "1", claims.Raw["aud"])
+
+	opts := parseOptions(Issuer{})
+	opts.Audience = Audience(testApp.ID)
+	_, err = ParseToken(token, opts)
+	assert.NoError(t, err)
+
+	opts.Audience = Audience(2)
+	_, err = ParseToken(token, opts)
+	assert.ErrorIs(t, err, ErrWrongAudience)
+
+	_, err = ParseToken(legacyToken(t), parseOptions(Issuer{}))
+	assert.ErrorIs(t, err, ErrWrongAudience)
+	_, err = ParseToken(legacyToken(t), parseOptions(Issuer{AllowMissing: true}))
+	assert.NoError(t, err)
+}
+
+func TestParseToken_AppID(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+	// A token naming another app in aud than in app_id, as the secret of
+	// the app_id app can sign.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid":    testUser.ID,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"app_id": 1,
+		"aud":    []string{"2"},
+	}).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		appID   int32
+		issuer  Issuer
+		wantErr error
+	}{
+		{name: "own app", token: token, appID: testApp.ID},
+		{name: "other app", token: token, appID: 2, wantErr: ErrWrongAudience},
+		{name: "no app required", token: token},
+		{name: "aud of another app", token: forged, appID: testApp.ID, wantErr: ErrWrongAudience},
+		{name: "app_id of another app", token: forged, appID: 2, wantErr: ErrWrongAudience},
+		{
+			name:    "legacy token of another app",
+			token:   legacyToken(t),
+			appID:   2,
+			issuer:  Issuer{AllowMissing: true},
+			wantErr: ErrWrongAudience,
+		},
+		{name: "legacy token of own app", token: legacyToken(t), appID: testApp.ID, issuer: Issuer{AllowMissing: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := parseOptions(tt.issuer)
+			opts.AppID = tt.appID
+			_, err := ParseToken(tt.token, opts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseToken_AppIDBounds(t *testing.T) {
+	sign := func(appID any) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"uid":    testUser.ID,
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"app_id": appID,
+		}).SignedString([]byte(testSecret))
+		require.NoError(t, err)
+
+		return token
+	}
+	opts := parseOptions(Issuer{AllowMissing: true})
+
+	for _, appID := range []any{-1, 0, int64(math.MaxInt32) + 1, 1.5, "1"} {
+		_, err := ParseToken(sign(appID), opts)
+		assert.ErrorIs(t, err, ErrInvalidToken, "app_id %v", appID)
+	}
+
+	claims, err := ParseToken(sign(math.MaxInt32), opts)
+	require.NoError(t, err)
+	assert.Equal(t, int32(math.MaxInt32), claims.AppID)
+}
+
+func TestGenerateNewToken_Clock(t *testing.T) {
+	issuedAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(issuedAt)
+
+	token, err := GenerateNewToken(clk, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+
+	opts := parseOptions(Issuer{})
+	opts.Clock = clk
+	claims, err := ParseToken(token, opts)
+	require.NoError(t, err)
+	assert.Equal(t, issuedAt, claims.IssuedAt.UTC())
+	assert.Equal(t, issuedAt.Add(time.Hour), claims.ExpiresAt.UTC())
+}
+
+func TestIssuedAt(t *testing.T) {
+	issuedAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "", IssuedAt(issuedAt))
+	require.NoError(t, err)
+
+	opts := parseOptions(Issuer{})
+	opts.Clock = clock.NewFake(issuedAt.Add(time.Minute))
+	claims, err := ParseToken(token, opts)
+	require.NoError(t, err)
+	assert.Equal(t, issuedAt.Add(time.Hour), claims.ExpiresAt.UTC())
+	assert.Equal(t, float64(issuedAt.Unix()), claims.Raw["iat"])
+
+	_, err = ParseToken(token, parseOptions(Issuer{}))
+	assert.ErrorIs(t, err, ErrTokenExpired, "issued in the past")
+}
+
+func TestPermissions(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "", Permissions([]string{"can_grade", "can_submit"}))
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.True(t, claims.HasPermission("can_grade"))
+	assert.False(t, claims.HasPermission("can_manage_enrollments"))
+	assert.False(t, claims.PermissionsOmitted)
+
+	// Too many to carry.
+	many := make([]string, MaxPermissionsSize/10)
+	for i := range many {
+		many[i] = fmt.Sprintf("perm_%04d", i)
+	}
+	token, err = GenerateNewToken(nil, testUser, testApp, time.Hour, "", Permissions(many))
+	require.NoError(t, err)
+
+	claims, err = ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.True(t, claims.PermissionsOmitted)
+	assert.False(t, claims.HasPermission(many[0]))
+	assert.NotContains(t, claims.Raw, "perms")
+}
+
+func TestCertBinding(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "", CertBinding("thumbprint"))
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.Equal(t, "thumbprint", claims.CertThumbprint)
+	assert.Equal(t, map[string]any{"x5t#S256": "thumbprint"}, claims.Raw["cnf"])
+
+	token, err = GenerateNewToken(nil, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+	claims, err = ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.Empty(t, claims.CertThumbprint)
+}
+
+func TestID(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "", ID("jti-1"))
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.Equal(t, "jti-1", claims.ID)
+}
+
+func TestFingerprint(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+
+	fp := Fingerprint(token)
+	assert.Len(t, fp, 2*FingerprintSize)
+	assert.Equal(t, fp, Fingerprint(token))
+	assert.NotEqual(t, fp, Fingerprint(token+"x"))
+}
+
+func TestParseToken_Leeway(t *testing.T) {
+	token, err := GenerateNewToken(nil, testUser, testApp, time.Hour, "")
+	require.NoError(t, err)
+
+	claims, err := ParseToken(token, parseOptions(Issuer{}))
+	require.NoError(t, err)
+	assert.Equal(t, claims.Raw["iat"], claims.Raw["nbf"], "nbf is stamped as iat")
+
+	issuedAt := time.Unix(int64(claims.Raw["nbf"].(float64)), 0)
+	const leeway = 30 * time.Second
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		leeway  time.Duration
+		wantErr error
+	}{
+		{name: "just expired within leeway", now: claims.ExpiresAt.Add(leeway - time.Second), leeway: leeway},
+		{name: "expired beyond leeway", now: claims.ExpiresAt.Add(leeway + time.Second), leeway: leeway, wantErr: ErrTokenExpired},
+		{name: "just expired without leeway", now: claims.ExpiresAt.Add(time.Second), wantErr: ErrTokenExpired},
+		{name: "just before nbf within leeway", now: issuedAt.Add(-leeway + time.Second), leeway: leeway},
+		{name: "before nbf beyond leeway", now: issuedAt.Add(-leeway - time.Second), leeway: leeway, wantErr: ErrNotYetValid},
+		{name: "just before nbf without leeway", now: issuedAt.Add(-time.Second), wantErr: ErrNotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := parseOptions(Issuer{})
+			opts.Leeway = tt.leeway
+			opts.Clock = clock.NewFake(tt.now)
+
+			_, err := ParseToken(token, opts)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"sync"
+)
+
+// Key is an RS256 signing key. ID is its RFC 7638 thumbprint, used as
+// the kid header of signed tokens.
+type Key struct {
+	ID      string
+	Private *rsa.PrivateKey
+}
+
+// NewKey returns the key with its ID computed.
+func NewKey(private *rsa.PrivateKey) Key {
+	return Key{ID: Thumbprint(&private.PublicKey), Private: private}
+}
+
+// KeySet holds the current signing key and the previous one, which is
+// still accepted so tokens signed before a rotation stay valid. It is safe
+// for concurrent use and is swapped in place on rotation.
+type KeySet struct {
+	mu       sync.RWMutex
+	current  Key
+	previous Key
+}
+
+// NewKeySet returns an empty key set. Signing with it fails until Set is
+// called.
+func NewKeySet() *KeySet {
+	return &KeySet{}
+}
+
+// Set replaces the keys. previous may be the zero Key.
+func (ks *KeySet) Set(current, previous Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.current = current
+	ks.previous = previous
+}
+
+// Current returns the key new tokens are signed with. ok is false when
+// the set is empty.
+func (ks *KeySet) Current() (key Key, ok bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.current, ks.current.Private != nil
+}
+
+// PublicKey returns the public key with the given ID.
+func (ks *KeySet) PublicKey(id string) (*rsa.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for _, key := range []Key{ks.current, ks.previous} {
+		if key.Private != nil && key.ID == id {
+			return &key.Private.PublicKey, true
+		}
+	}
+
+	return nil, false
+}
+
+// Keys returns the published keys, current first.
+func (ks *KeySet) Keys() []Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var keys []Key
+	for _, key := range []Key{ks.current, ks.previous} {
+		if key.Private != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Thumbprint returns the RFC 7638 JWK thumbprint of the key.
+func Thumbprint(pub *rsa.PublicKey) string {
+	// The members must be in lexicographic order with no whitespace,
+	// which is exactly how encoding/json marshals a struct declared so.
+	b, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   EncodeExponent(pub.E),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+	})
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// EncodeExponent encodes an RSA public exponent as a JWK "e" member.
+func EncodeExponent(e int) string {
+	return base64.RawURLEncoding.EncodeToString(big.NewInt(int64(e)).Bytes())
+}
@@ -0,0 +1,22 @@
+package metrics
+
+import "sync/atomic"
+
+// Counter is a value that only goes up.
+type Counter struct {
+	Name string
+
+	v atomic.Int64
+}
+
+// NewCounter returns a counter starting at zero.
+func NewCounter(name string) *Counter {
+	return &Counter{Name: name}
+}
+
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Add adds n, which must not be negative.
+func (c *Counter) Add(n int64) { c.v.Add(n) }
+
+func (c *Counter) Value() int64 { return c.v.Load() }
@@ -0,0 +1,23 @@
+package metrics
+
+import "sync/atomic"
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	Name string
+
+	v atomic.Int64
+}
+
+// NewGauge returns a gauge starting at zero.
+func NewGauge(name string) *Gauge {
+	return &Gauge{Name: name}
+}
+
+func (g *Gauge) Inc() { g.v.Add(1) }
+
+func (g *Gauge) Dec() { g.v.Add(-1) }
+
+func (g *Gauge) Set(v int64) { g.v.Store(v) }
+
+func (g *Gauge) Value() int64 { return g.v.Load() }
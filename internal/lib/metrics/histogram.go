@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+var (
+	// DefaultBuckets are upper bounds in seconds suited for request and
+	// query latencies.
+	DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+	// HashingBuckets are log-spaced upper bounds in seconds from 1ms to
+	// 2s, so that bcrypt costs a step apart land in buckets of their own.
+	HashingBuckets = []float64{0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2}
+	// StorageBuckets are log-spaced upper bounds in seconds from 0.1ms to
+	// 100ms, so that hits of the page cache are told apart.
+	StorageBuckets = []float64{0.0001, 0.0002, 0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1}
+)
+
+// ValidateBuckets reports whether buckets are usable upper bounds: at
+// least one, positive and increasing.
+func ValidateBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return errors.New("at least one bucket is required")
+	}
+	for i, upper := range buckets {
+		if upper <= 0 || i > 0 && upper <= buckets[i-1] {
+			return errors.New("buckets must be positive and increasing")
+		}
+	}
+
+	return nil
+}
+
+// Histogram is a concurrency-safe cumulative histogram.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+	// exemplars is nil unless kept, see HistogramVec.Exemplars.
+	exemplars []Exemplar
+}
+
+// Exemplar is an observation kept as an example of its bucket, with the
+// ID of the request it was made in.
+type Exemplar struct {
+	Value     float64
+	RequestID string
+}
+
+// HistogramSnapshot is a point-in-time copy of a Histogram.
+type HistogramSnapshot struct {
+	Buckets []float64
+	// Counts[i] is the number of observations less than or equal to Buckets[i].
+	Counts []uint64
+	Sum    float64
+	Count  uint64
+	// Exemplars[i] is the last observation with a request ID that
+	// Buckets[i] is the lowest bound of, if exemplars are kept.
+	Exemplars []Exemplar `json:",omitempty"`
+}
+
+// NewHistogram returns a histogram with the given bucket upper bounds.
+// Nil or empty buckets fall back to DefaultBuckets.
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	b := slices.Clone(buckets)
+	slices.Sort(b)
+
+	return &Histogram{
+		buckets: b,
+		counts:  make([]uint64, len(b)),
+	}
+}
+
+// Observe records a single value.
+func (h *Histogram) Observe(v float64) {
+	h.ObserveExemplar(v, "")
+}
+
+// ObserveExemplar records a single value made in the request of
+// requestID, which is kept as the exemplar of its bucket if the histogram
+// keeps them and requestID is not empty.
+func (h *Histogram) ObserveExemplar(v float64, requestID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	first := true
+	for i, upper := range h.buckets {
+		if v > upper {
+			continue
+		}
+		h.counts[i]++
+		if first && h.exemplars != nil && requestID != "" {
+			h.exemplars[i] = Exemplar{Value: v, RequestID: requestID}
+		}
+		first = false
+	}
+	h.sum += v
+	h.count++
+}
+
+// Snapshot returns a copy of the current state.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return HistogramSnapshot{
+		Buckets:   slices.Clone(h.buckets),
+		Counts:    slices.Clone(h.counts),
+		Sum:       h.sum,
+		Count:     h.count,
+		Exemplars: slices.Clone(h.exemplars),
+	}
+}
+
+// HistogramVec is a family of histograms partitioned by one label.
+type HistogramVec struct {
+	Name  string
+	Label string
+	// Exemplars makes the histograms keep an exemplar per bucket, see
+	// Histogram.ObserveExemplar. Set it before the first observation.
+	Exemplars bool
+
+	mu         sync.RWMutex
+	buckets    []float64
+	histograms map[string]*Histogram
+}
+
+// NewHistogramVec returns an empty histogram family.
+func NewHistogramVec(name, label string, buckets []float64) *HistogramVec {
+	return &HistogramVec{
+		Name:       name,
+		Label:      label,
+		buckets:    buckets,
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// With returns the histogram for the given label value, creating it on first use.
+func (v *HistogramVec) With(labelValue string) *Histogram {
+	v.mu.RLock()
+	h, ok := v.histograms[labelValue]
+	v.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if h, ok := v.histograms[labelValue]; ok {
+		return h
+	}
+	h = NewHistogram(v.buckets)
+	if v.Exemplars {
+		h.exemplars = make([]Exemplar, len(h.buckets))
+	}
+	v.histograms[labelValue] = h
+
+	return h
+}
+
+// SetBuckets replaces the bucket upper bounds of the family. Observations
+// made since the start cannot be sorted into other buckets, so every
+// histogram starts over; one held from With before keeps counting where
+// nobody looks.
+func (v *HistogramVec) SetBuckets(buckets []float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.buckets = slices.Clone(buckets)
+	clear(v.histograms)
+}
+
+// Snapshot returns copies of every histogram in the family keyed by label value.
+func (v *HistogramVec) Snapshot() map[string]HistogramSnapshot {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	res := make(map[string]HistogramSnapshot, len(v.histograms))
+	for label, h := range v.histograms {
+		res[label] = h.Snapshot()
+	}
+
+	return res
+}
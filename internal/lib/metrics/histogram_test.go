@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBuckets(t *testing.T) {
+	assert.NoError(t, ValidateBuckets(HashingBuckets))
+	assert.NoError(t, ValidateBuckets(StorageBuckets))
+	assert.NoError(t, ValidateBuckets(DefaultBuckets))
+
+	assert.Error(t, ValidateBuckets(nil))
+	assert.Error(t, ValidateBuckets([]float64{0, 1}))
+	assert.Error(t, ValidateBuckets([]float64{0.1, 0.1}))
+	assert.Error(t, ValidateBuckets([]float64{1, 0.5}))
+}
+
+func TestHistogramVec_Exemplars(t *testing.T) {
+	vec := NewHistogramVec("password_hash_duration_seconds", "op", []float64{0.01, 0.1, 1})
+	vec.Exemplars = true
+
+	h := vec.With("compare")
+	h.ObserveExemplar(0.05, "req-1")
+	h.ObserveExemplar(0.07, "")
+	h.ObserveExemplar(0.5, "req-2")
+	h.Observe(2)
+
+	snap := h.Snapshot()
+	assert.Equal(t, []uint64{0, 2, 3}, snap.Counts)
+	assert.Equal(t, uint64(4), snap.Count)
+	// Only the lowest bucket of an observation keeps it, and requests
+	// without an ID are not examples.
+	assert.Equal(t, []Exemplar{{}, {Value: 0.05, RequestID: "req-1"}, {Value: 0.5, RequestID: "req-2"}}, snap.Exemplars)
+
+	plain := NewHistogramVec("storage_query_duration_seconds", "op", nil).With("User")
+	plain.ObserveExemplar(0.05, "req-1")
+	assert.Nil(t, plain.Snapshot().Exemplars)
+}
+
+func TestHistogramVec_SetBuckets(t *testing.T) {
+	vec := NewHistogramVec("storage_query_duration_seconds", "op", StorageBuckets)
+	vec.With("User").Observe(0.0003)
+
+	vec.SetBuckets([]float64{0.001, 0.01})
+	vec.SetBuckets([]float64{0.001, 0.01})
+
+	assert.Empty(t, vec.Snapshot())
+	vec.With("User").Observe(0.0003)
+	snap := vec.Snapshot()["User"]
+	assert.Equal(t, []float64{0.001, 0.01}, snap.Buckets)
+	assert.Equal(t, []uint64{1, 1}, snap.Counts)
+}
@@ -0,0 +1,113 @@
+// Package nonce remembers one-time request nonces, so a replayed request
+// can be told apart from a fresh one.
+package nonce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"sso/internal/lib/clock"
+)
+
+var (
+	// ErrFull is returned by Memory.Use when every entry is still live.
+	// The nonce is refused rather than an entry dropped early, which
+	// would let its request be replayed.
+	ErrFull = errors.New("nonce store is full")
+	// ErrClientFull is returned by Memory.Use when the client holds as
+	// many live entries as it may, so that one client cannot fill the
+	// store for all.
+	ErrClientFull = errors.New("nonce store is full for the client")
+)
+
+// Memory keeps nonces in the memory of the instance, at most max of them
+// and perClient of each client, so it only catches replays sent to the
+// same instance. Expired nonces are evicted on every Use.
+type Memory struct {
+	mu        sync.Mutex
+	clock     clock.Clock
+	max       int
+	perClient int
+	seen      map[string]time.Time
+	// clients counts the live entries of each client.
+	clients map[string]int
+	// queue holds the nonces in the order they were used, which is the
+	// order they expire in as long as the ttl does not change.
+	queue []entry
+}
+
+type entry struct {
+	nonce   string
+	client  string
+	expires time.Time
+}
+
+// NewMemory returns an empty store of at most max nonces, perClient of
+// them by the same client.
+func NewMemory(max, perClient int, clk clock.Clock) *Memory {
+	return &Memory{
+		clock:     clk,
+		max:       max,
+		perClient: perClient,
+		seen:      make(map[string]time.Time),
+		clients:   make(map[string]int),
+	}
+}
+
+// Use records nonce, sent by client, for ttl. fresh is false if nonce
+// was already used within its ttl, by any client.
+func (m *Memory) Use(ctx context.Context, client, nonce string, ttl time.Duration) (fresh bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	m.evict(now)
+
+	if expires, ok := m.seen[nonce]; ok && now.Before(expires) {
+		return false, nil
+	}
+	if m.clients[client] >= m.perClient {
+		return false, ErrClientFull
+	}
+	if len(m.seen) >= m.max {
+		return false, ErrFull
+	}
+
+	expires := now.Add(ttl)
+	m.seen[nonce] = expires
+	m.clients[client]++
+	m.queue = append(m.queue, entry{nonce: nonce, client: client, expires: expires})
+
+	return true, nil
+}
+
+// Len returns the number of nonces held.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.seen)
+}
+
+// evict drops the expired nonces from the front of the queue.
+func (m *Memory) evict(now time.Time) {
+	n := 0
+	for ; n < len(m.queue) && !now.Before(m.queue[n].expires); n++ {
+		e := m.queue[n]
+		// A nonce used again after it expired has a later entry.
+		if m.seen[e.nonce].Equal(e.expires) {
+			delete(m.seen, e.nonce)
+		}
+		if m.clients[e.client]--; m.clients[e.client] <= 0 {
+			delete(m.clients, e.client)
+		}
+	}
+	clear(m.queue[:n])
+	m.queue = m.queue[n:]
+}
@@ -0,0 +1,103 @@
+package nonce
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_Use(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Now())
+	m := NewMemory(10, 10, clk)
+
+	fresh, err := m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	// Once expired the nonce is forgotten and may be used again.
+	clk.Advance(time.Minute)
+	fresh, err = m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestMemory_Bounded(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Now())
+	m := NewMemory(2, 10, clk)
+
+	for _, n := range []string{"a", "b"} {
+		_, err := m.Use(ctx, "10.0.0.1", n, time.Minute)
+		require.NoError(t, err)
+	}
+	_, err := m.Use(ctx, "10.0.0.1", "c", time.Minute)
+	assert.ErrorIs(t, err, ErrFull)
+	// A live nonce is not dropped to make room.
+	fresh, err := m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	clk.Advance(time.Minute)
+	fresh, err = m.Use(ctx, "10.0.0.1", "c", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	assert.Equal(t, 1, m.Len(), "expired nonces are evicted")
+}
+
+func TestMemory_ReusedAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Now())
+	m := NewMemory(10, 10, clk)
+
+	_, err := m.Use(ctx, "10.0.0.1", "a", time.Second)
+	require.NoError(t, err)
+	clk.Advance(time.Second)
+	_, err = m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+
+	// The first, expired entry of "a" must not evict the second.
+	clk.Advance(time.Second)
+	fresh, err := m.Use(ctx, "10.0.0.1", "a", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+}
+
+func TestMemory_PerClient(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Now())
+	m := NewMemory(100, 3, clk)
+
+	// A client flooding the store only uses up its own share.
+	for i := range 3 {
+		fresh, err := m.Use(ctx, "10.0.0.1", fmt.Sprintf("flood-%d", i), time.Minute)
+		require.NoError(t, err)
+		assert.True(t, fresh)
+	}
+	_, err := m.Use(ctx, "10.0.0.1", "flood-3", time.Minute)
+	assert.ErrorIs(t, err, ErrClientFull)
+	fresh, err := m.Use(ctx, "10.0.0.2", "n1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	// Nonces are not per client: a replay from elsewhere is caught.
+	fresh, err = m.Use(ctx, "10.0.0.2", "flood-0", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	clk.Advance(time.Minute)
+	fresh, err = m.Use(ctx, "10.0.0.1", "flood-3", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh, "the share is freed as the nonces expire")
+}
@@ -0,0 +1,213 @@
+// Package redact masks sensitive fields of protobuf messages before they
+// are logged.
+//
+// Fields are matched by name on the message descriptors, so messages
+// added later are covered without registering anything. A field is
+// sensitive when its name contains "password", "passwd", "secret" or
+// "token", or has "pass" as one of its underscore-separated words, e.g.
+// pass_hash. Matching errs on the side of masking too much.
+//
+// Keys of a google.protobuf.Struct are judged like field names. A
+// google.protobuf.StringValue or BytesValue message passed to Message has
+// no field name to judge by and is masked as a whole.
+package redact
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Mask replaces the value of sensitive string and bytes fields.
+const Mask = "***"
+
+var (
+	sensitiveParts = []string{"password", "passwd", "secret", "token"}
+	sensitiveWords = []string{"pass"}
+)
+
+// Sensitive reports whether a field named name is masked.
+func Sensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	for word := range strings.SplitSeq(name, "_") {
+		for _, w := range sensitiveWords {
+			if word == w {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Message returns a copy of m with every sensitive field masked, at any
+// depth. m itself is left untouched.
+//
+// Sensitive string and bytes fields, and lists and map values of them,
+// become Mask; sensitive fields of any other kind are cleared. Unknown
+// fields are dropped, and a google.protobuf.Any whose type is not linked
+// in is cleared, since neither can be inspected.
+func Message(m proto.Message) proto.Message {
+	if m == nil {
+		return nil
+	}
+
+	clone := proto.Clone(m)
+	rm := clone.ProtoReflect()
+	switch rm.Descriptor().FullName() {
+	case "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		fd := rm.Descriptor().Fields().ByName("value")
+		mask(rm, fd, rm.Get(fd))
+		rm.SetUnknown(nil)
+	default:
+		redact(rm)
+	}
+
+	return clone
+}
+
+func redact(m protoreflect.Message) {
+	m.SetUnknown(nil)
+
+	switch m.Descriptor().FullName() {
+	case "google.protobuf.Any":
+		redactAny(m)
+		return
+	case "google.protobuf.Struct":
+		redactStruct(m)
+		return
+	}
+
+	// Fields are collected first: the message must not be modified while
+	// ranging over it.
+	for _, fd := range populated(m) {
+		v := m.Get(fd)
+		if Sensitive(string(fd.Name())) {
+			mask(m, fd, v)
+			continue
+		}
+
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := range list.Len() {
+				redact(list.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			for _, k := range mapKeys(v.Map()) {
+				redact(v.Map().Get(k).Message())
+			}
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			redact(v.Message())
+		}
+	}
+}
+
+// mask masks the sensitive field fd of m holding v.
+func mask(m protoreflect.Message, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	kind := fd.Kind()
+	if fd.IsMap() {
+		kind = fd.MapValue().Kind()
+	}
+
+	var masked protoreflect.Value
+	switch kind {
+	case protoreflect.StringKind:
+		masked = protoreflect.ValueOfString(Mask)
+	case protoreflect.BytesKind:
+		masked = protoreflect.ValueOfBytes([]byte(Mask))
+	default:
+		m.Clear(fd)
+		return
+	}
+
+	switch {
+	case fd.IsList():
+		list := v.List()
+		for i := range list.Len() {
+			list.Set(i, masked)
+		}
+	case fd.IsMap():
+		for _, k := range mapKeys(v.Map()) {
+			v.Map().Set(k, masked)
+		}
+	default:
+		m.Set(fd, masked)
+	}
+}
+
+// redactAny redacts the message packed in the Any m, or clears m when the
+// type of that message is not linked in.
+func redactAny(m protoreflect.Message) {
+	fields := m.Descriptor().Fields()
+	typeURL := m.Get(fields.ByName("type_url")).String()
+	valueField := fields.ByName("value")
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+	if err != nil {
+		clearAll(m)
+		return
+	}
+	inner := mt.New()
+	if err := proto.Unmarshal(m.Get(valueField).Bytes(), inner.Interface()); err != nil {
+		clearAll(m)
+		return
+	}
+	redact(inner)
+
+	value, err := proto.Marshal(inner.Interface())
+	if err != nil {
+		clearAll(m)
+		return
+	}
+	m.Set(valueField, protoreflect.ValueOfBytes(value))
+}
+
+// redactStruct masks the values of sensitive keys of the Struct m and
+// redacts the others.
+func redactStruct(m protoreflect.Message) {
+	fields := m.Get(m.Descriptor().Fields().ByName("fields")).Map()
+	for _, k := range mapKeys(fields) {
+		if Sensitive(k.String()) {
+			masked := fields.NewValue()
+			vm := masked.Message()
+			vm.Set(vm.Descriptor().Fields().ByName("string_value"), protoreflect.ValueOfString(Mask))
+			fields.Set(k, masked)
+			continue
+		}
+		redact(fields.Get(k).Message())
+	}
+}
+
+func clearAll(m protoreflect.Message) {
+	for _, fd := range populated(m) {
+		m.Clear(fd)
+	}
+}
+
+func populated(m protoreflect.Message) []protoreflect.FieldDescriptor {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+
+	return fields
+}
+
+func mapKeys(m protoreflect.Map) []protoreflect.MapKey {
+	keys := make([]protoreflect.MapKey, 0, m.Len())
+	m.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+
+	return keys
+}
@@ -0,0 +1,237 @@
+package redact
+
+import (
+	"testing"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSensitive(t *testing.T) {
+	sensitive := []string{
+		"password", "new_password", "Password", "passwordConfirm", "passwd",
+		"pass_hash", "pass", "secret", "app_secret", "client_secret_hash",
+		"token", "refresh_token", "access_token", "tokens", "token_ttl",
+	}
+	for _, name := range sensitive {
+		assert.True(t, Sensitive(name), name)
+	}
+
+	safe := []string{"email", "first_name", "app_id", "user_id", "passport", "compass", "is_admin", "value", ""}
+	for _, name := range safe {
+		assert.False(t, Sensitive(name), name)
+	}
+}
+
+func TestMessage_Generated(t *testing.T) {
+	req := &ssov1.RegisterRequest{
+		Email:     "user@example.com",
+		Password:  "hunter2",
+		FirstName: "John",
+	}
+
+	redacted := Message(req).(*ssov1.RegisterRequest)
+	assert.Equal(t, Mask, redacted.GetPassword())
+	assert.Equal(t, "user@example.com", redacted.GetEmail())
+	assert.Equal(t, "John", redacted.GetFirstName())
+	assert.Equal(t, "hunter2", req.GetPassword(), "the original is untouched")
+
+	resp := Message(&ssov1.LoginResponse{Token: "eyJhbGciOi..."}).(*ssov1.LoginResponse)
+	assert.Equal(t, Mask, resp.GetToken())
+
+	// Empty fields stay empty rather than pretending there was a value.
+	empty := Message(&ssov1.LoginRequest{Email: "user@example.com"}).(*ssov1.LoginRequest)
+	assert.Empty(t, empty.GetPassword())
+
+	assert.Nil(t, Message(nil))
+}
+
+// testMessage builds a message type exercising every shape of field.
+func testMessage(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label *descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label,
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	const (
+		str   = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		bytes = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		i64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		msg   = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+
+	entry := func(name, valueType string, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{
+			Name: proto.String(name),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("key", 1, str, optional, ""),
+				field("value", 2, typ, optional, valueType),
+			},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("redact_test.proto"),
+		Package:    proto.String("redact.test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/any.proto", "google/protobuf/struct.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Inner"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, optional, ""),
+					field("secret", 2, str, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("Outer"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("email", 1, str, optional, ""),
+					field("password", 2, str, optional, ""),
+					field("pass_hash", 3, bytes, optional, ""),
+					field("token_ttl", 4, i64, optional, ""),
+					field("tokens", 5, str, repeated, ""),
+					field("inner", 6, msg, optional, ".redact.test.Inner"),
+					field("items", 7, msg, repeated, ".redact.test.Inner"),
+					field("by_name", 8, msg, repeated, ".redact.test.Outer.ByNameEntry"),
+					field("secrets", 9, msg, repeated, ".redact.test.Outer.SecretsEntry"),
+					field("payload", 10, msg, optional, ".google.protobuf.Any"),
+					field("claims", 11, msg, optional, ".google.protobuf.Struct"),
+					field("refresh_token", 12, msg, optional, ".redact.test.Inner"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					entry("ByNameEntry", ".redact.test.Inner", msg),
+					entry("SecretsEntry", "", str),
+				},
+			},
+		},
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+
+	return fd.Messages().ByName("Outer")
+}
+
+func TestMessage_Dynamic(t *testing.T) {
+	desc := testMessage(t)
+
+	const input = `{
+		"email": "user@example.com",
+		"password": "hunter2",
+		"pass_hash": "aGFzaA==",
+		"token_ttl": "3600",
+		"tokens": ["a", "b"],
+		"inner": {"name": "inner", "secret": "s1"},
+		"items": [{"name": "first", "secret": "s2"}, {"name": "second"}],
+		"by_name": {"x": {"name": "mapped", "secret": "s3"}},
+		"secrets": {"lms": "s4"},
+		"claims": {
+			"email": "user@example.com",
+			"token": "s5",
+			"nested": {"client_secret": "s6", "list": [{"password": "s7"}]}
+		},
+		"refresh_token": {"name": "hidden"}
+	}`
+	m := dynamicpb.NewMessage(desc)
+	require.NoError(t, protojson.Unmarshal([]byte(input), m))
+
+	out, err := protojson.Marshal(Message(m))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"email": "user@example.com",
+		"password": "***",
+		"pass_hash": "Kioq",
+		"tokens": ["***", "***"],
+		"inner": {"name": "inner", "secret": "***"},
+		"items": [{"name": "first", "secret": "***"}, {"name": "second"}],
+		"by_name": {"x": {"name": "mapped", "secret": "***"}},
+		"secrets": {"lms": "***"},
+		"claims": {
+			"email": "user@example.com",
+			"token": "***",
+			"nested": {"client_secret": "***", "list": [{"password": "***"}]}
+		}
+	}`, string(out))
+
+	// Nothing sensitive survives anywhere in the output.
+	for _, secret := range []string{"hunter2", "aGFzaA==", "3600", "s1", "s2", "s3", "s4", "s5", "s6", "s7", "hidden"} {
+		assert.NotContains(t, string(out), secret)
+	}
+}
+
+func TestMessage_Any(t *testing.T) {
+	desc := testMessage(t)
+
+	packed, err := anypb.New(&ssov1.LoginRequest{Email: "user@example.com", Password: "hunter2", AppId: 1})
+	require.NoError(t, err)
+
+	m := dynamicpb.NewMessage(desc)
+	m.Set(desc.Fields().ByName("payload"), protoreflect.ValueOfMessage(packed.ProtoReflect()))
+
+	// The field holds a dynamic Any; round trip it into the generated one.
+	raw, err := proto.Marshal(Message(m).ProtoReflect().Get(desc.Fields().ByName("payload")).Message().Interface())
+	require.NoError(t, err)
+	var payload anypb.Any
+	require.NoError(t, proto.Unmarshal(raw, &payload))
+
+	inner, err := payload.UnmarshalNew()
+	require.NoError(t, err)
+	login := inner.(*ssov1.LoginRequest)
+	assert.Equal(t, Mask, login.GetPassword())
+	assert.Equal(t, "user@example.com", login.GetEmail())
+
+	// A type that is not linked in cannot be inspected and is dropped.
+	unknown := &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Secret", Value: []byte("hunter2")}
+	redacted := Message(unknown).(*anypb.Any)
+	assert.Empty(t, redacted.GetTypeUrl())
+	assert.Empty(t, redacted.GetValue())
+}
+
+func TestMessage_UnknownFields(t *testing.T) {
+	req := &ssov1.LoginRequest{Email: "user@example.com"}
+	// Field 99, length-delimited "hunter2": a newer client's field.
+	req.ProtoReflect().SetUnknown(protoreflect.RawFields{0x9a, 0x06, 0x07, 'h', 'u', 'n', 't', 'e', 'r', '2'})
+
+	redacted := Message(req)
+	assert.Empty(t, redacted.ProtoReflect().GetUnknown())
+	assert.NotEmpty(t, req.ProtoReflect().GetUnknown(), "the original is untouched")
+}
+
+func TestMessage_TopLevelWrappers(t *testing.T) {
+	// Debug/WhoAmI takes the token as a bare StringValue.
+	assert.Equal(t, Mask, Message(wrapperspb.String("eyJhbGciOi...")).(*wrapperspb.StringValue).GetValue())
+	assert.Equal(t, []byte(Mask), Message(wrapperspb.Bytes([]byte("key"))).(*wrapperspb.BytesValue).GetValue())
+
+	claims, err := structpb.NewStruct(map[string]any{"uid": 1, "email": "user@example.com", "password": "hunter2"})
+	require.NoError(t, err)
+	redacted := Message(claims).(*structpb.Struct).AsMap()
+	assert.Equal(t, Mask, redacted["password"])
+	assert.Equal(t, "user@example.com", redacted["email"])
+	assert.Equal(t, float64(1), redacted["uid"])
+}
@@ -0,0 +1,65 @@
+// Package reqcache memoizes storage reads for the length of one request,
+// carried in the context, so that the lookups an RPC repeats, such as the
+// role the interceptor checked and the handler reads again, hit memory.
+// The cache goes away with the context.
+package reqcache
+
+import (
+	"context"
+	"sync"
+)
+
+type ctxKey struct{}
+
+// cache is the memo of one request. Its map is made on the first result
+// kept: requests reading nothing twice allocate nothing else.
+type cache struct {
+	mu    sync.Mutex
+	items map[any]any
+}
+
+// NewContext returns a copy of ctx carrying an empty cache, shared by
+// every call made with it or a context derived from it.
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &cache{})
+}
+
+// Load returns the value kept for key in the cache of ctx. On a miss it
+// calls fetch and keeps what it returns, unless it fails. Without a cache
+// every call fetches. Concurrent misses may fetch more than once.
+func Load[K comparable, V any](ctx context.Context, key K, fetch func() (V, error)) (V, error) {
+	c, ok := ctx.Value(ctxKey{}).(*cache)
+	if !ok {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	v, ok := c.items[key].(V)
+	c.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := fetch()
+	if err != nil {
+		return v, err
+	}
+
+	c.mu.Lock()
+	if c.items == nil {
+		c.items = make(map[any]any)
+	}
+	c.items[key] = v
+	c.mu.Unlock()
+
+	return v, nil
+}
+
+// Forget drops what the cache of ctx keeps, for requests that changed it.
+func Forget(ctx context.Context) {
+	if c, ok := ctx.Value(ctxKey{}).(*cache); ok {
+		c.mu.Lock()
+		clear(c.items)
+		c.mu.Unlock()
+	}
+}
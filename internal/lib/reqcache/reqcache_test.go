@@ -0,0 +1,61 @@
+package reqcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type userKey int64
+
+type roleKey int64
+
+func TestLoad(t *testing.T) {
+	calls := 0
+	fetch := func(v string, err error) func() (string, error) {
+		return func() (string, error) {
+			calls++
+			return v, err
+		}
+	}
+	ctx := NewContext(context.Background())
+
+	v, err := Load(ctx, userKey(1), fetch("john", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "john", v)
+	v, _ = Load(ctx, userKey(1), fetch("jane", nil))
+	assert.Equal(t, "john", v, "hits do not fetch")
+	assert.Equal(t, 1, calls)
+
+	// Keys of other types do not collide.
+	v, _ = Load(ctx, roleKey(1), fetch("admin", nil))
+	assert.Equal(t, "admin", v)
+	assert.Equal(t, 2, calls)
+
+	// Failures are not kept.
+	_, err = Load(ctx, userKey(2), fetch("", errors.New("boom")))
+	assert.Error(t, err)
+	v, err = Load(ctx, userKey(2), fetch("jane", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", v)
+
+	Forget(ctx)
+	v, _ = Load(ctx, userKey(1), fetch("johnny", nil))
+	assert.Equal(t, "johnny", v)
+}
+
+func TestLoad_NoCache(t *testing.T) {
+	calls := 0
+	fetch := func() (int, error) {
+		calls++
+		return calls, nil
+	}
+
+	Forget(context.Background())
+	for range 2 {
+		_, _ = Load(context.Background(), userKey(1), fetch)
+	}
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,105 @@
+// Package reserved matches identifiers, such as email local parts, against
+// a list of reserved words nobody may register with.
+//
+// An entry is either a word, matching exactly that word, or a word
+// followed by "*", matching every identifier starting with it:
+//
+//	support     matches support, not supporter
+//	admin*      matches admin and administrator
+//
+// Entries and identifiers are compared folded: in lower case, without a
+// "+" suffix and without dots, hyphens and underscores, so No.Reply+news
+// matches noreply.
+package reserved
+
+import (
+	"fmt"
+	"strings"
+)
+
+const prefixSuffix = "*"
+
+// List is a list of reserved entries. A nil List reserves nothing.
+type List struct {
+	exact    map[string]string
+	prefixes []entry
+}
+
+type entry struct {
+	word string
+	// raw is the entry as configured, for reports.
+	raw string
+}
+
+// New returns the list of entries. An entry that folds to nothing, with a
+// "+" or with a "*" elsewhere than at its end, is an error.
+func New(entries []string) (*List, error) {
+	l := &List{exact: make(map[string]string, len(entries))}
+	for _, raw := range entries {
+		raw = strings.TrimSpace(raw)
+		word, prefix := strings.CutSuffix(raw, prefixSuffix)
+		if strings.ContainsAny(word, prefixSuffix+"+") {
+			return nil, fmt.Errorf("invalid entry %q: only a trailing * is allowed", raw)
+		}
+		folded := Fold(word)
+		if folded == "" {
+			return nil, fmt.Errorf("invalid entry %q: empty word", raw)
+		}
+
+		if prefix {
+			l.prefixes = append(l.prefixes, entry{word: folded, raw: raw})
+		} else {
+			l.exact[folded] = raw
+		}
+	}
+
+	return l, nil
+}
+
+// Match returns the entry identifier matches, if any.
+func (l *List) Match(identifier string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+
+	folded := Fold(identifier)
+	if folded == "" {
+		return "", false
+	}
+	if raw, ok := l.exact[folded]; ok {
+		return raw, true
+	}
+	for _, e := range l.prefixes {
+		if strings.HasPrefix(folded, e.word) {
+			return e.raw, true
+		}
+	}
+
+	return "", false
+}
+
+// MatchEmail matches the local part of email, see Match.
+func (l *List) MatchEmail(email string) (string, bool) {
+	local := email
+	if at := strings.LastIndexByte(email, '@'); at >= 0 {
+		local = email[:at]
+	}
+
+	return l.Match(local)
+}
+
+// Fold returns identifier as it is compared.
+func Fold(identifier string) string {
+	if plus := strings.IndexByte(identifier, '+'); plus >= 0 {
+		identifier = identifier[:plus]
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '-', '_':
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(strings.TrimSpace(identifier)))
+}
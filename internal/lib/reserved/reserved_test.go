@@ -0,0 +1,85 @@
+package reserved
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList_Match(t *testing.T) {
+	l, err := New([]string{"admin*", "root", "support", "No-Reply", " help* "})
+	require.NoError(t, err)
+
+	tests := []struct {
+		identifier string
+		want       string
+	}{
+		{identifier: "admin", want: "admin*"},
+		{identifier: "Administrator", want: "admin*"},
+		{identifier: "a.d.m.i.n", want: "admin*"},
+		{identifier: "admin+billing", want: "admin*"},
+		{identifier: "ADMIN_team", want: "admin*"},
+		{identifier: "root", want: "root"},
+		{identifier: "Root+x", want: "root"},
+		{identifier: "r.o.o.t", want: "root"},
+		{identifier: "support", want: "support"},
+		{identifier: "sup-port", want: "support"},
+		{identifier: "noreply", want: "No-Reply"},
+		{identifier: "no_reply", want: "No-Reply"},
+		{identifier: "No.Reply+news", want: "No-Reply"},
+		{identifier: "helpdesk", want: "help*"},
+		{identifier: " help ", want: "help*"},
+
+		{identifier: "rooted"},
+		{identifier: "supporter"},
+		{identifier: "myadmin"},
+		{identifier: "jane+admin"},
+		{identifier: "+admin"},
+		{identifier: "..."},
+		{identifier: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.identifier, func(t *testing.T) {
+			got, ok := l.Match(tt.identifier)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestList_MatchEmail(t *testing.T) {
+	l, err := New([]string{"admin*", "support"})
+	require.NoError(t, err)
+
+	for email, want := range map[string]bool{
+		"admin@ourdomain.edu":           true,
+		"Admin.Office@ourdomain.edu":    true,
+		"support+tickets@ourdomain.edu": true,
+		// Only the local part counts.
+		"jane@admin.ourdomain.edu":   false,
+		"jane.support@ourdomain.edu": false,
+		"support":                    true,
+	} {
+		_, ok := l.MatchEmail(email)
+		assert.Equal(t, want, ok, email)
+	}
+}
+
+func TestList_Nil(t *testing.T) {
+	var l *List
+	_, ok := l.MatchEmail("admin@example.com")
+	assert.False(t, ok)
+
+	l, err := New(nil)
+	require.NoError(t, err)
+	_, ok = l.Match("admin")
+	assert.False(t, ok)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, entry := range []string{"", "*", "...", "ad*min", "**", "admin+x"} {
+		_, err := New([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
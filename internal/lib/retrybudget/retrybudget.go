@@ -0,0 +1,30 @@
+// Package retrybudget caps the retries one request makes across all of
+// its calls to dependencies, carried in the context.
+package retrybudget
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx allowing n retries in all, shared by
+// every call made with it or a context derived from it.
+func NewContext(ctx context.Context, n int) context.Context {
+	left := &atomic.Int64{}
+	left.Store(int64(n))
+
+	return context.WithValue(ctx, ctxKey{}, left)
+}
+
+// Take spends a retry of the budget of ctx and reports whether there was
+// one left. A context without a budget does not cap retries.
+func Take(ctx context.Context) bool {
+	left, ok := ctx.Value(ctxKey{}).(*atomic.Int64)
+	if !ok {
+		return true
+	}
+
+	return left.Add(-1) >= 0
+}
@@ -0,0 +1,22 @@
+package retrybudget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTake(t *testing.T) {
+	ctx := NewContext(context.Background(), 2)
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	assert.True(t, Take(ctx))
+	assert.True(t, Take(derived), "derived contexts share the budget")
+	assert.False(t, Take(ctx))
+	assert.False(t, Take(derived))
+
+	assert.True(t, Take(context.Background()), "no budget, no cap")
+	assert.False(t, Take(NewContext(context.Background(), 0)))
+}
@@ -0,0 +1,169 @@
+// Package useragent labels the devices tokens are issued to, such as
+// "Chrome on Windows" or "iOS app 2.3", from the user-agent a client sends
+// and the version it declares in x-client-version. Labels are coarse on
+// purpose: they let users tell their sessions apart, not fingerprint them.
+//
+// Only the first MaxLen bytes of a user-agent are read, and they are only
+// searched for fixed tokens, so labeling takes bounded time whatever a
+// client sends.
+package useragent
+
+import "strings"
+
+const (
+	// MaxLen is how much of a user-agent is read. The browsers and gRPC
+	// libraries name themselves well before.
+	MaxLen = 256
+	// MaxVersionLen bounds the client versions kept; longer ones are
+	// dropped rather than cut, as a cut version would be a wrong one.
+	MaxVersionLen = 32
+	// Unknown labels a device nothing is known of.
+	Unknown = "Unknown device"
+)
+
+// A token identifies what a user-agent is if it contains needle, checked
+// in order: the first match wins. Many user-agents name several, such as
+// Edge also claiming to be Chrome and Safari, or iOS claiming to be a Mac.
+type token struct {
+	needle string
+	name   string
+}
+
+var systems = []token{
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"cfnetwork", "iOS"},
+	{"android", "Android"},
+	{"cros ", "ChromeOS"},
+	{"windows", "Windows"},
+	{"macintosh", "macOS"},
+	{"mac os x", "macOS"},
+	{"darwin", "macOS"},
+	{"linux", "Linux"},
+}
+
+var browsers = []token{
+	{"edg/", "Edge"},
+	{"edga/", "Edge"},
+	{"edgios/", "Edge"},
+	{"opr/", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"yabrowser/", "Yandex Browser"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"safari/", "Safari"},
+}
+
+// bots are checked first, as they pose as browsers.
+var bots = []token{
+	{"bot/", "Bot"},
+	{"bot-", "Bot"},
+	{"bot;", "Bot"},
+	{"spider", "Bot"},
+	{"crawler", "Bot"},
+}
+
+// clients are those that are not browsers and run on no system worth
+// naming.
+var clients = []token{
+	{"grpcurl/", "grpcurl"},
+	{"curl/", "curl"},
+	{"grpc-", "gRPC client"},
+	{"okhttp/", "OkHttp client"},
+	{"python-", "Python client"},
+	{"go-http-client/", "Go client"},
+}
+
+// Label returns the label of the device sending userAgent, and version in
+// x-client-version if it is an app declaring one. Either may be empty.
+// The label is always printable ASCII of a bounded length.
+func Label(userAgent, version string) string {
+	ua := strings.ToLower(Normalize(userAgent))
+	system := find(systems, ua)
+
+	if version = normalizeVersion(version); version != "" {
+		if system == "" {
+			return "App " + version
+		}
+
+		return system + " app " + version
+	}
+
+	if bot := find(bots, ua); bot != "" {
+		return bot
+	}
+	if browser := find(browsers, ua); browser != "" {
+		if system == "" {
+			return browser
+		}
+
+		return browser + " on " + system
+	}
+	if client := find(clients, ua); client != "" {
+		return client
+	}
+	if system != "" {
+		return system + " device"
+	}
+
+	return Unknown
+}
+
+// Normalize returns the first MaxLen bytes of userAgent, without anything but
+// printable ASCII: runs of other bytes, such as binary garbage, control
+// characters or UTF-8, become a single space.
+func Normalize(userAgent string) string {
+	userAgent = userAgent[:min(len(userAgent), MaxLen)]
+
+	b := make([]byte, 0, len(userAgent))
+	space := false
+	for i := range len(userAgent) {
+		c := userAgent[i]
+		if c < 0x20 || c >= 0x7f {
+			c = ' '
+		}
+		if c == ' ' {
+			if space || len(b) == 0 {
+				continue
+			}
+			space = true
+		} else {
+			space = false
+		}
+		b = append(b, c)
+	}
+
+	return strings.TrimRight(string(b), " ")
+}
+
+// normalizeVersion returns version if it looks like one, such as "2.3" or
+// "2.3.1-beta+42", and "" otherwise.
+func normalizeVersion(version string) string {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" || len(version) > MaxVersionLen {
+		return ""
+	}
+	for i := range len(version) {
+		switch c := version[i]; {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '.', c == '-', c == '+', c == '_':
+		default:
+			return ""
+		}
+	}
+
+	return version
+}
+
+func find(tokens []token, ua string) string {
+	for _, t := range tokens {
+		if strings.Contains(ua, t.needle) {
+			return t.name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,145 @@
+package useragent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabel(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		userAgent string
+		version   string
+		want      string
+	}{
+		{
+			name:      "chrome on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      "Chrome on Windows",
+		},
+		{
+			name:      "edge on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want:      "Edge on Windows",
+		},
+		{
+			name:      "firefox on linux",
+			userAgent: "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want:      "Firefox on Linux",
+		},
+		{
+			name:      "safari on macos",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want:      "Safari on macOS",
+		},
+		{
+			name:      "safari on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want:      "Safari on iOS",
+		},
+		{
+			name:      "chrome on ipad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want:      "Chrome on iOS",
+		},
+		{
+			name:      "chrome on android",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			want:      "Chrome on Android",
+		},
+		{
+			name:      "samsung internet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want:      "Samsung Internet on Android",
+		},
+		{
+			name:      "opera on chromeos",
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/109.0.0.0",
+			want:      "Opera on ChromeOS",
+		},
+		{
+			name:      "ios app",
+			userAgent: "Diary/2.3 CFNetwork/1494.0.7 Darwin/23.4.0",
+			version:   "2.3",
+			want:      "iOS app 2.3",
+		},
+		{
+			name:      "android app",
+			userAgent: "grpc-java-okhttp/1.63.0 (Linux; Android 14)",
+			version:   "v4.1.0-beta",
+			want:      "Android app 4.1.0-beta",
+		},
+		{
+			name:    "app on no known system",
+			version: "1.0",
+			want:    "App 1.0",
+		},
+		{name: "grpc-go", userAgent: "grpc-go/1.63.2", want: "gRPC client"},
+		{name: "grpcurl", userAgent: "grpcurl/v1.9.1 grpc-go/1.61.0", want: "grpcurl"},
+		{name: "curl", userAgent: "curl/8.5.0", want: "curl"},
+		{name: "python", userAgent: "python-requests/2.31.0", want: "Python client"},
+		{
+			name:      "googlebot posing as chrome",
+			userAgent: "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko; compatible; Googlebot/2.1; +http://www.google.com/bot.html) Chrome/124.0.6367.91 Safari/537.36",
+			want:      "Bot",
+		},
+		{
+			name:      "phone brand is no bot",
+			userAgent: "Mozilla/5.0 (Linux; Android 11; Cubot X30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			want:      "Chrome on Android",
+		},
+		{name: "system only", userAgent: "Dalvik/2.1.0 (Linux; U; Android 14; Pixel 8 Build/AP1A)", want: "Android device"},
+
+		{name: "empty", want: Unknown},
+		{name: "garbage", userAgent: "\x00\xff\xfe\x01\x02", want: Unknown},
+		{name: "nonsense", userAgent: "hello there", want: Unknown},
+		{name: "binary around a browser", userAgent: "\x00Firefox/1\xff(Windows)\n", want: "Firefox on Windows"},
+		{name: "invalid version", userAgent: "curl/8.5.0", version: "2.3\nlevel=ERROR", want: "curl"},
+		{name: "version too long", userAgent: "curl/8.5.0", version: strings.Repeat("1", MaxVersionLen+1), want: "curl"},
+		{
+			name:      "past MaxLen is not read",
+			userAgent: strings.Repeat("a", MaxLen) + " Chrome/124.0 (Windows)",
+			want:      Unknown,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Label(tt.userAgent, tt.version))
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "Mozilla/5.0 (X11)", Normalize("  Mozilla/5.0\r\n\t(X11)  "))
+	assert.Equal(t, "caf Firefox/1", Normalize("caféé Firefox/1"))
+	assert.Empty(t, Normalize("\x00\x01\xff"))
+	assert.Len(t, Normalize(strings.Repeat("x", 1<<20)), MaxLen)
+}
+
+func FuzzLabel(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/124.0.0.0 Safari/537.36",
+		"grpc-go/1.63.2",
+		"\x00\xff",
+		strings.Repeat("Mozilla/5.0 ", 100),
+	} {
+		f.Add(seed, "2.3")
+	}
+
+	f.Fuzz(func(t *testing.T, userAgent, version string) {
+		label := Label(userAgent, version)
+		require.NotEmpty(t, label)
+		require.LessOrEqual(t, len(label), len("Samsung Internet on ChromeOS")+len(" app ")+MaxVersionLen)
+		require.Equal(t, label, strings.TrimSpace(label))
+		for i := range len(label) {
+			require.True(t, label[i] >= 0x20 && label[i] < 0x7f, "%q", label)
+		}
+
+		normalized := Normalize(userAgent)
+		require.LessOrEqual(t, len(normalized), MaxLen)
+		require.Equal(t, normalized, Normalize(normalized))
+	})
+}
@@ -0,0 +1,96 @@
+// Package oidc serves the OpenID Connect discovery document and the JWKS
+// with the public keys RS256 tokens are signed with.
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"sso/internal/lib/jwt"
+)
+
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks.json"
+)
+
+// Discovery is the subset of the OpenID Provider Metadata we can honestly
+// claim to support.
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// JWK is an RSA public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Handler serves both documents. They are built on every request from
+// keys, so a rotation shows up without a restart.
+//
+// issuer is the public base URL of the service. When empty, it is derived
+// from the request, which is only correct without proxies in front.
+func Handler(issuer string, keys *jwt.KeySet) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET "+DiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		base := issuer
+		if base == "" {
+			base = "http://" + r.Host
+		}
+		base = strings.TrimSuffix(base, "/")
+
+		writeJSON(w, Discovery{
+			Issuer:                           base,
+			JWKSURI:                          base + JWKSPath,
+			ResponseTypesSupported:           []string{"code"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{"RS256"},
+		})
+	})
+
+	mux.HandleFunc("GET "+JWKSPath, func(w http.ResponseWriter, _ *http.Request) {
+		jwks := JWKS{Keys: []JWK{}}
+		for _, key := range keys.Keys() {
+			pub := key.Private.PublicKey
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Use: "sig",
+				Alg: "RS256",
+				Kid: key.ID,
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   jwt.EncodeExponent(pub.E),
+			})
+		}
+
+		// Caches may keep the keys for a while, but not for longer than a
+		// refresh of the key set takes to notice a rotation.
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		writeJSON(w, jwks)
+	})
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	ssojwt "sso/internal/lib/jwt"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKey(t *testing.T) ssojwt.Key {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return ssojwt.NewKey(private)
+}
+
+func get(t *testing.T, url string, v any) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+// publicKeys decodes the JWKS the way a third-party verifier would,
+// without any of our own helpers.
+func publicKeys(t *testing.T, url string) map[string]*rsa.PublicKey {
+	t.Helper()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	get(t, url, &jwks)
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		require.Equal(t, "RSA", k.Kty)
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		require.NoError(t, err)
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		require.NoError(t, err)
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys
+}
+
+func TestDiscovery(t *testing.T) {
+	srv := httptest.NewServer(Handler("https://sso.example.com/", ssojwt.NewKeySet()))
+	t.Cleanup(srv.Close)
+
+	var doc Discovery
+	get(t, srv.URL+DiscoveryPath, &doc)
+
+	assert.Equal(t, "https://sso.example.com", doc.Issuer)
+	assert.Equal(t, "https://sso.example.com/.well-known/jwks.json", doc.JWKSURI)
+	assert.Equal(t, []string{"RS256"}, doc.IDTokenSigningAlgValuesSupported)
+}
+
+func TestJWKS_VerifiesIssuedToken(t *testing.T) {
+	keys := ssojwt.NewKeySet()
+	first := newKey(t)
+	keys.Set(first, ssojwt.Key{})
+
+	srv := httptest.NewServer(Handler("", keys))
+	t.Cleanup(srv.Close)
+
+	user := models.User{ID: 42, Email: "user@example.com"}
+	app := models.App{ID: 1}
+
+	oldToken, err := ssojwt.GenerateNewRS256Token(nil, user, app, time.Hour, "", first)
+	require.NoError(t, err)
+
+	verify := func(t *testing.T, tokenString string, published map[string]*rsa.PublicKey) error {
+		t.Helper()
+
+		_, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+			require.IsType(t, &jwt.SigningMethodRSA{}, token.Method)
+
+			key, ok := published[token.Header["kid"].(string)]
+			if !ok {
+				return nil, assert.AnError
+			}
+			return key, nil
+		})
+		return err
+	}
+
+	require.NoError(t, verify(t, oldToken, publicKeys(t, srv.URL+JWKSPath)))
+
+	// After a rotation the document changes without restarting anything:
+	// new tokens verify with the new key and old ones with the previous.
+	second := newKey(t)
+	keys.Set(second, first)
+
+	newToken, err := ssojwt.GenerateNewRS256Token(nil, user, app, time.Hour, "", second)
+	require.NoError(t, err)
+
+	published := publicKeys(t, srv.URL+JWKSPath)
+	assert.Len(t, published, 2)
+	assert.NoError(t, verify(t, newToken, published))
+	assert.NoError(t, verify(t, oldToken, published))
+
+	// Once the first key leaves the set, its tokens no longer verify.
+	keys.Set(newKey(t), second)
+	assert.Error(t, verify(t, oldToken, publicKeys(t, srv.URL+JWKSPath)))
+}
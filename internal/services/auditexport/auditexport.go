@@ -0,0 +1,332 @@
+// Package auditexport exports the lifecycle events recorded in the outbox
+// to external sinks, such as a file a SIEM ingests, as they happen. Every
+// sink is fed from a queue of its own, so a failing or slow sink neither
+// holds up the others nor the requests publishing events.
+package auditexport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/metrics"
+)
+
+// DefaultQueueSize is the number of events a sink may lag behind before
+// further ones are dropped.
+const DefaultQueueSize = 1024
+
+// Sink writes the export of events, one JSON object per line, see Encode.
+type Sink interface {
+	Write(line []byte) error
+	// Flush writes out what Write buffered. It is called whenever the
+	// queue of the sink runs empty.
+	Flush() error
+	Close() error
+}
+
+// EventSaver is the outbox the exported events are recorded in.
+type EventSaver interface {
+	SaveEvent(ctx context.Context, event models.Event) (int64, error)
+}
+
+// Exporter feeds its sinks with the events given to Publish.
+type Exporter struct {
+	log       *slog.Logger
+	queueSize int
+	sinks     []*sinkQueue
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type sinkQueue struct {
+	name  string
+	sink  Sink
+	queue chan models.Event
+
+	exported *metrics.Counter
+	failed   *metrics.Counter
+	dropped  *metrics.Counter
+}
+
+// Option configures Exporter, see New.
+type Option func(*Exporter)
+
+// WithSink exports to sink, named name in logs and metrics.
+func WithSink(name string, sink Sink) Option {
+	return func(e *Exporter) {
+		e.sinks = append(e.sinks, &sinkQueue{
+			name:     name,
+			sink:     sink,
+			exported: metrics.NewCounter("audit_export_events_total"),
+			failed:   metrics.NewCounter("audit_export_failures_total"),
+			dropped:  metrics.NewCounter("audit_export_dropped_total"),
+		})
+	}
+}
+
+// WithQueueSize sets how many events a sink may lag behind before further
+// ones are dropped. Default DefaultQueueSize.
+func WithQueueSize(n int) Option {
+	return func(e *Exporter) { e.queueSize = n }
+}
+
+// New returns an exporter to the sinks of opts, already running. Close
+// must be called to write out the events still queued.
+func New(log *slog.Logger, opts ...Option) (*Exporter, error) {
+	const op = "auditexport.New"
+
+	e := &Exporter{log: log, queueSize: DefaultQueueSize}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	switch {
+	case e.log == nil:
+		return nil, fmt.Errorf("%s: logger is required", op)
+	case e.queueSize <= 0:
+		return nil, fmt.Errorf("%s: queue size must be positive, got %d", op, e.queueSize)
+	}
+	for _, q := range e.sinks {
+		if q.sink == nil {
+			return nil, fmt.Errorf("%s: sink %s is nil", op, q.name)
+		}
+	}
+
+	for _, q := range e.sinks {
+		q.queue = make(chan models.Event, e.queueSize)
+		e.wg.Add(1)
+		go e.run(q)
+	}
+
+	return e, nil
+}
+
+// Publish queues event for every sink. It never blocks: a sink whose
+// queue is full misses the event, which is counted. Events published
+// after Close are dropped.
+func (e *Exporter) Publish(event models.Event) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return
+	}
+	for _, q := range e.sinks {
+		select {
+		case q.queue <- event:
+		default:
+			q.dropped.Inc()
+		}
+	}
+}
+
+// Close stops accepting events and waits for the sinks to write out those
+// queued, flush and close, or for ctx to be done.
+func (e *Exporter) Close(ctx context.Context) error {
+	const op = "auditexport.Close"
+
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		for _, q := range e.sinks {
+			close(q.queue)
+		}
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", op, ctx.Err())
+	}
+}
+
+// Metrics returns how many events every sink exported, failed to export
+// and dropped for a full queue, since the start.
+func (e *Exporter) Metrics() map[string]map[string]int64 {
+	res := make(map[string]map[string]int64, len(e.sinks))
+	for _, q := range e.sinks {
+		res[q.name] = map[string]int64{
+			"exported": q.exported.Value(),
+			"failed":   q.failed.Value(),
+			"dropped":  q.dropped.Value(),
+		}
+	}
+
+	return res
+}
+
+// run writes the events of q until its queue is closed. Failures are
+// logged when the sink starts and stops failing, not for every event.
+func (e *Exporter) run(q *sinkQueue) {
+	defer e.wg.Done()
+
+	log := e.log.With(slog.String("sink", q.name))
+	failing := false
+	report := func(err error) {
+		switch {
+		case err != nil && !failing:
+			log.Error("failed to export audit events", slog.Any("error", err))
+		case err == nil && failing:
+			log.Info("audit export recovered")
+		}
+		failing = err != nil
+	}
+
+	for event := range q.queue {
+		line, err := Encode(event)
+		if err == nil {
+			err = q.sink.Write(line)
+		}
+		if err != nil {
+			q.failed.Inc()
+		} else {
+			q.exported.Inc()
+		}
+		if err == nil && len(q.queue) == 0 {
+			err = q.sink.Flush()
+		}
+		report(err)
+	}
+
+	if err := errors.Join(q.sink.Flush(), q.sink.Close()); err != nil {
+		log.Error("failed to close audit sink", slog.Any("error", err))
+	}
+}
+
+// line is the export of an event.
+type line struct {
+	ID        int64           `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	UserID    int64           `json:"user_id,omitempty"`
+	AppID     int32           `json:"app_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// redacted replaces the values of secret payload fields in the export.
+const redacted = "[redacted]"
+
+// secretSuffixes are the endings of the payload fields Encode redacts:
+// the tokens of links mailed to users, such as the reactivation token of
+// a scheduled deletion, grant whoever reads the export their account.
+var secretSuffixes = []string{"token", "secret", "password"}
+
+// Encode returns the export of event: a JSON object on one line, ending
+// with a newline. The payload is embedded if it is JSON, with its secret
+// fields redacted, see secretSuffixes.
+func Encode(event models.Event) ([]byte, error) {
+	l := line{
+		ID:        event.ID,
+		Type:      event.Type,
+		UserID:    event.UserID,
+		AppID:     event.AppID,
+		CreatedAt: event.CreatedAt.UTC(),
+	}
+	if json.Valid(event.Payload) {
+		payload, err := redact(event.Payload)
+		if err != nil {
+			return nil, err
+		}
+		l.Payload = payload
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// redact returns payload with the values of its secret fields, at any
+// depth, replaced by redacted. A payload without any is returned as it is.
+func redact(payload json.RawMessage) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if !redactValue(v) {
+		return payload, nil
+	}
+
+	return json.Marshal(v)
+}
+
+// redactValue redacts the secret fields of the objects in v and reports
+// whether there were any.
+func redactValue(v any) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if isSecretField(key) {
+				v[key] = redacted
+				found = true
+				continue
+			}
+			found = redactValue(value) || found
+		}
+	case []any:
+		for _, value := range v {
+			found = redactValue(value) || found
+		}
+	}
+
+	return found
+}
+
+func isSecretField(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range secretSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Publisher records events in the outbox and exports them, whether or not
+// recording succeeded: the export of an event the outbox failed to record
+// has no ID.
+type Publisher struct {
+	next     EventSaver
+	exporter *Exporter
+}
+
+// NewPublisher returns a Publisher recording events with next and
+// exporting them with exporter.
+func NewPublisher(next EventSaver, exporter *Exporter) Publisher {
+	return Publisher{next: next, exporter: exporter}
+}
+
+// SaveEvent records event with the outbox, then exports it.
+func (p Publisher) SaveEvent(ctx context.Context, event models.Event) (int64, error) {
+	id, err := p.next.SaveEvent(ctx, event)
+	if err == nil {
+		event.ID = id
+	}
+	p.exporter.Publish(event)
+
+	return id, err
+}
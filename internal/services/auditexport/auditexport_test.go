@@ -0,0 +1,232 @@
+package auditexport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySink keeps the lines written, or fails them with err. It blocks in
+// Write until unblock is closed, if set.
+type memorySink struct {
+	err     error
+	unblock chan struct{}
+
+	mu      sync.Mutex
+	lines   []string
+	flushes int
+	closed  bool
+}
+
+func (s *memorySink) Write(line []byte) error {
+	if s.unblock != nil {
+		<-s.unblock
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, string(line))
+
+	return nil
+}
+
+func (s *memorySink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes++
+
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	return nil
+}
+
+func testEvent(id int64) models.Event {
+	return models.Event{
+		ID:        id,
+		Type:      models.EventUserLoggedIn,
+		UserID:    7,
+		AppID:     1,
+		Payload:   []byte(`{"email":"user@example.com"}`),
+		CreatedAt: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func newTestExporter(t *testing.T, opts ...Option) *Exporter {
+	t.Helper()
+
+	e, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), opts...)
+	require.NoError(t, err)
+
+	return e
+}
+
+func TestEncode(t *testing.T) {
+	line, err := Encode(testEvent(3))
+	require.NoError(t, err)
+	assert.Equal(t,
+		`{"id":3,"type":"user.logged_in","user_id":7,"app_id":1,"payload":{"email":"user@example.com"},"created_at":"2026-03-01T12:00:00Z"}`+"\n",
+		string(line))
+
+	event := testEvent(0)
+	event.Payload = []byte("not json")
+	line, err = Encode(event)
+	require.NoError(t, err)
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(line, &decoded))
+	assert.NotContains(t, decoded, "payload")
+	assert.NotContains(t, decoded, "id")
+}
+
+func TestEncode_RedactsSecrets(t *testing.T) {
+	const token = "reactivate-me-7f3a"
+
+	event := testEvent(3)
+	event.Type = models.EventDeletionScheduled
+	event.Payload = []byte(`{"delete_after":"2026-03-08T12:00:00Z","reactivation_token":"` + token +
+		`","nested":[{"Activation_Token":"` + token + `","status":200}]}`)
+	line, err := Encode(event)
+	require.NoError(t, err)
+
+	assert.NotContains(t, string(line), token)
+	var decoded struct {
+		Payload struct {
+			DeleteAfter       string `json:"delete_after"`
+			ReactivationToken string `json:"reactivation_token"`
+			Nested            []struct {
+				ActivationToken string `json:"Activation_Token"`
+				Status          int    `json:"status"`
+			} `json:"nested"`
+		} `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(line, &decoded))
+	assert.Equal(t, "2026-03-08T12:00:00Z", decoded.Payload.DeleteAfter)
+	assert.Equal(t, redacted, decoded.Payload.ReactivationToken)
+	require.Len(t, decoded.Payload.Nested, 1)
+	assert.Equal(t, redacted, decoded.Payload.Nested[0].ActivationToken)
+	assert.Equal(t, 200, decoded.Payload.Nested[0].Status)
+}
+
+func TestExporter(t *testing.T) {
+	good := &memorySink{}
+	bad := &memorySink{err: errors.New("disk full")}
+	e := newTestExporter(t, WithSink("good", good), WithSink("bad", bad))
+
+	for id := range int64(3) {
+		e.Publish(testEvent(id + 1))
+	}
+	require.NoError(t, e.Close(context.Background()))
+	e.Publish(testEvent(4))
+
+	assert.Len(t, good.lines, 3, "a failing sink does not keep the others from exporting")
+	assert.Positive(t, good.flushes)
+	assert.True(t, good.closed)
+	assert.True(t, bad.closed)
+	assert.Equal(t, map[string]map[string]int64{
+		"good": {"exported": 3, "failed": 0, "dropped": 0},
+		"bad":  {"exported": 0, "failed": 3, "dropped": 0},
+	}, e.Metrics())
+}
+
+func TestExporter_SlowSink(t *testing.T) {
+	fast := &memorySink{}
+	slow := &memorySink{unblock: make(chan struct{})}
+	e := newTestExporter(t, WithSink("fast", fast), WithSink("slow", slow), WithQueueSize(2))
+
+	// The slow sink holds one event and queues two: the rest is dropped,
+	// without Publish waiting for it.
+	done := make(chan struct{})
+	go func() {
+		for id := range int64(10) {
+			e.Publish(testEvent(id + 1))
+			time.Sleep(time.Millisecond)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a slow sink")
+	}
+
+	close(slow.unblock)
+	require.NoError(t, e.Close(context.Background()))
+
+	assert.Len(t, fast.lines, 10)
+	m := e.Metrics()["slow"]
+	assert.Equal(t, int64(10), m["exported"]+m["dropped"])
+	assert.LessOrEqual(t, m["exported"], int64(3))
+}
+
+func TestExporter_CloseTimeout(t *testing.T) {
+	stuck := &memorySink{unblock: make(chan struct{})}
+	defer close(stuck.unblock)
+	e := newTestExporter(t, WithSink("stuck", stuck))
+	e.Publish(testEvent(1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, e.Close(ctx), context.DeadlineExceeded)
+}
+
+type failingOutbox struct{}
+
+func (failingOutbox) SaveEvent(context.Context, models.Event) (int64, error) {
+	return 0, errs.Wrap("storage.failing.SaveEvent", errors.New("database is locked"))
+}
+
+type countingOutbox struct{ id int64 }
+
+func (o *countingOutbox) SaveEvent(context.Context, models.Event) (int64, error) {
+	o.id++
+
+	return o.id, nil
+}
+
+func TestPublisher(t *testing.T) {
+	sink := &memorySink{}
+	e := newTestExporter(t, WithSink("memory", sink))
+
+	id, err := NewPublisher(&countingOutbox{}, e).SaveEvent(context.Background(), testEvent(0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+
+	// Exported even though the outbox failed.
+	_, err = NewPublisher(failingOutbox{}, e).SaveEvent(context.Background(), testEvent(0))
+	require.Error(t, err)
+
+	require.NoError(t, e.Close(context.Background()))
+	require.Len(t, sink.lines, 2)
+	assert.Contains(t, sink.lines[0], `"id":1,`)
+	assert.NotContains(t, sink.lines[1], `"id"`)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := New(nil)
+	assert.Error(t, err)
+	_, err = New(log, WithQueueSize(0))
+	assert.Error(t, err)
+	_, err = New(log, WithSink("nil", nil))
+	assert.Error(t, err)
+}
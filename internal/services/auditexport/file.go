@@ -0,0 +1,133 @@
+package auditexport
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// FileSink appends the export of events to a file, rotated once it would
+// grow past a size: the file is renamed path.1, the former path.1 path.2
+// and so on, the oldest beyond keep being removed.
+type FileSink struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+}
+
+// NewFileSink opens the file at path for appending, creating it if need be.
+// maxSize is the size in bytes past which it is rotated, keep the number
+// of rotated files kept.
+func NewFileSink(path string, maxSize int64, keep int) (*FileSink, error) {
+	const op = "auditexport.NewFileSink"
+
+	switch {
+	case path == "":
+		return nil, fmt.Errorf("%s: path is required", op)
+	case maxSize <= 0:
+		return nil, fmt.Errorf("%s: max size must be positive, got %d", op, maxSize)
+	case keep < 0:
+		return nil, fmt.Errorf("%s: keep must not be negative, got %d", op, keep)
+	}
+
+	s := &FileSink{path: path, maxSize: maxSize, keep: keep}
+	if err := s.open(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+
+		return err
+	}
+
+	s.f, s.w, s.size = f, bufio.NewWriter(f), info.Size()
+
+	return nil
+}
+
+// Write appends line, rotating the file first if line would take it past
+// its max size. A line longer than the max size gets a file of its own.
+func (s *FileSink) Write(line []byte) error {
+	const op = "auditexport.FileSink.Write"
+
+	if s.f == nil {
+		// A failed rotation closed the file: try again.
+		if err := s.open(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	n, err := s.w.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	err := errors.Join(s.w.Flush(), s.f.Close())
+	s.f, s.w = nil, nil
+	if err != nil {
+		return err
+	}
+
+	if s.keep == 0 {
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+	for i := s.keep; i > 0; i-- {
+		from := s.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", s.path, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return s.open()
+}
+
+// Flush writes out the buffered lines.
+func (s *FileSink) Flush() error {
+	if s.w == nil {
+		return nil
+	}
+
+	return s.w.Flush()
+}
+
+// Close flushes and closes the file.
+func (s *FileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := errors.Join(s.w.Flush(), s.f.Close())
+	s.f, s.w = nil, nil
+
+	return err
+}
@@ -0,0 +1,71 @@
+package auditexport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	line := strings.Repeat("x", 9) + "\n"
+
+	s, err := NewFileSink(path, 25, 2)
+	require.NoError(t, err)
+	// Two lines fit a file: seven lines make four files, the oldest of
+	// which is removed.
+	for range 7 {
+		require.NoError(t, s.Write([]byte(line)))
+	}
+	require.NoError(t, s.Close())
+
+	read := func(name string) string {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, line, read(path))
+	assert.Equal(t, line+line, read(path+".1"))
+	assert.Equal(t, line+line, read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Reopened, the file is appended to where it was left.
+	s, err = NewFileSink(path, 25, 2)
+	require.NoError(t, err)
+	require.NoError(t, s.Write([]byte(line)))
+	require.NoError(t, s.Flush())
+	assert.Equal(t, line+line, read(path))
+	require.NoError(t, s.Close())
+}
+
+func TestFileSink_KeepNone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	s, err := NewFileSink(path, 10, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Write([]byte("first....\n")))
+	require.NoError(t, s.Write([]byte("second...\n")))
+	require.NoError(t, s.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second...\n", string(b))
+	assert.NoFileExists(t, path+".1")
+}
+
+func TestNewFileSink_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewFileSink("", 10, 1)
+	assert.Error(t, err)
+	_, err = NewFileSink(filepath.Join(dir, "a"), 0, 1)
+	assert.Error(t, err)
+	_, err = NewFileSink(filepath.Join(dir, "a"), 10, -1)
+	assert.Error(t, err)
+	_, err = NewFileSink(filepath.Join(dir, "missing", "a"), 10, 1)
+	assert.Error(t, err)
+}
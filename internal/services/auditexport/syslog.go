@@ -0,0 +1,45 @@
+//go:build !windows && !plan9
+
+package auditexport
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogSink sends the export of every event as a syslog message of the
+// auth facility, at info severity. The writer reconnects by itself after
+// a failure.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog daemon at address over network,
+// "udp", "tcp" or "unix" sockets, or to the local one if network is empty.
+// Messages are tagged with tag.
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	const op = "auditexport.NewSyslogSink"
+
+	w, err := syslog.Dial(network, address, syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends line without its newline.
+func (s *SyslogSink) Write(line []byte) error {
+	if err := s.w.Info(string(bytes.TrimRight(line, "\n"))); err != nil {
+		return fmt.Errorf("auditexport.SyslogSink.Write: %w", err)
+	}
+
+	return nil
+}
+
+// Flush does nothing: messages are sent as they are written.
+func (s *SyslogSink) Flush() error { return nil }
+
+// Close closes the connection.
+func (s *SyslogSink) Close() error { return s.w.Close() }
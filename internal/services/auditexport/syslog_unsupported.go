@@ -0,0 +1,19 @@
+//go:build windows || plan9
+
+package auditexport
+
+import "errors"
+
+// SyslogSink is not available on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink fails: there is no syslog on this platform.
+func NewSyslogSink(network, address, tag string) (*SyslogSink, error) {
+	return nil, errors.New("auditexport.NewSyslogSink: syslog is not supported on this platform")
+}
+
+func (*SyslogSink) Write([]byte) error { return nil }
+
+func (*SyslogSink) Flush() error { return nil }
+
+func (*SyslogSink) Close() error { return nil }
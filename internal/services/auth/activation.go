@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/emailaddr"
+)
+
+// DefaultActivationTTL is how long an activation token is valid when
+// WithActivation is given no lifetime.
+const DefaultActivationTTL = 30 * 24 * time.Hour
+
+// errNoActivation is returned by the activation methods when the service
+// was built without WithActivation.
+var errNoActivation = errors.New("activation storage is not configured")
+
+type ActivationStorage interface {
+	PreRegisterUser(
+		ctx context.Context,
+		email string,
+		firstName string,
+		lastName string,
+		middleName string,
+		tokenHash string,
+		expiresAt time.Time,
+	) (int64, error)
+	SetActivationToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	ActivateUser(ctx context.Context, tokenHash string, passHash []byte, now time.Time) (int64, error)
+}
+
+// PreRegisterUser creates an account without a password for the user to
+// activate with ActivateAccount, and returns its activation token. Logins
+// are refused with errs.ErrAccountNotActivated until then. The names are
+// held to the limits of RegisterNewUser.
+//
+// Only the hash of the token is stored, so the caller must hand it to the
+// user. The models.EventActivationIssued event published for the webhooks
+// of every app leaves the token out.
+//
+// If the email is taken, returns errs.ErrUserExists.
+func (a *Auth) PreRegisterUser(
+	ctx context.Context,
+	email string,
+	firstName string,
+	lastName string,
+	middleName string,
+) (models.Activation, error) {
+	const op = "services.auth.PreRegisterUser"
+
+	if err := sanitize(
+		input{"email", &email},
+		input{"first_name", &firstName},
+		input{"last_name", &lastName},
+		input{"middle_name", &middleName},
+	); err != nil {
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	if a.activations == nil {
+		return models.Activation{}, errs.Wrap(op, errNoActivation)
+	}
+
+	email = emailaddr.Normalize(email)
+	if email == "" {
+		return models.Activation{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "email is required"))
+	}
+	middleName = strings.TrimSpace(middleName)
+	if err := validateNames(firstName, lastName, middleName); err != nil {
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate activation token", slog.Any("error", err))
+
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	expiresAt := a.clock.Now().Add(a.activationTTL)
+	id, err := a.activations.PreRegisterUser(ctx, email, firstName, lastName, middleName, hashCode(token), expiresAt)
+	if err != nil {
+		if errors.Is(err, errs.ErrUserExists) {
+			log.Warn("user already exists")
+		} else {
+			log.Error("failed to pre-register user", slog.Any("error", err))
+		}
+
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "user pre-registered", "user_pre_registered", slog.Int64("user_id", id))
+	a.publish(ctx, log, models.EventUserRegistered, id, 0, map[string]string{"email": email})
+
+	activation := models.Activation{UserID: id, Token: token, ExpiresAt: expiresAt}
+	a.activationIssued(ctx, log, activation)
+
+	return activation, nil
+}
+
+// ReissueActivation replaces the activation token of a user pending
+// activation with a new one, valid for the full lifetime, and returns it.
+// The previous token stops working.
+//
+// If the user does not exist, returns errs.ErrUserNotFound; if it is
+// activated already, errs.ErrAccountActivated.
+func (a *Auth) ReissueActivation(ctx context.Context, userID int64) (models.Activation, error) {
+	const op = "services.auth.ReissueActivation"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if a.activations == nil {
+		return models.Activation{}, errs.Wrap(op, errNoActivation)
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate activation token", slog.Any("error", err))
+
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	expiresAt := a.clock.Now().Add(a.activationTTL)
+	if err := a.activations.SetActivationToken(ctx, userID, hashCode(token), expiresAt); err != nil {
+		logActivationError(log, err)
+
+		return models.Activation{}, errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "activation token reissued", "activation_reissued")
+
+	activation := models.Activation{UserID: userID, Token: token, ExpiresAt: expiresAt}
+	a.activationIssued(ctx, log, activation)
+
+	return activation, nil
+}
+
+// RevokeActivation revokes the activation token of a user pending
+// activation, which cannot activate the account until another is issued
+// by ReissueActivation.
+//
+// If the user does not exist, returns errs.ErrUserNotFound; if it is
+// activated already, errs.ErrAccountActivated.
+func (a *Auth) RevokeActivation(ctx context.Context, userID int64) error {
+	const op = "services.auth.RevokeActivation"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if a.activations == nil {
+		return errs.Wrap(op, errNoActivation)
+	}
+
+	if err := a.activations.SetActivationToken(ctx, userID, "", time.Time{}); err != nil {
+		logActivationError(log, err)
+
+		return errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "activation token revoked", "activation_revoked")
+
+	return nil
+}
+
+// ActivateAccount sets the password of the pre-registered account token
+// was issued for and returns its ID. The token is used up.
+//
+// If the token is unknown, expired, revoked or used, returns
+// errs.ErrInvalidActivationToken.
+func (a *Auth) ActivateAccount(ctx context.Context, token string, password string) (int64, error) {
+	const op = "services.auth.ActivateAccount"
+
+	if err := sanitize(input{"password", &password}); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	if a.activations == nil {
+		return 0, errs.Wrap(op, errNoActivation)
+	}
+	if token == "" {
+		return 0, errs.Wrap(op, errs.New(errs.InvalidArgument, "activation token is required"))
+	}
+	if password == "" {
+		return 0, errs.Wrap(op, errs.New(errs.InvalidArgument, "password is required"))
+	}
+
+	passHash, err := a.hashPassword(ctx, password)
+	if err != nil {
+		log.Error("failed to generate password hash", slog.Any("error", err))
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := a.activations.ActivateUser(ctx, hashCode(token), passHash, a.clock.Now())
+	if err != nil {
+		if errors.Is(err, errs.ErrInvalidActivationToken) {
+			log.Warn("invalid activation token")
+		} else {
+			log.Error("failed to activate user", slog.Any("error", err))
+		}
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "account activated", "account_activated", slog.Int64("user_id", id))
+	a.publish(ctx, log, models.EventUserActivated, id, 0, struct{}{})
+
+	return id, nil
+}
+
+// activationIssued publishes the expiry of a new activation token. The
+// token itself stays out: the event goes to the webhooks of every app, and
+// whoever holds the token can set the password of the account.
+func (a *Auth) activationIssued(ctx context.Context, log *slog.Logger, activation models.Activation) {
+	a.publish(ctx, log, models.EventActivationIssued, activation.UserID, 0, struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}{activation.ExpiresAt.UTC()})
+}
+
+func logActivationError(log *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, errs.ErrUserNotFound):
+		log.Warn("user not found")
+	case errors.Is(err, errs.ErrAccountActivated):
+		log.Warn("account is already activated")
+	default:
+		log.Error("failed to set activation token", slog.Any("error", err))
+	}
+}
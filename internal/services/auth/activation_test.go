@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestActivationAuth(t *testing.T) (*Auth, *clock.Fake) {
+	t.Helper()
+
+	a, _, clk := newTestAuthWithApp(t,
+		withStorage(func(s ActivationStorage) Option { return WithActivation(s, time.Hour) }),
+	)
+
+	return a, clk
+}
+
+func TestActivateAccount(t *testing.T) {
+	ctx := context.Background()
+	a, clk := newTestActivationAuth(t)
+
+	activation, err := a.PreRegisterUser(ctx, "Student@Example.com", "Jane", "Doe", "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, activation.Token)
+	assert.True(t, clk.Now().Add(time.Hour).Equal(activation.ExpiresAt))
+
+	_, err = a.PreRegisterUser(ctx, "student@example.com", "Jane", "Doe", "")
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+
+	_, err = a.Login(ctx, "student@example.com", "", 1)
+	assert.ErrorIs(t, err, errs.ErrAccountNotActivated)
+
+	_, err = a.ActivateAccount(ctx, "not-the-token", "new-password")
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+
+	id, err := a.ActivateAccount(ctx, activation.Token, "new-password")
+	require.NoError(t, err)
+	assert.Equal(t, activation.UserID, id)
+
+	_, err = a.ActivateAccount(ctx, activation.Token, "other-password")
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+
+	_, err = a.Login(ctx, "student@example.com", "new-password", 1)
+	require.NoError(t, err)
+
+	_, err = a.ReissueActivation(ctx, id)
+	assert.ErrorIs(t, err, errs.ErrAccountActivated)
+}
+
+func TestActivateAccount_Expired(t *testing.T) {
+	ctx := context.Background()
+	a, clk := newTestActivationAuth(t)
+
+	activation, err := a.PreRegisterUser(ctx, "student@example.com", "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	clk.Advance(time.Hour)
+	_, err = a.ActivateAccount(ctx, activation.Token, "new-password")
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+}
+
+func TestReissueActivation(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestActivationAuth(t)
+
+	first, err := a.PreRegisterUser(ctx, "student@example.com", "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	require.NoError(t, a.RevokeActivation(ctx, first.UserID))
+	_, err = a.ActivateAccount(ctx, first.Token, "new-password")
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+
+	second, err := a.ReissueActivation(ctx, first.UserID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Token, second.Token)
+
+	_, err = a.ActivateAccount(ctx, second.Token, "new-password")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, a.RevokeActivation(ctx, first.UserID+1), errs.ErrUserNotFound)
+}
+
+func TestPreRegisterUser_NotConfigured(t *testing.T) {
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.PreRegisterUser(context.Background(), "student@example.com", "Jane", "Doe", "")
+	assert.ErrorIs(t, err, errNoActivation)
+}
+
+func TestPreRegisterUser_EventLeavesTokenOut(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t,
+		withStorage(func(s ActivationStorage) Option { return WithActivation(s, time.Hour) }),
+		withStorage(WithEvents),
+	)
+
+	activation, err := a.PreRegisterUser(ctx, "student@example.com", "Jane", "Doe", "")
+	require.NoError(t, err)
+	reissued, err := a.ReissueActivation(ctx, activation.UserID)
+	require.NoError(t, err)
+
+	events, err := storage.Events(ctx, 0, 100)
+	require.NoError(t, err)
+	issued := 0
+	for _, event := range events {
+		assert.NotContains(t, string(event.Payload), activation.Token)
+		assert.NotContains(t, string(event.Payload), reissued.Token)
+		if event.Type == models.EventActivationIssued {
+			issued++
+		}
+	}
+	assert.Equal(t, 2, issued)
+}
@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/metrics"
+)
+
+// AnomalyConfig flags logins that look like an attack within a sliding
+// Window: a spray, one client IP failing against SprayThreshold distinct
+// emails, or a targeted attack, one account attempted from
+// TargetThreshold distinct IPs.
+type AnomalyConfig struct {
+	Window          time.Duration
+	SprayThreshold  int
+	TargetThreshold int
+	// MaxTracked bounds the IPs and the accounts tracked at once, each;
+	// past it the ones tracked for longest are forgotten. History bounds
+	// the attempts remembered of every one of them.
+	MaxTracked int
+	History    int
+	// Escalate requires a CAPTCHA of the attempts on a flagged account,
+	// until one of them succeeds, see WithCaptcha.
+	Escalate bool
+}
+
+func (c AnomalyConfig) valid() bool {
+	return c.Window > 0 && c.SprayThreshold > 0 && c.TargetThreshold > 0 && c.MaxTracked > 0 && c.History > 0
+}
+
+// Anomaly scores a login attempt. Spray is the share of SprayThreshold
+// the distinct emails its IP failed against reach, Targeted the share of
+// TargetThreshold the distinct IPs attempting its account reach, both
+// capped at 1.
+type Anomaly struct {
+	Spray    float64
+	Targeted float64
+}
+
+// Score is the higher of the two ratios.
+func (a Anomaly) Score() float64 {
+	return max(a.Spray, a.Targeted)
+}
+
+// Flagged reports whether a threshold was reached.
+func (a Anomaly) Flagged() bool {
+	return a.Score() >= 1
+}
+
+// CaptchaVerifier verifies the CAPTCHA response of a client at remoteIP,
+// empty if unknown. It returns captcha.ErrFailed for a response that does
+// not pass.
+type CaptchaVerifier interface {
+	Verify(ctx context.Context, response, remoteIP string) error
+}
+
+// ring remembers the last values added, with when.
+type ring struct {
+	values []uint64
+	at     []time.Time
+	next   int
+}
+
+func (r *ring) add(v uint64, at time.Time) {
+	r.values[r.next], r.at[r.next] = v, at
+	r.next = (r.next + 1) % len(r.values)
+}
+
+// distinct counts the distinct values added since.
+func (r *ring) distinct(since time.Time) int {
+	seen := make(map[uint64]struct{}, len(r.values))
+	for i, v := range r.values {
+		if !r.at[i].IsZero() && !r.at[i].Before(since) {
+			seen[v] = struct{}{}
+		}
+	}
+
+	return len(seen)
+}
+
+// count counts the values added since.
+func (r *ring) count(since time.Time) int {
+	n := 0
+	for _, at := range r.at {
+		if !at.IsZero() && !at.Before(since) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// rings maps keys to rings, at most len(order) of them: a new key takes
+// the place of the one added longest ago.
+type rings struct {
+	history int
+	byKey   map[uint64]*ring
+	order   []uint64
+	used    []bool
+	next    int
+}
+
+func newRings(maxKeys, history int) *rings {
+	return &rings{
+		history: history,
+		byKey:   make(map[uint64]*ring, maxKeys),
+		order:   make([]uint64, maxKeys),
+		used:    make([]bool, maxKeys),
+	}
+}
+
+func (rs *rings) get(key uint64) *ring {
+	if r, ok := rs.byKey[key]; ok {
+		return r
+	}
+
+	if rs.used[rs.next] {
+		delete(rs.byKey, rs.order[rs.next])
+	}
+	rs.order[rs.next], rs.used[rs.next] = key, true
+	rs.next = (rs.next + 1) % len(rs.order)
+
+	r := &ring{values: make([]uint64, rs.history), at: make([]time.Time, rs.history)}
+	rs.byKey[key] = r
+
+	return r
+}
+
+// loginTracker correlates the login attempts by client IP and by email,
+// in memory bounded by AnomalyConfig. Emails and IPs are kept only as
+// hashes.
+type loginTracker struct {
+	cfg  AnomalyConfig
+	seed maphash.Seed
+
+	mu sync.Mutex
+	// failures holds the emails every IP failed against, attempts the
+	// IPs every account was attempted from.
+	failures *rings
+	attempts *rings
+	// escalated holds the accounts that must pass a CAPTCHA.
+	escalated *rings
+
+	flagged *metrics.Counter
+}
+
+func newLoginTracker(cfg AnomalyConfig) *loginTracker {
+	if !cfg.valid() {
+		// Refused by NewService.
+		return &loginTracker{cfg: cfg}
+	}
+
+	return &loginTracker{
+		cfg:       cfg,
+		seed:      maphash.MakeSeed(),
+		failures:  newRings(cfg.MaxTracked, cfg.History),
+		attempts:  newRings(cfg.MaxTracked, cfg.History),
+		escalated: newRings(cfg.MaxTracked, 1),
+		flagged:   metrics.NewCounter("auth_login_anomalies_total"),
+	}
+}
+
+func (t *loginTracker) hash(s string) uint64 {
+	return maphash.String(t.seed, s)
+}
+
+// attempt records an attempt on email from ip and scores it.
+func (t *loginTracker) attempt(ip, email string, at time.Time) Anomaly {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts.get(t.hash(email)).add(t.hash(ip), at)
+
+	return t.score(ip, email, at)
+}
+
+// fail records that the attempt on email from ip failed and scores it
+// again.
+func (t *loginTracker) fail(ip, email string, at time.Time) Anomaly {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if ip != "" {
+		t.failures.get(t.hash(ip)).add(t.hash(email), at)
+	}
+
+	return t.score(ip, email, at)
+}
+
+func (t *loginTracker) score(ip, email string, at time.Time) Anomaly {
+	since := at.Add(-t.cfg.Window)
+
+	var a Anomaly
+	if r, ok := t.failures.byKey[t.hash(ip)]; ok && ip != "" {
+		a.Spray = min(float64(r.distinct(since))/float64(t.cfg.SprayThreshold), 1)
+	}
+	if r, ok := t.attempts.byKey[t.hash(email)]; ok {
+		a.Targeted = min(float64(r.distinct(since))/float64(t.cfg.TargetThreshold), 1)
+	}
+
+	return a
+}
+
+func (t *loginTracker) escalate(email string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.escalated.get(t.hash(email)).add(1, at)
+}
+
+// isEscalated reports whether email was escalated within the window.
+func (t *loginTracker) isEscalated(email string, at time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	r, ok := t.escalated.byKey[t.hash(email)]
+
+	return ok && r.distinct(at.Add(-t.cfg.Window)) > 0
+}
+
+func (t *loginTracker) clear(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if r, ok := t.escalated.byKey[t.hash(email)]; ok {
+		clear(r.at)
+	}
+}
+
+// anomalyKey carries the Anomaly of a login to the event of its success.
+type anomalyKey struct{}
+
+// trackAttempt records a login attempt on email and returns the context
+// carrying its score, see anomalyFrom.
+func (a *Auth) trackAttempt(ctx context.Context, log *slog.Logger, email string) context.Context {
+	if a.anomalies == nil {
+		return ctx
+	}
+
+	ip := clientIP(ctx)
+	anomaly := a.anomalies.attempt(ip, emailaddr.Normalize(email), a.clock.Now())
+	a.flagAnomaly(ctx, log, email, anomaly)
+
+	return context.WithValue(ctx, anomalyKey{}, anomaly)
+}
+
+// trackFailure records that the login attempt on email was refused for
+// its credentials.
+func (a *Auth) trackFailure(ctx context.Context, log *slog.Logger, email string) {
+	if a.anomalies == nil {
+		return
+	}
+
+	anomaly := a.anomalies.fail(clientIP(ctx), emailaddr.Normalize(email), a.clock.Now())
+	a.flagAnomaly(ctx, log, email, anomaly)
+}
+
+// trackSuccess lifts the CAPTCHA requirement of email and forgets its
+// failures in the tarpit.
+func (a *Auth) trackSuccess(email string) {
+	if a.anomalies != nil {
+		a.anomalies.clear(emailaddr.Normalize(email))
+	}
+	if a.tarpit != nil {
+		a.tarpit.clear(emailaddr.Normalize(email))
+	}
+}
+
+// flagAnomaly audits a flagged attempt and escalates its account, if
+// configured.
+func (a *Auth) flagAnomaly(ctx context.Context, log *slog.Logger, email string, anomaly Anomaly) {
+	if !anomaly.Flagged() {
+		return
+	}
+
+	a.anomalies.flagged.Inc()
+	escalate := a.anomalies.cfg.Escalate && a.captcha != nil
+	audit.Log(ctx, log, slog.LevelWarn, "login attempt looks like an attack", "login_anomaly",
+		slog.Float64("anomaly_score", anomaly.Score()),
+		slog.Bool("spray", anomaly.Spray >= 1),
+		slog.Bool("targeted", anomaly.Targeted >= 1),
+		slog.Bool("captcha_required", escalate),
+	)
+	if escalate {
+		a.anomalies.escalate(emailaddr.Normalize(email), a.clock.Now())
+	}
+}
+
+// checkCaptcha returns errs.ErrCaptchaRequired if email was escalated and
+// the call carries no CAPTCHA response that passes, see captcha.NewContext.
+func (a *Auth) checkCaptcha(ctx context.Context, log *slog.Logger, email string) error {
+	if a.anomalies == nil || a.captcha == nil || !a.anomalies.isEscalated(emailaddr.Normalize(email), a.clock.Now()) {
+		return nil
+	}
+
+	err := a.captcha.Verify(ctx, captcha.FromContext(ctx), clientIP(ctx))
+	switch {
+	case errors.Is(err, captcha.ErrFailed):
+		log.Info("captcha required")
+
+		return errs.ErrCaptchaRequired
+	case err != nil:
+		log.Error("failed to verify captcha", slog.Any("error", err))
+
+		return err
+	}
+
+	return nil
+}
+
+// anomalyFrom returns the score of the login attempt ctx is of, if its
+// attempts are tracked.
+func anomalyFrom(ctx context.Context) (Anomaly, bool) {
+	anomaly, ok := ctx.Value(anomalyKey{}).(Anomaly)
+
+	return anomaly, ok
+}
+
+// LoginAnomalies returns the number of login attempts flagged since the
+// start.
+func (a *Auth) LoginAnomalies() int64 {
+	if a.anomalies == nil {
+		return 0
+	}
+
+	return a.anomalies.flagged.Value()
+}
+
+func clientIP(ctx context.Context) string {
+	if ip, ok := clientip.FromContext(ctx); ok {
+		return ip.String()
+	}
+
+	return ""
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCaptcha passes the response "solved" only.
+type fakeCaptcha struct{ calls int }
+
+func (c *fakeCaptcha) Verify(_ context.Context, response, _ string) error {
+	c.calls++
+	if response != "solved" {
+		return captcha.ErrFailed
+	}
+
+	return nil
+}
+
+var testAnomalies = AnomalyConfig{
+	Window:          time.Minute,
+	SprayThreshold:  3,
+	TargetThreshold: 3,
+	MaxTracked:      100,
+	History:         8,
+}
+
+func newAnomalyAuth(t *testing.T, cfg AnomalyConfig, opts ...Option) (*Auth, *memory.Storage, *clock.Fake) {
+	t.Helper()
+
+	a, storage, clk := newTestAuthWithApp(t, append([]Option{WithLoginAnomalies(cfg)}, opts...)...)
+	a.events = storage
+
+	return a, storage, clk
+}
+
+func fromIP(ip string) context.Context {
+	return clientip.NewContext(context.Background(), netip.MustParseAddr(ip))
+}
+
+func TestLoginAnomaly_Spray(t *testing.T) {
+	a, _, clk := newAnomalyAuth(t, testAnomalies)
+	ctx := fromIP("203.0.113.7")
+
+	// The same wrong passwords against another email each.
+	for i := range testAnomalies.SprayThreshold - 1 {
+		_, err := a.Login(ctx, fmt.Sprintf("user%d@example.com", i), "password", 1)
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+	assert.Zero(t, a.LoginAnomalies())
+
+	// Retrying an email does not count twice, nor do other IPs.
+	_, _ = a.Login(ctx, "user0@example.com", "password", 1)
+	_, _ = a.Login(fromIP("198.51.100.1"), "user9@example.com", "password", 1)
+	assert.Zero(t, a.LoginAnomalies())
+
+	_, _ = a.Login(ctx, "user9@example.com", "password", 1)
+	assert.Equal(t, int64(1), a.LoginAnomalies())
+
+	// Once out of the window the failures are forgotten.
+	clk.Advance(testAnomalies.Window + time.Second)
+	_, _ = a.Login(ctx, "user10@example.com", "password", 1)
+	assert.Equal(t, int64(1), a.LoginAnomalies())
+}
+
+func TestLoginAnomaly_Targeted(t *testing.T) {
+	a, storage, _ := newAnomalyAuth(t, testAnomalies)
+	ctx := context.Background()
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	for i := range testAnomalies.TargetThreshold - 1 {
+		_, err := a.Login(fromIP(fmt.Sprintf("198.51.100.%d", i+1)), "user@example.com", "wrong-password", 1)
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+	assert.Zero(t, a.LoginAnomalies())
+
+	// Attempts count whatever they end with; the address is normalized.
+	_, err = a.Login(fromIP("198.51.100.99"), "User@Example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.LoginAnomalies())
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.EventUserLoggedIn, events[1].Type)
+	assert.JSONEq(t, `{"anomaly_score":1}`, string(events[1].Payload))
+}
+
+func TestLoginAnomaly_Score(t *testing.T) {
+	a, storage, _ := newAnomalyAuth(t, testAnomalies)
+	_, err := a.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	ctx := fromIP("198.51.100.1")
+	_, err = a.Login(ctx, "other@example.com", "password", 1)
+	require.Error(t, err)
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	// One of the three failures of a spray, one of the three IPs of a
+	// targeted attack.
+	assert.JSONEq(t, fmt.Sprintf(`{"anomaly_score":%v}`, 1.0/3), string(events[1].Payload))
+}
+
+func TestLoginAnomaly_Escalate(t *testing.T) {
+	cfg := testAnomalies
+	cfg.Escalate = true
+	verifier := &fakeCaptcha{}
+	a, _, clk := newAnomalyAuth(t, cfg, WithCaptcha(verifier))
+	_, err := a.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	for i := range cfg.TargetThreshold {
+		_, err := a.Login(fromIP(fmt.Sprintf("198.51.100.%d", i+1)), "user@example.com", "wrong-password", 1)
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+	assert.Zero(t, verifier.calls, "the attempt that flags the account is not asked")
+
+	// Even the right password needs the CAPTCHA now.
+	ctx := fromIP("198.51.100.1")
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.ErrorIs(t, err, errs.ErrCaptchaRequired)
+	_, err = a.Login(captcha.NewContext(ctx, "wrong"), "user@example.com", "correct-password", 1)
+	require.ErrorIs(t, err, errs.ErrCaptchaRequired)
+
+	// Other accounts are not held to it.
+	_, err = a.Login(ctx, "other@example.com", "password", 1)
+	require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+
+	_, err = a.Login(captcha.NewContext(ctx, "solved"), "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	// The success lifts the requirement, until the account is flagged
+	// again.
+	calls := verifier.calls
+	clk.Advance(cfg.Window + time.Second)
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.Equal(t, calls, verifier.calls)
+}
+
+func TestLoginTracker_Bounded(t *testing.T) {
+	tracker := newLoginTracker(AnomalyConfig{
+		Window:          time.Hour,
+		SprayThreshold:  4,
+		TargetThreshold: 4,
+		MaxTracked:      2,
+		History:         3,
+	})
+	now := time.Now()
+
+	// A ring holds History values: of the four emails only three count.
+	for i := range 4 {
+		tracker.fail("203.0.113.7", fmt.Sprintf("user%d@example.com", i), now)
+	}
+	assert.Equal(t, 0.75, tracker.score("203.0.113.7", "", now).Spray)
+
+	// Past MaxTracked the IP tracked for longest is forgotten.
+	tracker.fail("203.0.113.8", "user@example.com", now)
+	tracker.fail("203.0.113.9", "user@example.com", now)
+	assert.Len(t, tracker.failures.byKey, 2)
+	assert.Zero(t, tracker.score("203.0.113.7", "", now).Spray)
+	assert.Equal(t, 0.25, tracker.score("203.0.113.9", "", now).Spray)
+}
+
+func TestNewService_InvalidAnomalies(t *testing.T) {
+	storage := memory.New()
+	cfg := testAnomalies
+	cfg.MaxTracked = 0
+
+	_, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage, WithLoginAnomalies(cfg))
+	assert.Error(t, err)
+}
@@ -2,24 +2,95 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
-	"fmt"
+	"iter"
 	"log/slog"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"sso/internal/domain/errs"
 	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/clientcert"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/emaildomain"
 	"sso/internal/lib/jwt"
-	"sso/internal/storage"
-
-	"golang.org/x/crypto/bcrypt"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/reserved"
 )
 
 type Auth struct {
-	log          *slog.Logger
-	userSaver    UserSaver
-	userProvider UserProvider
-	appProvider  AppProvider
-	tokenTTL     time.Duration
+	log            *slog.Logger
+	userSaver      UserSaver
+	userProvider   UserProvider
+	appProvider    AppProvider
+	authorizations AuthorizationStorage
+	loginHistory   LoginHistory
+	events         EventPublisher
+	hasher         Hasher
+	clock          clock.Clock
+	keys           *jwt.KeySet
+	hs256Until     time.Time
+	issuer         jwt.Issuer
+	tokenTTL       time.Duration
+	maxTokenTTL    time.Duration
+	termsVersion   string
+	enforceTerms   bool
+	leeway         time.Duration
+	invalidHashes  *metrics.Counter
+	stages         *stages
+	anomalies      *loginTracker
+	tarpit         *tarpit
+	captcha        CaptchaVerifier
+	readRetry      *readRetry
+	invites        InviteStorage
+	registration   atomic.Pointer[RegistrationMode]
+	emailDomains   atomic.Pointer[emaildomain.Policy]
+	reserved       atomic.Pointer[reserved.List]
+	canonical      *emailaddr.Canonicalizer
+	concealUsers   bool
+	failureFloor   time.Duration
+	failureJitter  time.Duration
+	identities     IdentityStorage
+	deletions      DeletionStorage
+	deletionGrace  time.Duration
+	challenges     ChallengeStorage
+	permissions    PermissionStorage
+	activations    ActivationStorage
+	activationTTL  time.Duration
+	appQuotas      AppQuotaStorage
+	registrations  RegistrationStorage
+	usage          AppUsageStorage
+	usageCounts    *usageCounts
+	// permissionCache holds the permissions resolved for tokens.
+	permissionCache *lookupCache[permissionKey]
+	orgUnits        OrgUnitStorage
+	// roles is nil unless set by WithRoles.
+	roles RoleStorage
+	// unitRoleCache holds the roles resolved by UserRolesInUnit.
+	unitRoleCache *lookupCache[unitRoleKey]
+	requestCache  bool
+	// hashDurations is nil unless set by WithHashDurations.
+	hashDurations *metrics.HistogramVec
+	// tokenCache is nil unless enabled by WithTokenCache.
+	tokenCache *tokenCache
+	// identityProviders are keyed by the name callers give them by.
+	identityProviders map[string]IdentityProvider
+	// issuances is nil unless set by WithTokenIssuances.
+	issuances         TokenIssuanceStorage
+	issuanceRetention time.Duration
+	// revocations is nil unless set by WithRevocations, which sets
+	// revoked too.
+	revocations   RevocationStorage
+	revoked       *revocationCache
+	revocationBus RevocationBus
+	// cursorKey signs the page tokens of ListUsers, see WithCursorKey.
+	cursorKey []byte
 }
 
 type UserSaver interface {
@@ -31,99 +102,352 @@ type UserSaver interface {
 		lastName string,
 		middleName string,
 	) (uid int64, err error)
+	SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error
+	AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error
 }
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	UserRole(ctx context.Context, userID int64) (string, error)
 	UserExists(ctx context.Context, userID int64) (bool, error)
+	UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+	UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error)
+	Users(ctx context.Context, filter models.UserFilter) iter.Seq2[models.UserRecord, error]
+	LastUserID(ctx context.Context) (int64, error)
 }
 
 type AppProvider interface {
-	App(ctx context.Context, appID int) (models.App, error)
+	App(ctx context.Context, appID int32) (models.App, error)
+	AppByName(ctx context.Context, name string) (models.App, error)
 }
 
+// Deprecated: these alias the errs sentinels and will be removed in the
+// next release. Use errs directly.
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrInvalidAppID       = errors.New("invalid app id")
-	ErrUserExists         = errors.New("user already exists")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidCredentials = errs.ErrInvalidCredentials
+	ErrInvalidAppID       = errs.ErrAppNotFound
+	ErrUserExists         = errs.ErrUserExists
+	ErrUserNotFound       = errs.ErrUserNotFound
+	ErrBatchTooLarge      = errs.ErrBatchTooLarge
+	ErrInvalidToken       = errs.ErrInvalidToken
 )
 
+// MaxBatchSize is the largest number of user IDs accepted by batch methods.
+const MaxBatchSize = 500
+
 // New returns a new instance of Auth service.
+//
+// Tokens are signed with RS256 using keys when it is not nil, and with the
+// secret of the app otherwise. issuer.Name is stamped into every token.
+// leeway is the clock skew tolerated when validating tokens.
+//
+// Deprecated: use NewService, which reports missing dependencies. New
+// panics where NewService returns an error.
 func New(
 	log *slog.Logger,
 	userSaver UserSaver,
 	userProvider UserProvider,
 	appProvider AppProvider,
+	authorizations AuthorizationStorage,
+	keys *jwt.KeySet,
+	issuer jwt.Issuer,
 	tokenTTL time.Duration,
+	leeway time.Duration,
 ) *Auth {
-	return &Auth{
-		userSaver:    userSaver,
-		userProvider: userProvider,
-		log:          log,
-		appProvider:  appProvider,
-		tokenTTL:     tokenTTL,
+	a, err := NewService(log, userSaver, userProvider, appProvider,
+		WithAuthorizations(authorizations),
+		WithSigningKeys(keys),
+		WithIssuer(issuer),
+		WithTokenTTL(tokenTTL),
+		WithLeeway(leeway),
+	)
+	if err != nil {
+		panic(err)
 	}
+
+	return a
 }
 
 // Login checks if user with given credentials exists in the system.
 //
+// If app does not exist, returns errs.ErrAppNotFound.
 // If user exists, but password is incorrect, returns error.
 // If user does not exist, returns error.
+// If the deletion of the account is scheduled, returns
+// errs.ErrAccountPendingDeletion with the deadline.
+// An email or password that is not valid UTF-8 or has a control character
+// returns errs.InvalidArgument; byte order marks are stripped.
+//
+// With WithFailureFloor a failure returns no sooner than the floor after
+// the call started, whatever failed, so cache hits and misses cannot be
+// told apart by timing. Successful logins are never delayed. With
+// WithTarpit repeated failures of errs.ErrInvalidCredentials are delayed
+// further, or carry a retry_after when the deadline is too close.
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
 	password string,
-	appID int,
+	appID int32,
+) (string, error) {
+	return a.checkedLogin(ctx, email, password, appID, a.issueLogin)
+}
+
+// issueFunc completes a login that passed every check, such as with a
+// token, see issueLogin.
+type issueFunc func(ctx context.Context, log *slog.Logger, user models.User, app models.App) (string, error)
+
+// checkedLogin logs the user in to appID, completed by issue, with every
+// check of Login: the inputs are sanitized, failures count towards
+// anomalies and the tarpit, and are padded to the failure floor.
+func (a *Auth) checkedLogin(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int32,
+	issue issueFunc,
+) (string, error) {
+	if err := sanitize(input{"email", &email}, input{"password", &password}); err != nil {
+		return "", errs.Wrap("services.auth.Login", err)
+	}
+
+	start := a.clock.Now()
+
+	token, err := a.login(ctx, email, password, appID, issue)
+	log := withClientIP(ctx, a.log.With(slog.String("op", "services.auth.Login")))
+
+	return token, a.settleAttempt(ctx, log, email, start, err)
+}
+
+// settleAttempt completes a password attempt on email started at start
+// and returns its error: a success forgets the failures of email, wrong
+// credentials count towards anomalies and the tarpit, and any failure is
+// padded to the failure floor.
+func (a *Auth) settleAttempt(ctx context.Context, log *slog.Logger, email string, start time.Time, err error) error {
+	switch {
+	case err == nil:
+		a.trackSuccess(email)
+	case errors.Is(err, errs.ErrInvalidCredentials):
+		a.trackFailure(ctx, log, email)
+		err = a.tarpitFailure(ctx, log, email, err)
+	}
+	if err != nil {
+		a.padFailure(ctx, start)
+	}
+
+	return err
+}
+
+func (a *Auth) login(
+	ctx context.Context,
+	email string,
+	password string,
+	appID int32,
+	issue issueFunc,
 ) (string, error) {
 	const op = "services.auth.Login"
 
-	log := a.log.With(
+	log := withClientIP(ctx, a.log.With(
 		slog.String("op", op),
-	)
+	))
 
 	log.Info("attempting to login user")
 
-	user, err := a.userProvider.User(ctx, email)
+	if appID < 0 {
+		return "", errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+
+	// The app is resolved first: a request for an unknown app is never
+	// going to succeed, so there is no point in spending a bcrypt
+	// comparison on it.
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("app not found", slog.Int("app_id", int(appID)))
+
+			return "", errs.Wrap(op, errs.ErrAppNotFound)
+		}
+
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	if err := a.checkCaptcha(ctx, log, email); err != nil {
+		return "", errs.Wrap(op, err)
+	}
+	ctx = a.trackAttempt(ctx, log, email)
+
+	user, err := a.userByEmail(ctx, email)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			a.log.Warn("user not found", slog.Any("error", err))
+		if errors.Is(err, errs.ErrUserNotFound) {
+			log.Warn("user not found", slog.Any("error", err))
+			a.recordLogin(ctx, log, 0, appID, false)
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			return "", errs.Wrap(op, errs.ErrInvalidCredentials)
 		}
 
-		a.log.Error("failed to get user", slog.Any("error", err))
+		log.Error("failed to get user", slog.Any("error", err))
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", errs.Wrap(op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		a.log.Info("invalid credentials", slog.Any("error", err))
+	// Checked before the password, which a pre-registered account does
+	// not have yet.
+	if user.ActivationPending {
+		log.Info("account is not activated", slog.Int64("user_id", user.ID))
+		a.recordLogin(ctx, log, user.ID, appID, false)
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return "", errs.Wrap(op, errs.ErrAccountNotActivated)
 	}
 
-	app, err := a.appProvider.App(ctx, appID)
-	a.log.Debug("app contains", slog.Any("app", app))
-	a.log.Debug("error is", slog.Any("error", err))
+	if err := a.checkPassword(ctx, log, user, password); err != nil {
+		a.recordLogin(ctx, log, user.ID, appID, false)
+
+		return "", errs.Wrap(op, err)
+	}
+
+	// Checked after the password, so only the owner learns of it.
+	if !user.DeleteAfter.IsZero() {
+		log.Info("account is pending deletion", slog.Time("delete_after", user.DeleteAfter))
+		a.recordLogin(ctx, log, user.ID, appID, false)
+
+		return "", errs.Wrap(op, pendingDeletion(user.DeleteAfter))
+	}
+
+	if typ, ok := a.nextChallenge(user); ok {
+		return "", errs.Wrap(op, a.newChallenge(ctx, log, user, appID, typ))
+	}
+	if a.termsOutdated(user) && a.enforceTerms {
+		log.Info("terms of service not accepted", slog.String("accepted_version", user.TermsVersion))
+		a.recordLogin(ctx, log, user.ID, appID, false)
+
+		return "", errs.Wrap(op, errs.ErrTermsReacceptRequired)
+	}
+
+	token, err := issue(ctx, log, user, app)
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	return token, nil
+}
+
+// issueLogin returns the token of a login that passed every check and
+// records it.
+func (a *Auth) issueLogin(ctx context.Context, log *slog.Logger, user models.User, app models.App) (string, error) {
+	opts, err := a.permissionClaims(ctx, log, user.ID, app.ID)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", err
+	}
+	if a.termsOutdated(user) {
+		opts = append(opts, jwt.TermsReacceptRequired())
+	}
+	// Logins of binding apps over plain TLS get unbound tokens: the app
+	// decides whether to accept them by how it reaches the SSO.
+	if thumbprint, ok := clientcert.FromContext(ctx); ok && app.BindTokens {
+		opts = append(opts, jwt.CertBinding(thumbprint))
 	}
 
 	log.Info("user logged in successfully")
 
-	token, err := jwt.GenerateNewToken(user, app, a.tokenTTL)
+	ttl, source := a.resolveTokenTTL(user, app)
+	token, err := a.newToken(ctx, models.TokenLogin, user, app, ttl, append(opts, jwt.TTLSource(source))...)
 	if err != nil {
-		a.log.Error("failed to generate token", slog.Any("error", err))
+		log.Error("failed to generate token", slog.Any("error", err))
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", err
 	}
+	a.recordLogin(ctx, log, user.ID, app.ID, true)
+	a.countUsage(app.ID, models.AppUsage{Logins: 1, TokensIssued: 1})
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID, loginEvent(ctx))
+
 	return token, nil
 }
 
-// RegisterNewUser registers new user in the system and returns userID
-// If user with given email already exists, returns error.
+// loginEvent is the payload of models.EventUserLoggedIn.
+func loginEvent(ctx context.Context) any {
+	anomaly, ok := anomalyFrom(ctx)
+	if !ok {
+		return struct{}{}
+	}
+
+	return struct {
+		AnomalyScore float64 `json:"anomaly_score"`
+	}{AnomalyScore: anomaly.Score()}
+}
+
+// ResolveApp returns the ID of the app named appName, for clients that
+// know their app by name. A non-zero appID must refer to the same app.
+// Without a name appID is returned as is: Login checks it anyway.
+func (a *Auth) ResolveApp(ctx context.Context, appID int32, appName string) (int32, error) {
+	const op = "services.auth.ResolveApp"
+
+	if appID < 0 {
+		return 0, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+	if appName == "" {
+		return appID, nil
+	}
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	app, err := a.appProvider.AppByName(ctx, appName)
+	if err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("app not found", slog.String("app_name", appName))
+
+			return 0, errs.Wrap(op, errs.ErrAppNotFound)
+		}
+
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	if appID != 0 && appID != app.ID {
+		log.Warn("app_id and app_name mismatch", slog.Int("app_id", int(appID)), slog.String("app_name", appName))
+
+		return 0, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id and app_name refer to different apps"))
+	}
+
+	return app.ID, nil
+}
+
+// RegisterNewUser registers new user in the system and returns its
+// profile. If user with given email already exists, returns error. The
+// email is stored normalized, see emailaddr.Normalize. Each name must fit
+// in MaxNameRunes characters and MaxNameBytes bytes. Like those of Login,
+// inputs with a control character are refused and byte order marks
+// stripped.
+//
+// When terms of service are configured, tosVersion must be the required
+// version, otherwise returns errs.ErrTermsVersionMismatch; the acceptance
+// is recorded with the user.
+//
+// While registration is closed, returns errs.ErrRegistrationDisabled.
+// While it is invite-only, inviteCode must be a usable invite, which
+// loses one use: a missing code gives errs.ErrInviteRequired, an expired
+// invite errs.ErrInviteExpired and any other unusable one
+// errs.ErrInvalidInvite. Otherwise inviteCode is ignored.
+//
+// If the domain of email is not allowed, see WithEmailDomains, returns
+// errs.ErrEmailDomainNotAllowed; if its local part is reserved, see
+// WithReservedIdentifiers, errs.ErrReservedIdentifier.
+//
+// If the email is taken, returns errs.ErrUserExists; if its canonical
+// form is another user's, see WithCanonicalEmails,
+// errs.ErrCanonicalEmailExists.
+//
+// A non-zero appID attributes the user to the app, which needs
+// WithAppQuotas. If the app does not exist, returns errs.ErrAppNotFound;
+// if its quota is reached, see SetAppMaxUsers, errs.ErrQuotaExceeded.
+//
+// With WithConcealedRegistration, returns a zero user and no error both
+// when the user is created and when the email is taken; the owner of a
+// taken email is told through an models.EventRegistrationAttempted event.
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
 	email string,
@@ -131,41 +455,203 @@ func (a *Auth) RegisterNewUser(
 	firstName string,
 	lastName string,
 	middleName string,
-) (int64, error) {
+	tosVersion string,
+	inviteCode string,
+	appID int32,
+) (models.NewUser, error) {
 	const op = "services.auth.RegisterNewUser"
 
-	log := a.log.With(
+	if err := sanitize(
+		input{"email", &email},
+		input{"password", &password},
+		input{"first_name", &firstName},
+		input{"last_name", &lastName},
+		input{"middle_name", &middleName},
+		input{"tos_version", &tosVersion},
+		input{"invite_code", &inviteCode},
+	); err != nil {
+		return models.NewUser{}, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(
 		slog.String("op", op),
-	)
+	))
 
 	log.Info("registering user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	email = emailaddr.Normalize(email)
+	// A blank middle name is no middle name.
+	middleName = strings.TrimSpace(middleName)
+
+	mode := a.RegistrationMode()
+	if mode == RegistrationClosed {
+		log.Warn("registration is disabled")
+
+		return models.NewUser{}, errs.Wrap(op, errs.ErrRegistrationDisabled)
+	}
+
+	if !a.emailDomains.Load().Allowed(email) {
+		log.Warn("email domain is not allowed", slog.String("domain", emaildomain.Of(email)))
+
+		return models.NewUser{}, errs.Wrap(op, errs.ErrEmailDomainNotAllowed)
+	}
+	if entry, ok := a.reserved.Load().MatchEmail(email); ok {
+		log.Warn("email is reserved", slog.String("entry", entry))
+
+		return models.NewUser{}, errs.Wrap(op, errs.ErrReservedIdentifier)
+	}
+
+	if appID < 0 {
+		return models.NewUser{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must not be negative"))
+	}
+	if appID != 0 && a.appQuotas == nil {
+		return models.NewUser{}, errs.Wrap(op, errNoAppQuotas)
+	}
+	if err := validateNames(firstName, lastName, middleName); err != nil {
+		return models.NewUser{}, errs.Wrap(op, err)
+	}
+
+	if a.termsVersion != "" && tosVersion != a.termsVersion {
+		log.Warn("terms of service version mismatch", slog.String("tos_version", tosVersion))
+
+		return models.NewUser{}, errs.Wrap(op, errs.ErrTermsVersionMismatch)
+	}
+
+	var invite models.Invite
+	if mode == RegistrationInvite {
+		var err error
+		if invite, err = a.invite(ctx, log, inviteCode, email); err != nil {
+			return models.NewUser{}, errs.Wrap(op, err)
+		}
+	}
+
+	passHash, err := a.hashPassword(ctx, password)
 	if err != nil {
 		log.Error("failed to generate password hash", slog.Any("error", err))
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return models.NewUser{}, errs.Wrap(op, err)
 	}
 
-	id, err := a.userSaver.SaveUser(ctx, email, passHash, firstName, lastName, middleName)
+	reg := models.Registration{
+		Email:        email,
+		PassHash:     passHash,
+		FirstName:    firstName,
+		LastName:     lastName,
+		MiddleName:   middleName,
+		AppID:        appID,
+		InviteID:     invite.ID,
+		RegisteredAt: a.clock.Now(),
+	}
+	if a.termsVersion != "" {
+		reg.TermsVersion = tosVersion
+	}
+	if a.canonical != nil {
+		reg.CanonicalEmail = a.canonical.Canonical(email)
+	}
+
+	id, err := a.saveRegistration(ctx, log, reg)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserExists) {
+		if errors.Is(err, errs.ErrCanonicalEmailExists) {
+			log.Warn("user with the canonical email already exists", slog.String("canonical_email", reg.CanonicalEmail))
+			// The owner is not told: the email they registered with
+			// is another.
+			if a.concealUsers {
+				return models.NewUser{}, nil
+			}
+
+			return models.NewUser{}, errs.Wrap(op, errs.ErrCanonicalEmailExists)
+		}
+		if errors.Is(err, errs.ErrUserExists) {
 			log.Warn("user already exists", slog.Any("error", err))
+			if a.concealUsers {
+				a.registrationAttempted(ctx, log, email)
+
+				return models.NewUser{}, nil
+			}
 
-			return 0, fmt.Errorf("%s: %w", op, ErrUserExists)
+			return models.NewUser{}, errs.Wrap(op, errs.ErrUserExists)
+		}
+		// Another registration took the last use in the meantime.
+		if errors.Is(err, errs.ErrInviteUsedUp) || errors.Is(err, errs.ErrInviteNotFound) {
+			log.Warn("invite used up", slog.Int64("invite_id", invite.ID))
+
+			return models.NewUser{}, errs.Wrap(op, errs.ErrInvalidInvite)
+		}
+		if errors.Is(err, errs.ErrQuotaExceeded) || errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("app refused the registration", slog.Int("app_id", int(appID)), slog.Any("error", err))
+
+			return models.NewUser{}, errs.Wrap(op, err)
 		}
 
 		log.Error("failed to save user", slog.Any("error", err))
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return models.NewUser{}, errs.Wrap(op, err)
 	}
 
+	if invite.ID != 0 {
+		audit.Log(ctx, log, slog.LevelInfo, "invite used", "invite_used",
+			slog.Int64("invite_id", invite.ID),
+			slog.Int64("user_id", id),
+		)
+	}
 	log.Info("user registered", slog.Int64("userID", id))
+	if err := a.registered(ctx, log.With(slog.Int64("user_id", id)), reg, id); err != nil {
+		return models.NewUser{}, errs.Wrap(op, err)
+	}
+
+	if a.concealUsers {
+		return models.NewUser{}, nil
+	}
+
+	return a.newUser(ctx, log, reg, id), nil
+}
+
+// newUser returns the profile of the user id just registered with reg. The
+// user is read back for what the storage set; should that fail, the
+// profile is made of reg, as the user exists anyway.
+func (a *Auth) newUser(ctx context.Context, log *slog.Logger, reg models.Registration, id int64) models.NewUser {
+	user, err := a.userProvider.UserByID(ctx, id)
+	if err != nil {
+		log.Warn("failed to read back registered user", slog.Any("error", err))
+		user = models.User{
+			ID:           id,
+			Email:        reg.Email,
+			FirstName:    reg.FirstName,
+			LastName:     reg.LastName,
+			MiddleName:   reg.MiddleName,
+			TermsVersion: reg.TermsVersion,
+			CreatedAt:    reg.RegisteredAt,
+			UpdatedAt:    reg.RegisteredAt,
+		}
+	}
+	user.PassHash = nil
+
+	role, err := a.userProvider.UserRole(ctx, id)
+	if err != nil && !errors.Is(err, errs.ErrRoleNotFound) {
+		log.Warn("failed to read role of registered user", slog.Any("error", err))
+	}
+
+	return models.NewUser{User: user, Role: role}
+}
+
+// userByEmail returns the user of email. Emails are stored normalized,
+// except those of users registered before that and not yet migrated with
+// ssoctl emails normalize, which are matched as given.
+func (a *Auth) userByEmail(ctx context.Context, email string) (models.User, error) {
+	normalized := emailaddr.Normalize(email)
 
-	return id, nil
+	user, err := a.userProvider.User(ctx, normalized)
+	if errors.Is(err, errs.ErrUserNotFound) && normalized != email {
+		return a.userProvider.User(ctx, email)
+	}
+
+	return user, err
 }
 
 // UserRole returns role of user with given ID.
+//
+// If user does not exist, returns errs.ErrUserNotFound.
+// If user exists but has no role, returns an empty role and no error.
 func (a *Auth) UserRole(
 	ctx context.Context,
 	userID int64,
@@ -176,18 +662,27 @@ func (a *Auth) UserRole(
 		slog.String("op", op),
 	)
 
-	log.Info("checking if user is admin")
+	log.Info("checking user role")
+
+	if userID < 0 {
+		return "", errs.Wrap(op, errs.New(errs.InvalidArgument, "user_id must be positive"))
+	}
 
 	userRole, err := a.userProvider.UserRole(ctx, userID)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
+		if errors.Is(err, errs.ErrUserNotFound) {
 			log.Warn("user not found", slog.Any("error", err))
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+			return "", errs.Wrap(op, errs.ErrUserNotFound)
+		}
+		if errors.Is(err, errs.ErrRoleNotFound) {
+			log.Info("user has no role")
+
+			return "", nil
 		}
 		log.Error("failed to check role of the user", slog.Any("error", err))
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", errs.Wrap(op, err)
 	}
 
 	log.Info("checked user role", slog.String("user_role", userRole))
@@ -207,13 +702,272 @@ func (a *Auth) UserExists(
 
 	log.Info("checking if user exists")
 
+	if userID < 0 {
+		return false, errs.Wrap(op, errs.New(errs.InvalidArgument, "user_id must be positive"))
+	}
+
 	userExists, err := a.userProvider.UserExists(ctx, userID)
 	if err != nil {
 		log.Error("failed to check if user exists", slog.Any("error", err))
-		return false, fmt.Errorf("%s: %w", op, err)
+		return false, errs.Wrap(op, err)
 	}
 
 	log.Info("checked if user exists", slog.Bool("user_exists", userExists))
 
 	return userExists, nil
 }
+
+// UsersExist reports for every given user ID whether the user exists.
+// The result has an entry for each requested ID.
+//
+// If more than MaxBatchSize IDs are given, returns errs.ErrBatchTooLarge.
+func (a *Auth) UsersExist(
+	ctx context.Context,
+	userIDs []int64,
+) (map[int64]bool, error) {
+	const op = "services.auth.UsersExist"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if len(userIDs) > MaxBatchSize {
+		log.Warn("batch too large", slog.Int("size", len(userIDs)))
+
+		return nil, errs.Wrap(op, errs.ErrBatchTooLarge)
+	}
+
+	log.Info("checking if users exist", slog.Int("size", len(userIDs)))
+
+	exist, err := a.userProvider.UsersExist(ctx, userIDs)
+	if err != nil {
+		log.Error("failed to check if users exist", slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	return exist, nil
+}
+
+// UserRoles returns the role of every given user. The result has an entry
+// for each requested ID; users that do not exist or have no role get an
+// empty role.
+//
+// If more than MaxBatchSize IDs are given, returns errs.ErrBatchTooLarge.
+func (a *Auth) UserRoles(
+	ctx context.Context,
+	userIDs []int64,
+) (map[int64]string, error) {
+	const op = "services.auth.UserRoles"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	if len(userIDs) > MaxBatchSize {
+		log.Warn("batch too large", slog.Int("size", len(userIDs)))
+
+		return nil, errs.Wrap(op, errs.ErrBatchTooLarge)
+	}
+
+	log.Info("checking user roles", slog.Int("size", len(userIDs)))
+
+	roles, err := a.userProvider.UserRoles(ctx, userIDs)
+	if err != nil {
+		log.Error("failed to check user roles", slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	return roles, nil
+}
+
+// appSecret returns how ValidateToken finds the secret of the app an
+// HS256 token is for. With RS256 signing keys app secrets verify nothing:
+// every app client holds its own and could sign tokens of any user with
+// it. Only during the rollover of WithHS256Rollover are they still asked.
+func (a *Auth) appSecret(ctx context.Context) func(appID int32) (string, error) {
+	if a.keys != nil && !a.clock.Now().Before(a.hs256Until) {
+		return nil
+	}
+
+	return func(appID int32) (string, error) {
+		app, err := a.appProvider.App(ctx, appID)
+		if errors.Is(err, errs.ErrAppNotFound) {
+			return "", jwt.ErrInvalidToken
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return app.Secret, nil
+	}
+}
+
+// ValidateToken verifies a token issued by Login and returns its claims.
+//
+// If the token is malformed, expired, signed with a wrong secret, issued
+// for an unknown app or revoked, returns errs.ErrInvalidToken. Tokens of
+// another issuer or audience additionally wrap jwt.ErrWrongIssuer or
+// jwt.ErrWrongAudience: in calls authenticated with app credentials, see
+// authctx.WithApp, the token must be for that app. A token bound to a
+// client certificate is only valid in calls made with it, see clientcert;
+// otherwise returns errs.ErrTokenBindingMismatch. With signing keys,
+// HS256 tokens are invalid, see WithHS256Rollover.
+func (a *Auth) ValidateToken(
+	ctx context.Context,
+	token string,
+) (jwt.Claims, error) {
+	const op = "services.auth.ValidateToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	// Zero, for no app, when the call carries no app credentials.
+	bound, _ := authctx.AppFromContext(ctx)
+
+	claims, err := jwt.ParseToken(token, jwt.ParseOptions{
+		AppSecret: a.appSecret(ctx),
+		Keys:      a.keys,
+		AppID:     bound,
+		Issuer:    a.issuer,
+		Leeway:    a.leeway,
+		Clock:     a.clock,
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrWrongIssuer) || errors.Is(err, jwt.ErrWrongAudience) {
+			log.Warn("token rejected", slog.Any("error", err))
+
+			return jwt.Claims{}, &errs.Error{
+				Code:    errs.InvalidToken,
+				Op:      op,
+				Message: errs.ErrInvalidToken.Message,
+				Err:     err,
+			}
+		}
+		if errors.Is(err, jwt.ErrInvalidToken) ||
+			errors.Is(err, jwt.ErrTokenExpired) ||
+			errors.Is(err, jwt.ErrNotYetValid) {
+			log.Info("token rejected", slog.Any("error", err))
+
+			return jwt.Claims{}, errs.Wrap(op, errs.ErrInvalidToken)
+		}
+		log.Error("failed to validate token", slog.Any("error", err))
+
+		return jwt.Claims{}, errs.Wrap(op, err)
+	}
+
+	if err := checkBinding(ctx, claims); err != nil {
+		log.Warn("token rejected", slog.Any("error", err))
+
+		return jwt.Claims{}, errs.Wrap(op, err)
+	}
+
+	if err := a.checkRevoked(ctx, claims); err != nil {
+		if errors.Is(err, errs.ErrInvalidToken) {
+			log.Info("token rejected", slog.Any("error", err))
+		} else {
+			log.Error("failed to check token revocation", slog.Any("error", err))
+		}
+
+		return jwt.Claims{}, errs.Wrap(op, err)
+	}
+	if err := a.checkDenylisted(ctx, claims); err != nil {
+		if errors.Is(err, errs.ErrInvalidToken) {
+			log.Info("token rejected: revoked", slog.String("jti", claims.ID))
+		} else {
+			log.Error("failed to check token denylist", slog.Any("error", err))
+		}
+
+		return jwt.Claims{}, errs.Wrap(op, err)
+	}
+
+	return claims, nil
+}
+
+// checkBinding returns errs.ErrTokenBindingMismatch if claims are bound to
+// a client certificate other than that of the call of ctx, or the call has
+// none.
+func checkBinding(ctx context.Context, claims jwt.Claims) error {
+	if claims.CertThumbprint == "" {
+		return nil
+	}
+
+	thumbprint, _ := clientcert.FromContext(ctx)
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(claims.CertThumbprint)) != 1 {
+		return errs.ErrTokenBindingMismatch
+	}
+
+	return nil
+}
+
+// checkRevoked returns errs.ErrInvalidToken if claims were issued no later
+// than the sessions of their user were revoked. iat has a precision of a
+// second, so a token issued within the second of the revocation is taken
+// as revoked too, as is one without iat.
+func (a *Auth) checkRevoked(ctx context.Context, claims jwt.Claims) error {
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if errors.Is(err, errs.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !user.SessionsRevokedAt.IsZero() && !claims.IssuedAt.After(user.SessionsRevokedAt.Truncate(time.Second)) {
+		return errs.ErrInvalidToken
+	}
+
+	return nil
+}
+
+// newToken issues a token for the user of the app valid for ttl, with a
+// jti of its own, and records its issuance as kind.
+func (a *Auth) newToken(
+	ctx context.Context,
+	kind models.TokenKind,
+	user models.User,
+	app models.App,
+	ttl time.Duration,
+	opts ...jwt.TokenOption,
+) (string, error) {
+	jti, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	// Pinned, so the issuance record has the times of the claims.
+	now := a.clock.Now()
+	opts = append(opts, jwt.IssuedAt(now), jwt.ID(jti))
+
+	var token string
+	if a.keys == nil {
+		token, err = jwt.GenerateNewToken(a.clock, user, app, ttl, a.issuer.Name, opts...)
+	} else {
+		key, ok := a.keys.Current()
+		if !ok {
+			return "", errors.New("no signing key loaded")
+		}
+		token, err = jwt.GenerateNewRS256Token(a.clock, user, app, ttl, a.issuer.Name, key, opts...)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The claims are in seconds.
+	issuedAt := now.Truncate(time.Second)
+	if err := a.recordIssuance(ctx, kind, user, app, token, jti, issuedAt, issuedAt.Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// withClientIP adds the client IP found by the transport, if any, to log.
+func withClientIP(ctx context.Context, log *slog.Logger) *slog.Logger {
+	if ip, ok := clientip.FromContext(ctx); ok {
+		return log.With(slog.String("client_ip", ip.String()))
+	}
+
+	return log
+}
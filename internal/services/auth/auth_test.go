@@ -0,0 +1,506 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testTokenTTL = time.Hour
+
+// testApp is the app newTestAuthWithApp saves.
+var testApp = models.App{ID: 1, Name: "test", Secret: "test-secret"}
+
+// newTestAuth builds an Auth on a fresh memory storage and a fake clock
+// at the current second, which it returns. opts are applied after the
+// defaults, so they override them.
+func newTestAuth(t *testing.T, opts ...Option) (*Auth, *memory.Storage, *clock.Fake) {
+	t.Helper()
+
+	storage := memory.New()
+	clk := clock.NewFake(time.Now().Truncate(time.Second))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a, err := NewService(log, storage, storage, storage,
+		append([]Option{
+			WithClock(clk),
+			WithAuthorizations(storage),
+			WithInvites(storage),
+			WithTokenTTL(testTokenTTL),
+			WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+		}, opts...)...,
+	)
+	require.NoError(t, err)
+
+	return a, storage, clk
+}
+
+// newTestAuthWithApp is newTestAuth with testApp saved.
+func newTestAuthWithApp(t *testing.T, opts ...Option) (*Auth, *memory.Storage, *clock.Fake) {
+	t.Helper()
+
+	a, storage, clk := newTestAuth(t, opts...)
+	storage.SaveApp(testApp)
+
+	return a, storage, clk
+}
+
+// withStorage applies the option opt builds from the storage of
+// newTestAuth, e.g. withStorage(WithPermissions).
+func withStorage[S any](opt func(S) Option) Option {
+	return func(a *Auth) { opt(any(a.appProvider).(S))(a) }
+}
+
+// userStorage is what withUsers replaces the users of newTestAuth with.
+type userStorage interface {
+	UserSaver
+	UserProvider
+}
+
+// withUsers replaces the users of newTestAuth with wrap of its storage.
+// NewService wraps the replacement as it would the storage.
+func withUsers(wrap func(*memory.Storage) userStorage) Option {
+	return func(a *Auth) {
+		users := wrap(a.appProvider.(*memory.Storage))
+		a.userSaver = users
+		a.userProvider = users
+	}
+}
+
+func TestUserRole(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	withRole, err := storage.SaveUser(ctx, "teacher@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	storage.SetUserRole(withRole, "teacher")
+
+	withoutRole, err := storage.SaveUser(ctx, "student@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		userID   int64
+		wantRole string
+		wantErr  error
+	}{
+		{name: "user with role", userID: withRole, wantRole: "teacher"},
+		{name: "user without role", userID: withoutRole, wantRole: ""},
+		{name: "missing user", userID: withoutRole + 1000, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := a.UserRole(ctx, tt.userID)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRole, role)
+		})
+	}
+}
+
+func TestLogin_FailureOrdering(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		appID    int32
+		wantErr  error
+	}{
+		{name: "unknown app and wrong password", email: "user@example.com", password: "wrong", appID: 2, wantErr: ErrInvalidAppID},
+		{name: "unknown app and unknown user", email: "missing@example.com", password: "wrong", appID: 2, wantErr: ErrInvalidAppID},
+		{name: "known app and wrong password", email: "user@example.com", password: "wrong", appID: 1, wantErr: ErrInvalidCredentials},
+		{name: "known app and unknown user", email: "missing@example.com", password: "wrong", appID: 1, wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Login(ctx, tt.email, tt.password, tt.appID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+}
+
+func TestLogin_NormalizedEmail(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+
+	_, err := a.RegisterNewUser(ctx, " John@Example.COM", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = storage.User(ctx, "john@example.com")
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "JOHN@example.com", "correct-password", 1)
+	assert.NoError(t, err)
+
+	// Users registered before emails were normalized still log in with
+	// the email as they registered it.
+	hash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct-password")
+	require.NoError(t, err)
+	_, err = storage.SaveUser(ctx, "Legacy@Example.com", hash, "Jane", "Doe", "")
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "Legacy@Example.com", "correct-password", 1)
+	assert.NoError(t, err)
+}
+
+func TestResolveApp(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+	storage.SaveApp(models.App{ID: 1, Name: "journal", Secret: "journal-secret"})
+	storage.SaveApp(models.App{ID: 2, Name: "kiosk", Secret: "kiosk-secret"})
+
+	id, err := a.ResolveApp(ctx, 0, "kiosk")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), id)
+
+	id, err = a.ResolveApp(ctx, 2, "kiosk")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), id)
+
+	// Without a name the ID is left for Login to check.
+	id, err = a.ResolveApp(ctx, 5, "")
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), id)
+
+	_, err = a.ResolveApp(ctx, 1, "kiosk")
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	_, err = a.ResolveApp(ctx, 0, "missing")
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+
+	// A token for an app named by its name is the same as by its ID.
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	id, err = a.ResolveApp(ctx, 0, "journal")
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", "correct-password", id)
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), claims.AppID)
+}
+
+func TestIDBounds(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t)
+
+	_, err := a.Login(ctx, "user@example.com", "password", -1)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.ResolveApp(ctx, -1, "test")
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.UserRole(ctx, -1)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.UserExists(ctx, -1)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	// The largest IDs are valid, just unknown.
+	for _, appID := range []int32{0, math.MaxInt32} {
+		_, err = a.Login(ctx, "user@example.com", "password", appID)
+		assert.ErrorIs(t, err, ErrInvalidAppID)
+	}
+	_, err = a.UserRole(ctx, math.MaxInt64)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	exists, err := a.UserExists(ctx, math.MaxInt64)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestValidateToken(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t)
+
+	app := models.App{ID: 1, Secret: "test-secret"}
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	valid, err := jwt.GenerateNewToken(nil, user, app, time.Hour, "")
+	require.NoError(t, err)
+	expired, err := jwt.GenerateNewToken(nil, user, app, -time.Minute, "")
+	require.NoError(t, err)
+	wrongSecret, err := jwt.GenerateNewToken(nil, user, models.App{ID: 1, Secret: "other"}, time.Hour, "")
+	require.NoError(t, err)
+	unknownApp, err := jwt.GenerateNewToken(nil, user, models.App{ID: 2, Secret: "test-secret"}, time.Hour, "")
+	require.NoError(t, err)
+
+	claims, err := a.ValidateToken(ctx, valid)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, "user@example.com", claims.Email)
+	assert.Equal(t, int32(1), claims.AppID)
+
+	for name, token := range map[string]string{
+		"expired":      expired,
+		"wrong secret": wrongSecret,
+		"unknown app":  unknownApp,
+		"malformed":    "not-a-token",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := a.ValidateToken(ctx, token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestValidateToken_BoundApp(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	storage.SaveApp(models.App{ID: 1, Name: "lms", Secret: "lms-secret"})
+	storage.SaveApp(models.App{ID: 2, Name: "wiki", Secret: "wiki-secret"})
+	user := models.User{ID: 7, Email: "user@example.com"}
+
+	token, err := jwt.GenerateNewToken(nil, user, models.App{ID: 1, Secret: "lms-secret"}, time.Hour, "")
+	require.NoError(t, err)
+
+	_, err = a.ValidateToken(authctx.WithApp(ctx, 1), token)
+	assert.NoError(t, err)
+
+	_, err = a.ValidateToken(authctx.WithApp(ctx, 2), token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorIs(t, err, jwt.ErrWrongAudience)
+
+	// Calls without app credentials take tokens of any app.
+	_, err = a.ValidateToken(ctx, token)
+	assert.NoError(t, err)
+}
+
+func TestLogin_RS256(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := jwt.NewKeySet()
+	keys.Set(jwt.NewKey(private), jwt.Key{})
+
+	a, err := NewService(log, storage, storage, storage, WithSigningKeys(keys), WithTokenTTL(testTokenTTL))
+	require.NoError(t, err)
+	storage.SaveApp(testApp)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", claims.Email)
+
+	// App secrets verify nothing with signing keys: every app client
+	// could sign tokens of any user with its own.
+	forged, err := jwt.GenerateNewToken(nil, models.User{ID: 1, Email: "admin@example.com"},
+		models.App{ID: 1, Secret: "test-secret"}, time.Hour, "", jwt.Elevated())
+	require.NoError(t, err)
+	_, err = a.ValidateToken(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Rotated out keys no longer verify.
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys.Set(jwt.NewKey(other), jwt.Key{})
+
+	_, err = a.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestValidateToken_HS256Rollover(t *testing.T) {
+	ctx := context.Background()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys := jwt.NewKeySet()
+	keys.Set(jwt.NewKey(private), jwt.Key{})
+
+	clk := clock.NewFake(time.Now())
+	a, _, _ := newTestAuthWithApp(t, WithSigningKeys(keys), WithClock(clk), WithHS256Rollover(clk.Now().Add(time.Hour)))
+
+	legacy, err := jwt.GenerateNewToken(clk, models.User{ID: 7}, models.App{ID: 1, Secret: "test-secret"}, 2*time.Hour, "")
+	require.NoError(t, err)
+
+	_, err = a.ValidateToken(ctx, legacy)
+	assert.NoError(t, err, "during the rollover")
+
+	clk.Advance(time.Hour)
+	_, err = a.ValidateToken(ctx, legacy)
+	assert.ErrorIs(t, err, ErrInvalidToken, "after the rollover")
+}
+
+func TestNewService_Minimal(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a, err := NewService(log, storage, storage, storage)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTokenTTL, a.tokenTTL)
+
+	storage.SaveApp(testApp)
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	// The authorization code flow is off without its storage.
+	_, err = a.StartAuthorization(ctx, 1, "https://app.example.com/callback", "state")
+	assert.ErrorIs(t, err, errNoAuthorizations)
+}
+
+func TestNewService_FullyLoaded(t *testing.T) {
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	keys := jwt.NewKeySet()
+	issuer := jwt.Issuer{Name: "https://sso.example.com"}
+	hasher := BcryptHasher{Cost: bcrypt.MinCost}
+
+	a, err := NewService(log, storage, storage, storage,
+		WithAuthorizations(storage),
+		WithSigningKeys(keys),
+		WithIssuer(issuer),
+		WithTokenTTL(testTokenTTL),
+		WithLeeway(time.Second),
+		WithHasher(hasher),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, storage, a.authorizations)
+	assert.Same(t, keys, a.keys)
+	assert.Equal(t, issuer, a.issuer)
+	assert.Equal(t, testTokenTTL, a.tokenTTL)
+	assert.Equal(t, time.Second, a.leeway)
+	assert.Equal(t, hasher, a.hasher)
+}
+
+func TestNewService_Validation(t *testing.T) {
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name    string
+		build   func() (*Auth, error)
+		wantErr string
+	}{
+		{
+			name:    "no logger",
+			build:   func() (*Auth, error) { return NewService(nil, storage, storage, storage) },
+			wantErr: "logger is required",
+		},
+		{
+			name:    "no user saver",
+			build:   func() (*Auth, error) { return NewService(log, nil, storage, storage) },
+			wantErr: "user saver is required",
+		},
+		{
+			name:    "no user provider",
+			build:   func() (*Auth, error) { return NewService(log, storage, nil, storage) },
+			wantErr: "user provider is required",
+		},
+		{
+			name:    "no app provider",
+			build:   func() (*Auth, error) { return NewService(log, storage, storage, nil) },
+			wantErr: "app provider is required",
+		},
+		{
+			name: "nil pointer user saver",
+			build: func() (*Auth, error) {
+				var saver *memory.Storage
+				return NewService(log, saver, storage, storage)
+			},
+			wantErr: "user saver is required",
+		},
+		{
+			name: "nil pointer role storage",
+			build: func() (*Auth, error) {
+				var roles *memory.Storage
+				return NewService(log, storage, storage, storage, WithRoles(roles))
+			},
+			wantErr: "role storage is a nil *memory.Storage",
+		},
+		{
+			name:    "nil hasher",
+			build:   func() (*Auth, error) { return NewService(log, storage, storage, storage, WithHasher(nil)) },
+			wantErr: "hasher is nil",
+		},
+		{
+			name:    "zero token ttl",
+			build:   func() (*Auth, error) { return NewService(log, storage, storage, storage, WithTokenTTL(0)) },
+			wantErr: "token ttl must be positive",
+		},
+		{
+			name: "default ttl over the max",
+			build: func() (*Auth, error) {
+				return NewService(log, storage, storage, storage, WithMaxTokenTTL(time.Minute))
+			},
+			wantErr: "exceeds the max",
+		},
+		{
+			name:    "negative leeway",
+			build:   func() (*Auth, error) { return NewService(log, storage, storage, storage, WithLeeway(-time.Second)) },
+			wantErr: "leeway must not be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := tt.build()
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Nil(t, a)
+		})
+	}
+}
+
+func TestNew_Deprecated(t *testing.T) {
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a := New(log, storage, storage, storage, storage, nil, jwt.Issuer{}, testTokenTTL, 0)
+	assert.Equal(t, testTokenTTL, a.tokenTTL)
+	assert.Equal(t, storage, a.authorizations)
+
+	assert.Panics(t, func() {
+		New(log, nil, storage, storage, storage, nil, jwt.Issuer{}, testTokenTTL, 0)
+	})
+}
+
+func TestValidateToken_ExpiresWithClock(t *testing.T) {
+	ctx := context.Background()
+	a, _, clk := newTestAuthWithApp(t)
+	a.leeway = 30 * time.Second
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	clk.Advance(testTokenTTL + a.leeway)
+	_, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err, "still within leeway")
+
+	clk.Advance(time.Second)
+	_, err = a.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+)
+
+const (
+	// authorizationTTL is how long the user has to log in after the app
+	// started an authorization.
+	authorizationTTL = 10 * time.Minute
+	// codeTTL is how long the app has to exchange an issued code.
+	codeTTL = time.Minute
+)
+
+// Deprecated: these alias the errs sentinels and will be removed in the
+// next release. Use errs directly.
+var (
+	ErrRedirectURINotAllowed = errs.ErrRedirectURINotAllowed
+	ErrAuthorizationNotFound = errs.ErrAuthorizationNotFound
+	ErrAuthorizationExpired  = errs.ErrAuthorizationExpired
+	ErrInvalidCode           = errs.ErrInvalidCode
+	ErrCodeExpired           = errs.ErrCodeExpired
+	ErrCodeReused            = errs.ErrAuthorizationUsed
+	ErrRedirectURIMismatch   = errs.ErrRedirectURIMismatch
+)
+
+type AuthorizationStorage interface {
+	RedirectURIAllowed(ctx context.Context, appID int32, uri string) (bool, error)
+	SaveAuthorization(ctx context.Context, authz models.Authorization) error
+	Authorization(ctx context.Context, id string) (models.Authorization, error)
+	IssueAuthorizationCode(
+		ctx context.Context,
+		id string,
+		userID int64,
+		codeHash string,
+		expiresAt time.Time,
+	) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string, usedAt time.Time) (models.Authorization, error)
+}
+
+// StartAuthorization starts the authorization-code flow for the app and
+// returns the ID of the authorization the login page completes with
+// LoginAuthorization.
+//
+// If app does not exist, returns errs.ErrAppNotFound.
+// If redirectURI is not in the allowlist of the app, returns
+// errs.ErrRedirectURINotAllowed.
+func (a *Auth) StartAuthorization(
+	ctx context.Context,
+	appID int32,
+	redirectURI string,
+	state string,
+) (string, error) {
+	const op = "services.auth.StartAuthorization"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	if a.authorizations == nil {
+		return "", errs.Wrap(op, errNoAuthorizations)
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", errs.Wrap(op, errs.ErrAppNotFound)
+		}
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	allowed, err := a.authorizations.RedirectURIAllowed(ctx, appID, redirectURI)
+	if err != nil {
+		log.Error("failed to check redirect uri", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	if !allowed {
+		log.Warn("redirect uri not allowed", slog.String("redirect_uri", redirectURI))
+
+		return "", errs.Wrap(op, errs.ErrRedirectURINotAllowed)
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	err = a.authorizations.SaveAuthorization(ctx, models.Authorization{
+		ID:          id,
+		AppID:       appID,
+		RedirectURI: redirectURI,
+		State:       state,
+		ExpiresAt:   a.clock.Now().Add(authorizationTTL),
+	})
+	if err != nil {
+		log.Error("failed to save authorization", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	log.Info("authorization started")
+
+	return id, nil
+}
+
+// LoginAuthorization logs the user in for a started authorization and
+// returns the URL to redirect the user to: the redirect URI of the
+// authorization with the code and the state in the query.
+//
+// The login is checked as by Login, with the same errors, anomaly
+// tracking, tarpit and failure floor. A login that needs a challenge fails
+// with errs.ErrChallengeRequired as with Login; once the login page has
+// completed it, it logs in again for the code.
+//
+// If the authorization does not exist or already has a code, returns
+// errs.ErrAuthorizationNotFound; if it expired, errs.ErrAuthorizationExpired.
+func (a *Auth) LoginAuthorization(
+	ctx context.Context,
+	authorizationID string,
+	email string,
+	password string,
+) (string, error) {
+	const op = "services.auth.LoginAuthorization"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	if a.authorizations == nil {
+		return "", errs.Wrap(op, errNoAuthorizations)
+	}
+
+	authz, err := a.authorizations.Authorization(ctx, authorizationID)
+	if err != nil {
+		if errors.Is(err, errs.ErrAuthorizationNotFound) {
+			log.Warn("authorization not found")
+
+			return "", errs.Wrap(op, errs.ErrAuthorizationNotFound)
+		}
+		log.Error("failed to get authorization", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	if authz.CodeHash != "" {
+		log.Warn("authorization already completed")
+
+		return "", errs.Wrap(op, errs.ErrAuthorizationNotFound)
+	}
+	if !a.clock.Now().Before(authz.ExpiresAt) {
+		log.Info("authorization expired")
+
+		return "", errs.Wrap(op, errs.ErrAuthorizationExpired)
+	}
+
+	redirect, err := a.checkedLogin(ctx, email, password, authz.AppID,
+		func(ctx context.Context, log *slog.Logger, user models.User, app models.App) (string, error) {
+			return a.issueCode(ctx, log, authz, user, app)
+		},
+	)
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	return redirect, nil
+}
+
+// issueCode completes the login of user for authz with a code, records
+// the login and returns the URL to redirect the user to.
+func (a *Auth) issueCode(
+	ctx context.Context,
+	log *slog.Logger,
+	authz models.Authorization,
+	user models.User,
+	app models.App,
+) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = a.authorizations.IssueAuthorizationCode(ctx, authz.ID, user.ID, hashCode(code), a.clock.Now().Add(codeTTL))
+	if err != nil {
+		if errors.Is(err, errs.ErrAuthorizationNotFound) {
+			log.Warn("authorization completed concurrently")
+
+			return "", errs.ErrAuthorizationNotFound
+		}
+		log.Error("failed to issue authorization code", slog.Any("error", err))
+
+		return "", err
+	}
+
+	redirect, err := url.Parse(authz.RedirectURI)
+	if err != nil {
+		return "", err
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	if authz.State != "" {
+		query.Set("state", authz.State)
+	}
+	redirect.RawQuery = query.Encode()
+
+	log.Info("authorization code issued")
+	a.recordLogin(ctx, log, user.ID, app.ID, true)
+	a.countUsage(app.ID, models.AppUsage{Logins: 1})
+	a.publish(ctx, log, models.EventUserLoggedIn, user.ID, app.ID, loginEvent(ctx))
+
+	return redirect.String(), nil
+}
+
+// ExchangeAuthorizationCode exchanges a code issued by LoginAuthorization
+// for a token. The app authenticates with its secret and must present the
+// redirect URI the authorization was started with.
+//
+// If the app does not exist, returns errs.ErrAppNotFound; if the secret is
+// wrong, errs.ErrInvalidCredentials. Unknown codes and codes of other apps
+// give errs.ErrInvalidCode, used ones errs.ErrAuthorizationUsed and stale ones
+// errs.ErrCodeExpired. A code is consumed by the first exchange attempt, even
+// a failed one. If the account is pending deletion, returns
+// errs.ErrAccountPendingDeletion, as LoginAuthorization does.
+func (a *Auth) ExchangeAuthorizationCode(
+	ctx context.Context,
+	code string,
+	appID int32,
+	appSecret string,
+	redirectURI string,
+) (string, error) {
+	const op = "services.auth.ExchangeAuthorizationCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	if a.authorizations == nil {
+		return "", errs.Wrap(op, errNoAuthorizations)
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("app not found")
+
+			return "", errs.Wrap(op, errs.ErrAppNotFound)
+		}
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(app.Secret), []byte(appSecret)) != 1 {
+		log.Warn("invalid app secret")
+
+		return "", errs.Wrap(op, errs.ErrInvalidCredentials)
+	}
+
+	now := a.clock.Now()
+
+	authz, err := a.authorizations.ConsumeAuthorizationCode(ctx, hashCode(code), now)
+	if err != nil {
+		switch {
+		case errors.Is(err, errs.ErrAuthorizationNotFound):
+			log.Warn("authorization code not found")
+
+			return "", errs.Wrap(op, errs.ErrInvalidCode)
+		case errors.Is(err, errs.ErrAuthorizationUsed):
+			log.Warn("authorization code reused", slog.String("authorization_id", authz.ID))
+
+			return "", errs.Wrap(op, errs.ErrAuthorizationUsed)
+		}
+		log.Error("failed to consume authorization code", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	if authz.AppID != appID {
+		log.Warn("authorization code of another app", slog.Int("code_app_id", int(authz.AppID)))
+
+		return "", errs.Wrap(op, errs.ErrInvalidCode)
+	}
+	if authz.RedirectURI != redirectURI {
+		log.Warn("redirect uri mismatch", slog.String("redirect_uri", redirectURI))
+
+		return "", errs.Wrap(op, errs.ErrRedirectURIMismatch)
+	}
+	if !now.Before(authz.ExpiresAt) {
+		log.Info("authorization code expired")
+
+		return "", errs.Wrap(op, errs.ErrCodeExpired)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, authz.UserID)
+	if err != nil {
+		log.Error("failed to get user", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	// The deletion may have been scheduled since the code was issued.
+	if !user.DeleteAfter.IsZero() {
+		log.Info("account is pending deletion", slog.Time("delete_after", user.DeleteAfter))
+
+		return "", errs.Wrap(op, pendingDeletion(user.DeleteAfter))
+	}
+
+	opts, err := a.permissionClaims(ctx, log, user.ID, app.ID)
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	ttl, source := a.resolveTokenTTL(user, app)
+	token, err := a.newToken(ctx, models.TokenExchange, user, app, ttl, append(opts, jwt.TTLSource(source))...)
+	if err != nil {
+		log.Error("failed to generate token", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	a.countUsage(app.ID, models.AppUsage{TokensIssued: 1})
+	log.Info("authorization code exchanged")
+
+	return token, nil
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashCode returns the hash codes are stored under, so a leaked table
+// cannot be replayed.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
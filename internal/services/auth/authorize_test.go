@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/captcha"
+	"sso/internal/lib/clock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRedirectURI = "https://journal.example.com/callback"
+	testAppSecret   = "journal-secret"
+)
+
+// startAndLogin runs the flow up to the redirect and returns the code.
+func startAndLogin(t *testing.T, a *Auth) string {
+	t.Helper()
+
+	ctx := context.Background()
+
+	id, err := a.StartAuthorization(ctx, 7, testRedirectURI, "xyz")
+	require.NoError(t, err)
+
+	redirect, err := a.LoginAuthorization(ctx, id, "user@example.com", "correct-password")
+	require.NoError(t, err)
+
+	u, err := url.Parse(redirect)
+	require.NoError(t, err)
+	assert.Equal(t, "journal.example.com", u.Host)
+	assert.Equal(t, "xyz", u.Query().Get("state"))
+
+	code := u.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	return code
+}
+
+func newTestAuthorizationFlow(t *testing.T, opts ...Option) (*Auth, *clock.Fake) {
+	t.Helper()
+
+	a, storage, clk := newTestAuth(t, opts...)
+	storage.SaveApp(models.App{ID: 7, Name: "journal", Secret: testAppSecret})
+	storage.SaveApp(models.App{ID: 8, Name: "other", Secret: "other-secret"})
+	storage.AddRedirectURI(7, testRedirectURI)
+
+	_, err := a.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	return a, clk
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestAuthorizationFlow(t)
+
+	code := startAndLogin(t, a)
+
+	token, err := a.ExchangeAuthorizationCode(ctx, code, 7, testAppSecret, testRedirectURI)
+	require.NoError(t, err)
+
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", claims.Email)
+	assert.Equal(t, int32(7), claims.AppID)
+
+	_, err = a.ExchangeAuthorizationCode(ctx, code, 7, testAppSecret, testRedirectURI)
+	assert.ErrorIs(t, err, ErrCodeReused)
+}
+
+func TestStartAuthorization_Rejects(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestAuthorizationFlow(t)
+
+	_, err := a.StartAuthorization(ctx, 99, testRedirectURI, "")
+	assert.ErrorIs(t, err, ErrInvalidAppID)
+
+	_, err = a.StartAuthorization(ctx, 7, "https://evil.example.com/callback", "")
+	assert.ErrorIs(t, err, ErrRedirectURINotAllowed)
+
+	// The allowlist is per app.
+	_, err = a.StartAuthorization(ctx, 8, testRedirectURI, "")
+	assert.ErrorIs(t, err, ErrRedirectURINotAllowed)
+}
+
+func TestLoginAuthorization_Rejects(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestAuthorizationFlow(t)
+
+	_, err := a.LoginAuthorization(ctx, "missing", "user@example.com", "correct-password")
+	assert.ErrorIs(t, err, ErrAuthorizationNotFound)
+
+	id, err := a.StartAuthorization(ctx, 7, testRedirectURI, "")
+	require.NoError(t, err)
+
+	_, err = a.LoginAuthorization(ctx, id, "user@example.com", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = a.LoginAuthorization(ctx, id, "user@example.com", "correct-password")
+	require.NoError(t, err)
+
+	_, err = a.LoginAuthorization(ctx, id, "user@example.com", "correct-password")
+	assert.ErrorIs(t, err, ErrAuthorizationNotFound, "an authorization yields one code")
+}
+
+func TestLoginAuthorization_ChecksLikeLogin(t *testing.T) {
+	cfg := testAnomalies
+	cfg.Escalate = true
+	a, _ := newTestAuthorizationFlow(t,
+		WithLoginAnomalies(cfg),
+		WithCaptcha(&fakeCaptcha{}),
+	)
+	id, err := a.StartAuthorization(context.Background(), 7, testRedirectURI, "")
+	require.NoError(t, err)
+
+	// The failures are tracked as those of Login, sanitized email and all.
+	for i := range cfg.TargetThreshold {
+		_, err := a.LoginAuthorization(fromIP(fmt.Sprintf("198.51.100.%d", i+1)), id, " User@Example.com", "wrong-password")
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+
+	ctx := fromIP("198.51.100.1")
+	_, err = a.LoginAuthorization(ctx, id, "user@example.com", "correct-password")
+	require.ErrorIs(t, err, errs.ErrCaptchaRequired)
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 7)
+	require.ErrorIs(t, err, errs.ErrCaptchaRequired)
+
+	_, err = a.LoginAuthorization(captcha.NewContext(ctx, "solved"), id, "user@example.com", "correct-password")
+	require.NoError(t, err)
+}
+
+func TestAuthorizationCodeFlow_PendingDeletion(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestAuthorizationFlow(t, withStorage(func(s DeletionStorage) Option { return WithDeletion(s, time.Hour) }))
+	user, err := a.userProvider.User(ctx, "user@example.com")
+	require.NoError(t, err)
+
+	// A deletion scheduled between the login and the exchange.
+	code := startAndLogin(t, a)
+	deletion, err := a.ScheduleDeletion(authctx.WithCaller(ctx, authctx.Caller{UserID: user.ID}), user.ID)
+	require.NoError(t, err)
+	_, err = a.ExchangeAuthorizationCode(ctx, code, 7, testAppSecret, testRedirectURI)
+	require.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+	assert.Equal(t, map[string]string{"delete_after": deletion.DeleteAfter.UTC().Format(time.RFC3339)}, errs.MetadataOf(err))
+
+	id, err := a.StartAuthorization(ctx, 7, testRedirectURI, "")
+	require.NoError(t, err)
+	_, err = a.LoginAuthorization(ctx, id, "user@example.com", "correct-password")
+	require.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+}
+
+func TestExchangeAuthorizationCode_Rejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		appID       int32
+		secret      string
+		redirectURI string
+		wantErr     error
+	}{
+		{name: "unknown app", appID: 99, secret: testAppSecret, redirectURI: testRedirectURI, wantErr: ErrInvalidAppID},
+		{name: "wrong secret", appID: 7, secret: "wrong", redirectURI: testRedirectURI, wantErr: ErrInvalidCredentials},
+		{name: "other app", appID: 8, secret: "other-secret", redirectURI: testRedirectURI, wantErr: ErrInvalidCode},
+		{
+			name:        "redirect uri mismatch",
+			appID:       7,
+			secret:      testAppSecret,
+			redirectURI: "https://journal.example.com/other",
+			wantErr:     ErrRedirectURIMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAuthorizationFlow(t)
+			code := startAndLogin(t, a)
+
+			_, err := a.ExchangeAuthorizationCode(context.Background(), code, tt.appID, tt.secret, tt.redirectURI)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("unknown code", func(t *testing.T) {
+		a, _ := newTestAuthorizationFlow(t)
+
+		_, err := a.ExchangeAuthorizationCode(context.Background(), "nope", 7, testAppSecret, testRedirectURI)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	})
+
+	t.Run("expired code", func(t *testing.T) {
+		a, clk := newTestAuthorizationFlow(t)
+		code := startAndLogin(t, a)
+
+		clk.Advance(codeTTL)
+
+		_, err := a.ExchangeAuthorizationCode(context.Background(), code, 7, testAppSecret, testRedirectURI)
+		assert.ErrorIs(t, err, ErrCodeExpired)
+	})
+
+	t.Run("expired authorization", func(t *testing.T) {
+		a, clk := newTestAuthorizationFlow(t)
+
+		id, err := a.StartAuthorization(context.Background(), 7, testRedirectURI, "xyz")
+		require.NoError(t, err)
+
+		clk.Advance(authorizationTTL)
+
+		_, err = a.LoginAuthorization(context.Background(), id, "user@example.com", "correct-password")
+		assert.ErrorIs(t, err, ErrAuthorizationExpired)
+	})
+}
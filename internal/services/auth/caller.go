@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/authctx"
+)
+
+// authorizeOwner lets the caller of ctx, see authctx.CallerFromContext,
+// act on the account of userID: it must be their own, or they must be an
+// admin. The role is looked up unless the interceptor already did.
+//
+// Without a caller, returns errs.ErrNoCaller; for another user's account
+// without the admin role, errs.ErrNotAdmin.
+func (a *Auth) authorizeOwner(ctx context.Context, userID int64) error {
+	caller, ok := authctx.CallerFromContext(ctx)
+	if !ok {
+		return errs.ErrNoCaller
+	}
+	if caller.UserID == userID || caller.HasRole(AdminRole) {
+		return nil
+	}
+	if len(caller.Roles) > 0 {
+		return errs.ErrNotAdmin
+	}
+
+	role, err := a.userProvider.UserRole(ctx, caller.UserID)
+	if err != nil && !errors.Is(err, errs.ErrRoleNotFound) {
+		return err
+	}
+	if role != AdminRole {
+		return errs.ErrNotAdmin
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// challengeTTL is how long the user has to complete a login challenge.
+const challengeTTL = 5 * time.Minute
+
+// errNoChallenges is returned by CompleteChallenge when the service was
+// built without WithChallenges.
+var errNoChallenges = errors.New("challenge storage is not configured")
+
+type ChallengeStorage interface {
+	SaveChallenge(ctx context.Context, challenge models.Challenge) error
+	Challenge(ctx context.Context, tokenHash string) (models.Challenge, error)
+	ConsumeChallenge(ctx context.Context, tokenHash string, usedAt time.Time) error
+}
+
+// CompleteChallenge completes the login challenge of token with payload,
+// the fields its type asks for, and returns the token of the login once
+// no challenge is left. Otherwise it returns errs.ErrChallengeRequired
+// with the next challenge, as Login does with the first.
+//
+// If the challenge is unknown, completed or expired, returns
+// errs.ErrInvalidChallenge: the login has to start over.
+// If payload does not complete the challenge, returns the error of its
+// type, see models.ChallengeType; the challenge can be tried again.
+func (a *Auth) CompleteChallenge(ctx context.Context, token string, payload map[string]string) (string, error) {
+	const op = "services.auth.CompleteChallenge"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	if a.challenges == nil {
+		return "", errs.Wrap(op, errNoChallenges)
+	}
+	if token == "" {
+		return "", errs.Wrap(op, errs.New(errs.InvalidArgument, "challenge token is required"))
+	}
+
+	challenge, err := a.challenges.Challenge(ctx, hashCode(token))
+	if err != nil {
+		if errors.Is(err, errs.ErrInvalidChallenge) {
+			log.Warn("challenge not found")
+		} else {
+			log.Error("failed to get challenge", slog.Any("error", err))
+		}
+
+		return "", errs.Wrap(op, err)
+	}
+	log = log.With(
+		slog.Int64("user_id", challenge.UserID),
+		slog.String("challenge_type", string(challenge.Type)),
+	)
+
+	now := a.clock.Now()
+	if !challenge.UsedAt.IsZero() || !now.Before(challenge.ExpiresAt) {
+		log.Info("challenge already completed or expired")
+
+		return "", errs.Wrap(op, errs.ErrInvalidChallenge)
+	}
+	if err := a.checkChallenge(challenge, payload); err != nil {
+		log.Warn("challenge not passed", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	// Consumed before its effect, so that of two racing calls only one
+	// goes on.
+	if err := a.challenges.ConsumeChallenge(ctx, challenge.TokenHash, now); err != nil {
+		if errors.Is(err, errs.ErrInvalidChallenge) {
+			log.Warn("challenge completed concurrently")
+		} else {
+			log.Error("failed to consume challenge", slog.Any("error", err))
+		}
+
+		return "", errs.Wrap(op, err)
+	}
+	if err := a.passChallenge(ctx, log, challenge, payload); err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	// The account is read again: the challenge just passed changed it,
+	// and so may have anything else since Login.
+	user, err := a.userProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		log.Error("failed to get user", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	app, err := a.appProvider.App(ctx, challenge.AppID)
+	if err != nil {
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	if !user.DeleteAfter.IsZero() {
+		log.Info("account is pending deletion", slog.Time("delete_after", user.DeleteAfter))
+
+		return "", errs.Wrap(op, pendingDeletion(user.DeleteAfter))
+	}
+
+	if typ, ok := a.nextChallenge(user); ok {
+		return "", errs.Wrap(op, a.newChallenge(ctx, log, user, app.ID, typ))
+	}
+
+	token, err = a.issueLogin(ctx, log, user, app)
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	return token, nil
+}
+
+// nextChallenge returns the first challenge the login of user has yet to
+// pass. Challenges are only raised with WithChallenges.
+func (a *Auth) nextChallenge(user models.User) (models.ChallengeType, bool) {
+	if a.challenges == nil {
+		return "", false
+	}
+
+	switch {
+	case a.enforceTerms && a.termsOutdated(user):
+		return models.ChallengeTOSAccept, true
+	default:
+		return "", false
+	}
+}
+
+// newChallenge stores a challenge of typ for the login of user to app and
+// returns errs.ErrChallengeRequired carrying it: its type, the token it
+// is completed with, when it expires and what the type needs.
+func (a *Auth) newChallenge(
+	ctx context.Context,
+	log *slog.Logger,
+	user models.User,
+	appID int32,
+	typ models.ChallengeType,
+) error {
+	token, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate challenge token", slog.Any("error", err))
+
+		return err
+	}
+
+	expiresAt := a.clock.Now().Add(challengeTTL)
+	err = a.challenges.SaveChallenge(ctx, models.Challenge{
+		TokenHash: hashCode(token),
+		Type:      typ,
+		UserID:    user.ID,
+		AppID:     appID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Error("failed to save challenge", slog.Any("error", err))
+
+		return err
+	}
+
+	metadata := map[string]string{
+		"challenge_type":  string(typ),
+		"challenge_token": token,
+		"expires_at":      expiresAt.UTC().Format(time.RFC3339),
+	}
+	if typ == models.ChallengeTOSAccept {
+		metadata["tos_version"] = a.termsVersion
+	}
+
+	log.Info("login challenge required", slog.String("challenge_type", string(typ)))
+
+	return &errs.Error{
+		Code:     errs.ChallengeRequired,
+		Message:  errs.ErrChallengeRequired.Message,
+		Metadata: metadata,
+	}
+}
+
+// checkChallenge reports whether payload completes challenge, without
+// acting on it.
+func (a *Auth) checkChallenge(challenge models.Challenge, payload map[string]string) error {
+	switch challenge.Type {
+	case models.ChallengeTOSAccept:
+		if version := payload["tos_version"]; version == "" || version != a.termsVersion {
+			return errs.ErrTermsVersionMismatch
+		}
+
+		return nil
+	default:
+		// Raised by an older version that knew of more types.
+		return errs.ErrInvalidChallenge
+	}
+}
+
+// passChallenge acts on the completed challenge.
+func (a *Auth) passChallenge(
+	ctx context.Context,
+	log *slog.Logger,
+	challenge models.Challenge,
+	payload map[string]string,
+) error {
+	switch challenge.Type {
+	case models.ChallengeTOSAccept:
+		return a.recordTerms(ctx, log, challenge.UserID, payload["tos_version"])
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestChallengeAuth(t *testing.T) (*Auth, *memory.Storage, *clock.Fake) {
+	t.Helper()
+
+	a, storage, clk := newTestAuthWithApp(t,
+		WithTerms("2024-01", true),
+		withStorage(WithChallenges),
+		withStorage(func(s DeletionStorage) Option { return WithDeletion(s, time.Hour) }),
+	)
+
+	_, err := a.RegisterNewUser(context.Background(), "john@example.com", "correct-password", "John", "Doe", "", "2024-01", "", 0)
+	require.NoError(t, err)
+
+	return a, storage, clk
+}
+
+func TestLogin_NoChallenge(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestChallengeAuth(t)
+
+	token, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.ValidateToken(ctx, token)
+	assert.NoError(t, err)
+
+	_, err = a.Login(ctx, "john@example.com", "wrong-password", 1)
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+}
+
+func TestLoginChallenge_TermsAccept(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk := newTestChallengeAuth(t)
+	a.termsVersion = "2024-06"
+
+	_, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.ErrorIs(t, err, errs.ErrChallengeRequired)
+	challenge := errs.MetadataOf(err)
+	assert.Equal(t, string(models.ChallengeTOSAccept), challenge["challenge_type"])
+	assert.Equal(t, "2024-06", challenge["tos_version"])
+	assert.Equal(t, clk.Now().Add(challengeTTL).UTC().Format(time.RFC3339), challenge["expires_at"])
+	require.NotEmpty(t, challenge["challenge_token"])
+
+	// A wrong payload leaves the challenge open.
+	_, err = a.CompleteChallenge(ctx, challenge["challenge_token"], nil)
+	assert.ErrorIs(t, err, errs.ErrTermsVersionMismatch)
+	_, err = a.CompleteChallenge(ctx, challenge["challenge_token"], map[string]string{"tos_version": "2024-01"})
+	assert.ErrorIs(t, err, errs.ErrTermsVersionMismatch)
+	_, err = a.CompleteChallenge(ctx, "not-the-token", map[string]string{"tos_version": "2024-06"})
+	assert.ErrorIs(t, err, errs.ErrInvalidChallenge)
+
+	token, err := a.CompleteChallenge(ctx, challenge["challenge_token"], map[string]string{"tos_version": "2024-06"})
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.NotContains(t, claims.Raw, "tos_reaccept_required")
+
+	user, err := storage.UserByID(ctx, claims.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06", user.TermsVersion)
+
+	// A challenge is completed once, and is not asked for again.
+	_, err = a.CompleteChallenge(ctx, challenge["challenge_token"], map[string]string{"tos_version": "2024-06"})
+	assert.ErrorIs(t, err, errs.ErrInvalidChallenge)
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	assert.NoError(t, err)
+}
+
+func TestLoginChallenge_Expired(t *testing.T) {
+	ctx := context.Background()
+	a, _, clk := newTestChallengeAuth(t)
+	a.termsVersion = "2024-06"
+
+	_, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.ErrorIs(t, err, errs.ErrChallengeRequired)
+
+	clk.Advance(challengeTTL)
+	_, err = a.CompleteChallenge(ctx, errs.MetadataOf(err)["challenge_token"], map[string]string{"tos_version": "2024-06"})
+	assert.ErrorIs(t, err, errs.ErrInvalidChallenge)
+}
+
+func TestLoginChallenge_AccountChanged(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestChallengeAuth(t)
+	a.termsVersion = "2024-06"
+
+	_, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.ErrorIs(t, err, errs.ErrChallengeRequired)
+	challengeToken := errs.MetadataOf(err)["challenge_token"]
+
+	user, err := a.userByEmail(ctx, "john@example.com")
+	require.NoError(t, err)
+	_, err = a.ScheduleDeletion(authctx.WithCaller(ctx, authctx.Caller{UserID: user.ID}), user.ID)
+	require.NoError(t, err)
+
+	// Passing the challenge does not get around the deletion.
+	_, err = a.CompleteChallenge(ctx, challengeToken, map[string]string{"tos_version": "2024-06"})
+	assert.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+}
+
+func TestLoginChallenge_NotConfigured(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.CompleteChallenge(ctx, "token", nil)
+	assert.ErrorIs(t, err, errNoChallenges)
+}
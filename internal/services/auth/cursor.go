@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+
+	"sso/internal/domain/errs"
+)
+
+const (
+	// cursorVersion is the first byte of every cursor, so that the layout
+	// can change without reading old cursors wrong.
+	cursorVersion = 1
+	// cursorLen is the length of a decoded cursor: the version, the two
+	// IDs and the MAC.
+	cursorLen = 1 + 8 + 8 + sha256.Size
+	// cursorKeyLen is the length of the key made when none is given,
+	// minCursorKeyLen the shortest one accepted.
+	cursorKeyLen    = 32
+	minCursorKeyLen = 16
+)
+
+// errInvalidCursor is returned for a page token that was not made here,
+// was made with another key, or was changed.
+var errInvalidCursor = errs.New(errs.InvalidArgument, "invalid page_token")
+
+// pageCursor is where a list of users resumes: after afterID, and up to
+// upToID if the list is a snapshot.
+type pageCursor struct {
+	afterID int64
+	upToID  int64
+}
+
+// encodeCursor returns c as an opaque token, signed with key so that
+// clients cannot move a snapshot or skip users they are not given.
+func encodeCursor(key []byte, c pageCursor) string {
+	b := make([]byte, 0, cursorLen)
+	b = append(b, cursorVersion)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.afterID))
+	b = binary.BigEndian.AppendUint64(b, uint64(c.upToID))
+	b = append(b, cursorMAC(key, b)...)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the cursor of token, or errInvalidCursor if it was
+// not made by encodeCursor with key.
+func decodeCursor(key []byte, token string) (pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != cursorLen || b[0] != cursorVersion {
+		return pageCursor{}, errInvalidCursor
+	}
+	payload, mac := b[:cursorLen-sha256.Size], b[cursorLen-sha256.Size:]
+	if !hmac.Equal(mac, cursorMAC(key, payload)) {
+		return pageCursor{}, errInvalidCursor
+	}
+
+	c := pageCursor{
+		afterID: int64(binary.BigEndian.Uint64(payload[1:9])),
+		upToID:  int64(binary.BigEndian.Uint64(payload[9:17])),
+	}
+	if c.afterID < 0 || c.upToID < 0 {
+		return pageCursor{}, errInvalidCursor
+	}
+
+	return c, nil
+}
+
+func cursorMAC(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("users."))
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
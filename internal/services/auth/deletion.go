@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+const (
+	// DefaultDeletionGrace is how long a scheduled deletion can be
+	// cancelled when WithDeletion is given no grace period.
+	DefaultDeletionGrace = 14 * 24 * time.Hour
+
+	// deletionBatch bounds the users DeleteDueAccounts anonymizes per
+	// transaction.
+	deletionBatch = 100
+)
+
+// errNoDeletion is returned by the deletion methods when the service was
+// built without WithDeletion.
+var errNoDeletion = errors.New("deletion storage is not configured")
+
+type DeletionStorage interface {
+	ScheduleDeletion(
+		ctx context.Context,
+		userID int64,
+		deleteAfter time.Time,
+		tokenHash string,
+		revokedAt time.Time,
+	) error
+	CancelDeletion(ctx context.Context, userID int64, tokenHash string, now time.Time) error
+	AnonymizeDueUsers(ctx context.Context, now time.Time, limit int) ([]int64, error)
+}
+
+// ScheduleDeletion schedules the account of the user to be anonymized
+// once the grace period is over and returns the deadline and the
+// reactivation token CancelDeletion takes. Every token issued so far stops
+// working at once, and logins are refused with
+// errs.ErrAccountPendingDeletion until the deletion is cancelled. Only the
+// user and admins may, see authorizeOwner.
+//
+// Only the hash of the token is stored, so the caller must keep it. The
+// models.EventDeletionScheduled event published for the webhooks of every
+// app leaves it out.
+//
+// If the user does not exist, returns errs.ErrUserNotFound.
+// If the deletion is already scheduled, returns
+// errs.ErrAccountPendingDeletion with the deadline.
+func (a *Auth) ScheduleDeletion(ctx context.Context, userID int64) (models.Deletion, error) {
+	const op = "services.auth.ScheduleDeletion"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if a.deletions == nil {
+		return models.Deletion{}, errs.Wrap(op, errNoDeletion)
+	}
+	if err := a.authorizeOwner(ctx, userID); err != nil {
+		log.Warn("caller may not delete the account", slog.Any("error", err))
+
+		return models.Deletion{}, errs.Wrap(op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, errs.ErrUserNotFound) {
+			log.Error("failed to get user", slog.Any("error", err))
+		}
+
+		return models.Deletion{}, errs.Wrap(op, err)
+	}
+	if !user.DeleteAfter.IsZero() {
+		return models.Deletion{}, errs.Wrap(op, pendingDeletion(user.DeleteAfter))
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		log.Error("failed to generate reactivation token", slog.Any("error", err))
+
+		return models.Deletion{}, errs.Wrap(op, err)
+	}
+
+	now := a.clock.Now()
+	deleteAfter := now.Add(a.deletionGrace)
+	if err := a.deletions.ScheduleDeletion(ctx, userID, deleteAfter, hashCode(token), now); err != nil {
+		if !errors.Is(err, errs.ErrUserNotFound) {
+			log.Error("failed to schedule deletion", slog.Any("error", err))
+		}
+
+		return models.Deletion{}, errs.Wrap(op, err)
+	}
+
+	a.tokenCache.clear()
+	log.Info("account deletion scheduled", slog.Time("delete_after", deleteAfter))
+	a.publish(ctx, log, models.EventDeletionScheduled, userID, 0, struct {
+		DeleteAfter time.Time `json:"delete_after"`
+	}{deleteAfter.UTC()})
+
+	return models.Deletion{UserID: userID, ReactivationToken: token, DeleteAfter: deleteAfter}, nil
+}
+
+// CancelDeletion cancels the scheduled deletion of the user, given the
+// reactivation token ScheduleDeletion returned. The token is the proof of
+// ownership: the tokens of the user were revoked by ScheduleDeletion, and
+// stay revoked. The user can log in again.
+//
+// If no deletion is scheduled, token is not the one returned or the grace
+// period is over, returns errs.ErrInvalidReactivationToken.
+func (a *Auth) CancelDeletion(ctx context.Context, userID int64, token string) error {
+	const op = "services.auth.CancelDeletion"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if a.deletions == nil {
+		return errs.Wrap(op, errNoDeletion)
+	}
+	if token == "" {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "reactivation token is required"))
+	}
+
+	if err := a.deletions.CancelDeletion(ctx, userID, hashCode(token), a.clock.Now()); err != nil {
+		if errors.Is(err, errs.ErrInvalidReactivationToken) {
+			log.Warn("invalid reactivation token")
+		} else {
+			log.Error("failed to cancel deletion", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+
+	log.Info("account deletion cancelled")
+	a.publish(ctx, log, models.EventDeletionCancelled, userID, 0, struct{}{})
+
+	return nil
+}
+
+// DeleteDueAccounts anonymizes every account whose grace period is over
+// and returns how many there were. It is run periodically by a background
+// job; a failed run leaves the rest for the next.
+func (a *Auth) DeleteDueAccounts(ctx context.Context) (int, error) {
+	const op = "services.auth.DeleteDueAccounts"
+
+	log := a.log.With(slog.String("op", op))
+
+	if a.deletions == nil {
+		return 0, errs.Wrap(op, errNoDeletion)
+	}
+
+	now := a.clock.Now()
+	deleted := 0
+	for {
+		ids, err := a.deletions.AnonymizeDueUsers(ctx, now, deletionBatch)
+		if err != nil {
+			log.Error("failed to anonymize users", slog.Int("deleted", deleted), slog.Any("error", err))
+
+			return deleted, errs.Wrap(op, err)
+		}
+		for _, id := range ids {
+			a.publish(ctx, log, models.EventUserDeleted, id, 0, struct{}{})
+		}
+		deleted += len(ids)
+
+		if len(ids) < deletionBatch {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		// Anonymized accounts lose their roles.
+		a.permissionCache.clear()
+		a.unitRoleCache.clear()
+		a.tokenCache.clear()
+		log.Info("accounts deleted", slog.Int("count", deleted))
+	}
+
+	return deleted, nil
+}
+
+// pendingDeletion returns errs.ErrAccountPendingDeletion carrying the
+// deadline, so clients can tell the user when it is too late to cancel.
+func pendingDeletion(deleteAfter time.Time) error {
+	return &errs.Error{
+		Code:     errs.AccountPendingDeletion,
+		Message:  errs.ErrAccountPendingDeletion.Message,
+		Metadata: map[string]string{"delete_after": deleteAfter.UTC().Format(time.RFC3339)},
+	}
+}
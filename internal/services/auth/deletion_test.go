@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeferredDeletion(t *testing.T) {
+	ctx := context.Background()
+	const grace = 24 * time.Hour
+	a, storage, clk := newTestAuthWithApp(t,
+		withStorage(WithEvents),
+		withStorage(func(s DeletionStorage) Option { return WithDeletion(s, grace) }),
+	)
+
+	registered, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	userID := registered.ID
+	login := func() (string, error) {
+		return a.Login(ctx, "john@example.com", "correct-password", 1)
+	}
+	// The user deletes their own account.
+	owner := authctx.WithCaller(ctx, authctx.Caller{UserID: userID})
+
+	// Before: the account works as usual.
+	token, err := login()
+	require.NoError(t, err)
+	_, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	clk.Advance(time.Minute)
+	deletion, err := a.ScheduleDeletion(owner, userID)
+	require.NoError(t, err)
+	assert.Equal(t, clk.Now().Add(grace), deletion.DeleteAfter)
+	assert.NotEmpty(t, deletion.ReactivationToken)
+
+	// During: tokens are revoked and logins refused with the deadline.
+	_, err = a.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	clk.Advance(time.Hour)
+	_, err = login()
+	require.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+	assert.Equal(t, map[string]string{"delete_after": deletion.DeleteAfter.UTC().Format(time.RFC3339)}, errs.MetadataOf(err))
+	_, err = a.Login(ctx, "john@example.com", "wrong-password", 1)
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+
+	_, err = a.ScheduleDeletion(owner, userID)
+	assert.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+
+	assert.ErrorIs(t, a.CancelDeletion(ctx, userID, "not-the-token"), errs.ErrInvalidReactivationToken)
+	require.NoError(t, a.CancelDeletion(ctx, userID, deletion.ReactivationToken))
+	assert.ErrorIs(t, a.CancelDeletion(ctx, userID, deletion.ReactivationToken), errs.ErrInvalidReactivationToken)
+
+	// Cancelled: logins work again, the revoked token stays revoked.
+	clk.Advance(time.Second)
+	fresh, err := login()
+	require.NoError(t, err)
+	_, err = a.ValidateToken(ctx, fresh)
+	require.NoError(t, err)
+	_, err = a.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	deletion, err = a.ScheduleDeletion(owner, userID)
+	require.NoError(t, err)
+
+	n, err := a.DeleteDueAccounts(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// After the window, until the job runs: still pending, too late to
+	// cancel.
+	clk.Set(deletion.DeleteAfter)
+	_, err = login()
+	assert.ErrorIs(t, err, errs.ErrAccountPendingDeletion)
+	assert.ErrorIs(t, a.CancelDeletion(ctx, userID, deletion.ReactivationToken), errs.ErrInvalidReactivationToken)
+
+	n, err = a.DeleteDueAccounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Deleted: the account is gone for good.
+	_, err = login()
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	_, err = a.ScheduleDeletion(owner, userID)
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+	user, err := storage.UserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.NotEqual(t, "john@example.com", user.Email)
+	assert.Empty(t, user.FirstName)
+
+	events, err := storage.Events(ctx, 0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, models.EventUserDeleted, events[len(events)-1].Type)
+	assert.Equal(t, userID, events[len(events)-1].UserID)
+	// The webhooks of every app get the events: none carries the token.
+	for _, event := range events {
+		assert.NotContains(t, string(event.Payload), deletion.ReactivationToken)
+	}
+
+	// The email is free again.
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+}
+
+func TestScheduleDeletion_OwnerOrAdmin(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t, withStorage(func(s DeletionStorage) Option { return WithDeletion(s, time.Hour) }))
+	john, err := storage.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	jane, err := storage.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+	admin, err := storage.SaveUser(ctx, "admin@example.com", []byte("hash"), "Ada", "Admin", "")
+	require.NoError(t, err)
+	storage.SetUserRole(admin, AdminRole)
+
+	_, err = a.ScheduleDeletion(ctx, john)
+	assert.ErrorIs(t, err, errs.ErrNoCaller)
+	_, err = a.ScheduleDeletion(authctx.WithCaller(ctx, authctx.Caller{UserID: jane}), john)
+	assert.ErrorIs(t, err, errs.ErrNotAdmin)
+
+	deletion, err := a.ScheduleDeletion(authctx.WithCaller(ctx, authctx.Caller{UserID: admin}), john)
+	require.NoError(t, err)
+	assert.Equal(t, john, deletion.UserID)
+	assert.NoError(t, a.CancelDeletion(ctx, john, deletion.ReactivationToken))
+}
+
+func TestDeferredDeletion_NotConfigured(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.ScheduleDeletion(ctx, 1)
+	assert.ErrorIs(t, err, errNoDeletion)
+	assert.ErrorIs(t, a.CancelDeletion(ctx, 1, "token"), errNoDeletion)
+	_, err = a.DeleteDueAccounts(ctx)
+	assert.ErrorIs(t, err, errNoDeletion)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+)
+
+const (
+	// AdminRole is the role allowed to elevate its privileges.
+	AdminRole = "admin"
+	// ElevatedTokenTTL is how long a step-up token stays valid.
+	ElevatedTokenTTL = 5 * time.Minute
+)
+
+// Deprecated: use errs.ErrNotAdmin.
+var ErrNotAdmin = errs.ErrNotAdmin
+
+// ElevatePrivileges exchanges a valid token of an admin plus the admin's
+// password for a short-lived token with the elevated claim, required by
+// destructive admin operations.
+//
+// If the token is not valid, returns errs.ErrInvalidToken.
+// If the user is not an admin, returns errs.ErrNotAdmin.
+// If the password is wrong, returns errs.ErrInvalidCredentials, delayed
+// by the tarpit like a failed login.
+func (a *Auth) ElevatePrivileges(
+	ctx context.Context,
+	token string,
+	password string,
+) (string, error) {
+	const op = "services.auth.ElevatePrivileges"
+
+	if err := sanitize(input{"password", &password}); err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, errs.ErrUserNotFound) {
+			log.Warn("token of a missing user")
+
+			return "", errs.Wrap(op, errs.ErrInvalidToken)
+		}
+		log.Error("failed to get user", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	role, err := a.userProvider.UserRole(ctx, user.ID)
+	if err != nil && !errors.Is(err, errs.ErrRoleNotFound) {
+		log.Error("failed to get user role", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+	if role != AdminRole {
+		log.Warn("elevation refused for non-admin", slog.String("role", role))
+
+		return "", errs.Wrap(op, errs.ErrNotAdmin)
+	}
+
+	// A stolen token must not make an unthrottled password oracle: the
+	// password is checked like that of a login.
+	start := a.clock.Now()
+	ctx = a.trackAttempt(ctx, log, user.Email)
+	err = a.checkPassword(ctx, log, user, password)
+	if err := a.settleAttempt(ctx, withClientIP(ctx, log), user.Email, start, err); err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, claims.AppID)
+	if err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			return "", errs.Wrap(op, errs.ErrInvalidToken)
+		}
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	elevated, err := a.newToken(ctx, models.TokenElevation, user, app, ElevatedTokenTTL, jwt.Elevated())
+	if err != nil {
+		log.Error("failed to generate token", slog.Any("error", err))
+
+		return "", errs.Wrap(op, err)
+	}
+
+	a.countUsage(app.ID, models.AppUsage{TokensIssued: 1, Refreshes: 1})
+	log.Info("privileges elevated")
+
+	return elevated, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElevatePrivileges(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+
+	admin, err := a.RegisterNewUser(ctx, "admin@example.com", "admin-password", "Ada", "Admin", "", "", "", 0)
+	require.NoError(t, err)
+	adminID := admin.ID
+	storage.SetUserRole(adminID, AdminRole)
+
+	_, err = a.RegisterNewUser(ctx, "student@example.com", "student-password", "Sam", "Student", "", "", "", 0)
+	require.NoError(t, err)
+
+	adminToken, err := a.Login(ctx, "admin@example.com", "admin-password", 1)
+	require.NoError(t, err)
+	studentToken, err := a.Login(ctx, "student@example.com", "student-password", 1)
+	require.NoError(t, err)
+
+	elevated, err := a.ElevatePrivileges(ctx, adminToken, "admin-password")
+	require.NoError(t, err)
+
+	claims, err := a.ValidateToken(ctx, elevated)
+	require.NoError(t, err)
+	assert.True(t, claims.Elevated)
+	assert.Equal(t, adminID, claims.UserID)
+	assert.WithinDuration(t, time.Now().Add(ElevatedTokenTTL), claims.ExpiresAt, 2*time.Second)
+
+	normal, err := a.ValidateToken(ctx, adminToken)
+	require.NoError(t, err)
+	assert.False(t, normal.Elevated)
+
+	_, err = a.ElevatePrivileges(ctx, adminToken, "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = a.ElevatePrivileges(ctx, studentToken, "student-password")
+	assert.ErrorIs(t, err, ErrNotAdmin)
+
+	_, err = a.ElevatePrivileges(ctx, "not-a-token", "admin-password")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestElevatePrivileges_Tarpit(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk := newTestAuthWithApp(t, WithTarpit(testTarpit))
+
+	admin, err := a.RegisterNewUser(ctx, "admin@example.com", "admin-password", "Ada", "Admin", "", "", "", 0)
+	require.NoError(t, err)
+	storage.SetUserRole(admin.ID, AdminRole)
+	token, err := a.Login(ctx, "admin@example.com", "admin-password", 1)
+	require.NoError(t, err)
+
+	for range testTarpit.Free {
+		_, err := a.ElevatePrivileges(ctx, token, "wrong-password")
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+	assert.Zero(t, clk.Tickers())
+
+	// The next wrong password waits like a failed login.
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.ElevatePrivileges(ctx, token, "wrong-password")
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return clk.Tickers() == 1 }, time.Second, time.Millisecond)
+	clk.Advance(testTarpit.Base)
+	require.ErrorIs(t, <-done, errs.ErrInvalidCredentials)
+
+	delays, _ := a.TarpitDelays()
+	assert.Equal(t, uint64(1), delays.Count)
+
+	// The right password forgets the failures.
+	_, err = a.ElevatePrivileges(ctx, token, "admin-password")
+	require.NoError(t, err)
+	_, err = a.ElevatePrivileges(ctx, token, "wrong-password")
+	require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	assert.Zero(t, clk.Tickers())
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"sso/internal/domain/models"
+)
+
+// EventPublisher appends lifecycle events to the outbox the webhooks are
+// fed from.
+type EventPublisher interface {
+	SaveEvent(ctx context.Context, event models.Event) (int64, error)
+}
+
+// publish appends an event to the outbox, if any. Like the login history,
+// a failure is logged and otherwise ignored.
+func (a *Auth) publish(ctx context.Context, log *slog.Logger, eventType string, userID int64, appID int32, data any) {
+	if a.events == nil {
+		return
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error("failed to encode event", slog.String("event", eventType), slog.Any("error", err))
+
+		return
+	}
+
+	_, err = a.events.SaveEvent(ctx, models.Event{
+		Type:      eventType,
+		UserID:    userID,
+		AppID:     appID,
+		Payload:   payload,
+		CreatedAt: a.clock.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to publish event", slog.String("event", eventType), slog.Any("error", err))
+	}
+}
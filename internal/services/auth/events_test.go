@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+	a.events = storage
+
+	user, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	id := user.ID
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "user@example.com", "wrong-password", 1)
+	require.Error(t, err)
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2, "failed logins are not published")
+
+	assert.Equal(t, models.EventUserRegistered, events[0].Type)
+	assert.Equal(t, id, events[0].UserID)
+	assert.Zero(t, events[0].AppID)
+	assert.JSONEq(t, `{"email":"user@example.com"}`, string(events[0].Payload))
+
+	assert.Equal(t, models.EventUserLoggedIn, events[1].Type)
+	assert.Equal(t, id, events[1].UserID)
+	assert.Equal(t, int32(1), events[1].AppID)
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+const (
+	// StreamBatchSize is the largest number of users StreamUsers sends at
+	// once.
+	StreamBatchSize = 100
+	// DefaultUsersPageSize is the page size of ListUsers when none is
+	// given, MaxUsersPageSize the largest one.
+	DefaultUsersPageSize = 50
+	MaxUsersPageSize     = 500
+)
+
+// StreamUsers sends the users matching filter to send in ID order, in
+// batches of at most StreamBatchSize. The batch is reused, so send must
+// not keep it after returning; memory use does not depend on the number
+// of users.
+//
+// An export cut short, e.g. by a canceled context or a failed send, can be
+// resumed with filter.AfterID set to the last ID received.
+func (a *Auth) StreamUsers(
+	ctx context.Context,
+	filter models.UserFilter,
+	send func(batch []models.UserRecord) error,
+) error {
+	const op = "services.auth.StreamUsers"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("after_id", filter.AfterID),
+	))
+
+	if filter.AfterID < 0 {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "after_id must not be negative"))
+	}
+
+	batch := make([]models.UserRecord, 0, StreamBatchSize)
+	sent := 0
+	flush := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+		sent += len(batch)
+		batch = batch[:0]
+
+		return nil
+	}
+
+	for user, err := range a.userProvider.Users(ctx, filter) {
+		if err == nil && len(batch) == StreamBatchSize {
+			err = flush()
+		}
+		if err != nil {
+			return a.exportFailed(ctx, log, op, sent, err)
+		}
+		batch = append(batch, user)
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return a.exportFailed(ctx, log, op, sent, err)
+		}
+	}
+
+	log.Info("users exported", slog.Int("count", sent))
+
+	return nil
+}
+
+// ListUsers returns a page of at most pageSize users in ID order, zero
+// for DefaultUsersPageSize, and the token of the next page, empty after
+// the last one. pageToken is empty for the first page, else the token of
+// the page before.
+//
+// Pages are cut on the ID alone, which never changes nor is reused, so
+// users saved or changed while a client pages through are neither listed
+// twice nor skipped: saved users only come after the others, on the last
+// page. With snapshot, the ID of the newest user is pinned in the token of
+// the first page, and the later pages leave out the users saved after it,
+// so that an export sees a set of users as it was; the snapshot of a later
+// page is that of its token.
+//
+// If pageToken was not made here, or changed, returns errs.InvalidArgument.
+func (a *Auth) ListUsers(ctx context.Context, pageToken string, pageSize int, snapshot bool) (models.UserPage, error) {
+	const op = "services.auth.ListUsers"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	switch {
+	case pageSize < 0:
+		return models.UserPage{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "page_size must not be negative"))
+	case pageSize > MaxUsersPageSize:
+		return models.UserPage{}, errs.Wrap(op, errs.New(errs.InvalidArgument, fmt.Sprintf(
+			"page_size must be at most %d", MaxUsersPageSize,
+		)))
+	case pageSize == 0:
+		pageSize = DefaultUsersPageSize
+	}
+
+	var cursor pageCursor
+	if pageToken != "" {
+		var err error
+		if cursor, err = decodeCursor(a.cursorKey, pageToken); err != nil {
+			log.Warn("invalid page token")
+
+			return models.UserPage{}, errs.Wrap(op, err)
+		}
+	} else if snapshot {
+		last, err := a.userProvider.LastUserID(ctx)
+		if err != nil {
+			log.Error("failed to get last user id", slog.Any("error", err))
+
+			return models.UserPage{}, errs.Wrap(op, err)
+		}
+		if last == 0 {
+			return models.UserPage{Users: []models.UserRecord{}}, nil
+		}
+		cursor.upToID = last
+	}
+
+	// One more than the page tells whether there is a next one.
+	users := make([]models.UserRecord, 0, pageSize+1)
+	filter := models.UserFilter{AfterID: cursor.afterID, UpToID: cursor.upToID}
+	for user, err := range a.userProvider.Users(ctx, filter) {
+		if err != nil {
+			log.Error("failed to list users", slog.Any("error", err))
+
+			return models.UserPage{}, errs.Wrap(op, err)
+		}
+		users = append(users, user)
+		if len(users) > pageSize {
+			break
+		}
+	}
+
+	page := models.UserPage{Users: users}
+	if len(users) > pageSize {
+		page.Users = users[:pageSize]
+		page.NextPageToken = encodeCursor(a.cursorKey, pageCursor{
+			afterID: page.Users[pageSize-1].ID,
+			upToID:  cursor.upToID,
+		})
+	}
+
+	return page, nil
+}
+
+func (a *Auth) exportFailed(ctx context.Context, log *slog.Logger, op string, sent int, err error) error {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		log.Info("users export canceled", slog.Int("count", sent))
+	} else {
+		log.Error("failed to export users", slog.Int("count", sent), slog.Any("error", err))
+	}
+
+	return errs.Wrap(op, err)
+}
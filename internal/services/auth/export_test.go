@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamUsers(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	const total = 10_000
+	for i := range total {
+		_, err := storage.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "User", "Number", "")
+		require.NoError(t, err)
+	}
+
+	var (
+		seen    = make(map[int64]bool, total)
+		lastID  int64
+		buffers = make(map[*models.UserRecord]bool)
+	)
+	err := a.StreamUsers(ctx, models.UserFilter{}, func(batch []models.UserRecord) error {
+		assert.LessOrEqual(t, len(batch), StreamBatchSize)
+		assert.Equal(t, StreamBatchSize, cap(batch))
+		buffers[&batch[0]] = true
+		for _, user := range batch {
+			assert.Greater(t, user.ID, lastID, "users come in ID order")
+			lastID = user.ID
+			seen[user.ID] = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, total)
+	assert.Len(t, buffers, 1, "every batch reuses the same buffer")
+}
+
+func TestStreamUsers_ResumeAfterCancel(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	const total = 10_000
+	for i := range total {
+		_, err := storage.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "User", "Number", "")
+		require.NoError(t, err)
+	}
+
+	var got []int64
+	receive := func(batch []models.UserRecord) error {
+		for _, user := range batch {
+			got = append(got, user.ID)
+		}
+		return nil
+	}
+
+	// The client goes away in the middle of the export.
+	streamCtx, cancel := context.WithCancel(ctx)
+	batches := 0
+	err := a.StreamUsers(streamCtx, models.UserFilter{}, func(batch []models.UserRecord) error {
+		batches++
+		if batches == 37 {
+			cancel()
+		}
+		return receive(batch)
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	require.Len(t, got, 37*StreamBatchSize)
+
+	err = a.StreamUsers(ctx, models.UserFilter{AfterID: got[len(got)-1]}, receive)
+	require.NoError(t, err)
+
+	require.Len(t, got, total)
+	for i, id := range got {
+		assert.Equal(t, int64(i+1), id, "no user is skipped or sent twice")
+	}
+}
+
+func TestStreamUsers_SendError(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	for i := range 2 * StreamBatchSize {
+		_, err := storage.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "User", "Number", "")
+		require.NoError(t, err)
+	}
+
+	sendErr := errors.New("stream broken")
+	calls := 0
+	err := a.StreamUsers(ctx, models.UserFilter{}, func([]models.UserRecord) error {
+		calls++
+		return sendErr
+	})
+	assert.ErrorIs(t, err, sendErr)
+	assert.Equal(t, 1, calls)
+
+	err = a.StreamUsers(ctx, models.UserFilter{AfterID: -1}, func([]models.UserRecord) error { return nil })
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+}
+
+func TestListUsers_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+
+	for _, snapshot := range []bool{false, true} {
+		t.Run(fmt.Sprintf("snapshot=%t", snapshot), func(t *testing.T) {
+			a, storage, _ := newTestAuth(t)
+			save := func(email string) int64 {
+				t.Helper()
+
+				id, err := storage.SaveUser(ctx, email, []byte("hash"), "User", "Number", "")
+				require.NoError(t, err)
+
+				return id
+			}
+
+			want := make([]int64, 0, 250)
+			for i := range cap(want) {
+				want = append(want, save(fmt.Sprintf("user%d@example.com", i)))
+			}
+
+			var (
+				got   []int64
+				saved []int64
+				token string
+			)
+			for pages := 0; ; pages++ {
+				require.Less(t, pages, 100, "the listing ends")
+
+				page, err := a.ListUsers(ctx, token, 40, snapshot)
+				require.NoError(t, err)
+				for _, user := range page.Users {
+					got = append(got, user.ID)
+				}
+				if page.NextPageToken == "" {
+					break
+				}
+				token = page.NextPageToken
+
+				// Registrations land between the pages, and a user the
+				// client already has changes.
+				saved = append(saved, save(fmt.Sprintf("late%d@example.com", pages)))
+				require.NoError(t, storage.SetUserTokenTTL(ctx, got[0], time.Hour))
+			}
+
+			if snapshot {
+				assert.Equal(t, want, got, "the snapshot has no duplicates, gaps nor later users")
+			} else {
+				assert.Equal(t, append(want, saved...), got, "later users come at the end")
+			}
+		})
+	}
+}
+
+func TestListUsers_Tokens(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	page, err := a.ListUsers(ctx, "", 0, true)
+	require.NoError(t, err)
+	assert.Empty(t, page.Users)
+	assert.Empty(t, page.NextPageToken, "an empty snapshot has one page")
+
+	for i := range 3 {
+		_, err := storage.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "User", "Number", "")
+		require.NoError(t, err)
+	}
+	page, err = a.ListUsers(ctx, "", 2, false)
+	require.NoError(t, err)
+	require.Len(t, page.Users, 2)
+	require.NotEmpty(t, page.NextPageToken)
+
+	tampered := []byte(page.NextPageToken)
+	tampered[3] ^= 1
+	other, _, _ := newTestAuth(t)
+	for name, token := range map[string]string{
+		"garbage":     "not a token",
+		"tampered":    string(tampered),
+		"other key":   page.NextPageToken,
+		"truncated":   page.NextPageToken[:10],
+		"empty bytes": "AA",
+	} {
+		svc := a
+		if name == "other key" {
+			svc = other
+		}
+		_, err := svc.ListUsers(ctx, token, 2, false)
+		assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err), name)
+	}
+
+	// Instances sharing the key take the tokens of each other.
+	key := []byte("0123456789abcdef0123456789abcdef")
+	first, err := NewService(a.log, storage, storage, storage, WithCursorKey(key))
+	require.NoError(t, err)
+	second, err := NewService(a.log, storage, storage, storage, WithCursorKey(key))
+	require.NoError(t, err)
+	page, err = first.ListUsers(ctx, "", 2, false)
+	require.NoError(t, err)
+	page, err = second.ListUsers(ctx, page.NextPageToken, 2, false)
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "user2@example.com", page.Users[0].Email)
+	assert.Empty(t, page.NextPageToken)
+
+	_, err = a.ListUsers(ctx, "", MaxUsersPageSize+1, false)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.ListUsers(ctx, "", -1, false)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = NewService(a.log, storage, storage, storage, WithCursorKey([]byte("short")))
+	assert.ErrorContains(t, err, "cursor key must be at least 16 bytes")
+}
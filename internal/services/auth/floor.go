@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"sso/internal/lib/clock"
+)
+
+// padFailure waits until the failure floor, moved by a random jitter, has
+// passed since start. It gives up when ctx is done: the caller is gone.
+func (a *Auth) padFailure(ctx context.Context, start time.Time) {
+	if a.failureFloor == 0 {
+		return
+	}
+
+	target := a.failureFloor
+	if a.failureJitter > 0 {
+		target += time.Duration(rand.Int64N(int64(2*a.failureJitter)+1)) - a.failureJitter
+	}
+
+	_ = clock.Sleep(ctx, a.clock, target-a.clock.Now().Sub(start))
+}
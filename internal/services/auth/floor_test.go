@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// clockHasher advances the clock by delay on every comparison.
+type clockHasher struct {
+	BcryptHasher
+	clock *clock.Fake
+	delay time.Duration
+}
+
+func (h clockHasher) Compare(hash []byte, password string) error {
+	h.clock.Advance(h.delay)
+
+	return h.BcryptHasher.Compare(hash, password)
+}
+
+func TestLogin_FailureFloor(t *testing.T) {
+	const (
+		floor  = 250 * time.Millisecond
+		jitter = 50 * time.Millisecond
+		delay  = 100 * time.Millisecond
+	)
+
+	ctx := context.Background()
+	storage := memory.New()
+	storage.SaveApp(testApp)
+	clk := clock.NewFake(time.Now())
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithClock(clk),
+		WithHasher(clockHasher{BcryptHasher: BcryptHasher{Cost: bcrypt.MinCost}, clock: clk, delay: delay}),
+		WithFailureFloor(floor, jitter),
+	)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	// Each failure waits for the rest of the floor: its own delay, the
+	// comparison of the wrong password, counts towards it.
+	failures := map[string]struct {
+		appID   int32
+		elapsed time.Duration
+	}{
+		"unknown app":    {appID: 2},
+		"wrong password": {appID: 1, elapsed: delay},
+	}
+	for name, tt := range failures {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan error, 1)
+			go func() {
+				_, err := a.Login(ctx, "user@example.com", "wrong-password", tt.appID)
+				done <- err
+			}()
+
+			require.Eventually(t, func() bool { return clk.Tickers() == 1 }, time.Second, time.Millisecond)
+			clk.Advance(floor - jitter - tt.elapsed - time.Nanosecond)
+			select {
+			case <-done:
+				require.Fail(t, "failure returned before the floor")
+			default:
+			}
+
+			clk.Advance(2*jitter + time.Nanosecond)
+			assert.Error(t, <-done)
+		})
+	}
+
+	// A success returns straight away, without waiting on the clock.
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Zero(t, clk.Tickers())
+
+	_, err = NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithFailureFloor(floor, 2*floor),
+	)
+	assert.ErrorContains(t, err, "exceeds the floor")
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// errNoIdentities is returned by the identity linking methods when the
+// service was built without WithIdentities.
+var errNoIdentities = errors.New("identity storage is not configured")
+
+type IdentityStorage interface {
+	LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error
+	LinkedIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID int64, provider string) error
+}
+
+// IdentityProvider is a federated identity provider, such as Google.
+type IdentityProvider interface {
+	// Exchange redeems the authorization code the provider gave the user
+	// and returns the account it was issued for. A code the provider
+	// refuses is errs.ErrInvalidCode.
+	Exchange(ctx context.Context, code string) (models.ProviderIdentity, error)
+}
+
+// LinkIdentity links the provider account code was issued for to the
+// holder of token, so they can sign in with either. Linking an account
+// that is already linked to the same user does nothing.
+//
+// If the token is not valid, returns errs.ErrInvalidToken.
+// If provider is not configured, returns errs.ErrUnknownProvider.
+// If the account is linked to another user, or the user has another
+// account of the provider linked, returns errs.ErrIdentityLinked.
+func (a *Auth) LinkIdentity(
+	ctx context.Context,
+	token string,
+	provider string,
+	code string,
+) (models.LinkedIdentity, error) {
+	const op = "services.auth.LinkIdentity"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+	))
+
+	if a.identities == nil {
+		return models.LinkedIdentity{}, errs.Wrap(op, errNoIdentities)
+	}
+	if code == "" {
+		return models.LinkedIdentity{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "code is required"))
+	}
+
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return models.LinkedIdentity{}, errs.Wrap(op, err)
+	}
+	log = log.With(slog.Int64("user_id", claims.UserID))
+
+	idp, ok := a.identityProviders[provider]
+	if !ok {
+		log.Warn("unknown identity provider")
+
+		return models.LinkedIdentity{}, errs.Wrap(op, errs.ErrUnknownProvider)
+	}
+
+	account, err := idp.Exchange(ctx, code)
+	if err != nil {
+		log.Warn("failed to exchange code", slog.Any("error", err))
+
+		return models.LinkedIdentity{}, errs.Wrap(op, err)
+	}
+	if account.Subject == "" {
+		return models.LinkedIdentity{}, errs.Wrap(op, fmt.Errorf("provider %q returned no subject", provider))
+	}
+
+	identity := models.LinkedIdentity{
+		UserID:   claims.UserID,
+		Provider: provider,
+		Subject:  account.Subject,
+		Email:    account.Email,
+		LinkedAt: a.clock.Now(),
+	}
+	if err := a.identities.LinkIdentity(ctx, identity); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrIdentityLinked):
+			if linked, ok := a.linkedIdentity(ctx, claims.UserID, identity); ok {
+				return linked, nil
+			}
+			log.Warn("identity is already linked")
+		case errors.Is(err, errs.ErrUserNotFound):
+			// The token outlived its user.
+			err = errs.ErrInvalidToken
+		default:
+			log.Error("failed to link identity", slog.Any("error", err))
+		}
+
+		return models.LinkedIdentity{}, errs.Wrap(op, err)
+	}
+
+	log.Info("identity linked")
+
+	return identity, nil
+}
+
+// linkedIdentity returns identity as linked to the user, if it is.
+func (a *Auth) linkedIdentity(
+	ctx context.Context,
+	userID int64,
+	identity models.LinkedIdentity,
+) (models.LinkedIdentity, bool) {
+	identities, err := a.identities.LinkedIdentities(ctx, userID)
+	if err != nil {
+		return models.LinkedIdentity{}, false
+	}
+	for _, linked := range identities {
+		if linked.Provider == identity.Provider && linked.Subject == identity.Subject {
+			return linked, true
+		}
+	}
+
+	return models.LinkedIdentity{}, false
+}
+
+// UnlinkIdentity unlinks the account of provider from the user. A user
+// always keeps a way to sign in: a password or another linked account.
+// Only the user and admins may, see authorizeOwner.
+//
+// If no account of provider is linked, returns errs.ErrIdentityNotFound.
+// If it is the last login method of the user, returns
+// errs.ErrLastLoginMethod.
+func (a *Auth) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	const op = "services.auth.UnlinkIdentity"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.String("provider", provider),
+	))
+
+	if a.identities == nil {
+		return errs.Wrap(op, errNoIdentities)
+	}
+	if userID <= 0 {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "user_id must be positive"))
+	}
+	if provider == "" {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "provider is required"))
+	}
+	if err := a.authorizeOwner(ctx, userID); err != nil {
+		log.Warn("caller may not unlink identities of the user", slog.Any("error", err))
+
+		return errs.Wrap(op, err)
+	}
+
+	if err := a.identities.UnlinkIdentity(ctx, userID, provider); err != nil {
+		if errors.Is(err, errs.ErrIdentityNotFound) || errors.Is(err, errs.ErrLastLoginMethod) {
+			log.Warn("identity not unlinked", slog.Any("error", err))
+		} else {
+			log.Error("failed to unlink identity", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+
+	log.Info("identity unlinked")
+
+	return nil
+}
+
+// ListIdentities returns the provider accounts linked to the user, oldest
+// first. Only the user and admins may, see authorizeOwner.
+func (a *Auth) ListIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error) {
+	const op = "services.auth.ListIdentities"
+
+	if a.identities == nil {
+		return nil, errs.Wrap(op, errNoIdentities)
+	}
+	if userID <= 0 {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "user_id must be positive"))
+	}
+	if err := a.authorizeOwner(ctx, userID); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	identities, err := a.identities.LinkedIdentities(ctx, userID)
+	if err != nil {
+		a.log.Error("failed to list identities", slog.String("op", op), slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	return identities, nil
+}
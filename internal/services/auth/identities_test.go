@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider knows the accounts of its codes.
+type fakeProvider map[string]models.ProviderIdentity
+
+func (p fakeProvider) Exchange(_ context.Context, code string) (models.ProviderIdentity, error) {
+	account, ok := p[code]
+	if !ok {
+		return models.ProviderIdentity{}, errs.ErrInvalidCode
+	}
+
+	return account, nil
+}
+
+func TestLinkIdentity(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t,
+		withStorage(WithIdentities),
+		WithIdentityProviders(map[string]IdentityProvider{
+			"google": fakeProvider{
+				"john-code":  {Subject: "g-john", Email: "john@gmail.com"},
+				"other-code": {Subject: "g-other"},
+			},
+		}),
+	)
+
+	token := func(email string) string {
+		_, err := a.RegisterNewUser(ctx, email, "correct-password", "John", "Doe", "", "", "", 0)
+		require.NoError(t, err)
+		token, err := a.Login(ctx, email, "correct-password", 1)
+		require.NoError(t, err)
+
+		return token
+	}
+	john, jane := token("john@example.com"), token("jane@example.com")
+
+	identity, err := a.LinkIdentity(ctx, john, "google", "john-code")
+	require.NoError(t, err)
+	assert.Equal(t, "g-john", identity.Subject)
+	assert.Equal(t, "john@gmail.com", identity.Email)
+
+	// Linking the same account again is a no-op.
+	again, err := a.LinkIdentity(ctx, john, "google", "john-code")
+	require.NoError(t, err)
+	assert.WithinDuration(t, identity.LinkedAt, again.LinkedAt, time.Millisecond)
+
+	_, err = a.LinkIdentity(ctx, jane, "google", "john-code")
+	assert.ErrorIs(t, err, errs.ErrIdentityLinked)
+	_, err = a.LinkIdentity(ctx, john, "google", "other-code")
+	assert.ErrorIs(t, err, errs.ErrIdentityLinked)
+	_, err = a.LinkIdentity(ctx, jane, "gitlab", "john-code")
+	assert.ErrorIs(t, err, errs.ErrUnknownProvider)
+	_, err = a.LinkIdentity(ctx, jane, "google", "bad-code")
+	assert.ErrorIs(t, err, errs.ErrInvalidCode)
+	_, err = a.LinkIdentity(ctx, "not-a-token", "google", "john-code")
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	claims, err := a.ValidateToken(ctx, john)
+	require.NoError(t, err)
+	_, err = a.ListIdentities(ctx, claims.UserID)
+	assert.ErrorIs(t, err, errs.ErrNoCaller)
+	ctx = authctx.WithCaller(ctx, authctx.Caller{UserID: claims.UserID})
+	assert.ErrorIs(t, a.UnlinkIdentity(ctx, claims.UserID+1, "google"), errs.ErrNotAdmin)
+	identities, err := a.ListIdentities(ctx, claims.UserID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "google", identities[0].Provider)
+
+	// John still has his password.
+	require.NoError(t, a.UnlinkIdentity(ctx, claims.UserID, "google"))
+	assert.ErrorIs(t, a.UnlinkIdentity(ctx, claims.UserID, "google"), errs.ErrIdentityNotFound)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(a.UnlinkIdentity(ctx, -1, "google")))
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+	admin, err := storage.SaveUser(ctx, "admin@example.com", []byte("hash"), "Ada", "Admin", "")
+	require.NoError(t, err)
+	storage.SetUserRole(admin, AdminRole)
+	user, err := storage.SaveUser(ctx, "user@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	as := func(caller authctx.Caller) context.Context { return authctx.WithCaller(ctx, caller) }
+	assert.NoError(t, a.authorizeOwner(as(authctx.Caller{UserID: user}), user))
+	assert.NoError(t, a.authorizeOwner(as(authctx.Caller{UserID: admin}), user))
+	assert.ErrorIs(t, a.authorizeOwner(as(authctx.Caller{UserID: user}), admin), errs.ErrNotAdmin)
+	assert.ErrorIs(t, a.authorizeOwner(ctx, user), errs.ErrNoCaller)
+
+	// Roles the interceptor looked up are trusted as they are.
+	assert.NoError(t, a.authorizeOwner(as(authctx.Caller{UserID: user, Roles: []string{AdminRole}}), admin))
+	assert.ErrorIs(t, a.authorizeOwner(as(authctx.Caller{UserID: admin, Roles: []string{"user"}}), user), errs.ErrNotAdmin)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/jwt"
+)
+
+// IntrospectToken validates a token like ValidateToken, reusing the
+// claims of tokens found valid within the TTL given to WithTokenCache.
+// Only valid tokens are cached, never past their expiry, and a revocation
+// by this instance empties the cache; one by another instance shows up
+// once the TTL is over.
+func (a *Auth) IntrospectToken(ctx context.Context, token string) (jwt.Claims, error) {
+	const op = "services.auth.IntrospectToken"
+
+	if a.tokenCache == nil {
+		claims, err := a.ValidateToken(ctx, token)
+		if err != nil {
+			return jwt.Claims{}, errs.Wrap(op, err)
+		}
+
+		return claims, nil
+	}
+
+	key := sha256.Sum256([]byte(token))
+	now := a.clock.Now()
+	if claims, ok := a.tokenCache.get(key, now); ok {
+		return claims, nil
+	}
+
+	// Read before the validation, so that a revocation landing during it
+	// is not hidden by the stale result.
+	gen := a.tokenCache.generation()
+	claims, err := a.ValidateToken(ctx, token)
+	if err != nil {
+		return jwt.Claims{}, errs.Wrap(op, err)
+	}
+
+	expires := now.Add(a.tokenCache.ttl)
+	if claims.ExpiresAt.Before(expires) {
+		expires = claims.ExpiresAt
+	}
+	a.tokenCache.put(key, claims, gen, expires, now)
+
+	return claims, nil
+}
+
+// CachedTokens returns the number of tokens in the cache of
+// IntrospectToken, zero without WithTokenCache.
+func (a *Auth) CachedTokens() int {
+	return a.tokenCache.len()
+}
+
+// tokenCache keeps the claims of valid tokens by the hash of the token.
+// Every revocation empties it and bumps its generation, so results
+// validated before the revocation are not stored after it.
+type tokenCache struct {
+	ttl  time.Duration
+	size int
+
+	mu    sync.Mutex
+	gen   uint64
+	items map[[sha256.Size]byte]cachedClaims
+}
+
+type cachedClaims struct {
+	claims  jwt.Claims
+	expires time.Time
+}
+
+func newTokenCache(ttl time.Duration, size int) *tokenCache {
+	return &tokenCache{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[[sha256.Size]byte]cachedClaims),
+	}
+}
+
+func (c *tokenCache) get(key [sha256.Size]byte, now time.Time) (jwt.Claims, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || !now.Before(item.expires) {
+		return jwt.Claims{}, false
+	}
+
+	return item.claims, true
+}
+
+func (c *tokenCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+func (c *tokenCache) put(key [sha256.Size]byte, claims jwt.Claims, gen uint64, expires, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || !now.Before(expires) {
+		return
+	}
+	if len(c.items) >= c.size {
+		// Expired tokens go first: emptying the whole cache would send
+		// every proxy back to storage at once.
+		for k, item := range c.items {
+			if !now.Before(item.expires) {
+				delete(c.items, k)
+			}
+		}
+		if len(c.items) >= c.size {
+			clear(c.items)
+		}
+	}
+	c.items[key] = cachedClaims{claims: claims, expires: expires}
+}
+
+// clear empties the cache after a revocation. A nil cache has nothing to
+// clear.
+func (c *tokenCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	clear(c.items)
+}
+
+// len returns the number of cached tokens, expired ones included.
+func (c *tokenCache) len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
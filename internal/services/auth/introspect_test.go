@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/authctx"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/jwt"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingUsers counts the lookups ValidateToken makes to check
+// revocations, one per token validated.
+type countingUsers struct {
+	*memory.Storage
+	lookups int
+}
+
+func (u *countingUsers) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	u.lookups++
+
+	return u.Storage.UserByID(ctx, userID)
+}
+
+func newTestIntrospectAuth(t *testing.T, tokenTTL time.Duration) (*Auth, *countingUsers, *clock.Fake) {
+	t.Helper()
+
+	var users *countingUsers
+	a, _, clk := newTestAuthWithApp(t,
+		WithTokenTTL(tokenTTL),
+		withStorage(func(s DeletionStorage) Option { return WithDeletion(s, time.Hour) }),
+		WithTokenCache(time.Minute, 10),
+		withUsers(func(s *memory.Storage) userStorage {
+			users = &countingUsers{Storage: s}
+			return users
+		}),
+	)
+
+	return a, users, clk
+}
+
+func TestIntrospectToken_Cached(t *testing.T) {
+	ctx := context.Background()
+	a, users, clk := newTestIntrospectAuth(t, time.Hour)
+
+	user, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	userID := user.ID
+	token, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	users.lookups = 0
+	for range 3 {
+		claims, err := a.IntrospectToken(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, userID, claims.UserID)
+	}
+	assert.Equal(t, 1, users.lookups)
+	assert.Equal(t, 1, a.CachedTokens())
+
+	// Past the TTL the token is validated again.
+	clk.Advance(time.Minute)
+	_, err = a.IntrospectToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, 2, users.lookups)
+
+	// Invalid tokens are never cached.
+	for range 2 {
+		_, err = a.IntrospectToken(ctx, "forged")
+		assert.ErrorIs(t, err, errs.ErrInvalidToken)
+	}
+	assert.Equal(t, 1, a.CachedTokens())
+}
+
+func TestIntrospectToken_Revoked(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestIntrospectAuth(t, time.Hour)
+
+	user, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	userID := user.ID
+	token, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	_, err = a.IntrospectToken(ctx, token)
+	require.NoError(t, err)
+
+	_, err = a.ScheduleDeletion(authctx.WithCaller(ctx, authctx.Caller{UserID: userID}), userID)
+	require.NoError(t, err)
+	assert.Zero(t, a.CachedTokens())
+
+	_, err = a.IntrospectToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+}
+
+func TestIntrospectToken_NotPastExpiry(t *testing.T) {
+	ctx := context.Background()
+	a, _, clk := newTestIntrospectAuth(t, 30*time.Second)
+
+	_, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	token, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	_, err = a.IntrospectToken(ctx, token)
+	require.NoError(t, err)
+
+	// The cache TTL is a minute, the token expires first.
+	clk.Advance(31 * time.Second)
+	_, err = a.IntrospectToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+}
+
+func TestTokenCache_Generation(t *testing.T) {
+	c := newTokenCache(time.Minute, 2)
+	now := time.Now()
+	expires := now.Add(time.Minute)
+
+	gen := c.generation()
+	c.clear()
+	c.put([32]byte{1}, testClaims(1), gen, expires, now)
+	assert.Zero(t, c.len(), "validated before the revocation")
+
+	gen = c.generation()
+	c.put([32]byte{1}, testClaims(1), gen, now.Add(time.Second), now)
+	c.put([32]byte{2}, testClaims(2), gen, expires, now)
+	// Full: the expired entry makes room.
+	c.put([32]byte{3}, testClaims(3), gen, expires, now.Add(time.Second))
+	assert.Equal(t, 2, c.len())
+	_, ok := c.get([32]byte{2}, now)
+	assert.True(t, ok)
+}
+
+func testClaims(userID int64) jwt.Claims {
+	return jwt.Claims{UserID: userID, AppID: 1}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/useragent"
+)
+
+// DefaultIssuanceRetention is how long issuances are kept after their
+// tokens expire when WithTokenIssuances is given zero.
+const DefaultIssuanceRetention = 30 * 24 * time.Hour
+
+// errNoTokenIssuances is returned by LookupToken when the service was
+// built without WithTokenIssuances.
+var errNoTokenIssuances = errors.New("token issuance storage is not configured")
+
+type TokenIssuanceStorage interface {
+	SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error
+	TokenIssuance(ctx context.Context, fingerprint string) (models.TokenIssuance, error)
+}
+
+// recordIssuance records that token was issued, if issuances are on. Only
+// its fingerprint is stored. A failure fails the issuance: a token must
+// not be handed out that LookupToken would deny issuing.
+func (a *Auth) recordIssuance(
+	ctx context.Context,
+	kind models.TokenKind,
+	user models.User,
+	app models.App,
+	token string,
+	jti string,
+	issuedAt time.Time,
+	expiresAt time.Time,
+) error {
+	if a.issuances == nil {
+		return nil
+	}
+
+	return a.issuances.SaveTokenIssuance(ctx, models.TokenIssuance{
+		Fingerprint: jwt.Fingerprint(token),
+		JTI:         jti,
+		Kind:        kind,
+		UserID:      user.ID,
+		AppID:       app.ID,
+		IssuedAt:    issuedAt,
+		ExpiresAt:   expiresAt,
+		RetainUntil: expiresAt.Add(a.issuanceRetention),
+		Device:      deviceLabel(ctx),
+	})
+}
+
+// deviceLabel labels the client of the call in ctx by its user-agent and
+// declared version, see useragent.Label, or returns "" outside a call.
+func deviceLabel(ctx context.Context) string {
+	e := audit.FromContext(ctx)
+	if e.Method == audit.Unknown {
+		return ""
+	}
+
+	known := func(v string) string {
+		if v == audit.Unknown {
+			return ""
+		}
+
+		return v
+	}
+
+	return useragent.Label(known(e.UserAgent), known(e.ClientVersion))
+}
+
+// LookupToken tells whether a token was issued here, and to whom, from
+// either the token or its fingerprint, see jwt.Fingerprint. The token is
+// neither verified nor logged: an expired or tampered one resolves as
+// long as its record is kept.
+//
+// If no issuance is recorded, or it was purged, returns
+// errs.ErrTokenNotIssued.
+func (a *Auth) LookupToken(ctx context.Context, tokenOrFingerprint string) (models.TokenIssuance, error) {
+	const op = "services.auth.LookupToken"
+
+	if a.issuances == nil {
+		return models.TokenIssuance{}, errs.Wrap(op, errNoTokenIssuances)
+	}
+
+	fingerprint, err := fingerprintOf(tokenOrFingerprint)
+	if err != nil {
+		return models.TokenIssuance{}, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(slog.String("op", op), slog.String("fingerprint", fingerprint)))
+
+	issuance, err := a.issuances.TokenIssuance(ctx, fingerprint)
+	if err != nil {
+		if !errors.Is(err, errs.ErrTokenNotIssued) {
+			log.Error("failed to look up token", slog.Any("error", err))
+		}
+
+		return models.TokenIssuance{}, errs.Wrap(op, err)
+	}
+	log.Info("token looked up", slog.Int64("user_id", issuance.UserID), slog.String("jti", issuance.JTI))
+
+	return issuance, nil
+}
+
+// fingerprintOf returns s if it is a fingerprint, and the fingerprint of
+// s if it is a token, which unlike fingerprints has dots.
+func fingerprintOf(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ".") {
+		return jwt.Fingerprint(s), nil
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != jwt.FingerprintSize {
+		return "", errs.New(errs.InvalidArgument, "expected a token or its fingerprint")
+	}
+
+	return strings.ToLower(s), nil
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/useragent"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedIssuances keeps what is saved, and fails saving with err.
+type recordedIssuances struct {
+	TokenIssuanceStorage
+
+	mu    sync.Mutex
+	saved []models.TokenIssuance
+	err   error
+}
+
+func (r *recordedIssuances) SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.saved = append(r.saved, issuance)
+
+	return r.TokenIssuanceStorage.SaveTokenIssuance(ctx, issuance)
+}
+
+func newTestIssuanceAuth(t *testing.T) (*Auth, *memory.Storage, *recordedIssuances) {
+	t.Helper()
+
+	var issuances *recordedIssuances
+	a, storage, _ := newTestAuthWithApp(t, withStorage(func(s *memory.Storage) Option {
+		issuances = &recordedIssuances{TokenIssuanceStorage: s}
+		return WithTokenIssuances(issuances, time.Hour)
+	}))
+
+	return a, storage, issuances
+}
+
+func TestLookupToken(t *testing.T) {
+	ctx := context.Background()
+	a, storage, issuances := newTestIssuanceAuth(t)
+
+	admin, err := a.RegisterNewUser(ctx, "admin@example.com", "admin-password", "Ada", "Admin", "", "", "", 0)
+	require.NoError(t, err)
+	storage.SetUserRole(admin.ID, AdminRole)
+
+	token, err := a.Login(ctx, "admin@example.com", "admin-password", 1)
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	require.NotEmpty(t, claims.ID)
+
+	got, err := a.LookupToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, jwt.Fingerprint(token), got.Fingerprint)
+	assert.Equal(t, claims.ID, got.JTI)
+	assert.Equal(t, models.TokenLogin, got.Kind)
+	assert.Equal(t, admin.ID, got.UserID)
+	assert.Equal(t, int32(1), got.AppID)
+	assert.True(t, claims.ExpiresAt.Equal(got.ExpiresAt), "recorded %s, token %s", got.ExpiresAt, claims.ExpiresAt)
+	assert.True(t, got.ExpiresAt.Add(time.Hour).Equal(got.RetainUntil))
+
+	// By fingerprint, however it is cased.
+	byFingerprint, err := a.LookupToken(ctx, " "+strings.ToUpper(got.Fingerprint)+"\n")
+	require.NoError(t, err)
+	assert.Equal(t, got, byFingerprint)
+
+	elevated, err := a.ElevatePrivileges(ctx, token, "admin-password")
+	require.NoError(t, err)
+	got, err = a.LookupToken(ctx, elevated)
+	require.NoError(t, err)
+	assert.Equal(t, models.TokenElevation, got.Kind)
+
+	// Only fingerprints are stored: no part of a token is.
+	require.Len(t, issuances.saved, 2)
+	for _, saved := range issuances.saved {
+		record := fmt.Sprintf("%+v", saved)
+		for _, issued := range []string{token, elevated} {
+			for part := range strings.SplitSeq(issued, ".") {
+				assert.NotContains(t, record, part)
+			}
+		}
+	}
+
+	_, err = a.LookupToken(ctx, jwt.Fingerprint("not issued.by.us"))
+	assert.ErrorIs(t, err, errs.ErrTokenNotIssued)
+	_, err = a.LookupToken(ctx, "not issued.by.us")
+	assert.ErrorIs(t, err, errs.ErrTokenNotIssued)
+
+	for _, bad := range []string{"", "deadbeef", strings.Repeat("z", 2*jwt.FingerprintSize)} {
+		_, err = a.LookupToken(ctx, bad)
+		assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err), "%q", bad)
+	}
+}
+
+func TestLookupToken_RecordFailure(t *testing.T) {
+	ctx := context.Background()
+	a, _, issuances := newTestIssuanceAuth(t)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	// A token that could not be recorded is not handed out.
+	issuances.err = errors.New("disk full")
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	assert.Error(t, err)
+	assert.Empty(t, issuances.saved)
+}
+
+func TestLookupToken_Device(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestIssuanceAuth(t)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "user-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name      string
+		userAgent string
+		version   string
+		want      string
+	}{
+		{
+			name:      "browser",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      "Chrome on Windows",
+		},
+		{name: "app", userAgent: "Diary/2.3 CFNetwork/1494.0.7 Darwin/23.4.0", version: "2.3", want: "iOS app 2.3"},
+		{name: "nothing sent", want: useragent.Unknown},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := audit.NewContext(ctx, audit.Envelope{Method: "/auth.Auth/Login", UserAgent: tt.userAgent, ClientVersion: tt.version})
+			token, err := a.Login(ctx, "user@example.com", "user-password", 1)
+			require.NoError(t, err)
+
+			got, err := a.LookupToken(ctx, token)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Device)
+		})
+	}
+
+	// Outside a call, there is no device to tell.
+	token, err := a.Login(ctx, "user@example.com", "user-password", 1)
+	require.NoError(t, err)
+	got, err := a.LookupToken(ctx, token)
+	require.NoError(t, err)
+	assert.Empty(t, got.Device)
+}
+
+func TestLookupToken_NotConfigured(t *testing.T) {
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.LookupToken(context.Background(), strings.Repeat("a", 2*jwt.FingerprintSize))
+	assert.Equal(t, errs.Internal, errs.CodeOf(err))
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"sso/internal/domain/errs"
+)
+
+const (
+	// MaxNameRunes bounds each name of a user in characters, that is code
+	// points: a letter with a combining accent counts twice.
+	MaxNameRunes = 100
+	// MaxNameBytes bounds each name of a user in UTF-8 bytes, as the
+	// storage and the systems downstream do.
+	MaxNameBytes = 255
+)
+
+// validateNames checks the names of a user against MaxNameRunes and
+// MaxNameBytes. Names are rejected, never truncated.
+func validateNames(firstName, lastName, middleName string) error {
+	for _, name := range []struct{ field, value string }{
+		{"first_name", firstName},
+		{"last_name", lastName},
+		{"middle_name", middleName},
+	} {
+		if err := validateName(name.field, name.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateName(field, name string) error {
+	if !utf8.ValidString(name) {
+		return errs.New(errs.InvalidArgument, field+" must be valid UTF-8")
+	}
+
+	if runes := utf8.RuneCountInString(name); runes > MaxNameRunes || len(name) > MaxNameBytes {
+		return errs.New(errs.InvalidArgument, fmt.Sprintf(
+			"%s must be at most %d characters and %d bytes, got %d characters and %d bytes",
+			field, MaxNameRunes, MaxNameBytes, runes, len(name),
+		))
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nameAlphabet mixes ASCII with two-, three- and four-byte characters,
+// combining marks and the pieces of emoji sequences.
+var nameAlphabet = []string{
+	"a", "Z", "-", " ", "'",
+	"ж", "Ё", "é",
+	"漢", "ह", "\u093f",
+	"\u0301", "\u0308",
+	"😀", "👍", "\U0001F3FD", "\u200d", "\ufe0f",
+}
+
+// randomName is a name of up to twice MaxNameRunes pieces of
+// nameAlphabet, so that both limits are crossed often.
+type randomName string
+
+func (randomName) Generate(r *rand.Rand, _ int) reflect.Value {
+	var b strings.Builder
+	for range r.Intn(2*MaxNameRunes + 1) {
+		b.WriteString(nameAlphabet[r.Intn(len(nameAlphabet))])
+	}
+
+	return reflect.ValueOf(randomName(b.String()))
+}
+
+func TestValidateName_Property(t *testing.T) {
+	accepts := func(name randomName) bool {
+		s := string(name)
+		fits := utf8.RuneCountInString(s) <= MaxNameRunes && len(s) <= MaxNameBytes
+
+		return (validateName("first_name", s) == nil) == fits
+	}
+	require.NoError(t, quick.Check(accepts, &quick.Config{MaxCount: 2000}))
+
+	// Rejections state both limits whichever is crossed.
+	states := func(name randomName) bool {
+		err := validateName("first_name", string(name))
+
+		return err == nil || strings.Contains(err.Error(), "at most 100 characters and 255 bytes")
+	}
+	require.NoError(t, quick.Check(states, nil))
+}
+
+func TestValidateName(t *testing.T) {
+	// A family emoji: 7 code points joined by U+200D, 25 bytes.
+	family := "👨\u200d👩\u200d👧\u200d👦"
+
+	for _, tt := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{name: "ascii at the rune limit", value: strings.Repeat("a", MaxNameRunes), ok: true},
+		{name: "ascii past the rune limit", value: strings.Repeat("a", MaxNameRunes+1)},
+		{name: "cyrillic within both", value: strings.Repeat("ж", MaxNameRunes), ok: true},
+		{name: "cjk past the byte limit", value: strings.Repeat("漢", 86)},
+		{name: "emoji past the byte limit", value: strings.Repeat("😀", MaxNameRunes)},
+		{name: "combining marks count as runes", value: strings.Repeat("e\u0301", 51)},
+		{name: "emoji sequences within both", value: strings.Repeat(family, 10), ok: true},
+		{name: "emoji sequences past the byte limit", value: strings.Repeat(family, 11)},
+		{name: "invalid utf-8", value: "\xff"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateName("first_name", tt.value)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+			}
+		})
+	}
+}
+
+func TestRegisterNewUser_NameLimits(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", strings.Repeat("😀", 100), "Doe", "", "", "", 0)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	assert.ErrorContains(t, err, "first_name must be at most 100 characters and 255 bytes, got 100 characters and 400 bytes")
+
+	activations, _ := newTestActivationAuth(t)
+	_, err = activations.PreRegisterUser(ctx, "user@example.com", "John", "Doe", strings.Repeat("ж", 128))
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+}
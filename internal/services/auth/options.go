@@ -0,0 +1,573 @@
+package auth
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/lib/clock"
+	"sso/internal/lib/deps"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/reserved"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultTokenTTL is the lifetime of tokens when WithTokenTTL is not given.
+const DefaultTokenTTL = time.Hour
+
+var (
+	// errNoAuthorizations is returned by the authorization code flow when
+	// the service was built without WithAuthorizations.
+	errNoAuthorizations = errors.New("authorization storage is not configured")
+	// errNoLoginHistory is returned by Stats when the service was built
+	// without WithLoginHistory.
+	errNoLoginHistory = errors.New("login history is not configured")
+)
+
+var (
+	// ErrMismatch is returned by Hasher.Compare when the password does not
+	// match the hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrInvalidHash is returned by Hasher.Compare when the stored hash
+	// cannot be parsed, which means it is corrupted.
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// Hasher hashes passwords and checks them against stored hashes.
+type Hasher interface {
+	Hash(password string) ([]byte, error)
+	// Compare returns nil if password matches hash, an error wrapping
+	// ErrInvalidHash if hash is corrupted and ErrMismatch otherwise.
+	// Other errors are taken as a mismatch.
+	Compare(hash []byte, password string) error
+}
+
+// BcryptHasher is the default Hasher.
+//
+// bcrypt reads no more than 72 bytes of a password, so passwords are
+// hashed with SHA-512 first and bcrypt is given the digest: every byte of
+// a long password counts. Such hashes carry PrehashPrefix before the
+// bcrypt hash. Hashes without it, made before, hold the password itself
+// and keep verifying as such.
+type BcryptHasher struct {
+	// Cost is the bcrypt cost. Zero means bcrypt.DefaultCost.
+	Cost int
+}
+
+// PrehashPrefix marks the hashes of BcryptHasher whose password was
+// pre-hashed with SHA-512. Other schemes, such as argon2, get prefixes of
+// their own.
+const PrehashPrefix = "$sha512$"
+
+func (h BcryptHasher) Hash(password string) ([]byte, error) {
+	cost := h.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(prehash(password), cost)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(PrehashPrefix), hash...), nil
+}
+
+func (BcryptHasher) Compare(hash []byte, password string) error {
+	key := []byte(password)
+	if rest, ok := bytes.CutPrefix(hash, []byte(PrehashPrefix)); ok {
+		hash, key = rest, prehash(password)
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, key)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	return nil
+}
+
+// Check reports an error wrapping ErrInvalidHash if hash cannot be parsed,
+// without comparing any password.
+func (BcryptHasher) Check(hash []byte) error {
+	if _, err := bcrypt.Cost(bytes.TrimPrefix(hash, []byte(PrehashPrefix))); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidHash, err)
+	}
+
+	return nil
+}
+
+// prehash returns the SHA-512 digest of password, which at 64 bytes fits
+// in bcrypt whole. The digest is binary: bcrypt here reads NUL bytes like
+// any other.
+func prehash(password string) []byte {
+	sum := sha512.Sum512([]byte(password))
+
+	return sum[:]
+}
+
+// Option configures Auth, see NewService.
+type Option func(*Auth)
+
+// WithTokenTTL sets the lifetime of issued tokens. Default DefaultTokenTTL.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(a *Auth) { a.tokenTTL = ttl }
+}
+
+// WithMaxTokenTTL caps the lifetime of issued tokens, overrides included.
+// Zero means no cap.
+func WithMaxTokenTTL(max time.Duration) Option {
+	return func(a *Auth) { a.maxTokenTTL = max }
+}
+
+// WithTerms requires users to accept version of the terms of service on
+// registration. Users who accepted an older version get a
+// tos_reaccept_required claim on login, or with enforce are refused with
+// errs.ErrTermsReacceptRequired, or a challenge with WithChallenges. An
+// empty version disables the check.
+func WithTerms(version string, enforce bool) Option {
+	return func(a *Auth) {
+		a.termsVersion = version
+		a.enforceTerms = enforce
+	}
+}
+
+// WithHasher sets the password hasher. Default BcryptHasher.
+func WithHasher(hasher Hasher) Option {
+	return func(a *Auth) { a.hasher = hasher }
+}
+
+// WithRegistrationMode sets who may register. Default RegistrationOpen.
+// RegistrationInvite needs WithInvites. The mode can be changed later
+// with Auth.SetRegistrationMode.
+func WithRegistrationMode(mode RegistrationMode) Option {
+	return func(a *Auth) { a.registration.Store(&mode) }
+}
+
+// WithEmailDomains restricts the email domains users register with. A
+// nil policy allows every domain, which is the default. The policy can be
+// replaced later with Auth.SetEmailDomains.
+func WithEmailDomains(policy *emaildomain.Policy) Option {
+	return func(a *Auth) { a.emailDomains.Store(policy) }
+}
+
+// WithReservedIdentifiers refuses the registrations of emails whose local
+// part is on list, such as admin@ or support@. A nil list reserves
+// nothing, which is the default. Admins are not held to it: the accounts
+// of PreRegisterUser may take reserved emails. The list can be replaced
+// later with Auth.SetReservedIdentifiers.
+func WithReservedIdentifiers(list *reserved.List) Option {
+	return func(a *Auth) { a.reserved.Store(list) }
+}
+
+// WithCanonicalEmails stores the canonical form of the emails users
+// register with, by the rules of canonicalizer, and refuses an email whose
+// canonical form is another user's: name+x@gmail.com once name@gmail.com
+// is registered. The email is kept as given. Needs WithRegistrations.
+//
+// Users registered before are held to it once their canonical emails
+// are backfilled with ssoctl emails canonicalize.
+func WithCanonicalEmails(canonicalizer *emailaddr.Canonicalizer) Option {
+	return func(a *Auth) { a.canonical = canonicalizer }
+}
+
+// WithConcealedRegistration hides from RegisterNewUser callers whether an
+// email is taken, see Auth.RegisterNewUser. Default false.
+func WithConcealedRegistration(conceal bool) Option {
+	return func(a *Auth) { a.concealUsers = conceal }
+}
+
+// WithFailureFloor pads failed logins to floor, plus or minus a random
+// jitter, see Auth.Login. Default no padding.
+func WithFailureFloor(floor, jitter time.Duration) Option {
+	return func(a *Auth) {
+		a.failureFloor = floor
+		a.failureJitter = jitter
+	}
+}
+
+// WithInvites stores the invites of CreateInvite and invite-only
+// registration.
+func WithInvites(invites InviteStorage) Option {
+	return func(a *Auth) { a.invites = invites }
+}
+
+// WithIdentities stores the provider accounts of LinkIdentity.
+func WithIdentities(identities IdentityStorage) Option {
+	return func(a *Auth) { a.identities = identities }
+}
+
+// WithIdentityProviders sets the identity providers accounts can be
+// linked from, keyed by name. Default none.
+func WithIdentityProviders(providers map[string]IdentityProvider) Option {
+	return func(a *Auth) { a.identityProviders = providers }
+}
+
+// WithDeletion enables ScheduleDeletion, cancellable for grace, zero
+// meaning DefaultDeletionGrace.
+func WithDeletion(deletions DeletionStorage, grace time.Duration) Option {
+	return func(a *Auth) {
+		a.deletions = deletions
+		a.deletionGrace = cmp.Or(grace, DefaultDeletionGrace)
+	}
+}
+
+// WithActivation enables PreRegisterUser, whose activation tokens are
+// valid for ttl, zero for DefaultActivationTTL.
+func WithActivation(activations ActivationStorage, ttl time.Duration) Option {
+	return func(a *Auth) {
+		a.activations = activations
+		a.activationTTL = cmp.Or(ttl, DefaultActivationTTL)
+	}
+}
+
+// WithAppQuotas lets registrations be attributed to apps, whose quotas
+// they are then held to, see SetAppMaxUsers.
+func WithAppQuotas(quotas AppQuotaStorage) Option {
+	return func(a *Auth) { a.appQuotas = quotas }
+}
+
+// WithRegistrations saves each registration with its invite use, terms
+// acceptance and registration event in one transaction, rather than one
+// write after the other, so the response is sent as soon as it commits
+// and a registration is never left half recorded. The deliveries of the
+// event stay with the outbox consumers.
+func WithRegistrations(registrations RegistrationStorage) Option {
+	return func(a *Auth) { a.registrations = registrations }
+}
+
+// WithChallenges turns the steps a login has to pass before tokens are
+// issued, such as terms of service enforced by WithTerms, into challenges
+// completed with CompleteChallenge rather than plain errors.
+func WithChallenges(challenges ChallengeStorage) Option {
+	return func(a *Auth) { a.challenges = challenges }
+}
+
+// WithPermissions resolves the permissions the roles of users grant in
+// an app, and adds them to the tokens of their logins.
+func WithPermissions(permissions PermissionStorage) Option {
+	return func(a *Auth) { a.permissions = permissions }
+}
+
+// WithOrgUnits enables the tree of org units roles can be given in, see
+// UserRolesInUnit.
+func WithOrgUnits(units OrgUnitStorage) Option {
+	return func(a *Auth) { a.orgUnits = units }
+}
+
+// WithRoles enables AssignRoleBulk.
+func WithRoles(roles RoleStorage) Option {
+	return func(a *Auth) { a.roles = roles }
+}
+
+// WithTokenIssuances records the fingerprint of every token issued, with
+// its user, app, jti and expiry, so LookupToken can tell whether a token
+// is ours. Records are kept retention past the expiry of their tokens,
+// zero for DefaultIssuanceRetention; purging them is up to the storage.
+func WithTokenIssuances(issuances TokenIssuanceStorage, retention time.Duration) Option {
+	return func(a *Auth) {
+		a.issuances = issuances
+		a.issuanceRetention = cmp.Or(retention, DefaultIssuanceRetention)
+	}
+}
+
+// WithRevocations enables Logout, which adds the jti of a token to the
+// denylist of revocations, and has ValidateToken refuse the tokens on it.
+// Answers of the denylist are cached for cacheTTL, zero for
+// DefaultRevocationCacheTTL: instances sharing revocations learn of one
+// by another within it, unless told sooner, see WithRevocationBus.
+func WithRevocations(revocations RevocationStorage, cacheTTL time.Duration) Option {
+	return func(a *Auth) {
+		a.revocations = revocations
+		a.revoked = newRevocationCache(cmp.Or(cacheTTL, DefaultRevocationCacheTTL))
+	}
+}
+
+// WithRevocationBus announces revocations to the instances sharing the
+// storage over bus, and drops the cached answers of those it announces.
+// It needs WithRevocations.
+func WithRevocationBus(bus RevocationBus) Option {
+	return func(a *Auth) { a.revocationBus = bus }
+}
+
+// WithCursorKey signs the page tokens of ListUsers with key, which the
+// instances serving the same storage share. Without it, a random key is
+// made by NewService: tokens then work on this instance only, until it
+// stops.
+func WithCursorKey(key []byte) Option {
+	return func(a *Auth) { a.cursorKey = key }
+}
+
+// WithHashDurations records how long password hashing and comparisons
+// take in durations, by "hash" and "compare".
+func WithHashDurations(durations *metrics.HistogramVec) Option {
+	return func(a *Auth) { a.hashDurations = durations }
+}
+
+// WithRequestCache keeps the users, roles and apps looked up in a request
+// for the rest of it, when its context carries a cache, see reqcache.
+// Changes of users made through the service drop it.
+func WithRequestCache() Option {
+	return func(a *Auth) { a.requestCache = true }
+}
+
+// WithTokenCache caches the claims of the tokens IntrospectToken finds
+// valid for ttl, at most size of them. Zero ttl disables the cache.
+func WithTokenCache(ttl time.Duration, size int) Option {
+	return func(a *Auth) {
+		a.tokenCache = nil
+		if ttl != 0 {
+			a.tokenCache = newTokenCache(ttl, size)
+		}
+	}
+}
+
+// WithStageTimeouts bounds the calls to the dependencies of the service,
+// see StageTimeouts. The storage calls it wraps also have their failures
+// classified, so that those worth retrying reach callers as
+// errs.StorageUnavailable. Default unbounded.
+func WithStageTimeouts(timeouts StageTimeouts) Option {
+	return func(a *Auth) { a.stages = newStages(timeouts) }
+}
+
+// WithReadRetry retries once the storage reads that are safe to repeat,
+// User, UserByID, UserRole and App, when they fail in a way worth
+// retrying, such as during a checkpoint, and the request has minBudget
+// left before its deadline. A request may also cap its retries across
+// reads, see retrybudget. Writes are never retried.
+func WithReadRetry(minBudget time.Duration) Option {
+	return func(a *Auth) { a.readRetry = newReadRetry(minBudget) }
+}
+
+// WithLoginAnomalies correlates the login attempts by client IP and by
+// email to flag sprays and targeted attacks, see AnomalyConfig. Flagged
+// attempts are audited, and the logged-in events carry the anomaly_score
+// of their login.
+func WithLoginAnomalies(cfg AnomalyConfig) Option {
+	return func(a *Auth) { a.anomalies = newLoginTracker(cfg) }
+}
+
+// WithTarpit delays the logins refused for their credentials by the recent
+// failures of their email or client IP, see TarpitConfig.
+func WithTarpit(cfg TarpitConfig) Option {
+	return func(a *Auth) { a.tarpit = newTarpit(cfg) }
+}
+
+// WithCaptcha verifies the CAPTCHA responses required of the accounts
+// escalated by WithLoginAnomalies.
+func WithCaptcha(verifier CaptchaVerifier) Option {
+	return func(a *Auth) { a.captcha = verifier }
+}
+
+// WithAuthorizations enables the authorization code flow.
+func WithAuthorizations(authorizations AuthorizationStorage) Option {
+	return func(a *Auth) { a.authorizations = authorizations }
+}
+
+// WithAppUsage counts the logins and tokens of every app by day, see
+// AppUsage. The counts are kept in memory until FlushUsage writes them:
+// call it periodically and on shutdown.
+func WithAppUsage(usage AppUsageStorage) Option {
+	return func(a *Auth) { a.usage = usage }
+}
+
+// WithLoginHistory records every login attempt of a known app and
+// enables Stats.
+func WithLoginHistory(history LoginHistory) Option {
+	return func(a *Auth) { a.loginHistory = history }
+}
+
+// WithEvents publishes registrations and logins to the outbox the
+// webhooks are fed from.
+func WithEvents(events EventPublisher) Option {
+	return func(a *Auth) { a.events = events }
+}
+
+// WithSigningKeys signs tokens with RS256 using keys instead of HS256 with
+// the secret of the app.
+func WithSigningKeys(keys *jwt.KeySet) Option {
+	return func(a *Auth) { a.keys = keys }
+}
+
+// WithHS256Rollover keeps accepting HS256 tokens signed with app secrets
+// until until, when WithSigningKeys moves a deployment from HS256 to
+// RS256, so tokens issued before the switch stay valid until they expire.
+// Set until no later than the switch plus the max token TTL: while it
+// lasts, every app client can sign tokens of any user with its secret.
+func WithHS256Rollover(until time.Time) Option {
+	return func(a *Auth) { a.hs256Until = until }
+}
+
+// WithIssuer sets the issuer stamped into tokens and the issuers accepted
+// when validating them.
+func WithIssuer(issuer jwt.Issuer) Option {
+	return func(a *Auth) { a.issuer = issuer }
+}
+
+// WithClock sets the clock of TTLs and token timestamps. Default the
+// system clock.
+func WithClock(c clock.Clock) Option {
+	return func(a *Auth) { a.clock = c }
+}
+
+// WithLeeway sets the clock skew tolerated when validating tokens.
+func WithLeeway(leeway time.Duration) Option {
+	return func(a *Auth) { a.leeway = leeway }
+}
+
+// NewService returns a new instance of Auth service. The storages are
+// required; everything else comes from opts.
+//
+// Missing dependencies and invalid options are reported here rather than
+// on the first request.
+func NewService(
+	log *slog.Logger,
+	userSaver UserSaver,
+	userProvider UserProvider,
+	appProvider AppProvider,
+	opts ...Option,
+) (*Auth, error) {
+	const op = "auth.NewService"
+
+	a := &Auth{
+		log:          log,
+		userSaver:    userSaver,
+		userProvider: userProvider,
+		appProvider:  appProvider,
+		hasher:       BcryptHasher{},
+		clock:        clock.Real(),
+		tokenTTL:     DefaultTokenTTL,
+
+		invalidHashes: metrics.NewCounter("password_hash_invalid_total"),
+		stages:        newStages(StageTimeouts{}),
+
+		permissionCache: newLookupCache[permissionKey](),
+		unitRoleCache:   newLookupCache[unitRoleKey](),
+		usageCounts:     newUsageCounts(),
+	}
+	mode := RegistrationOpen
+	a.registration.Store(&mode)
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	switch {
+	case a.log == nil:
+		return nil, fmt.Errorf("%s: logger is required", op)
+	case deps.Missing(a.userSaver):
+		return nil, fmt.Errorf("%s: user saver is required", op)
+	case deps.Missing(a.userProvider):
+		return nil, fmt.Errorf("%s: user provider is required", op)
+	case deps.Missing(a.appProvider):
+		return nil, fmt.Errorf("%s: app provider is required", op)
+	case deps.Missing(a.hasher):
+		return nil, fmt.Errorf("%s: hasher is nil", op)
+	case deps.Missing(a.clock):
+		return nil, fmt.Errorf("%s: clock is nil", op)
+	case a.tokenTTL <= 0:
+		return nil, fmt.Errorf("%s: token ttl must be positive, got %s", op, a.tokenTTL)
+	case a.maxTokenTTL < 0:
+		return nil, fmt.Errorf("%s: max token ttl must not be negative, got %s", op, a.maxTokenTTL)
+	case a.maxTokenTTL > 0 && a.tokenTTL > a.maxTokenTTL:
+		return nil, fmt.Errorf("%s: token ttl %s exceeds the max %s", op, a.tokenTTL, a.maxTokenTTL)
+	case a.leeway < 0:
+		return nil, fmt.Errorf("%s: leeway must not be negative, got %s", op, a.leeway)
+	case a.failureFloor < 0 || a.failureJitter < 0:
+		return nil, fmt.Errorf("%s: failure floor and jitter must not be negative", op)
+	case a.failureJitter > a.failureFloor:
+		return nil, fmt.Errorf("%s: failure jitter %s exceeds the floor %s", op, a.failureJitter, a.failureFloor)
+	case a.issuanceRetention < 0:
+		return nil, fmt.Errorf("%s: token issuance retention must not be negative, got %s", op, a.issuanceRetention)
+	case a.revoked != nil && a.revoked.ttl < 0:
+		return nil, fmt.Errorf("%s: revocation cache ttl must not be negative, got %s", op, a.revoked.ttl)
+	case a.revocationBus != nil && a.revocations == nil:
+		return nil, fmt.Errorf("%s: revocation bus needs revocation storage", op)
+	case a.deletionGrace < 0:
+		return nil, fmt.Errorf("%s: deletion grace period must not be negative, got %s", op, a.deletionGrace)
+	case a.tokenCache != nil && (a.tokenCache.ttl < 0 || a.tokenCache.size <= 0):
+		return nil, fmt.Errorf("%s: token cache ttl and size must be positive", op)
+	case a.canonical != nil && a.registrations == nil:
+		return nil, fmt.Errorf("%s: canonical emails need registration storage", op)
+	case a.anomalies != nil && !a.anomalies.cfg.valid():
+		return nil, fmt.Errorf("%s: anomaly window, thresholds, max tracked and history must be positive", op)
+	case a.tarpit != nil && !a.tarpit.cfg.valid():
+		return nil, fmt.Errorf("%s: tarpit window, base delay and max tracked must be positive, max delay at least the base", op)
+	case a.cursorKey != nil && len(a.cursorKey) < minCursorKeyLen:
+		return nil, fmt.Errorf("%s: cursor key must be at least %d bytes, got %d", op, minCursorKeyLen, len(a.cursorKey))
+	case a.readRetry != nil && a.readRetry.minBudget < 0:
+		return nil, fmt.Errorf("%s: read retry budget must not be negative, got %s", op, a.readRetry.minBudget)
+	}
+	// The optional storages are left nil when not given; given, they
+	// must not be a nil pointer, which only fails once called.
+	for _, dep := range []struct {
+		name string
+		v    any
+	}{
+		{"authorization storage", a.authorizations},
+		{"login history", a.loginHistory},
+		{"event publisher", a.events},
+		{"captcha verifier", a.captcha},
+		{"invite storage", a.invites},
+		{"identity storage", a.identities},
+		{"deletion storage", a.deletions},
+		{"challenge storage", a.challenges},
+		{"permission storage", a.permissions},
+		{"activation storage", a.activations},
+		{"app quota storage", a.appQuotas},
+		{"registration storage", a.registrations},
+		{"app usage storage", a.usage},
+		{"org unit storage", a.orgUnits},
+		{"role storage", a.roles},
+		{"token issuance storage", a.issuances},
+		{"revocation storage", a.revocations},
+		{"revocation bus", a.revocationBus},
+	} {
+		if dep.v != nil && deps.Missing(dep.v) {
+			return nil, fmt.Errorf("%s: %s is a nil %T", op, dep.name, dep.v)
+		}
+	}
+	for name, provider := range a.identityProviders {
+		if deps.Missing(provider) {
+			return nil, fmt.Errorf("%s: identity provider %q is nil", op, name)
+		}
+	}
+	for stage, timeout := range a.stages.timeouts {
+		if timeout < 0 {
+			return nil, fmt.Errorf("%s: %s timeout must not be negative, got %s", op, stage, timeout)
+		}
+	}
+	if err := a.checkRegistrationMode(a.RegistrationMode()); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if a.cursorKey == nil {
+		a.cursorKey = make([]byte, cursorKeyLen)
+		if _, err := rand.Read(a.cursorKey); err != nil {
+			return nil, fmt.Errorf("%s: failed to make cursor key: %w", op, err)
+		}
+	}
+	a.stages.retry = a.readRetry
+	if a.stages.enabled() || a.stages.retry != nil {
+		a.withStageTimeouts()
+	}
+	// Outside the timeouts, so hits are not timed.
+	if a.requestCache {
+		a.withRequestCache()
+	}
+	if a.revocationBus != nil {
+		a.revocationBus.Subscribe(a.invalidateRevocation)
+	}
+
+	return a, nil
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+)
+
+// maxOrgUnitNameLen bounds the name of an org unit, in characters.
+const maxOrgUnitNameLen = 128
+
+// errNoOrgUnits is returned by the org unit methods when the service was
+// built without WithOrgUnits.
+var errNoOrgUnits = errors.New("org unit storage is not configured")
+
+type OrgUnitStorage interface {
+	SaveOrgUnit(ctx context.Context, unit models.OrgUnit) (int64, error)
+	MoveOrgUnit(ctx context.Context, id, parentID int64) error
+	OrgUnits(ctx context.Context) ([]models.OrgUnit, error)
+	UnitRoles(ctx context.Context, userID, unitID int64) ([]string, error)
+}
+
+type unitRoleKey struct {
+	userID int64
+	unitID int64
+}
+
+// CreateOrgUnit creates a unit named name under parentID, a root unit if
+// zero.
+//
+// If the parent does not exist, returns errs.ErrOrgUnitNotFound.
+// If it already has a unit of the name, returns errs.ErrOrgUnitExists.
+func (a *Auth) CreateOrgUnit(ctx context.Context, parentID int64, name string) (models.OrgUnit, error) {
+	const op = "services.auth.CreateOrgUnit"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("parent_id", parentID),
+	))
+
+	if a.orgUnits == nil {
+		return models.OrgUnit{}, errs.Wrap(op, errNoOrgUnits)
+	}
+	if err := validateOrgUnit(parentID, name); err != nil {
+		return models.OrgUnit{}, errs.Wrap(op, err)
+	}
+
+	unit := models.OrgUnit{ParentID: parentID, Name: name}
+	id, err := a.orgUnits.SaveOrgUnit(ctx, unit)
+	if err != nil {
+		switch {
+		case errors.Is(err, errs.ErrOrgUnitNotFound):
+			log.Warn("parent org unit not found")
+		case errors.Is(err, errs.ErrOrgUnitExists):
+			log.Warn("org unit already exists")
+		default:
+			log.Error("failed to save org unit", slog.Any("error", err))
+		}
+
+		return models.OrgUnit{}, errs.Wrap(op, err)
+	}
+	unit.ID = id
+
+	audit.Log(ctx, log, slog.LevelInfo, "org unit created", "org_unit_created", slog.Int64("org_unit_id", id))
+
+	return unit, nil
+}
+
+// MoveOrgUnit moves the unit under parentID, to the root if zero, with
+// everything below it. Roles given above its old place stop holding in it
+// at once.
+//
+// If either unit does not exist, returns errs.ErrOrgUnitNotFound.
+// If parentID is the unit or below it, returns errs.ErrOrgUnitCycle.
+// If the parent already has a unit of the name, returns
+// errs.ErrOrgUnitExists.
+func (a *Auth) MoveOrgUnit(ctx context.Context, id, parentID int64) error {
+	const op = "services.auth.MoveOrgUnit"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("org_unit_id", id),
+		slog.Int64("parent_id", parentID),
+	))
+
+	if a.orgUnits == nil {
+		return errs.Wrap(op, errNoOrgUnits)
+	}
+	switch {
+	case id <= 0:
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "id must be positive"))
+	case parentID < 0:
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "parent_id must not be negative"))
+	}
+
+	if err := a.orgUnits.MoveOrgUnit(ctx, id, parentID); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrOrgUnitNotFound):
+			log.Warn("org unit not found")
+		case errors.Is(err, errs.ErrOrgUnitCycle), errors.Is(err, errs.ErrOrgUnitExists):
+			log.Warn("org unit cannot be moved", slog.Any("error", err))
+		default:
+			log.Error("failed to move org unit", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+	a.unitRoleCache.clear()
+
+	audit.Log(ctx, log, slog.LevelInfo, "org unit moved", "org_unit_moved")
+
+	return nil
+}
+
+// ListOrgUnits returns the root units, by ID, with the units below them in
+// Children, by ID too.
+func (a *Auth) ListOrgUnits(ctx context.Context) ([]models.OrgUnit, error) {
+	const op = "services.auth.ListOrgUnits"
+
+	if a.orgUnits == nil {
+		return nil, errs.Wrap(op, errNoOrgUnits)
+	}
+
+	units, err := a.orgUnits.OrgUnits(ctx)
+	if err != nil {
+		a.log.Error("failed to list org units", slog.String("op", op), slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	return orgUnitTree(units), nil
+}
+
+// UserRolesInUnit returns the roles the user holds in the unit, sorted:
+// those given in the unit or any unit above it, and the global ones, which
+// hold in every unit.
+//
+// If the unit does not exist, returns errs.ErrOrgUnitNotFound.
+func (a *Auth) UserRolesInUnit(ctx context.Context, userID, unitID int64) ([]string, error) {
+	const op = "services.auth.UserRolesInUnit"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+		slog.Int64("org_unit_id", unitID),
+	))
+
+	if a.orgUnits == nil {
+		return nil, errs.Wrap(op, errNoOrgUnits)
+	}
+	if userID <= 0 || unitID <= 0 {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "user_id and unit_id must be positive"))
+	}
+
+	key := unitRoleKey{userID: userID, unitID: unitID}
+	if roles, ok := a.unitRoleCache.get(key, a.clock.Now()); ok {
+		return slices.Clone(roles), nil
+	}
+
+	gen := a.unitRoleCache.generation()
+	roles, err := a.orgUnits.UnitRoles(ctx, userID, unitID)
+	if err != nil {
+		if errors.Is(err, errs.ErrOrgUnitNotFound) {
+			log.Warn("org unit not found")
+		} else {
+			log.Error("failed to get unit roles", slog.Any("error", err))
+		}
+
+		return nil, errs.Wrap(op, err)
+	}
+	a.unitRoleCache.put(key, roles, gen, a.clock.Now().Add(permissionCacheTTL))
+
+	// The cache keeps its own.
+	return slices.Clone(roles), nil
+}
+
+func validateOrgUnit(parentID int64, name string) error {
+	switch {
+	case parentID < 0:
+		return errs.New(errs.InvalidArgument, "parent_id must not be negative")
+	case name == "":
+		return errs.New(errs.InvalidArgument, "name is required")
+	case strings.TrimSpace(name) != name:
+		return errs.New(errs.InvalidArgument, "name must not start or end with spaces")
+	case !utf8.ValidString(name):
+		return errs.New(errs.InvalidArgument, "name must be valid UTF-8")
+	case utf8.RuneCountInString(name) > maxOrgUnitNameLen:
+		return errs.New(errs.InvalidArgument, "name is too long")
+	}
+
+	return nil
+}
+
+// orgUnitTree nests units, sorted by ID, under their parents.
+func orgUnitTree(units []models.OrgUnit) []models.OrgUnit {
+	children := make(map[int64][]models.OrgUnit, len(units))
+	for _, unit := range units {
+		children[unit.ParentID] = append(children[unit.ParentID], unit)
+	}
+
+	var build func(parentID int64) []models.OrgUnit
+	build = func(parentID int64) []models.OrgUnit {
+		res := make([]models.OrgUnit, 0, len(children[parentID]))
+		for _, unit := range children[parentID] {
+			unit.Children = build(unit.ID)
+			res = append(res, unit)
+		}
+
+		return res
+	}
+
+	return build(0)
+}
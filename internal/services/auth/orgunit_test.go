@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgUnits(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t, withStorage(WithOrgUnits))
+
+	uni, err := a.CreateOrgUnit(ctx, 0, "University")
+	require.NoError(t, err)
+	faculty, err := a.CreateOrgUnit(ctx, uni.ID, "Faculty of Physics")
+	require.NoError(t, err)
+	dept, err := a.CreateOrgUnit(ctx, faculty.ID, "Optics")
+	require.NoError(t, err)
+	law, err := a.CreateOrgUnit(ctx, uni.ID, "Faculty of Law")
+	require.NoError(t, err)
+
+	_, err = a.CreateOrgUnit(ctx, uni.ID, "Faculty of Law")
+	assert.ErrorIs(t, err, errs.ErrOrgUnitExists)
+	_, err = a.CreateOrgUnit(ctx, 42, "Lost")
+	assert.ErrorIs(t, err, errs.ErrOrgUnitNotFound)
+	assert.ErrorIs(t, a.MoveOrgUnit(ctx, faculty.ID, dept.ID), errs.ErrOrgUnitCycle)
+
+	require.NoError(t, a.MoveOrgUnit(ctx, dept.ID, law.ID))
+
+	units, err := a.ListOrgUnits(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.OrgUnit{{
+		ID:   uni.ID,
+		Name: "University",
+		Children: []models.OrgUnit{
+			{ID: faculty.ID, ParentID: uni.ID, Name: "Faculty of Physics", Children: []models.OrgUnit{}},
+			{ID: law.ID, ParentID: uni.ID, Name: "Faculty of Law", Children: []models.OrgUnit{
+				{ID: dept.ID, ParentID: law.ID, Name: "Optics", Children: []models.OrgUnit{}},
+			}},
+		},
+	}}, units)
+}
+
+func TestCreateOrgUnit_InvalidName(t *testing.T) {
+	a, _, _ := newTestAuth(t, withStorage(WithOrgUnits))
+
+	for _, name := range []string{"", " Physics", "Physics\n", "\xff", strings.Repeat("ф", maxOrgUnitNameLen+1)} {
+		_, err := a.CreateOrgUnit(context.Background(), 0, name)
+		assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err), "name %q", name)
+	}
+
+	_, err := a.CreateOrgUnit(context.Background(), 0, strings.Repeat("ф", maxOrgUnitNameLen))
+	assert.NoError(t, err)
+}
+
+func TestUserRolesInUnit(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk := newTestAuth(t, withStorage(WithOrgUnits))
+
+	faculty, err := a.CreateOrgUnit(ctx, 0, "Faculty of Physics")
+	require.NoError(t, err)
+	dept, err := a.CreateOrgUnit(ctx, faculty.ID, "Optics")
+	require.NoError(t, err)
+	law, err := a.CreateOrgUnit(ctx, 0, "Faculty of Law")
+	require.NoError(t, err)
+	storage.SetUserRole(1, "student")
+	storage.SetUnitRole(1, faculty.ID, "dean")
+
+	roles, err := a.UserRolesInUnit(ctx, 1, dept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dean", "student"}, roles)
+	_, err = a.UserRolesInUnit(ctx, 1, 42)
+	assert.ErrorIs(t, err, errs.ErrOrgUnitNotFound)
+
+	// Roles given in storage directly show up once the cache expires.
+	storage.SetUnitRole(1, dept.ID, "teacher")
+	roles, err = a.UserRolesInUnit(ctx, 1, dept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dean", "student"}, roles)
+	clk.Advance(permissionCacheTTL)
+	roles, err = a.UserRolesInUnit(ctx, 1, dept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dean", "student", "teacher"}, roles)
+
+	// Moves take effect at once.
+	require.NoError(t, a.MoveOrgUnit(ctx, dept.ID, law.ID))
+	roles, err = a.UserRolesInUnit(ctx, 1, dept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"student", "teacher"}, roles)
+}
+
+func TestOrgUnits_NotConfigured(t *testing.T) {
+	storage := memory.New()
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage)
+	require.NoError(t, err)
+
+	_, err = a.ListOrgUnits(context.Background())
+	assert.ErrorIs(t, err, errNoOrgUnits)
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// checkPassword compares password with the hash of user. A wrong password
+// is errs.ErrInvalidCredentials. A corrupted hash is logged, counted and
+// returned as is, so that the caller fails with Internal and operators
+// notice instead of the user just being unable to log in. So is a
+// comparison that timed out or was canceled.
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, user models.User, password string) error {
+	err := a.comparePassword(ctx, user.PassHash, password)
+	switch {
+	case err == nil:
+		return nil
+	case errs.CodeOf(err) == errs.DependencyTimeout || ctx.Err() != nil:
+		log.Error("failed to compare password", slog.Any("error", err))
+
+		return err
+	case errors.Is(err, ErrInvalidHash):
+		a.invalidHashes.Inc()
+		log.Error("stored password hash is corrupted",
+			slog.Int64("user_id", user.ID),
+			slog.Any("error", err),
+		)
+
+		return err
+	default:
+		log.Info("invalid credentials", slog.Any("error", err))
+
+		return errs.ErrInvalidCredentials
+	}
+}
+
+// InvalidHashes returns how many corrupted password hashes were met since
+// the start.
+func (a *Auth) InvalidHashes() int64 {
+	return a.invalidHashes.Value()
+}
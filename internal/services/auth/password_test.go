@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptHasher(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost}
+
+	hash, err := h.Hash("correct-password")
+	require.NoError(t, err)
+
+	assert.NoError(t, h.Compare(hash, "correct-password"))
+	assert.ErrorIs(t, h.Compare(hash, "wrong-password"), ErrMismatch)
+	assert.NoError(t, h.Check(hash))
+
+	truncated := hash[:len(hash)/2]
+	assert.ErrorIs(t, h.Compare(truncated, "correct-password"), ErrInvalidHash)
+	assert.ErrorIs(t, h.Check(truncated), ErrInvalidHash)
+	assert.ErrorIs(t, h.Check([]byte("garbage")), ErrInvalidHash)
+}
+
+func TestBcryptHasher_Prehash(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost}
+	long := strings.Repeat("correcthorsebatterystaple", 4)
+	require.Greater(t, len(long), 72)
+
+	hash, err := h.Hash(long)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(hash, []byte(PrehashPrefix)))
+	assert.NoError(t, h.Check(hash))
+
+	// Every byte counts, past the 72 bcrypt reads too.
+	assert.NoError(t, h.Compare(hash, long))
+	assert.ErrorIs(t, h.Compare(hash, long[:72]), ErrMismatch)
+	assert.ErrorIs(t, h.Compare(hash, long[:len(long)-1]+"x"), ErrMismatch)
+
+	// Hashes made before the prefix verify as plain bcrypt.
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	assert.NoError(t, h.Compare(legacy, "correct-password"))
+	assert.ErrorIs(t, h.Compare(legacy, "wrong-password"), ErrMismatch)
+	assert.NoError(t, h.Check(legacy))
+
+	// The prefix is no part of the bcrypt hash.
+	assert.ErrorIs(t, h.Compare(append([]byte(PrehashPrefix), legacy...), "correct-password"), ErrMismatch)
+	assert.ErrorIs(t, h.Check([]byte(PrehashPrefix+"garbage")), ErrInvalidHash)
+}
+
+func TestRegisterNewUser_LongPassword(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t)
+
+	long := strings.Repeat("correcthorsebatterystaple", 4)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", long, "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", long, 1)
+	assert.NoError(t, err)
+	_, err = a.Login(ctx, "user@example.com", long[:72], 1)
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+}
+
+func TestLogin_CorruptedHash(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	a, storage, _ := newTestAuthWithApp(t)
+	a.log = slog.New(slog.NewTextHandler(&logs, nil))
+
+	id, err := storage.SaveUser(ctx, "user@example.com", []byte("$2a$04$truncated"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	assert.ErrorIs(t, err, ErrInvalidHash)
+	assert.NotErrorIs(t, err, errs.ErrInvalidCredentials)
+	assert.Equal(t, errs.Internal, errs.CodeOf(err))
+
+	assert.Equal(t, int64(1), a.InvalidHashes())
+	assert.Contains(t, logs.String(), "level=ERROR")
+	assert.Contains(t, logs.String(), fmt.Sprintf("user_id=%d", id))
+
+	// A wrong password on a sound hash is not counted.
+	_, err = a.RegisterNewUser(ctx, "jane@example.com", "correct-password", "Jane", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "jane@example.com", "wrong-password", 1)
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	assert.Equal(t, int64(1), a.InvalidHashes())
+}
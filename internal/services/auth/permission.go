@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/jwt"
+)
+
+const (
+	// permissionCacheTTL is how long resolved permissions are reused.
+	// Changes made through the service drop them at once; the TTL bounds
+	// how long roles changed in storage directly go unnoticed.
+	permissionCacheTTL = time.Minute
+	// lookupCacheSize is the number of keys cached before a cache is
+	// emptied.
+	lookupCacheSize = 4096
+	// maxPermissionLen bounds the name of a permission.
+	maxPermissionLen = 64
+)
+
+// errNoPermissions is returned by the permission methods when the service
+// was built without WithPermissions.
+var errNoPermissions = errors.New("permission storage is not configured")
+
+type PermissionStorage interface {
+	AttachPermission(ctx context.Context, role string, appID int32, permission string) error
+	DetachPermission(ctx context.Context, role string, appID int32, permission string) error
+	RolePermissions(ctx context.Context, role string, appID int32) ([]string, error)
+	UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error)
+}
+
+// UserPermissions returns the permissions of the app the roles of the
+// user grant, sorted by name. Login tokens carry the same list in their
+// perms claim, see jwt.Claims.HasPermission.
+func (a *Auth) UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error) {
+	const op = "services.auth.UserPermissions"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if a.permissions == nil {
+		return nil, errs.Wrap(op, errNoPermissions)
+	}
+
+	permissions, err := a.resolvePermissions(ctx, userID, appID)
+	if err != nil {
+		log.Error("failed to get permissions", slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	// The cache keeps its own.
+	return slices.Clone(permissions), nil
+}
+
+// AttachPermission grants permission of the app to every user of role,
+// creating the permission on first use. Attaching it again is a no-op.
+//
+// If role is not given to any user, returns errs.InvalidArgument.
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (a *Auth) AttachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	const op = "services.auth.AttachPermission"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.String("role", role),
+		slog.String("permission", permission),
+	))
+
+	if a.permissions == nil {
+		return errs.Wrap(op, errNoPermissions)
+	}
+	if err := validateRolePermission(role, appID, permission); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	if err := a.permissions.AttachPermission(ctx, role, appID, permission); err != nil {
+		switch {
+		case errors.Is(err, errs.ErrRoleNotFound):
+			log.Warn("role not found")
+
+			// Role lookups are not public, see grpcerr.
+			return errs.Wrap(op, errs.New(errs.InvalidArgument, "unknown role"))
+		case errors.Is(err, errs.ErrAppNotFound):
+			log.Warn("app not found", slog.Int("app_id", int(appID)))
+		default:
+			log.Error("failed to attach permission", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+	a.permissionCache.clear()
+
+	log.Info("permission attached", slog.Int("app_id", int(appID)))
+
+	return nil
+}
+
+// DetachPermission takes permission of the app back from role. Tokens
+// issued before keep it until they expire.
+//
+// If role does not have it, returns errs.ErrPermissionNotFound.
+func (a *Auth) DetachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	const op = "services.auth.DetachPermission"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.String("role", role),
+		slog.String("permission", permission),
+	))
+
+	if a.permissions == nil {
+		return errs.Wrap(op, errNoPermissions)
+	}
+	if err := validateRolePermission(role, appID, permission); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	if err := a.permissions.DetachPermission(ctx, role, appID, permission); err != nil {
+		if errors.Is(err, errs.ErrPermissionNotFound) {
+			log.Warn("permission not attached")
+		} else {
+			log.Error("failed to detach permission", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+	a.permissionCache.clear()
+
+	log.Info("permission detached", slog.Int("app_id", int(appID)))
+
+	return nil
+}
+
+// RolePermissions returns the permissions of the app attached to role,
+// sorted by name.
+func (a *Auth) RolePermissions(ctx context.Context, role string, appID int32) ([]string, error) {
+	const op = "services.auth.RolePermissions"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.String("role", role),
+	))
+
+	if a.permissions == nil {
+		return nil, errs.Wrap(op, errNoPermissions)
+	}
+	if role == "" {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "role is required"))
+	}
+	if appID <= 0 {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+
+	permissions, err := a.permissions.RolePermissions(ctx, role, appID)
+	if err != nil {
+		log.Error("failed to get permissions", slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	return permissions, nil
+}
+
+// permissionClaims returns the options adding the permissions of the user
+// in app to a login token, none without WithPermissions.
+func (a *Auth) permissionClaims(ctx context.Context, log *slog.Logger, userID int64, appID int32) ([]jwt.TokenOption, error) {
+	if a.permissions == nil {
+		return nil, nil
+	}
+
+	permissions, err := a.resolvePermissions(ctx, userID, appID)
+	if err != nil {
+		log.Error("failed to get permissions", slog.Any("error", err))
+
+		return nil, err
+	}
+
+	return []jwt.TokenOption{jwt.Permissions(permissions)}, nil
+}
+
+func (a *Auth) resolvePermissions(ctx context.Context, userID int64, appID int32) ([]string, error) {
+	key := permissionKey{userID: userID, appID: appID}
+	if permissions, ok := a.permissionCache.get(key, a.clock.Now()); ok {
+		return permissions, nil
+	}
+
+	// Read before the fetch, so that a change landing during it is not
+	// hidden by the stale result.
+	gen := a.permissionCache.generation()
+	permissions, err := a.permissions.UserPermissions(ctx, userID, appID)
+	if err != nil {
+		return nil, err
+	}
+	a.permissionCache.put(key, permissions, gen, a.clock.Now().Add(permissionCacheTTL))
+
+	return permissions, nil
+}
+
+func validateRolePermission(role string, appID int32, permission string) error {
+	switch {
+	case role == "":
+		return errs.New(errs.InvalidArgument, "role is required")
+	case appID <= 0:
+		return errs.New(errs.InvalidArgument, "app_id must be positive")
+	case permission == "":
+		return errs.New(errs.InvalidArgument, "permission is required")
+	case len(permission) > maxPermissionLen:
+		return errs.New(errs.InvalidArgument, "permission is too long")
+	}
+	for _, r := range permission {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == ':') {
+			return errs.New(errs.InvalidArgument, "permission must be lowercase letters, digits, '_', '.' or ':'")
+		}
+	}
+
+	return nil
+}
+
+type permissionKey struct {
+	userID int64
+	appID  int32
+}
+
+type cachedLookup struct {
+	values  []string
+	expires time.Time
+}
+
+// lookupCache keeps lists resolved from storage by key, such as the
+// permissions of a user in an app. Every change empties it and bumps its
+// generation, so results fetched before the change are not stored after
+// it.
+type lookupCache[K comparable] struct {
+	mu    sync.Mutex
+	gen   uint64
+	items map[K]cachedLookup
+}
+
+func newLookupCache[K comparable]() *lookupCache[K] {
+	return &lookupCache[K]{items: make(map[K]cachedLookup)}
+}
+
+func (c *lookupCache[K]) get(key K, now time.Time) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || !now.Before(item.expires) {
+		return nil, false
+	}
+
+	return item.values, true
+}
+
+func (c *lookupCache[K]) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+func (c *lookupCache[K]) put(key K, values []string, gen uint64, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if len(c.items) >= lookupCacheSize {
+		clear(c.items)
+	}
+	c.items[key] = cachedLookup{values: values, expires: expires}
+}
+
+func (c *lookupCache[K]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	clear(c.items)
+}
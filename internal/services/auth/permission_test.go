@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPermissionAuth(t *testing.T) (*Auth, *memory.Storage, *clock.Fake, int64) {
+	t.Helper()
+
+	a, storage, clk := newTestAuthWithApp(t, withStorage(WithPermissions))
+
+	user, err := a.RegisterNewUser(context.Background(), "teacher@example.com", "correct-password", "Jane", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	userID := user.ID
+	storage.SetUserRole(userID, "teacher")
+
+	return a, storage, clk, userID
+}
+
+func TestLogin_Permissions(t *testing.T) {
+	ctx := context.Background()
+	a, _, _, userID := newTestPermissionAuth(t)
+
+	require.NoError(t, a.AttachPermission(ctx, "teacher", 1, "can_grade"))
+
+	token, err := a.Login(ctx, "teacher@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.True(t, claims.HasPermission("can_grade"))
+	assert.False(t, claims.HasPermission("can_manage_enrollments"))
+
+	// Changes show up in the next token, cached or not.
+	require.NoError(t, a.AttachPermission(ctx, "teacher", 1, "can_manage_enrollments"))
+	require.NoError(t, a.DetachPermission(ctx, "teacher", 1, "can_grade"))
+
+	token, err = a.Login(ctx, "teacher@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	claims, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.False(t, claims.HasPermission("can_grade"))
+	assert.True(t, claims.HasPermission("can_manage_enrollments"))
+
+	perms, err := a.UserPermissions(ctx, userID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_manage_enrollments"}, perms)
+	perms, err = a.RolePermissions(ctx, "teacher", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_manage_enrollments"}, perms)
+}
+
+func TestUserPermissions_Cache(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk, userID := newTestPermissionAuth(t)
+
+	storage.SetUserRole(userID+1, "student")
+	require.NoError(t, a.AttachPermission(ctx, "student", 1, "can_submit"))
+
+	perms, err := a.UserPermissions(ctx, userID, 1)
+	require.NoError(t, err)
+	assert.Empty(t, perms)
+
+	// Roles changed in storage go unnoticed until the cache expires.
+	storage.SetUserRole(userID, "student")
+	perms, err = a.UserPermissions(ctx, userID, 1)
+	require.NoError(t, err)
+	assert.Empty(t, perms)
+
+	clk.Advance(permissionCacheTTL)
+	perms, err = a.UserPermissions(ctx, userID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_submit"}, perms)
+}
+
+func TestAttachPermission_Invalid(t *testing.T) {
+	ctx := context.Background()
+	a, _, _, _ := newTestPermissionAuth(t)
+
+	for _, tt := range []struct {
+		role       string
+		appID      int32
+		permission string
+	}{
+		{"", 1, "can_grade"},
+		{"teacher", 0, "can_grade"},
+		{"teacher", 1, ""},
+		{"teacher", 1, "Can Grade"},
+		{"nobody", 1, "can_grade"},
+	} {
+		err := a.AttachPermission(ctx, tt.role, tt.appID, tt.permission)
+		assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err), "%+v: %v", tt, err)
+	}
+
+	assert.ErrorIs(t, a.AttachPermission(ctx, "teacher", 2, "can_grade"), errs.ErrAppNotFound)
+	assert.ErrorIs(t, a.DetachPermission(ctx, "teacher", 1, "can_grade"), errs.ErrPermissionNotFound)
+}
+
+func TestUserPermissions_NotConfigured(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.UserPermissions(ctx, 1, 1)
+	assert.ErrorIs(t, err, errNoPermissions)
+}
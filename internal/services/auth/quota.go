@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+)
+
+// errNoAppQuotas is returned by the quota methods, and by registrations
+// attributed to an app, when the service was built without
+// WithAppQuotas.
+var errNoAppQuotas = errors.New("app quota storage is not configured")
+
+type AppQuotaStorage interface {
+	SaveAppUser(
+		ctx context.Context,
+		appID int32,
+		email string,
+		passHash []byte,
+		firstName string,
+		lastName string,
+		middleName string,
+	) (int64, error)
+	SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error
+	AppQuota(ctx context.Context, appID int32) (models.AppQuota, error)
+}
+
+// SetAppMaxUsers caps the users that can register through the app, see
+// RegisterNewUser, zero to clear the quota. A quota below the users
+// already registered only stops new ones.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (a *Auth) SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error {
+	const op = "services.auth.SetAppMaxUsers"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	))
+
+	if a.appQuotas == nil {
+		return errs.Wrap(op, errNoAppQuotas)
+	}
+	if appID <= 0 {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+	if maxUsers < 0 {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "max_users must not be negative"))
+	}
+
+	if err := a.appQuotas.SetAppMaxUsers(ctx, appID, maxUsers); err != nil {
+		if !errors.Is(err, errs.ErrAppNotFound) {
+			log.Error("failed to set app quota", slog.Any("error", err))
+		}
+
+		return errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "app quota set", "app_quota_set", slog.Int("max_users", maxUsers))
+
+	return nil
+}
+
+// AppQuota returns the user quota of the app and how many users have
+// registered through it.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (a *Auth) AppQuota(ctx context.Context, appID int32) (models.AppQuota, error) {
+	const op = "services.auth.AppQuota"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	if a.appQuotas == nil {
+		return models.AppQuota{}, errs.Wrap(op, errNoAppQuotas)
+	}
+	if appID <= 0 {
+		return models.AppQuota{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+
+	quota, err := a.appQuotas.AppQuota(ctx, appID)
+	if err != nil {
+		if !errors.Is(err, errs.ErrAppNotFound) {
+			log.Error("failed to get app quota", slog.Any("error", err))
+		}
+
+		return models.AppQuota{}, errs.Wrap(op, err)
+	}
+
+	return quota, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterNewUser_AppQuota(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+	a.appQuotas = storage
+	a.events = storage
+
+	require.NoError(t, a.SetAppMaxUsers(ctx, 1, 1))
+
+	user, err := a.RegisterNewUser(ctx, "first@example.com", "correct-password", "John", "Doe", "", "", "", 1)
+	require.NoError(t, err)
+	id := user.ID
+
+	_, err = a.RegisterNewUser(ctx, "second@example.com", "correct-password", "Jane", "Doe", "", "", "", 1)
+	assert.ErrorIs(t, err, errs.ErrQuotaExceeded)
+
+	// Registrations not attributed to the app do not count.
+	_, err = a.RegisterNewUser(ctx, "third@example.com", "correct-password", "Jim", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	quota, err := a.AppQuota(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.AppQuota{AppID: 1, MaxUsers: 1, Users: 1}, quota)
+
+	require.NoError(t, a.SetAppMaxUsers(ctx, 1, 0))
+	_, err = a.RegisterNewUser(ctx, "second@example.com", "correct-password", "Jane", "Doe", "", "", "", 1)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "fourth@example.com", "correct-password", "Joe", "Doe", "", "", "", 2)
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, id, events[0].UserID)
+	assert.Equal(t, int32(1), events[0].AppID)
+}
+
+func TestSetAppMaxUsers_InvalidArgument(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+	a.appQuotas = storage
+
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(a.SetAppMaxUsers(ctx, 0, 10)))
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(a.SetAppMaxUsers(ctx, 1, -1)))
+	assert.ErrorIs(t, a.SetAppMaxUsers(ctx, 2, 10), errs.ErrAppNotFound)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", -1)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+}
+
+func TestAppQuota_NotConfigured(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.AppQuota(ctx, 1)
+	assert.ErrorIs(t, err, errNoAppQuotas)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 1)
+	assert.ErrorIs(t, err, errNoAppQuotas)
+}
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/reserved"
+)
+
+// RegistrationMode decides who may register, see WithRegistrationMode.
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone register.
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationClosed refuses every registration with
+	// errs.ErrRegistrationDisabled.
+	RegistrationClosed RegistrationMode = "closed"
+	// RegistrationInvite requires an invite code from CreateInvite.
+	RegistrationInvite RegistrationMode = "invite"
+)
+
+// errNoInvites is returned by CreateInvite when the service was built
+// without WithInvites.
+var errNoInvites = errors.New("invite storage is not configured")
+
+type InviteStorage interface {
+	SaveInvite(ctx context.Context, invite models.Invite) (int64, error)
+	Invite(ctx context.Context, codeHash string) (models.Invite, error)
+	SaveInvitedUser(
+		ctx context.Context,
+		inviteID int64,
+		appID int32,
+		email string,
+		passHash []byte,
+		firstName string,
+		lastName string,
+		middleName string,
+		usedAt time.Time,
+	) (int64, error)
+}
+
+// RegistrationMode returns the registration mode in effect.
+func (a *Auth) RegistrationMode() RegistrationMode {
+	return *a.registration.Load()
+}
+
+// SetRegistrationMode changes the registration mode of the running
+// service. Registrations already past the check are not affected.
+func (a *Auth) SetRegistrationMode(mode RegistrationMode) error {
+	const op = "services.auth.SetRegistrationMode"
+
+	if err := a.checkRegistrationMode(mode); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if old := a.registration.Swap(&mode); *old != mode {
+		a.log.Info("registration mode changed",
+			slog.String("op", op),
+			slog.String("old", string(*old)),
+			slog.String("new", string(mode)),
+		)
+	}
+
+	return nil
+}
+
+// SetEmailDomains replaces the email domain policy of the running service,
+// see WithEmailDomains.
+func (a *Auth) SetEmailDomains(policy *emaildomain.Policy) {
+	a.emailDomains.Store(policy)
+}
+
+// SetReservedIdentifiers replaces the reserved list of the running
+// service, see WithReservedIdentifiers.
+func (a *Auth) SetReservedIdentifiers(list *reserved.List) {
+	a.reserved.Store(list)
+}
+
+func (a *Auth) checkRegistrationMode(mode RegistrationMode) error {
+	switch mode {
+	case RegistrationOpen, RegistrationClosed:
+		return nil
+	case RegistrationInvite:
+		if a.invites == nil {
+			return errNoInvites
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown registration mode %q", mode)
+}
+
+// CreateInvite creates an invite that registers up to uses users within
+// ttl, zero meaning it never expires. A non-empty email restricts it to
+// that address. The returned code is not stored and is not shown again.
+func (a *Auth) CreateInvite(
+	ctx context.Context,
+	email string,
+	uses int,
+	ttl time.Duration,
+) (string, models.Invite, error) {
+	const op = "services.auth.CreateInvite"
+
+	if err := sanitize(input{"email", &email}); err != nil {
+		return "", models.Invite{}, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+	))
+
+	if a.invites == nil {
+		return "", models.Invite{}, errs.Wrap(op, errNoInvites)
+	}
+	if uses <= 0 {
+		return "", models.Invite{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "uses must be positive"))
+	}
+	if ttl < 0 {
+		return "", models.Invite{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "ttl must not be negative"))
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return "", models.Invite{}, errs.Wrap(op, err)
+	}
+
+	now := a.clock.Now()
+	invite := models.Invite{
+		CodeHash:      hashCode(code),
+		Email:         email,
+		UsesRemaining: uses,
+		CreatedAt:     now,
+	}
+	if ttl > 0 {
+		invite.ExpiresAt = now.Add(ttl)
+	}
+	invite.ID, err = a.invites.SaveInvite(ctx, invite)
+	if err != nil {
+		log.Error("failed to save invite", slog.Any("error", err))
+
+		return "", models.Invite{}, errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "invite created", "invite_created",
+		slog.Int64("invite_id", invite.ID),
+		slog.Bool("email_restricted", email != ""),
+		slog.Int("uses", uses),
+		slog.Time("expires_at", invite.ExpiresAt),
+	)
+
+	return code, invite, nil
+}
+
+// invite returns the invite of code if it can register email.
+//
+// A missing code gives errs.ErrInviteRequired, a stale invite
+// errs.ErrInviteExpired and any other unusable one errs.ErrInvalidInvite.
+func (a *Auth) invite(ctx context.Context, log *slog.Logger, code, email string) (models.Invite, error) {
+	if code == "" {
+		log.Warn("invite code missing")
+
+		return models.Invite{}, errs.ErrInviteRequired
+	}
+
+	invite, err := a.invites.Invite(ctx, hashCode(code))
+	if err != nil {
+		if errors.Is(err, errs.ErrInviteNotFound) {
+			log.Warn("invite not found")
+
+			return models.Invite{}, errs.ErrInvalidInvite
+		}
+		log.Error("failed to get invite", slog.Any("error", err))
+
+		return models.Invite{}, err
+	}
+
+	log = log.With(slog.Int64("invite_id", invite.ID))
+	switch {
+	case !invite.ExpiresAt.IsZero() && !a.clock.Now().Before(invite.ExpiresAt):
+		log.Info("invite expired")
+
+		return models.Invite{}, errs.ErrInviteExpired
+	case invite.UsesRemaining <= 0:
+		log.Warn("invite used up")
+
+		return models.Invite{}, errs.ErrInvalidInvite
+	case invite.Email != "" && !strings.EqualFold(invite.Email, email):
+		log.Warn("invite is for another email")
+
+		return models.Invite{}, errs.ErrInvalidInvite
+	}
+
+	return invite, nil
+}
+
+// registrationAttempted tells the owner of email that someone tried to
+// register with it. The password was hashed already, so this path costs
+// about as much as creating the user.
+func (a *Auth) registrationAttempted(ctx context.Context, log *slog.Logger, email string) {
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		log.Warn("failed to get existing user", slog.Any("error", err))
+
+		return
+	}
+
+	a.publish(ctx, log, models.EventRegistrationAttempted, user.ID, 0, map[string]string{"email": email})
+}
+
+// RegistrationStorage saves a registration with its invite use, terms
+// acceptance and outbox event in one transaction, see WithRegistrations.
+type RegistrationStorage interface {
+	SaveRegistration(ctx context.Context, reg models.Registration) (int64, error)
+}
+
+// saveRegistration saves the user of reg. With WithRegistrations, the
+// rest of reg and the registration event are saved in the same
+// transaction; otherwise only the user and its invite use are, and
+// registered records the rest.
+func (a *Auth) saveRegistration(ctx context.Context, log *slog.Logger, reg models.Registration) (int64, error) {
+	if a.registrations != nil {
+		if a.events != nil {
+			payload, err := json.Marshal(map[string]string{"email": reg.Email})
+			if err != nil {
+				log.Error("failed to encode event", slog.String("event", models.EventUserRegistered), slog.Any("error", err))
+			} else {
+				reg.Event = models.Event{
+					Type:      models.EventUserRegistered,
+					AppID:     reg.AppID,
+					Payload:   payload,
+					CreatedAt: reg.RegisteredAt,
+				}
+			}
+		}
+
+		return a.registrations.SaveRegistration(ctx, reg)
+	}
+
+	switch {
+	case reg.InviteID != 0:
+		return a.invites.SaveInvitedUser(ctx, reg.InviteID, reg.AppID,
+			reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName, reg.RegisteredAt)
+	case reg.AppID != 0:
+		return a.appQuotas.SaveAppUser(ctx, reg.AppID, reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName)
+	default:
+		return a.userSaver.SaveUser(ctx, reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName)
+	}
+}
+
+// registered records what saveRegistration left of reg for the new user
+// id: the event and the terms acceptance, unless they were saved with it.
+// Deliveries of the event are up to the outbox consumers, which retry
+// them on their own schedule.
+func (a *Auth) registered(ctx context.Context, log *slog.Logger, reg models.Registration, id int64) error {
+	if a.registrations != nil {
+		if reg.TermsVersion != "" {
+			audit.Log(ctx, log, slog.LevelInfo, "terms of service accepted", "tos_accepted",
+				slog.String("tos_version", reg.TermsVersion),
+				slog.Time("accepted_at", reg.RegisteredAt),
+			)
+		}
+
+		return nil
+	}
+
+	a.publish(ctx, log, models.EventUserRegistered, id, reg.AppID, map[string]string{"email": reg.Email})
+
+	if reg.TermsVersion != "" {
+		// The user exists already: if this fails, the acceptance is asked
+		// for again on login.
+		return a.recordTerms(ctx, log, id, reg.TermsVersion)
+	}
+
+	return nil
+}
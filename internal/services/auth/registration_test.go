@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/emaildomain"
+	"sso/internal/lib/reserved"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRegisterNewUser_Closed(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+	require.NoError(t, a.SetRegistrationMode(RegistrationClosed))
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrRegistrationDisabled)
+	_, err = storage.User(ctx, "user@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	require.NoError(t, a.SetRegistrationMode(RegistrationOpen))
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNewUser_Profile(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	user, err := a.RegisterNewUser(ctx, " John@Example.com ", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	stored, err := storage.UserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email)
+	assert.Equal(t, "John", user.FirstName)
+	assert.Equal(t, "Doe", user.LastName)
+	assert.Equal(t, stored.CreatedAt, user.CreatedAt)
+	assert.Nil(t, user.PassHash)
+	assert.Empty(t, user.Role, "registration assigns no role")
+}
+
+func TestRegisterNewUser_MiddleName(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+
+	for email, tt := range map[string]struct{ given, want string }{
+		"none@example.com":  {given: "", want: ""},
+		"blank@example.com": {given: "  ", want: ""},
+		"named@example.com": {given: " Ivanovich ", want: "Ivanovich"},
+	} {
+		_, err := a.RegisterNewUser(ctx, email, "correct-password", "Ivan", "Petrov", tt.given, "", "", 0)
+		require.NoError(t, err)
+		user, err := storage.User(ctx, email)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, user.MiddleName, email)
+	}
+}
+
+func TestRegisterNewUser_Invite(t *testing.T) {
+	ctx := context.Background()
+	a, storage, fake := newTestAuth(t)
+	require.NoError(t, a.SetRegistrationMode(RegistrationInvite))
+
+	code, invite, err := a.CreateInvite(ctx, "", 2, time.Hour)
+	require.NoError(t, err)
+	assert.NotEmpty(t, code)
+	assert.NotEqual(t, code, invite.CodeHash)
+	assert.True(t, fake.Now().Add(time.Hour).Equal(invite.ExpiresAt))
+
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrInviteRequired)
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "wrong", 0)
+	assert.ErrorIs(t, err, errs.ErrInvalidInvite)
+
+	registered, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", code, 0)
+	require.NoError(t, err)
+	id := registered.ID
+	// A failed registration does not spend a use.
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", code, 0)
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+	other, err := a.RegisterNewUser(ctx, "jane@example.com", "correct-password", "Jane", "Doe", "", "", code, 0)
+	require.NoError(t, err)
+	otherID := other.ID
+
+	_, err = a.RegisterNewUser(ctx, "sam@example.com", "correct-password", "Sam", "Doe", "", "", code, 0)
+	assert.ErrorIs(t, err, errs.ErrInvalidInvite)
+
+	uses, err := storage.InviteUses(ctx, invite.ID)
+	require.NoError(t, err)
+	require.Len(t, uses, 2)
+	assert.Equal(t, id, uses[0].UserID)
+	assert.Equal(t, otherID, uses[1].UserID)
+}
+
+func TestRegisterNewUser_OneTransaction(t *testing.T) {
+	ctx := context.Background()
+	a, storage, fake := newTestAuthWithApp(t)
+	a.registrations = storage
+	a.appQuotas = storage
+	a.events = storage
+	a.termsVersion = "v1"
+	require.NoError(t, a.SetAppMaxUsers(ctx, 1, 1))
+	require.NoError(t, a.SetRegistrationMode(RegistrationInvite))
+	code, invite, err := a.CreateInvite(ctx, "", 2, time.Hour)
+	require.NoError(t, err)
+
+	registered, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "v1", code, 1)
+	require.NoError(t, err)
+	id := registered.ID
+
+	user, err := storage.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", user.TermsVersion)
+	assert.True(t, fake.Now().Equal(user.TermsAcceptedAt))
+	uses, err := storage.InviteUses(ctx, invite.ID)
+	require.NoError(t, err)
+	assert.Len(t, uses, 1)
+
+	// Refused by the quota: nothing of the registration is kept.
+	_, err = a.RegisterNewUser(ctx, "jane@example.com", "correct-password", "Jane", "Doe", "", "v1", code, 1)
+	assert.ErrorIs(t, err, errs.ErrQuotaExceeded)
+	uses, err = storage.InviteUses(ctx, invite.ID)
+	require.NoError(t, err)
+	assert.Len(t, uses, 1)
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.EventUserRegistered, events[0].Type)
+	assert.Equal(t, id, events[0].UserID)
+	assert.Equal(t, int32(1), events[0].AppID)
+	assert.JSONEq(t, `{"email":"john@example.com"}`, string(events[0].Payload))
+}
+
+func TestRegisterNewUser_InviteRestrictions(t *testing.T) {
+	ctx := context.Background()
+	a, _, fake := newTestAuth(t)
+	require.NoError(t, a.SetRegistrationMode(RegistrationInvite))
+
+	code, _, err := a.CreateInvite(ctx, "John@Example.com", 5, time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "jane@example.com", "correct-password", "Jane", "Doe", "", "", code, 0)
+	assert.ErrorIs(t, err, errs.ErrInvalidInvite)
+
+	fake.Advance(time.Hour)
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", code, 0)
+	assert.ErrorIs(t, err, errs.ErrInviteExpired)
+
+	code, invite, err := a.CreateInvite(ctx, "john@example.com", 1, 0)
+	require.NoError(t, err)
+	assert.True(t, invite.ExpiresAt.IsZero())
+	fake.Advance(365 * 24 * time.Hour)
+	_, err = a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", code, 0)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNewUser_InviteIgnoredWhenOpen(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "whatever", 0)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNewUser_EmailDomains(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	policy, err := emaildomain.New([]string{"university.edu", "*.university.edu"}, []string{"spam.university.edu"})
+	require.NoError(t, err)
+	a.SetEmailDomains(policy)
+
+	_, err = a.RegisterNewUser(ctx, "john@gmail.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrEmailDomainNotAllowed)
+	_, err = a.RegisterNewUser(ctx, "john@spam.university.edu", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrEmailDomainNotAllowed)
+
+	_, err = a.RegisterNewUser(ctx, "john@University.edu", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "jane@cs.university.edu", "correct-password", "Jane", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+
+	a.SetEmailDomains(nil)
+	_, err = a.RegisterNewUser(ctx, "john@gmail.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNewUser_Reserved(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	list, err := reserved.New([]string{"admin*", "support"})
+	require.NoError(t, err)
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+		WithActivation(storage, time.Hour),
+		WithReservedIdentifiers(list),
+	)
+	require.NoError(t, err)
+
+	for _, email := range []string{"admin@ourdomain.edu", "Ad.Ministrator@ourdomain.edu", "support+x@ourdomain.edu"} {
+		_, err = a.RegisterNewUser(ctx, email, "correct-password", "John", "Doe", "", "", "", 0)
+		assert.ErrorIs(t, err, errs.ErrReservedIdentifier, email)
+	}
+	_, err = a.RegisterNewUser(ctx, "supporter@ourdomain.edu", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+
+	// Admins create the accounts of reserved emails.
+	_, err = a.PreRegisterUser(ctx, "support@ourdomain.edu", "Help", "Desk", "")
+	assert.NoError(t, err)
+
+	a.SetReservedIdentifiers(nil)
+	_, err = a.RegisterNewUser(ctx, "admin@ourdomain.edu", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.NoError(t, err)
+}
+
+func TestRegisterNewUser_CanonicalEmails(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+		WithRegistrations(storage),
+		WithCanonicalEmails(emailaddr.NewCanonicalizer(emailaddr.KnownProviders)),
+	)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "Na.Me@gmail.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "na.me@gmail.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+	for _, email := range []string{"name@gmail.com", "name+trial@gmail.com", "n.a.m.e@googlemail.com"} {
+		_, err = a.RegisterNewUser(ctx, email, "correct-password", "John", "Doe", "", "", "", 0)
+		assert.ErrorIs(t, err, errs.ErrCanonicalEmailExists, email)
+	}
+	// Dots count elsewhere.
+	_, err = a.RegisterNewUser(ctx, "name@outlook.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "na.me@outlook.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	// The email is kept as given, normalized.
+	user, err := storage.User(ctx, "na.me@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, "na.me@gmail.com", user.Email)
+
+	_, err = NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithCanonicalEmails(emailaddr.NewCanonicalizer(nil)),
+	)
+	assert.Error(t, err, "canonical emails without registrations")
+}
+
+// countingHasher counts the passwords it hashes.
+type countingHasher struct {
+	BcryptHasher
+	hashed *int
+}
+
+func (h countingHasher) Hash(password string) ([]byte, error) {
+	*h.hashed++
+
+	return h.BcryptHasher.Hash(password)
+}
+
+func TestRegisterNewUser_Concealed(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var hashed int
+	a, err := NewService(log, storage, storage, storage,
+		WithEvents(storage),
+		WithHasher(countingHasher{BcryptHasher: BcryptHasher{Cost: bcrypt.MinCost}, hashed: &hashed}),
+		WithConcealedRegistration(true),
+	)
+	require.NoError(t, err)
+
+	created, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	duplicate, err := a.RegisterNewUser(ctx, "user@example.com", "other-password", "Jane", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	assert.Zero(t, created)
+	assert.Equal(t, created, duplicate)
+	assert.Equal(t, 2, hashed, "the duplicate is hashed as well")
+
+	user, err := storage.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "John", user.FirstName)
+
+	events, err := storage.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.EventUserRegistered, events[0].Type)
+	assert.Equal(t, models.EventRegistrationAttempted, events[1].Type)
+	assert.Equal(t, user.ID, events[1].UserID)
+	assert.JSONEq(t, `{"email":"user@example.com"}`, string(events[1].Payload))
+
+	// Errors that do not depend on the email are still returned.
+	require.NoError(t, a.SetRegistrationMode(RegistrationClosed))
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrRegistrationDisabled)
+}
+
+func TestCreateInvite_Invalid(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	_, _, err := a.CreateInvite(ctx, "", 0, time.Hour)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, _, err = a.CreateInvite(ctx, "", 1, -time.Hour)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+}
+
+func TestRegistrationMode_NeedsInvites(t *testing.T) {
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := NewService(log, storage, storage, storage, WithRegistrationMode(RegistrationInvite))
+	assert.Error(t, err)
+	_, err = NewService(log, storage, storage, storage, WithRegistrationMode("pilot"))
+	assert.Error(t, err)
+
+	a, err := NewService(log, storage, storage, storage)
+	require.NoError(t, err)
+	assert.Equal(t, RegistrationOpen, a.RegistrationMode())
+	assert.Error(t, a.SetRegistrationMode(RegistrationInvite))
+	assert.Equal(t, RegistrationOpen, a.RegistrationMode())
+
+	_, _, err = a.CreateInvite(context.Background(), "", 1, 0)
+	assert.ErrorIs(t, err, errNoInvites)
+}
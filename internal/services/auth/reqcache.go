@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/reqcache"
+)
+
+// The keys of the reads kept by the request cache, see WithRequestCache.
+type (
+	userByEmailKey string
+	userByIDKey    int64
+	userRoleKey    int64
+	appByIDKey     int32
+	appByNameKey   string
+)
+
+// withRequestCache makes the user and app lookups go through the cache of
+// the request, if it has one. Only the lookups of single entities are
+// kept; the batch and stream reads are not repeated within a request.
+func (a *Auth) withRequestCache() {
+	a.userProvider = cachedUserProvider{a.userProvider}
+	a.appProvider = cachedAppProvider{a.appProvider}
+	a.userSaver = forgetfulUserSaver{a.userSaver}
+}
+
+type cachedUserProvider struct {
+	UserProvider
+}
+
+func (c cachedUserProvider) User(ctx context.Context, email string) (models.User, error) {
+	return reqcache.Load(ctx, userByEmailKey(email), func() (models.User, error) {
+		return c.UserProvider.User(ctx, email)
+	})
+}
+
+func (c cachedUserProvider) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	return reqcache.Load(ctx, userByIDKey(userID), func() (models.User, error) {
+		return c.UserProvider.UserByID(ctx, userID)
+	})
+}
+
+func (c cachedUserProvider) UserRole(ctx context.Context, userID int64) (string, error) {
+	return reqcache.Load(ctx, userRoleKey(userID), func() (string, error) {
+		return c.UserProvider.UserRole(ctx, userID)
+	})
+}
+
+type cachedAppProvider struct {
+	AppProvider
+}
+
+func (c cachedAppProvider) App(ctx context.Context, appID int32) (models.App, error) {
+	return reqcache.Load(ctx, appByIDKey(appID), func() (models.App, error) {
+		return c.AppProvider.App(ctx, appID)
+	})
+}
+
+func (c cachedAppProvider) AppByName(ctx context.Context, name string) (models.App, error) {
+	return reqcache.Load(ctx, appByNameKey(name), func() (models.App, error) {
+		return c.AppProvider.AppByName(ctx, name)
+	})
+}
+
+// forgetfulUserSaver drops the cache of the request on every change of a
+// user, in case a method the cache is on for writes after all.
+type forgetfulUserSaver struct {
+	UserSaver
+}
+
+func (f forgetfulUserSaver) SaveUser(
+	ctx context.Context,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	defer reqcache.Forget(ctx)
+
+	return f.UserSaver.SaveUser(ctx, email, passHash, firstName, lastName, middleName)
+}
+
+func (f forgetfulUserSaver) SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error {
+	defer reqcache.Forget(ctx)
+
+	return f.UserSaver.SetUserTokenTTL(ctx, userID, ttl)
+}
+
+func (f forgetfulUserSaver) AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error {
+	defer reqcache.Forget(ctx)
+
+	return f.UserSaver.AcceptTerms(ctx, userID, version, acceptedAt)
+}
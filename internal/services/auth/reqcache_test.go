@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/reqcache"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// countingStorage counts the lookups of single users, roles and apps.
+type countingStorage struct {
+	*memory.Storage
+	calls map[string]int
+}
+
+func (s *countingStorage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	s.calls["UserByID"]++
+	return s.Storage.UserByID(ctx, userID)
+}
+
+func (s *countingStorage) UserRole(ctx context.Context, userID int64) (string, error) {
+	s.calls["UserRole"]++
+	return s.Storage.UserRole(ctx, userID)
+}
+
+func (s *countingStorage) App(ctx context.Context, appID int32) (models.App, error) {
+	s.calls["App"]++
+	return s.Storage.App(ctx, appID)
+}
+
+func TestRequestCache(t *testing.T) {
+	storage := &countingStorage{Storage: memory.New(), calls: make(map[string]int)}
+	storage.SaveApp(models.App{ID: 1, Name: "journal", Secret: "journal-secret"})
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+		WithTerms("v1", false),
+		WithRequestCache(),
+	)
+	require.NoError(t, err)
+	user, err := a.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "John", "Doe", "", "v1", "", 0)
+	require.NoError(t, err)
+	id := user.ID
+	storage.SetUserRole(id, AdminRole)
+	claims := jwt.Claims{UserID: id, AppID: 1}
+	// RegisterNewUser reads the user back.
+	clear(storage.calls)
+
+	// The role check of the interceptor, then the handler.
+	call := func(ctx context.Context) {
+		_, err := a.UserRole(ctx, id)
+		require.NoError(t, err)
+		identity, err := a.WhoAmI(ctx, claims)
+		require.NoError(t, err)
+		assert.Equal(t, []string{AdminRole}, identity.Roles)
+		_, err = a.WhoAmI(ctx, claims)
+		require.NoError(t, err)
+	}
+
+	call(context.Background())
+	assert.Equal(t, map[string]int{"UserRole": 3, "UserByID": 2, "App": 2}, storage.calls)
+
+	clear(storage.calls)
+	call(reqcache.NewContext(context.Background()))
+	assert.Equal(t, map[string]int{"UserRole": 1, "UserByID": 1, "App": 1}, storage.calls)
+
+	// Every request has a cache of its own, and changes drop it.
+	clear(storage.calls)
+	ctx := reqcache.NewContext(context.Background())
+	_, err = a.WhoAmI(ctx, claims)
+	require.NoError(t, err)
+	require.NoError(t, a.AcceptTerms(ctx, id, "v1"))
+	_, err = a.WhoAmI(ctx, claims)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"UserRole": 2, "UserByID": 2, "App": 2}, storage.calls)
+}
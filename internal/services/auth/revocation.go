@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/jwt"
+)
+
+// DefaultRevocationCacheTTL is how long the denylist answers are cached
+// when WithRevocations is given zero.
+const DefaultRevocationCacheTTL = 5 * time.Second
+
+// revocationCacheSize bounds the jti cached by the denylist cache.
+const revocationCacheSize = 10000
+
+// errNoRevocations is returned by Logout when the service was built
+// without WithRevocations.
+var errNoRevocations = errors.New("token revocation storage is not configured")
+
+// RevocationStorage is the denylist of revoked tokens, by jti, shared by
+// every instance over the storage.
+type RevocationStorage interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	TokenRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// RevocationBus tells the other instances sharing a storage about
+// revocations as they happen, so they need not wait for their caches to
+// expire. It is best effort: an instance that misses a message still
+// learns of the revocation once its cache expires.
+type RevocationBus interface {
+	// Publish announces the revocation of jti.
+	Publish(ctx context.Context, jti string) error
+	// Subscribe makes fn be called with the jti of every revocation
+	// published, by any instance, until the bus is closed.
+	Subscribe(fn func(jti string))
+}
+
+// Logout revokes the token of claims, already checked by ValidateToken,
+// on every instance: this one at once, others within the cache TTL given
+// to WithRevocations, or as soon as the RevocationBus tells them.
+//
+// Tokens issued without a jti cannot be revoked one by one: that returns
+// errs.InvalidArgument.
+func (a *Auth) Logout(ctx context.Context, claims jwt.Claims) error {
+	const op = "services.auth.Logout"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UserID),
+		slog.String("jti", claims.ID),
+	))
+
+	if a.revocations == nil {
+		return errs.Wrap(op, errNoRevocations)
+	}
+	if claims.ID == "" {
+		return errs.Wrap(op, errs.New(errs.InvalidArgument, "token has no jti to revoke"))
+	}
+
+	// Tokens are accepted for the leeway past their expiry.
+	if err := a.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Add(a.leeway)); err != nil {
+		log.Error("failed to revoke token", slog.Any("error", err))
+
+		return errs.Wrap(op, err)
+	}
+	a.revoked.revoke(claims.ID, a.clock.Now())
+	a.tokenCache.clear()
+
+	if a.revocationBus != nil {
+		if err := a.revocationBus.Publish(ctx, claims.ID); err != nil {
+			log.Warn("failed to announce token revocation", slog.Any("error", err))
+		}
+	}
+	audit.Log(ctx, log, slog.LevelInfo, "token revoked", "token_revoked")
+
+	return nil
+}
+
+// checkDenylisted returns errs.ErrInvalidToken if the jti of claims was
+// revoked, asking the storage only when the cache has no fresh answer.
+// Tokens without a jti are never denylisted.
+func (a *Auth) checkDenylisted(ctx context.Context, claims jwt.Claims) error {
+	if a.revocations == nil || claims.ID == "" {
+		return nil
+	}
+
+	now := a.clock.Now()
+	revoked, ok := a.revoked.get(claims.ID, now)
+	if !ok {
+		// Read before the storage, so that an invalidation landing
+		// during the read is not hidden by a stale answer.
+		gen := a.revoked.generation()
+
+		var err error
+		revoked, err = a.revocations.TokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return err
+		}
+		a.revoked.put(claims.ID, revoked, gen, now)
+	}
+	if revoked {
+		return errs.ErrInvalidToken
+	}
+
+	return nil
+}
+
+// invalidateRevocation drops the cached answer for jti, revoked by
+// another instance, so the next validation asks the storage.
+func (a *Auth) invalidateRevocation(jti string) {
+	a.revoked.invalidate(jti)
+	a.tokenCache.clear()
+}
+
+// revocationCache keeps the denylist answers by jti for ttl. Every
+// invalidation bumps its generation, so answers read before it are not
+// stored after it.
+type revocationCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	gen   uint64
+	items map[string]cachedRevocation
+}
+
+type cachedRevocation struct {
+	revoked bool
+	expires time.Time
+}
+
+func newRevocationCache(ttl time.Duration) *revocationCache {
+	return &revocationCache{ttl: ttl, items: make(map[string]cachedRevocation)}
+}
+
+func (c *revocationCache) get(jti string, now time.Time) (revoked, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[jti]
+	if !ok || !now.Before(item.expires) {
+		return false, false
+	}
+
+	return item.revoked, true
+}
+
+func (c *revocationCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+func (c *revocationCache) put(jti string, revoked bool, gen uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	c.store(jti, revoked, now)
+}
+
+// revoke records a revocation made by this instance, whatever the
+// generation.
+func (c *revocationCache) revoke(jti string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.store(jti, true, now)
+}
+
+func (c *revocationCache) invalidate(jti string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	delete(c.items, jti)
+}
+
+func (c *revocationCache) store(jti string, revoked bool, now time.Time) {
+	if len(c.items) >= revocationCacheSize {
+		clear(c.items)
+	}
+	c.items[jti] = cachedRevocation{revoked: revoked, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// localBus delivers every revocation to every subscriber, synchronously.
+type localBus struct {
+	mu   sync.Mutex
+	subs []func(jti string)
+}
+
+func (b *localBus) Publish(_ context.Context, jti string) error {
+	b.mu.Lock()
+	subs := b.subs
+	b.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(jti)
+	}
+
+	return nil
+}
+
+func (b *localBus) Subscribe(fn func(jti string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, fn)
+}
+
+const testRevocationTTL = 5 * time.Second
+
+// newTestReplicas returns two instances sharing storage, and a token of a
+// user issued by the first.
+func newTestReplicas(t *testing.T, opts ...Option) (first, second *Auth, token string, clk *clock.Fake) {
+	t.Helper()
+
+	storage := memory.New()
+	storage.SaveApp(testApp)
+	clk = clock.NewFake(time.Now().Truncate(time.Second))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	newReplica := func() *Auth {
+		a, err := NewService(log, storage, storage, storage, append([]Option{
+			WithRevocations(storage, testRevocationTTL),
+			WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+			WithClock(clk),
+		}, opts...)...)
+		require.NoError(t, err)
+
+		return a
+	}
+	first, second = newReplica(), newReplica()
+
+	ctx := context.Background()
+	_, err := first.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	token, err = first.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	return first, second, token, clk
+}
+
+func TestLogout_AcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	first, second, token, clk := newTestReplicas(t)
+
+	// The second instance caches that the token is not revoked.
+	_, err := second.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	claims, err := first.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	require.NoError(t, first.Logout(ctx, claims))
+
+	_, err = first.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken, "revoked at once where logged out")
+
+	// Within the TTL the second instance has yet to learn.
+	clk.Advance(testRevocationTTL - time.Second)
+	_, err = second.ValidateToken(ctx, token)
+	assert.NoError(t, err)
+
+	clk.Advance(time.Second)
+	_, err = second.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken, "revoked everywhere once the TTL is over")
+
+	// Logging out twice is no error.
+	assert.NoError(t, first.Logout(ctx, claims))
+}
+
+func TestLogout_Bus(t *testing.T) {
+	ctx := context.Background()
+	first, second, token, _ := newTestReplicas(t, WithRevocationBus(&localBus{}), WithTokenCache(time.Minute, 10))
+
+	_, err := second.IntrospectToken(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, 1, second.CachedTokens())
+
+	claims, err := first.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	require.NoError(t, first.Logout(ctx, claims))
+
+	// Told by the bus, the second instance drops its cached answers.
+	_, err = second.ValidateToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+	_, err = second.IntrospectToken(ctx, token)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+}
+
+func TestLogout_Rejects(t *testing.T) {
+	ctx := context.Background()
+	first, _, token, _ := newTestReplicas(t)
+
+	claims, err := first.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	claims.ID = ""
+	err = first.Logout(ctx, claims)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	a, _, _ := newTestAuth(t)
+	claims.ID = "jti"
+	assert.ErrorIs(t, a.Logout(ctx, claims), errNoRevocations)
+
+	_, err = NewService(a.log, memory.New(), memory.New(), memory.New(), WithRevocationBus(&localBus{}))
+	assert.ErrorContains(t, err, "revocation bus needs revocation storage")
+}
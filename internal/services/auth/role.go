@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+)
+
+const (
+	// MaxAssignRoleBatch bounds the users of one AssignRoleBulk. Larger
+	// lists are split by the caller.
+	MaxAssignRoleBatch = 1000
+	// assignRoleChunk is the users assigned in one transaction, which
+	// holds up the other writes while it runs.
+	assignRoleChunk = 100
+)
+
+// errNoRoles is returned by AssignRoleBulk when the service was built
+// without WithRoles.
+var errNoRoles = errors.New("role storage is not configured")
+
+type RoleStorage interface {
+	AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error)
+}
+
+// AssignRoleBulk makes role the role of every given user, in place of the
+// one they had, and returns what it did to each, once per user, in the
+// order given. The users are assigned in chunks of a transaction each: a
+// chunk that fails leaves its users as they were, with models.RoleError,
+// and the others go on. Assigning a role a user has is a no-op, so the
+// same call can be repeated until no user is left with an error.
+//
+// The role must already be given to some user, as for AttachPermission;
+// if not, returns errs.InvalidArgument and assigns nothing.
+func (a *Auth) AssignRoleBulk(ctx context.Context, userIDs []int64, role string) ([]models.RoleAssignment, error) {
+	const op = "services.auth.AssignRoleBulk"
+
+	if err := sanitize(input{"role", &role}); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.String("role", role),
+	))
+
+	if a.roles == nil {
+		return nil, errs.Wrap(op, errNoRoles)
+	}
+	userIDs, err := validateAssignRoleBulk(userIDs, role)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	res := make([]models.RoleAssignment, 0, len(userIDs))
+	counts := make(map[models.RoleOutcome]int, 4)
+	for chunk := range slices.Chunk(userIDs, assignRoleChunk) {
+		outcomes, err := a.roles.AssignRoles(ctx, chunk, role)
+		if errors.Is(err, errs.ErrRoleNotFound) && len(res) == 0 {
+			log.Warn("role not found")
+
+			// Role lookups are not public, see grpcerr.
+			return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "unknown role"))
+		}
+		if err != nil {
+			log.Error("failed to assign role", slog.Int("users", len(chunk)), slog.Any("error", err))
+		}
+
+		for _, id := range chunk {
+			assignment := models.RoleAssignment{UserID: id, Outcome: outcomes[id]}
+			if err != nil {
+				assignment = models.RoleAssignment{UserID: id, Outcome: models.RoleError, Err: err}
+			}
+			res = append(res, assignment)
+			counts[assignment.Outcome]++
+
+			if assignment.Outcome == models.RoleUserNotFound || assignment.Outcome == models.RoleError {
+				attrs := []slog.Attr{slog.Int64("user_id", id), slog.String("outcome", string(assignment.Outcome))}
+				if assignment.Err != nil {
+					attrs = append(attrs, slog.Any("error", assignment.Err))
+				}
+				audit.Log(ctx, log, slog.LevelWarn, "role not assigned", "role_assign_failed", attrs...)
+			}
+		}
+	}
+	if counts[models.RoleAssigned] > 0 {
+		a.permissionCache.clear()
+		a.unitRoleCache.clear()
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "role assigned in bulk", "role_bulk_assigned",
+		slog.Int("users", len(res)),
+		slog.Int(string(models.RoleAssigned), counts[models.RoleAssigned]),
+		slog.Int(string(models.RoleAlreadyHad), counts[models.RoleAlreadyHad]),
+		slog.Int(string(models.RoleUserNotFound), counts[models.RoleUserNotFound]),
+		slog.Int(string(models.RoleError), counts[models.RoleError]),
+	)
+
+	return res, nil
+}
+
+// validateAssignRoleBulk returns userIDs without repeats, in the order
+// given.
+func validateAssignRoleBulk(userIDs []int64, role string) ([]int64, error) {
+	switch {
+	case role == "":
+		return nil, errs.New(errs.InvalidArgument, "role is required")
+	case len(userIDs) == 0:
+		return nil, errs.New(errs.InvalidArgument, "user_ids is required")
+	case len(userIDs) > MaxAssignRoleBatch:
+		return nil, errs.New(errs.InvalidArgument, fmt.Sprintf("at most %d user_ids are allowed", MaxAssignRoleBatch))
+	}
+
+	seen := make(map[int64]bool, len(userIDs))
+	unique := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id <= 0 {
+			return nil, errs.New(errs.InvalidArgument, "user_ids must be positive")
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique, nil
+}
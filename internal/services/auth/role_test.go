@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyRoles fails the chunks with the user poisoned, while it is set.
+type flakyRoles struct {
+	*memory.Storage
+	poisoned int64
+}
+
+func (f *flakyRoles) AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error) {
+	if slices.Contains(userIDs, f.poisoned) {
+		return nil, errors.New("database is locked")
+	}
+
+	return f.Storage.AssignRoles(ctx, userIDs, role)
+}
+
+func TestAssignRoleBulk(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	storage := memory.New()
+	roles := &flakyRoles{Storage: storage}
+	a, err := NewService(slog.New(slog.NewJSONHandler(&logs, nil)), storage, storage, storage,
+		WithRoles(roles),
+	)
+	require.NoError(t, err)
+
+	save := func(email string) int64 {
+		t.Helper()
+
+		id, err := storage.SaveUser(ctx, email, []byte("hash"), "John", "Doe", "")
+		require.NoError(t, err)
+
+		return id
+	}
+	teacher := save("teacher@example.com")
+	storage.SetUserRole(teacher, "teacher")
+	student := save("student@example.com")
+	storage.SetUserRole(student, "student")
+	late := save("late@example.com")
+
+	// The first chunk has every outcome but an error, the second the
+	// user whose chunk fails.
+	ids := []int64{teacher, student, teacher}
+	for id := int64(1000); len(ids) < assignRoleChunk+1; id++ {
+		ids = append(ids, id)
+	}
+	ids = append(ids, late)
+	roles.poisoned = late
+
+	res, err := a.AssignRoleBulk(ctx, ids, "teacher")
+	require.NoError(t, err)
+	require.Len(t, res, assignRoleChunk+1, "once per user")
+	assert.Equal(t, models.RoleAssignment{UserID: teacher, Outcome: models.RoleAlreadyHad}, res[0])
+	assert.Equal(t, models.RoleAssignment{UserID: student, Outcome: models.RoleAssigned}, res[1])
+	assert.Equal(t, models.RoleAssignment{UserID: 1000, Outcome: models.RoleUserNotFound}, res[2])
+	last := res[len(res)-1]
+	assert.Equal(t, late, last.UserID)
+	assert.Equal(t, models.RoleError, last.Outcome)
+	assert.ErrorContains(t, last.Err, "database is locked")
+
+	role, err := storage.UserRole(ctx, student)
+	require.NoError(t, err)
+	assert.Equal(t, "teacher", role)
+	_, err = storage.UserRole(ctx, late)
+	assert.ErrorIs(t, err, errs.ErrRoleNotFound, "the failed chunk is left as it was")
+
+	// One audit entry per failure, and one for the whole call.
+	var failures int
+	var summary map[string]any
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var entry map[string]any
+		require.NoError(t, dec.Decode(&entry))
+		switch entry["audit"] {
+		case "role_assign_failed":
+			failures++
+		case "role_bulk_assigned":
+			summary = entry
+		}
+	}
+	assert.Equal(t, assignRoleChunk-2+1, failures)
+	require.NotNil(t, summary)
+	assert.EqualValues(t, 1, summary["assigned"])
+	assert.EqualValues(t, 1, summary["already_had"])
+	assert.EqualValues(t, assignRoleChunk-2, summary["user_not_found"])
+	assert.EqualValues(t, 1, summary["error"])
+
+	// Repeating the call picks up where it failed.
+	roles.poisoned = 0
+	res, err = a.AssignRoleBulk(ctx, ids, "teacher")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAlreadyHad, res[1].Outcome)
+	assert.Equal(t, models.RoleAssigned, res[len(res)-1].Outcome)
+}
+
+func TestAssignRoleBulk_Invalid(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		WithRoles(storage),
+	)
+	require.NoError(t, err)
+
+	id, err := storage.SaveUser(ctx, "user@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	storage.SetUserRole(id, "student")
+
+	tests := []struct {
+		name    string
+		userIDs []int64
+		role    string
+		want    string
+	}{
+		{name: "no role", userIDs: []int64{id}, want: "role is required"},
+		{name: "no users", role: "student", want: "user_ids is required"},
+		{name: "too many", userIDs: make([]int64, MaxAssignRoleBatch+1), role: "student", want: "at most 1000 user_ids"},
+		{name: "zero id", userIDs: []int64{id, 0}, role: "student", want: "user_ids must be positive"},
+		{name: "unknown role", userIDs: []int64{id}, role: "rector", want: "unknown role"},
+		{name: "control characters", userIDs: []int64{id}, role: "stu\ndent", want: "role must not contain control characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.AssignRoleBulk(ctx, tt.userIDs, tt.role)
+			assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+
+	a, _, _ = newTestAuth(t)
+	_, err = a.AssignRoleBulk(ctx, []int64{id}, "student")
+	assert.ErrorIs(t, err, errNoRoles)
+}
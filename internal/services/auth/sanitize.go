@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"sso/internal/domain/errs"
+)
+
+// byteOrderMark is U+FEFF, which editors and some clients prepend to text.
+// It is invisible, so an email with it looks like one without.
+const byteOrderMark = "\uFEFF"
+
+// input is a string argument of a call, named as the field its errors
+// name.
+type input struct {
+	field string
+	value *string
+}
+
+// sanitize checks the string inputs of a call, and strips them of byte
+// order marks in place. Calls run it first, before they log anything, so
+// that what the checks reject never reaches the logs, the storage or the
+// C layer of SQLite.
+func sanitize(inputs ...input) error {
+	for _, in := range inputs {
+		clean, err := sanitizeString(in.field, *in.value)
+		if err != nil {
+			return err
+		}
+		*in.value = clean
+	}
+
+	return nil
+}
+
+// sanitizeString returns s without byte order marks, or errs.InvalidArgument
+// naming field if s is not valid UTF-8 or has a C0 control character, NUL,
+// tabs and line breaks included: none belongs in an email, a password or a
+// name.
+func sanitizeString(field, s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", errs.New(errs.InvalidArgument, field+" must be valid UTF-8")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 {
+			return "", errs.New(errs.InvalidArgument, fmt.Sprintf(
+				"%s must not contain control characters, got %U at byte %d", field, rune(s[i]), i,
+			))
+		}
+	}
+
+	return strings.ReplaceAll(s, byteOrderMark, ""), nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"sso/internal/domain/errs"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSanitizeString(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value string
+		want  string
+		err   string
+	}{
+		{name: "plain", value: "user@example.com", want: "user@example.com"},
+		{name: "non-ascii", value: "Иван 😀", want: "Иван 😀"},
+		{name: "leading bom", value: "\uFEFFuser@example.com", want: "user@example.com"},
+		{name: "bom inside", value: "us\uFEFFer", want: "user"},
+		{name: "nul", value: "pass\x00word", err: "password must not contain control characters, got U+0000 at byte 4"},
+		{name: "crlf", value: "John\r\nDoe", err: "got U+000D at byte 4"},
+		{name: "tab", value: "\tJohn", err: "got U+0009 at byte 0"},
+		{name: "invalid utf-8", value: "caf\xe9", err: "password must be valid UTF-8"},
+		{name: "del and c1 are not c0", value: "a\x7f\u0085", want: "a\x7f\u0085"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeString("password", tt.value)
+			if tt.err != "" {
+				assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+				assert.ErrorContains(t, err, tt.err)
+
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func FuzzSanitizeString(f *testing.F) {
+	for _, seed := range []string{"", "user@example.com", "pass\x00word", "\uFEFFuser@example.com", "John\r\nDoe", "\xff", "\uFEFF\uFEFF"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		clean, err := sanitizeString("email", s)
+		if err != nil {
+			require.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+			require.ErrorContains(t, err, "email")
+
+			return
+		}
+
+		require.True(t, utf8.ValidString(clean))
+		require.NotContains(t, clean, byteOrderMark)
+		for _, r := range clean {
+			require.GreaterOrEqual(t, r, rune(0x20), "%q", clean)
+		}
+		again, err := sanitizeString("email", clean)
+		require.NoError(t, err)
+		require.Equal(t, clean, again)
+	})
+}
+
+func TestSanitize_BeforeLogging(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	storage := memory.New()
+	a, err := NewService(slog.New(slog.NewTextHandler(&logs, nil)), storage, storage, storage,
+		WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+	)
+	require.NoError(t, err)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John\r\nlevel=ERROR msg=forged", "Doe", "", "", "", 0)
+	assert.ErrorContains(t, err, "first_name must not contain control characters")
+	_, err = a.Login(ctx, "user@example.com", "pass\x00word", 1)
+	assert.ErrorContains(t, err, "password must not contain control characters")
+	assert.Empty(t, logs.String(), "rejected before anything is logged")
+
+	// The byte order mark is not part of the email.
+	user, err := a.RegisterNewUser(ctx, "\uFEFFuser@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", user.Email)
+	_, err = a.ElevatePrivileges(ctx, "token", "correct\x00")
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	assert.NotContains(t, logs.String(), "\uFEFF")
+}
+
+func FuzzValidateName(f *testing.F) {
+	for _, seed := range []string{"", "John", strings.Repeat("ж", MaxNameRunes), strings.Repeat("😀", MaxNameRunes), "\xff", "é"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		err := validateName("first_name", name)
+		fits := utf8.ValidString(name) && utf8.RuneCountInString(name) <= MaxNameRunes && len(name) <= MaxNameBytes
+		if fits {
+			require.NoError(t, err)
+		} else {
+			require.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// MaxStatsRange is the longest range accepted by Stats.
+const MaxStatsRange = 366 * 24 * time.Hour
+
+// LoginHistory stores login attempts and aggregates usage numbers.
+type LoginHistory interface {
+	RecordLogin(ctx context.Context, attempt models.LoginAttempt) error
+	UsageStats(ctx context.Context, from, to time.Time) (models.Stats, error)
+}
+
+// Stats returns usage numbers over [from, to) for the admins: total and
+// new users, successful and failed logins, and daily and weekly active
+// users as of to.
+//
+// If the range is longer than MaxStatsRange, returns errs.ErrRangeTooLarge.
+func (a *Auth) Stats(ctx context.Context, from, to time.Time) (models.Stats, error) {
+	const op = "services.auth.Stats"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Time("from", from),
+		slog.Time("to", to),
+	))
+
+	if a.loginHistory == nil {
+		return models.Stats{}, errs.Wrap(op, errNoLoginHistory)
+	}
+	if !from.Before(to) {
+		return models.Stats{}, errs.Wrap(op, errs.New(errs.InvalidArgument, "from must be before to"))
+	}
+	if to.Sub(from) > MaxStatsRange {
+		return models.Stats{}, errs.Wrap(op, errs.ErrRangeTooLarge)
+	}
+
+	stats, err := a.loginHistory.UsageStats(ctx, from, to)
+	if err != nil {
+		log.Error("failed to compute stats", slog.Any("error", err))
+
+		return models.Stats{}, errs.Wrap(op, err)
+	}
+
+	return stats, nil
+}
+
+// recordLogin adds an attempt to the login history, if any. A failure is
+// logged and otherwise ignored: it must not decide the outcome of the
+// login.
+func (a *Auth) recordLogin(ctx context.Context, log *slog.Logger, userID int64, appID int32, success bool) {
+	if a.loginHistory == nil {
+		return
+	}
+
+	err := a.loginHistory.RecordLogin(ctx, models.LoginAttempt{
+		UserID:  userID,
+		AppID:   appID,
+		Success: success,
+		At:      a.clock.Now(),
+	})
+	if err != nil {
+		log.Warn("failed to record login attempt", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuthWithApp(t)
+	a.loginHistory = storage
+
+	_, err := a.RegisterNewUser(ctx, "john@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "jane@example.com", "correct-password", "Jane", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "jane@example.com", "wrong-password", 1)
+	require.Error(t, err)
+	_, err = a.Login(ctx, "nobody@example.com", "correct-password", 1)
+	require.Error(t, err)
+	// Attempts for unknown apps are not recorded.
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 2)
+	require.Error(t, err)
+
+	now := time.Now()
+	stats, err := a.Stats(ctx, now.Add(-time.Hour), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalUsers)
+	assert.Equal(t, int64(2), stats.Registrations)
+	assert.Equal(t, int64(2), stats.SuccessfulLogins)
+	assert.Equal(t, int64(2), stats.FailedLogins)
+	assert.Equal(t, int64(1), stats.DailyActive)
+	assert.Equal(t, int64(1), stats.WeeklyActive)
+}
+
+func TestStats_Rejects(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	a, storage, _ := newTestAuth(t)
+	_, err := a.Stats(ctx, now.Add(-time.Hour), now)
+	assert.ErrorIs(t, err, errNoLoginHistory)
+
+	a.loginHistory = storage
+
+	_, err = a.Stats(ctx, now, now)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	_, err = a.Stats(ctx, now, now.Add(-time.Hour))
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	_, err = a.Stats(ctx, now.Add(-MaxStatsRange-time.Second), now)
+	assert.ErrorIs(t, err, errs.ErrRangeTooLarge)
+
+	_, err = a.Stats(ctx, now.Add(-MaxStatsRange), now)
+	assert.NoError(t, err)
+}
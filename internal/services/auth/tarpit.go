@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"context"
+	"hash/maphash"
+	"log/slog"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/emailaddr"
+	"sso/internal/lib/metrics"
+)
+
+// TarpitConfig delays the logins refused for their credentials by how
+// many failed within Window for the same email or client IP, whichever
+// failed more: the first Free are not delayed, the next one by Base, and
+// every one after it twice as long as the last, up to Max.
+type TarpitConfig struct {
+	Window time.Duration
+	Free   int
+	Base   time.Duration
+	Max    time.Duration
+	// MaxTracked bounds the emails and the IPs tracked at once, each;
+	// past it the ones tracked for longest are forgotten.
+	MaxTracked int
+}
+
+func (c TarpitConfig) valid() bool {
+	return c.Window > 0 && c.Free >= 0 && c.Base > 0 && c.Max >= c.Base && c.MaxTracked > 0
+}
+
+// Delay returns the delay of a login that is the failures-th to fail.
+func (c TarpitConfig) Delay(failures int) time.Duration {
+	if failures <= c.Free {
+		return 0
+	}
+
+	d := c.Base
+	for i := c.Free + 1; i < failures && d < c.Max; i++ {
+		d *= 2
+	}
+
+	return min(d, c.Max)
+}
+
+// history is the number of failures past which the delay stops growing.
+func (c TarpitConfig) history() int {
+	n := c.Free + 1
+	for d := c.Base; d < c.Max; d *= 2 {
+		n++
+	}
+
+	return n
+}
+
+// tarpitBuckets are the upper bounds, in seconds, of the delay histogram.
+var tarpitBuckets = []float64{0.5, 1, 2, 4, 8, 16, 32}
+
+// tarpit counts the failed logins by email and by client IP in the rings
+// the loginTracker keeps its attempts in, bounded the same way. Emails
+// and IPs are kept only as hashes.
+type tarpit struct {
+	cfg  TarpitConfig
+	seed maphash.Seed
+
+	mu      sync.Mutex
+	byEmail *rings
+	byIP    *rings
+
+	delays  *metrics.Histogram
+	skipped *metrics.Counter
+}
+
+func newTarpit(cfg TarpitConfig) *tarpit {
+	if !cfg.valid() {
+		// Refused by NewService.
+		return &tarpit{cfg: cfg}
+	}
+
+	return &tarpit{
+		cfg:     cfg,
+		seed:    maphash.MakeSeed(),
+		byEmail: newRings(cfg.MaxTracked, cfg.history()),
+		byIP:    newRings(cfg.MaxTracked, cfg.history()),
+		delays:  metrics.NewHistogram(tarpitBuckets),
+		skipped: metrics.NewCounter("auth_tarpit_skipped_total"),
+	}
+}
+
+// fail records a failed login on email from ip and returns its delay.
+func (t *tarpit) fail(ip, email string, at time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	since := at.Add(-t.cfg.Window)
+
+	r := t.byEmail.get(maphash.String(t.seed, email))
+	r.add(1, at)
+	failures := r.count(since)
+	if ip != "" {
+		r := t.byIP.get(maphash.String(t.seed, ip))
+		r.add(1, at)
+		failures = max(failures, r.count(since))
+	}
+
+	return t.cfg.Delay(failures)
+}
+
+// clear forgets the failures of email, once its owner got in.
+func (t *tarpit) clear(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if r, ok := t.byEmail.byKey[maphash.String(t.seed, email)]; ok {
+		clear(r.at)
+	}
+}
+
+// tarpitFailure delays the return of err, a login refused for the
+// credentials of email, see WithTarpit. When the deadline of ctx comes
+// before the delay would be over, err is returned at once with a
+// retry_after of the delay instead.
+func (a *Auth) tarpitFailure(ctx context.Context, log *slog.Logger, email string, err error) error {
+	if a.tarpit == nil {
+		return err
+	}
+
+	delay := a.tarpit.fail(clientIP(ctx), emailaddr.Normalize(email), a.clock.Now())
+	if delay == 0 {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		log.Info("login failure not delayed, too little time left", slog.Duration("delay", delay))
+		a.tarpit.skipped.Inc()
+
+		return &errs.Error{
+			Code:     errs.CodeOf(err),
+			Err:      err,
+			Metadata: map[string]string{"retry_after": delay.String()},
+		}
+	}
+
+	a.tarpit.delays.Observe(delay.Seconds())
+	_ = clock.Sleep(ctx, a.clock, delay)
+
+	return err
+}
+
+// TarpitDelays returns the distribution of the delays applied to failed
+// logins, in seconds, and the number of delays skipped for the deadline.
+func (a *Auth) TarpitDelays() (metrics.HistogramSnapshot, int64) {
+	if a.tarpit == nil {
+		return metrics.HistogramSnapshot{}, 0
+	}
+
+	return a.tarpit.delays.Snapshot(), a.tarpit.skipped.Value()
+}
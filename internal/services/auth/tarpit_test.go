@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTarpit = TarpitConfig{
+	Window:     time.Minute,
+	Free:       2,
+	Base:       time.Second,
+	Max:        10 * time.Second,
+	MaxTracked: 100,
+}
+
+func TestTarpitConfig_Delay(t *testing.T) {
+	var delays []time.Duration
+	for failures := 1; failures <= 8; failures++ {
+		delays = append(delays, testTarpit.Delay(failures))
+	}
+	assert.Equal(t, []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second}, delays)
+	assert.Equal(t, 7, testTarpit.history())
+}
+
+func newTarpitAuth(t *testing.T) (*Auth, *clock.Fake) {
+	t.Helper()
+
+	a, _, clk := newTestAuthWithApp(t, WithTarpit(testTarpit))
+
+	_, err := a.RegisterNewUser(context.Background(), "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+
+	return a, clk
+}
+
+func TestLogin_Tarpit(t *testing.T) {
+	a, clk := newTarpitAuth(t)
+	ctx := fromIP("203.0.113.7")
+
+	for range testTarpit.Free {
+		_, err := a.Login(ctx, "user@example.com", "wrong-password", 1)
+		require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	}
+	assert.Zero(t, clk.Tickers())
+
+	// The next failure, from another IP still, waits for the base delay.
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Login(fromIP("198.51.100.1"), "User@Example.com", "wrong-password", 1)
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return clk.Tickers() == 1 }, time.Second, time.Millisecond)
+	clk.Advance(testTarpit.Base - time.Nanosecond)
+	select {
+	case <-done:
+		require.Fail(t, "failure returned before its delay")
+	default:
+	}
+	clk.Advance(time.Nanosecond)
+	require.ErrorIs(t, <-done, errs.ErrInvalidCredentials)
+
+	delays, skipped := a.TarpitDelays()
+	assert.Equal(t, uint64(1), delays.Count)
+	assert.Equal(t, 1.0, delays.Sum)
+	assert.Zero(t, skipped)
+
+	// Logging in forgets the failures of the account, though not those
+	// of the IP.
+	_, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(fromIP("198.51.100.2"), "user@example.com", "wrong-password", 1)
+	require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	assert.Zero(t, clk.Tickers())
+}
+
+func TestLogin_TarpitDeadline(t *testing.T) {
+	a, clk := newTarpitAuth(t)
+
+	for range testTarpit.Free {
+		_, _ = a.Login(context.Background(), "user@example.com", "wrong-password", 1)
+	}
+
+	// Too little time left to wait: the client is told when to retry.
+	ctx, cancel := context.WithTimeout(context.Background(), testTarpit.Base/2)
+	defer cancel()
+	_, err := a.Login(ctx, "user@example.com", "wrong-password", 1)
+	require.ErrorIs(t, err, errs.ErrInvalidCredentials)
+	assert.Equal(t, map[string]string{"retry_after": "1s"}, errs.MetadataOf(err))
+	assert.Zero(t, clk.Tickers())
+
+	delays, skipped := a.TarpitDelays()
+	assert.Zero(t, delays.Count)
+	assert.Equal(t, int64(1), skipped)
+}
+
+func TestNewService_InvalidTarpit(t *testing.T) {
+	storage := memory.New()
+	cfg := testTarpit
+	cfg.Max = cfg.Base / 2
+
+	_, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage, WithTarpit(cfg))
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+)
+
+// AcceptTerms records that the user accepted version of the terms of
+// service, which must be the required one.
+//
+// If version is not the required one, returns errs.ErrTermsVersionMismatch.
+// If user does not exist, returns errs.ErrUserNotFound.
+func (a *Auth) AcceptTerms(ctx context.Context, userID int64, version string) error {
+	const op = "services.auth.AcceptTerms"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", userID),
+	))
+
+	if version == "" || version != a.termsVersion {
+		log.Warn("terms of service version mismatch", slog.String("tos_version", version))
+
+		return errs.Wrap(op, errs.ErrTermsVersionMismatch)
+	}
+
+	if err := a.recordTerms(ctx, log, userID, version); err != nil {
+		if errors.Is(err, errs.ErrUserNotFound) {
+			return errs.Wrap(op, errs.ErrUserNotFound)
+		}
+
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// recordTerms stores the acceptance and logs the audit event legal asks
+// for.
+func (a *Auth) recordTerms(ctx context.Context, log *slog.Logger, userID int64, version string) error {
+	acceptedAt := a.clock.Now()
+
+	if err := a.userSaver.AcceptTerms(ctx, userID, version, acceptedAt); err != nil {
+		log.Error("failed to record terms acceptance", slog.Any("error", err))
+
+		return err
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "terms of service accepted", "tos_accepted",
+		slog.String("tos_version", version),
+		slog.Time("accepted_at", acceptedAt),
+	)
+
+	return nil
+}
+
+// termsOutdated reports whether the user has yet to accept the required
+// terms of service.
+func (a *Auth) termsOutdated(user models.User) bool {
+	return a.termsVersion != "" && user.TermsVersion != a.termsVersion
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterNewUser_Terms(t *testing.T) {
+	ctx := context.Background()
+	a, storage, fake := newTestAuth(t)
+	a.termsVersion = "2024-01"
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	assert.ErrorIs(t, err, errs.ErrTermsVersionMismatch)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "2023-06", "", 0)
+	assert.ErrorIs(t, err, errs.ErrTermsVersionMismatch)
+
+	registered, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "2024-01", "", 0)
+	require.NoError(t, err)
+	id := registered.ID
+
+	user, err := storage.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01", user.TermsVersion)
+	assert.True(t, fake.Now().Equal(user.TermsAcceptedAt))
+}
+
+func TestLogin_TermsOutdated(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuthWithApp(t)
+
+	a.termsVersion = "2024-01"
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "2024-01", "", 0)
+	require.NoError(t, err)
+
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.NotContains(t, claims.Raw, "tos_reaccept_required")
+
+	// A new version is published: the old acceptance no longer counts.
+	a.termsVersion = "2024-06"
+
+	token, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	claims, err = a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, true, claims.Raw["tos_reaccept_required"])
+
+	a.enforceTerms = true
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	assert.ErrorIs(t, err, errs.ErrTermsReacceptRequired)
+
+	// Wrong credentials are still reported as such.
+	_, err = a.Login(ctx, "user@example.com", "wrong-password", 1)
+	assert.ErrorIs(t, err, errs.ErrInvalidCredentials)
+}
+
+func TestAcceptTerms(t *testing.T) {
+	ctx := context.Background()
+	a, storage, fake := newTestAuthWithApp(t)
+
+	a.enforceTerms = true
+
+	registered, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	id := registered.ID
+
+	a.termsVersion = "2024-06"
+	fake.Advance(time.Hour)
+
+	err = a.AcceptTerms(ctx, id, "2024-01")
+	assert.ErrorIs(t, err, errs.ErrTermsVersionMismatch)
+
+	err = a.AcceptTerms(ctx, id+1000, "2024-06")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	require.NoError(t, a.AcceptTerms(ctx, id, "2024-06"))
+
+	user, err := storage.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-06", user.TermsVersion)
+	assert.True(t, fake.Now().Equal(user.TermsAcceptedAt))
+
+	_, err = a.Login(ctx, "user@example.com", "correct-password", 1)
+	assert.NoError(t, err)
+}
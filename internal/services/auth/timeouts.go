@@ -0,0 +1,812 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/retrybudget"
+	"sso/internal/storage"
+)
+
+// Stages are the dependencies of the service bounded by
+// WithStageTimeouts. They name a timeout in errors and metrics.
+const (
+	StageStorage  = "storage"
+	StageHashing  = "hashing"
+	StageNotifier = "notifier"
+)
+
+// StageTimeouts bound every call of the service to a dependency, so that
+// one slow dependency fails the request with an error naming it. Zero
+// leaves the stage unbounded, bar the deadline of the request.
+type StageTimeouts struct {
+	Storage  time.Duration
+	Hashing  time.Duration
+	Notifier time.Duration
+}
+
+// StageTimeoutError reports that a dependency did not answer within its
+// timeout. It reaches callers wrapped in an errs.DependencyTimeout error.
+type StageTimeoutError struct {
+	Stage   string
+	Timeout time.Duration
+}
+
+func (e *StageTimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Stage, e.Timeout)
+}
+
+// StageOf returns the stage that timed out, if err is a stage timeout.
+func StageOf(err error) (string, bool) {
+	var e *StageTimeoutError
+	if errors.As(err, &e) {
+		return e.Stage, true
+	}
+
+	return "", false
+}
+
+// stages runs calls to dependencies with their timeout and counts the
+// timeouts of every stage.
+type stages struct {
+	timeouts map[string]time.Duration
+	timedOut map[string]*metrics.Counter
+	// retry retries the idempotent storage reads, nil if they are not,
+	// see WithReadRetry.
+	retry *readRetry
+}
+
+func newStages(t StageTimeouts) *stages {
+	s := &stages{
+		timeouts: map[string]time.Duration{
+			StageStorage:  t.Storage,
+			StageHashing:  t.Hashing,
+			StageNotifier: t.Notifier,
+		},
+		timedOut: make(map[string]*metrics.Counter),
+	}
+	for stage := range s.timeouts {
+		s.timedOut[stage] = metrics.NewCounter("auth_stage_timeouts_total")
+	}
+
+	return s
+}
+
+// enabled reports whether any stage is bounded.
+func (s *stages) enabled() bool {
+	for _, timeout := range s.timeouts {
+		if timeout > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// counts returns the number of timeouts of every stage since the start.
+func (s *stages) counts() map[string]int64 {
+	res := make(map[string]int64, len(s.timedOut))
+	for stage, c := range s.timedOut {
+		res[stage] = c.Value()
+	}
+
+	return res
+}
+
+// runStage calls fn with the timeout of stage. fn runs on its own
+// goroutine, so a dependency that ignores its context, such as bcrypt,
+// cannot hold up the request; its result is dropped once the timeout
+// fires. An expired or canceled request keeps its own context error.
+// Storage failures are classified, see stageErr.
+func runStage[T any](ctx context.Context, s *stages, stage string, fn func(ctx context.Context) (T, error)) (T, error) {
+	timeout := s.timeouts[stage]
+	if timeout <= 0 {
+		v, err := fn(ctx)
+
+		return v, stageErr(ctx, stage, err)
+	}
+
+	timeoutErr := &StageTimeoutError{Stage: stage, Timeout: timeout}
+	stageCtx, cancel := context.WithTimeoutCause(ctx, timeout, timeoutErr)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := fn(stageCtx)
+		done <- result{v: v, err: err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-stageCtx.Done():
+		res.err = stageCtx.Err()
+	}
+	if res.err != nil && ctx.Err() == nil && context.Cause(stageCtx) == timeoutErr {
+		s.timedOut[stage].Inc()
+		var zero T
+
+		return zero, &errs.Error{Code: errs.DependencyTimeout, Err: timeoutErr}
+	}
+
+	return res.v, stageErr(ctx, stage, res.err)
+}
+
+// stageErr marks the storage failures worth retrying, see
+// storage.Classify, as errs.StorageUnavailable. Failures with a code of
+// their own, such as errs.ErrUserNotFound, and those of an expired or
+// canceled request are left as they are.
+func stageErr(ctx context.Context, stage string, err error) error {
+	if err == nil || stage != StageStorage || ctx.Err() != nil || errs.CodeOf(err) != errs.Internal {
+		return err
+	}
+	if storage.Classify(err) != storage.Transient {
+		return err
+	}
+
+	return &errs.Error{Code: errs.StorageUnavailable, Err: err}
+}
+
+// readRetry retries a storage read once if the first attempt failed with
+// a failure worth retrying and the request has both time and retries
+// left, see retrybudget.
+type readRetry struct {
+	minBudget time.Duration
+	retried   *metrics.Counter
+	succeeded *metrics.Counter
+	skipped   *metrics.Counter
+}
+
+func newReadRetry(minBudget time.Duration) *readRetry {
+	return &readRetry{
+		minBudget: minBudget,
+		retried:   metrics.NewCounter("auth_storage_read_retries_total"),
+		succeeded: metrics.NewCounter("auth_storage_read_retries_succeeded_total"),
+		skipped:   metrics.NewCounter("auth_storage_read_retries_skipped_total"),
+	}
+}
+
+// allows reports whether the read that failed with err may be retried,
+// and spends a retry of the budget of ctx if so.
+func (r *readRetry) allows(ctx context.Context, err error) bool {
+	if r == nil || ctx.Err() != nil {
+		return false
+	}
+	if code := errs.CodeOf(err); code != errs.StorageUnavailable && code != errs.DependencyTimeout {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < r.minBudget || !retrybudget.Take(ctx) {
+		r.skipped.Inc()
+
+		return false
+	}
+	r.retried.Inc()
+
+	return true
+}
+
+// runRead is runStage for the storage reads that are safe to repeat, which
+// are retried once, see WithReadRetry. Writes must never go through it.
+func runRead[T any](ctx context.Context, s *stages, fn func(ctx context.Context) (T, error)) (T, error) {
+	v, err := runStage(ctx, s, StageStorage, fn)
+	if err == nil || !s.retry.allows(ctx, err) {
+		return v, err
+	}
+
+	v, err = runStage(ctx, s, StageStorage, fn)
+	if err == nil {
+		s.retry.succeeded.Inc()
+	}
+
+	return v, err
+}
+
+// runStageErr is runStage for calls without a result.
+func runStageErr(ctx context.Context, s *stages, stage string, fn func(ctx context.Context) error) error {
+	_, err := runStage(ctx, s, stage, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+
+	return err
+}
+
+// hashPassword hashes password within the hashing timeout.
+func (a *Auth) hashPassword(ctx context.Context, password string) ([]byte, error) {
+	defer a.observeHashing(ctx, "hash")()
+
+	return runStage(ctx, a.stages, StageHashing, func(context.Context) ([]byte, error) {
+		return a.hasher.Hash(password)
+	})
+}
+
+// comparePassword compares password with hash within the hashing timeout.
+func (a *Auth) comparePassword(ctx context.Context, hash []byte, password string) error {
+	defer a.observeHashing(ctx, "compare")()
+
+	return runStageErr(ctx, a.stages, StageHashing, func(context.Context) error {
+		return a.hasher.Compare(hash, password)
+	})
+}
+
+// observeHashing starts timing a hashing call of op, recorded by the
+// returned function with the request ID of ctx as exemplar. Calls cut by
+// the timeout are recorded at the time they were given up.
+func (a *Auth) observeHashing(ctx context.Context, op string) func() {
+	if a.hashDurations == nil {
+		return func() {}
+	}
+
+	start := a.clock.Now()
+
+	return func() {
+		requestID := audit.FromContext(ctx).RequestID
+		if requestID == audit.Unknown {
+			requestID = ""
+		}
+		a.hashDurations.With(op).ObserveExemplar(a.clock.Now().Sub(start).Seconds(), requestID)
+	}
+}
+
+// StageTimeouts returns how many calls of every stage timed out since the
+// start.
+func (a *Auth) StageTimeouts() map[string]int64 {
+	return a.stages.counts()
+}
+
+// ReadRetries returns how many storage reads were retried since the start,
+// how many of those then succeeded, and how many failed reads were not
+// retried for want of time or of retries left. Empty without
+// WithReadRetry.
+func (a *Auth) ReadRetries() map[string]int64 {
+	r := a.stages.retry
+	if r == nil {
+		return map[string]int64{}
+	}
+
+	return map[string]int64{
+		"retried":   r.retried.Value(),
+		"succeeded": r.succeeded.Value(),
+		"skipped":   r.skipped.Value(),
+	}
+}
+
+// withStageTimeouts wraps the storages and the notifier of a with their
+// timeouts and the classification of storage failures.
+func (a *Auth) withStageTimeouts() {
+	s := a.stages
+	a.userSaver = timedUserSaver{a.userSaver, s}
+	a.userProvider = timedUserProvider{a.userProvider, s}
+	a.appProvider = timedAppProvider{a.appProvider, s}
+	if a.authorizations != nil {
+		a.authorizations = timedAuthorizations{a.authorizations, s}
+	}
+	if a.loginHistory != nil {
+		a.loginHistory = timedLoginHistory{a.loginHistory, s}
+	}
+	if a.invites != nil {
+		a.invites = timedInvites{a.invites, s}
+	}
+	if a.events != nil {
+		a.events = timedEvents{a.events, s}
+	}
+	if a.identities != nil {
+		a.identities = timedIdentities{a.identities, s}
+	}
+	if a.deletions != nil {
+		a.deletions = timedDeletions{a.deletions, s}
+	}
+	if a.challenges != nil {
+		a.challenges = timedChallenges{a.challenges, s}
+	}
+	if a.permissions != nil {
+		a.permissions = timedPermissions{a.permissions, s}
+	}
+	if a.orgUnits != nil {
+		a.orgUnits = timedOrgUnits{a.orgUnits, s}
+	}
+	if a.roles != nil {
+		a.roles = timedRoles{a.roles, s}
+	}
+	if a.activations != nil {
+		a.activations = timedActivations{a.activations, s}
+	}
+	if a.appQuotas != nil {
+		a.appQuotas = timedAppQuotas{a.appQuotas, s}
+	}
+	if a.registrations != nil {
+		a.registrations = timedRegistrations{a.registrations, s}
+	}
+	if a.usage != nil {
+		a.usage = timedAppUsage{a.usage, s}
+	}
+	if a.issuances != nil {
+		a.issuances = timedIssuances{a.issuances, s}
+	}
+	if a.revocations != nil {
+		a.revocations = timedRevocations{a.revocations, s}
+	}
+}
+
+type timedUserSaver struct {
+	next UserSaver
+	s    *stages
+}
+
+func (t timedUserSaver) SaveUser(
+	ctx context.Context,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveUser(ctx, email, passHash, firstName, lastName, middleName)
+	})
+}
+
+func (t timedUserSaver) SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SetUserTokenTTL(ctx, userID, ttl)
+	})
+}
+
+func (t timedUserSaver) AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.AcceptTerms(ctx, userID, version, acceptedAt)
+	})
+}
+
+type timedUserProvider struct {
+	next UserProvider
+	s    *stages
+}
+
+func (t timedUserProvider) User(ctx context.Context, email string) (models.User, error) {
+	return runRead(ctx, t.s, func(ctx context.Context) (models.User, error) {
+		return t.next.User(ctx, email)
+	})
+}
+
+func (t timedUserProvider) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	return runRead(ctx, t.s, func(ctx context.Context) (models.User, error) {
+		return t.next.UserByID(ctx, userID)
+	})
+}
+
+func (t timedUserProvider) UserRole(ctx context.Context, userID int64) (string, error) {
+	return runRead(ctx, t.s, func(ctx context.Context) (string, error) {
+		return t.next.UserRole(ctx, userID)
+	})
+}
+
+func (t timedUserProvider) UserExists(ctx context.Context, userID int64) (bool, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (bool, error) {
+		return t.next.UserExists(ctx, userID)
+	})
+}
+
+func (t timedUserProvider) UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (map[int64]bool, error) {
+		return t.next.UsersExist(ctx, userIDs)
+	})
+}
+
+func (t timedUserProvider) UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (map[int64]string, error) {
+		return t.next.UserRoles(ctx, userIDs)
+	})
+}
+
+// Users is not bounded: an export streams for as long as the client reads.
+func (t timedUserProvider) Users(ctx context.Context, filter models.UserFilter) iter.Seq2[models.UserRecord, error] {
+	return t.next.Users(ctx, filter)
+}
+
+func (t timedUserProvider) LastUserID(ctx context.Context) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.LastUserID(ctx)
+	})
+}
+
+type timedAppProvider struct {
+	next AppProvider
+	s    *stages
+}
+
+func (t timedAppProvider) App(ctx context.Context, appID int32) (models.App, error) {
+	return runRead(ctx, t.s, func(ctx context.Context) (models.App, error) {
+		return t.next.App(ctx, appID)
+	})
+}
+
+func (t timedAppProvider) AppByName(ctx context.Context, name string) (models.App, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.App, error) {
+		return t.next.AppByName(ctx, name)
+	})
+}
+
+type timedAuthorizations struct {
+	next AuthorizationStorage
+	s    *stages
+}
+
+func (t timedAuthorizations) RedirectURIAllowed(ctx context.Context, appID int32, uri string) (bool, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (bool, error) {
+		return t.next.RedirectURIAllowed(ctx, appID, uri)
+	})
+}
+
+func (t timedAuthorizations) SaveAuthorization(ctx context.Context, authz models.Authorization) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SaveAuthorization(ctx, authz)
+	})
+}
+
+func (t timedAuthorizations) Authorization(ctx context.Context, id string) (models.Authorization, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.Authorization, error) {
+		return t.next.Authorization(ctx, id)
+	})
+}
+
+func (t timedAuthorizations) IssueAuthorizationCode(
+	ctx context.Context,
+	id string,
+	userID int64,
+	codeHash string,
+	expiresAt time.Time,
+) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.IssueAuthorizationCode(ctx, id, userID, codeHash, expiresAt)
+	})
+}
+
+func (t timedAuthorizations) ConsumeAuthorizationCode(
+	ctx context.Context,
+	codeHash string,
+	usedAt time.Time,
+) (models.Authorization, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.Authorization, error) {
+		return t.next.ConsumeAuthorizationCode(ctx, codeHash, usedAt)
+	})
+}
+
+type timedLoginHistory struct {
+	next LoginHistory
+	s    *stages
+}
+
+func (t timedLoginHistory) RecordLogin(ctx context.Context, attempt models.LoginAttempt) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.RecordLogin(ctx, attempt)
+	})
+}
+
+func (t timedLoginHistory) UsageStats(ctx context.Context, from, to time.Time) (models.Stats, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.Stats, error) {
+		return t.next.UsageStats(ctx, from, to)
+	})
+}
+
+type timedInvites struct {
+	next InviteStorage
+	s    *stages
+}
+
+func (t timedInvites) SaveInvite(ctx context.Context, invite models.Invite) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveInvite(ctx, invite)
+	})
+}
+
+func (t timedInvites) Invite(ctx context.Context, codeHash string) (models.Invite, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.Invite, error) {
+		return t.next.Invite(ctx, codeHash)
+	})
+}
+
+func (t timedInvites) SaveInvitedUser(
+	ctx context.Context,
+	inviteID int64,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+	usedAt time.Time,
+) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveInvitedUser(ctx, inviteID, appID, email, passHash, firstName, lastName, middleName, usedAt)
+	})
+}
+
+type timedEvents struct {
+	next EventPublisher
+	s    *stages
+}
+
+func (t timedEvents) SaveEvent(ctx context.Context, event models.Event) (int64, error) {
+	return runStage(ctx, t.s, StageNotifier, func(ctx context.Context) (int64, error) {
+		return t.next.SaveEvent(ctx, event)
+	})
+}
+
+type timedIdentities struct {
+	next IdentityStorage
+	s    *stages
+}
+
+func (t timedIdentities) LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.LinkIdentity(ctx, identity)
+	})
+}
+
+func (t timedIdentities) LinkedIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]models.LinkedIdentity, error) {
+		return t.next.LinkedIdentities(ctx, userID)
+	})
+}
+
+func (t timedIdentities) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.UnlinkIdentity(ctx, userID, provider)
+	})
+}
+
+type timedDeletions struct {
+	next DeletionStorage
+	s    *stages
+}
+
+func (t timedDeletions) ScheduleDeletion(
+	ctx context.Context,
+	userID int64,
+	deleteAfter time.Time,
+	tokenHash string,
+	revokedAt time.Time,
+) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.ScheduleDeletion(ctx, userID, deleteAfter, tokenHash, revokedAt)
+	})
+}
+
+func (t timedDeletions) CancelDeletion(ctx context.Context, userID int64, tokenHash string, now time.Time) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.CancelDeletion(ctx, userID, tokenHash, now)
+	})
+}
+
+func (t timedDeletions) AnonymizeDueUsers(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]int64, error) {
+		return t.next.AnonymizeDueUsers(ctx, now, limit)
+	})
+}
+
+type timedChallenges struct {
+	next ChallengeStorage
+	s    *stages
+}
+
+func (t timedChallenges) SaveChallenge(ctx context.Context, challenge models.Challenge) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SaveChallenge(ctx, challenge)
+	})
+}
+
+func (t timedChallenges) Challenge(ctx context.Context, tokenHash string) (models.Challenge, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.Challenge, error) {
+		return t.next.Challenge(ctx, tokenHash)
+	})
+}
+
+func (t timedChallenges) ConsumeChallenge(ctx context.Context, tokenHash string, usedAt time.Time) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.ConsumeChallenge(ctx, tokenHash, usedAt)
+	})
+}
+
+type timedPermissions struct {
+	next PermissionStorage
+	s    *stages
+}
+
+func (t timedPermissions) AttachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.AttachPermission(ctx, role, appID, permission)
+	})
+}
+
+func (t timedPermissions) DetachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.DetachPermission(ctx, role, appID, permission)
+	})
+}
+
+func (t timedPermissions) RolePermissions(ctx context.Context, role string, appID int32) ([]string, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]string, error) {
+		return t.next.RolePermissions(ctx, role, appID)
+	})
+}
+
+func (t timedPermissions) UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]string, error) {
+		return t.next.UserPermissions(ctx, userID, appID)
+	})
+}
+
+type timedOrgUnits struct {
+	next OrgUnitStorage
+	s    *stages
+}
+
+func (t timedOrgUnits) SaveOrgUnit(ctx context.Context, unit models.OrgUnit) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveOrgUnit(ctx, unit)
+	})
+}
+
+func (t timedOrgUnits) MoveOrgUnit(ctx context.Context, id, parentID int64) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.MoveOrgUnit(ctx, id, parentID)
+	})
+}
+
+func (t timedOrgUnits) OrgUnits(ctx context.Context) ([]models.OrgUnit, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]models.OrgUnit, error) {
+		return t.next.OrgUnits(ctx)
+	})
+}
+
+func (t timedOrgUnits) UnitRoles(ctx context.Context, userID, unitID int64) ([]string, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]string, error) {
+		return t.next.UnitRoles(ctx, userID, unitID)
+	})
+}
+
+type timedRoles struct {
+	next RoleStorage
+	s    *stages
+}
+
+func (t timedRoles) AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (map[int64]models.RoleOutcome, error) {
+		return t.next.AssignRoles(ctx, userIDs, role)
+	})
+}
+
+type timedActivations struct {
+	next ActivationStorage
+	s    *stages
+}
+
+func (t timedActivations) PreRegisterUser(
+	ctx context.Context,
+	email string,
+	firstName string,
+	lastName string,
+	middleName string,
+	tokenHash string,
+	expiresAt time.Time,
+) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.PreRegisterUser(ctx, email, firstName, lastName, middleName, tokenHash, expiresAt)
+	})
+}
+
+func (t timedActivations) SetActivationToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SetActivationToken(ctx, userID, tokenHash, expiresAt)
+	})
+}
+
+func (t timedActivations) ActivateUser(ctx context.Context, tokenHash string, passHash []byte, now time.Time) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.ActivateUser(ctx, tokenHash, passHash, now)
+	})
+}
+
+type timedAppQuotas struct {
+	next AppQuotaStorage
+	s    *stages
+}
+
+func (t timedAppQuotas) SaveAppUser(
+	ctx context.Context,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveAppUser(ctx, appID, email, passHash, firstName, lastName, middleName)
+	})
+}
+
+func (t timedAppQuotas) SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SetAppMaxUsers(ctx, appID, maxUsers)
+	})
+}
+
+func (t timedAppQuotas) AppQuota(ctx context.Context, appID int32) (models.AppQuota, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.AppQuota, error) {
+		return t.next.AppQuota(ctx, appID)
+	})
+}
+
+type timedRegistrations struct {
+	next RegistrationStorage
+	s    *stages
+}
+
+func (t timedRegistrations) SaveRegistration(ctx context.Context, reg models.Registration) (int64, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (int64, error) {
+		return t.next.SaveRegistration(ctx, reg)
+	})
+}
+
+type timedAppUsage struct {
+	next AppUsageStorage
+	s    *stages
+}
+
+func (t timedAppUsage) AddAppUsage(ctx context.Context, usage []models.AppUsage) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.AddAppUsage(ctx, usage)
+	})
+}
+
+func (t timedAppUsage) AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) ([]models.AppUsage, error) {
+		return t.next.AppUsage(ctx, appID, from, to)
+	})
+}
+
+type timedIssuances struct {
+	next TokenIssuanceStorage
+	s    *stages
+}
+
+func (t timedIssuances) SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.SaveTokenIssuance(ctx, issuance)
+	})
+}
+
+func (t timedIssuances) TokenIssuance(ctx context.Context, fingerprint string) (models.TokenIssuance, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (models.TokenIssuance, error) {
+		return t.next.TokenIssuance(ctx, fingerprint)
+	})
+}
+
+type timedRevocations struct {
+	next RevocationStorage
+	s    *stages
+}
+
+func (t timedRevocations) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return runStageErr(ctx, t.s, StageStorage, func(ctx context.Context) error {
+		return t.next.RevokeToken(ctx, jti, expiresAt)
+	})
+}
+
+func (t timedRevocations) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return runStage(ctx, t.s, StageStorage, func(ctx context.Context) (bool, error) {
+		return t.next.TokenRevoked(ctx, jti)
+	})
+}
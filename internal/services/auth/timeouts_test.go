@@ -0,0 +1,371 @@
+package auth
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/metrics"
+	"sso/internal/lib/retrybudget"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testStageTimeout = 20 * time.Millisecond
+
+// slowUsers blocks lookups by email until their context is done.
+type slowUsers struct {
+	*memory.Storage
+}
+
+func (s slowUsers) User(ctx context.Context, _ string) (models.User, error) {
+	<-ctx.Done()
+
+	return models.User{}, ctx.Err()
+}
+
+// slowHasher ignores contexts, as bcrypt does.
+type slowHasher struct {
+	BcryptHasher
+	delay time.Duration
+}
+
+func (h slowHasher) Hash(password string) ([]byte, error) {
+	time.Sleep(h.delay)
+
+	return h.BcryptHasher.Hash(password)
+}
+
+// slowEvents blocks until its context is done.
+type slowEvents struct{}
+
+func (slowEvents) SaveEvent(ctx context.Context, _ models.Event) (int64, error) {
+	<-ctx.Done()
+
+	return 0, ctx.Err()
+}
+
+// flakyUsers fails lookups by email with err.
+type flakyUsers struct {
+	*memory.Storage
+	err error
+}
+
+func (s flakyUsers) User(context.Context, string) (models.User, error) {
+	return models.User{}, errs.Wrap("storage.flaky.User", s.err)
+}
+
+func newTimedAuth(t *testing.T, opts ...Option) (*Auth, *memory.Storage) {
+	t.Helper()
+
+	a, storage, _ := newTestAuth(t, append([]Option{
+		WithStageTimeouts(StageTimeouts{
+			Storage:  testStageTimeout,
+			Hashing:  testStageTimeout,
+			Notifier: testStageTimeout,
+		}),
+	}, opts...)...)
+
+	return a, storage
+}
+
+func TestStageTimeouts_Storage(t *testing.T) {
+	ctx := context.Background()
+	a, storage := newTimedAuth(t, withUsers(func(s *memory.Storage) userStorage { return slowUsers{s} }))
+	storage.SaveApp(testApp)
+
+	_, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.Error(t, err)
+	assert.Equal(t, errs.DependencyTimeout, errs.CodeOf(err))
+	assert.ErrorContains(t, err, "storage timed out after 20ms")
+	stage, ok := StageOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, StageStorage, stage)
+	assert.Equal(t, int64(1), a.StageTimeouts()[StageStorage])
+}
+
+func TestStageTimeouts_Hashing(t *testing.T) {
+	ctx := context.Background()
+	a, storage := newTimedAuth(t, WithHasher(slowHasher{
+		BcryptHasher: BcryptHasher{Cost: bcrypt.MinCost},
+		delay:        time.Second,
+	}))
+
+	start := time.Now()
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	// The hasher is not waited for.
+	assert.Less(t, time.Since(start), time.Second/2)
+	stage, ok := StageOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, StageHashing, stage)
+	assert.Equal(t, errs.DependencyTimeout, errs.CodeOf(err))
+
+	_, err = storage.User(ctx, "user@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+}
+
+func TestHashDurations(t *testing.T) {
+	durations := metrics.NewHistogramVec("password_hash_duration_seconds", "op", metrics.HashingBuckets)
+	durations.Exemplars = true
+	a, storage := newTimedAuth(t, WithHashDurations(durations))
+	storage.SaveApp(testApp)
+
+	ctx := audit.NewContext(context.Background(), audit.Envelope{RequestID: "req-1"})
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.Login(context.Background(), "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	snap := durations.Snapshot()
+	assert.Equal(t, uint64(1), snap["hash"].Count)
+	assert.Equal(t, uint64(1), snap["compare"].Count)
+	assert.Contains(t, snap["hash"].Exemplars, metrics.Exemplar{Value: snap["hash"].Sum, RequestID: "req-1"})
+	for _, e := range snap["compare"].Exemplars {
+		assert.Empty(t, e.RequestID, "the login carried no request ID")
+	}
+}
+
+func TestStageTimeouts_Notifier(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTimedAuth(t, WithEvents(slowEvents{}))
+
+	// A failed publish does not fail the registration.
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "", "", "", 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), a.StageTimeouts()[StageNotifier])
+	assert.Zero(t, a.StageTimeouts()[StageStorage])
+}
+
+func TestStageTimeouts_RequestDeadlineFirst(t *testing.T) {
+	a, _ := newTimedAuth(t, WithEvents(slowEvents{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := runStage(ctx, a.stages, StageNotifier, func(ctx context.Context) (int64, error) {
+		return slowEvents{}.SaveEvent(ctx, models.Event{})
+	})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, ok := StageOf(err)
+	assert.False(t, ok)
+	assert.Zero(t, a.StageTimeouts()[StageNotifier])
+}
+
+func TestStageTimeouts_ClassifiesStorage(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		err  error
+		want errs.Code
+	}{
+		{name: "transient", err: driver.ErrBadConn, want: errs.StorageUnavailable},
+		{name: "permanent", err: errors.New("no such table: users"), want: errs.Internal},
+		{name: "coded", err: errs.ErrUserNotFound, want: errs.InvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, storage := newTimedAuth(t, withUsers(func(s *memory.Storage) userStorage { return flakyUsers{s, tt.err} }))
+			storage.SaveApp(testApp)
+
+			_, err := a.Login(ctx, "john@example.com", "password", 1)
+			assert.Equal(t, tt.want, errs.CodeOf(err), err)
+		})
+	}
+}
+
+func TestStageTimeouts_Unbounded(t *testing.T) {
+	a, _, _ := newTestAuth(t)
+
+	// Without timeouts the dependencies are not wrapped at all.
+	_, ok := a.userProvider.(*memory.Storage)
+	assert.True(t, ok)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	storage := memory.New()
+	_, err := NewService(log, storage, storage, storage, WithStageTimeouts(StageTimeouts{Storage: -time.Second}))
+	assert.Error(t, err)
+}
+
+// stallingUsers fails the first failures lookups by email or ID as a
+// busy database does, then answers. Its saves always fail that way.
+type stallingUsers struct {
+	*memory.Storage
+	failures int
+	calls    *int
+}
+
+func (s stallingUsers) stall(op string) error {
+	*s.calls++
+	if *s.calls <= s.failures {
+		return errs.Wrap(op, driver.ErrBadConn)
+	}
+
+	return nil
+}
+
+func (s stallingUsers) User(ctx context.Context, email string) (models.User, error) {
+	if err := s.stall("storage.stalling.User"); err != nil {
+		return models.User{}, err
+	}
+
+	return s.Storage.User(ctx, email)
+}
+
+func (s stallingUsers) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	if err := s.stall("storage.stalling.UserByID"); err != nil {
+		return models.User{}, err
+	}
+
+	return s.Storage.UserByID(ctx, userID)
+}
+
+func (s stallingUsers) SaveUser(context.Context, string, []byte, string, string, string) (int64, error) {
+	*s.calls++
+
+	return 0, errs.Wrap("storage.stalling.SaveUser", driver.ErrBadConn)
+}
+
+func newRetryingAuth(t *testing.T, failures int, opts ...Option) (*Auth, stallingUsers) {
+	t.Helper()
+
+	var users stallingUsers
+	opts = append([]Option{
+		WithReadRetry(100 * time.Millisecond),
+		withUsers(func(s *memory.Storage) userStorage {
+			users = stallingUsers{Storage: s, failures: failures, calls: new(int)}
+			return users
+		}),
+	}, opts...)
+	a, storage, _ := newTestAuthWithApp(t, opts...)
+
+	hash, err := a.hasher.Hash("correct-password")
+	require.NoError(t, err)
+	_, err = storage.SaveUser(context.Background(), "john@example.com", hash, "John", "Doe", "")
+	require.NoError(t, err)
+
+	return a, users
+}
+
+func TestReadRetry(t *testing.T) {
+	ctx := context.Background()
+
+	// Without the option the stall reaches the caller.
+	storage := memory.New()
+	storage.SaveApp(testApp)
+	users := stallingUsers{Storage: storage, failures: 1, calls: new(int)}
+	plain, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), users, users, storage,
+		WithHasher(BcryptHasher{Cost: bcrypt.MinCost}),
+		WithStageTimeouts(StageTimeouts{Storage: time.Second}),
+	)
+	require.NoError(t, err)
+	_, err = plain.Login(ctx, "john@example.com", "correct-password", 1)
+	assert.Equal(t, errs.StorageUnavailable, errs.CodeOf(err))
+	assert.Empty(t, plain.ReadRetries())
+
+	a, users := newRetryingAuth(t, 1)
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, *users.calls)
+	assert.Equal(t, map[string]int64{"retried": 1, "succeeded": 1, "skipped": 0}, a.ReadRetries())
+
+	// A read is retried once only.
+	a, users = newRetryingAuth(t, 2)
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	assert.Equal(t, errs.StorageUnavailable, errs.CodeOf(err))
+	assert.Equal(t, 2, *users.calls)
+	assert.Equal(t, map[string]int64{"retried": 1, "succeeded": 0, "skipped": 0}, a.ReadRetries())
+}
+
+func TestReadRetry_Budget(t *testing.T) {
+	// One retry for the request: the second read that stalls fails.
+	a, users := newRetryingAuth(t, 1)
+	ctx := retrybudget.NewContext(context.Background(), 1)
+	_, err := a.Login(ctx, "john@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	*users.calls = 0
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	assert.Equal(t, errs.StorageUnavailable, errs.CodeOf(err))
+	assert.Equal(t, map[string]int64{"retried": 1, "succeeded": 1, "skipped": 1}, a.ReadRetries())
+
+	// Too little time left before the deadline to try again.
+	a, users = newRetryingAuth(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = a.Login(ctx, "john@example.com", "correct-password", 1)
+	assert.Equal(t, errs.StorageUnavailable, errs.CodeOf(err))
+	assert.Equal(t, 1, *users.calls)
+	assert.Equal(t, int64(1), a.ReadRetries()["skipped"])
+}
+
+func TestReadRetry_NotWrites(t *testing.T) {
+	a, users := newRetryingAuth(t, 0)
+
+	_, err := a.RegisterNewUser(context.Background(), "jane@example.com", "correct-password", "Jane", "Doe", "", "", "", 0)
+	assert.Equal(t, errs.StorageUnavailable, errs.CodeOf(err))
+	assert.Equal(t, 1, *users.calls, "saves are not retried")
+	assert.Zero(t, a.ReadRetries()["retried"])
+}
+
+// BenchmarkReadRetry measures logins while every other lookup stalls, as
+// during a checkpoint, with and without retries.
+func BenchmarkReadRetry(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "no retry", opts: []Option{WithStageTimeouts(StageTimeouts{Storage: time.Second})}},
+		{name: "retry", opts: []Option{WithReadRetry(0)}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			storage := memory.New()
+			storage.SaveApp(testApp)
+			hasher := BcryptHasher{Cost: bcrypt.MinCost}
+			hash, _ := hasher.Hash("correct-password")
+			_, _ = storage.SaveUser(context.Background(), "john@example.com", hash, "John", "Doe", "")
+
+			calls := 0
+			users := alternatingUsers{Storage: storage, calls: &calls}
+			a, err := NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), users, users, storage,
+				append([]Option{WithHasher(hasher)}, bb.opts...)...)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			failed := 0
+			for b.Loop() {
+				if _, err := a.Login(context.Background(), "john@example.com", "correct-password", 1); err != nil {
+					failed++
+				}
+			}
+			b.ReportMetric(float64(failed)/float64(b.N), "failed/op")
+		})
+	}
+}
+
+// alternatingUsers fails every other lookup by email as a busy database
+// does.
+type alternatingUsers struct {
+	*memory.Storage
+	calls *int
+}
+
+func (s alternatingUsers) User(ctx context.Context, email string) (models.User, error) {
+	*s.calls++
+	if *s.calls%2 == 1 {
+		return models.User{}, errs.Wrap("storage.alternating.User", driver.ErrBadConn)
+	}
+
+	return s.Storage.User(ctx, email)
+}
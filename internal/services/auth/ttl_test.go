@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogin_TokenTTLPrecedence(t *testing.T) {
+	const week = 7 * 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		userTTL    time.Duration
+		appTTL     time.Duration
+		maxTTL     time.Duration
+		wantTTL    time.Duration
+		wantSource string
+	}{
+		{name: "service default", wantTTL: testTokenTTL, wantSource: TTLSourceDefault},
+		{name: "app override", appTTL: 2 * time.Hour, wantTTL: 2 * time.Hour, wantSource: TTLSourceApp},
+		{name: "user override", userTTL: week, wantTTL: week, wantSource: TTLSourceUser},
+		{name: "user wins over app", userTTL: week, appTTL: 2 * time.Hour, wantTTL: week, wantSource: TTLSourceUser},
+		{name: "shorter user override", userTTL: time.Minute, appTTL: 2 * time.Hour, wantTTL: time.Minute, wantSource: TTLSourceUser},
+		{name: "user capped", userTTL: week, maxTTL: 24 * time.Hour, wantTTL: 24 * time.Hour, wantSource: TTLSourceUser},
+		{name: "app capped", appTTL: week, maxTTL: 24 * time.Hour, wantTTL: 24 * time.Hour, wantSource: TTLSourceApp},
+		{name: "default under the cap", maxTTL: 24 * time.Hour, wantTTL: testTokenTTL, wantSource: TTLSourceDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, storage, _ := newTestAuth(t)
+			a.maxTokenTTL = tt.maxTTL
+			storage.SaveApp(models.App{ID: 1, Name: "kiosk", Secret: "test-secret", TokenTTL: tt.appTTL})
+
+			user, err := a.RegisterNewUser(ctx, "kiosk@example.com", "correct-password", "Kiosk", "One", "", "", "", 0)
+			require.NoError(t, err)
+			userID := user.ID
+			require.NoError(t, a.SetUserTokenTTL(ctx, userID, tt.userTTL))
+
+			token, err := a.Login(ctx, "kiosk@example.com", "correct-password", 1)
+			require.NoError(t, err)
+
+			claims, err := a.ValidateToken(ctx, token)
+			require.NoError(t, err)
+
+			ttl := time.Duration(claims.Raw["exp"].(float64)-claims.Raw["iat"].(float64)) * time.Second
+			assert.Equal(t, tt.wantTTL, ttl)
+			assert.Equal(t, tt.wantSource, claims.Raw["ttl_src"])
+		})
+	}
+}
+
+func TestSetUserTokenTTL(t *testing.T) {
+	ctx := context.Background()
+	a, _, _ := newTestAuth(t)
+
+	user, err := a.RegisterNewUser(ctx, "kiosk@example.com", "correct-password", "Kiosk", "One", "", "", "", 0)
+	require.NoError(t, err)
+	userID := user.ID
+
+	err = a.SetUserTokenTTL(ctx, userID, -time.Second)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+
+	err = a.SetUserTokenTTL(ctx, userID+1000, time.Hour)
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+}
+
+func TestExchangeAuthorizationCode_TokenTTL(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newTestAuthorizationFlow(t)
+
+	user, err := a.userProvider.User(ctx, "user@example.com")
+	require.NoError(t, err)
+	require.NoError(t, a.SetUserTokenTTL(ctx, user.ID, 24*time.Hour))
+
+	code := startAndLogin(t, a)
+	token, err := a.ExchangeAuthorizationCode(ctx, code, 7, testAppSecret, testRedirectURI)
+	require.NoError(t, err)
+
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, TTLSourceUser, claims.Raw["ttl_src"])
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), claims.ExpiresAt, 2*time.Second)
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// MaxUsageRange is the longest range accepted by AppUsage.
+const MaxUsageRange = MaxStatsRange
+
+// errNoAppUsage is returned by AppUsage when the service was built
+// without WithAppUsage.
+var errNoAppUsage = errors.New("app usage storage is not configured")
+
+type AppUsageStorage interface {
+	AddAppUsage(ctx context.Context, usage []models.AppUsage) error
+	AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error)
+}
+
+type usageKey struct {
+	appID int32
+	day   time.Time
+}
+
+// usageCounts holds the usage counted since the last flush, by app and
+// day.
+type usageCounts struct {
+	mu      sync.Mutex
+	pending map[usageKey]models.AppUsage
+}
+
+func newUsageCounts() *usageCounts {
+	return &usageCounts{pending: make(map[usageKey]models.AppUsage)}
+}
+
+// add adds the counts of u to those pending for its app and day.
+func (c *usageCounts) add(u models.AppUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := usageKey{appID: u.AppID, day: u.Day}
+	total := c.pending[key]
+	total.AppID, total.Day = u.AppID, u.Day
+	total.Logins += u.Logins
+	total.TokensIssued += u.TokensIssued
+	total.Refreshes += u.Refreshes
+	c.pending[key] = total
+}
+
+// take returns the pending counts, oldest day first, and clears them.
+func (c *usageCounts) take() []models.AppUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	usage := slices.SortedFunc(maps.Values(c.pending), func(a, b models.AppUsage) int {
+		if n := a.Day.Compare(b.Day); n != 0 {
+			return n
+		}
+		return int(a.AppID - b.AppID)
+	})
+	clear(c.pending)
+
+	return usage
+}
+
+// usageDay returns the midnight in UTC starting the day of t.
+func usageDay(t time.Time) time.Time {
+	t = t.UTC()
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// countUsage counts a use of the app for the day, if usage is accounted.
+// It only adds to the counts in memory: FlushUsage writes them.
+func (a *Auth) countUsage(appID int32, u models.AppUsage) {
+	if a.usage == nil || appID == 0 {
+		return
+	}
+
+	u.AppID = appID
+	u.Day = usageDay(a.clock.Now())
+	a.usageCounts.add(u)
+}
+
+// FlushUsage writes the app usage counted since the last flush, in one
+// batch. Counts that fail to be written are kept for the next flush, but
+// for those of apps that no longer exist, which are dropped.
+func (a *Auth) FlushUsage(ctx context.Context) error {
+	const op = "services.auth.FlushUsage"
+
+	if a.usage == nil {
+		return nil
+	}
+
+	usage := a.usageCounts.take()
+	if len(usage) == 0 {
+		return nil
+	}
+
+	err := a.usage.AddAppUsage(ctx, usage)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errs.ErrAppNotFound):
+		a.log.Warn("app usage of unknown app dropped", slog.String("op", op), slog.Int("days", len(usage)))
+
+		return nil
+	}
+
+	for _, u := range usage {
+		a.usageCounts.add(u)
+	}
+
+	return errs.Wrap(op, err)
+}
+
+// AppUsage returns the usage of the app on every day from the day of from
+// up to to, oldest first, days without any included. Usage not flushed
+// yet, see FlushUsage, is not included.
+//
+// If the range is longer than MaxUsageRange, returns
+// errs.ErrRangeTooLarge.
+func (a *Auth) AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error) {
+	const op = "services.auth.AppUsage"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	))
+
+	if a.usage == nil {
+		return nil, errs.Wrap(op, errNoAppUsage)
+	}
+	if appID <= 0 {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id must be positive"))
+	}
+	if !from.Before(to) {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "from must be before to"))
+	}
+	if to.Sub(from) > MaxUsageRange {
+		return nil, errs.Wrap(op, errs.ErrRangeTooLarge)
+	}
+
+	if _, err := a.appProvider.App(ctx, appID); err != nil {
+		if !errors.Is(err, errs.ErrAppNotFound) {
+			log.Error("failed to get app", slog.Any("error", err))
+		}
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	from = usageDay(from)
+	stored, err := a.usage.AppUsage(ctx, appID, from, to)
+	if err != nil {
+		log.Error("failed to get app usage", slog.Any("error", err))
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	var usage []models.AppUsage
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if len(stored) > 0 && stored[0].Day.Equal(day) {
+			usage = append(usage, stored[0])
+			stored = stored[1:]
+
+			continue
+		}
+		usage = append(usage, models.AppUsage{AppID: appID, Day: day})
+	}
+
+	return usage, nil
+}
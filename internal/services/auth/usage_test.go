@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyUsage fails AddAppUsage while failing is set.
+type flakyUsage struct {
+	*memory.Storage
+	failing bool
+}
+
+func (f *flakyUsage) AddAppUsage(ctx context.Context, usage []models.AppUsage) error {
+	if f.failing {
+		return errors.New("database is locked")
+	}
+
+	return f.Storage.AddAppUsage(ctx, usage)
+}
+
+func newTestUsageAuth(t *testing.T) (*Auth, *flakyUsage, *clock.Fake) {
+	t.Helper()
+
+	var usage *flakyUsage
+	a, storage, clk := newTestAuthWithApp(t,
+		WithTokenTTL(72*time.Hour),
+		withStorage(func(s *memory.Storage) Option {
+			usage = &flakyUsage{Storage: s}
+			return WithAppUsage(usage)
+		}),
+	)
+	storage.SaveApp(models.App{ID: 2, Name: "pilot", Secret: "pilot-secret"})
+	clk.Set(time.Date(2024, 3, 1, 22, 0, 0, 0, time.UTC))
+
+	return a, usage, clk
+}
+
+func TestAppUsage(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk := newTestUsageAuth(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	admin, err := a.RegisterNewUser(ctx, "admin@example.com", "correct-password", "Ada", "Admin", "", "", "", 0)
+	require.NoError(t, err)
+	adminID := admin.ID
+	storage.SetUserRole(adminID, AdminRole)
+
+	// Day one: two logins to lms, one to pilot, and a failed one.
+	token, err := a.Login(ctx, "admin@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "admin@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "admin@example.com", "correct-password", 2)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "admin@example.com", "wrong-password", 1)
+	require.Error(t, err)
+
+	// Nothing is written until flushed.
+	usage, err := a.AppUsage(ctx, 1, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{{AppID: 1, Day: day}}, usage)
+	require.NoError(t, a.FlushUsage(ctx))
+
+	// Day two, counted in the same flush as day three: an elevation.
+	clk.Advance(4 * time.Hour)
+	_, err = a.ElevatePrivileges(ctx, token, "correct-password")
+	require.NoError(t, err)
+	// Day four.
+	clk.Advance(48 * time.Hour)
+	_, err = a.Login(ctx, "admin@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	require.NoError(t, a.FlushUsage(ctx))
+
+	usage, err = a.AppUsage(ctx, 1, day.Add(3*time.Hour), day.AddDate(0, 0, 5))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{
+		{AppID: 1, Day: day, Logins: 2, TokensIssued: 2},
+		{AppID: 1, Day: day.AddDate(0, 0, 1), TokensIssued: 1, Refreshes: 1},
+		{AppID: 1, Day: day.AddDate(0, 0, 2)},
+		{AppID: 1, Day: day.AddDate(0, 0, 3), Logins: 1, TokensIssued: 1},
+		{AppID: 1, Day: day.AddDate(0, 0, 4)},
+	}, usage)
+
+	usage, err = a.AppUsage(ctx, 2, day, day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{{AppID: 2, Day: day, Logins: 1, TokensIssued: 1}}, usage)
+}
+
+func TestFlushUsage_KeepsFailedCounts(t *testing.T) {
+	ctx := context.Background()
+	a, storage, clk := newTestUsageAuth(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := a.RegisterNewUser(ctx, "student@example.com", "correct-password", "Sam", "Student", "", "", "", 0)
+	require.NoError(t, err)
+	_, err = a.Login(ctx, "student@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	storage.failing = true
+	assert.Error(t, a.FlushUsage(ctx))
+	clk.Advance(24 * time.Hour)
+	_, err = a.Login(ctx, "student@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	assert.Error(t, a.FlushUsage(ctx))
+
+	storage.failing = false
+	require.NoError(t, a.FlushUsage(ctx))
+	require.NoError(t, a.FlushUsage(ctx), "nothing left")
+
+	usage, err := a.AppUsage(ctx, 1, day, day.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{
+		{AppID: 1, Day: day, Logins: 1, TokensIssued: 1},
+		{AppID: 1, Day: day.AddDate(0, 0, 1), Logins: 1, TokensIssued: 1},
+	}, usage)
+}
+
+func TestAppUsage_Rejects(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	a, _, _ := newTestAuth(t)
+	_, err := a.AppUsage(ctx, 1, day, day.AddDate(0, 0, 1))
+	assert.ErrorIs(t, err, errNoAppUsage)
+	assert.NoError(t, a.FlushUsage(ctx), "nothing to flush without usage")
+
+	a, _, _ = newTestUsageAuth(t)
+	_, err = a.AppUsage(ctx, 0, day, day.AddDate(0, 0, 1))
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.AppUsage(ctx, 1, day, day)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	_, err = a.AppUsage(ctx, 1, day, day.Add(MaxUsageRange+time.Hour))
+	assert.ErrorIs(t, err, errs.ErrRangeTooLarge)
+	_, err = a.AppUsage(ctx, 3, day, day.AddDate(0, 0, 1))
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+}
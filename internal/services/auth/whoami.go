@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+)
+
+// WhoAmI describes the holder of a token already checked by
+// ValidateToken. Names and roles are read from storage, so they are
+// current rather than what they were when the token was issued.
+//
+// A token that outlived its user or app returns errs.ErrInvalidToken.
+func (a *Auth) WhoAmI(ctx context.Context, claims jwt.Claims) (models.Identity, error) {
+	const op = "services.auth.WhoAmI"
+
+	log := withClientIP(ctx, a.log.With(
+		slog.String("op", op),
+		slog.Int64("user_id", claims.UserID),
+	))
+
+	user, err := a.userProvider.UserByID(ctx, claims.UserID)
+	if err != nil {
+		if errors.Is(err, errs.ErrUserNotFound) {
+			log.Warn("token of a deleted user")
+
+			return models.Identity{}, errs.Wrap(op, errs.ErrInvalidToken)
+		}
+		log.Error("failed to get user", slog.Any("error", err))
+
+		return models.Identity{}, errs.Wrap(op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, claims.AppID)
+	if err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			log.Warn("token of a deleted app", slog.Int("app_id", int(claims.AppID)))
+
+			return models.Identity{}, errs.Wrap(op, errs.ErrInvalidToken)
+		}
+		log.Error("failed to get app", slog.Any("error", err))
+
+		return models.Identity{}, errs.Wrap(op, err)
+	}
+
+	roles := []string{}
+	role, err := a.userProvider.UserRole(ctx, user.ID)
+	switch {
+	case err == nil:
+		roles = append(roles, role)
+	case errors.Is(err, errs.ErrRoleNotFound):
+	default:
+		log.Error("failed to get user role", slog.Any("error", err))
+
+		return models.Identity{}, errs.Wrap(op, err)
+	}
+
+	return models.Identity{
+		UserID:     user.ID,
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		MiddleName: user.MiddleName,
+		Roles:      roles,
+		AppID:      app.ID,
+		AppName:    app.Name,
+		IssuedAt:   claims.IssuedAt,
+		ExpiresAt:  claims.ExpiresAt,
+	}, nil
+}
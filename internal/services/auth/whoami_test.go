@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhoAmI(t *testing.T) {
+	ctx := context.Background()
+	a, storage, _ := newTestAuth(t)
+	storage.SaveApp(models.App{ID: 1, Name: "journal", Secret: "journal-secret"})
+
+	user, err := a.RegisterNewUser(ctx, "user@example.com", "correct-password", "John", "Doe", "Jr", "", "", 0)
+	require.NoError(t, err)
+	id := user.ID
+
+	token, err := a.Login(ctx, "user@example.com", "correct-password", 1)
+	require.NoError(t, err)
+	claims, err := a.ValidateToken(ctx, token)
+	require.NoError(t, err)
+
+	identity, err := a.WhoAmI(ctx, claims)
+	require.NoError(t, err)
+	assert.Equal(t, models.Identity{
+		UserID:     id,
+		Email:      "user@example.com",
+		FirstName:  "John",
+		LastName:   "Doe",
+		MiddleName: "Jr",
+		Roles:      []string{},
+		AppID:      1,
+		AppName:    "journal",
+		IssuedAt:   claims.IssuedAt,
+		ExpiresAt:  claims.ExpiresAt,
+	}, identity)
+	assert.WithinDuration(t, time.Now(), identity.IssuedAt, time.Minute)
+	assert.Equal(t, identity.IssuedAt.Add(testTokenTTL), identity.ExpiresAt)
+
+	storage.SetUserRole(id, AdminRole)
+	identity, err = a.WhoAmI(ctx, claims)
+	require.NoError(t, err)
+	assert.Equal(t, []string{AdminRole}, identity.Roles)
+
+	// Tokens that outlived their user or app.
+	claims.AppID = 2
+	_, err = a.WhoAmI(ctx, claims)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+
+	claims.AppID, claims.UserID = 1, id+1
+	_, err = a.WhoAmI(ctx, claims)
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+}
@@ -0,0 +1,137 @@
+// Package keys manages RS256 signing keys: it keeps them in storage, so
+// every instance signs with the same key, and rotates them.
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/jwt"
+)
+
+const rsaKeyBits = 2048
+
+type Storage interface {
+	SaveSigningKey(ctx context.Context, key models.SigningKey) error
+	SigningKeys(ctx context.Context, limit int) ([]models.SigningKey, error)
+}
+
+// Manager loads the signing keys into a jwt.KeySet and rotates them.
+type Manager struct {
+	log              *slog.Logger
+	storage          Storage
+	keys             *jwt.KeySet
+	rotationInterval time.Duration
+	clock            clock.Clock
+}
+
+// New returns a manager with an empty key set; call Refresh before
+// signing anything. A non-positive rotationInterval disables rotation, but
+// a key is still generated when storage has none. Key ages are measured
+// with clk.
+func New(log *slog.Logger, storage Storage, rotationInterval time.Duration, clk clock.Clock) *Manager {
+	return &Manager{
+		log:              log,
+		storage:          storage,
+		keys:             jwt.NewKeySet(),
+		rotationInterval: rotationInterval,
+		clock:            clk,
+	}
+}
+
+// Keys returns the key set kept up to date by Refresh.
+func (m *Manager) Keys() *jwt.KeySet {
+	return m.keys
+}
+
+// Refresh reloads the two newest keys from storage, generating a new one
+// first when there is none or the newest is older than the rotation
+// interval. It is meant to run periodically on every instance, so keys
+// rotated by one instance reach the others.
+func (m *Manager) Refresh(ctx context.Context) error {
+	const op = "services.keys.Refresh"
+
+	log := m.log.With(
+		slog.String("op", op),
+	)
+
+	stored, err := m.storage.SigningKeys(ctx, 2)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if m.rotationDue(stored) {
+		key, err := generate(m.clock.Now())
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err := m.storage.SaveSigningKey(ctx, key); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("signing key rotated", slog.String("kid", key.ID))
+
+		stored, err = m.storage.SigningKeys(ctx, 2)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var loaded [2]jwt.Key
+	for i, key := range stored {
+		loaded[i], err = parse(key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	m.keys.Set(loaded[0], loaded[1])
+
+	return nil
+}
+
+func (m *Manager) rotationDue(stored []models.SigningKey) bool {
+	if len(stored) == 0 {
+		return true
+	}
+
+	return m.rotationInterval > 0 && m.clock.Now().Sub(stored[0].CreatedAt) >= m.rotationInterval
+}
+
+func generate(now time.Time) (models.SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	return models.SigningKey{
+		ID:         jwt.Thumbprint(&private.PublicKey),
+		PrivateKey: der,
+		CreatedAt:  now,
+	}, nil
+}
+
+func parse(key models.SigningKey) (jwt.Key, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return jwt.Key{}, fmt.Errorf("failed to decode key %q: %w", key.ID, err)
+	}
+
+	private, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return jwt.Key{}, fmt.Errorf("key %q is not an RSA key", key.ID)
+	}
+
+	return jwt.NewKey(private), nil
+}
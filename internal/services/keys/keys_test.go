@@ -0,0 +1,72 @@
+package keys
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	m := New(log, storage, time.Hour, clock.Real())
+
+	_, ok := m.Keys().Current()
+	require.False(t, ok, "empty before the first refresh")
+
+	require.NoError(t, m.Refresh(ctx))
+	first, ok := m.Keys().Current()
+	require.True(t, ok)
+	assert.Len(t, m.Keys().Keys(), 1)
+
+	// A fresh key is not rotated.
+	require.NoError(t, m.Refresh(ctx))
+	current, _ := m.Keys().Current()
+	assert.Equal(t, first.ID, current.ID)
+
+	// Another instance sharing the storage loads the same key.
+	other := New(log, storage, time.Hour, clock.Real())
+	require.NoError(t, other.Refresh(ctx))
+	current, _ = other.Keys().Current()
+	assert.Equal(t, first.ID, current.ID)
+}
+
+func TestRefresh_Rotates(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	clk := clock.NewFake(time.Now())
+	m := New(log, storage, time.Hour, clk)
+
+	require.NoError(t, m.Refresh(ctx))
+	first, _ := m.Keys().Current()
+
+	clk.Advance(time.Hour - time.Second)
+	require.NoError(t, m.Refresh(ctx))
+	current, _ := m.Keys().Current()
+	assert.Equal(t, first.ID, current.ID, "not due yet")
+
+	clk.Advance(time.Second)
+	require.NoError(t, m.Refresh(ctx))
+	second, _ := m.Keys().Current()
+	assert.NotEqual(t, first.ID, second.ID)
+
+	_, ok := m.Keys().PublicKey(first.ID)
+	assert.True(t, ok, "previous key is still published")
+
+	clk.Advance(time.Hour)
+	require.NoError(t, m.Refresh(ctx))
+	_, ok = m.Keys().PublicKey(first.ID)
+	assert.False(t, ok, "only two keys are kept")
+}
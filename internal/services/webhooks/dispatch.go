@@ -0,0 +1,223 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+)
+
+// EventPing is the type of the events sent by TestWebhook.
+const EventPing = "ping"
+
+// Headers of every delivery. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), see Sign.
+const (
+	HeaderEvent     = "X-SSO-Event"
+	HeaderDelivery  = "X-SSO-Delivery"
+	HeaderTimestamp = "X-SSO-Timestamp"
+	HeaderSignature = "X-SSO-Signature"
+)
+
+const (
+	// eventsPerDispatch bounds the events a webhook is sent per Dispatch.
+	eventsPerDispatch = 100
+
+	initialRetryBackoff = 10 * time.Second
+	maxRetryBackoff     = time.Hour
+)
+
+// payload is the body of a delivery.
+type payload struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    int64           `json:"user_id,omitempty"`
+	AppID     int32           `json:"app_id,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign returns the signature header value of body sent at timestamp, in
+// Unix seconds.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatch sends every active webhook due for an attempt the events
+// published since its last delivery, in order. It is meant to run
+// periodically.
+//
+// A failed delivery stops the webhook at that event and schedules a retry
+// with exponential backoff; after the configured number of consecutive
+// failures the webhook is disabled. Failures of one webhook never hold up
+// the others.
+func (s *Service) Dispatch(ctx context.Context) error {
+	const op = "services.webhooks.Dispatch"
+
+	webhooks, err := s.storage.Webhooks(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := s.clock.Now()
+	for _, webhook := range webhooks {
+		if !webhook.Active || webhook.NextAttemptAt.After(now) {
+			continue
+		}
+		if err := s.dispatch(ctx, webhook); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%s: %w", op, ctx.Err())
+			}
+			s.log.Error("failed to dispatch webhook",
+				slog.String("op", op),
+				slog.Int64("webhook_id", webhook.ID),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	return nil
+}
+
+// dispatch delivers the pending events of one webhook and stores how far
+// it got.
+func (s *Service) dispatch(ctx context.Context, webhook models.Webhook) error {
+	log := s.log.With(
+		slog.Int64("webhook_id", webhook.ID),
+		slog.Int("app_id", int(webhook.AppID)),
+	)
+
+	events, err := s.storage.Events(ctx, webhook.LastEventID, eventsPerDispatch)
+	if err != nil {
+		return err
+	}
+
+	state := webhook.WebhookState
+	for _, event := range events {
+		if !matches(webhook, event) {
+			state.LastEventID = event.ID
+			continue
+		}
+
+		status, err := s.send(ctx, webhook, event)
+		if err == nil && (status < 200 || status > 299) {
+			err = fmt.Errorf("unexpected status %d", status)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			s.failed(ctx, log, &state, event, err)
+			break
+		}
+
+		s.delivered.Inc()
+		state.LastEventID = event.ID
+		state.Failures = 0
+		state.NextAttemptAt = time.Time{}
+	}
+
+	if state == webhook.WebhookState {
+		return nil
+	}
+
+	// The context may be done already; the progress is still worth saving
+	// so that delivered events are not sent twice.
+	return s.storage.UpdateWebhookState(context.WithoutCancel(ctx), webhook.ID, state)
+}
+
+// failed records a failed delivery in state, disabling the webhook when
+// it failed too many times in a row.
+func (s *Service) failed(ctx context.Context, log *slog.Logger, state *models.WebhookState, event models.Event, err error) {
+	state.Failures++
+	s.failures.Inc()
+
+	if state.Failures >= s.maxFailures {
+		state.Active = false
+		s.disabled.Inc()
+		state.NextAttemptAt = time.Time{}
+
+		audit.Log(ctx, log, slog.LevelWarn, "webhook disabled after consecutive failures", "webhook_disabled",
+			slog.Int("failures", state.Failures),
+			slog.Int64("event_id", event.ID),
+			slog.Any("error", err),
+		)
+
+		return
+	}
+
+	backoff := initialRetryBackoff << min(state.Failures-1, 16)
+	state.NextAttemptAt = s.clock.Now().Add(min(backoff, maxRetryBackoff))
+
+	log.Warn("webhook delivery failed",
+		slog.Int("failures", state.Failures),
+		slog.Int64("event_id", event.ID),
+		slog.Time("next_attempt_at", state.NextAttemptAt),
+		slog.Any("error", err),
+	)
+}
+
+// matches reports whether the webhook is subscribed to event. Events of
+// an app only go to the webhooks of that app.
+func matches(webhook models.Webhook, event models.Event) bool {
+	if event.AppID != 0 && event.AppID != webhook.AppID {
+		return false
+	}
+
+	return slices.Contains(webhook.EventTypes, event.Type)
+}
+
+// send posts event to the webhook and returns the response status.
+func (s *Service) send(ctx context.Context, webhook models.Webhook, event models.Event) (int, error) {
+	body, err := json.Marshal(payload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt.UTC(),
+		UserID:    event.UserID,
+		AppID:     event.AppID,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := s.clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(event.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a little so the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	return resp.StatusCode, nil
+}
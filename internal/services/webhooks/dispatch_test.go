@@ -0,0 +1,248 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a webhook endpoint recording the events it accepted.
+type receiver struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	status int
+	events []payload
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+
+	r := &receiver{status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.status == http.StatusOK {
+			var p payload
+			_ = json.NewDecoder(req.Body).Decode(&p)
+			r.events = append(r.events, p)
+		}
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+}
+
+func (r *receiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []string
+	for _, p := range r.events {
+		res = append(res, p.Type)
+	}
+	return res
+}
+
+func publish(t *testing.T, storage interface {
+	SaveEvent(ctx context.Context, event models.Event) (int64, error)
+}, eventType string, appID int32) {
+	t.Helper()
+
+	_, err := storage.SaveEvent(context.Background(), models.Event{
+		Type:      eventType,
+		UserID:    1,
+		AppID:     appID,
+		Payload:   []byte(`{"email":"user@example.com"}`),
+		CreatedAt: time.Now(),
+	})
+	require.NoError(t, err)
+}
+
+func TestDispatch_FansOut(t *testing.T) {
+	ctx := context.Background()
+	s, storage, _ := newTestService(t)
+
+	lms, library := newReceiver(t), newReceiver(t)
+	_, err := s.CreateWebhook(ctx, 1, lms.URL, []string{models.EventUserRegistered, models.EventUserLoggedIn})
+	require.NoError(t, err)
+	_, err = s.CreateWebhook(ctx, 2, library.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+
+	publish(t, storage, models.EventUserRegistered, 0)
+	publish(t, storage, models.EventUserLoggedIn, 1)
+	publish(t, storage, models.EventUserLoggedIn, 2)
+
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Equal(t, []string{models.EventUserRegistered, models.EventUserLoggedIn}, lms.received())
+	assert.Equal(t, []string{models.EventUserRegistered}, library.received(), "logins go to the app logged in only")
+
+	// Nothing is sent twice.
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Len(t, lms.received(), 2)
+	assert.Len(t, library.received(), 1)
+
+	lms.mu.Lock()
+	defer lms.mu.Unlock()
+	assert.JSONEq(t, `{"email":"user@example.com"}`, string(lms.events[0].Data))
+	assert.Equal(t, int32(1), lms.events[1].AppID)
+}
+
+func TestDispatch_RetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	s, storage, clk := newTestService(t)
+
+	r := newReceiver(t)
+	webhook, err := s.CreateWebhook(ctx, 1, r.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+
+	publish(t, storage, models.EventUserRegistered, 0)
+	publish(t, storage, models.EventUserRegistered, 0)
+
+	r.setStatus(http.StatusServiceUnavailable)
+	require.NoError(t, s.Dispatch(ctx))
+
+	stored, err := storage.Webhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Failures)
+	assert.Equal(t, webhook.LastEventID, stored.LastEventID, "the failed event is retried")
+	assert.True(t, clk.Now().Add(initialRetryBackoff).Equal(stored.NextAttemptAt))
+
+	// Not due yet: the receiver recovered, but is not called.
+	r.setStatus(http.StatusOK)
+	clk.Advance(initialRetryBackoff - time.Second)
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Empty(t, r.received())
+
+	clk.Advance(time.Second)
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Len(t, r.received(), 2)
+
+	stored, err = storage.Webhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Zero(t, stored.Failures)
+	assert.True(t, stored.NextAttemptAt.IsZero())
+}
+
+func TestDispatch_DisablesAfterMaxFailures(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	s, storage, clk := newTestService(t, WithMaxFailures(3))
+	s.log = slog.New(slog.NewTextHandler(&logs, nil))
+
+	r := newReceiver(t)
+	r.setStatus(http.StatusInternalServerError)
+	webhook, err := s.CreateWebhook(ctx, 1, r.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	publish(t, storage, models.EventUserRegistered, 0)
+
+	for range 3 {
+		require.NoError(t, s.Dispatch(ctx))
+		clk.Advance(maxRetryBackoff)
+	}
+
+	stored, err := storage.Webhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.Active)
+	assert.Equal(t, 3, stored.Failures)
+	assert.Contains(t, logs.String(), "audit=webhook_disabled")
+
+	// A disabled webhook is left alone even once the receiver recovers.
+	r.setStatus(http.StatusOK)
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Empty(t, r.received())
+}
+
+func TestDispatch_FailingWebhookDoesNotBlockOthers(t *testing.T) {
+	ctx := context.Background()
+	s, storage, _ := newTestService(t)
+	s.client = &http.Client{Timeout: time.Second}
+
+	down := newReceiver(t)
+	down.Close()
+	up := newReceiver(t)
+
+	_, err := s.CreateWebhook(ctx, 1, down.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	_, err = s.CreateWebhook(ctx, 2, up.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	publish(t, storage, models.EventUserRegistered, 0)
+
+	require.NoError(t, s.Dispatch(ctx))
+	assert.Len(t, up.received(), 1)
+}
+
+func TestListFailedTasks(t *testing.T) {
+	ctx := context.Background()
+	s, storage, clk := newTestService(t, WithMaxFailures(2))
+
+	failing, up := newReceiver(t), newReceiver(t)
+	failing.setStatus(http.StatusServiceUnavailable)
+	webhook, err := s.CreateWebhook(ctx, 1, failing.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	_, err = s.CreateWebhook(ctx, 2, up.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	publish(t, storage, models.EventUserLoggedIn, 0)
+	publish(t, storage, models.EventUserRegistered, 0)
+
+	tasks, err := s.ListFailedTasks(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tasks)
+
+	require.NoError(t, s.Dispatch(ctx))
+	tasks, err = s.ListFailedTasks(ctx)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.Equal(t, models.FailedTask{
+		Consumer:      ConsumerWebhook,
+		WebhookID:     webhook.ID,
+		AppID:         1,
+		EventID:       2,
+		EventType:     models.EventUserRegistered,
+		Failures:      1,
+		NextAttemptAt: clk.Now().Add(initialRetryBackoff),
+	}, tasks[0])
+
+	clk.Advance(initialRetryBackoff)
+	require.NoError(t, s.Dispatch(ctx))
+	tasks, err = s.ListFailedTasks(ctx)
+	require.NoError(t, err)
+	require.Len(t, tasks, 1)
+	assert.True(t, tasks[0].Disabled)
+	assert.Equal(t, 2, tasks[0].Failures)
+
+	metrics := s.Metrics()
+	assert.Equal(t, int64(1), metrics["webhook_deliveries_total"])
+	assert.Equal(t, int64(2), metrics["webhook_delivery_failures_total"])
+	assert.Equal(t, int64(1), metrics["webhooks_disabled_total"])
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":1}`)
+
+	sig := Sign("secret", 1700000000, body)
+	assert.Equal(t, sig, Sign("secret", 1700000000, body))
+	assert.NotEqual(t, sig, Sign("secret", 1700000001, body))
+	assert.NotEqual(t, sig, Sign("other", 1700000000, body))
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, sig)
+}
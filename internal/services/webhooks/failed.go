@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"context"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// ConsumerWebhook is the consumer of the failed tasks of webhooks.
+const ConsumerWebhook = "webhook"
+
+// ListFailedTasks returns the deliveries the webhooks are stuck at: those
+// of webhooks that failed their last attempt, retried by Dispatch with
+// backoff, and of webhooks disabled after too many failures, which are
+// not.
+func (s *Service) ListFailedTasks(ctx context.Context) ([]models.FailedTask, error) {
+	const op = "services.webhooks.ListFailedTasks"
+
+	webhooks, err := s.storage.Webhooks(ctx, 0)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	var tasks []models.FailedTask
+	for _, webhook := range webhooks {
+		if webhook.Active && webhook.Failures == 0 {
+			continue
+		}
+
+		task := models.FailedTask{
+			Consumer:      ConsumerWebhook,
+			WebhookID:     webhook.ID,
+			AppID:         webhook.AppID,
+			Failures:      webhook.Failures,
+			NextAttemptAt: webhook.NextAttemptAt,
+			Disabled:      !webhook.Active,
+		}
+		event, ok, err := s.pending(ctx, webhook)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		if ok {
+			task.EventID = event.ID
+			task.EventType = event.Type
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// pending returns the next event the webhook is to be sent, the one it
+// failed if it did, looking as far as one Dispatch does.
+func (s *Service) pending(ctx context.Context, webhook models.Webhook) (models.Event, bool, error) {
+	events, err := s.storage.Events(ctx, webhook.LastEventID, eventsPerDispatch)
+	if err != nil {
+		return models.Event{}, false, err
+	}
+	for _, event := range events {
+		if matches(webhook, event) {
+			return event, true, nil
+		}
+	}
+
+	return models.Event{}, false, nil
+}
+
+// Metrics returns the metrics of the deliveries by name.
+func (s *Service) Metrics() map[string]any {
+	return map[string]any{
+		s.delivered.Name: s.delivered.Value(),
+		s.failures.Name:  s.failures.Value(),
+		s.disabled.Name:  s.disabled.Value(),
+	}
+}
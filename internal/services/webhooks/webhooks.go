@@ -0,0 +1,304 @@
+// Package webhooks manages the webhooks apps subscribe to lifecycle events
+// with and delivers the outbox events to them.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/audit"
+	"sso/internal/lib/clock"
+	"sso/internal/lib/metrics"
+)
+
+// EventTypes are the event types a webhook can subscribe to.
+var EventTypes = []string{
+	models.EventUserRegistered,
+	models.EventUserLoggedIn,
+	models.EventRegistrationAttempted,
+	models.EventDeletionScheduled,
+	models.EventDeletionCancelled,
+	models.EventUserDeleted,
+	models.EventActivationIssued,
+	models.EventUserActivated,
+}
+
+const (
+	// DefaultMaxFailures is the number of consecutive failed deliveries
+	// after which a webhook is disabled.
+	DefaultMaxFailures = 10
+	// DefaultTimeout bounds a single delivery.
+	DefaultTimeout = 5 * time.Second
+
+	secretBytes = 32
+)
+
+type Storage interface {
+	Events(ctx context.Context, afterID int64, limit int) ([]models.Event, error)
+	LastEventID(ctx context.Context) (int64, error)
+	SaveWebhook(ctx context.Context, webhook models.Webhook) (int64, error)
+	Webhook(ctx context.Context, id int64) (models.Webhook, error)
+	Webhooks(ctx context.Context, appID int32) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	UpdateWebhookState(ctx context.Context, id int64, state models.WebhookState) error
+}
+
+type AppProvider interface {
+	App(ctx context.Context, appID int32) (models.App, error)
+}
+
+type Service struct {
+	log         *slog.Logger
+	storage     Storage
+	apps        AppProvider
+	client      *http.Client
+	clock       clock.Clock
+	maxFailures int
+
+	delivered *metrics.Counter
+	failures  *metrics.Counter
+	disabled  *metrics.Counter
+}
+
+// Option configures Service, see New.
+type Option func(*Service)
+
+// WithClient sets the HTTP client of deliveries. Default a client with
+// DefaultTimeout.
+func WithClient(client *http.Client) Option {
+	return func(s *Service) { s.client = client }
+}
+
+// WithClock sets the clock of retry schedules. Default the system clock.
+func WithClock(c clock.Clock) Option {
+	return func(s *Service) { s.clock = c }
+}
+
+// WithMaxFailures sets the number of consecutive failed deliveries after
+// which a webhook is disabled. Default DefaultMaxFailures.
+func WithMaxFailures(n int) Option {
+	return func(s *Service) { s.maxFailures = n }
+}
+
+// New returns a new webhooks service.
+func New(log *slog.Logger, storage Storage, apps AppProvider, opts ...Option) (*Service, error) {
+	const op = "webhooks.New"
+
+	s := &Service{
+		log:         log,
+		storage:     storage,
+		apps:        apps,
+		client:      &http.Client{Timeout: DefaultTimeout},
+		clock:       clock.Real(),
+		maxFailures: DefaultMaxFailures,
+		delivered:   metrics.NewCounter("webhook_deliveries_total"),
+		failures:    metrics.NewCounter("webhook_delivery_failures_total"),
+		disabled:    metrics.NewCounter("webhooks_disabled_total"),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	switch {
+	case s.log == nil:
+		return nil, fmt.Errorf("%s: logger is required", op)
+	case s.storage == nil:
+		return nil, fmt.Errorf("%s: storage is required", op)
+	case s.apps == nil:
+		return nil, fmt.Errorf("%s: app provider is required", op)
+	case s.client == nil:
+		return nil, fmt.Errorf("%s: http client is nil", op)
+	case s.clock == nil:
+		return nil, fmt.Errorf("%s: clock is nil", op)
+	case s.maxFailures <= 0:
+		return nil, fmt.Errorf("%s: max failures must be positive, got %d", op, s.maxFailures)
+	}
+
+	return s, nil
+}
+
+// CreateWebhook subscribes the app to eventTypes at rawURL. Only events
+// published from now on are delivered. The returned webhook carries the
+// signing secret, which is not shown again.
+//
+// If app does not exist, returns errs.ErrAppNotFound.
+func (s *Service) CreateWebhook(
+	ctx context.Context,
+	appID int32,
+	rawURL string,
+	eventTypes []string,
+) (models.Webhook, error) {
+	const op = "services.webhooks.CreateWebhook"
+
+	log := s.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", int(appID)),
+	)
+
+	if err := validateURL(rawURL); err != nil {
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+	eventTypes, err := validateEventTypes(eventTypes)
+	if err != nil {
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	if _, err := s.apps.App(ctx, appID); err != nil {
+		if errors.Is(err, errs.ErrAppNotFound) {
+			return models.Webhook{}, errs.Wrap(op, errs.ErrAppNotFound)
+		}
+
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+	lastEventID, err := s.storage.LastEventID(ctx)
+	if err != nil {
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	webhook := models.Webhook{
+		AppID:      appID,
+		URL:        rawURL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		CreatedAt:  s.clock.Now(),
+		WebhookState: models.WebhookState{
+			Active:      true,
+			LastEventID: lastEventID,
+		},
+	}
+	webhook.ID, err = s.storage.SaveWebhook(ctx, webhook)
+	if err != nil {
+		log.Error("failed to save webhook", slog.Any("error", err))
+
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, log, slog.LevelInfo, "webhook created", "webhook_created",
+		slog.Int64("webhook_id", webhook.ID),
+		slog.Any("event_types", eventTypes),
+	)
+
+	return webhook, nil
+}
+
+// ListWebhooks returns the webhooks of the app without their secrets.
+func (s *Service) ListWebhooks(ctx context.Context, appID int32) ([]models.Webhook, error) {
+	const op = "services.webhooks.ListWebhooks"
+
+	if appID <= 0 {
+		return nil, errs.Wrap(op, errs.New(errs.InvalidArgument, "app_id is required"))
+	}
+
+	webhooks, err := s.storage.Webhooks(ctx, appID)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes the webhook. Deliveries in flight may still
+// complete.
+//
+// If webhook does not exist, returns errs.ErrWebhookNotFound.
+func (s *Service) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "services.webhooks.DeleteWebhook"
+
+	if err := s.storage.DeleteWebhook(ctx, id); err != nil {
+		if errors.Is(err, errs.ErrWebhookNotFound) {
+			return errs.Wrap(op, errs.ErrWebhookNotFound)
+		}
+
+		return errs.Wrap(op, err)
+	}
+
+	audit.Log(ctx, s.log, slog.LevelInfo, "webhook deleted", "webhook_deleted",
+		slog.String("op", op),
+		slog.Int64("webhook_id", id),
+	)
+
+	return nil
+}
+
+// TestWebhook sends a signed ping to the webhook, active or not, and
+// returns the HTTP status of the response. The delivery state is left
+// untouched.
+//
+// If webhook does not exist, returns errs.ErrWebhookNotFound.
+func (s *Service) TestWebhook(ctx context.Context, id int64) (int, error) {
+	const op = "services.webhooks.TestWebhook"
+
+	webhook, err := s.storage.Webhook(ctx, id)
+	if err != nil {
+		if errors.Is(err, errs.ErrWebhookNotFound) {
+			return 0, errs.Wrap(op, errs.ErrWebhookNotFound)
+		}
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	status, err := s.send(ctx, webhook, models.Event{
+		Type:      EventPing,
+		AppID:     webhook.AppID,
+		Payload:   []byte("{}"),
+		CreatedAt: s.clock.Now(),
+	})
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return status, nil
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errs.New(errs.InvalidArgument, "url must be an absolute http(s) url")
+	}
+
+	return nil
+}
+
+// validateEventTypes returns the sorted set of eventTypes.
+func validateEventTypes(eventTypes []string) ([]string, error) {
+	if len(eventTypes) == 0 {
+		return nil, errs.New(errs.InvalidArgument, "event_types are required")
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(EventTypes, t) {
+			return nil, errs.New(errs.InvalidArgument, "unknown event type "+t)
+		}
+	}
+
+	res := slices.Clone(eventTypes)
+	slices.Sort(res)
+
+	return slices.Compact(res), nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, secretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
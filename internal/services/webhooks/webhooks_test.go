@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/clock"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, opts ...Option) (*Service, *memory.Storage, *clock.Fake) {
+	t.Helper()
+
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 1, Name: "lms", Secret: "lms-secret"})
+	storage.SaveApp(models.App{ID: 2, Name: "library", Secret: "library-secret"})
+
+	clk := clock.NewFake(time.Now().Truncate(time.Second))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	s, err := New(log, storage, storage, append([]Option{WithClock(clk)}, opts...)...)
+	require.NoError(t, err)
+
+	return s, storage, clk
+}
+
+func TestCreateWebhook(t *testing.T) {
+	ctx := context.Background()
+	s, storage, _ := newTestService(t)
+
+	_, err := storage.SaveEvent(ctx, models.Event{Type: models.EventUserRegistered, Payload: []byte("{}")})
+	require.NoError(t, err)
+
+	webhook, err := s.CreateWebhook(ctx, 1, "https://lms.example.com/hooks",
+		[]string{models.EventUserLoggedIn, models.EventUserRegistered, models.EventUserLoggedIn})
+	require.NoError(t, err)
+	assert.Positive(t, webhook.ID)
+	assert.Len(t, webhook.Secret, 2*secretBytes)
+	assert.Equal(t, []string{models.EventUserLoggedIn, models.EventUserRegistered}, webhook.EventTypes)
+	assert.True(t, webhook.Active)
+	assert.Equal(t, int64(1), webhook.LastEventID, "earlier events are not delivered")
+
+	listed, err := s.ListWebhooks(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, webhook.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Secret, "secrets are shown once")
+
+	listed, err = s.ListWebhooks(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+
+	require.NoError(t, s.DeleteWebhook(ctx, webhook.ID))
+	assert.ErrorIs(t, s.DeleteWebhook(ctx, webhook.ID), errs.ErrWebhookNotFound)
+}
+
+func TestCreateWebhook_Rejects(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newTestService(t)
+
+	tests := []struct {
+		name       string
+		appID      int32
+		url        string
+		eventTypes []string
+		wantCode   errs.Code
+	}{
+		{name: "unknown app", appID: 9, url: "https://x.example.com", eventTypes: []string{models.EventUserRegistered}, wantCode: errs.AppNotFound},
+		{name: "relative url", appID: 1, url: "/hooks", eventTypes: []string{models.EventUserRegistered}, wantCode: errs.InvalidArgument},
+		{name: "other scheme", appID: 1, url: "ftp://x.example.com", eventTypes: []string{models.EventUserRegistered}, wantCode: errs.InvalidArgument},
+		{name: "no event types", appID: 1, url: "https://x.example.com", wantCode: errs.InvalidArgument},
+		{name: "unknown event type", appID: 1, url: "https://x.example.com", eventTypes: []string{"user.renamed"}, wantCode: errs.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.CreateWebhook(ctx, tt.appID, tt.url, tt.eventTypes)
+			assert.Equal(t, tt.wantCode, errs.CodeOf(err))
+		})
+	}
+}
+
+func TestTestWebhook(t *testing.T) {
+	ctx := context.Background()
+	s, storage, clk := newTestService(t)
+
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	webhook, err := s.CreateWebhook(ctx, 1, server.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+
+	status, err := s.TestWebhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, status)
+
+	require.NotNil(t, got)
+	assert.Equal(t, EventPing, got.Header.Get(HeaderEvent))
+	timestamp := strconv.FormatInt(clk.Now().Unix(), 10)
+	assert.Equal(t, timestamp, got.Header.Get(HeaderTimestamp))
+	assert.Equal(t, Sign(webhook.Secret, clk.Now().Unix(), body), got.Header.Get(HeaderSignature))
+	assert.NotEqual(t, Sign("other-secret", clk.Now().Unix(), body), got.Header.Get(HeaderSignature))
+
+	stored, err := storage.Webhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, webhook.WebhookState, stored.WebhookState, "a ping does not touch the delivery state")
+
+	// The status is reported as is, failures included.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	webhook, err = s.CreateWebhook(ctx, 1, failing.URL, []string{models.EventUserRegistered})
+	require.NoError(t, err)
+	status, err = s.TestWebhook(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+
+	_, err = s.TestWebhook(ctx, webhook.ID+100)
+	assert.ErrorIs(t, err, errs.ErrWebhookNotFound)
+}
+
+func TestNew_Validation(t *testing.T) {
+	storage := memory.New()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err := New(nil, storage, storage)
+	assert.Error(t, err)
+	_, err = New(log, nil, storage)
+	assert.Error(t, err)
+	_, err = New(log, storage, storage, WithMaxFailures(0))
+	assert.Error(t, err)
+	_, err = New(log, storage, storage, WithClient(nil))
+	assert.Error(t, err)
+
+	_, err = New(log, storage, storage)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Class tells whether a storage failure is worth retrying.
+type Class int
+
+const (
+	// Permanent failures, such as constraint violations or corrupt data,
+	// fail again however often they are retried. Unknown errors are
+	// permanent.
+	Permanent Class = iota
+	// Transient failures, such as a busy database, a reset connection or
+	// a deadline, may succeed when retried.
+	Transient
+)
+
+func (c Class) String() string {
+	if c == Transient {
+		return "transient"
+	}
+
+	return "permanent"
+}
+
+// Classifier classifies the errors of a driver. ok is false for errors it
+// does not know.
+type Classifier func(err error) (class Class, ok bool)
+
+var (
+	classifiersMu sync.RWMutex
+	classifiers   []Classifier
+)
+
+// RegisterClassifier adds the classification of the errors of a backend
+// driver to Classify. Backends call it from init.
+func RegisterClassifier(c Classifier) {
+	classifiersMu.Lock()
+	defer classifiersMu.Unlock()
+
+	classifiers = append(classifiers, c)
+}
+
+// Classify tells whether the storage failure err is worth retrying. The
+// backends that registered a classifier are asked first, then errors
+// common to every backend are recognized: deadlines, bad and closed
+// connections and network failures.
+func Classify(err error) Class {
+	classifiersMu.RLock()
+	defer classifiersMu.RUnlock()
+
+	for _, c := range classifiers {
+		if class, ok := c(err); ok {
+			return class
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE),
+		errors.As(err, &netErr):
+		return Transient
+	default:
+		return Permanent
+	}
+}
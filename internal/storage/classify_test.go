@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"sso/internal/domain/errs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replicaLagError stands for the error of a driver that registers its own
+// classification.
+type replicaLagError struct{}
+
+func (replicaLagError) Error() string { return "replica is lagging" }
+
+func TestClassify(t *testing.T) {
+	RegisterClassifier(func(err error) (Class, bool) {
+		if errors.As(err, new(replicaLagError)) {
+			return Transient, true
+		}
+
+		return 0, false
+	})
+
+	tests := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{
+			name: "deadline",
+			err:  errs.Wrap("storage.sqlite.User", context.DeadlineExceeded),
+			want: Transient,
+		},
+		{
+			name: "bad connection",
+			err:  errs.Wrap("storage.sqlite.User", driver.ErrBadConn),
+			want: Transient,
+		},
+		{
+			name: "closed connection",
+			err:  fmt.Errorf("storage.sqlite.User: %w", sql.ErrConnDone),
+			want: Transient,
+		},
+		{
+			name: "connection reset",
+			err: errs.Wrap("storage.postgres.User", &net.OpError{
+				Op:  "read",
+				Net: "tcp",
+				Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET},
+			}),
+			want: Transient,
+		},
+		{
+			name: "connection refused",
+			err:  errs.Wrap("storage.postgres.Ping", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}),
+			want: Transient,
+		},
+		{
+			name: "broken pipe",
+			err:  fmt.Errorf("write: %w", syscall.EPIPE),
+			want: Transient,
+		},
+		{
+			name: "registered by a backend",
+			err:  errs.Wrap("storage.replica.User", replicaLagError{}),
+			want: Transient,
+		},
+		{
+			name: "no rows",
+			err:  errs.Wrap("storage.sqlite.User", sql.ErrNoRows),
+			want: Permanent,
+		},
+		{
+			name: "unknown",
+			err:  errs.Wrap("storage.sqlite.User", errors.New("no such table: users")),
+			want: Permanent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Classify(tt.err))
+		})
+	}
+}
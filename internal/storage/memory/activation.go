@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/errs"
+)
+
+// activation is the activation token of a user pending activation.
+type activation struct {
+	tokenHash string
+	expiresAt time.Time
+}
+
+// PreRegisterUser saves a user without a password, pending activation
+// with the activation token stored as tokenHash until expiresAt.
+//
+// If the email is taken, returns errs.ErrUserExists.
+func (s *Storage) PreRegisterUser(
+	ctx context.Context,
+	email string,
+	firstName string,
+	lastName string,
+	middleName string,
+	tokenHash string,
+	expiresAt time.Time,
+) (int64, error) {
+	const op = "storage.memory.PreRegisterUser"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.saveUser(email, nil, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	user := s.users[id]
+	user.PassHash = []byte{}
+	user.ActivationPending = true
+	s.users[id] = user
+	// Same precision as the sqlite column.
+	s.activations[id] = activation{tokenHash: tokenHash, expiresAt: time.UnixMilli(expiresAt.UnixMilli())}
+
+	return id, nil
+}
+
+// SetActivationToken replaces the activation token of a user pending
+// activation with the one stored as tokenHash until expiresAt. An empty
+// tokenHash revokes the token without issuing another.
+//
+// If the user does not exist, returns errs.ErrUserNotFound; if it is
+// activated already, errs.ErrAccountActivated.
+func (s *Storage) SetActivationToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	const op = "storage.memory.SetActivationToken"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || s.deleted[userID] {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+	if !user.ActivationPending {
+		return errs.Wrap(op, errs.ErrAccountActivated)
+	}
+
+	if tokenHash == "" {
+		delete(s.activations, userID)
+	} else {
+		s.activations[userID] = activation{tokenHash: tokenHash, expiresAt: time.UnixMilli(expiresAt.UnixMilli())}
+	}
+	user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+	s.users[userID] = user
+
+	return nil
+}
+
+// ActivateUser sets the password of the user whose activation token is
+// stored as tokenHash, unless it expired by now, and returns its ID. The
+// token is used up.
+//
+// If no user has the token or it expired, returns
+// errs.ErrInvalidActivationToken.
+func (s *Storage) ActivateUser(ctx context.Context, tokenHash string, passHash []byte, now time.Time) (int64, error) {
+	const op = "storage.memory.ActivateUser"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, a := range s.activations {
+		if a.tokenHash != tokenHash {
+			continue
+		}
+		if !a.expiresAt.After(now) {
+			break
+		}
+
+		user := s.users[id]
+		user.PassHash = append([]byte(nil), passHash...)
+		user.ActivationPending = false
+		user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+		s.users[id] = user
+		delete(s.activations, id)
+
+		return id, nil
+	}
+
+	return 0, errs.Wrap(op, errs.ErrInvalidActivationToken)
+}
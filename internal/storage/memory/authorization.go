@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// AddRedirectURI adds uri to the allowlist of the app.
+func (s *Storage) AddRedirectURI(appID int32, uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.redirectURIs[appID] == nil {
+		s.redirectURIs[appID] = make(map[string]bool)
+	}
+	s.redirectURIs[appID][uri] = true
+}
+
+// RedirectURIAllowed reports whether uri is in the allowlist of the app.
+func (s *Storage) RedirectURIAllowed(ctx context.Context, appID int32, uri string) (bool, error) {
+	const op = "storage.memory.RedirectURIAllowed"
+
+	if err := ctx.Err(); err != nil {
+		return false, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.redirectURIs[appID][uri], nil
+}
+
+// SaveAuthorization stores a new pending authorization.
+func (s *Storage) SaveAuthorization(ctx context.Context, authz models.Authorization) error {
+	const op = "storage.memory.SaveAuthorization"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.authorizations[authz.ID]; ok {
+		return fmt.Errorf("%s: authorization %q already exists", op, authz.ID)
+	}
+	s.authorizations[authz.ID] = authz
+
+	return nil
+}
+
+// Authorization returns the authorization with the given ID.
+func (s *Storage) Authorization(ctx context.Context, id string) (models.Authorization, error) {
+	const op = "storage.memory.Authorization"
+
+	if err := ctx.Err(); err != nil {
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	authz, ok := s.authorizations[id]
+	if !ok {
+		return models.Authorization{}, errs.Wrap(op, errs.ErrAuthorizationNotFound)
+	}
+
+	return authz, nil
+}
+
+// IssueAuthorizationCode binds the user and the code to a pending
+// authorization. A code can be issued only once per authorization.
+func (s *Storage) IssueAuthorizationCode(
+	ctx context.Context,
+	id string,
+	userID int64,
+	codeHash string,
+	expiresAt time.Time,
+) error {
+	const op = "storage.memory.IssueAuthorizationCode"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authz, ok := s.authorizations[id]
+	if !ok || authz.CodeHash != "" {
+		return errs.Wrap(op, errs.ErrAuthorizationNotFound)
+	}
+
+	authz.UserID = userID
+	authz.CodeHash = codeHash
+	authz.ExpiresAt = expiresAt
+	s.authorizations[id] = authz
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks the code as used and returns its
+// authorization. If the code was already used, the authorization is
+// returned together with errs.ErrAuthorizationUsed.
+func (s *Storage) ConsumeAuthorizationCode(
+	ctx context.Context,
+	codeHash string,
+	usedAt time.Time,
+) (models.Authorization, error) {
+	const op = "storage.memory.ConsumeAuthorizationCode"
+
+	if err := ctx.Err(); err != nil {
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, authz := range s.authorizations {
+		if codeHash == "" || authz.CodeHash != codeHash {
+			continue
+		}
+		if !authz.UsedAt.IsZero() {
+			return authz, errs.Wrap(op, errs.ErrAuthorizationUsed)
+		}
+
+		authz.UsedAt = usedAt
+		s.authorizations[id] = authz
+
+		return authz, nil
+	}
+
+	return models.Authorization{}, errs.Wrap(op, errs.ErrAuthorizationNotFound)
+}
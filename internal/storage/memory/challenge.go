@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveChallenge stores a new pending login challenge.
+func (s *Storage) SaveChallenge(ctx context.Context, challenge models.Challenge) error {
+	const op = "storage.memory.SaveChallenge"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.challenges[challenge.TokenHash]; ok {
+		return fmt.Errorf("%s: challenge already exists", op)
+	}
+	s.challenges[challenge.TokenHash] = challenge
+
+	return nil
+}
+
+// Challenge returns the login challenge with the given token hash, used
+// or not. If there is none, returns errs.ErrInvalidChallenge.
+func (s *Storage) Challenge(ctx context.Context, tokenHash string) (models.Challenge, error) {
+	const op = "storage.memory.Challenge"
+
+	if err := ctx.Err(); err != nil {
+		return models.Challenge{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	challenge, ok := s.challenges[tokenHash]
+	if !ok {
+		return models.Challenge{}, errs.Wrap(op, errs.ErrInvalidChallenge)
+	}
+
+	return challenge, nil
+}
+
+// ConsumeChallenge marks the login challenge as used. A challenge can be
+// used once: if it is unknown or already used, returns
+// errs.ErrInvalidChallenge.
+func (s *Storage) ConsumeChallenge(ctx context.Context, tokenHash string, usedAt time.Time) error {
+	const op = "storage.memory.ConsumeChallenge"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	challenge, ok := s.challenges[tokenHash]
+	if !ok || !challenge.UsedAt.IsZero() {
+		return errs.Wrap(op, errs.ErrInvalidChallenge)
+	}
+	challenge.UsedAt = time.UnixMilli(usedAt.UnixMilli())
+	s.challenges[tokenHash] = challenge
+
+	return nil
+}
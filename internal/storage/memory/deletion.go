@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// ScheduleDeletion marks the user for anonymization after deleteAfter,
+// undoable with the reactivation token stored as tokenHash, and revokes
+// the tokens issued up to revokedAt. Scheduling again replaces the
+// deadline and the token.
+//
+// If the user does not exist or was already anonymized, returns
+// errs.ErrUserNotFound.
+func (s *Storage) ScheduleDeletion(
+	ctx context.Context,
+	userID int64,
+	deleteAfter time.Time,
+	tokenHash string,
+	revokedAt time.Time,
+) error {
+	const op = "storage.memory.ScheduleDeletion"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok || s.deleted[userID] {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	// Same precision as the sqlite columns.
+	user.DeleteAfter = time.UnixMilli(deleteAfter.UnixMilli())
+	user.SessionsRevokedAt = time.UnixMilli(revokedAt.UnixMilli())
+	user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+	s.users[userID] = user
+	s.reactivations[userID] = tokenHash
+
+	return nil
+}
+
+// CancelDeletion undoes the scheduled deletion of the user, given the hash
+// of its reactivation token before the deadline passed at now.
+//
+// If no deletion is scheduled, the token does not match or the deadline
+// has passed, returns errs.ErrInvalidReactivationToken.
+func (s *Storage) CancelDeletion(ctx context.Context, userID int64, tokenHash string, now time.Time) error {
+	const op = "storage.memory.CancelDeletion"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	hash, scheduled := s.reactivations[userID]
+	if !ok || !scheduled || hash != tokenHash || !user.DeleteAfter.After(now) {
+		return errs.Wrap(op, errs.ErrInvalidReactivationToken)
+	}
+
+	user.DeleteAfter = time.Time{}
+	user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+	s.users[userID] = user
+	delete(s.reactivations, userID)
+
+	return nil
+}
+
+// AnonymizeDueUsers anonymizes up to limit users whose deletion deadline
+// is not after now, earliest first, and returns their IDs. The users are
+// kept, but the email, names and password are replaced and the roles,
+// linked identities, pending authorizations and login challenges
+// removed.
+func (s *Storage) AnonymizeDueUsers(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	const op = "storage.memory.AnonymizeDueUsers"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []models.User
+	for _, user := range s.users {
+		if !user.DeleteAfter.IsZero() && !user.DeleteAfter.After(now) {
+			due = append(due, user)
+		}
+	}
+	slices.SortFunc(due, func(a, b models.User) int {
+		if c := a.DeleteAfter.Compare(b.DeleteAfter); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.ID, b.ID)
+	})
+	due = due[:min(len(due), limit)]
+
+	deletedAt := time.UnixMilli(now.UnixMilli())
+	ids := make([]int64, 0, len(due))
+	for _, user := range due {
+		delete(s.byEmail, user.Email)
+		s.users[user.ID] = models.User{
+			ID:                user.ID,
+			Email:             fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			PassHash:          []byte{},
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         deletedAt,
+			SessionsRevokedAt: user.SessionsRevokedAt,
+		}
+		s.byEmail[s.users[user.ID].Email] = user.ID
+		maps.DeleteFunc(s.byCanonical, func(_ string, id int64) bool { return id == user.ID })
+		delete(s.reactivations, user.ID)
+		delete(s.activations, user.ID)
+		delete(s.userApps, user.ID)
+		delete(s.roles, user.ID)
+		delete(s.unitRoles, user.ID)
+		s.deleted[user.ID] = true
+
+		s.identities = slices.DeleteFunc(s.identities, func(identity models.LinkedIdentity) bool {
+			return identity.UserID == user.ID
+		})
+		for id, authz := range s.authorizations {
+			if authz.UserID == user.ID && authz.UsedAt.IsZero() {
+				delete(s.authorizations, id)
+			}
+		}
+		for hash, challenge := range s.challenges {
+			if challenge.UserID == user.ID {
+				delete(s.challenges, hash)
+			}
+		}
+		ids = append(ids, user.ID)
+	}
+
+	return ids, nil
+}
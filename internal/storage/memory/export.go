@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// exportPageSize is the number of users Users copies per lock.
+const exportPageSize = 500
+
+// Users iterates over the users matching filter in ID order. The lock is
+// held for one page at a time, so the storage stays writable during a long
+// export. A failure is yielded once and ends the iteration.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter) iter.Seq2[models.UserRecord, error] {
+	return func(yield func(models.UserRecord, error) bool) {
+		afterID := filter.AfterID
+		for {
+			page, err := s.usersPage(ctx, afterID, filter)
+			if err != nil {
+				yield(models.UserRecord{}, err)
+				return
+			}
+
+			for _, user := range page {
+				if !yield(user, nil) {
+					return
+				}
+			}
+			if len(page) < exportPageSize {
+				return
+			}
+			afterID = page[len(page)-1].ID
+		}
+	}
+}
+
+func (s *Storage) usersPage(ctx context.Context, afterID int64, filter models.UserFilter) ([]models.UserRecord, error) {
+	const op = "storage.memory.Users"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Same precision as the sqlite column.
+	updatedSince := time.UnixMilli(filter.UpdatedSince.UnixMilli())
+	last := s.nextID
+	if filter.UpToID != 0 {
+		last = min(last, filter.UpToID)
+	}
+
+	// IDs are assigned sequentially and never reused.
+	page := make([]models.UserRecord, 0, exportPageSize)
+	for id := afterID + 1; id <= last && len(page) < exportPageSize; id++ {
+		user, ok := s.users[id]
+		if !ok || user.UpdatedAt.Before(updatedSince) {
+			continue
+		}
+		page = append(page, models.UserRecord{
+			ID:         user.ID,
+			Email:      user.Email,
+			FirstName:  user.FirstName,
+			LastName:   user.LastName,
+			MiddleName: user.MiddleName,
+			CreatedAt:  user.CreatedAt,
+			UpdatedAt:  user.UpdatedAt,
+		})
+	}
+
+	return page, nil
+}
+
+// LastUserID returns the ID of the newest user, zero if there is none.
+func (s *Storage) LastUserID(ctx context.Context) (int64, error) {
+	const op = "storage.memory.LastUserID"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nextID, nil
+}
@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveInvite stores a new invite and returns its ID.
+func (s *Storage) SaveInvite(ctx context.Context, invite models.Invite) (int64, error) {
+	const op = "storage.memory.SaveInvite"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.invites {
+		if other.CodeHash == invite.CodeHash {
+			return 0, fmt.Errorf("%s: invite with this code already exists", op)
+		}
+	}
+
+	s.nextInviteID++
+	invite.ID = s.nextInviteID
+	// Same precision as the sqlite columns.
+	if !invite.ExpiresAt.IsZero() {
+		invite.ExpiresAt = time.UnixMilli(invite.ExpiresAt.UnixMilli())
+	}
+	invite.CreatedAt = time.UnixMilli(invite.CreatedAt.UnixMilli())
+	s.invites[invite.ID] = invite
+
+	return invite.ID, nil
+}
+
+// Invite returns the invite stored under codeHash.
+func (s *Storage) Invite(ctx context.Context, codeHash string) (models.Invite, error) {
+	const op = "storage.memory.Invite"
+
+	if err := ctx.Err(); err != nil {
+		return models.Invite{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, invite := range s.invites {
+		if invite.CodeHash == codeHash {
+			return invite, nil
+		}
+	}
+
+	return models.Invite{}, errs.Wrap(op, errs.ErrInviteNotFound)
+}
+
+// SaveInvitedUser saves the user and spends one use of the invite at
+// once.
+//
+// If the invite has no uses left, returns errs.ErrInviteUsedUp.
+func (s *Storage) SaveInvitedUser(
+	ctx context.Context,
+	inviteID int64,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+	usedAt time.Time,
+) (int64, error) {
+	const op = "storage.memory.SaveInvitedUser"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invite, ok := s.invites[inviteID]
+	if !ok {
+		return 0, errs.Wrap(op, errs.ErrInviteNotFound)
+	}
+	if invite.UsesRemaining <= 0 {
+		return 0, errs.Wrap(op, errs.ErrInviteUsedUp)
+	}
+
+	id, err := s.saveAppUser(appID, email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	invite.UsesRemaining--
+	s.invites[inviteID] = invite
+	s.inviteUses = append(s.inviteUses, models.InviteUse{
+		InviteID: inviteID,
+		UserID:   id,
+		// Same precision as the sqlite column.
+		UsedAt: time.UnixMilli(usedAt.UnixMilli()),
+	})
+
+	return id, nil
+}
+
+// InviteUses returns who registered with the invite, oldest first.
+func (s *Storage) InviteUses(ctx context.Context, inviteID int64) ([]models.InviteUse, error) {
+	const op = "storage.memory.InviteUses"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var uses []models.InviteUse
+	for _, use := range s.inviteUses {
+		if use.InviteID == inviteID {
+			uses = append(uses, use)
+		}
+	}
+
+	return uses, nil
+}
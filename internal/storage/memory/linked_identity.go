@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// LinkIdentity links the provider account of identity to its user.
+//
+// If the user does not exist, returns errs.ErrUserNotFound.
+// If the provider account, or another account of the provider, is already
+// linked, returns errs.ErrIdentityLinked.
+func (s *Storage) LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error {
+	const op = "storage.memory.LinkIdentity"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[identity.UserID]; !ok {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+	for _, other := range s.identities {
+		if other.Provider != identity.Provider {
+			continue
+		}
+		if other.Subject == identity.Subject || other.UserID == identity.UserID {
+			return errs.Wrap(op, errs.ErrIdentityLinked)
+		}
+	}
+
+	// Same precision as the sqlite column.
+	identity.LinkedAt = time.UnixMilli(identity.LinkedAt.UnixMilli())
+	s.identities = append(s.identities, identity)
+
+	return nil
+}
+
+// LinkedIdentities returns the provider accounts linked to the user,
+// oldest first.
+func (s *Storage) LinkedIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error) {
+	const op = "storage.memory.LinkedIdentities"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var identities []models.LinkedIdentity
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	slices.SortFunc(identities, func(a, b models.LinkedIdentity) int {
+		return cmp.Or(a.LinkedAt.Compare(b.LinkedAt), strings.Compare(a.Provider, b.Provider))
+	})
+
+	return identities, nil
+}
+
+// UnlinkIdentity unlinks the account of provider from the user.
+//
+// If no account of provider is linked, returns errs.ErrIdentityNotFound.
+// If it is the last login method of the user, returns
+// errs.ErrLastLoginMethod.
+func (s *Storage) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	const op = "storage.memory.UnlinkIdentity"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.identities, func(identity models.LinkedIdentity) bool {
+		return identity.UserID == userID && identity.Provider == provider
+	})
+	if i < 0 {
+		return errs.Wrap(op, errs.ErrIdentityNotFound)
+	}
+
+	linked := 0
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			linked++
+		}
+	}
+	if len(s.users[userID].PassHash) == 0 && linked == 1 {
+		return errs.Wrap(op, errs.ErrLastLoginMethod)
+	}
+
+	s.identities = slices.Delete(s.identities, i, i+1)
+
+	return nil
+}
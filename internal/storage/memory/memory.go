@@ -0,0 +1,358 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// Storage is an in-memory storage. It is safe for concurrent use and is
+// meant for tests and local experiments: nothing survives a restart.
+type Storage struct {
+	mu      sync.RWMutex
+	nextID  int64
+	users   map[int64]models.User
+	byEmail map[string]int64
+	roles   map[int64]string
+	apps    map[int32]models.App
+
+	redirectURIs   map[int32]map[string]bool
+	authorizations map[string]models.Authorization
+	signingKeys    []models.SigningKey
+	logins         []models.LoginAttempt
+	events         []models.Event
+	webhooks       map[int64]models.Webhook
+	nextWebhookID  int64
+	invites        map[int64]models.Invite
+	inviteUses     []models.InviteUse
+	nextInviteID   int64
+	identities     []models.LinkedIdentity
+	challenges     map[string]models.Challenge
+	// permissions holds the permission names attached to every role.
+	permissions map[rolePermissionKey]map[string]bool
+	// reactivations holds the reactivation token hashes of the users
+	// scheduled for deletion, deleted those already anonymized.
+	reactivations map[int64]string
+	deleted       map[int64]bool
+	// activations holds the activation tokens of the users pending
+	// activation that have one.
+	activations map[int64]activation
+	// userApps holds the app each user registered through, if any.
+	userApps map[int64]int32
+	appUsage map[appUsageKey]models.AppUsage
+	// byCanonical holds the users saved with a canonical email.
+	byCanonical map[string]int64
+	orgUnits    map[int64]models.OrgUnit
+	nextUnitID  int64
+	// unitRoles holds the enrollments of every user in org units.
+	unitRoles map[int64][]unitRole
+	// tokenIssuances are keyed by fingerprint.
+	tokenIssuances map[string]models.TokenIssuance
+	// revokedTokens holds the expiry of the tokens revoked, by jti.
+	revokedTokens map[string]time.Time
+}
+
+// New creates a new empty instance of in-memory storage.
+func New() *Storage {
+	return &Storage{
+		users:   make(map[int64]models.User),
+		byEmail: make(map[string]int64),
+		roles:   make(map[int64]string),
+		apps:    make(map[int32]models.App),
+
+		redirectURIs:   make(map[int32]map[string]bool),
+		authorizations: make(map[string]models.Authorization),
+		webhooks:       make(map[int64]models.Webhook),
+		invites:        make(map[int64]models.Invite),
+		challenges:     make(map[string]models.Challenge),
+		permissions:    make(map[rolePermissionKey]map[string]bool),
+		reactivations:  make(map[int64]string),
+		deleted:        make(map[int64]bool),
+		activations:    make(map[int64]activation),
+		userApps:       make(map[int64]int32),
+		appUsage:       make(map[appUsageKey]models.AppUsage),
+		byCanonical:    make(map[string]int64),
+		orgUnits:       make(map[int64]models.OrgUnit),
+		unitRoles:      make(map[int64][]unitRole),
+		tokenIssuances: make(map[string]models.TokenIssuance),
+		revokedTokens:  make(map[string]time.Time),
+	}
+}
+
+func (s *Storage) SaveUser(
+	ctx context.Context,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	const op = "storage.memory.SaveUser"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.saveUser(email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// saveUser adds a user. s.mu must be held.
+func (s *Storage) saveUser(
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	if _, ok := s.byEmail[email]; ok {
+		return 0, errs.ErrUserExists
+	}
+
+	// Same precision as the sqlite column.
+	now := time.UnixMilli(time.Now().UnixMilli())
+
+	s.nextID++
+	s.users[s.nextID] = models.User{
+		ID:         s.nextID,
+		Email:      email,
+		PassHash:   append([]byte(nil), passHash...),
+		FirstName:  firstName,
+		LastName:   lastName,
+		MiddleName: middleName,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	s.byEmail[email] = s.nextID
+
+	return s.nextID, nil
+}
+
+// User return user by email
+func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
+	const op = "storage.memory.User"
+
+	if err := ctx.Err(); err != nil {
+		return models.User{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byEmail[email]
+	if !ok {
+		return models.User{}, errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return s.users[id], nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.memory.UserByID"
+
+	if err := ctx.Err(); err != nil {
+		return models.User{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return models.User{}, errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return user, nil
+}
+
+// SetUserTokenTTL sets the token lifetime override of the user. Zero
+// removes the override.
+func (s *Storage) SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error {
+	const op = "storage.memory.SetUserTokenTTL"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+	// Same precision as the sqlite column.
+	user.TokenTTL = ttl.Truncate(time.Second)
+	user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+	s.users[userID] = user
+
+	return nil
+}
+
+// AcceptTerms records that the user accepted version of the terms of
+// service at acceptedAt.
+func (s *Storage) AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error {
+	const op = "storage.memory.AcceptTerms"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+	user.TermsVersion = version
+	// Same precision as the sqlite column.
+	user.TermsAcceptedAt = time.UnixMilli(acceptedAt.UnixMilli())
+	user.UpdatedAt = time.UnixMilli(time.Now().UnixMilli())
+	s.users[userID] = user
+
+	return nil
+}
+
+// UserExists returns true if user exists
+func (s *Storage) UserExists(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.memory.UserExists"
+
+	if err := ctx.Err(); err != nil {
+		return false, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[userID]
+
+	return ok, nil
+}
+
+// UserRole returns role of the user
+func (s *Storage) UserRole(ctx context.Context, userID int64) (string, error) {
+	const op = "storage.memory.UserRole"
+
+	if err := ctx.Err(); err != nil {
+		return "", errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return "", errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	role, ok := s.roles[userID]
+	if !ok {
+		return "", errs.Wrap(op, errs.ErrRoleNotFound)
+	}
+
+	return role, nil
+}
+
+func (s *Storage) App(ctx context.Context, appID int32) (models.App, error) {
+	const op = "storage.memory.App"
+
+	if err := ctx.Err(); err != nil {
+		return models.App{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.App{}, errs.Wrap(op, errs.ErrAppNotFound)
+	}
+
+	return app, nil
+}
+
+// AppByName returns the app registered under name.
+func (s *Storage) AppByName(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.memory.AppByName"
+
+	if err := ctx.Err(); err != nil {
+		return models.App{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, app := range s.apps {
+		if app.Name == name {
+			return app, nil
+		}
+	}
+
+	return models.App{}, errs.Wrap(op, errs.ErrAppNotFound)
+}
+
+// SaveApp adds or replaces an app.
+func (s *Storage) SaveApp(app models.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.apps[app.ID] = app
+}
+
+// SetUserRole enrolls the user with the given role.
+func (s *Storage) SetUserRole(userID int64, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roles[userID] = role
+}
+
+// UsersExist reports for every given ID whether the user exists.
+func (s *Storage) UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	const op = "storage.memory.UsersExist"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		_, res[id] = s.users[id]
+	}
+
+	return res, nil
+}
+
+// UserRoles returns the role of every given user. Users that do not exist
+// or have no enrollment get an empty role.
+func (s *Storage) UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	const op = "storage.memory.UserRoles"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	res := make(map[int64]string, len(userIDs))
+	for _, id := range userIDs {
+		res[id] = s.roles[id]
+	}
+
+	return res, nil
+}
@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"sso/internal/domain/models"
+	"sso/internal/storage/storagetest"
+)
+
+type seededStorage struct {
+	*Storage
+}
+
+func (s seededStorage) SeedApp(_ context.Context, app models.App) error {
+	s.SaveApp(app)
+	return nil
+}
+
+func (s seededStorage) SeedUserRole(_ context.Context, userID int64, role string) error {
+	s.SetUserRole(userID, role)
+	return nil
+}
+
+func (s seededStorage) SeedUnitRole(_ context.Context, userID, unitID int64, role string) error {
+	s.SetUnitRole(userID, unitID, role)
+	return nil
+}
+
+func (s seededStorage) SeedRedirectURI(_ context.Context, appID int32, uri string) error {
+	s.AddRedirectURI(appID, uri)
+	return nil
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformanceTests(t, func() storagetest.Storage {
+		return seededStorage{New()}
+	})
+}
@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+type unitRole struct {
+	unitID int64
+	role   string
+}
+
+// SaveOrgUnit saves the unit under its parent, a root unit if ParentID is
+// zero, and returns its ID. If the parent does not exist, returns
+// errs.ErrOrgUnitNotFound; if it already has a unit of the name,
+// errs.ErrOrgUnitExists.
+func (s *Storage) SaveOrgUnit(ctx context.Context, unit models.OrgUnit) (int64, error) {
+	const op = "storage.memory.SaveOrgUnit"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgUnits[unit.ParentID]; unit.ParentID != 0 && !ok {
+		return 0, errs.Wrap(op, errs.ErrOrgUnitNotFound)
+	}
+	if s.unitNameTaken(unit.ParentID, unit.Name) {
+		return 0, errs.Wrap(op, errs.ErrOrgUnitExists)
+	}
+
+	s.nextUnitID++
+	unit.ID = s.nextUnitID
+	unit.Children = nil
+	s.orgUnits[unit.ID] = unit
+
+	return unit.ID, nil
+}
+
+// MoveOrgUnit moves the unit under parentID, to the root if zero, with
+// everything below it. If either unit does not exist, returns
+// errs.ErrOrgUnitNotFound; if parentID is the unit or below it,
+// errs.ErrOrgUnitCycle; if the parent already has a unit of the name,
+// errs.ErrOrgUnitExists.
+func (s *Storage) MoveOrgUnit(ctx context.Context, id, parentID int64) error {
+	const op = "storage.memory.MoveOrgUnit"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unit, ok := s.orgUnits[id]
+	if !ok {
+		return errs.Wrap(op, errs.ErrOrgUnitNotFound)
+	}
+	if _, ok := s.orgUnits[parentID]; parentID != 0 && !ok {
+		return errs.Wrap(op, errs.ErrOrgUnitNotFound)
+	}
+	if slices.Contains(s.ancestors(parentID), id) {
+		return errs.Wrap(op, errs.ErrOrgUnitCycle)
+	}
+	if unit.ParentID == parentID {
+		return nil
+	}
+	if s.unitNameTaken(parentID, unit.Name) {
+		return errs.Wrap(op, errs.ErrOrgUnitExists)
+	}
+
+	unit.ParentID = parentID
+	s.orgUnits[id] = unit
+
+	return nil
+}
+
+// OrgUnits returns every unit, by ID, without their children.
+func (s *Storage) OrgUnits(ctx context.Context) ([]models.OrgUnit, error) {
+	const op = "storage.memory.OrgUnits"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	units := make([]models.OrgUnit, 0, len(s.orgUnits))
+	for _, unit := range s.orgUnits {
+		units = append(units, unit)
+	}
+	slices.SortFunc(units, func(a, b models.OrgUnit) int { return cmp.Compare(a.ID, b.ID) })
+
+	return units, nil
+}
+
+// UnitRoles returns the roles of the user in the unit, sorted: those given
+// in the unit or any unit above it, and the role given with SetUserRole,
+// which holds everywhere. If the unit does not exist, returns
+// errs.ErrOrgUnitNotFound.
+func (s *Storage) UnitRoles(ctx context.Context, userID, unitID int64) ([]string, error) {
+	const op = "storage.memory.UnitRoles"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.orgUnits[unitID]; !ok {
+		return nil, errs.Wrap(op, errs.ErrOrgUnitNotFound)
+	}
+
+	roles := []string{}
+	if role, ok := s.roles[userID]; ok {
+		roles = append(roles, role)
+	}
+	ancestors := s.ancestors(unitID)
+	for _, r := range s.unitRoles[userID] {
+		if slices.Contains(ancestors, r.unitID) {
+			roles = append(roles, r.role)
+		}
+	}
+	slices.Sort(roles)
+
+	return slices.Compact(roles), nil
+}
+
+// SetUnitRole enrolls the user with the given role in the unit.
+func (s *Storage) SetUnitRole(userID, unitID int64, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unitRoles[userID] = append(s.unitRoles[userID], unitRole{unitID: unitID, role: role})
+}
+
+// ancestors returns the unit of id and every unit above it.
+func (s *Storage) ancestors(id int64) []int64 {
+	var res []int64
+	for id != 0 {
+		res = append(res, id)
+		id = s.orgUnits[id].ParentID
+	}
+
+	return res
+}
+
+func (s *Storage) unitNameTaken(parentID int64, name string) bool {
+	for _, unit := range s.orgUnits {
+		if unit.ParentID == parentID && unit.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveAppUser saves a user registered through the app.
+//
+// If the app does not exist, returns errs.ErrAppNotFound. If its quota is
+// reached, returns errs.ErrQuotaExceeded.
+func (s *Storage) SaveAppUser(
+	ctx context.Context,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	const op = "storage.memory.SaveAppUser"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.saveAppUser(appID, email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// saveAppUser adds a user registered through appID, none if zero. s.mu
+// must be held.
+func (s *Storage) saveAppUser(
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	if appID != 0 {
+		app, ok := s.apps[appID]
+		if !ok {
+			return 0, errs.ErrAppNotFound
+		}
+		if app.MaxUsers > 0 && s.appUsers(appID) >= app.MaxUsers {
+			return 0, &errs.Error{
+				Code:     errs.QuotaExceeded,
+				Message:  errs.ErrQuotaExceeded.Message,
+				Metadata: map[string]string{"max_users": strconv.Itoa(app.MaxUsers)},
+			}
+		}
+	}
+
+	id, err := s.saveUser(email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, err
+	}
+	if appID != 0 {
+		s.userApps[id] = appID
+	}
+
+	return id, nil
+}
+
+// appUsers counts the users registered through the app. s.mu must be
+// held.
+func (s *Storage) appUsers(appID int32) int {
+	n := 0
+	for _, id := range s.userApps {
+		if id == appID {
+			n++
+		}
+	}
+
+	return n
+}
+
+// SetAppMaxUsers sets the user quota of the app, zero to clear it.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error {
+	const op = "storage.memory.SetAppMaxUsers"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return errs.Wrap(op, errs.ErrAppNotFound)
+	}
+	app.MaxUsers = max(maxUsers, 0)
+	s.apps[appID] = app
+
+	return nil
+}
+
+// AppQuota returns the user quota of the app and the users registered
+// through it.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) AppQuota(ctx context.Context, appID int32) (models.AppQuota, error) {
+	const op = "storage.memory.AppQuota"
+
+	if err := ctx.Err(); err != nil {
+		return models.AppQuota{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.AppQuota{}, errs.Wrap(op, errs.ErrAppNotFound)
+	}
+
+	return models.AppQuota{AppID: appID, MaxUsers: app.MaxUsers, Users: s.appUsers(appID)}, nil
+}
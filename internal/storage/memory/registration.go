@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveRegistration saves the user of reg together with its invite use,
+// terms acceptance and outbox event, all or nothing, and returns its ID.
+//
+// If the user exists, returns errs.ErrUserExists. If the invite does not
+// exist or has no uses left, returns errs.ErrInviteNotFound or
+// errs.ErrInviteUsedUp. If the app does not exist, returns
+// errs.ErrAppNotFound. If its quota is reached, returns
+// errs.ErrQuotaExceeded.
+func (s *Storage) SaveRegistration(ctx context.Context, reg models.Registration) (int64, error) {
+	const op = "storage.memory.SaveRegistration"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var invite models.Invite
+	if reg.InviteID != 0 {
+		var ok bool
+		if invite, ok = s.invites[reg.InviteID]; !ok {
+			return 0, errs.Wrap(op, errs.ErrInviteNotFound)
+		}
+		if invite.UsesRemaining <= 0 {
+			return 0, errs.Wrap(op, errs.ErrInviteUsedUp)
+		}
+	}
+
+	// An exact duplicate is refused by saveAppUser as such.
+	if _, taken := s.byCanonical[reg.CanonicalEmail]; taken && reg.CanonicalEmail != "" {
+		if _, exact := s.byEmail[reg.Email]; !exact {
+			return 0, errs.Wrap(op, errs.ErrCanonicalEmailExists)
+		}
+	}
+
+	// Nothing is changed before the user is saved, so a refusal leaves
+	// the storage as it was.
+	id, err := s.saveAppUser(reg.AppID, reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	if reg.CanonicalEmail != "" {
+		s.byCanonical[reg.CanonicalEmail] = id
+	}
+
+	// Same precision as the sqlite columns.
+	registeredAt := time.UnixMilli(reg.RegisteredAt.UnixMilli())
+
+	if reg.InviteID != 0 {
+		invite.UsesRemaining--
+		s.invites[reg.InviteID] = invite
+		s.inviteUses = append(s.inviteUses, models.InviteUse{
+			InviteID: reg.InviteID,
+			UserID:   id,
+			UsedAt:   registeredAt,
+		})
+	}
+	if reg.TermsVersion != "" {
+		user := s.users[id]
+		user.TermsVersion = reg.TermsVersion
+		user.TermsAcceptedAt = registeredAt
+		s.users[id] = user
+	}
+	if event := reg.Event; event.Type != "" {
+		event.ID = int64(len(s.events)) + 1
+		event.UserID = id
+		event.Payload = append([]byte(nil), event.Payload...)
+		event.CreatedAt = time.UnixMilli(event.CreatedAt.UnixMilli())
+		s.events = append(s.events, event)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/errs"
+)
+
+// RevokeToken adds the jti of a token valid until expiresAt to the
+// denylist. Revoking it again changes nothing.
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.memory.RevokeToken"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedTokens[jti]; !ok {
+		s.revokedTokens[jti] = time.UnixMilli(expiresAt.UnixMilli())
+	}
+
+	return nil
+}
+
+// TokenRevoked reports whether the jti is on the denylist.
+func (s *Storage) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.memory.TokenRevoked"
+
+	if err := ctx.Err(); err != nil {
+		return false, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revokedTokens[jti]
+
+	return ok, nil
+}
+
+// PurgeRevokedTokens deletes the revocations of the tokens expired before
+// now and returns how many it deleted.
+func (s *Storage) PurgeRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.memory.PurgeRevokedTokens"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for jti, expiresAt := range s.revokedTokens {
+		if expiresAt.UnixMilli() < now.UnixMilli() {
+			delete(s.revokedTokens, jti)
+			n++
+		}
+	}
+
+	return n, nil
+}
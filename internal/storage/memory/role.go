@@ -0,0 +1,60 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// AssignRoles makes role the role of every given user, in place of the
+// one they had, all at once. It has to be given to some user already: if
+// not, returns errs.ErrRoleNotFound and assigns nothing.
+func (s *Storage) AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error) {
+	const op = "storage.memory.AssignRoles"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.roleKnown(role) {
+		return nil, errs.Wrap(op, errs.ErrRoleNotFound)
+	}
+
+	res := make(map[int64]models.RoleOutcome, len(userIDs))
+	for _, id := range userIDs {
+		switch _, ok := s.users[id]; {
+		case !ok:
+			res[id] = models.RoleUserNotFound
+		case s.roles[id] == role:
+			res[id] = models.RoleAlreadyHad
+		default:
+			s.roles[id] = role
+			res[id] = models.RoleAssigned
+		}
+	}
+
+	return res, nil
+}
+
+// roleKnown reports whether role is given to some user, globally or in
+// an org unit. Roles only exist through the users given them. s.mu must
+// be held.
+func (s *Storage) roleKnown(role string) bool {
+	for _, r := range s.roles {
+		if r == role {
+			return true
+		}
+	}
+	for _, roles := range s.unitRoles {
+		if slices.ContainsFunc(roles, func(r unitRole) bool { return r.role == role }) {
+			return true
+		}
+	}
+
+	return false
+}
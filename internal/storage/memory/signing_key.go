@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"slices"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveSigningKey stores a new signing key.
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.memory.SaveSigningKey"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.PrivateKey = append([]byte(nil), key.PrivateKey...)
+	s.signingKeys = append(s.signingKeys, key)
+
+	return nil
+}
+
+// SigningKeys returns at most limit newest signing keys, newest first.
+func (s *Storage) SigningKeys(ctx context.Context, limit int) ([]models.SigningKey, error) {
+	const op = "storage.memory.SigningKeys"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := slices.Clone(s.signingKeys)
+	// Stable, so keys created in the same instant keep the newest-saved
+	// first order after the reversal.
+	slices.Reverse(keys)
+	slices.SortStableFunc(keys, func(a, b models.SigningKey) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return keys[:min(limit, len(keys))], nil
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// RecordLogin appends attempt to the login history.
+func (s *Storage) RecordLogin(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.memory.RecordLogin"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Same precision as the sqlite column.
+	attempt.At = time.UnixMilli(attempt.At.UnixMilli())
+	s.logins = append(s.logins, attempt)
+
+	return nil
+}
+
+// UsageStats returns usage numbers over [from, to).
+func (s *Storage) UsageStats(ctx context.Context, from, to time.Time) (models.Stats, error) {
+	const op = "storage.memory.UsageStats"
+
+	if err := ctx.Err(); err != nil {
+		return models.Stats{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	in := func(t, from time.Time) bool { return !t.Before(from) && t.Before(to) }
+	day, week := to.Add(-24*time.Hour), to.Add(-7*24*time.Hour)
+
+	stats := models.Stats{From: from, To: to, TotalUsers: int64(len(s.users))}
+	for _, user := range s.users {
+		if in(user.CreatedAt, from) {
+			stats.Registrations++
+		}
+	}
+
+	daily := make(map[int64]bool)
+	weekly := make(map[int64]bool)
+	for _, attempt := range s.logins {
+		if in(attempt.At, from) {
+			if attempt.Success {
+				stats.SuccessfulLogins++
+			} else {
+				stats.FailedLogins++
+			}
+		}
+		if !attempt.Success {
+			continue
+		}
+		if in(attempt.At, day) {
+			daily[attempt.UserID] = true
+		}
+		if in(attempt.At, week) {
+			weekly[attempt.UserID] = true
+		}
+	}
+	stats.DailyActive = int64(len(daily))
+	stats.WeeklyActive = int64(len(weekly))
+
+	return stats, nil
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveTokenIssuance records the issuance of a token.
+func (s *Storage) SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error {
+	const op = "storage.memory.SaveTokenIssuance"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[issuance.UserID]; !ok {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+	if _, ok := s.tokenIssuances[issuance.Fingerprint]; ok {
+		return fmt.Errorf("%s: issuance already recorded", op)
+	}
+	issuance.IssuedAt = time.UnixMilli(issuance.IssuedAt.UnixMilli())
+	issuance.ExpiresAt = time.UnixMilli(issuance.ExpiresAt.UnixMilli())
+	issuance.RetainUntil = time.UnixMilli(issuance.RetainUntil.UnixMilli())
+	s.tokenIssuances[issuance.Fingerprint] = issuance
+
+	return nil
+}
+
+// TokenIssuance returns the issuance of the token with the given
+// fingerprint. If there is none, returns errs.ErrTokenNotIssued.
+func (s *Storage) TokenIssuance(ctx context.Context, fingerprint string) (models.TokenIssuance, error) {
+	const op = "storage.memory.TokenIssuance"
+
+	if err := ctx.Err(); err != nil {
+		return models.TokenIssuance{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	issuance, ok := s.tokenIssuances[fingerprint]
+	if !ok {
+		return models.TokenIssuance{}, errs.Wrap(op, errs.ErrTokenNotIssued)
+	}
+
+	return issuance, nil
+}
+
+// PurgeTokenIssuances deletes the issuances retained until before now and
+// returns how many it deleted.
+func (s *Storage) PurgeTokenIssuances(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.memory.PurgeTokenIssuances"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for fingerprint, issuance := range s.tokenIssuances {
+		if issuance.RetainUntil.UnixMilli() < now.UnixMilli() {
+			delete(s.tokenIssuances, fingerprint)
+			n++
+		}
+	}
+
+	return n, nil
+}
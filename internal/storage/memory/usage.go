@@ -0,0 +1,93 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+type appUsageKey struct {
+	appID int32
+	day   int64
+}
+
+// AddAppUsage adds the counts of usage to those of their apps and days,
+// all or none. If an app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) AddAppUsage(ctx context.Context, usage []models.AppUsage) error {
+	const op = "storage.memory.AddAppUsage"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range usage {
+		if _, ok := s.apps[u.AppID]; !ok {
+			return errs.Wrap(op, errs.ErrAppNotFound)
+		}
+	}
+	for _, u := range usage {
+		key := appUsageKey{appID: u.AppID, day: u.Day.UnixMilli()}
+		total, ok := s.appUsage[key]
+		if !ok {
+			total = models.AppUsage{AppID: u.AppID, Day: time.UnixMilli(key.day).UTC()}
+		}
+		total.Logins += u.Logins
+		total.TokensIssued += u.TokensIssued
+		total.Refreshes += u.Refreshes
+		s.appUsage[key] = total
+	}
+
+	return nil
+}
+
+// AppUsage returns the usage of the app on the days starting in
+// [from, to), oldest first. Days without any are left out.
+func (s *Storage) AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error) {
+	const op = "storage.memory.AppUsage"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var usage []models.AppUsage
+	for key, u := range s.appUsage {
+		if key.appID == appID && key.day >= from.UnixMilli() && key.day < to.UnixMilli() {
+			usage = append(usage, u)
+		}
+	}
+	slices.SortFunc(usage, func(a, b models.AppUsage) int { return a.Day.Compare(b.Day) })
+
+	return usage, nil
+}
+
+// TrimAppUsage deletes the usage of the days starting before before and
+// returns how many app days it deleted.
+func (s *Storage) TrimAppUsage(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.memory.TrimAppUsage"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for key := range s.appUsage {
+		if key.day < before.UnixMilli() {
+			delete(s.appUsage, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveEvent appends event to the outbox and returns its ID.
+func (s *Storage) SaveEvent(ctx context.Context, event models.Event) (int64, error) {
+	const op = "storage.memory.SaveEvent"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = int64(len(s.events)) + 1
+	event.Payload = append([]byte(nil), event.Payload...)
+	// Same precision as the sqlite column.
+	event.CreatedAt = time.UnixMilli(event.CreatedAt.UnixMilli())
+	s.events = append(s.events, event)
+
+	return event.ID, nil
+}
+
+// Events returns at most limit events following afterID, oldest first.
+func (s *Storage) Events(ctx context.Context, afterID int64, limit int) ([]models.Event, error) {
+	const op = "storage.memory.Events"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Event IDs are positions in the slice, starting at one.
+	from := min(max(afterID, 0), int64(len(s.events)))
+	to := min(from+int64(limit), int64(len(s.events)))
+
+	return slices.Clone(s.events[from:to]), nil
+}
+
+// LastEventID returns the ID of the newest event, zero if there is none.
+func (s *Storage) LastEventID(ctx context.Context) (int64, error) {
+	const op = "storage.memory.LastEventID"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.events)), nil
+}
+
+// SaveWebhook stores a new webhook and returns its ID.
+func (s *Storage) SaveWebhook(ctx context.Context, webhook models.Webhook) (int64, error) {
+	const op = "storage.memory.SaveWebhook"
+
+	if err := ctx.Err(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextWebhookID++
+	webhook.ID = s.nextWebhookID
+	webhook.EventTypes = slices.Clone(webhook.EventTypes)
+	// Same precision as the sqlite columns.
+	webhook.CreatedAt = time.UnixMilli(webhook.CreatedAt.UnixMilli())
+	webhook.NextAttemptAt = truncateMilli(webhook.NextAttemptAt)
+	s.webhooks[webhook.ID] = webhook
+
+	return webhook.ID, nil
+}
+
+// Webhook returns the webhook with the given ID.
+func (s *Storage) Webhook(ctx context.Context, id int64) (models.Webhook, error) {
+	const op = "storage.memory.Webhook"
+
+	if err := ctx.Err(); err != nil {
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return models.Webhook{}, errs.Wrap(op, errs.ErrWebhookNotFound)
+	}
+	webhook.EventTypes = slices.Clone(webhook.EventTypes)
+
+	return webhook, nil
+}
+
+// Webhooks returns the webhooks of the app, or of every app when appID is
+// zero, oldest first.
+func (s *Storage) Webhooks(ctx context.Context, appID int32) ([]models.Webhook, error) {
+	const op = "storage.memory.Webhooks"
+
+	if err := ctx.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var webhooks []models.Webhook
+	for _, webhook := range s.webhooks {
+		if appID == 0 || webhook.AppID == appID {
+			webhook.EventTypes = slices.Clone(webhook.EventTypes)
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b models.Webhook) int { return int(a.ID - b.ID) })
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes the webhook with the given ID.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "storage.memory.DeleteWebhook"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return errs.Wrap(op, errs.ErrWebhookNotFound)
+	}
+	delete(s.webhooks, id)
+
+	return nil
+}
+
+// UpdateWebhookState stores the delivery progress of the webhook.
+func (s *Storage) UpdateWebhookState(ctx context.Context, id int64, state models.WebhookState) error {
+	const op = "storage.memory.UpdateWebhookState"
+
+	if err := ctx.Err(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return errs.Wrap(op, errs.ErrWebhookNotFound)
+	}
+	// Same precision as the sqlite column.
+	state.NextAttemptAt = truncateMilli(state.NextAttemptAt)
+	webhook.WebhookState = state
+	s.webhooks[id] = webhook
+
+	return nil
+}
+
+// truncateMilli truncates t to the precision of the sqlite columns but
+// keeps the zero time zero.
+func truncateMilli(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+
+	return time.UnixMilli(t.UnixMilli())
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"log/slog"
+	"time"
+
+	"sso/internal/lib/metrics"
+)
+
+// QueryObserver times storage operations. Every duration is recorded in a
+// per-operation histogram, and operations slower than the threshold are
+// logged as warnings.
+//
+// Only the operation name and the duration are reported: query parameters
+// carry emails and hashes and must never reach the logs.
+//
+// A nil *QueryObserver is valid and observes nothing.
+type QueryObserver struct {
+	log       *slog.Logger
+	threshold time.Duration
+	durations *metrics.HistogramVec
+	now       func() time.Time
+}
+
+// NewQueryObserver returns an observer logging operations slower than
+// threshold. A non-positive threshold disables slow-query logging. A nil
+// log logs to slog.Default().
+func NewQueryObserver(
+	log *slog.Logger,
+	threshold time.Duration,
+	durations *metrics.HistogramVec,
+) *QueryObserver {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	return &QueryObserver{
+		log:       log,
+		threshold: threshold,
+		durations: durations,
+		now:       time.Now,
+	}
+}
+
+// Observe starts timing op. The returned function must be called when
+// the operation finishes:
+//
+//	defer s.observer.Observe(op)()
+func (o *QueryObserver) Observe(op string) func() {
+	if o == nil {
+		return func() {}
+	}
+
+	start := o.now()
+
+	return func() {
+		elapsed := o.now().Sub(start)
+
+		if o.durations != nil {
+			o.durations.With(op).Observe(elapsed.Seconds())
+		}
+
+		if o.threshold > 0 && elapsed >= o.threshold {
+			o.log.Warn("slow storage query",
+				slog.String("op", op),
+				slog.Duration("duration", elapsed.Round(time.Millisecond)),
+			)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"sso/internal/lib/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestQueryObserver(t *testing.T) {
+	tests := []struct {
+		name     string
+		elapsed  time.Duration
+		wantWarn bool
+	}{
+		{name: "fast query", elapsed: 10 * time.Millisecond, wantWarn: false},
+		{name: "exactly at threshold", elapsed: 100 * time.Millisecond, wantWarn: true},
+		{name: "slow query", elapsed: 1234567 * time.Microsecond, wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&buf, nil))
+			durations := metrics.NewHistogramVec("storage_query_duration_seconds", "op", nil)
+			clock := &fakeClock{now: time.Unix(0, 0)}
+
+			o := NewQueryObserver(log, 100*time.Millisecond, durations)
+			o.now = clock.Now
+
+			done := o.Observe("storage.sqlite.User")
+			clock.now = clock.now.Add(tt.elapsed)
+			done()
+
+			snap := durations.Snapshot()["storage.sqlite.User"]
+			require.Equal(t, uint64(1), snap.Count)
+			assert.InDelta(t, tt.elapsed.Seconds(), snap.Sum, 1e-9)
+
+			if !tt.wantWarn {
+				assert.Empty(t, buf.String())
+				return
+			}
+			assert.Contains(t, buf.String(), "slow storage query")
+			assert.Contains(t, buf.String(), "op=storage.sqlite.User")
+			assert.Contains(t, buf.String(), "duration="+tt.elapsed.Round(time.Millisecond).String())
+		})
+	}
+}
+
+func TestQueryObserver_Nil(t *testing.T) {
+	var o *QueryObserver
+
+	assert.NotPanics(t, func() { o.Observe("storage.sqlite.User")() })
+
+	// Without a logger, slow queries go to the default one.
+	o = NewQueryObserver(nil, time.Nanosecond, nil)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	o.now = func() time.Time {
+		clock.now = clock.now.Add(time.Second)
+		return clock.now
+	}
+	assert.NotPanics(t, func() { o.Observe("storage.sqlite.User")() })
+}
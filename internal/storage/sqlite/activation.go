@@ -0,0 +1,139 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// PreRegisterUser saves a user without a password, pending activation
+// with the activation token stored as tokenHash until expiresAt.
+//
+// If the email is taken, returns errs.ErrUserExists.
+func (s *Storage) PreRegisterUser(
+	ctx context.Context,
+	email string,
+	firstName string,
+	lastName string,
+	middleName string,
+	tokenHash string,
+	expiresAt time.Time,
+) (int64, error) {
+	const op = "storage.sqlite.PreRegisterUser"
+
+	defer s.observer.Observe(op)()
+
+	now := time.Now().UnixMilli()
+	res, err := s.writer.ExecContext(ctx, `
+		INSERT INTO users (email, pass_hash, first_name, last_name, middle_name, created_at, updated_at,
+			activation_pending, activation_token_hash, activation_expires_at)
+		VALUES (?, X'', ?, ?, ?, ?, ?, 1, ?, ?)`,
+		email, firstName, lastName, optional(middleName), now, now, tokenHash, expiresAt.UnixMilli(),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, errs.Wrap(op, errs.ErrUserExists)
+		}
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// SetActivationToken replaces the activation token of a user pending
+// activation with the one stored as tokenHash until expiresAt. An empty
+// tokenHash revokes the token without issuing another.
+//
+// If the user does not exist, returns errs.ErrUserNotFound; if it is
+// activated already, errs.ErrAccountActivated.
+func (s *Storage) SetActivationToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	const op = "storage.sqlite.SetActivationToken"
+
+	defer s.observer.Observe(op)()
+
+	var hash sql.NullString
+	var expires sql.NullInt64
+	if tokenHash != "" {
+		hash = sql.NullString{String: tokenHash, Valid: true}
+		expires = sql.NullInt64{Int64: expiresAt.UnixMilli(), Valid: true}
+	}
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var pending bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT activation_pending FROM users WHERE id = ? AND deleted_at IS NULL", userID,
+	).Scan(&pending)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errs.Wrap(op, errs.ErrUserNotFound)
+		}
+
+		return errs.Wrap(op, err)
+	}
+	if !pending {
+		return errs.Wrap(op, errs.ErrAccountActivated)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE users SET activation_token_hash = ?, activation_expires_at = ?, updated_at = ?
+		WHERE id = ?`,
+		hash, expires, time.Now().UnixMilli(), userID,
+	); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// ActivateUser sets the password of the user whose activation token is
+// stored as tokenHash, unless it expired by now, and returns its ID. The
+// token is used up.
+//
+// If no user has the token or it expired, returns
+// errs.ErrInvalidActivationToken.
+func (s *Storage) ActivateUser(ctx context.Context, tokenHash string, passHash []byte, now time.Time) (int64, error) {
+	const op = "storage.sqlite.ActivateUser"
+
+	defer s.observer.Observe(op)()
+
+	passHash, version := storedHash(s.compat.HashVersion, passHash)
+
+	var id int64
+	err := s.writer.QueryRowContext(ctx, `
+		UPDATE users SET pass_hash = ?, hash_version = ?, activation_pending = 0, activation_token_hash = NULL,
+			activation_expires_at = NULL, updated_at = ?
+		WHERE activation_token_hash = ? AND activation_expires_at > ? AND activation_pending = 1
+		RETURNING id`,
+		passHash, version, time.Now().UnixMilli(), tokenHash, now.UnixMilli(),
+	).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errs.Wrap(op, errs.ErrInvalidActivationToken)
+		}
+
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,173 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// RedirectURIAllowed reports whether uri is in the allowlist of the app.
+func (s *Storage) RedirectURIAllowed(ctx context.Context, appID int32, uri string) (bool, error) {
+	const op = "storage.sqlite.RedirectURIAllowed"
+
+	defer s.observer.Observe(op)()
+
+	var allowed int
+	err := s.reader.QueryRowContext(ctx,
+		"SELECT 1 FROM app_redirect_uris WHERE app_id = ? AND uri = ?",
+		appID, uri,
+	).Scan(&allowed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(op, err)
+	}
+
+	return true, nil
+}
+
+// SaveAuthorization stores a new pending authorization.
+func (s *Storage) SaveAuthorization(ctx context.Context, authz models.Authorization) error {
+	const op = "storage.sqlite.SaveAuthorization"
+
+	defer s.observer.Observe(op)()
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO authorizations (id, app_id, redirect_uri, state, expires_at) VALUES (?, ?, ?, ?, ?)",
+		authz.ID, authz.AppID, authz.RedirectURI, authz.State, authz.ExpiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Authorization returns the authorization with the given ID.
+func (s *Storage) Authorization(ctx context.Context, id string) (models.Authorization, error) {
+	const op = "storage.sqlite.Authorization"
+
+	defer s.observer.Observe(op)()
+
+	authz, err := scanAuthorization(s.reader.QueryRowContext(ctx,
+		"SELECT "+authorizationColumns+" FROM authorizations WHERE id = ?", id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, errs.Wrap(op, errs.ErrAuthorizationNotFound)
+		}
+
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+
+	return authz, nil
+}
+
+// IssueAuthorizationCode binds the user and the code to a pending
+// authorization. A code can be issued only once per authorization.
+func (s *Storage) IssueAuthorizationCode(
+	ctx context.Context,
+	id string,
+	userID int64,
+	codeHash string,
+	expiresAt time.Time,
+) error {
+	const op = "storage.sqlite.IssueAuthorizationCode"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx,
+		"UPDATE authorizations SET user_id = ?, code_hash = ?, expires_at = ? WHERE id = ? AND code_hash IS NULL",
+		userID, codeHash, expiresAt.UnixMilli(), id,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n == 0 {
+		return errs.Wrap(op, errs.ErrAuthorizationNotFound)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks the code as used and returns its
+// authorization. If the code was already used, the authorization is
+// returned together with errs.ErrAuthorizationUsed.
+func (s *Storage) ConsumeAuthorizationCode(
+	ctx context.Context,
+	codeHash string,
+	usedAt time.Time,
+) (models.Authorization, error) {
+	const op = "storage.sqlite.ConsumeAuthorizationCode"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	authz, err := scanAuthorization(tx.QueryRowContext(ctx,
+		"SELECT "+authorizationColumns+" FROM authorizations WHERE code_hash = ?", codeHash,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Authorization{}, errs.Wrap(op, errs.ErrAuthorizationNotFound)
+		}
+
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+	if !authz.UsedAt.IsZero() {
+		return authz, errs.Wrap(op, errs.ErrAuthorizationUsed)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE authorizations SET used_at = ? WHERE id = ?", usedAt.UnixMilli(), authz.ID,
+	); err != nil {
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Authorization{}, errs.Wrap(op, err)
+	}
+
+	authz.UsedAt = time.UnixMilli(usedAt.UnixMilli())
+
+	return authz, nil
+}
+
+const authorizationColumns = "id, app_id, redirect_uri, state, user_id, code_hash, expires_at, used_at"
+
+func scanAuthorization(row *sql.Row) (models.Authorization, error) {
+	var (
+		authz     models.Authorization
+		userID    sql.NullInt64
+		codeHash  sql.NullString
+		expiresAt int64
+		usedAt    sql.NullInt64
+	)
+
+	err := row.Scan(&authz.ID, &authz.AppID, &authz.RedirectURI, &authz.State, &userID, &codeHash, &expiresAt, &usedAt)
+	if err != nil {
+		return models.Authorization{}, err
+	}
+
+	authz.UserID = userID.Int64
+	authz.CodeHash = codeHash.String
+	authz.ExpiresAt = time.UnixMilli(expiresAt)
+	if usedAt.Valid {
+		authz.UsedAt = time.UnixMilli(usedAt.Int64)
+	}
+
+	return authz, nil
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// backupStepPages is the number of pages copied while holding the
+	// source read lock. Writers proceed between steps.
+	backupStepPages = 256
+	backupStepPause = 10 * time.Millisecond
+)
+
+// Backup copies the live database into a new file at destPath using the
+// SQLite online backup API.
+//
+// The copy is made in small steps so writers are never blocked for the
+// whole duration; a write from another connection restarts the copy. The
+// result is written to a temporary file, checked with PRAGMA quick_check
+// and only then renamed to destPath. An encrypted database is backed up
+// encrypted with the same key.
+func (s *Storage) Backup(ctx context.Context, destPath string) error {
+	const op = "storage.sqlite.Backup"
+
+	defer s.observer.Observe(op)()
+
+	// Backups may overlap each other, not a Vacuum.
+	s.copies.RLock()
+	defer s.copies.RUnlock()
+
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("%s: destination %q already exists", op, destPath)
+	}
+
+	tmpPath := destPath + ".tmp"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.copyTo(ctx, tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := checkBackup(ctx, tmpPath, s.key); err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("%s: backup verification failed: %w", op, err)
+	}
+
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) copyTo(ctx context.Context, path string) error {
+	dst, err := open(path, s.key)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	srcConn, err := s.reader.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return dstConn.Raw(func(dstRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			dstSQLite, ok := dstRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected destination driver connection %T", dstRaw)
+			}
+			srcSQLite, ok := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected source driver connection %T", srcRaw)
+			}
+
+			b, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+
+			for {
+				done, err := b.Step(backupStepPages)
+				if err != nil {
+					_ = b.Close()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+
+				select {
+				case <-ctx.Done():
+					_ = b.Close()
+					return ctx.Err()
+				case <-time.After(backupStepPause):
+				}
+			}
+		})
+	})
+}
+
+// checkBackup verifies that the file at path opens and passes quick_check.
+func checkBackup(ctx context.Context, path, key string) error {
+	db, err := open(path, key)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if key != "" {
+		if err := verifyKey(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	var res string
+	if err := db.QueryRowContext(ctx, "PRAGMA quick_check").Scan(&res); err != nil {
+		return err
+	}
+	if res != "ok" {
+		return fmt.Errorf("quick_check reported: %s", res)
+	}
+
+	return nil
+}
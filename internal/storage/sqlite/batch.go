@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+
+	"sso/internal/domain/errs"
+)
+
+// maxBatchParams keeps IN lists well below SQLite's bound parameter limit.
+const maxBatchParams = 500
+
+// UsersExist reports for every given ID whether the user exists.
+func (s *Storage) UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	const op = "storage.sqlite.UsersExist"
+
+	defer s.observer.Observe(op)()
+
+	res := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		res[id] = false
+	}
+
+	for chunk := range chunks(userIDs, maxBatchParams) {
+		rows, err := s.reader.QueryContext(ctx,
+			"SELECT id FROM users WHERE id IN ("+placeholders(len(chunk))+")",
+			args(chunk)...,
+		)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				_ = rows.Close()
+				return nil, errs.Wrap(op, err)
+			}
+			res[id] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		_ = rows.Close()
+	}
+
+	return res, nil
+}
+
+// UserRoles returns the role of every given user. Users that do not exist
+// or have no enrollment get an empty role.
+func (s *Storage) UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	const op = "storage.sqlite.UserRoles"
+
+	defer s.observer.Observe(op)()
+
+	res := make(map[int64]string, len(userIDs))
+	for _, id := range userIDs {
+		res[id] = ""
+	}
+
+	seen := make(map[int64]bool, len(userIDs))
+	for chunk := range chunks(userIDs, maxBatchParams) {
+		// Rows are ordered like in UserRole so both return the same role
+		// for users with several enrollments.
+		rows, err := s.reader.QueryContext(ctx, `
+			SELECT en.user_id, r.role
+			FROM enrollments en
+			INNER JOIN roles r ON r.id = en.role_id
+			WHERE en.user_id IN (`+placeholders(len(chunk))+`) AND en.org_unit_id IS NULL
+			ORDER BY en.id`,
+			args(chunk)...,
+		)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+
+		for rows.Next() {
+			var (
+				id   int64
+				role string
+			)
+			if err := rows.Scan(&id, &role); err != nil {
+				_ = rows.Close()
+				return nil, errs.Wrap(op, err)
+			}
+			if !seen[id] {
+				seen[id] = true
+				res[id] = role
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		_ = rows.Close()
+	}
+
+	return res, nil
+}
+
+// chunks yields consecutive slices of ids of at most size elements.
+func chunks(ids []int64, size int) func(yield func([]int64) bool) {
+	return func(yield func([]int64) bool) {
+		for len(ids) > 0 {
+			n := min(size, len(ids))
+			if !yield(ids[:n]) {
+				return
+			}
+			ids = ids[n:]
+		}
+	}
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func args(ids []int64) []any {
+	res := make([]any, len(ids))
+	for i, id := range ids {
+		res[i] = id
+	}
+	return res
+}
@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveChallenge stores a new pending login challenge.
+func (s *Storage) SaveChallenge(ctx context.Context, challenge models.Challenge) error {
+	const op = "storage.sqlite.SaveChallenge"
+
+	defer s.observer.Observe(op)()
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO login_challenges (token_hash, type, user_id, app_id, expires_at) VALUES (?, ?, ?, ?, ?)",
+		challenge.TokenHash, string(challenge.Type), challenge.UserID, challenge.AppID, challenge.ExpiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Challenge returns the login challenge with the given token hash, used
+// or not. If there is none, returns errs.ErrInvalidChallenge.
+func (s *Storage) Challenge(ctx context.Context, tokenHash string) (models.Challenge, error) {
+	const op = "storage.sqlite.Challenge"
+
+	defer s.observer.Observe(op)()
+
+	var (
+		challenge models.Challenge
+		typ       string
+		expiresAt int64
+		usedAt    sql.NullInt64
+	)
+	err := s.reader.QueryRowContext(ctx,
+		"SELECT token_hash, type, user_id, app_id, expires_at, used_at FROM login_challenges WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&challenge.TokenHash, &typ, &challenge.UserID, &challenge.AppID, &expiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Challenge{}, errs.Wrap(op, errs.ErrInvalidChallenge)
+		}
+
+		return models.Challenge{}, errs.Wrap(op, err)
+	}
+
+	challenge.Type = models.ChallengeType(typ)
+	challenge.ExpiresAt = time.UnixMilli(expiresAt)
+	if usedAt.Valid {
+		challenge.UsedAt = time.UnixMilli(usedAt.Int64)
+	}
+
+	return challenge, nil
+}
+
+// ConsumeChallenge marks the login challenge as used. A challenge can be
+// used once: if it is unknown or already used, returns
+// errs.ErrInvalidChallenge.
+func (s *Storage) ConsumeChallenge(ctx context.Context, tokenHash string, usedAt time.Time) error {
+	const op = "storage.sqlite.ConsumeChallenge"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx,
+		"UPDATE login_challenges SET used_at = ? WHERE token_hash = ? AND used_at IS NULL",
+		usedAt.UnixMilli(), tokenHash,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errs.Wrap(op, err)
+	} else if n == 0 {
+		return errs.Wrap(op, errs.ErrInvalidChallenge)
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package sqlite
+
+// Encryption at rest.
+//
+// The storage file can be encrypted with SQLCipher. Support is chosen at
+// build time and the default build never needs the SQLCipher C library:
+//
+//	build tags              linked SQLite                         Options.Key
+//	(none)                  amalgamation bundled in go-sqlite3    must be empty
+//	sqlcipher,libsqlite3    system libsqlite3 built as SQLCipher  required for encrypted files
+//	                        (CGO_CFLAGS=-DSQLITE_HAS_CODEC)
+//
+// A sqlcipher build linked against a plain libsqlite3 is detected at open
+// time via PRAGMA cipher_version and reported as ErrCipherUnsupported.
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrCipherUnsupported = errors.New("storage encryption requested but sqlcipher support is not available")
+	ErrWrongKey          = errors.New("wrong encryption key or storage file is not a database")
+	ErrNotEncrypted      = errors.New("encryption key given but storage file is not encrypted")
+)
+
+// plaintextHeader starts every unencrypted SQLite database file.
+var plaintextHeader = []byte("SQLite format 3\x00")
+
+// verifyKey checks that the linked SQLite is SQLCipher and that the key
+// decrypts the file.
+func verifyKey(ctx context.Context, db *sql.DB) error {
+	var version string
+	err := db.QueryRowContext(ctx, "PRAGMA cipher_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && version == "") {
+		return ErrCipherUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query cipher version: %w", err)
+	}
+
+	var tables int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&tables); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrNotADB {
+			return ErrWrongKey
+		}
+
+		return fmt.Errorf("failed to read schema: %w", err)
+	}
+
+	return nil
+}
+
+// Encrypt copies the plaintext database at srcPath into a new database at
+// dstPath encrypted with key. dstPath must not exist.
+func Encrypt(ctx context.Context, srcPath, dstPath, key string) error {
+	const op = "storage.sqlite.Encrypt"
+
+	if !cipherSupported {
+		return fmt.Errorf("%s: %w", op, ErrCipherUnsupported)
+	}
+	if key == "" {
+		return fmt.Errorf("%s: encryption key is empty", op)
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		return fmt.Errorf("%s: destination %q already exists", op, dstPath)
+	}
+
+	plaintext, err := isPlaintext(srcPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !plaintext {
+		return fmt.Errorf("%s: source %q is not a plaintext database", op, srcPath)
+	}
+
+	db, err := sql.Open("sqlite3", srcPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer db.Close()
+
+	// ATTACH, export and DETACH must run on the same connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS encrypted KEY ?", dstPath, key); err != nil {
+		return fmt.Errorf("%s: failed to attach destination: %w", op, err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT sqlcipher_export('encrypted')"); err != nil {
+		return fmt.Errorf("%s: failed to export: %w", op, err)
+	}
+	if _, err := conn.ExecContext(ctx, "DETACH DATABASE encrypted"); err != nil {
+		return fmt.Errorf("%s: failed to detach destination: %w", op, err)
+	}
+
+	return nil
+}
+
+// isPlaintext reports whether the file at path is an unencrypted SQLite
+// database. Missing and empty files are not plaintext.
+func isPlaintext(path string) (bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect storage file: %w", err)
+	}
+	defer f.Close()
+
+	header := make([]byte, len(plaintextHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("failed to inspect storage file: %w", err)
+	}
+
+	return bytes.Equal(header, plaintextHeader), nil
+}
@@ -0,0 +1,5 @@
+//go:build !sqlcipher
+
+package sqlite
+
+const cipherSupported = false
@@ -0,0 +1,32 @@
+package sqlite
+
+import (
+	"errors"
+
+	"sso/internal/storage"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// init plugs the classification of SQLite errors into storage.Classify.
+func init() {
+	storage.RegisterClassifier(classify)
+}
+
+// classify tells the SQLite errors worth retrying: a database busy or
+// locked past the busy timeout, an interrupted statement and a failed
+// read or write of the file. Anything else SQLite reports, constraint
+// violations and corruption included, fails again.
+func classify(err error) (storage.Class, bool) {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return 0, false
+	}
+
+	switch sqliteErr.Code {
+	case sqlite3.ErrBusy, sqlite3.ErrLocked, sqlite3.ErrInterrupt, sqlite3.ErrIoErr, sqlite3.ErrProtocol:
+		return storage.Transient, true
+	default:
+		return storage.Permanent, true
+	}
+}
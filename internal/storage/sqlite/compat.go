@@ -0,0 +1,134 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+
+	"sso/internal/domain/errs"
+)
+
+// Phase is how far a change of columns has gone in a rolling deploy, when
+// the binaries of before and after it serve the same file.
+//
+// A change goes through three steps. A migration adds the new columns,
+// which the old binaries ignore, and the new binaries are rolled out in
+// PhaseExpand: they write both the old and the new columns and read the
+// new ones, falling back to the old. A backfill then fills in the new
+// columns of the rows the old binaries wrote. Once no old binary is left,
+// the new binaries are switched to PhaseContract: they write and read the
+// new columns only, and a later migration may drop the old ones.
+type Phase string
+
+const (
+	// PhaseExpand writes both layouts and reads either. The zero Phase
+	// is PhaseExpand too.
+	PhaseExpand Phase = "expand"
+	// PhaseContract writes and reads the new layout only.
+	PhaseContract Phase = "contract"
+)
+
+// Compat is the Phase of each change of columns in progress.
+type Compat struct {
+	// CanonicalEmail is the move of the uniqueness of emails from email
+	// to canonical_email, which binaries before migration 018 leave
+	// NULL. In PhaseExpand, a canonical email clashing with the email of
+	// a user without one is taken, as if it were that user's; backfill
+	// with ssoctl emails canonicalize before PhaseContract.
+	CanonicalEmail Phase
+	// HashVersion is the move of the scheme tag of password hashes, such
+	// as auth.PrehashPrefix, out of pass_hash into hash_version, added by
+	// migration 024. In PhaseExpand pass_hash keeps the tag, as the old
+	// binaries expect; in PhaseContract it has none. Backfill with
+	// ssoctl hash-versions before PhaseContract.
+	HashVersion Phase
+}
+
+// Versions of hash_version. A NULL one is a row written before it, whose
+// pass_hash has the tag if any.
+const (
+	// hashVersionPrehashed is a bcrypt hash of the SHA-512 digest of the
+	// password, tagged prehashTag in pass_hash in PhaseExpand.
+	hashVersionPrehashed = "sha512"
+	// hashVersionPlain is a hash kept as the hasher made it.
+	hashVersionPlain = "plain"
+)
+
+// prehashTag is auth.PrehashPrefix. The storage cannot import the
+// service; TestCompat_Prefix keeps them the same.
+const prehashTag = "$sha512$"
+
+// storedHash returns the pass_hash and hash_version to write a hash as in
+// phase. The empty hash of users without a password has no version.
+func storedHash(phase Phase, hash []byte) ([]byte, sql.NullString) {
+	if len(hash) == 0 {
+		return hash, sql.NullString{}
+	}
+
+	bare, tagged := bytes.CutPrefix(hash, []byte(prehashTag))
+	if !tagged {
+		return hash, sql.NullString{String: hashVersionPlain, Valid: true}
+	}
+	version := sql.NullString{String: hashVersionPrehashed, Valid: true}
+	if phase == PhaseContract {
+		return bare, version
+	}
+
+	return hash, version
+}
+
+// loadedHash returns the hash as the hasher made it from the pass_hash and
+// hash_version of a row, written in either phase or before hash_version.
+func loadedHash(stored []byte, version sql.NullString) []byte {
+	if version.String != hashVersionPrehashed || bytes.HasPrefix(stored, []byte(prehashTag)) {
+		return stored
+	}
+
+	return append([]byte(prehashTag), stored...)
+}
+
+// BackfillHashVersions sets the hash_version of the users written before
+// it and returns how many were set. pass_hash is left as it is, so the
+// binaries of before hash_version keep reading it.
+func (s *Storage) BackfillHashVersions(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.BackfillHashVersions"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		UPDATE users SET hash_version = CASE
+			WHEN substr(pass_hash, 1, ?) = CAST(? AS BLOB) THEN ?
+			ELSE ?
+		END
+		WHERE hash_version IS NULL AND length(pass_hash) > 0`,
+		len(prehashTag), prehashTag, hashVersionPrehashed, hashVersionPlain,
+	)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return n, nil
+}
+
+// canonicalEmailTaken reports whether canonical is the email of a user
+// other than id without a canonical email, in PhaseExpand of
+// Compat.CanonicalEmail. The unique index on canonical_email sees the
+// others.
+func (s *Storage) canonicalEmailTaken(ctx context.Context, tx *sql.Tx, canonical string, id int64) (bool, error) {
+	if s.compat.CanonicalEmail == PhaseContract {
+		return false, nil
+	}
+
+	var taken bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM users WHERE email = ? AND canonical_email IS NULL AND id != ?)",
+		canonical, id,
+	).Scan(&taken)
+
+	return taken, err
+}
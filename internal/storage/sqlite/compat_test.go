@@ -0,0 +1,230 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/services/auth"
+
+	"github.com/golang-migrate/migrate/v4"
+)
+
+// migrateTo returns the path of a database file migrated up to version,
+// and a function migrating it further, as a rolling deploy would.
+func migrateTo(tb testing.TB, version uint) (string, func(version uint)) {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "sso.db")
+	step := func(version uint) {
+		tb.Helper()
+
+		m, err := migrate.New("file://"+migrationsPath, "sqlite3://"+path)
+		if err != nil {
+			tb.Fatalf("failed to init migrations: %v", err)
+		}
+		if err := m.Migrate(version); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			tb.Fatalf("failed to migrate to %d: %v", version, err)
+		}
+		if srcErr, dbErr := m.Close(); srcErr != nil || dbErr != nil {
+			tb.Fatalf("failed to close migrations: %v, %v", srcErr, dbErr)
+		}
+	}
+	step(version)
+
+	return path, step
+}
+
+// openPhase opens path as a binary in compat would.
+func openPhase(tb testing.TB, path string, compat Compat) *Storage {
+	tb.Helper()
+
+	s, err := New(path, Options{Compat: compat})
+	if err != nil {
+		tb.Fatalf("failed to open storage: %v", err)
+	}
+	tb.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// oldBinary is the database as the binaries before a change see it.
+type oldBinary struct {
+	tb testing.TB
+	db *sql.DB
+}
+
+func openOldBinary(tb testing.TB, path string) oldBinary {
+	tb.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { _ = db.Close() })
+
+	return oldBinary{tb: tb, db: db}
+}
+
+func (o oldBinary) saveUser(email string, passHash []byte) {
+	o.tb.Helper()
+
+	if _, err := o.db.Exec(
+		"INSERT INTO users (email, pass_hash, first_name, last_name) VALUES (?, ?, 'John', 'Doe')", email, passHash,
+	); err != nil {
+		o.tb.Fatal(err)
+	}
+}
+
+func (o oldBinary) passHash(email string) []byte {
+	o.tb.Helper()
+
+	var hash []byte
+	if err := o.db.QueryRow("SELECT pass_hash FROM users WHERE email = ?", email).Scan(&hash); err != nil {
+		o.tb.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestCompat_Prefix(t *testing.T) {
+	if prehashTag != auth.PrehashPrefix {
+		t.Fatalf("prehashTag = %q, want auth.PrehashPrefix %q", prehashTag, auth.PrehashPrefix)
+	}
+}
+
+func TestCompat_HashVersion(t *testing.T) {
+	ctx := context.Background()
+	tagged := []byte(prehashTag + "bcrypt")
+	plain := []byte("legacy-bcrypt")
+
+	// Before: the old binaries tag pass_hash.
+	path, migrateUp := migrateTo(t, 23)
+	old := openOldBinary(t, path)
+	old.saveUser("old@example.com", tagged)
+	old.saveUser("plain@example.com", plain)
+
+	hashOf := func(s *Storage, email string) []byte {
+		t.Helper()
+
+		user, err := s.User(ctx, email)
+		if err != nil {
+			t.Fatalf("User(%s): %v", email, err)
+		}
+
+		return user.PassHash
+	}
+
+	// Expand: hash_version is added while the old binaries still serve.
+	migrateUp(24)
+	expand := openPhase(t, path, Compat{})
+	if got := hashOf(expand, "old@example.com"); !bytes.Equal(got, tagged) {
+		t.Fatalf("expand reads the hash of an old row as %q, want %q", got, tagged)
+	}
+	if _, err := expand.SaveUser(ctx, "expand@example.com", tagged, "Jane", "Doe", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := old.passHash("expand@example.com"); !bytes.Equal(got, tagged) {
+		t.Fatalf("the old binaries read the hash expand wrote as %q, want %q", got, tagged)
+	}
+	old.saveUser("during@example.com", tagged)
+
+	// Migrate: the rows of the old binaries are backfilled.
+	n, err := expand.BackfillHashVersions(ctx)
+	if err != nil || n != 3 {
+		t.Fatalf("BackfillHashVersions = %d, %v, want 3, nil", n, err)
+	}
+	if n, err := expand.BackfillHashVersions(ctx); err != nil || n != 0 {
+		t.Fatalf("BackfillHashVersions again = %d, %v, want 0, nil", n, err)
+	}
+
+	// Contract: no old binary is left; pass_hash loses the tag.
+	contract := openPhase(t, path, Compat{HashVersion: PhaseContract})
+	if _, err := contract.SaveUser(ctx, "contract@example.com", tagged, "Jane", "Doe", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := old.passHash("contract@example.com"); !bytes.Equal(got, []byte("bcrypt")) {
+		t.Fatalf("contract wrote pass_hash %q, want it untagged", got)
+	}
+
+	// Either phase reads every row as the hasher made it, so rolling
+	// back from contract to expand is safe.
+	want := map[string][]byte{
+		"old@example.com":      tagged,
+		"plain@example.com":    plain,
+		"expand@example.com":   tagged,
+		"during@example.com":   tagged,
+		"contract@example.com": tagged,
+	}
+	for _, s := range []*Storage{expand, contract} {
+		for email, hash := range want {
+			if got := hashOf(s, email); !bytes.Equal(got, hash) {
+				t.Fatalf("User(%s).PassHash = %q, want %q", email, got, hash)
+			}
+		}
+	}
+
+	var checked [][]byte
+	if _, err := contract.InvalidPassHashes(ctx, func(hash []byte) error {
+		checked = append(checked, hash)
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range checked {
+		if !bytes.Equal(hash, plain) && !bytes.Equal(hash, tagged) {
+			t.Fatalf("InvalidPassHashes checked %q, want the hashes as made", hash)
+		}
+	}
+}
+
+func TestCompat_CanonicalEmail(t *testing.T) {
+	ctx := context.Background()
+
+	// Before migration 018 nothing has a canonical email.
+	path, migrateUp := migrateTo(t, 17)
+	openOldBinary(t, path).saveUser("name@gmail.com", []byte("hash"))
+	migrateUp(SchemaVersion)
+
+	reg := models.Registration{
+		Email:          "name+trial@gmail.com",
+		CanonicalEmail: "name@gmail.com",
+		PassHash:       []byte("hash"),
+		FirstName:      "John",
+		LastName:       "Doe",
+	}
+
+	// Expand: the email of a user without a canonical one stands for it.
+	expand := openPhase(t, path, Compat{})
+	if _, err := expand.SaveRegistration(ctx, reg); !errors.Is(err, errs.ErrCanonicalEmailExists) {
+		t.Fatalf("expand SaveRegistration = %v, want ErrCanonicalEmailExists", err)
+	}
+
+	// Contract reads only canonical_email, hence the backfill first.
+	contract := openPhase(t, path, Compat{CanonicalEmail: PhaseContract})
+	users, err := contract.UserEmails(ctx, 0, 10)
+	if err != nil || len(users) != 1 {
+		t.Fatalf("UserEmails = %v, %v", users, err)
+	}
+	if _, err := contract.SetCanonicalEmails(ctx, []models.EmailChange{
+		{UserID: users[0].ID, From: users[0].Email, To: "name@gmail.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := contract.SaveRegistration(ctx, reg); !errors.Is(err, errs.ErrCanonicalEmailExists) {
+		t.Fatalf("contract SaveRegistration = %v, want ErrCanonicalEmailExists", err)
+	}
+
+	for email, s := range map[string]*Storage{"expand@gmail.com": expand, "contract@gmail.com": contract} {
+		reg.Email, reg.CanonicalEmail = email, email
+		if _, err := s.SaveRegistration(ctx, reg); err != nil {
+			t.Fatalf("SaveRegistration(%s): %v", email, err)
+		}
+	}
+}
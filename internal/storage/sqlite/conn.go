@@ -0,0 +1,78 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// open opens a connection pool to the database. Every new connection is
+// keyed with key, when set, and then configured with pragmas, in that
+// order: SQLCipher rejects any statement issued before the key.
+// The key is never included in returned errors.
+func open(storagePath, key string, pragmas ...string) (*sql.DB, error) {
+	return openHooked(storagePath, key, nil, pragmas...)
+}
+
+// openHooked is open calling hook, if not nil, with every new connection
+// once it is configured.
+func openHooked(storagePath, key string, hook func(*sqlite3.SQLiteConn), pragmas ...string) (*sql.DB, error) {
+	if key != "" {
+		if !cipherSupported {
+			return nil, ErrCipherUnsupported
+		}
+
+		plaintext, err := isPlaintext(storagePath)
+		if err != nil {
+			return nil, err
+		}
+		if plaintext {
+			return nil, ErrNotEncrypted
+		}
+	}
+
+	return sql.OpenDB(&connector{
+		dsn: storagePath,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				if key != "" {
+					if _, err := conn.Exec("PRAGMA key = "+quote(key), nil); err != nil {
+						return err
+					}
+				}
+
+				for _, pragma := range pragmas {
+					if _, err := conn.Exec("PRAGMA "+pragma, nil); err != nil {
+						return err
+					}
+				}
+				if hook != nil {
+					hook(conn)
+				}
+
+				return nil
+			},
+		},
+	}), nil
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// connector opens connections through a driver carrying a ConnectHook.
+type connector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
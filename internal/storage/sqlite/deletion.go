@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sso/internal/domain/errs"
+)
+
+// ScheduleDeletion marks the user for anonymization after deleteAfter,
+// undoable with the reactivation token stored as tokenHash, and revokes
+// the tokens issued up to revokedAt. Scheduling again replaces the
+// deadline and the token.
+//
+// If the user does not exist or was already anonymized, returns
+// errs.ErrUserNotFound.
+func (s *Storage) ScheduleDeletion(
+	ctx context.Context,
+	userID int64,
+	deleteAfter time.Time,
+	tokenHash string,
+	revokedAt time.Time,
+) error {
+	const op = "storage.sqlite.ScheduleDeletion"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		UPDATE users SET delete_after = ?, reactivation_token_hash = ?, sessions_revoked_at = ?, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		deleteAfter.UnixMilli(), tokenHash, revokedAt.UnixMilli(), time.Now().UnixMilli(), userID,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errs.Wrap(op, err)
+	} else if n == 0 {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// CancelDeletion undoes the scheduled deletion of the user, given the hash
+// of its reactivation token before the deadline passed at now.
+//
+// If no deletion is scheduled, the token does not match or the deadline
+// has passed, returns errs.ErrInvalidReactivationToken.
+func (s *Storage) CancelDeletion(ctx context.Context, userID int64, tokenHash string, now time.Time) error {
+	const op = "storage.sqlite.CancelDeletion"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		UPDATE users SET delete_after = NULL, reactivation_token_hash = NULL, updated_at = ?
+		WHERE id = ? AND reactivation_token_hash = ? AND delete_after > ?`,
+		time.Now().UnixMilli(), userID, tokenHash, now.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errs.Wrap(op, err)
+	} else if n == 0 {
+		return errs.Wrap(op, errs.ErrInvalidReactivationToken)
+	}
+
+	return nil
+}
+
+// AnonymizeDueUsers anonymizes up to limit users whose deletion deadline
+// is not after now, earliest first, and returns their IDs. The rows are
+// kept, so the login history and the outbox still refer to a user, but
+// the email, names and password are replaced and the roles, linked
+// identities, pending authorizations and login challenges removed, all
+// in one transaction.
+func (s *Storage) AnonymizeDueUsers(ctx context.Context, now time.Time, limit int) ([]int64, error) {
+	const op = "storage.sqlite.AnonymizeDueUsers"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id FROM users WHERE delete_after <= ? ORDER BY delete_after, id LIMIT ?",
+		now.UnixMilli(), limit,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+
+			return nil, errs.Wrap(op, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	_ = rows.Close()
+
+	deletedAt := now.UnixMilli()
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE users SET email = ?, canonical_email = NULL, first_name = '', last_name = '', middle_name = NULL, pass_hash = X'', hash_version = NULL,
+				token_ttl_seconds = NULL, tos_version_accepted = NULL, tos_accepted_at = NULL,
+				delete_after = NULL, reactivation_token_hash = NULL, deleted_at = ?, updated_at = ?,
+				activation_pending = 0, activation_token_hash = NULL, activation_expires_at = NULL,
+				app_id = NULL
+			WHERE id = ?`,
+			anonymizedEmail(id), deletedAt, deletedAt, id,
+		); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		for _, query := range []string{
+			"DELETE FROM enrollments WHERE user_id = ?",
+			"DELETE FROM linked_identities WHERE user_id = ?",
+			"DELETE FROM authorizations WHERE user_id = ? AND used_at IS NULL",
+			"DELETE FROM login_challenges WHERE user_id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, query, id); err != nil {
+				return nil, errs.Wrap(op, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return ids, nil
+}
+
+// anonymizedEmail is the email an anonymized user is left with. It keeps
+// the column unique and can never be registered, as .invalid is reserved.
+func anonymizedEmail(userID int64) string {
+	return fmt.Sprintf("deleted-%d@deleted.invalid", userID)
+}
@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// UserEmails returns up to limit users after afterID in ID order, with
+// their email and canonical email as stored and their last successful
+// login.
+func (s *Storage) UserEmails(ctx context.Context, afterID int64, limit int) ([]models.UserEmail, error) {
+	const op = "storage.sqlite.UserEmails"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx, `
+		SELECT u.id, u.email, u.canonical_email,
+			(SELECT MAX(h.created_at) FROM login_history h WHERE h.user_id = u.id AND h.success = 1)
+		FROM users u
+		WHERE u.id > ?
+		ORDER BY u.id
+		LIMIT ?`,
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var res []models.UserEmail
+	for rows.Next() {
+		var (
+			user      models.UserEmail
+			canonical sql.NullString
+			lastLogin sql.NullInt64
+		)
+		if err := rows.Scan(&user.ID, &user.Email, &canonical, &lastLogin); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		user.CanonicalEmail = canonical.String
+		if lastLogin.Valid {
+			user.LastLoginAt = time.UnixMilli(lastLogin.Int64)
+		}
+		res = append(res, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return res, nil
+}
+
+// ChangeEmails applies changes in one transaction and returns how many
+// users were changed. A user whose email is no longer From is left alone,
+// so a batch planned from a stale scan does no harm. If a To is taken,
+// nothing is changed and errs.ErrUserExists is returned.
+func (s *Storage) ChangeEmails(ctx context.Context, changes []models.EmailChange) (int, error) {
+	const op = "storage.sqlite.ChangeEmails"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, "UPDATE users SET email = ?, updated_at = ? WHERE id = ? AND email = ?")
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	changed := 0
+	for _, c := range changes {
+		res, err := stmt.ExecContext(ctx, c.To, now, c.UserID, c.From)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return 0, errs.Wrap(op, errs.ErrUserExists)
+			}
+
+			return 0, errs.Wrap(op, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+		changed += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return changed, nil
+}
+
+// SetCanonicalEmails stores the canonical email To of every user of
+// changes whose email is still From, in one transaction, and returns how
+// many users were changed. An empty To clears it. If a To is another
+// user's, nothing is changed and errs.ErrCanonicalEmailExists is
+// returned.
+func (s *Storage) SetCanonicalEmails(ctx context.Context, changes []models.EmailChange) (int, error) {
+	const op = "storage.sqlite.SetCanonicalEmails"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, "UPDATE users SET canonical_email = ?, updated_at = ? WHERE id = ? AND email = ?")
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer stmt.Close()
+
+	now := time.Now().UnixMilli()
+	changed := 0
+	for _, c := range changes {
+		res, err := stmt.ExecContext(ctx, optional(c.To), now, c.UserID, c.From)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return 0, errs.Wrap(op, errs.ErrCanonicalEmailExists)
+			}
+
+			return 0, errs.Wrap(op, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+		changed += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return changed, nil
+}
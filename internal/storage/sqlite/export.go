@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// exportPageSize is the number of users Users reads per query.
+const exportPageSize = 500
+
+// Users iterates over the users matching filter in ID order. Pages are
+// read lazily with keyset pagination on the ID, so only one page is held
+// in memory and no read transaction stays open between pages. A failure
+// is yielded once and ends the iteration.
+func (s *Storage) Users(ctx context.Context, filter models.UserFilter) iter.Seq2[models.UserRecord, error] {
+	return func(yield func(models.UserRecord, error) bool) {
+		afterID := filter.AfterID
+		for {
+			page, err := s.usersPage(ctx, afterID, filter)
+			if err != nil {
+				yield(models.UserRecord{}, err)
+				return
+			}
+
+			for _, user := range page {
+				if !yield(user, nil) {
+					return
+				}
+			}
+			if len(page) < exportPageSize {
+				return
+			}
+			afterID = page[len(page)-1].ID
+		}
+	}
+}
+
+func (s *Storage) usersPage(ctx context.Context, afterID int64, filter models.UserFilter) ([]models.UserRecord, error) {
+	const op = "storage.sqlite.Users"
+
+	defer s.observer.Observe(op)()
+
+	var since, upTo sql.NullInt64
+	if !filter.UpdatedSince.IsZero() {
+		since = sql.NullInt64{Int64: filter.UpdatedSince.UnixMilli(), Valid: true}
+	}
+	if filter.UpToID != 0 {
+		upTo = sql.NullInt64{Int64: filter.UpToID, Valid: true}
+	}
+
+	rows, err := s.reader.QueryContext(ctx, `
+		SELECT id, email, first_name, last_name, middle_name, created_at, updated_at
+		FROM users
+		WHERE id > ? AND (? IS NULL OR updated_at >= ?) AND (? IS NULL OR id <= ?)
+		ORDER BY id
+		LIMIT ?`,
+		afterID, since, since, upTo, upTo, exportPageSize,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	page := make([]models.UserRecord, 0, exportPageSize)
+	for rows.Next() {
+		var (
+			user                 models.UserRecord
+			middleName           sql.NullString
+			createdAt, updatedAt sql.NullInt64
+		)
+		err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName, &middleName, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		user.MiddleName = middleName.String
+		if createdAt.Valid {
+			user.CreatedAt = time.UnixMilli(createdAt.Int64)
+		}
+		if updatedAt.Valid {
+			user.UpdatedAt = time.UnixMilli(updatedAt.Int64)
+		}
+		page = append(page, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return page, nil
+}
+
+// LastUserID returns the ID of the newest user, zero if there is none.
+func (s *Storage) LastUserID(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.LastUserID"
+
+	defer s.observer.Observe(op)()
+
+	var id int64
+	if err := s.reader.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM users").Scan(&id); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
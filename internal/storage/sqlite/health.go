@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"sso/internal/domain/errs"
+)
+
+// SchemaVersion is the version of the newest migration the code relies on.
+// It must be bumped with every new migration.
+const SchemaVersion = 25
+
+// Ping checks that both handles can reach the database.
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	if err := s.writer.PingContext(ctx); err != nil {
+		return errs.Wrap(op, err)
+	}
+	if s.reader != s.writer {
+		if err := s.reader.PingContext(ctx); err != nil {
+			return errs.Wrap(op, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema reports an errs.SchemaDrift error unless the schema is the
+// one the code relies on, see VerifySchema. Its message tells what
+// differs.
+func (s *Storage) CheckSchema(ctx context.Context, table string) error {
+	const op = "storage.sqlite.CheckSchema"
+
+	check, err := s.VerifySchema(ctx, table)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if !check.OK() {
+		return errs.Wrap(op, schemaDriftError(check))
+	}
+
+	return nil
+}
+
+// MigrationVersion returns the version of the last migration recorded in
+// table, the migrator's bookkeeping table, and whether it failed halfway.
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (version int, dirty bool, err error) {
+	const op = "storage.sqlite.MigrationVersion"
+
+	defer s.observer.Observe(op)()
+
+	if table == "" {
+		return 0, false, errs.Wrap(op, errors.New("migrations table is required"))
+	}
+
+	query := fmt.Sprintf("SELECT version, dirty FROM %s LIMIT 1", quoteIdent(table))
+	if err := s.reader.QueryRowContext(ctx, query).Scan(&version, &dirty); err != nil {
+		return 0, false, errs.Wrap(op, err)
+	}
+
+	return version, dirty, nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"sso/internal/domain/errs"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// InvalidPassHashes returns the IDs of the users whose password hash
+// check rejects, in ID order. Users are read a page at a time so that the
+// scan does not hold a read transaction for long.
+func (s *Storage) InvalidPassHashes(ctx context.Context, check func(hash []byte) error) ([]int64, error) {
+	const op = "storage.sqlite.InvalidPassHashes"
+
+	defer s.observer.Observe(op)()
+
+	var (
+		invalid []int64
+		afterID int64
+	)
+	for {
+		rows, err := s.reader.QueryContext(ctx,
+			"SELECT id, pass_hash, hash_version FROM users WHERE id > ? ORDER BY id LIMIT ?",
+			afterID, exportPageSize,
+		)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+
+		n := 0
+		for rows.Next() {
+			var hash []byte
+			var version sql.NullString
+			if err := rows.Scan(&afterID, &hash, &version); err != nil {
+				_ = rows.Close()
+
+				return nil, errs.Wrap(op, err)
+			}
+			if check(loadedHash(hash, version)) != nil {
+				invalid = append(invalid, afterID)
+			}
+			n++
+		}
+		err = rows.Err()
+		_ = rows.Close()
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+
+		if n < exportPageSize {
+			return invalid, nil
+		}
+	}
+}
+
+// maxIntegrityProblems bounds the problems CheckIntegrity reports, which
+// on a badly damaged file can be one per page.
+const maxIntegrityProblems = 100
+
+// CheckIntegrity checks the database file for corruption with PRAGMA
+// integrity_check, or with the faster quick_check, which skips the
+// contents of indexes, and returns the problems found, none if the file
+// is sound. A file too damaged for the check to run is one problem. Both
+// read every page, so they take long on large files.
+func (s *Storage) CheckIntegrity(ctx context.Context, quick bool) ([]string, error) {
+	const op = "storage.sqlite.CheckIntegrity"
+
+	defer s.observer.Observe(op)()
+
+	pragma := "integrity_check"
+	if quick {
+		pragma = "quick_check"
+	}
+
+	rows, err := s.reader.QueryContext(ctx, fmt.Sprintf("PRAGMA %s(%d)", pragma, maxIntegrityProblems))
+	if err != nil {
+		return integrityFailed(op, nil, err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return integrityFailed(op, problems, err)
+	}
+
+	return problems, nil
+}
+
+// integrityFailed adds err to problems if it reports the damage the
+// check was looking for, and returns it otherwise.
+func integrityFailed(op string, problems []string, err error) ([]string, error) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB) {
+		return append(problems, sqliteErr.Error()), nil
+	}
+
+	return nil, errs.Wrap(op, err)
+}
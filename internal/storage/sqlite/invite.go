@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveInvite stores a new invite and returns its ID.
+func (s *Storage) SaveInvite(ctx context.Context, invite models.Invite) (int64, error) {
+	const op = "storage.sqlite.SaveInvite"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx,
+		"INSERT INTO invites (code_hash, email, uses_remaining, expires_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		invite.CodeHash, sql.NullString{String: invite.Email, Valid: invite.Email != ""},
+		invite.UsesRemaining, nullInt64(unixMilli(invite.ExpiresAt)), invite.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// Invite returns the invite stored under codeHash.
+func (s *Storage) Invite(ctx context.Context, codeHash string) (models.Invite, error) {
+	const op = "storage.sqlite.Invite"
+
+	defer s.observer.Observe(op)()
+
+	var (
+		invite               models.Invite
+		email                sql.NullString
+		expiresAt, createdAt sql.NullInt64
+	)
+	err := s.reader.QueryRowContext(ctx,
+		"SELECT id, code_hash, email, uses_remaining, expires_at, created_at FROM invites WHERE code_hash = ?",
+		codeHash,
+	).Scan(&invite.ID, &invite.CodeHash, &email, &invite.UsesRemaining, &expiresAt, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Invite{}, errs.Wrap(op, errs.ErrInviteNotFound)
+		}
+
+		return models.Invite{}, errs.Wrap(op, err)
+	}
+	invite.Email = email.String
+	if expiresAt.Valid {
+		invite.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+	}
+	invite.CreatedAt = time.UnixMilli(createdAt.Int64)
+
+	return invite, nil
+}
+
+// SaveInvitedUser saves the user and spends one use of the invite in one
+// transaction, so a failed registration keeps the use and a used up invite
+// registers nobody. A non-zero appID attributes the user to the app, as
+// SaveAppUser does.
+//
+// If the invite has no uses left, returns errs.ErrInviteUsedUp.
+func (s *Storage) SaveInvitedUser(
+	ctx context.Context,
+	inviteID int64,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+	usedAt time.Time,
+) (int64, error) {
+	const op = "storage.sqlite.SaveInvitedUser"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := takeInviteUse(ctx, tx, inviteID); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := s.insertUser(ctx, tx, appID, email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	if err := insertInviteUse(ctx, tx, inviteID, id, usedAt); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// takeInviteUse takes one of the remaining uses of the invite.
+func takeInviteUse(ctx context.Context, tx *sql.Tx, inviteID int64) error {
+	res, err := tx.ExecContext(ctx,
+		"UPDATE invites SET uses_remaining = uses_remaining - 1 WHERE id = ? AND uses_remaining > 0", inviteID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM invites WHERE id = ?)", inviteID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errs.ErrInviteNotFound
+		}
+
+		return errs.ErrInviteUsedUp
+	}
+
+	return nil
+}
+
+func insertInviteUse(ctx context.Context, tx *sql.Tx, inviteID, userID int64, usedAt time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)", inviteID, userID, usedAt.UnixMilli(),
+	)
+
+	return err
+}
+
+// InviteUses returns who registered with the invite, oldest first.
+func (s *Storage) InviteUses(ctx context.Context, inviteID int64) ([]models.InviteUse, error) {
+	const op = "storage.sqlite.InviteUses"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx,
+		"SELECT invite_id, user_id, used_at FROM invite_uses WHERE invite_id = ? ORDER BY used_at, user_id",
+		inviteID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var uses []models.InviteUse
+	for rows.Next() {
+		var (
+			use    models.InviteUse
+			usedAt int64
+		)
+		if err := rows.Scan(&use.InviteID, &use.UserID, &usedAt); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		use.UsedAt = time.UnixMilli(usedAt)
+		uses = append(uses, use)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return uses, nil
+}
@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// LinkIdentity links the provider account of identity to its user.
+//
+// If the user does not exist, returns errs.ErrUserNotFound.
+// If the provider account, or another account of the provider, is already
+// linked, returns errs.ErrIdentityLinked.
+func (s *Storage) LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error {
+	const op = "storage.sqlite.LinkIdentity"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		INSERT INTO linked_identities (provider, subject, user_id, email, linked_at)
+		SELECT ?, ?, id, ?, ? FROM users WHERE id = ?`,
+		identity.Provider, identity.Subject, sql.NullString{String: identity.Email, Valid: identity.Email != ""},
+		identity.LinkedAt.UnixMilli(), identity.UserID,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) &&
+			(sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey) {
+			return errs.Wrap(op, errs.ErrIdentityLinked)
+		}
+
+		return errs.Wrap(op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errs.Wrap(op, err)
+	} else if n == 0 {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// LinkedIdentities returns the provider accounts linked to the user,
+// oldest first.
+func (s *Storage) LinkedIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error) {
+	const op = "storage.sqlite.LinkedIdentities"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx, `
+		SELECT provider, subject, user_id, email, linked_at FROM linked_identities
+		WHERE user_id = ? ORDER BY linked_at, provider`,
+		userID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var identities []models.LinkedIdentity
+	for rows.Next() {
+		var (
+			identity models.LinkedIdentity
+			email    sql.NullString
+			linkedAt int64
+		)
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &email, &linkedAt); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		identity.Email = email.String
+		identity.LinkedAt = time.UnixMilli(linkedAt)
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity unlinks the account of provider from the user. The
+// check that the user keeps a way to sign in and the removal are one
+// transaction, so concurrent unlinks cannot both pass it.
+//
+// If no account of provider is linked, returns errs.ErrIdentityNotFound.
+// If it is the last login method of the user, returns
+// errs.ErrLastLoginMethod.
+func (s *Storage) UnlinkIdentity(ctx context.Context, userID int64, provider string) error {
+	const op = "storage.sqlite.UnlinkIdentity"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var hasPassword bool
+	var linked, ofProvider int
+	err = tx.QueryRowContext(ctx, `
+		SELECT length(u.pass_hash) > 0, COUNT(l.provider), COUNT(CASE WHEN l.provider = ? THEN 1 END)
+		FROM users u LEFT JOIN linked_identities l ON l.user_id = u.id
+		WHERE u.id = ? GROUP BY u.id`,
+		provider, userID,
+	).Scan(&hasPassword, &linked, &ofProvider)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errs.Wrap(op, errs.ErrIdentityNotFound)
+		}
+
+		return errs.Wrap(op, err)
+	}
+	if ofProvider == 0 {
+		return errs.Wrap(op, errs.ErrIdentityNotFound)
+	}
+	if !hasPassword && linked == 1 {
+		return errs.Wrap(op, errs.ErrLastLoginMethod)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM linked_identities WHERE user_id = ? AND provider = ?", userID, provider,
+	); err != nil {
+		return errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package sqlite
+
+// Instance lock.
+//
+// SQLite locks the database file for every transaction, but those locks
+// are unreliable on network filesystems, and two servers sharing a file
+// also run the same background jobs twice. With Options.Exclusive, New
+// holds an exclusive lock on <storage file>.lock for as long as the
+// storage is open and refuses to open a file another instance holds.
+//
+// The lock is an flock(2) lock, which the kernel drops with the process
+// that took it: a lock file left behind by a crashed instance is stale as
+// soon as it can be locked, and its holder is simply overwritten. The
+// file itself stays in place, as removing it would let a late opener lock
+// an unlinked inode while another creates a new one.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by New when another instance holds the storage
+// file, see Options.Exclusive.
+var ErrLocked = errors.New("storage file is in use by another instance")
+
+// lockHolder is written to the lock file by its holder, for the error
+// of the next instance.
+type lockHolder struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+func (h lockHolder) String() string {
+	if h.PID == 0 {
+		return "unknown holder"
+	}
+
+	return fmt.Sprintf("pid %d on %s since %s", h.PID, h.Hostname, h.StartedAt.Format(time.RFC3339))
+}
+
+// instanceLock is the lock file of an open storage.
+type instanceLock struct {
+	file *os.File
+}
+
+// acquireLock locks the lock file of storagePath. If another instance
+// holds it, returns an error wrapping ErrLocked that names the holder.
+func acquireLock(storagePath string) (*instanceLock, error) {
+	path := filePath(storagePath) + ".lock"
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		var holder lockHolder
+		if errors.Is(err, errLockHeld) {
+			// A holder that is still writing its details reads as unknown.
+			if b, readErr := os.ReadFile(path); readErr == nil {
+				_ = json.Unmarshal(b, &holder)
+			}
+			err = fmt.Errorf("%w: %s is held by %s", ErrLocked, path, holder)
+		} else {
+			err = fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		_ = f.Close()
+
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	b, err := json.Marshal(lockHolder{PID: os.Getpid(), Hostname: hostname, StartedAt: time.Now()})
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(b, 0)
+	}
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	return &instanceLock{file: f}, nil
+}
+
+// release drops the lock. The file is left for the next instance.
+func (l *instanceLock) release() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.file.Close()
+}
@@ -0,0 +1,22 @@
+//go:build unix && !aix && !solaris
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLockHeld reports a lock file locked by another open file.
+var errLockHeld = errors.New("lock is held")
+
+// lockFile takes an exclusive flock on f without waiting for it.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLockHeld
+	}
+
+	return err
+}
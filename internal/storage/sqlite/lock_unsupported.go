@@ -0,0 +1,16 @@
+//go:build !unix || aix || solaris
+
+package sqlite
+
+import (
+	"errors"
+	"os"
+)
+
+// errLockHeld is never returned on this platform.
+var errLockHeld = errors.New("lock is held")
+
+// lockFile fails: there is no flock on this platform.
+func lockFile(*os.File) error {
+	return errors.New("instance locks are not supported on this platform")
+}
@@ -0,0 +1,78 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// Optimize runs PRAGMA optimize, which refreshes the statistics of the
+// query planner where they are likely stale. It is cheap enough to run
+// often.
+func (s *Storage) Optimize(ctx context.Context) error {
+	const op = "storage.sqlite.Optimize"
+
+	defer s.observer.Observe(op)()
+
+	if _, err := s.writer.ExecContext(ctx, "PRAGMA optimize"); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// Vacuum rebuilds the file to give back the pages freed by deletions and
+// then runs ANALYZE. It holds the writer connection throughout, so writes
+// wait for it: bound it with ctx, which interrupts it, leaving the file as
+// it was.
+//
+// While a backup runs, or another Vacuum, the result has Skipped set:
+// the backup would restart on every page the vacuum rewrites.
+func (s *Storage) Vacuum(ctx context.Context) (models.MaintenanceResult, error) {
+	const op = "storage.sqlite.Vacuum"
+
+	defer s.observer.Observe(op)()
+
+	if !s.copies.TryLock() {
+		return models.MaintenanceResult{Skipped: true}, nil
+	}
+	defer s.copies.Unlock()
+
+	start := time.Now()
+	conn, err := s.writer.Conn(ctx)
+	if err != nil {
+		return models.MaintenanceResult{}, errs.Wrap(op, err)
+	}
+	defer conn.Close()
+
+	res := models.MaintenanceResult{}
+	if err := conn.QueryRowContext(ctx, "PRAGMA page_size").Scan(&res.PageSize); err != nil {
+		return models.MaintenanceResult{}, errs.Wrap(op, err)
+	}
+	if res.PagesBefore, err = pageCount(ctx, conn); err != nil {
+		return models.MaintenanceResult{}, errs.Wrap(op, err)
+	}
+
+	for _, query := range []string{"VACUUM", "ANALYZE"} {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return models.MaintenanceResult{}, errs.Wrap(op, err)
+		}
+	}
+
+	if res.PagesAfter, err = pageCount(ctx, conn); err != nil {
+		return models.MaintenanceResult{}, errs.Wrap(op, err)
+	}
+	res.Duration = time.Since(start)
+
+	return res, nil
+}
+
+func pageCount(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var n int64
+	err := conn.QueryRowContext(ctx, "PRAGMA page_count").Scan(&n)
+
+	return n, err
+}
@@ -0,0 +1,209 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ancestorsQuery lists the unit of the first parameter and every unit
+// above it.
+const ancestorsQuery = `
+	WITH RECURSIVE ancestors (id) AS (
+		SELECT id FROM org_units WHERE id = ?
+		UNION
+		SELECT u.parent_id FROM org_units u JOIN ancestors a ON u.id = a.id
+		WHERE u.parent_id IS NOT NULL
+	)`
+
+// SaveOrgUnit saves the unit under its parent, a root unit if ParentID is
+// zero, and returns its ID. If the parent does not exist, returns
+// errs.ErrOrgUnitNotFound; if it already has a unit of the name,
+// errs.ErrOrgUnitExists.
+func (s *Storage) SaveOrgUnit(ctx context.Context, unit models.OrgUnit) (int64, error) {
+	const op = "storage.sqlite.SaveOrgUnit"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if unit.ParentID != 0 {
+		if err := orgUnitExists(ctx, tx, unit.ParentID); err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO org_units (parent_id, name) VALUES (?, ?)", optionalID(unit.ParentID), unit.Name,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, errs.Wrap(op, errs.ErrOrgUnitExists)
+		}
+
+		return 0, errs.Wrap(op, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// MoveOrgUnit moves the unit under parentID, to the root if zero, with
+// everything below it. If either unit does not exist, returns
+// errs.ErrOrgUnitNotFound; if parentID is the unit or below it,
+// errs.ErrOrgUnitCycle; if the parent already has a unit of the name,
+// errs.ErrOrgUnitExists.
+func (s *Storage) MoveOrgUnit(ctx context.Context, id, parentID int64) error {
+	const op = "storage.sqlite.MoveOrgUnit"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := orgUnitExists(ctx, tx, id); err != nil {
+		return errs.Wrap(op, err)
+	}
+	if parentID != 0 {
+		if err := orgUnitExists(ctx, tx, parentID); err != nil {
+			return errs.Wrap(op, err)
+		}
+
+		// The writer is the only connection changing units, so no move
+		// can land between this check and the update.
+		var cycle bool
+		err := tx.QueryRowContext(ctx,
+			ancestorsQuery+" SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = ?)", parentID, id,
+		).Scan(&cycle)
+		if err != nil {
+			return errs.Wrap(op, err)
+		}
+		if cycle {
+			return errs.Wrap(op, errs.ErrOrgUnitCycle)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE org_units SET parent_id = ? WHERE id = ?", optionalID(parentID), id); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return errs.Wrap(op, errs.ErrOrgUnitExists)
+		}
+
+		return errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// OrgUnits returns every unit, by ID, without their children.
+func (s *Storage) OrgUnits(ctx context.Context) ([]models.OrgUnit, error) {
+	const op = "storage.sqlite.OrgUnits"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx, "SELECT id, parent_id, name FROM org_units ORDER BY id")
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	units := []models.OrgUnit{}
+	for rows.Next() {
+		var (
+			unit     models.OrgUnit
+			parentID sql.NullInt64
+		)
+		if err := rows.Scan(&unit.ID, &parentID, &unit.Name); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		unit.ParentID = parentID.Int64
+		units = append(units, unit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return units, nil
+}
+
+// UnitRoles returns the roles of the user in the unit, sorted: those of
+// the enrollments in the unit or any unit above it, and those of the
+// enrollments in no unit, which hold everywhere. If the unit does not
+// exist, returns errs.ErrOrgUnitNotFound.
+func (s *Storage) UnitRoles(ctx context.Context, userID, unitID int64) ([]string, error) {
+	const op = "storage.sqlite.UnitRoles"
+
+	defer s.observer.Observe(op)()
+
+	if err := orgUnitExists(ctx, s.reader, unitID); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	rows, err := s.reader.QueryContext(ctx, ancestorsQuery+`
+		SELECT DISTINCT r.role
+		FROM enrollments en
+		JOIN roles r ON r.id = en.role_id
+		WHERE en.user_id = ?
+			AND (en.org_unit_id IS NULL OR en.org_unit_id IN (SELECT id FROM ancestors))
+		ORDER BY r.role`,
+		unitID, userID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return roles, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func orgUnitExists(ctx context.Context, q queryRower, id int64) error {
+	var one int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM org_units WHERE id = ?", id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.ErrOrgUnitNotFound
+	}
+
+	return err
+}
+
+func optionalID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
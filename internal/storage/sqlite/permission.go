@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"sso/internal/domain/errs"
+)
+
+// AttachPermission grants permission of the app to every user of role,
+// creating the permission on first use. Attaching it again is a no-op.
+// If no user was ever given role, returns errs.ErrRoleNotFound; if the
+// app does not exist, errs.ErrAppNotFound.
+func (s *Storage) AttachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	const op = "storage.sqlite.AttachPermission"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var one int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM roles WHERE role = ? LIMIT 1", role).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.Wrap(op, errs.ErrRoleNotFound)
+	}
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM apps WHERE id = ?", appID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errs.Wrap(op, errs.ErrAppNotFound)
+	}
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT OR IGNORE INTO permissions (app_id, name) VALUES (?, ?)", appID, permission,
+	); err != nil {
+		return errs.Wrap(op, err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO role_permissions (role, permission_id)
+		SELECT ?, id FROM permissions WHERE app_id = ? AND name = ?`,
+		role, appID, permission,
+	); err != nil {
+		return errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// DetachPermission takes permission of the app back from role. If role
+// does not have it, returns errs.ErrPermissionNotFound.
+func (s *Storage) DetachPermission(ctx context.Context, role string, appID int32, permission string) error {
+	const op = "storage.sqlite.DetachPermission"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		DELETE FROM role_permissions
+		WHERE role = ?
+			AND permission_id IN (SELECT id FROM permissions WHERE app_id = ? AND name = ?)`,
+		role, appID, permission,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n == 0 {
+		return errs.Wrap(op, errs.ErrPermissionNotFound)
+	}
+
+	return nil
+}
+
+// RolePermissions returns the permissions of the app attached to role,
+// sorted by name.
+func (s *Storage) RolePermissions(ctx context.Context, role string, appID int32) ([]string, error) {
+	const op = "storage.sqlite.RolePermissions"
+
+	defer s.observer.Observe(op)()
+
+	permissions, err := s.permissions(ctx, `
+		SELECT p.name
+		FROM role_permissions rp
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE rp.role = ? AND p.app_id = ?
+		ORDER BY p.name`,
+		role, appID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return permissions, nil
+}
+
+// UserPermissions returns the permissions of the app the roles of the
+// user grant, sorted by name. A user without any has none, whether they
+// exist or not.
+func (s *Storage) UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error) {
+	const op = "storage.sqlite.UserPermissions"
+
+	defer s.observer.Observe(op)()
+
+	permissions, err := s.permissions(ctx, `
+		SELECT DISTINCT p.name
+		FROM enrollments en
+		JOIN roles r ON r.id = en.role_id
+		JOIN role_permissions rp ON rp.role = r.role
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE en.user_id = ? AND en.org_unit_id IS NULL AND p.app_id = ?
+		ORDER BY p.name`,
+		userID, appID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return permissions, nil
+}
+
+func (s *Storage) permissions(ctx context.Context, query string, args ...any) ([]string, error) {
+	rows, err := s.reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, name)
+	}
+
+	return permissions, rows.Err()
+}
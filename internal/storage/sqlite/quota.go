@@ -0,0 +1,166 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// insertAppUserQuery inserts a user registered through an app, if the app
+// exists and has a slot left. Counting and inserting in one statement
+// leaves no room for another registration between them; the count is
+// served by idx_users_app_id.
+const insertAppUserQuery = `
+	INSERT INTO users (email, pass_hash, hash_version, first_name, last_name, middle_name, created_at, updated_at, app_id)
+	SELECT ?, ?, ?, ?, ?, ?, ?, ?, a.id
+	FROM apps a
+	WHERE a.id = ?
+		AND (a.max_users IS NULL OR (SELECT COUNT(*) FROM users u WHERE u.app_id = a.id) < a.max_users)`
+
+// SaveAppUser saves a user registered through the app.
+//
+// If the app does not exist, returns errs.ErrAppNotFound. If its quota is
+// reached, returns errs.ErrQuotaExceeded.
+func (s *Storage) SaveAppUser(
+	ctx context.Context,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	const op = "storage.sqlite.SaveAppUser"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := s.insertUser(ctx, tx, appID, email, passHash, firstName, lastName, middleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// insertUser inserts a user registered through appID, none if zero.
+func (s *Storage) insertUser(
+	ctx context.Context,
+	tx *sql.Tx,
+	appID int32,
+	email string,
+	passHash []byte,
+	firstName string,
+	lastName string,
+	middleName string,
+) (int64, error) {
+	now := time.Now().UnixMilli()
+	passHash, version := storedHash(s.compat.HashVersion, passHash)
+
+	var res sql.Result
+	var err error
+	if appID == 0 {
+		res, err = tx.ExecContext(ctx, insertUserQuery, email, passHash, version, firstName, lastName, optional(middleName), now, now)
+	} else {
+		res, err = tx.ExecContext(ctx, insertAppUserQuery, email, passHash, version, firstName, lastName, optional(middleName), now, now, appID)
+	}
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, errs.ErrUserExists
+		}
+
+		return 0, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		var maxUsers int
+		err := tx.QueryRowContext(ctx, "SELECT max_users FROM apps WHERE id = ?", appID).Scan(&maxUsers)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errs.ErrAppNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return 0, quotaExceeded(maxUsers)
+	}
+
+	return res.LastInsertId()
+}
+
+// quotaExceeded returns errs.ErrQuotaExceeded carrying the quota.
+func quotaExceeded(maxUsers int) error {
+	return &errs.Error{
+		Code:     errs.QuotaExceeded,
+		Message:  errs.ErrQuotaExceeded.Message,
+		Metadata: map[string]string{"max_users": strconv.Itoa(maxUsers)},
+	}
+}
+
+// SetAppMaxUsers sets the user quota of the app, zero to clear it. A
+// quota below the users already registered only stops new ones.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error {
+	const op = "storage.sqlite.SetAppMaxUsers"
+
+	defer s.observer.Observe(op)()
+
+	value := sql.NullInt64{Int64: int64(maxUsers), Valid: maxUsers > 0}
+	res, err := s.writer.ExecContext(ctx, "UPDATE apps SET max_users = ? WHERE id = ?", value, appID)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errs.Wrap(op, err)
+	} else if n == 0 {
+		return errs.Wrap(op, errs.ErrAppNotFound)
+	}
+
+	return nil
+}
+
+// AppQuota returns the user quota of the app and the users registered
+// through it.
+//
+// If the app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) AppQuota(ctx context.Context, appID int32) (models.AppQuota, error) {
+	const op = "storage.sqlite.AppQuota"
+
+	defer s.observer.Observe(op)()
+
+	quota := models.AppQuota{AppID: appID}
+	var maxUsers sql.NullInt64
+	err := s.reader.QueryRowContext(ctx, `
+		SELECT a.max_users, (SELECT COUNT(*) FROM users u WHERE u.app_id = a.id)
+		FROM apps a
+		WHERE a.id = ?`, appID,
+	).Scan(&maxUsers, &quota.Users)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.AppQuota{}, errs.Wrap(op, errs.ErrAppNotFound)
+	}
+	if err != nil {
+		return models.AppQuota{}, errs.Wrap(op, err)
+	}
+	quota.MaxUsers = int(maxUsers.Int64)
+
+	return quota, nil
+}
@@ -0,0 +1,92 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SaveRegistration saves the user of reg together with its invite use,
+// terms acceptance and outbox event, all or nothing, and returns its ID.
+//
+// If the user exists, returns errs.ErrUserExists; if another user has the
+// canonical email of reg, errs.ErrCanonicalEmailExists. If the invite
+// does not exist or has no uses left, returns errs.ErrInviteNotFound or
+// errs.ErrInviteUsedUp. If the app does not exist, returns
+// errs.ErrAppNotFound. If its quota is reached, returns
+// errs.ErrQuotaExceeded.
+func (s *Storage) SaveRegistration(ctx context.Context, reg models.Registration) (int64, error) {
+	const op = "storage.sqlite.SaveRegistration"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if reg.InviteID != 0 {
+		if err := takeInviteUse(ctx, tx, reg.InviteID); err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+	}
+
+	id, err := s.insertUser(ctx, tx, reg.AppID, reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	// Set apart from the insert: an exact duplicate fails there first, so
+	// a conflict here is on the canonical form only.
+	if reg.CanonicalEmail != "" {
+		taken, err := s.canonicalEmailTaken(ctx, tx, reg.CanonicalEmail, id)
+		if err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+		if taken {
+			return 0, errs.Wrap(op, errs.ErrCanonicalEmailExists)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET canonical_email = ? WHERE id = ?", reg.CanonicalEmail, id,
+		); err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return 0, errs.Wrap(op, errs.ErrCanonicalEmailExists)
+			}
+
+			return 0, errs.Wrap(op, err)
+		}
+	}
+
+	if reg.InviteID != 0 {
+		if err := insertInviteUse(ctx, tx, reg.InviteID, id, reg.RegisteredAt); err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+	}
+	if reg.TermsVersion != "" {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET tos_version_accepted = ?, tos_accepted_at = ?, updated_at = ? WHERE id = ?",
+			reg.TermsVersion, reg.RegisteredAt.UnixMilli(), time.Now().UnixMilli(), id,
+		); err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+	}
+	if event := reg.Event; event.Type != "" {
+		if _, err := tx.ExecContext(ctx, insertEventQuery,
+			event.Type, id, nullInt64(int64(event.AppID)), event.Payload, event.CreatedAt.UnixMilli(),
+		); err != nil {
+			return 0, errs.Wrap(op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
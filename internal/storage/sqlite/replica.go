@@ -0,0 +1,423 @@
+package sqlite
+
+// Replica.
+//
+// With Options.Replica, the rows of replicatedTables that a commit of the
+// writer changed are copied to a secondary database file right after the
+// commit, as a warm standby. The update hook of the writer connection
+// records the changed rows and its commit hook hands them to a goroutine,
+// which reads their current state from the primary and writes it to the
+// replica in one transaction. Reading the current state makes a copy
+// idempotent: a row changed by a transaction that failed to commit is
+// copied unchanged.
+//
+// Replication is best effort. A failed copy is logged, counted and
+// retried with the next commit, but never fails the write, and what has
+// not been copied when the process stops is lost. Deletions by the
+// truncate optimization of SQLite are not seen either. ReconcileReplica,
+// run by ssoctl replica reconcile, compares the two files and repairs the
+// replica. The replica must start as a copy of the primary, such as an
+// ssoctl backup: rows are matched by rowid, which it copies.
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/lib/metrics"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// replicatedTables are the tables copied to the replica: the accounts,
+// the apps and what authorizes them.
+var replicatedTables = []string{
+	"users",
+	"apps",
+	"app_redirect_uris",
+	"roles",
+	"enrollments",
+	"org_units",
+	"permissions",
+	"role_permissions",
+	"signing_keys",
+}
+
+// replicaChange is a row changed on the primary.
+type replicaChange struct {
+	table string
+	rowid int64
+}
+
+type replicator struct {
+	log     *slog.Logger
+	primary *sql.DB
+	replica *sql.DB
+
+	mu sync.Mutex
+	// changed are the rows of the open transaction of the writer, pending
+	// those of committed ones not copied yet.
+	changed []replicaChange
+	pending map[replicaChange]struct{}
+	columns map[string][]string
+
+	signal chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+
+	applied  *metrics.Counter
+	failures *metrics.Counter
+}
+
+func newReplicator(log *slog.Logger, path, key string) (*replicator, error) {
+	// Rows arrive in no particular order, so the replica does not enforce
+	// the REFERENCES of the schema.
+	replica, err := open(path, key, busyTimeoutPragma)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replica: %w", err)
+	}
+	replica.SetMaxOpenConns(1)
+
+	if log == nil {
+		log = slog.New(slog.DiscardHandler)
+	}
+
+	return &replicator{
+		log:      log.With(slog.String("op", "storage.sqlite.replicate")),
+		replica:  replica,
+		pending:  make(map[replicaChange]struct{}),
+		columns:  make(map[string][]string),
+		signal:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		exited:   make(chan struct{}),
+		applied:  metrics.NewCounter("storage_replica_rows_total"),
+		failures: metrics.NewCounter("storage_replica_failures_total"),
+	}, nil
+}
+
+// hook registers the hooks recording the changes on conn, the writer.
+func (r *replicator) hook(conn *sqlite3.SQLiteConn) {
+	conn.RegisterUpdateHook(func(_ int, db, table string, rowid int64) {
+		if db != "main" || !slices.Contains(replicatedTables, table) {
+			return
+		}
+		r.mu.Lock()
+		r.changed = append(r.changed, replicaChange{table: table, rowid: rowid})
+		r.mu.Unlock()
+	})
+	conn.RegisterCommitHook(func() int {
+		r.mu.Lock()
+		for _, c := range r.changed {
+			r.pending[c] = struct{}{}
+		}
+		n := len(r.changed)
+		r.changed = r.changed[:0]
+		r.mu.Unlock()
+
+		if n > 0 {
+			select {
+			case r.signal <- struct{}{}:
+			default:
+			}
+		}
+
+		return 0
+	})
+	conn.RegisterRollbackHook(func() {
+		r.mu.Lock()
+		r.changed = r.changed[:0]
+		r.mu.Unlock()
+	})
+}
+
+// start copies the pending changes read from primary until stop.
+func (r *replicator) start(primary *sql.DB) {
+	r.primary = primary
+
+	go func() {
+		defer close(r.exited)
+
+		for {
+			select {
+			case <-r.signal:
+				r.flush(context.Background())
+			case <-r.done:
+				// Whatever committed last still gets its chance.
+				r.flush(context.Background())
+
+				return
+			}
+		}
+	}()
+}
+
+// stop copies the last pending changes and closes the replica.
+func (r *replicator) stop() error {
+	close(r.done)
+	<-r.exited
+
+	return r.replica.Close()
+}
+
+// replicaRow is the state of a changed row on the primary, nil values if
+// it has been deleted.
+type replicaRow struct {
+	replicaChange
+	values []any
+}
+
+// flush copies the pending changes. Those that fail stay pending.
+func (r *replicator) flush(ctx context.Context) {
+	r.mu.Lock()
+	changes := make([]replicaChange, 0, len(r.pending))
+	for c := range r.pending {
+		changes = append(changes, c)
+	}
+	clear(r.pending)
+	r.mu.Unlock()
+
+	if len(changes) == 0 {
+		return
+	}
+
+	err := r.copy(ctx, changes)
+	if err == nil {
+		r.applied.Add(int64(len(changes)))
+
+		return
+	}
+
+	r.failures.Inc()
+	r.log.Error("failed to copy changes to the replica", slog.Int("rows", len(changes)), slog.Any("error", err))
+
+	r.mu.Lock()
+	for _, c := range changes {
+		r.pending[c] = struct{}{}
+	}
+	r.mu.Unlock()
+}
+
+func (r *replicator) copy(ctx context.Context, changes []replicaChange) error {
+	// The writer connection is taken once the commit that signaled has
+	// returned, so the rows are read as committed.
+	rows := make([]replicaRow, 0, len(changes))
+	for _, c := range changes {
+		values, err := r.read(ctx, c)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, replicaRow{replicaChange: c, values: values})
+	}
+
+	tx, err := r.replica.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, row := range rows {
+		// The columns were read by read.
+		columns := r.columns[row.table]
+		if row.values == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM "+row.table+" WHERE rowid = ?", row.rowid)
+		} else {
+			_, err = tx.ExecContext(ctx, upsert(row.table, columns)+"VALUES (?"+strings.Repeat(", ?", len(columns))+")", row.values...)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %d: %w", row.table, row.rowid, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// read returns the rowid and the columns, in the order of r.columns,
+// of the changed row on the primary, nil if it has been deleted.
+func (r *replicator) read(ctx context.Context, c replicaChange) ([]any, error) {
+	columns, ok := r.columns[c.table]
+	if !ok {
+		var err error
+		if columns, err = tableColumns(ctx, r.primary, "main", c.table); err != nil {
+			return nil, err
+		}
+		r.columns[c.table] = columns
+	}
+
+	values := make([]any, len(columns)+1)
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	query := "SELECT rowid, " + strings.Join(columns, ", ") + " FROM " + c.table + " WHERE rowid = ?"
+	err := r.primary.QueryRowContext(ctx, query, c.rowid).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %d: %w", c.table, c.rowid, err)
+	}
+
+	return values, nil
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// tableColumns returns the columns of table in schema, but the one
+// aliasing its rowid: the rowid is copied on its own, and with it the
+// alias.
+func tableColumns(ctx context.Context, db querier, schema, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, type, pk FROM pragma_table_info(?, ?)", table, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	alias, keys := "", 0
+	for rows.Next() {
+		var name, typ string
+		var pk int
+		if err := rows.Scan(&name, &typ, &pk); err != nil {
+			return nil, err
+		}
+		if pk > 0 {
+			keys++
+			if strings.EqualFold(typ, "INTEGER") {
+				alias = name
+			}
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no table %s.%s", schema, table)
+	}
+
+	if keys == 1 && alias != "" {
+		columns = slices.DeleteFunc(columns, func(c string) bool { return c == alias })
+	}
+
+	return columns, nil
+}
+
+// upsert starts a statement writing the rowid and columns of rows to
+// table, replacing the row of that rowid or of any unique key they take.
+func upsert(table string, columns []string) string {
+	return "INSERT OR REPLACE INTO " + table + " (rowid, " + strings.Join(columns, ", ") + ") "
+}
+
+// ReplicaStats returns the rows copied to the replica, the failed copies
+// and the rows waiting for a copy. Nil without a replica.
+func (s *Storage) ReplicaStats() map[string]int64 {
+	if s.replica == nil {
+		return nil
+	}
+
+	s.replica.mu.Lock()
+	pending := len(s.replica.pending)
+	s.replica.mu.Unlock()
+
+	return map[string]int64{
+		"copied":   s.replica.applied.Value(),
+		"failures": s.replica.failures.Value(),
+		"pending":  int64(pending),
+	}
+}
+
+// ReconcileReplica compares the replicated tables of the storage with
+// those of the replica at path and, unless dryRun, makes the replica
+// match: rows missing or different there are copied, rows the storage no
+// longer has are deleted. The replica must have the schema of the
+// storage.
+func (s *Storage) ReconcileReplica(ctx context.Context, path string, dryRun bool) ([]models.ReplicaDiff, error) {
+	const op = "storage.sqlite.ReconcileReplica"
+
+	defer s.observer.Observe(op)()
+
+	conn, err := s.writer.Conn(ctx)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer conn.Close()
+
+	attach := "ATTACH DATABASE ? AS replica"
+	args := []any{path}
+	if s.key != "" {
+		attach += " KEY ?"
+		args = append(args, s.key)
+	}
+	if _, err := conn.ExecContext(ctx, attach, args...); err != nil {
+		return nil, errs.Wrap(op, fmt.Errorf("failed to attach replica: %w", err))
+	}
+	defer func() { _, _ = conn.ExecContext(context.Background(), "DETACH DATABASE replica") }()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	diffs := make([]models.ReplicaDiff, 0, len(replicatedTables))
+	for _, table := range replicatedTables {
+		diff, err := reconcileTable(ctx, tx, table, dryRun)
+		if err != nil {
+			return nil, errs.Wrap(op, fmt.Errorf("%s: %w", table, err))
+		}
+		diffs = append(diffs, diff)
+	}
+
+	if !dryRun {
+		if err := tx.Commit(); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+	}
+
+	return diffs, nil
+}
+
+func reconcileTable(ctx context.Context, tx *sql.Tx, table string, dryRun bool) (models.ReplicaDiff, error) {
+	columns, err := tableColumns(ctx, tx, "main", table)
+	if err != nil {
+		return models.ReplicaDiff{}, err
+	}
+	if _, err := tableColumns(ctx, tx, "replica", table); err != nil {
+		return models.ReplicaDiff{}, err
+	}
+
+	list := "rowid, " + strings.Join(columns, ", ")
+	differing := "SELECT rowid FROM (SELECT " + list + " FROM main." + table +
+		" EXCEPT SELECT " + list + " FROM replica." + table + ")"
+	extra := "SELECT rowid FROM replica." + table + " WHERE rowid NOT IN (SELECT rowid FROM main." + table + ")"
+
+	diff := models.ReplicaDiff{Table: table}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+differing+")").Scan(&diff.Copied); err != nil {
+		return models.ReplicaDiff{}, err
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+extra+")").Scan(&diff.Deleted); err != nil {
+		return models.ReplicaDiff{}, err
+	}
+	if dryRun {
+		return diff, nil
+	}
+
+	insert := upsert("replica."+table, columns) + "SELECT " + list + " FROM main." + table + " WHERE rowid IN (" + differing + ")"
+	if _, err := tx.ExecContext(ctx, insert); err != nil {
+		return models.ReplicaDiff{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM replica."+table+" WHERE rowid IN ("+extra+")"); err != nil {
+		return models.ReplicaDiff{}, err
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,65 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+)
+
+// RevokeToken adds the jti of a token valid until expiresAt to the
+// denylist. Revoking it again changes nothing.
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeToken"
+
+	defer s.observer.Observe(op)()
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?) ON CONFLICT (jti) DO NOTHING",
+		jti, expiresAt.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// TokenRevoked reports whether the jti is on the denylist.
+func (s *Storage) TokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.sqlite.TokenRevoked"
+
+	defer s.observer.Observe(op)()
+
+	var one int
+	err := s.reader.QueryRowContext(ctx, "SELECT 1 FROM revoked_tokens WHERE jti = ?", jti).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errs.Wrap(op, err)
+	}
+
+	return true, nil
+}
+
+// PurgeRevokedTokens deletes the revocations of the tokens expired before
+// now and returns how many it deleted.
+func (s *Storage) PurgeRevokedTokens(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.PurgeRevokedTokens"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < ?", now.UnixMilli())
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return n, nil
+}
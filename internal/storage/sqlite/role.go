@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// AssignRoles makes role the global role of every given user, in place of
+// the ones they had, in one transaction. Their enrollments in org units
+// are left as they are. If no user was ever given role, returns
+// errs.ErrRoleNotFound and assigns nothing.
+func (s *Storage) AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error) {
+	const op = "storage.sqlite.AssignRoles"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var roleID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM roles WHERE role = ? ORDER BY id LIMIT 1", role).Scan(&roleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.Wrap(op, errs.ErrRoleNotFound)
+	}
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	res := make(map[int64]models.RoleOutcome, len(userIDs))
+	for _, id := range userIDs {
+		var current sql.NullString
+		err := tx.QueryRowContext(ctx, userRoleQuery, id).Scan(&current)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			res[id] = models.RoleUserNotFound
+
+			continue
+		case err != nil:
+			return nil, errs.Wrap(op, err)
+		case current.Valid && current.String == role:
+			res[id] = models.RoleAlreadyHad
+
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM enrollments WHERE user_id = ? AND org_unit_id IS NULL", id,
+		); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO enrollments (user_id, role_id) VALUES (?, ?)", id, roleID,
+		); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		res[id] = models.RoleAssigned
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return res, nil
+}
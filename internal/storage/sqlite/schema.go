@@ -0,0 +1,193 @@
+package sqlite
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// manifestText lists the tables and columns of the schema at
+// SchemaVersion. TestSchemaManifest regenerates it from the migrations:
+// run it with -update-schema after adding one.
+//
+//go:embed schema.txt
+var manifestText string
+
+// schemaManifest is the parsed manifestText.
+type schemaManifest struct {
+	version int
+	// tables maps the tables to their columns, in the order created.
+	tables map[string][]string
+}
+
+var manifest = mustParseManifest(manifestText)
+
+// parseManifest reads a manifest of "version N" and then a line per table,
+// "table: column, column", ignoring blank lines and # comments.
+func parseManifest(text string) (schemaManifest, error) {
+	m := schemaManifest{version: -1, tables: make(map[string][]string)}
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case m.version < 0:
+			v, ok := strings.CutPrefix(line, "version ")
+			version, err := strconv.Atoi(v)
+			if !ok || err != nil {
+				return schemaManifest{}, fmt.Errorf("line %d: want the version first, got %q", n, line)
+			}
+			m.version = version
+
+			continue
+		}
+
+		table, columns, ok := strings.Cut(line, ":")
+		table = strings.TrimSpace(table)
+		if !ok || table == "" {
+			return schemaManifest{}, fmt.Errorf("line %d: want table: columns, got %q", n, line)
+		}
+		if _, ok := m.tables[table]; ok {
+			return schemaManifest{}, fmt.Errorf("line %d: table %s listed twice", n, table)
+		}
+		for col := range strings.SplitSeq(columns, ",") {
+			if col = strings.TrimSpace(col); col != "" {
+				m.tables[table] = append(m.tables[table], col)
+			}
+		}
+	}
+	if m.version < 0 {
+		return schemaManifest{}, fmt.Errorf("no version")
+	}
+
+	return m, sc.Err()
+}
+
+func mustParseManifest(text string) schemaManifest {
+	m, err := parseManifest(text)
+	if err != nil {
+		panic("sqlite: schema manifest: " + err.Error())
+	}
+
+	return m
+}
+
+// render writes m in the format parseManifest reads, tables sorted.
+func (m schemaManifest) render() string {
+	var b strings.Builder
+	b.WriteString("# Generated by TestSchemaManifest from the migrations; do not edit.\n")
+	fmt.Fprintf(&b, "version %d\n\n", m.version)
+
+	names := make([]string, 0, len(m.tables))
+	for name := range m.tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(m.tables[name], ", "))
+	}
+
+	return b.String()
+}
+
+// VerifySchema compares the schema of the storage with what the code
+// relies on: the migrations recorded in table, the migrator's bookkeeping
+// table, must be at SchemaVersion at least and none failed halfway, and
+// the tables and columns of the manifest at SchemaVersion must all be
+// there. It fails only if the schema cannot be read; drift is reported in
+// the result.
+func (s *Storage) VerifySchema(ctx context.Context, table string) (models.SchemaCheck, error) {
+	const op = "storage.sqlite.VerifySchema"
+
+	res := models.SchemaCheck{Want: SchemaVersion}
+
+	version, dirty, err := s.MigrationVersion(ctx, table)
+	if err != nil {
+		return res, errs.Wrap(op, err)
+	}
+	res.Version, res.Dirty = version, dirty
+
+	actual, err := s.schema(ctx, table)
+	if err != nil {
+		return res, errs.Wrap(op, err)
+	}
+	res.Missing = manifest.missing(actual)
+
+	return res, nil
+}
+
+// missing lists what m has that actual lacks, as SchemaCheck.Missing.
+func (m schemaManifest) missing(actual schemaManifest) []string {
+	var missing []string
+	for table, columns := range m.tables {
+		have, ok := actual.tables[table]
+		if !ok {
+			missing = append(missing, "table "+table)
+
+			continue
+		}
+		for _, col := range columns {
+			if !slices.Contains(have, col) {
+				missing = append(missing, "column "+table+"."+col)
+			}
+		}
+	}
+	slices.Sort(missing)
+
+	return missing
+}
+
+// schema reads the tables and columns of the storage, but for those of
+// SQLite itself and the migrations table.
+func (s *Storage) schema(ctx context.Context, migrationsTable string) (schemaManifest, error) {
+	defer s.observer.Observe("storage.sqlite.schema")()
+
+	rows, err := s.reader.QueryContext(ctx, `
+		SELECT m.name, p.name
+		FROM sqlite_master AS m, pragma_table_info(m.name) AS p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite\_%' ESCAPE '\' AND m.name != ?
+		ORDER BY m.name, p.cid`,
+		migrationsTable,
+	)
+	if err != nil {
+		return schemaManifest{}, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	res := schemaManifest{version: -1, tables: make(map[string][]string)}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return schemaManifest{}, err
+		}
+		res.tables[table] = append(res.tables[table], column)
+	}
+
+	return res, rows.Err()
+}
+
+// schemaDriftError describes how check differs from the schema the code
+// relies on.
+func schemaDriftError(check models.SchemaCheck) error {
+	var problems []string
+	switch {
+	case check.Dirty:
+		problems = append(problems, fmt.Sprintf("migration %d failed and must be fixed by hand", check.Version))
+	case check.Version < check.Want:
+		problems = append(problems, fmt.Sprintf("schema version is %d, want %d", check.Version, check.Want))
+	}
+	if len(check.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(check.Missing, ", "))
+	}
+
+	return &errs.Error{Code: errs.SchemaDrift, Message: strings.Join(problems, "; ")}
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveSigningKey stores a new signing key.
+func (s *Storage) SaveSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.sqlite.SaveSigningKey"
+
+	defer s.observer.Observe(op)()
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO signing_keys (id, private_key, created_at) VALUES (?, ?, ?)",
+		key.ID, key.PrivateKey, key.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// SigningKeys returns at most limit newest signing keys, newest first.
+func (s *Storage) SigningKeys(ctx context.Context, limit int) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKeys"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx,
+		"SELECT id, private_key, created_at FROM signing_keys ORDER BY created_at DESC, rowid DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var (
+			key       models.SigningKey
+			createdAt int64
+		)
+		if err := rows.Scan(&key.ID, &key.PrivateKey, &createdAt); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		key.CreatedAt = time.UnixMilli(createdAt)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return keys, nil
+}
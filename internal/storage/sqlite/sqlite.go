@@ -5,27 +5,308 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sso/internal/domain/errs"
 	"sso/internal/domain/models"
 	"sso/internal/storage"
 
 	"github.com/mattn/go-sqlite3"
 )
 
+const (
+	initialBackoff = 100 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+)
+
+// Storage keeps two handles to the same database file. SQLite serializes
+// writers anyway, so all writes go through a single connection while reads
+// use a pool that, thanks to WAL mode, never waits for the writer.
+//
+// In-memory DSNs are not supported: every connection would see its own
+// database.
 type Storage struct {
-	db *sql.DB
+	writer   *sql.DB
+	reader   *sql.DB
+	observer *storage.QueryObserver
+	key      string
+	stmts    *statements
+	// copies is held for reading by backups and for writing by Vacuum.
+	copies  sync.RWMutex
+	lock    *instanceLock
+	shared  bool
+	replica *replicator
+	compat  Compat
+}
+
+// Options configures how the storage is opened.
+type Options struct {
+	// ConnectTimeout bounds how long New keeps retrying the initial ping.
+	// Zero means a single attempt.
+	ConnectTimeout time.Duration
+	// CreateDir creates the parent directory of the storage file when missing.
+	CreateDir bool
+	// Observer times every query. Nil disables timing.
+	Observer *storage.QueryObserver
+	// Key is the SQLCipher key of an encrypted storage file. Empty means
+	// the file is plaintext. See cipher.go for the build requirements.
+	Key string
+	// ReadConns is the size of the reader pool. Zero routes reads through
+	// the writer connection.
+	ReadConns int
+	// Exclusive holds the lock file of the storage while it is open and
+	// fails with ErrLocked if another instance holds it. See lock.go.
+	Exclusive bool
+	// AllowShared opens an Exclusive storage another instance holds
+	// anyway, as reported by Shared.
+	AllowShared bool
+	// Replica is the path of a secondary database file the writes to the
+	// accounts, apps and roles are copied to, best effort. Empty disables
+	// replication. See replica.go.
+	Replica string
+	// Log logs the failures to copy to the Replica. Nil discards them.
+	Log *slog.Logger
+	// Compat is the phase of the changes of columns in progress, see
+	// Phase. The zero Compat expands them all.
+	Compat Compat
 }
 
-// New creates a new instance of SQLite storage
-func New(storagePath string) (*Storage, error) {
+const (
+	busyTimeoutPragma = "busy_timeout = 5000"
+	// foreignKeysPragma enforces the REFERENCES of the schema, which SQLite
+	// ignores by default on every new connection.
+	foreignKeysPragma = "foreign_keys = ON"
+)
+
+// New creates a new instance of SQLite storage.
+//
+// sql.Open does not touch the file, so New pings the database with
+// exponential backoff until it answers or opts.ConnectTimeout elapses.
+func New(storagePath string, opts Options) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	if opts.CreateDir {
+		if err := createParentDir(storagePath); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+	}
+
+	var lock *instanceLock
+	shared := false
+	if opts.Exclusive && filePath(storagePath) != "" {
+		var err error
+		lock, err = acquireLock(storagePath)
+		switch {
+		case errors.Is(err, ErrLocked) && opts.AllowShared:
+			shared = true
+		case err != nil:
+			return nil, errs.Wrap(op, err)
+		}
+	}
+
+	var replica *replicator
+	var hook func(*sqlite3.SQLiteConn)
+	if opts.Replica != "" {
+		var err error
+		if replica, err = newReplicator(opts.Log, opts.Replica, opts.Key); err != nil {
+			_ = lock.release()
+
+			return nil, errs.Wrap(op, err)
+		}
+		hook = replica.hook
+	}
+	fail := func(err error) (*Storage, error) {
+		if replica != nil {
+			_ = replica.replica.Close()
+		}
+		_ = lock.release()
+
+		return nil, errs.Wrap(op, err)
+	}
+
+	// The writer goes first: it switches the file to WAL mode, which is
+	// persistent and lets the readers proceed.
+	writer, err := connect(storagePath, opts, 1, hook, "journal_mode = WAL", busyTimeoutPragma, foreignKeysPragma)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return fail(err)
+	}
+
+	reader := writer
+	if opts.ReadConns > 0 {
+		reader, err = connect(storagePath, opts, opts.ReadConns, nil, "query_only = 1", busyTimeoutPragma, foreignKeysPragma)
+		if err != nil {
+			_ = writer.Close()
+
+			return fail(err)
+		}
+	}
+	if replica != nil {
+		replica.start(writer)
+	}
+
+	return &Storage{
+		writer:   writer,
+		reader:   reader,
+		observer: opts.Observer,
+		key:      opts.Key,
+		stmts:    newStatements(writer, reader),
+		lock:     lock,
+		shared:   shared,
+		replica:  replica,
+		compat:   opts.Compat,
+	}, nil
+}
+
+// connect opens a pool of at most maxConns connections and waits until it
+// answers.
+func connect(
+	storagePath string,
+	opts Options,
+	maxConns int,
+	hook func(*sqlite3.SQLiteConn),
+	pragmas ...string,
+) (*sql.DB, error) {
+	db, err := openHooked(storagePath, opts.Key, hook, pragmas...)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(maxConns)
+
+	if err := ping(db, opts.ConnectTimeout); err != nil {
+		_ = db.Close()
+
+		return nil, fmt.Errorf("failed to open %q: %w", storagePath, err)
+	}
+
+	if opts.Key != "" {
+		if err := verifyKey(context.Background(), db); err != nil {
+			_ = db.Close()
+
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// MustNew creates a new instance of SQLite storage and panics on failure.
+// It is intended for tests only.
+func MustNew(storagePath string) *Storage {
+	s, err := New(storagePath, Options{ReadConns: 4})
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// Stats returns connection pool statistics of both handles.
+func (s *Storage) Stats() map[string]sql.DBStats {
+	return map[string]sql.DBStats{
+		"writer": s.writer.Stats(),
+		"reader": s.reader.Stats(),
+	}
+}
+
+// Shared reports whether the storage was opened with Options.AllowShared
+// while another instance held it. Such an instance should leave the
+// background writes, such as maintenance, to the holder.
+func (s *Storage) Shared() bool {
+	return s.shared
+}
+
+// Close closes the prepared statements and both database handles, and
+// releases the instance lock. The last changes are copied to the replica
+// first.
+func (s *Storage) Close() error {
+	if s.replica != nil {
+		_ = s.replica.stop()
+	}
+	_ = s.stmts.close()
+	defer func() { _ = s.lock.release() }()
+
+	if s.reader != s.writer {
+		if err := s.reader.Close(); err != nil {
+			_ = s.writer.Close()
+
+			return err
+		}
+	}
+
+	return s.writer.Close()
+}
+
+// ping pings the database until it succeeds or timeout elapses.
+// A non-positive timeout means a single attempt.
+func ping(db *sql.DB, timeout time.Duration) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if timeout <= 0 || ctx.Err() != nil {
+			return fmt.Errorf("ping failed after %d attempt(s): %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("ping failed after %d attempt(s): %w", attempt, err)
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// createParentDir creates the directory holding the storage file.
+// DSNs with query parameters and in-memory databases are left untouched.
+func createParentDir(storagePath string) error {
+	path := filePath(storagePath)
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return nil
+}
+
+// filePath returns the file of a storage path or DSN, empty for in-memory
+// databases.
+func filePath(storagePath string) string {
+	path := strings.TrimPrefix(storagePath, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == ":memory:" {
+		return ""
 	}
 
-	return &Storage{db: db}, nil
+	return path
+}
+
+// optional stores the empty string of an optional column, such as
+// users.middle_name, as NULL. Reads take NULL and an empty string
+// alike for none.
+func optional(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
 }
 
 func (s *Storage) SaveUser(
@@ -38,27 +319,29 @@ func (s *Storage) SaveUser(
 ) (int64, error) {
 	const op = "storage.sqlite.SaveUser"
 
-	stmp, err := s.db.Prepare(
-		"INSERT INTO users (email, pass_hash, first_name, last_name, middle_name) VALUES (?, ?, ?, ?, ?)",
-	)
+	defer s.observer.Observe(op)()
+
+	stmt, err := s.stmts.insertUser.get()
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, errs.Wrap(op, err)
 	}
 
-	res, err := stmp.ExecContext(ctx, email, passHash, firstName, lastName, middleName)
+	now := time.Now().UnixMilli()
+	passHash, version := storedHash(s.compat.HashVersion, passHash)
+	res, err := stmt.ExecContext(ctx, email, passHash, version, firstName, lastName, optional(middleName), now, now)
 	if err != nil {
 		var sqliteErr sqlite3.Error
 
 		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return 0, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+			return 0, errs.Wrap(op, errs.ErrUserExists)
 		}
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, errs.Wrap(op, err)
 	}
 
 	id, err := res.LastInsertId()
 	if err != nil {
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, errs.Wrap(op, err)
 	}
 
 	return id, nil
@@ -68,98 +351,219 @@ func (s *Storage) SaveUser(
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmp, err := s.db.Prepare(
-		"SELECT id, email, pass_hash, first_name, last_name, middle_name FROM users WHERE email = ?",
-	)
+	defer s.observer.Observe(op)()
+
+	return s.user(ctx, op, s.stmts.userByEmail, email)
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	defer s.observer.Observe(op)()
+
+	return s.user(ctx, op, s.stmts.userByID, userID)
+}
+
+// user returns the user p, a query of userColumns, finds by key.
+func (s *Storage) user(ctx context.Context, op string, p *stmt, key any) (models.User, error) {
+	stmt, err := p.get()
 	if err != nil {
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, errs.Wrap(op, err)
 	}
 
-	res := stmp.QueryRowContext(ctx, email)
-
 	var user models.User
-	err = res.Scan(&user.ID, &user.Email, &user.PassHash, &user.FirstName, &user.LastName, &user.MiddleName)
+	var tokenTTL, termsAcceptedAt, createdAt, updatedAt, deleteAfter, sessionsRevokedAt sql.NullInt64
+	var middleName, termsVersion, hashVersion sql.NullString
+	err = stmt.QueryRowContext(ctx, key).Scan(
+		&user.ID, &user.Email, &user.PassHash, &hashVersion, &user.FirstName, &user.LastName, &middleName,
+		&tokenTTL, &termsVersion, &termsAcceptedAt, &createdAt, &updatedAt, &deleteAfter, &sessionsRevokedAt,
+		&user.ActivationPending,
+	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.User{}, storage.ErrUserNotFound
+			return models.User{}, errs.ErrUserNotFound
 		}
 
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, errs.Wrap(op, err)
+	}
+	user.PassHash = loadedHash(user.PassHash, hashVersion)
+	user.MiddleName = middleName.String
+	user.TokenTTL = time.Duration(tokenTTL.Int64) * time.Second
+	user.TermsVersion = termsVersion.String
+	if termsAcceptedAt.Valid {
+		user.TermsAcceptedAt = time.UnixMilli(termsAcceptedAt.Int64)
+	}
+	if createdAt.Valid {
+		user.CreatedAt = time.UnixMilli(createdAt.Int64)
+	}
+	if updatedAt.Valid {
+		user.UpdatedAt = time.UnixMilli(updatedAt.Int64)
+	}
+	if deleteAfter.Valid {
+		user.DeleteAfter = time.UnixMilli(deleteAfter.Int64)
 	}
+	if sessionsRevokedAt.Valid {
+		user.SessionsRevokedAt = time.UnixMilli(sessionsRevokedAt.Int64)
+	}
+
 	return user, nil
 }
 
+// SetUserTokenTTL sets the token lifetime override of the user. Zero
+// removes the override.
+func (s *Storage) SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error {
+	const op = "storage.sqlite.SetUserTokenTTL"
+
+	defer s.observer.Observe(op)()
+
+	var seconds sql.NullInt64
+	if ttl > 0 {
+		seconds = sql.NullInt64{Int64: int64(ttl / time.Second), Valid: true}
+	}
+
+	res, err := s.writer.ExecContext(ctx, "UPDATE users SET token_ttl_seconds = ?, updated_at = ? WHERE id = ?",
+		seconds, time.Now().UnixMilli(), userID,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if affected == 0 {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// AcceptTerms records that the user accepted version of the terms of
+// service at acceptedAt.
+func (s *Storage) AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error {
+	const op = "storage.sqlite.AcceptTerms"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx,
+		"UPDATE users SET tos_version_accepted = ?, tos_accepted_at = ?, updated_at = ? WHERE id = ?",
+		version, acceptedAt.UnixMilli(), time.Now().UnixMilli(), userID,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if affected == 0 {
+		return errs.Wrap(op, errs.ErrUserNotFound)
+	}
+
+	return nil
+}
+
 // UserExists returns true if user exists
 func (s *Storage) UserExists(ctx context.Context, userID int64) (bool, error) {
 	const op = "storage.sqlite.UserExists"
 
-	stmp, err := s.db.Prepare(
-		"SELECT 1 FROM users WHERE id = ? LIMIT 1",
-	)
+	defer s.observer.Observe(op)()
+
+	stmt, err := s.stmts.userExists.get()
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
+		return false, errs.Wrap(op, err)
 	}
 
-	row := stmp.QueryRowContext(ctx, userID)
+	row := stmt.QueryRowContext(ctx, userID)
 
-	err = row.Scan()
-
-	if err == sql.ErrNoRows {
+	var exists int
+	err = row.Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	}
 	if err != nil {
-		return false, fmt.Errorf("%s: %w", op, err)
+		return false, errs.Wrap(op, err)
 	}
 
 	return true, nil
 }
 
-// UserRole returns role of the user
+// UserRole returns role of the user.
+//
+// It returns errs.ErrUserNotFound when the user does not exist and
+// errs.ErrRoleNotFound when the user exists but has no enrollment.
 func (s *Storage) UserRole(ctx context.Context, userID int64) (string, error) {
 	const op = "storage.sqlite.UserRole"
 
-	stmp, err := s.db.Prepare(
-		"SELECT r.role FROM roles r INNER JOIN enrollments en ON r.id = en.role_id WHERE en.user_id = ?",
-	)
+	defer s.observer.Observe(op)()
+
+	stmt, err := s.stmts.userRole.get()
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", errs.Wrap(op, err)
 	}
 
-	res := stmp.QueryRowContext(ctx, userID)
+	res := stmt.QueryRowContext(ctx, userID)
 
-	var role string
+	var role sql.NullString
 	err = res.Scan(&role)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", storage.ErrUserNotFound
+			return "", errs.ErrUserNotFound
 		}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+		return "", errs.Wrap(op, err)
 	}
 
-	return role, nil
+	if !role.Valid {
+		return "", errs.ErrRoleNotFound
+	}
+
+	return role.String, nil
 }
 
-func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+func (s *Storage) App(ctx context.Context, appID int32) (models.App, error) {
 	const op = "storage.sqlite.App"
 
-	stmp, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
+	defer s.observer.Observe(op)()
+
+	return s.app(ctx, op, s.stmts.appByID, appID)
+}
+
+// AppByName returns the app registered under name. Names are unique, and
+// their constraint index serves the lookup.
+func (s *Storage) AppByName(ctx context.Context, name string) (models.App, error) {
+	const op = "storage.sqlite.AppByName"
+
+	defer s.observer.Observe(op)()
+
+	return s.app(ctx, op, s.stmts.appByName, name)
+}
+
+// app returns the app p, a query of appColumns, finds by key.
+func (s *Storage) app(ctx context.Context, op string, p *stmt, key any) (models.App, error) {
+	stmt, err := p.get()
 	if err != nil {
-		return models.App{}, fmt.Errorf("%s: %w", op, err)
+		return models.App{}, errs.Wrap(op, err)
 	}
 
-	res := stmp.QueryRowContext(ctx, appID)
+	res := stmt.QueryRowContext(ctx, key)
 
 	var app models.App
+	var tokenTTL, maxUsers sql.NullInt64
 
-	err = res.Scan(&app.ID, &app.Name, &app.Secret)
+	err = res.Scan(&app.ID, &app.Name, &app.Secret, &tokenTTL, &maxUsers, &app.BindTokens)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return models.App{}, storage.ErrAppNotFound
+			return models.App{}, errs.ErrAppNotFound
 		}
 
-		return models.App{}, fmt.Errorf("%s: %w", op, err)
+		return models.App{}, errs.Wrap(op, err)
 	}
+	app.TokenTTL = time.Duration(tokenTTL.Int64) * time.Second
+	app.MaxUsers = int(maxUsers.Int64)
 
 	return app, nil
 }
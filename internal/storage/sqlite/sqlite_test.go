@@ -0,0 +1,1118 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+	"sso/internal/storage/storagetest"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/mattn/go-sqlite3"
+)
+
+const migrationsPath = "../../../migrations"
+
+// newTestStorage returns a storage over a freshly migrated temporary file.
+func newTestStorage(tb testing.TB, opts Options) *Storage {
+	tb.Helper()
+
+	s, err := New(migratedFile(tb), opts)
+	if err != nil {
+		tb.Fatalf("failed to open storage: %v", err)
+	}
+	tb.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// migratedFile returns the path of a new database file with the
+// migrations applied.
+func migratedFile(tb testing.TB) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://"+migrationsPath, "sqlite3://"+path)
+	if err != nil {
+		tb.Fatalf("failed to init migrations: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		tb.Fatalf("failed to apply migrations: %v", err)
+	}
+	if srcErr, dbErr := m.Close(); srcErr != nil || dbErr != nil {
+		tb.Fatalf("failed to close migrations: %v, %v", srcErr, dbErr)
+	}
+
+	return path
+}
+
+// BenchmarkReadsUnderWriteLoad measures User latency while registrations
+// stream in. Compare the p99 of the single-handle and split setups.
+func BenchmarkReadsUnderWriteLoad(b *testing.B) {
+	for _, bb := range []struct {
+		name      string
+		readConns int
+	}{
+		{name: "single handle", readConns: 0},
+		{name: "read/write split", readConns: 4},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			s := newTestStorage(b, Options{ReadConns: bb.readConns})
+			ctx := context.Background()
+
+			const email = "reader@example.com"
+			if _, err := s.SaveUser(ctx, email, []byte("hash"), "First", "Last", ""); err != nil {
+				b.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; ctx.Err() == nil; i++ {
+					_, _ = s.SaveUser(ctx, fmt.Sprintf("writer-%d@example.com", i), []byte("hash"), "First", "Last", "")
+				}
+			}()
+
+			var mu sync.Mutex
+			latencies := make([]time.Duration, 0, b.N)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					start := time.Now()
+					if _, err := s.User(ctx, email); err != nil && ctx.Err() == nil {
+						b.Error(err)
+					}
+					elapsed := time.Since(start)
+
+					mu.Lock()
+					latencies = append(latencies, elapsed)
+					mu.Unlock()
+				}
+			})
+			b.StopTimer()
+
+			cancel()
+			wg.Wait()
+
+			slices.Sort(latencies)
+			if len(latencies) > 0 {
+				p99 := latencies[len(latencies)*99/100]
+				b.ReportMetric(float64(p99.Microseconds()), "p99-µs")
+			}
+		})
+	}
+}
+
+// BenchmarkRegistrationWrites measures the storage writes of a
+// registration with terms and an event: one after the other, as without
+// auth.WithRegistrations, or in the one transaction of SaveRegistration.
+// Compare their p99.
+func BenchmarkRegistrationWrites(b *testing.B) {
+	for _, bb := range []struct {
+		name     string
+		register func(ctx context.Context, s *Storage, reg models.Registration) error
+	}{
+		{
+			name: "separate writes",
+			register: func(ctx context.Context, s *Storage, reg models.Registration) error {
+				id, err := s.SaveUser(ctx, reg.Email, reg.PassHash, reg.FirstName, reg.LastName, reg.MiddleName)
+				if err != nil {
+					return err
+				}
+				reg.Event.UserID = id
+				if _, err := s.SaveEvent(ctx, reg.Event); err != nil {
+					return err
+				}
+
+				return s.AcceptTerms(ctx, id, reg.TermsVersion, reg.RegisteredAt)
+			},
+		},
+		{
+			name: "one transaction",
+			register: func(ctx context.Context, s *Storage, reg models.Registration) error {
+				_, err := s.SaveRegistration(ctx, reg)
+
+				return err
+			},
+		},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			s := newTestStorage(b, Options{})
+			ctx := context.Background()
+			latencies := make([]time.Duration, 0, b.N)
+
+			b.ResetTimer()
+			for i := range b.N {
+				reg := models.Registration{
+					Email:        fmt.Sprintf("user-%d@example.com", i),
+					PassHash:     []byte("hash"),
+					FirstName:    "First",
+					LastName:     "Last",
+					TermsVersion: "v1",
+					RegisteredAt: time.Now(),
+					Event: models.Event{
+						Type:      models.EventUserRegistered,
+						Payload:   []byte("{}"),
+						CreatedAt: time.Now(),
+					},
+				}
+
+				start := time.Now()
+				if err := bb.register(ctx, s, reg); err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+
+			slices.Sort(latencies)
+			p99 := latencies[len(latencies)*99/100]
+			b.ReportMetric(float64(p99.Microseconds()), "p99-µs")
+		})
+	}
+}
+
+type seededStorage struct {
+	*Storage
+}
+
+func (s seededStorage) SeedApp(ctx context.Context, app models.App) error {
+	var tokenTTL sql.NullInt64
+	if app.TokenTTL > 0 {
+		tokenTTL = sql.NullInt64{Int64: int64(app.TokenTTL / time.Second), Valid: true}
+	}
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO apps (id, name, secret, token_ttl_seconds) VALUES (?, ?, ?, ?)",
+		app.ID, app.Name, app.Secret, tokenTTL,
+	)
+	return err
+}
+
+func (s seededStorage) SeedRedirectURI(ctx context.Context, appID int32, uri string) error {
+	_, err := s.writer.ExecContext(ctx, "INSERT INTO app_redirect_uris (app_id, uri) VALUES (?, ?)", appID, uri)
+	return err
+}
+
+func (s seededStorage) SeedUserRole(ctx context.Context, userID int64, role string) error {
+	res, err := s.writer.ExecContext(ctx, "INSERT INTO roles (role) VALUES (?)", role)
+	if err != nil {
+		return err
+	}
+	roleID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.writer.ExecContext(ctx, "INSERT INTO enrollments (user_id, role_id) VALUES (?, ?)", userID, roleID)
+	return err
+}
+
+func (s seededStorage) SeedUnitRole(ctx context.Context, userID, unitID int64, role string) error {
+	res, err := s.writer.ExecContext(ctx, "INSERT INTO roles (role) VALUES (?)", role)
+	if err != nil {
+		return err
+	}
+	roleID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.writer.ExecContext(ctx,
+		"INSERT INTO enrollments (user_id, role_id, org_unit_id) VALUES (?, ?, ?)", userID, roleID, unitID,
+	)
+	return err
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformanceTests(t, func() storagetest.Storage {
+		return seededStorage{newTestStorage(t, Options{ReadConns: 4})}
+	})
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{ReadConns: 2})
+
+	if err := s.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	// newTestStorage migrates with the default bookkeeping table.
+	if err := s.CheckSchema(ctx, "schema_migrations"); err != nil {
+		t.Fatalf("CheckSchema on a migrated storage: %v", err)
+	}
+	if version, dirty, err := s.MigrationVersion(ctx, "schema_migrations"); err != nil || version != SchemaVersion || dirty {
+		t.Fatalf("MigrationVersion = %d, %v, %v, want %d, false, nil", version, dirty, err, SchemaVersion)
+	}
+	if err := s.CheckSchema(ctx, "no_such_table"); err == nil {
+		t.Fatal("CheckSchema without a migrations table: want error")
+	}
+
+	if _, err := s.writer.ExecContext(ctx, "UPDATE schema_migrations SET version = version - 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx, "schema_migrations"); err == nil {
+		t.Fatal("CheckSchema on an outdated schema: want error")
+	}
+
+	if _, err := s.writer.ExecContext(ctx, "UPDATE schema_migrations SET version = ?, dirty = 1", SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckSchema(ctx, "schema_migrations"); err == nil {
+		t.Fatal("CheckSchema on a dirty schema: want error")
+	}
+}
+
+func TestSchemaVersion_MatchesMigrations(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(migrationsPath, "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := 0
+	for _, f := range files {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(f), "%d_", &n); err != nil {
+			t.Fatalf("unexpected migration name %q", f)
+		}
+		latest = max(latest, n)
+	}
+
+	if latest != SchemaVersion {
+		t.Fatalf("SchemaVersion = %d, the newest migration is %d", SchemaVersion, latest)
+	}
+}
+
+func TestInvalidPassHashes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	var want []int64
+	for i := range exportPageSize + 2 {
+		hash := []byte("good")
+		if i%250 == 0 {
+			hash = []byte("bad")
+		}
+		id, err := s.SaveUser(ctx, fmt.Sprintf("user-%d@example.com", i), hash, "First", "Last", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(hash) == "bad" {
+			want = append(want, id)
+		}
+	}
+
+	got, err := s.InvalidPassHashes(ctx, func(hash []byte) error {
+		if string(hash) != "good" {
+			return errors.New("invalid")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("InvalidPassHashes: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("InvalidPassHashes = %v, want %v", got, want)
+	}
+}
+
+func TestMiddleName_StoredAsNull(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	id, err := s.SaveUser(ctx, "none@example.com", []byte("hash"), "First", "Last", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored sql.NullString
+	if err := s.writer.QueryRowContext(ctx, "SELECT middle_name FROM users WHERE id = ?", id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Valid {
+		t.Fatalf("middle_name = %q, want NULL", stored.String)
+	}
+
+	// Users saved before stored the empty string.
+	if _, err := s.writer.ExecContext(ctx, `
+		INSERT INTO users (email, pass_hash, first_name, last_name, middle_name)
+		VALUES ('legacy@example.com', X'00', 'First', 'Last', '')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"none@example.com", "legacy@example.com"} {
+		user, err := s.User(ctx, email)
+		if err != nil {
+			t.Fatalf("User(%s): %v", email, err)
+		}
+		if user.MiddleName != "" {
+			t.Fatalf("User(%s).MiddleName = %q, want empty", email, user.MiddleName)
+		}
+	}
+}
+
+func TestUserEmails_ChangeEmails(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	mixed, err := s.SaveUser(ctx, "John@Example.com", []byte("hash"), "John", "Doe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := s.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writer.ExecContext(ctx, "INSERT INTO apps (id, name, secret) VALUES (1, 'test', 'test-secret')"); err != nil {
+		t.Fatal(err)
+	}
+	loggedIn := time.UnixMilli(1700000000000)
+	if err := s.RecordLogin(ctx, models.LoginAttempt{UserID: mixed, AppID: 1, Success: true, At: loggedIn}); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := s.UserEmails(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("UserEmails: %v", err)
+	}
+	want := []models.UserEmail{
+		{ID: mixed, Email: "John@Example.com", LastLoginAt: loggedIn},
+		{ID: lower, Email: "jane@example.com"},
+	}
+	if !slices.Equal(users, want) {
+		t.Fatalf("UserEmails = %v, want %v", users, want)
+	}
+	if users, _ := s.UserEmails(ctx, mixed, 10); len(users) != 1 || users[0].ID != lower {
+		t.Fatalf("UserEmails after %d = %v", mixed, users)
+	}
+
+	// Taking an email in use changes nothing, not even the other rows.
+	_, err = s.ChangeEmails(ctx, []models.EmailChange{
+		{UserID: mixed, From: "John@Example.com", To: "john@example.com"},
+		{UserID: lower, From: "jane@example.com", To: "john@example.com"},
+	})
+	if !errors.Is(err, errs.ErrUserExists) {
+		t.Fatalf("ChangeEmails to a taken email: %v, want ErrUserExists", err)
+	}
+	if _, err := s.User(ctx, "John@Example.com"); err != nil {
+		t.Fatalf("the batch was not rolled back: %v", err)
+	}
+
+	// A stale From is skipped.
+	n, err := s.ChangeEmails(ctx, []models.EmailChange{
+		{UserID: mixed, From: "John@Example.com", To: "john@example.com"},
+		{UserID: lower, From: "Jane@Example.com", To: "jane@example.org"},
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("ChangeEmails = %d, %v, want 1, nil", n, err)
+	}
+	if user, err := s.User(ctx, "john@example.com"); err != nil || user.ID != mixed {
+		t.Fatalf("User after ChangeEmails = %v, %v", user, err)
+	}
+}
+
+func TestSaveRegistration_CanonicalEmail(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	reg := models.Registration{Email: "na.me@gmail.com", CanonicalEmail: "name@gmail.com", PassHash: []byte("hash"), FirstName: "John", LastName: "Doe"}
+	first, err := s.SaveRegistration(ctx, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The exact duplicate is told apart from the equivalent one.
+	if _, err := s.SaveRegistration(ctx, reg); !errors.Is(err, errs.ErrUserExists) {
+		t.Fatalf("SaveRegistration of the same email: %v, want ErrUserExists", err)
+	}
+	reg.Email = "name+trial@gmail.com"
+	if _, err := s.SaveRegistration(ctx, reg); !errors.Is(err, errs.ErrCanonicalEmailExists) {
+		t.Fatalf("SaveRegistration of an equivalent email: %v, want ErrCanonicalEmailExists", err)
+	}
+	if _, err := s.User(ctx, "name+trial@gmail.com"); !errors.Is(err, errs.ErrUserNotFound) {
+		t.Fatalf("the refused user was saved: %v", err)
+	}
+
+	// Users without one do not conflict.
+	second, err := s.SaveUser(ctx, "name@gmail.com", []byte("hash"), "Jane", "Doe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := s.UserEmails(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.UserEmail{
+		{ID: first, Email: "na.me@gmail.com", CanonicalEmail: "name@gmail.com"},
+		{ID: second, Email: "name@gmail.com"},
+	}
+	if !slices.Equal(users, want) {
+		t.Fatalf("UserEmails = %v, want %v", users, want)
+	}
+
+	// Backfilling it conflicts, until the first one is cleared.
+	_, err = s.SetCanonicalEmails(ctx, []models.EmailChange{{UserID: second, From: "name@gmail.com", To: "name@gmail.com"}})
+	if !errors.Is(err, errs.ErrCanonicalEmailExists) {
+		t.Fatalf("SetCanonicalEmails to a taken email: %v, want ErrCanonicalEmailExists", err)
+	}
+	n, err := s.SetCanonicalEmails(ctx, []models.EmailChange{
+		{UserID: first, From: "na.me@gmail.com"},
+		{UserID: second, From: "name@gmail.com", To: "name@gmail.com"},
+		{UserID: second, From: "stale@gmail.com", To: "stale@gmail.com"},
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("SetCanonicalEmails = %d, %v, want 2, nil", n, err)
+	}
+}
+
+// TestConcurrentAccess runs the hot paths from many goroutines at once on a
+// fresh storage, so the first uses of the shared statements race too. Run
+// it with -race.
+func TestConcurrentAccess(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		readConns int
+	}{
+		{name: "single handle", readConns: 0},
+		{name: "read/write split", readConns: 4},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStorage(t, Options{ReadConns: tt.readConns})
+			seeded := seededStorage{s}
+			if err := seeded.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "test-secret"}); err != nil {
+				t.Fatal(err)
+			}
+			// Seeded behind the storage's back, so the statements are
+			// still unprepared when the goroutines start.
+			res, err := s.writer.ExecContext(ctx,
+				"INSERT INTO users (email, pass_hash, first_name, last_name) VALUES ('admin@example.com', X'00', 'A', 'B')")
+			if err != nil {
+				t.Fatal(err)
+			}
+			adminID, err := res.LastInsertId()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := seeded.SeedUserRole(ctx, adminID, "admin"); err != nil {
+				t.Fatal(err)
+			}
+
+			const goroutines = 100
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := range goroutines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+
+					email := fmt.Sprintf("user-%d@example.com", i)
+					id, err := s.SaveUser(ctx, email, []byte("hash"), "First", "Last", "")
+					if err != nil {
+						t.Errorf("SaveUser: %v", err)
+						return
+					}
+					if user, err := s.User(ctx, email); err != nil || user.ID != id {
+						t.Errorf("User(%q) = %d, %v, want %d", email, user.ID, err, id)
+					}
+					if _, err := s.UserRole(ctx, id); !errors.Is(err, errs.ErrRoleNotFound) {
+						t.Errorf("UserRole of a new user: %v, want ErrRoleNotFound", err)
+					}
+					if role, err := s.UserRole(ctx, adminID); err != nil || role != "admin" {
+						t.Errorf("UserRole(%d) = %q, %v, want admin", adminID, role, err)
+					}
+					if app, err := s.App(ctx, 1); err != nil || app.Name != "test" {
+						t.Errorf("App(1) = %q, %v, want test", app.Name, err)
+					}
+				}()
+			}
+			close(start)
+			wg.Wait()
+		})
+	}
+}
+
+func TestClose_StatementsFail(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{ReadConns: 2})
+
+	// One statement prepared, the others not.
+	if _, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := s.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", ""); err == nil {
+		t.Error("SaveUser after Close: want error")
+	}
+	if _, err := s.User(ctx, "john@example.com"); !errors.Is(err, errStmtClosed) {
+		t.Errorf("User after Close: %v, want errStmtClosed", err)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{ReadConns: 2})
+
+	// Enough rows to span many pages, most of them deleted again.
+	for i := range 500 {
+		email := fmt.Sprintf("user-%d@example.com", i)
+		if _, err := s.SaveUser(ctx, email, make([]byte, 1024), "John", "Doe", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.writer.ExecContext(ctx, "DELETE FROM users WHERE id > 10"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Optimize(ctx); err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+
+	// An interrupted vacuum leaves the file as it was.
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.Vacuum(canceled); err == nil {
+		t.Error("Vacuum with a canceled context: want error")
+	}
+
+	// A running backup wins.
+	s.copies.RLock()
+	res, err := s.Vacuum(ctx)
+	s.copies.RUnlock()
+	if err != nil || !res.Skipped {
+		t.Errorf("Vacuum during a backup: %+v, %v, want skipped", res, err)
+	}
+
+	res, err = s.Vacuum(ctx)
+	if err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	if res.Skipped || res.PageSize <= 0 || res.ReclaimedPages() <= 0 || res.PagesAfter >= res.PagesBefore {
+		t.Errorf("Vacuum: %+v, want pages reclaimed", res)
+	}
+
+	if _, err := s.User(ctx, "user-1@example.com"); err != nil {
+		t.Errorf("User after Vacuum: %v", err)
+	}
+	if _, err := s.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", ""); err != nil {
+		t.Errorf("SaveUser after Vacuum: %v", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// A second connection writing while the first holds the write lock,
+	// without a busy timeout.
+	busyPath := filepath.Join(dir, "busy.db")
+	holder, err := sql.Open("sqlite3", busyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	waiter, err := sql.Open("sqlite3", busyPath+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+	if _, err := holder.ExecContext(ctx, "CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := holder.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	_, busyErr := waiter.ExecContext(ctx, "INSERT INTO t VALUES (2)")
+
+	s := seededStorage{newTestStorage(t, Options{ReadConns: 1})}
+	if err := s.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	constraintErr := s.SeedApp(ctx, models.App{ID: 2, Name: "test", Secret: "other"})
+
+	notADBPath := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(notADBPath, bytes.Repeat([]byte("garbage!"), 512), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	garbage, err := sql.Open("sqlite3", notADBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer garbage.Close()
+	_, notADBErr := garbage.ExecContext(ctx, "SELECT * FROM sqlite_master")
+
+	tests := []struct {
+		name string
+		err  error
+		want storage.Class
+	}{
+		{name: "busy", err: errs.Wrap("storage.sqlite.SaveUser", busyErr), want: storage.Transient},
+		{name: "locked", err: sqlite3.Error{Code: sqlite3.ErrLocked}, want: storage.Transient},
+		{name: "interrupted", err: sqlite3.Error{Code: sqlite3.ErrInterrupt}, want: storage.Transient},
+		{name: "io error", err: sqlite3.Error{Code: sqlite3.ErrIoErr, ExtendedCode: sqlite3.ErrIoErrRead}, want: storage.Transient},
+		{name: "unique constraint", err: errs.Wrap("storage.sqlite.SaveApp", constraintErr), want: storage.Permanent},
+		{name: "not a database", err: notADBErr, want: storage.Permanent},
+		{name: "corrupt", err: sqlite3.Error{Code: sqlite3.ErrCorrupt}, want: storage.Permanent},
+		{name: "disk full", err: sqlite3.Error{Code: sqlite3.ErrFull}, want: storage.Permanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.err == nil {
+				t.Fatal("no error to classify")
+			}
+			if got := storage.Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkConcurrentAccess measures the hot paths run in parallel. Reads
+// scale with ReadConns; writes run one at a time on the single writer
+// connection whatever the parallelism, see statements.
+func BenchmarkConcurrentAccess(b *testing.B) {
+	for _, bb := range []struct {
+		name      string
+		readConns int
+	}{
+		{name: "single handle", readConns: 0},
+		{name: "read/write split", readConns: 4},
+	} {
+		b.Run(bb.name+"/reads", func(b *testing.B) {
+			ctx := context.Background()
+			s := newTestStorage(b, Options{ReadConns: bb.readConns})
+			if err := (seededStorage{s}).SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "test-secret"}); err != nil {
+				b.Fatal(err)
+			}
+			id, err := s.SaveUser(ctx, "reader@example.com", []byte("hash"), "First", "Last", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := s.User(ctx, "reader@example.com"); err != nil {
+						b.Error(err)
+					}
+					if _, err := s.UserRole(ctx, id); !errors.Is(err, errs.ErrRoleNotFound) {
+						b.Error(err)
+					}
+					if _, err := s.App(ctx, 1); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+
+		b.Run(bb.name+"/writes", func(b *testing.B) {
+			ctx := context.Background()
+			s := newTestStorage(b, Options{ReadConns: bb.readConns})
+
+			var n atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					email := fmt.Sprintf("writer-%d@example.com", n.Add(1))
+					if _, err := s.SaveUser(ctx, email, []byte("hash"), "First", "Last", ""); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	s := seededStorage{newTestStorage(t, Options{ReadConns: 1})}
+
+	userID, err := s.SaveUser(ctx, "student@example.com", []byte("hash"), "Jane", "Doe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SeedUserRole(ctx, userID, "student"); err != nil {
+		t.Fatalf("enrollment of an existing user: %v", err)
+	}
+
+	err = s.SeedUserRole(ctx, userID+1, "student")
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.ExtendedCode != sqlite3.ErrConstraintForeignKey {
+		t.Fatalf("enrollment of a missing user: got %v, want a foreign key violation", err)
+	}
+}
+
+func TestUserNameBytes(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	isLimit := func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintTrigger
+	}
+
+	// 64 four-byte emoji are 256 bytes; 255 ASCII bytes fit.
+	long := strings.Repeat("😀", 64)
+	if _, err := s.SaveUser(ctx, "emoji@example.com", []byte("hash"), long, "Doe", ""); !isLimit(err) {
+		t.Fatalf("SaveUser with a %d-byte first name = %v, want the byte limit", len(long), err)
+	}
+	if _, err := s.SaveUser(ctx, "middle@example.com", []byte("hash"), "John", "Doe", long); !isLimit(err) {
+		t.Fatalf("SaveUser with a %d-byte middle name = %v, want the byte limit", len(long), err)
+	}
+	id, err := s.SaveUser(ctx, "ascii@example.com", []byte("hash"), strings.Repeat("a", 255), "Doe", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.writer.ExecContext(ctx, "UPDATE users SET last_name = ? WHERE id = ?", long, id)
+	if !isLimit(err) {
+		t.Fatalf("update to a %d-byte last name = %v, want the byte limit", len(long), err)
+	}
+	if _, err := s.writer.ExecContext(ctx, "UPDATE users SET last_name = 'Roe' WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	ctx := context.Background()
+
+	s := newTestStorage(t, Options{ReadConns: 1})
+	for _, quick := range []bool{true, false} {
+		problems, err := s.CheckIntegrity(ctx, quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) > 0 {
+			t.Errorf("CheckIntegrity(quick=%t) of a sound file = %q", quick, problems)
+		}
+	}
+
+	// Zero the root page of an index, as a torn backup copy would.
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rootPage, pageSize int64
+	for _, query := range []string{
+		"CREATE TABLE t (x TEXT)",
+		"CREATE INDEX t_x ON t (x)",
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 500) INSERT INTO t SELECT printf('row-%d', i) FROM n",
+	} {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.QueryRowContext(ctx, "SELECT rootpage FROM sqlite_master WHERE name = 't_x'").Scan(&rootPage); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(make([]byte, pageSize), (rootPage-1)*pageSize); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	corrupt, err := New(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corrupt.Close()
+
+	for _, quick := range []bool{true, false} {
+		problems, err := corrupt.CheckIntegrity(ctx, quick)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) == 0 {
+			t.Errorf("CheckIntegrity(quick=%t) of a corrupt file found no problem", quick)
+		}
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+	opts := Options{ConnectTimeout: 10 * time.Second, Exclusive: true}
+
+	// A crashed instance leaves its details but no lock behind.
+	if err := os.WriteFile(path+".lock", []byte(`{"pid":999999,"hostname":"gone"}`), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := New(path, opts)
+	if err != nil {
+		t.Fatalf("New over a stale lock: %v", err)
+	}
+	if first.Shared() {
+		t.Error("the holder is shared")
+	}
+	b, err := os.ReadFile(path + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`"pid":%d`, os.Getpid()); !strings.Contains(string(b), want) {
+		t.Errorf("lock file = %s, want %s", b, want)
+	}
+
+	start := time.Now()
+	_, err = New(path, opts)
+	if !errors.Is(err, ErrLocked) {
+		t.Fatalf("second New = %v, want ErrLocked", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("second New failed after %s, want it to fail fast", elapsed)
+	}
+	if want := fmt.Sprintf("pid %d", os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not name the holder, want %q", err, want)
+	}
+
+	allowed := opts
+	allowed.AllowShared = true
+	shared, err := New(path, allowed)
+	if err != nil {
+		t.Fatalf("New with AllowShared: %v", err)
+	}
+	if !shared.Shared() {
+		t.Error("Shared() = false while another instance holds the file")
+	}
+	_ = shared.Close()
+
+	// Closing the shared instance left the lock to the holder.
+	if _, err := New(path, opts); !errors.Is(err, ErrLocked) {
+		t.Fatalf("New after the shared instance closed = %v, want ErrLocked", err)
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := New(path, opts)
+	if err != nil {
+		t.Fatalf("New after the holder closed: %v", err)
+	}
+	_ = next.Close()
+}
+
+func TestReplica(t *testing.T) {
+	ctx := context.Background()
+	path := migratedFile(t)
+	replicaPath := filepath.Join(t.TempDir(), "replica.db")
+
+	seed, err := New(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Backup(ctx, replicaPath); err != nil {
+		t.Fatal(err)
+	}
+	_ = seed.Close()
+
+	s, err := New(path, Options{ReadConns: 2, Replica: replicaPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := s.SaveUser(ctx, "kept@example.com", []byte("hash"), "Kept", "User", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := s.SaveUser(ctx, "gone@example.com", []byte("hash"), "Gone", "User", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserTokenTTL(ctx, kept, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.writer.ExecContext(ctx, "DELETE FROM users WHERE id = ?", gone); err != nil {
+		t.Fatal(err)
+	}
+	// A failed transaction copies nothing it changed.
+	if _, err := s.SaveUser(ctx, "kept@example.com", []byte("hash"), "Dup", "User", ""); err == nil {
+		t.Fatal("duplicate email saved")
+	}
+	// Close copies what is still pending.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replica, err := New(replicaPath, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	user, err := replica.User(ctx, "kept@example.com")
+	if err != nil {
+		t.Fatalf("replicated user: %v", err)
+	}
+	if user.ID != kept || user.FirstName != "Kept" || user.TokenTTL != time.Hour {
+		t.Errorf("replicated user = %+v", user)
+	}
+	if _, err := replica.User(ctx, "gone@example.com"); !errors.Is(err, errs.ErrUserNotFound) {
+		t.Errorf("deleted user on the replica: %v", err)
+	}
+
+	// Diverge the replica behind the back of replication.
+	if _, err := replica.writer.ExecContext(ctx, "UPDATE users SET first_name = 'Stale' WHERE id = ?", kept); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.writer.ExecContext(ctx, "INSERT INTO roles (role) VALUES ('extra')"); err != nil {
+		t.Fatal(err)
+	}
+
+	primary, err := New(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	want := map[string]models.ReplicaDiff{
+		"users": {Copied: 1},
+		"roles": {Deleted: 1},
+	}
+	for _, dryRun := range []bool{true, false} {
+		diffs, err := primary.ReconcileReplica(ctx, replicaPath, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, diff := range diffs {
+			w := want[diff.Table]
+			if diff.Copied != w.Copied || diff.Deleted != w.Deleted {
+				t.Errorf("ReconcileReplica(dryRun=%t) %s = %+v, want %+v", dryRun, diff.Table, diff, w)
+			}
+		}
+		if len(diffs) != len(replicatedTables) {
+			t.Errorf("ReconcileReplica compared %d tables, want %d", len(diffs), len(replicatedTables))
+		}
+	}
+
+	diffs, err := primary.ReconcileReplica(ctx, replicaPath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, diff := range diffs {
+		if diff.Copied != 0 || diff.Deleted != 0 {
+			t.Errorf("after reconciling, %s = %+v", diff.Table, diff)
+		}
+	}
+	user, err = replica.User(ctx, "kept@example.com")
+	if err != nil || user.FirstName != "Kept" {
+		t.Errorf("reconciled user = %+v, %v", user, err)
+	}
+}
+
+var updateSchema = flag.Bool("update-schema", false, "rewrite schema.txt from the migrations")
+
+// TestSchemaManifest keeps schema.txt in step with the migrations: it
+// regenerates the manifest from a freshly migrated storage and compares.
+func TestSchemaManifest(t *testing.T) {
+	s := newTestStorage(t, Options{})
+
+	actual, err := s.schema(context.Background(), "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual.version = SchemaVersion
+
+	want := actual.render()
+	if *updateSchema {
+		if err := os.WriteFile("schema.txt", []byte(want), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+	if manifestText != want {
+		t.Fatalf("schema.txt is out of date, run go test -run TestSchemaManifest -update-schema; want:\n%s", want)
+	}
+
+	parsed, err := parseManifest(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.render() != want {
+		t.Fatalf("parsing and rendering the manifest changes it:\n%s", parsed.render())
+	}
+}
+
+func TestCheckSchema_Drift(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStorage(t, Options{})
+
+	// A migration recorded as applied whose statements were not. The
+	// triggers on the names go first, as they use the column.
+	for _, stmt := range []string{
+		"DROP TABLE app_usage",
+		"DROP TRIGGER users_name_bytes_insert",
+		"DROP TRIGGER users_name_bytes_update",
+		"ALTER TABLE users DROP COLUMN middle_name",
+	} {
+		if _, err := s.writer.ExecContext(ctx, stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check, err := s.VerifySchema(ctx, "schema_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"column users.middle_name", "table app_usage"}; !slices.Equal(check.Missing, want) {
+		t.Fatalf("Missing = %q, want %q", check.Missing, want)
+	}
+	if check.Version != SchemaVersion || check.Dirty || check.OK() {
+		t.Fatalf("VerifySchema = %+v, want version %d, clean, not OK", check, SchemaVersion)
+	}
+
+	err = s.CheckSchema(ctx, "schema_migrations")
+	if !errors.Is(err, errs.ErrSchemaDrift) {
+		t.Fatalf("CheckSchema = %v, want ErrSchemaDrift", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "missing column users.middle_name, table app_usage") {
+		t.Fatalf("CheckSchema = %q, want the missing tables and columns", msg)
+	}
+}
+
+func TestParseManifest_Invalid(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"users: id\n",
+		"version x\n",
+		"version 1\nusers id\n",
+		"version 1\nusers: id\nusers: email\n",
+	} {
+		if _, err := parseManifest(text); err == nil {
+			t.Errorf("parseManifest(%q): want error", text)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// RecordLogin appends attempt to the login history.
+func (s *Storage) RecordLogin(ctx context.Context, attempt models.LoginAttempt) error {
+	const op = "storage.sqlite.RecordLogin"
+
+	defer s.observer.Observe(op)()
+
+	var userID sql.NullInt64
+	if attempt.UserID != 0 {
+		userID = sql.NullInt64{Int64: attempt.UserID, Valid: true}
+	}
+
+	_, err := s.writer.ExecContext(ctx,
+		"INSERT INTO login_history (user_id, app_id, success, created_at) VALUES (?, ?, ?, ?)",
+		userID, attempt.AppID, attempt.Success, attempt.At.UnixMilli(),
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// statsQuery computes every number in one statement so that they all come
+// from the same snapshot. Each subquery is served by the created_at
+// indexes.
+const statsQuery = `
+	SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM users WHERE created_at >= :from AND created_at < :to),
+		(SELECT COUNT(*) FROM login_history WHERE created_at >= :from AND created_at < :to AND success = 1),
+		(SELECT COUNT(*) FROM login_history WHERE created_at >= :from AND created_at < :to AND success = 0),
+		(SELECT COUNT(DISTINCT user_id) FROM login_history WHERE created_at >= :day AND created_at < :to AND success = 1),
+		(SELECT COUNT(DISTINCT user_id) FROM login_history WHERE created_at >= :week AND created_at < :to AND success = 1)`
+
+// UsageStats returns usage numbers over [from, to).
+func (s *Storage) UsageStats(ctx context.Context, from, to time.Time) (models.Stats, error) {
+	const op = "storage.sqlite.UsageStats"
+
+	defer s.observer.Observe(op)()
+
+	stats := models.Stats{From: from, To: to}
+	err := s.reader.QueryRowContext(ctx, statsQuery,
+		sql.Named("from", from.UnixMilli()),
+		sql.Named("to", to.UnixMilli()),
+		sql.Named("day", to.Add(-24*time.Hour).UnixMilli()),
+		sql.Named("week", to.Add(-7*24*time.Hour).UnixMilli()),
+	).Scan(
+		&stats.TotalUsers,
+		&stats.Registrations,
+		&stats.SuccessfulLogins,
+		&stats.FailedLogins,
+		&stats.DailyActive,
+		&stats.WeeklyActive,
+	)
+	if err != nil {
+		return models.Stats{}, errs.Wrap(op, err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// errStmtClosed is returned by statements used after Close.
+var errStmtClosed = errors.New("statement is closed")
+
+// stmt is a statement of one of the handles, prepared on its first use and
+// shared by every call after it.
+//
+// A *sql.Stmt is safe for concurrent use, but preparing it on first use is
+// not unless guarded: two calls racing to prepare it would each store their
+// own, leaking one and writing the field unsynchronized. once makes the
+// first call prepare it while the others wait.
+//
+// The first call prepares it without its own context, so a canceled request
+// cannot leave a failed preparation to everyone after it. A statement only
+// fails to prepare if the schema lacks what it refers to, which CheckSchema
+// rules out at start; that failure is then returned on every call.
+type stmt struct {
+	db    *sql.DB
+	query string
+
+	once sync.Once
+	stmt *sql.Stmt
+	err  error
+}
+
+func newStmt(db *sql.DB, query string) *stmt {
+	return &stmt{db: db, query: query}
+}
+
+// get returns the prepared statement, preparing it if it is the first call.
+func (p *stmt) get() (*sql.Stmt, error) {
+	p.once.Do(func() {
+		p.stmt, p.err = p.db.PrepareContext(context.Background(), p.query)
+	})
+
+	return p.stmt, p.err
+}
+
+// close closes the statement if it was prepared. Calls after it fail with
+// errStmtClosed, or the error of sql.Stmt if it was prepared.
+func (p *stmt) close() error {
+	p.once.Do(func() { p.err = errStmtClosed })
+	if p.stmt == nil {
+		return nil
+	}
+
+	return p.stmt.Close()
+}
+
+// statements are the prepared statements of the hot paths: registration,
+// login and the lookups every token validation makes. Other queries are
+// rare enough to be prepared per call by database/sql.
+//
+// Sharing them adds no serialization point of its own: a *sql.Stmt takes
+// a mutex only to find a connection it is prepared on, which is brief. The
+// writer is a single connection, so writes, SaveUser included, run one at
+// a time whatever the statements; reads wait only when all ReadConns
+// reader connections are busy. BenchmarkConcurrentAccess measures both.
+type statements struct {
+	insertUser  *stmt
+	userByEmail *stmt
+	userByID    *stmt
+	userExists  *stmt
+	userRole    *stmt
+	appByID     *stmt
+	appByName   *stmt
+}
+
+const (
+	insertUserQuery = "INSERT INTO users (email, pass_hash, hash_version, first_name, last_name, middle_name, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+
+	userColumns = "id, email, pass_hash, hash_version, first_name, last_name, middle_name, token_ttl_seconds, tos_version_accepted, tos_accepted_at, created_at, updated_at, delete_after, sessions_revoked_at, activation_pending"
+	appColumns  = "id, name, secret, token_ttl_seconds, max_users, bind_tokens"
+
+	// userRoleQuery returns a row with the global role of the user, NULL
+	// without one, if the user exists.
+	userRoleQuery = `
+		SELECT r.role
+		FROM users u
+		LEFT JOIN enrollments en ON en.user_id = u.id AND en.org_unit_id IS NULL
+		LEFT JOIN roles r ON r.id = en.role_id
+		WHERE u.id = ?
+		ORDER BY en.id
+		LIMIT 1`
+)
+
+func newStatements(writer, reader *sql.DB) *statements {
+	return &statements{
+		insertUser:  newStmt(writer, insertUserQuery),
+		userByEmail: newStmt(reader, "SELECT "+userColumns+" FROM users WHERE email = ?"),
+		userByID:    newStmt(reader, "SELECT "+userColumns+" FROM users WHERE id = ?"),
+		userExists:  newStmt(reader, "SELECT 1 FROM users WHERE id = ? LIMIT 1"),
+		userRole:    newStmt(reader, userRoleQuery),
+		appByID:     newStmt(reader, "SELECT "+appColumns+" FROM apps WHERE id = ?"),
+		appByName:   newStmt(reader, "SELECT "+appColumns+" FROM apps WHERE name = ?"),
+	}
+}
+
+// close closes every statement and returns the first error.
+func (s *statements) close() error {
+	var first error
+	for _, p := range []*stmt{
+		s.insertUser, s.userByEmail, s.userByID, s.userExists, s.userRole, s.appByID, s.appByName,
+	} {
+		if err := p.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
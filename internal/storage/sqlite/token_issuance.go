@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+// SaveTokenIssuance records the issuance of a token.
+func (s *Storage) SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error {
+	const op = "storage.sqlite.SaveTokenIssuance"
+
+	defer s.observer.Observe(op)()
+
+	_, err := s.writer.ExecContext(ctx, `
+		INSERT INTO token_issuances (fingerprint, jti, kind, user_id, app_id, issued_at, expires_at, retain_until, device)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		issuance.Fingerprint, issuance.JTI, string(issuance.Kind), issuance.UserID, issuance.AppID,
+		issuance.IssuedAt.UnixMilli(), issuance.ExpiresAt.UnixMilli(), issuance.RetainUntil.UnixMilli(),
+		issuance.Device,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// TokenIssuance returns the issuance of the token with the given
+// fingerprint. If there is none, returns errs.ErrTokenNotIssued.
+func (s *Storage) TokenIssuance(ctx context.Context, fingerprint string) (models.TokenIssuance, error) {
+	const op = "storage.sqlite.TokenIssuance"
+
+	defer s.observer.Observe(op)()
+
+	var (
+		issuance                         models.TokenIssuance
+		kind                             string
+		issuedAt, expiresAt, retainUntil int64
+	)
+	err := s.reader.QueryRowContext(ctx, `
+		SELECT fingerprint, jti, kind, user_id, app_id, issued_at, expires_at, retain_until, device
+		FROM token_issuances WHERE fingerprint = ?`,
+		fingerprint,
+	).Scan(&issuance.Fingerprint, &issuance.JTI, &kind, &issuance.UserID, &issuance.AppID,
+		&issuedAt, &expiresAt, &retainUntil, &issuance.Device)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.TokenIssuance{}, errs.Wrap(op, errs.ErrTokenNotIssued)
+		}
+
+		return models.TokenIssuance{}, errs.Wrap(op, err)
+	}
+
+	issuance.Kind = models.TokenKind(kind)
+	issuance.IssuedAt = time.UnixMilli(issuedAt)
+	issuance.ExpiresAt = time.UnixMilli(expiresAt)
+	issuance.RetainUntil = time.UnixMilli(retainUntil)
+
+	return issuance, nil
+}
+
+// PurgeTokenIssuances deletes the issuances retained until before now and
+// returns how many it deleted.
+func (s *Storage) PurgeTokenIssuances(ctx context.Context, now time.Time) (int64, error) {
+	const op = "storage.sqlite.PurgeTokenIssuances"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, "DELETE FROM token_issuances WHERE retain_until < ?", now.UnixMilli())
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return n, nil
+}
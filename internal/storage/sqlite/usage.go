@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const addAppUsageQuery = `
+	INSERT INTO app_usage (app_id, day, logins, tokens_issued, refreshes) VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT (app_id, day) DO UPDATE SET
+		logins = logins + excluded.logins,
+		tokens_issued = tokens_issued + excluded.tokens_issued,
+		refreshes = refreshes + excluded.refreshes`
+
+// AddAppUsage adds the counts of usage to those of their apps and days,
+// all or none. If an app does not exist, returns errs.ErrAppNotFound.
+func (s *Storage) AddAppUsage(ctx context.Context, usage []models.AppUsage) error {
+	const op = "storage.sqlite.AddAppUsage"
+
+	defer s.observer.Observe(op)()
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, addAppUsageQuery)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	defer stmt.Close()
+
+	for _, u := range usage {
+		if _, err := stmt.ExecContext(ctx,
+			u.AppID, u.Day.UnixMilli(), u.Logins, u.TokensIssued, u.Refreshes,
+		); err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey {
+				return errs.Wrap(op, errs.ErrAppNotFound)
+			}
+
+			return errs.Wrap(op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return nil
+}
+
+// AppUsage returns the usage of the app on the days starting in
+// [from, to), oldest first. Days without any are left out.
+func (s *Storage) AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error) {
+	const op = "storage.sqlite.AppUsage"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx, `
+		SELECT day, logins, tokens_issued, refreshes FROM app_usage
+		WHERE app_id = ? AND day >= ? AND day < ?
+		ORDER BY day`,
+		appID, from.UnixMilli(), to.UnixMilli(),
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var usage []models.AppUsage
+	for rows.Next() {
+		u := models.AppUsage{AppID: appID}
+		var day int64
+		if err := rows.Scan(&day, &u.Logins, &u.TokensIssued, &u.Refreshes); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		u.Day = time.UnixMilli(day).UTC()
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return usage, nil
+}
+
+// TrimAppUsage deletes the usage of the days starting before before and
+// returns how many app days it deleted.
+func (s *Storage) TrimAppUsage(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.sqlite.TrimAppUsage"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, "DELETE FROM app_usage WHERE day < ?", before.UnixMilli())
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return n, nil
+}
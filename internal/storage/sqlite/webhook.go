@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+)
+
+const insertEventQuery = "INSERT INTO events (type, user_id, app_id, payload, created_at) VALUES (?, ?, ?, ?, ?)"
+
+// SaveEvent appends event to the outbox and returns its ID.
+func (s *Storage) SaveEvent(ctx context.Context, event models.Event) (int64, error) {
+	const op = "storage.sqlite.SaveEvent"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, insertEventQuery,
+		event.Type, nullInt64(event.UserID), nullInt64(int64(event.AppID)), event.Payload, event.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// Events returns at most limit events following afterID, oldest first.
+func (s *Storage) Events(ctx context.Context, afterID int64, limit int) ([]models.Event, error) {
+	const op = "storage.sqlite.Events"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx,
+		"SELECT id, type, user_id, app_id, payload, created_at FROM events WHERE id > ? ORDER BY id LIMIT ?",
+		afterID, limit,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		var (
+			event     models.Event
+			userID    sql.NullInt64
+			appID     sql.NullInt32
+			createdAt int64
+		)
+		if err := rows.Scan(&event.ID, &event.Type, &userID, &appID, &event.Payload, &createdAt); err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		event.UserID = userID.Int64
+		event.AppID = appID.Int32
+		event.CreatedAt = time.UnixMilli(createdAt)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return events, nil
+}
+
+// LastEventID returns the ID of the newest event, zero if there is none.
+func (s *Storage) LastEventID(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.LastEventID"
+
+	defer s.observer.Observe(op)()
+
+	var id int64
+	if err := s.reader.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM events").Scan(&id); err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+// SaveWebhook stores a new webhook and returns its ID.
+func (s *Storage) SaveWebhook(ctx context.Context, webhook models.Webhook) (int64, error) {
+	const op = "storage.sqlite.SaveWebhook"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, `
+		INSERT INTO webhook_subscriptions
+			(app_id, url, secret, event_types, active, last_event_id, failures, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		webhook.AppID, webhook.URL, webhook.Secret, strings.Join(webhook.EventTypes, ","),
+		webhook.Active, webhook.LastEventID, webhook.Failures, unixMilli(webhook.NextAttemptAt),
+		webhook.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap(op, err)
+	}
+
+	return id, nil
+}
+
+const webhookColumns = "id, app_id, url, secret, event_types, active, last_event_id, failures, next_attempt_at, created_at"
+
+// Webhook returns the webhook with the given ID.
+func (s *Storage) Webhook(ctx context.Context, id int64) (models.Webhook, error) {
+	const op = "storage.sqlite.Webhook"
+
+	defer s.observer.Observe(op)()
+
+	webhook, err := scanWebhook(s.reader.QueryRowContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhook_subscriptions WHERE id = ?", id,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Webhook{}, errs.Wrap(op, errs.ErrWebhookNotFound)
+		}
+
+		return models.Webhook{}, errs.Wrap(op, err)
+	}
+
+	return webhook, nil
+}
+
+// Webhooks returns the webhooks of the app, or of every app when appID is
+// zero, oldest first.
+func (s *Storage) Webhooks(ctx context.Context, appID int32) ([]models.Webhook, error) {
+	const op = "storage.sqlite.Webhooks"
+
+	defer s.observer.Observe(op)()
+
+	rows, err := s.reader.QueryContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhook_subscriptions WHERE ? = 0 OR app_id = ? ORDER BY id",
+		appID, appID,
+	)
+	if err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+	defer rows.Close()
+
+	var webhooks []models.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, errs.Wrap(op, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap(op, err)
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes the webhook with the given ID.
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	const op = "storage.sqlite.DeleteWebhook"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", id)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return requireAffected(op, res, errs.ErrWebhookNotFound)
+}
+
+// UpdateWebhookState stores the delivery progress of the webhook.
+func (s *Storage) UpdateWebhookState(ctx context.Context, id int64, state models.WebhookState) error {
+	const op = "storage.sqlite.UpdateWebhookState"
+
+	defer s.observer.Observe(op)()
+
+	res, err := s.writer.ExecContext(ctx,
+		"UPDATE webhook_subscriptions SET active = ?, last_event_id = ?, failures = ?, next_attempt_at = ? WHERE id = ?",
+		state.Active, state.LastEventID, state.Failures, unixMilli(state.NextAttemptAt), id,
+	)
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+
+	return requireAffected(op, res, errs.ErrWebhookNotFound)
+}
+
+func scanWebhook(row interface{ Scan(dest ...any) error }) (models.Webhook, error) {
+	var (
+		webhook                  models.Webhook
+		eventTypes               string
+		nextAttemptAt, createdAt int64
+	)
+	err := row.Scan(
+		&webhook.ID, &webhook.AppID, &webhook.URL, &webhook.Secret, &eventTypes,
+		&webhook.Active, &webhook.LastEventID, &webhook.Failures, &nextAttemptAt, &createdAt,
+	)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if eventTypes != "" {
+		webhook.EventTypes = strings.Split(eventTypes, ",")
+	}
+	if nextAttemptAt != 0 {
+		webhook.NextAttemptAt = time.UnixMilli(nextAttemptAt)
+	}
+	webhook.CreatedAt = time.UnixMilli(createdAt)
+
+	return webhook, nil
+}
+
+// requireAffected returns notFound when res changed no row.
+func requireAffected(op string, res sql.Result, notFound error) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errs.Wrap(op, err)
+	}
+	if affected == 0 {
+		return errs.Wrap(op, notFound)
+	}
+
+	return nil
+}
+
+// unixMilli maps the zero time to zero.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+// nullInt64 maps zero to NULL.
+func nullInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
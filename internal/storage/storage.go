@@ -1,9 +1,15 @@
 package storage
 
-import "errors"
+import "sso/internal/domain/errs"
 
+// Deprecated: these alias the errs sentinels and will be removed in the
+// next release. Use errs directly.
 var (
-	ErrUserExists   = errors.New("user already exists")
-	ErrUserNotFound = errors.New("user not found")
-	ErrAppNotFound  = errors.New("app not found")
+	ErrUserExists   = errs.ErrUserExists
+	ErrUserNotFound = errs.ErrUserNotFound
+	ErrRoleNotFound = errs.ErrRoleNotFound
+	ErrAppNotFound  = errs.ErrAppNotFound
+
+	ErrAuthorizationNotFound = errs.ErrAuthorizationNotFound
+	ErrAuthorizationUsed     = errs.ErrAuthorizationUsed
 )
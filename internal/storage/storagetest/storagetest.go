@@ -0,0 +1,1587 @@
+// Package storagetest provides a conformance suite every storage backend
+// must pass, so that all of them map failures to the same sentinels.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	"sso/internal/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage is the set of methods a backend must implement to be tested.
+type Storage interface {
+	SaveUser(
+		ctx context.Context,
+		email string,
+		passHash []byte,
+		firstName string,
+		lastName string,
+		middleName string,
+	) (int64, error)
+	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
+	UserExists(ctx context.Context, userID int64) (bool, error)
+	UserRole(ctx context.Context, userID int64) (string, error)
+	App(ctx context.Context, appID int32) (models.App, error)
+	AppByName(ctx context.Context, name string) (models.App, error)
+	UsersExist(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+	UserRoles(ctx context.Context, userIDs []int64) (map[int64]string, error)
+	AssignRoles(ctx context.Context, userIDs []int64, role string) (map[int64]models.RoleOutcome, error)
+	Users(ctx context.Context, filter models.UserFilter) iter.Seq2[models.UserRecord, error]
+	LastUserID(ctx context.Context) (int64, error)
+	SetUserTokenTTL(ctx context.Context, userID int64, ttl time.Duration) error
+	AcceptTerms(ctx context.Context, userID int64, version string, acceptedAt time.Time) error
+
+	RedirectURIAllowed(ctx context.Context, appID int32, uri string) (bool, error)
+	SaveAuthorization(ctx context.Context, authz models.Authorization) error
+	Authorization(ctx context.Context, id string) (models.Authorization, error)
+	IssueAuthorizationCode(
+		ctx context.Context,
+		id string,
+		userID int64,
+		codeHash string,
+		expiresAt time.Time,
+	) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string, usedAt time.Time) (models.Authorization, error)
+	SaveSigningKey(ctx context.Context, key models.SigningKey) error
+	SigningKeys(ctx context.Context, limit int) ([]models.SigningKey, error)
+	RecordLogin(ctx context.Context, attempt models.LoginAttempt) error
+	UsageStats(ctx context.Context, from, to time.Time) (models.Stats, error)
+	SaveEvent(ctx context.Context, event models.Event) (int64, error)
+	Events(ctx context.Context, afterID int64, limit int) ([]models.Event, error)
+	LastEventID(ctx context.Context) (int64, error)
+	SaveWebhook(ctx context.Context, webhook models.Webhook) (int64, error)
+	Webhook(ctx context.Context, id int64) (models.Webhook, error)
+	Webhooks(ctx context.Context, appID int32) ([]models.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	UpdateWebhookState(ctx context.Context, id int64, state models.WebhookState) error
+	SaveInvite(ctx context.Context, invite models.Invite) (int64, error)
+	Invite(ctx context.Context, codeHash string) (models.Invite, error)
+	SaveInvitedUser(
+		ctx context.Context,
+		inviteID int64,
+		appID int32,
+		email string,
+		passHash []byte,
+		firstName string,
+		lastName string,
+		middleName string,
+		usedAt time.Time,
+	) (int64, error)
+	InviteUses(ctx context.Context, inviteID int64) ([]models.InviteUse, error)
+	LinkIdentity(ctx context.Context, identity models.LinkedIdentity) error
+	LinkedIdentities(ctx context.Context, userID int64) ([]models.LinkedIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID int64, provider string) error
+	ScheduleDeletion(
+		ctx context.Context,
+		userID int64,
+		deleteAfter time.Time,
+		tokenHash string,
+		revokedAt time.Time,
+	) error
+	CancelDeletion(ctx context.Context, userID int64, tokenHash string, now time.Time) error
+	AnonymizeDueUsers(ctx context.Context, now time.Time, limit int) ([]int64, error)
+	SaveChallenge(ctx context.Context, challenge models.Challenge) error
+	Challenge(ctx context.Context, tokenHash string) (models.Challenge, error)
+	ConsumeChallenge(ctx context.Context, tokenHash string, usedAt time.Time) error
+	AttachPermission(ctx context.Context, role string, appID int32, permission string) error
+	DetachPermission(ctx context.Context, role string, appID int32, permission string) error
+	RolePermissions(ctx context.Context, role string, appID int32) ([]string, error)
+	UserPermissions(ctx context.Context, userID int64, appID int32) ([]string, error)
+	PreRegisterUser(
+		ctx context.Context,
+		email string,
+		firstName string,
+		lastName string,
+		middleName string,
+		tokenHash string,
+		expiresAt time.Time,
+	) (int64, error)
+	SetActivationToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	ActivateUser(ctx context.Context, tokenHash string, passHash []byte, now time.Time) (int64, error)
+	SaveAppUser(
+		ctx context.Context,
+		appID int32,
+		email string,
+		passHash []byte,
+		firstName string,
+		lastName string,
+		middleName string,
+	) (int64, error)
+	SetAppMaxUsers(ctx context.Context, appID int32, maxUsers int) error
+	AppQuota(ctx context.Context, appID int32) (models.AppQuota, error)
+	SaveRegistration(ctx context.Context, reg models.Registration) (int64, error)
+	AddAppUsage(ctx context.Context, usage []models.AppUsage) error
+	AppUsage(ctx context.Context, appID int32, from, to time.Time) ([]models.AppUsage, error)
+	TrimAppUsage(ctx context.Context, before time.Time) (int64, error)
+	SaveOrgUnit(ctx context.Context, unit models.OrgUnit) (int64, error)
+	MoveOrgUnit(ctx context.Context, id, parentID int64) error
+	OrgUnits(ctx context.Context) ([]models.OrgUnit, error)
+	UnitRoles(ctx context.Context, userID, unitID int64) ([]string, error)
+	SaveTokenIssuance(ctx context.Context, issuance models.TokenIssuance) error
+	TokenIssuance(ctx context.Context, fingerprint string) (models.TokenIssuance, error)
+	PurgeTokenIssuances(ctx context.Context, now time.Time) (int64, error)
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	TokenRevoked(ctx context.Context, jti string) (bool, error)
+	PurgeRevokedTokens(ctx context.Context, now time.Time) (int64, error)
+
+	Seeder
+}
+
+// Seeder creates fixtures the provider interfaces have no methods for.
+// Backends usually implement it in their own test files.
+type Seeder interface {
+	SeedApp(ctx context.Context, app models.App) error
+	SeedUserRole(ctx context.Context, userID int64, role string) error
+	SeedRedirectURI(ctx context.Context, appID int32, uri string) error
+	SeedUnitRole(ctx context.Context, userID, unitID int64, role string) error
+}
+
+// RunConformanceTests runs the suite. newStore must return an empty,
+// independent storage on every call.
+func RunConformanceTests(t *testing.T, newStore func() Storage) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s Storage)
+	}{
+		{name: "SaveUser and User round trip", run: testSaveAndGetUser},
+		{name: "SaveUser duplicate email", run: testDuplicateEmail},
+		{name: "No middle name", run: testNoMiddleName},
+		{name: "User not found", run: testUserNotFound},
+		{name: "UserExists", run: testUserExists},
+		{name: "UserRole", run: testUserRole},
+		{name: "Role assignments", run: testAssignRoles},
+		{name: "App", run: testApp},
+		{name: "Token TTL overrides", run: testTokenTTL},
+		{name: "Terms acceptance", run: testAcceptTerms},
+		{name: "Authorizations", run: testAuthorizations},
+		{name: "Signing keys", run: testSigningKeys},
+		{name: "Usage stats", run: testUsageStats},
+		{name: "Events", run: testEvents},
+		{name: "Webhooks", run: testWebhooks},
+		{name: "Invites", run: testInvites},
+		{name: "Linked identities", run: testLinkedIdentities},
+		{name: "Deferred deletion", run: testDeferredDeletion},
+		{name: "Login challenges", run: testChallenges},
+		{name: "Token issuances", run: testTokenIssuances},
+		{name: "Revoked tokens", run: testRevokedTokens},
+		{name: "Permissions", run: testPermissions},
+		{name: "Org units", run: testOrgUnits},
+		{name: "Account activation", run: testActivation},
+		{name: "App user quotas", run: testAppQuota},
+		{name: "Concurrent registrations for the last slot", run: testConcurrentAppQuota},
+		{name: "Registrations", run: testRegistrations},
+		{name: "App usage", run: testAppUsage},
+		{name: "Batch lookups", run: testBatchLookups},
+		{name: "Users export", run: testUsersExport},
+		{name: "Unicode emails", run: testUnicodeEmails},
+		{name: "Concurrent SaveUser race", run: testConcurrentSaveUser},
+		{name: "Context cancellation", run: testContextCancellation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore())
+		})
+	}
+}
+
+func testSaveAndGetUser(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "Jr")
+	require.NoError(t, err)
+	assert.Positive(t, id)
+
+	otherID, err := s.SaveUser(ctx, "jane@example.com", []byte("hash2"), "Jane", "Doe", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, id, otherID)
+
+	user, err := s.User(ctx, "john@example.com")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), user.CreatedAt, time.Minute)
+	assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+	user.CreatedAt, user.UpdatedAt = time.Time{}, time.Time{}
+	assert.Equal(t, models.User{
+		ID:         id,
+		Email:      "john@example.com",
+		PassHash:   []byte("hash"),
+		FirstName:  "John",
+		LastName:   "Doe",
+		MiddleName: "Jr",
+	}, user)
+}
+
+func testDuplicateEmail(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	_, err := s.SaveUser(ctx, "dup@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	_, err = s.SaveUser(ctx, "dup@example.com", []byte("other"), "Jane", "Roe", "")
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+}
+
+// testNoMiddleName checks that users without a middle name read back with
+// an empty one through every way of saving and reading users.
+func testNoMiddleName(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "lms", Secret: "test-secret"}))
+
+	direct, err := s.SaveUser(ctx, "direct@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	app, err := s.SaveAppUser(ctx, 1, "app@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	pending, err := s.PreRegisterUser(ctx, "pending@example.com", "John", "Doe", "", "token-hash", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	withName, err := s.SaveUser(ctx, "named@example.com", []byte("hash"), "John", "Doe", "Jr")
+	require.NoError(t, err)
+
+	want := map[int64]string{direct: "", app: "", pending: "", withName: "Jr"}
+	for id, middleName := range want {
+		user, err := s.UserByID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, middleName, user.MiddleName, user.Email)
+
+		user, err = s.User(ctx, user.Email)
+		require.NoError(t, err)
+		assert.Equal(t, middleName, user.MiddleName, user.Email)
+	}
+	for user, err := range s.Users(ctx, models.UserFilter{}) {
+		require.NoError(t, err)
+		assert.Equal(t, want[user.ID], user.MiddleName, user.Email)
+	}
+}
+
+func testUserNotFound(t *testing.T, s Storage) {
+	_, err := s.User(context.Background(), "missing@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUserExists(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "exists@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	exists, err := s.UserExists(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = s.UserExists(ctx, id+1000)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func testUserRole(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "role@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUserRole(ctx, id, "teacher"))
+
+	role, err := s.UserRole(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "teacher", role)
+
+	noRoleID, err := s.SaveUser(ctx, "norole@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	_, err = s.UserRole(ctx, noRoleID)
+	assert.ErrorIs(t, err, storage.ErrRoleNotFound)
+
+	_, err = s.UserRole(ctx, noRoleID+1000)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testAssignRoles(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	save := func(email string) int64 {
+		t.Helper()
+
+		id, err := s.SaveUser(ctx, email, []byte("hash"), "John", "Doe", "")
+		require.NoError(t, err)
+
+		return id
+	}
+	teacher := save("teacher@example.com")
+	require.NoError(t, s.SeedUserRole(ctx, teacher, "teacher"))
+	student := save("student@example.com")
+	require.NoError(t, s.SeedUserRole(ctx, student, "student"))
+	unit, err := s.SaveOrgUnit(ctx, models.OrgUnit{Name: "Faculty"})
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUnitRole(ctx, student, unit, "dean"))
+	newcomer := save("newcomer@example.com")
+	missing := newcomer + 1000
+
+	_, err = s.AssignRoles(ctx, []int64{student}, "rector")
+	assert.ErrorIs(t, err, errs.ErrRoleNotFound)
+
+	ids := []int64{teacher, student, newcomer, missing}
+	res, err := s.AssignRoles(ctx, ids, "teacher")
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]models.RoleOutcome{
+		teacher:  models.RoleAlreadyHad,
+		student:  models.RoleAssigned,
+		newcomer: models.RoleAssigned,
+		missing:  models.RoleUserNotFound,
+	}, res)
+
+	// The role replaces the global one, not those in units.
+	roles, err := s.UserRoles(ctx, ids)
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{teacher: "teacher", student: "teacher", newcomer: "teacher", missing: ""}, roles)
+	unitRoles, err := s.UnitRoles(ctx, student, unit)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dean", "teacher"}, unitRoles)
+
+	// Assigning it again changes nothing.
+	res, err = s.AssignRoles(ctx, ids, "teacher")
+	require.NoError(t, err)
+	for _, id := range ids[:3] {
+		assert.Equal(t, models.RoleAlreadyHad, res[id])
+	}
+	assert.Equal(t, models.RoleUserNotFound, res[missing])
+}
+
+func testAppUsage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "pilot", Secret: "pilot-secret"}))
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 2, Name: "lms", Secret: "lms-secret"}))
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	next := day.AddDate(0, 0, 1)
+	require.NoError(t, s.AddAppUsage(ctx, []models.AppUsage{
+		{AppID: 1, Day: day, Logins: 2, TokensIssued: 3},
+		{AppID: 2, Day: day, Logins: 1, TokensIssued: 1},
+	}))
+	require.NoError(t, s.AddAppUsage(ctx, []models.AppUsage{
+		{AppID: 1, Day: day, Logins: 1, TokensIssued: 1, Refreshes: 1},
+		{AppID: 1, Day: next, TokensIssued: 4},
+	}))
+
+	// The whole batch fails with an unknown app.
+	err := s.AddAppUsage(ctx, []models.AppUsage{
+		{AppID: 1, Day: next, Logins: 10},
+		{AppID: 99, Day: next, Logins: 1},
+	})
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+
+	usage, err := s.AppUsage(ctx, 1, day, next.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{
+		{AppID: 1, Day: day, Logins: 3, TokensIssued: 4, Refreshes: 1},
+		{AppID: 1, Day: next, TokensIssued: 4},
+	}, usage)
+
+	usage, err = s.AppUsage(ctx, 1, next, next.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	assert.Equal(t, []models.AppUsage{{AppID: 1, Day: next, TokensIssued: 4}}, usage)
+
+	usage, err = s.AppUsage(ctx, 3, day, next)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	n, err := s.TrimAppUsage(ctx, next)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	usage, err = s.AppUsage(ctx, 2, day, next)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+	usage, err = s.AppUsage(ctx, 1, day, next.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Len(t, usage, 1)
+}
+
+func testBatchLookups(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	const users = 1200 // spans several IN chunks
+
+	ids := make([]int64, 0, users+1)
+	for i := range users {
+		id, err := s.SaveUser(ctx, fmt.Sprintf("batch-%d@example.com", i), []byte("hash"), "John", "Doe", "")
+		require.NoError(t, err)
+		if i%2 == 0 {
+			require.NoError(t, s.SeedUserRole(ctx, id, "student"))
+		}
+		ids = append(ids, id)
+	}
+	missing := ids[len(ids)-1] + 1000
+	ids = append(ids, missing)
+
+	exist, err := s.UsersExist(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, exist, len(ids))
+	for _, id := range ids[:users] {
+		assert.True(t, exist[id])
+	}
+	assert.False(t, exist[missing])
+
+	roles, err := s.UserRoles(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, roles, len(ids))
+	for i, id := range ids[:users] {
+		if i%2 == 0 {
+			assert.Equal(t, "student", roles[id])
+		} else {
+			assert.Empty(t, roles[id])
+		}
+	}
+	assert.Empty(t, roles[missing])
+
+	empty, err := s.UsersExist(ctx, nil)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+func testUsersExport(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	// More than two pages of every backend.
+	const total = 1200
+	ids := make([]int64, 0, total)
+	for i := range total {
+		id, err := s.SaveUser(ctx, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "User", "Number", "")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	collect := func(filter models.UserFilter, limit int) []int64 {
+		var got []int64
+		for user, err := range s.Users(ctx, filter) {
+			require.NoError(t, err)
+			got = append(got, user.ID)
+			if len(got) == limit {
+				break
+			}
+		}
+		return got
+	}
+
+	assert.Equal(t, ids, collect(models.UserFilter{}, 0))
+
+	// Stopping early and resuming after the last ID sees every user once.
+	head := collect(models.UserFilter{}, 700)
+	rest := collect(models.UserFilter{AfterID: head[len(head)-1]}, 0)
+	assert.Equal(t, ids, append(head, rest...))
+
+	time.Sleep(5 * time.Millisecond)
+	since := time.Now()
+	require.NoError(t, s.SetUserTokenTTL(ctx, ids[10], time.Hour))
+	require.NoError(t, s.AcceptTerms(ctx, ids[1100], "2025-01", since))
+	assert.Equal(t, []int64{ids[10], ids[1100]}, collect(models.UserFilter{UpdatedSince: since}, 0))
+
+	// A snapshot pinned at the last ID sees none of the users saved
+	// during the export, and every user before it once.
+	last, err := s.LastUserID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ids[len(ids)-1], last)
+	var snapshot []int64
+	for user, err := range s.Users(ctx, models.UserFilter{AfterID: ids[990], UpToID: last}) {
+		require.NoError(t, err)
+		snapshot = append(snapshot, user.ID)
+		if len(snapshot)%200 == 0 {
+			_, err := s.SaveUser(ctx, fmt.Sprintf("late%d@example.com", len(snapshot)), []byte("hash"), "User", "Late", "")
+			require.NoError(t, err)
+		}
+	}
+	assert.Equal(t, ids[991:], snapshot)
+	later := collect(models.UserFilter{AfterID: last}, 0)
+	assert.Len(t, later, len(snapshot)/200, "saved users come after the others")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	yields := 0
+	for _, err := range s.Users(canceled, models.UserFilter{}) {
+		assert.ErrorIs(t, err, context.Canceled)
+		yields++
+	}
+	assert.Equal(t, 1, yields, "the error ends the iteration")
+}
+
+func testApp(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	want := models.App{ID: 7, Name: "journal", Secret: "journal-secret"}
+	require.NoError(t, s.SeedApp(ctx, want))
+
+	app, err := s.App(ctx, want.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want, app)
+
+	_, err = s.App(ctx, 8)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+
+	app, err = s.AppByName(ctx, "journal")
+	require.NoError(t, err)
+	assert.Equal(t, want, app)
+
+	_, err = s.AppByName(ctx, "Journal")
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func testTokenTTL(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 7, Name: "kiosk", Secret: "kiosk-secret", TokenTTL: 24 * time.Hour}))
+	app, err := s.App(ctx, 7)
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, app.TokenTTL)
+
+	id, err := s.SaveUser(ctx, "kiosk@example.com", []byte("hash"), "Kiosk", "One", "")
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Zero(t, user.TokenTTL, "no override by default")
+
+	require.NoError(t, s.SetUserTokenTTL(ctx, id, 7*24*time.Hour))
+	user, err = s.User(ctx, "kiosk@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, user.TokenTTL)
+
+	require.NoError(t, s.SetUserTokenTTL(ctx, id, 0))
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Zero(t, user.TokenTTL, "zero removes the override")
+
+	err = s.SetUserTokenTTL(ctx, id+1000, time.Hour)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testAcceptTerms(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, user.TermsVersion)
+	assert.True(t, user.TermsAcceptedAt.IsZero())
+
+	acceptedAt := time.UnixMilli(time.Now().UnixMilli())
+	require.NoError(t, s.AcceptTerms(ctx, id, "2024-09", acceptedAt))
+	require.NoError(t, s.AcceptTerms(ctx, id, "2025-01", acceptedAt.Add(time.Hour)))
+
+	user, err = s.User(ctx, "john@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-01", user.TermsVersion)
+	assert.True(t, acceptedAt.Add(time.Hour).Equal(user.TermsAcceptedAt))
+
+	err = s.AcceptTerms(ctx, id+1000, "2025-01", acceptedAt)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testAuthorizations(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 7, Name: "journal", Secret: "journal-secret"}))
+	require.NoError(t, s.SeedRedirectURI(ctx, 7, "https://journal.example.com/callback"))
+
+	allowed, err := s.RedirectURIAllowed(ctx, 7, "https://journal.example.com/callback")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = s.RedirectURIAllowed(ctx, 7, "https://evil.example.com/callback")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	userID, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	expiresAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	want := models.Authorization{
+		ID:          "authz-1",
+		AppID:       7,
+		RedirectURI: "https://journal.example.com/callback",
+		State:       "xyz",
+		ExpiresAt:   expiresAt,
+	}
+	require.NoError(t, s.SaveAuthorization(ctx, want))
+
+	got, err := s.Authorization(ctx, want.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want.RedirectURI, got.RedirectURI)
+	assert.Equal(t, want.State, got.State)
+	assert.True(t, want.ExpiresAt.Equal(got.ExpiresAt))
+	assert.Empty(t, got.CodeHash)
+
+	_, err = s.Authorization(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrAuthorizationNotFound)
+
+	_, err = s.ConsumeAuthorizationCode(ctx, "code-hash", time.Now())
+	assert.ErrorIs(t, err, storage.ErrAuthorizationNotFound, "no code issued yet")
+
+	require.NoError(t, s.IssueAuthorizationCode(ctx, want.ID, userID, "code-hash", expiresAt))
+	err = s.IssueAuthorizationCode(ctx, want.ID, userID, "other-hash", expiresAt)
+	assert.ErrorIs(t, err, storage.ErrAuthorizationNotFound, "code is issued once")
+
+	consumed, err := s.ConsumeAuthorizationCode(ctx, "code-hash", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, want.ID, consumed.ID)
+	assert.Equal(t, userID, consumed.UserID)
+	assert.False(t, consumed.UsedAt.IsZero())
+
+	reused, err := s.ConsumeAuthorizationCode(ctx, "code-hash", time.Now())
+	assert.ErrorIs(t, err, storage.ErrAuthorizationUsed)
+	assert.Equal(t, want.ID, reused.ID)
+
+	user, err := s.UserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email)
+	_, err = s.UserByID(ctx, userID+1000)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testSigningKeys(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	keys, err := s.SigningKeys(ctx, 2)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	now := time.UnixMilli(time.Now().UnixMilli())
+	for i, id := range []string{"a", "b", "c"} {
+		require.NoError(t, s.SaveSigningKey(ctx, models.SigningKey{
+			ID:         id,
+			PrivateKey: []byte("der-" + id),
+			CreatedAt:  now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	keys, err = s.SigningKeys(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "c", keys[0].ID)
+	assert.Equal(t, []byte("der-c"), keys[0].PrivateKey)
+	assert.True(t, now.Add(2*time.Second).Equal(keys[0].CreatedAt))
+	assert.Equal(t, "b", keys[1].ID)
+}
+
+func testUsageStats(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "test-secret"}))
+
+	john, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	jane, err := s.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	now := time.Now()
+	attempts := []models.LoginAttempt{
+		{UserID: john, AppID: 1, Success: true, At: now.Add(-time.Hour)},
+		{UserID: john, AppID: 1, Success: true, At: now.Add(-2 * time.Hour)},
+		{UserID: jane, AppID: 1, Success: false, At: now.Add(-time.Hour)},
+		{UserID: jane, AppID: 1, Success: true, At: now.Add(-3 * 24 * time.Hour)},
+		{AppID: 1, Success: false, At: now.Add(-time.Minute)},
+		{UserID: jane, AppID: 1, Success: true, At: now.Add(-30 * 24 * time.Hour)},
+	}
+	for _, attempt := range attempts {
+		require.NoError(t, s.RecordLogin(ctx, attempt))
+	}
+
+	stats, err := s.UsageStats(ctx, now.Add(-7*24*time.Hour), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalUsers)
+	assert.Equal(t, int64(2), stats.Registrations)
+	assert.Equal(t, int64(3), stats.SuccessfulLogins)
+	assert.Equal(t, int64(2), stats.FailedLogins)
+	assert.Equal(t, int64(1), stats.DailyActive)
+	assert.Equal(t, int64(2), stats.WeeklyActive)
+
+	// The range bounds registrations and logins, but not the total.
+	stats, err = s.UsageStats(ctx, now.Add(-60*24*time.Hour), now.Add(-7*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.TotalUsers)
+	assert.Zero(t, stats.Registrations)
+	assert.Equal(t, int64(1), stats.SuccessfulLogins)
+	assert.Zero(t, stats.FailedLogins)
+	assert.Zero(t, stats.DailyActive)
+	assert.Zero(t, stats.WeeklyActive)
+}
+
+func testEvents(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	last, err := s.LastEventID(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, last)
+
+	at := time.UnixMilli(time.Now().UnixMilli())
+	var ids []int64
+	for i := range 5 {
+		id, err := s.SaveEvent(ctx, models.Event{
+			Type:      "user.registered",
+			UserID:    int64(i + 1),
+			Payload:   []byte(fmt.Sprintf(`{"n":%d}`, i)),
+			CreatedAt: at,
+		})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err = s.SaveEvent(ctx, models.Event{Type: "user.logged_in", UserID: 1, AppID: 7, Payload: []byte("{}"), CreatedAt: at})
+	require.NoError(t, err)
+
+	last, err = s.LastEventID(ctx)
+	require.NoError(t, err)
+
+	events, err := s.Events(ctx, ids[1], 3)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, ids[2], events[0].ID)
+	assert.Equal(t, int64(3), events[0].UserID)
+	assert.Equal(t, []byte(`{"n":2}`), events[0].Payload)
+	assert.True(t, at.Equal(events[0].CreatedAt))
+
+	events, err = s.Events(ctx, ids[4], 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, last, events[0].ID)
+	assert.Equal(t, int32(7), events[0].AppID)
+
+	events, err = s.Events(ctx, last, 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func testWebhooks(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "lms", Secret: "lms-secret"}))
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 2, Name: "library", Secret: "library-secret"}))
+
+	createdAt := time.UnixMilli(time.Now().UnixMilli())
+	id, err := s.SaveWebhook(ctx, models.Webhook{
+		AppID:        1,
+		URL:          "https://lms.example.com/hooks",
+		Secret:       "hook-secret",
+		EventTypes:   []string{"user.registered", "user.logged_in"},
+		CreatedAt:    createdAt,
+		WebhookState: models.WebhookState{Active: true, LastEventID: 42},
+	})
+	require.NoError(t, err)
+	otherID, err := s.SaveWebhook(ctx, models.Webhook{
+		AppID:        2,
+		URL:          "https://library.example.com/hooks",
+		Secret:       "other-secret",
+		EventTypes:   []string{"user.registered"},
+		CreatedAt:    createdAt,
+		WebhookState: models.WebhookState{Active: true},
+	})
+	require.NoError(t, err)
+
+	webhook, err := s.Webhook(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), webhook.AppID)
+	assert.Equal(t, "https://lms.example.com/hooks", webhook.URL)
+	assert.Equal(t, "hook-secret", webhook.Secret)
+	assert.Equal(t, []string{"user.registered", "user.logged_in"}, webhook.EventTypes)
+	assert.True(t, webhook.Active)
+	assert.Equal(t, int64(42), webhook.LastEventID)
+	assert.True(t, createdAt.Equal(webhook.CreatedAt))
+
+	webhooks, err := s.Webhooks(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 1)
+	assert.Equal(t, otherID, webhooks[0].ID)
+
+	webhooks, err = s.Webhooks(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, webhooks, 2)
+	assert.Equal(t, id, webhooks[0].ID)
+
+	nextAttemptAt := createdAt.Add(time.Minute)
+	state := models.WebhookState{LastEventID: 50, Failures: 3, NextAttemptAt: nextAttemptAt}
+	require.NoError(t, s.UpdateWebhookState(ctx, id, state))
+	webhook, err = s.Webhook(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, webhook.Active)
+	assert.Equal(t, int64(50), webhook.LastEventID)
+	assert.Equal(t, 3, webhook.Failures)
+	assert.True(t, nextAttemptAt.Equal(webhook.NextAttemptAt))
+
+	require.NoError(t, s.DeleteWebhook(ctx, id))
+	_, err = s.Webhook(ctx, id)
+	assert.ErrorIs(t, err, errs.ErrWebhookNotFound)
+	assert.ErrorIs(t, s.DeleteWebhook(ctx, id), errs.ErrWebhookNotFound)
+	assert.ErrorIs(t, s.UpdateWebhookState(ctx, id, state), errs.ErrWebhookNotFound)
+}
+
+func testInvites(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	createdAt := time.UnixMilli(time.Now().UnixMilli())
+	expiresAt := createdAt.Add(24 * time.Hour)
+	id, err := s.SaveInvite(ctx, models.Invite{
+		CodeHash:      "hash",
+		Email:         "john@example.com",
+		UsesRemaining: 1,
+		ExpiresAt:     expiresAt,
+		CreatedAt:     createdAt,
+	})
+	require.NoError(t, err)
+	openID, err := s.SaveInvite(ctx, models.Invite{CodeHash: "open-hash", UsesRemaining: 2, CreatedAt: createdAt})
+	require.NoError(t, err)
+	assert.NotEqual(t, id, openID)
+
+	invite, err := s.Invite(ctx, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, id, invite.ID)
+	assert.Equal(t, "john@example.com", invite.Email)
+	assert.Equal(t, 1, invite.UsesRemaining)
+	assert.True(t, expiresAt.Equal(invite.ExpiresAt))
+	assert.True(t, createdAt.Equal(invite.CreatedAt))
+
+	invite, err = s.Invite(ctx, "open-hash")
+	require.NoError(t, err)
+	assert.Empty(t, invite.Email)
+	assert.True(t, invite.ExpiresAt.IsZero())
+
+	_, err = s.Invite(ctx, "unknown")
+	assert.ErrorIs(t, err, errs.ErrInviteNotFound)
+
+	usedAt := createdAt.Add(time.Minute)
+	userID, err := s.SaveInvitedUser(ctx, id, 0, "john@example.com", []byte("hash"), "John", "Doe", "", usedAt)
+	require.NoError(t, err)
+	user, err := s.UserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email)
+
+	invite, err = s.Invite(ctx, "hash")
+	require.NoError(t, err)
+	assert.Zero(t, invite.UsesRemaining)
+
+	_, err = s.SaveInvitedUser(ctx, id, 0, "jane@example.com", []byte("hash"), "Jane", "Doe", "", usedAt)
+	assert.ErrorIs(t, err, errs.ErrInviteUsedUp)
+	_, err = s.User(ctx, "jane@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	_, err = s.SaveInvitedUser(ctx, openID+1000, 0, "jane@example.com", []byte("hash"), "Jane", "Doe", "", usedAt)
+	assert.ErrorIs(t, err, errs.ErrInviteNotFound)
+
+	// A failed registration keeps the use.
+	_, err = s.SaveInvitedUser(ctx, openID, 0, "john@example.com", []byte("hash"), "John", "Doe", "", usedAt)
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+	invite, err = s.Invite(ctx, "open-hash")
+	require.NoError(t, err)
+	assert.Equal(t, 2, invite.UsesRemaining)
+
+	uses, err := s.InviteUses(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, uses, 1)
+	assert.Equal(t, userID, uses[0].UserID)
+	assert.True(t, usedAt.Equal(uses[0].UsedAt))
+
+	uses, err = s.InviteUses(ctx, openID)
+	require.NoError(t, err)
+	assert.Empty(t, uses)
+}
+
+func testLinkedIdentities(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	john, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	// Jane has no password, so she can only sign in with a provider.
+	jane, err := s.SaveUser(ctx, "jane@example.com", []byte{}, "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	linkedAt := time.UnixMilli(time.Now().UnixMilli())
+	link := func(userID int64, provider, subject string) error {
+		linkedAt = linkedAt.Add(time.Second)
+
+		return s.LinkIdentity(ctx, models.LinkedIdentity{
+			UserID:   userID,
+			Provider: provider,
+			Subject:  subject,
+			Email:    "john@gmail.com",
+			LinkedAt: linkedAt,
+		})
+	}
+	require.NoError(t, link(john, "google", "g-1"))
+	require.NoError(t, link(john, "github", "gh-1"))
+	githubAt := linkedAt
+
+	assert.ErrorIs(t, link(jane, "google", "g-1"), errs.ErrIdentityLinked)
+	assert.ErrorIs(t, link(john, "google", "g-2"), errs.ErrIdentityLinked)
+	assert.ErrorIs(t, link(jane+1000, "google", "g-3"), errs.ErrUserNotFound)
+
+	identities, err := s.LinkedIdentities(ctx, john)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+	assert.Equal(t, "google", identities[0].Provider)
+	assert.Equal(t, "g-1", identities[0].Subject)
+	assert.Equal(t, john, identities[0].UserID)
+	assert.Equal(t, "john@gmail.com", identities[0].Email)
+	assert.Equal(t, "github", identities[1].Provider)
+	assert.True(t, githubAt.Equal(identities[1].LinkedAt))
+
+	identities, err = s.LinkedIdentities(ctx, jane)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+
+	require.NoError(t, link(jane, "google", "g-2"))
+	assert.ErrorIs(t, s.UnlinkIdentity(ctx, jane, "google"), errs.ErrLastLoginMethod)
+	require.NoError(t, link(jane, "github", "gh-2"))
+	require.NoError(t, s.UnlinkIdentity(ctx, jane, "google"))
+	assert.ErrorIs(t, s.UnlinkIdentity(ctx, jane, "github"), errs.ErrLastLoginMethod)
+
+	assert.ErrorIs(t, s.UnlinkIdentity(ctx, john, "gitlab"), errs.ErrIdentityNotFound)
+	assert.ErrorIs(t, s.UnlinkIdentity(ctx, jane+1000, "google"), errs.ErrIdentityNotFound)
+
+	// John keeps his password.
+	require.NoError(t, s.UnlinkIdentity(ctx, john, "google"))
+	require.NoError(t, s.UnlinkIdentity(ctx, john, "github"))
+	identities, err = s.LinkedIdentities(ctx, john)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+
+	// An unlinked account can be linked again, to anyone.
+	assert.NoError(t, link(jane, "google", "g-1"))
+}
+
+func testDeferredDeletion(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	john, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "Jr")
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUserRole(ctx, john, "admin"))
+	require.NoError(t, s.LinkIdentity(ctx, models.LinkedIdentity{
+		UserID: john, Provider: "google", Subject: "g-1", LinkedAt: time.Now(),
+	}))
+	jane, err := s.SaveUser(ctx, "jane@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+
+	now := time.UnixMilli(time.Now().UnixMilli())
+	deadline := now.Add(time.Hour)
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "secret"}))
+	require.NoError(t, s.SaveChallenge(ctx, models.Challenge{
+		TokenHash: "john-challenge", Type: models.ChallengeTOSAccept, UserID: john, AppID: 1, ExpiresAt: deadline,
+	}))
+
+	assert.ErrorIs(t, s.ScheduleDeletion(ctx, jane+1000, deadline, "h", now), errs.ErrUserNotFound)
+	require.NoError(t, s.ScheduleDeletion(ctx, john, deadline, "john-hash", now))
+	require.NoError(t, s.ScheduleDeletion(ctx, jane, deadline, "jane-hash", now))
+
+	user, err := s.UserByID(ctx, john)
+	require.NoError(t, err)
+	assert.True(t, deadline.Equal(user.DeleteAfter))
+	assert.True(t, now.Equal(user.SessionsRevokedAt))
+
+	// Nothing is due before the deadline.
+	ids, err := s.AnonymizeDueUsers(ctx, deadline.Add(-time.Millisecond), 10)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	assert.ErrorIs(t, s.CancelDeletion(ctx, jane, "john-hash", now), errs.ErrInvalidReactivationToken)
+	assert.ErrorIs(t, s.CancelDeletion(ctx, jane, "jane-hash", deadline), errs.ErrInvalidReactivationToken)
+	require.NoError(t, s.CancelDeletion(ctx, jane, "jane-hash", now))
+	assert.ErrorIs(t, s.CancelDeletion(ctx, jane, "jane-hash", now), errs.ErrInvalidReactivationToken)
+
+	user, err = s.UserByID(ctx, jane)
+	require.NoError(t, err)
+	assert.True(t, user.DeleteAfter.IsZero())
+	// Cancelling does not bring revoked tokens back.
+	assert.True(t, now.Equal(user.SessionsRevokedAt))
+
+	ids, err = s.AnonymizeDueUsers(ctx, deadline, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{john}, ids)
+
+	_, err = s.User(ctx, "john@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+	user, err = s.UserByID(ctx, john)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("deleted-%d@deleted.invalid", john), user.Email)
+	assert.Empty(t, user.PassHash)
+	assert.Empty(t, user.FirstName)
+	assert.Empty(t, user.MiddleName)
+	assert.True(t, user.DeleteAfter.IsZero())
+	_, err = s.UserRole(ctx, john)
+	assert.ErrorIs(t, err, storage.ErrRoleNotFound)
+	identities, err := s.LinkedIdentities(ctx, john)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+	_, err = s.Challenge(ctx, "john-challenge")
+	assert.ErrorIs(t, err, errs.ErrInvalidChallenge)
+
+	// Anonymization is done once, and cannot be scheduled again.
+	ids, err = s.AnonymizeDueUsers(ctx, deadline.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.ErrorIs(t, s.ScheduleDeletion(ctx, john, deadline, "h", now), errs.ErrUserNotFound)
+	assert.ErrorIs(t, s.CancelDeletion(ctx, john, "john-hash", now), errs.ErrInvalidReactivationToken)
+
+	_, err = s.UserByID(ctx, jane)
+	require.NoError(t, err)
+}
+
+func testChallenges(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "secret"}))
+	userID, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	expiresAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	want := models.Challenge{
+		TokenHash: "hash-1",
+		Type:      models.ChallengeTOSAccept,
+		UserID:    userID,
+		AppID:     1,
+		ExpiresAt: expiresAt,
+	}
+	require.NoError(t, s.SaveChallenge(ctx, want))
+	assert.Error(t, s.SaveChallenge(ctx, want))
+
+	_, err = s.Challenge(ctx, "hash-2")
+	assert.ErrorIs(t, err, errs.ErrInvalidChallenge)
+
+	got, err := s.Challenge(ctx, "hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, want.Type, got.Type)
+	assert.Equal(t, want.UserID, got.UserID)
+	assert.Equal(t, want.AppID, got.AppID)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+	assert.True(t, got.UsedAt.IsZero())
+
+	usedAt := time.UnixMilli(time.Now().UnixMilli())
+	assert.ErrorIs(t, s.ConsumeChallenge(ctx, "hash-2", usedAt), errs.ErrInvalidChallenge)
+	require.NoError(t, s.ConsumeChallenge(ctx, "hash-1", usedAt))
+	// A challenge is completed once.
+	assert.ErrorIs(t, s.ConsumeChallenge(ctx, "hash-1", usedAt), errs.ErrInvalidChallenge)
+
+	got, err = s.Challenge(ctx, "hash-1")
+	require.NoError(t, err)
+	assert.True(t, usedAt.Equal(got.UsedAt))
+}
+
+func testTokenIssuances(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "test", Secret: "secret"}))
+	userID, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+
+	issuedAt := time.UnixMilli(time.Now().UnixMilli())
+	want := models.TokenIssuance{
+		Fingerprint: "fp-1",
+		JTI:         "jti-1",
+		Kind:        models.TokenLogin,
+		UserID:      userID,
+		AppID:       1,
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(time.Hour),
+		RetainUntil: issuedAt.Add(2 * time.Hour),
+		Device:      "Chrome on Windows",
+	}
+	require.NoError(t, s.SaveTokenIssuance(ctx, want))
+	assert.Error(t, s.SaveTokenIssuance(ctx, want))
+
+	expired := want
+	expired.Fingerprint = "fp-2"
+	expired.JTI = "jti-2"
+	expired.RetainUntil = issuedAt.Add(-time.Minute)
+	require.NoError(t, s.SaveTokenIssuance(ctx, expired))
+
+	got, err := s.TokenIssuance(ctx, "fp-1")
+	require.NoError(t, err)
+	assert.Equal(t, want.JTI, got.JTI)
+	assert.Equal(t, want.Kind, got.Kind)
+	assert.Equal(t, want.UserID, got.UserID)
+	assert.Equal(t, want.AppID, got.AppID)
+	assert.True(t, want.IssuedAt.Equal(got.IssuedAt))
+	assert.True(t, want.ExpiresAt.Equal(got.ExpiresAt))
+	assert.True(t, want.RetainUntil.Equal(got.RetainUntil))
+	assert.Equal(t, want.Device, got.Device)
+
+	_, err = s.TokenIssuance(ctx, "fp-3")
+	assert.ErrorIs(t, err, errs.ErrTokenNotIssued)
+
+	n, err := s.PurgeTokenIssuances(ctx, issuedAt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	_, err = s.TokenIssuance(ctx, "fp-2")
+	assert.ErrorIs(t, err, errs.ErrTokenNotIssued)
+	_, err = s.TokenIssuance(ctx, "fp-1")
+	assert.NoError(t, err)
+}
+
+func testRevokedTokens(t *testing.T, s Storage) {
+	ctx := context.Background()
+	now := time.UnixMilli(time.Now().UnixMilli())
+
+	revoked, err := s.TokenRevoked(ctx, "jti-1")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", now.Add(time.Hour)))
+	// Revoking again is no error, and keeps the first expiry.
+	require.NoError(t, s.RevokeToken(ctx, "jti-1", now.Add(-time.Hour)))
+	require.NoError(t, s.RevokeToken(ctx, "jti-2", now.Add(-time.Minute)))
+
+	for _, jti := range []string{"jti-1", "jti-2"} {
+		revoked, err = s.TokenRevoked(ctx, jti)
+		require.NoError(t, err)
+		assert.True(t, revoked, jti)
+	}
+
+	n, err := s.PurgeRevokedTokens(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	revoked, err = s.TokenRevoked(ctx, "jti-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = s.TokenRevoked(ctx, "jti-2")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func testPermissions(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "lms", Secret: "secret"}))
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 2, Name: "other", Secret: "other-secret"}))
+	teacher, err := s.SaveUser(ctx, "teacher@example.com", []byte("hash"), "Jane", "Doe", "")
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUserRole(ctx, teacher, "teacher"))
+	student, err := s.SaveUser(ctx, "student@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUserRole(ctx, student, "student"))
+
+	assert.ErrorIs(t, s.AttachPermission(ctx, "nobody", 1, "can_grade"), errs.ErrRoleNotFound)
+	assert.ErrorIs(t, s.AttachPermission(ctx, "teacher", 3, "can_grade"), errs.ErrAppNotFound)
+
+	require.NoError(t, s.AttachPermission(ctx, "teacher", 1, "can_grade"))
+	require.NoError(t, s.AttachPermission(ctx, "teacher", 1, "can_manage_enrollments"))
+	require.NoError(t, s.AttachPermission(ctx, "teacher", 1, "can_grade"))
+	require.NoError(t, s.AttachPermission(ctx, "teacher", 2, "can_publish"))
+	require.NoError(t, s.AttachPermission(ctx, "student", 1, "can_submit"))
+
+	perms, err := s.RolePermissions(ctx, "teacher", 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_grade", "can_manage_enrollments"}, perms)
+
+	perms, err = s.UserPermissions(ctx, teacher, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_grade", "can_manage_enrollments"}, perms)
+	perms, err = s.UserPermissions(ctx, teacher, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_publish"}, perms)
+	perms, err = s.UserPermissions(ctx, student, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_submit"}, perms)
+
+	// Unknown users and apps have none.
+	perms, err = s.UserPermissions(ctx, teacher+student, 1)
+	require.NoError(t, err)
+	assert.Empty(t, perms)
+	perms, err = s.UserPermissions(ctx, student, 2)
+	require.NoError(t, err)
+	assert.NotNil(t, perms)
+	assert.Empty(t, perms)
+
+	require.NoError(t, s.DetachPermission(ctx, "teacher", 1, "can_grade"))
+	assert.ErrorIs(t, s.DetachPermission(ctx, "teacher", 1, "can_grade"), errs.ErrPermissionNotFound)
+	assert.ErrorIs(t, s.DetachPermission(ctx, "teacher", 2, "can_submit"), errs.ErrPermissionNotFound)
+
+	perms, err = s.UserPermissions(ctx, teacher, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"can_manage_enrollments"}, perms)
+}
+
+func testOrgUnits(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	uni, err := s.SaveOrgUnit(ctx, models.OrgUnit{Name: "University"})
+	require.NoError(t, err)
+	faculty, err := s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: uni, Name: "Faculty of Physics"})
+	require.NoError(t, err)
+	dept, err := s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: faculty, Name: "Optics"})
+	require.NoError(t, err)
+	other, err := s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: uni, Name: "Faculty of Law"})
+	require.NoError(t, err)
+
+	// Names are unique among siblings only, roots included.
+	_, err = s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: uni, Name: "Faculty of Law"})
+	assert.ErrorIs(t, err, errs.ErrOrgUnitExists)
+	_, err = s.SaveOrgUnit(ctx, models.OrgUnit{Name: "University"})
+	assert.ErrorIs(t, err, errs.ErrOrgUnitExists)
+	_, err = s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: other, Name: "Optics"})
+	require.NoError(t, err)
+	_, err = s.SaveOrgUnit(ctx, models.OrgUnit{ParentID: dept + 100, Name: "Lost"})
+	assert.ErrorIs(t, err, errs.ErrOrgUnitNotFound)
+
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, uni, uni), errs.ErrOrgUnitCycle)
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, uni, dept), errs.ErrOrgUnitCycle)
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, dept, other), errs.ErrOrgUnitExists)
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, dept, dept+100), errs.ErrOrgUnitNotFound)
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, dept+100, uni), errs.ErrOrgUnitNotFound)
+
+	john, err := s.SaveUser(ctx, "john@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	require.NoError(t, s.SeedUserRole(ctx, john, "student"))
+	require.NoError(t, s.SeedUnitRole(ctx, john, faculty, "dean"))
+	require.NoError(t, s.SeedUnitRole(ctx, john, dept, "teacher"))
+
+	// Roles hold below the unit they are given in, global ones everywhere.
+	roles, err := s.UnitRoles(ctx, john, dept)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dean", "student", "teacher"}, roles)
+	roles, err = s.UnitRoles(ctx, john, other)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"student"}, roles)
+	roles, err = s.UnitRoles(ctx, john+1, uni)
+	require.NoError(t, err)
+	assert.NotNil(t, roles)
+	assert.Empty(t, roles)
+	_, err = s.UnitRoles(ctx, john, dept+100)
+	assert.ErrorIs(t, err, errs.ErrOrgUnitNotFound)
+
+	// Unit roles are not global ones.
+	role, err := s.UserRole(ctx, john)
+	require.NoError(t, err)
+	assert.Equal(t, "student", role)
+
+	// A moved unit takes what is below it along and inherits from its new
+	// parent only.
+	require.NoError(t, s.MoveOrgUnit(ctx, faculty, other))
+	roles, err = s.UnitRoles(ctx, john, other)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"student"}, roles)
+	assert.ErrorIs(t, s.MoveOrgUnit(ctx, other, dept), errs.ErrOrgUnitCycle)
+	require.NoError(t, s.MoveOrgUnit(ctx, faculty, 0))
+
+	units, err := s.OrgUnits(ctx)
+	require.NoError(t, err)
+	require.Len(t, units, 5)
+	assert.Equal(t, models.OrgUnit{ID: faculty, Name: "Faculty of Physics"}, units[1])
+	assert.Equal(t, models.OrgUnit{ID: dept, ParentID: faculty, Name: "Optics"}, units[2])
+}
+
+func testActivation(t *testing.T, s Storage) {
+	ctx := context.Background()
+	now := time.UnixMilli(time.Now().UnixMilli())
+	expiresAt := now.Add(time.Hour)
+
+	jane, err := s.PreRegisterUser(ctx, "jane@example.com", "Jane", "Doe", "", "jane-hash", expiresAt)
+	require.NoError(t, err)
+	john, err := s.PreRegisterUser(ctx, "john@example.com", "John", "Doe", "", "john-hash", expiresAt)
+	require.NoError(t, err)
+	_, err = s.PreRegisterUser(ctx, "jane@example.com", "Jane", "Doe", "", "other-hash", expiresAt)
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+
+	user, err := s.User(ctx, "jane@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.ActivationPending)
+	assert.Empty(t, user.PassHash)
+	assert.Equal(t, "Jane", user.FirstName)
+
+	// Nobody activates with an expired or unknown token.
+	_, err = s.ActivateUser(ctx, "jane-hash", []byte("hash"), expiresAt)
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+	_, err = s.ActivateUser(ctx, "unknown-hash", []byte("hash"), now)
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+
+	id, err := s.ActivateUser(ctx, "jane-hash", []byte("hash"), now)
+	require.NoError(t, err)
+	assert.Equal(t, jane, id)
+	// A token is used once.
+	_, err = s.ActivateUser(ctx, "jane-hash", []byte("other"), now)
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+
+	user, err = s.UserByID(ctx, jane)
+	require.NoError(t, err)
+	assert.False(t, user.ActivationPending)
+	assert.Equal(t, []byte("hash"), user.PassHash)
+
+	assert.ErrorIs(t, s.SetActivationToken(ctx, jane, "new-hash", expiresAt), errs.ErrAccountActivated)
+	assert.ErrorIs(t, s.SetActivationToken(ctx, jane+john, "new-hash", expiresAt), errs.ErrUserNotFound)
+
+	// Revoked, then re-issued: only the new token works.
+	require.NoError(t, s.SetActivationToken(ctx, john, "", time.Time{}))
+	_, err = s.ActivateUser(ctx, "john-hash", []byte("hash"), now)
+	assert.ErrorIs(t, err, errs.ErrInvalidActivationToken)
+	require.NoError(t, s.SetActivationToken(ctx, john, "john-new-hash", expiresAt))
+	id, err = s.ActivateUser(ctx, "john-new-hash", []byte("hash"), now)
+	require.NoError(t, err)
+	assert.Equal(t, john, id)
+}
+
+func testUnicodeEmails(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	emails := []string{"пользователь@пример.рф", "用户@例子.中国", "user@example.com"}
+	ids := make(map[int64]string, len(emails))
+	for _, email := range emails {
+		id, err := s.SaveUser(ctx, email, []byte("hash"), "Имя", "Фамилия", "")
+		require.NoError(t, err)
+		ids[id] = email
+	}
+	assert.Len(t, ids, len(emails))
+
+	for id, email := range ids {
+		user, err := s.User(ctx, email)
+		require.NoError(t, err)
+		assert.Equal(t, id, user.ID)
+		assert.Equal(t, email, user.Email)
+		assert.Equal(t, "Имя", user.FirstName)
+	}
+}
+
+func testConcurrentSaveUser(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	const goroutines = 20
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		failures  []error
+	)
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.SaveUser(ctx, "race@example.com", []byte("hash"), fmt.Sprint(i), "Doe", "")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+				return
+			}
+			failures = append(failures, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, succeeded)
+	for _, err := range failures {
+		assert.ErrorIs(t, err, storage.ErrUserExists)
+	}
+}
+
+func testAppQuota(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "pilot", Secret: "pilot-secret"}))
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 2, Name: "lms", Secret: "lms-secret"}))
+
+	// Without quota, users are counted all the same.
+	_, err := s.SaveAppUser(ctx, 1, "first@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	_, err = s.SaveAppUser(ctx, 2, "other@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	_, err = s.SaveUser(ctx, "direct@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	quota, err := s.AppQuota(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.AppQuota{AppID: 1, Users: 1}, quota)
+
+	require.NoError(t, s.SetAppMaxUsers(ctx, 1, 3))
+	app, err := s.App(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, app.MaxUsers)
+
+	inviteID, err := s.SaveInvite(ctx, models.Invite{CodeHash: "quota-hash", UsesRemaining: 2, CreatedAt: time.Now()})
+	require.NoError(t, err)
+	_, err = s.SaveInvitedUser(ctx, inviteID, 1, "invited@example.com", []byte("hash"), "Jane", "Doe", "", time.Now())
+	require.NoError(t, err)
+
+	// Exactly at the quota: the last slot is taken, the next is refused.
+	_, err = s.SaveAppUser(ctx, 1, "last@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	_, err = s.SaveAppUser(ctx, 1, "over@example.com", []byte("hash"), "John", "Doe", "")
+	require.ErrorIs(t, err, errs.ErrQuotaExceeded)
+	assert.Equal(t, map[string]string{"max_users": "3"}, errs.MetadataOf(err))
+	// An invite does not get around it, and keeps its use.
+	_, err = s.SaveInvitedUser(ctx, inviteID, 1, "over@example.com", []byte("hash"), "John", "Doe", "", time.Now())
+	assert.ErrorIs(t, err, errs.ErrQuotaExceeded)
+	invite, err := s.Invite(ctx, "quota-hash")
+	require.NoError(t, err)
+	assert.Equal(t, 1, invite.UsesRemaining)
+	_, err = s.User(ctx, "over@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+
+	quota, err = s.AppQuota(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, models.AppQuota{AppID: 1, MaxUsers: 3, Users: 3}, quota)
+
+	// Raised, there is room again; cleared, there is no limit.
+	require.NoError(t, s.SetAppMaxUsers(ctx, 1, 4))
+	_, err = s.SaveAppUser(ctx, 1, "raised@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	require.NoError(t, s.SetAppMaxUsers(ctx, 1, 0))
+	_, err = s.SaveAppUser(ctx, 1, "cleared@example.com", []byte("hash"), "John", "Doe", "")
+	require.NoError(t, err)
+	app, err = s.App(ctx, 1)
+	require.NoError(t, err)
+	assert.Zero(t, app.MaxUsers)
+
+	_, err = s.SaveAppUser(ctx, 99, "unknown@example.com", []byte("hash"), "John", "Doe", "")
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+	assert.ErrorIs(t, s.SetAppMaxUsers(ctx, 99, 1), errs.ErrAppNotFound)
+	_, err = s.AppQuota(ctx, 99)
+	assert.ErrorIs(t, err, errs.ErrAppNotFound)
+
+	// A duplicate email is not a quota problem.
+	_, err = s.SaveAppUser(ctx, 2, "first@example.com", []byte("hash"), "John", "Doe", "")
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+}
+
+func testConcurrentAppQuota(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "pilot", Secret: "pilot-secret"}))
+
+	const (
+		goroutines = 20
+		maxUsers   = 5
+	)
+	require.NoError(t, s.SetAppMaxUsers(ctx, 1, maxUsers))
+	// Only the last slot is left to race for.
+	for i := range maxUsers - 1 {
+		_, err := s.SaveAppUser(ctx, 1, fmt.Sprintf("user%d@example.com", i), []byte("hash"), "John", "Doe", "")
+		require.NoError(t, err)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+		failures  []error
+	)
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.SaveAppUser(ctx, 1, fmt.Sprintf("race%d@example.com", i), []byte("hash"), "John", "Doe", "")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				succeeded++
+				return
+			}
+			failures = append(failures, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, succeeded)
+	for _, err := range failures {
+		assert.ErrorIs(t, err, errs.ErrQuotaExceeded)
+	}
+	quota, err := s.AppQuota(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, maxUsers, quota.Users)
+}
+
+func testContextCancellation(t *testing.T, s Storage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.SaveUser(ctx, "cancelled@example.com", []byte("hash"), "John", "Doe", "")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.User(ctx, "cancelled@example.com")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = s.User(context.Background(), "cancelled@example.com")
+	assert.True(t, errors.Is(err, storage.ErrUserNotFound), "cancelled SaveUser must not create the user, got %v", err)
+}
+
+func testRegistrations(t *testing.T, s Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.SeedApp(ctx, models.App{ID: 1, Name: "pilot", Secret: "pilot-secret"}))
+	require.NoError(t, s.SetAppMaxUsers(ctx, 1, 1))
+	inviteID, err := s.SaveInvite(ctx, models.Invite{CodeHash: "hash", UsesRemaining: 2, CreatedAt: time.Now()})
+	require.NoError(t, err)
+
+	registeredAt := time.UnixMilli(time.Now().UnixMilli())
+	reg := models.Registration{
+		Email:        "john@example.com",
+		PassHash:     []byte("hash"),
+		FirstName:    "John",
+		LastName:     "Doe",
+		AppID:        1,
+		InviteID:     inviteID,
+		TermsVersion: "2024-01",
+		RegisteredAt: registeredAt,
+		Event: models.Event{
+			Type:      models.EventUserRegistered,
+			Payload:   []byte(`{"email":"john@example.com"}`),
+			CreatedAt: registeredAt,
+		},
+	}
+	id, err := s.SaveRegistration(ctx, reg)
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email)
+	assert.Equal(t, "2024-01", user.TermsVersion)
+	assert.True(t, registeredAt.Equal(user.TermsAcceptedAt))
+
+	uses, err := s.InviteUses(ctx, inviteID)
+	require.NoError(t, err)
+	require.Len(t, uses, 1)
+	assert.Equal(t, id, uses[0].UserID)
+
+	events, err := s.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.EventUserRegistered, events[0].Type)
+	assert.Equal(t, id, events[0].UserID)
+	assert.Zero(t, events[0].AppID)
+
+	// A refused registration leaves nothing behind: not the invite use,
+	// not the event.
+	reg.Email = "jane@example.com"
+	_, err = s.SaveRegistration(ctx, reg)
+	assert.ErrorIs(t, err, errs.ErrQuotaExceeded)
+	_, err = s.User(ctx, "jane@example.com")
+	assert.ErrorIs(t, err, errs.ErrUserNotFound)
+	invite, err := s.Invite(ctx, "hash")
+	require.NoError(t, err)
+	assert.Equal(t, 1, invite.UsesRemaining)
+
+	reg.AppID = 0
+	reg.Email = "john@example.com"
+	_, err = s.SaveRegistration(ctx, reg)
+	assert.ErrorIs(t, err, errs.ErrUserExists)
+
+	_, err = s.SaveRegistration(ctx, models.Registration{Email: "jane@example.com", InviteID: inviteID + 1000})
+	assert.ErrorIs(t, err, errs.ErrInviteNotFound)
+
+	events, err = s.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	// Without extras, only the user is saved.
+	id, err = s.SaveRegistration(ctx, models.Registration{Email: "jane@example.com", PassHash: []byte("hash")})
+	require.NoError(t, err)
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, user.TermsVersion)
+	events, err = s.Events(ctx, 0, 10)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
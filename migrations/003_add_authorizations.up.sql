@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS app_redirect_uris (
+    app_id INTEGER NOT NULL,
+    uri TEXT NOT NULL,
+    PRIMARY KEY (app_id, uri),
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+
+CREATE TABLE IF NOT EXISTS authorizations (
+    id TEXT PRIMARY KEY,
+    app_id INTEGER NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    state TEXT NOT NULL,
+    user_id INTEGER,
+    code_hash TEXT UNIQUE,
+    expires_at INTEGER NOT NULL,
+    used_at INTEGER,
+    FOREIGN KEY (app_id) REFERENCES apps(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN token_ttl_seconds;
+ALTER TABLE users DROP COLUMN token_ttl_seconds;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN token_ttl_seconds INTEGER;
+ALTER TABLE apps ADD COLUMN token_ttl_seconds INTEGER;
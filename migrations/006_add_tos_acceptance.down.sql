@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN tos_accepted_at;
+ALTER TABLE users DROP COLUMN tos_version_accepted;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN tos_version_accepted TEXT;
+ALTER TABLE users ADD COLUMN tos_accepted_at INTEGER;
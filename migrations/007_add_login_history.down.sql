@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_login_history_created_at;
+DROP TABLE IF EXISTS login_history;
+DROP INDEX IF EXISTS idx_users_created_at;
+ALTER TABLE users DROP COLUMN created_at;
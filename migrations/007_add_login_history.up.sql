@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN created_at INTEGER;
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at);
+
+CREATE TABLE IF NOT EXISTS login_history (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER,
+    app_id INTEGER NOT NULL,
+    success INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+CREATE INDEX IF NOT EXISTS idx_login_history_created_at ON login_history (created_at, success, user_id);
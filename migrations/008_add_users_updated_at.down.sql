@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_updated_at;
+ALTER TABLE users DROP COLUMN updated_at;
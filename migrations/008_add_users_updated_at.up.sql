@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN updated_at INTEGER;
+UPDATE users SET updated_at = created_at;
+CREATE INDEX IF NOT EXISTS idx_users_updated_at ON users (updated_at);
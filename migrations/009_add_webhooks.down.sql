@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_webhook_subscriptions_app_id;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS events;
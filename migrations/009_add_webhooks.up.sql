@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS events (
+    id INTEGER PRIMARY KEY,
+    type TEXT NOT NULL,
+    user_id INTEGER,
+    app_id INTEGER,
+    payload BLOB NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INTEGER PRIMARY KEY,
+    app_id INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    last_event_id INTEGER NOT NULL DEFAULT 0,
+    failures INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL,
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_subscriptions_app_id ON webhook_subscriptions (app_id);
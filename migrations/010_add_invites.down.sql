@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS invite_uses;
+DROP TABLE IF EXISTS invites;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_delete_after;
+ALTER TABLE users DROP COLUMN deleted_at;
+ALTER TABLE users DROP COLUMN sessions_revoked_at;
+ALTER TABLE users DROP COLUMN reactivation_token_hash;
+ALTER TABLE users DROP COLUMN delete_after;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN delete_after INTEGER;
+ALTER TABLE users ADD COLUMN reactivation_token_hash TEXT;
+ALTER TABLE users ADD COLUMN sessions_revoked_at INTEGER;
+ALTER TABLE users ADD COLUMN deleted_at INTEGER;
+CREATE INDEX IF NOT EXISTS idx_users_delete_after ON users (delete_after) WHERE delete_after IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_login_challenges_user_id;
+DROP TABLE IF EXISTS login_challenges;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id INTEGER PRIMARY KEY,
+    app_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    UNIQUE (app_id, name),
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role TEXT NOT NULL,
+    permission_id INTEGER NOT NULL,
+    PRIMARY KEY (role, permission_id),
+    FOREIGN KEY (permission_id) REFERENCES permissions(id)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_activation_token_hash;
+ALTER TABLE users DROP COLUMN activation_expires_at;
+ALTER TABLE users DROP COLUMN activation_token_hash;
+ALTER TABLE users DROP COLUMN activation_pending;
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN activation_pending INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN activation_token_hash TEXT;
+ALTER TABLE users ADD COLUMN activation_expires_at INTEGER;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_activation_token_hash ON users (activation_token_hash) WHERE activation_token_hash IS NOT NULL;
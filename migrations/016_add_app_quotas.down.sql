@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_app_id;
+ALTER TABLE users DROP COLUMN app_id;
+ALTER TABLE apps DROP COLUMN max_users;
@@ -0,0 +1,3 @@
+ALTER TABLE apps ADD COLUMN max_users INTEGER;
+ALTER TABLE users ADD COLUMN app_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_users_app_id ON users (app_id) WHERE app_id IS NOT NULL;
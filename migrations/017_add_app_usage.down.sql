@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_app_usage_day;
+DROP TABLE IF EXISTS app_usage;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS app_usage (
+    app_id INTEGER NOT NULL,
+    day INTEGER NOT NULL,
+    logins INTEGER NOT NULL DEFAULT 0,
+    tokens_issued INTEGER NOT NULL DEFAULT 0,
+    refreshes INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (app_id, day),
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+CREATE INDEX IF NOT EXISTS idx_app_usage_day ON app_usage (day);
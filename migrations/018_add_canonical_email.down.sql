@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_canonical_email;
+ALTER TABLE users DROP COLUMN canonical_email;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN canonical_email TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_canonical_email ON users (canonical_email) WHERE canonical_email IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE apps ADD COLUMN bind_tokens INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_enrollments_org_unit_id;
+ALTER TABLE enrollments DROP COLUMN org_unit_id;
+DROP INDEX IF EXISTS idx_org_units_parent_name;
+DROP TABLE IF EXISTS org_units;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS org_units (
+    id INTEGER PRIMARY KEY,
+    parent_id INTEGER,
+    name TEXT NOT NULL,
+    FOREIGN KEY (parent_id) REFERENCES org_units(id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_units_parent_name ON org_units (COALESCE(parent_id, 0), name);
+ALTER TABLE enrollments ADD COLUMN org_unit_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_enrollments_org_unit_id ON enrollments (org_unit_id) WHERE org_unit_id IS NOT NULL;
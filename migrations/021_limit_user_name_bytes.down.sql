@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS users_name_bytes_update;
+DROP TRIGGER IF EXISTS users_name_bytes_insert;
@@ -0,0 +1,20 @@
+-- SQLite cannot add a CHECK constraint to an existing table without
+-- rebuilding it and every table referencing it; these triggers hold the
+-- names of users to 255 bytes instead. Rows written before are left as
+-- they are until their names change.
+CREATE TRIGGER IF NOT EXISTS users_name_bytes_insert
+BEFORE INSERT ON users
+WHEN length(CAST(NEW.first_name AS BLOB)) > 255
+    OR length(CAST(NEW.last_name AS BLOB)) > 255
+    OR length(CAST(NEW.middle_name AS BLOB)) > 255
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: user names must be at most 255 bytes');
+END;
+CREATE TRIGGER IF NOT EXISTS users_name_bytes_update
+BEFORE UPDATE OF first_name, last_name, middle_name ON users
+WHEN (NEW.first_name IS NOT OLD.first_name AND length(CAST(NEW.first_name AS BLOB)) > 255)
+    OR (NEW.last_name IS NOT OLD.last_name AND length(CAST(NEW.last_name AS BLOB)) > 255)
+    OR (NEW.middle_name IS NOT OLD.middle_name AND length(CAST(NEW.middle_name AS BLOB)) > 255)
+BEGIN
+    SELECT RAISE(ABORT, 'CHECK constraint failed: user names must be at most 255 bytes');
+END;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_token_issuances_retain_until;
+DROP TABLE IF EXISTS token_issuances;
@@ -0,0 +1,14 @@
+-- Only the fingerprint of a token is kept, never the token.
+CREATE TABLE IF NOT EXISTS token_issuances (
+    fingerprint TEXT PRIMARY KEY,
+    jti TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    app_id INTEGER NOT NULL,
+    issued_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    retain_until INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (app_id) REFERENCES apps(id)
+);
+CREATE INDEX IF NOT EXISTS idx_token_issuances_retain_until ON token_issuances (retain_until);
@@ -0,0 +1,3 @@
+-- Expand phase of moving the scheme tag out of pass_hash, see
+-- sqlite.Compat: NULL until written or backfilled.
+ALTER TABLE users ADD COLUMN hash_version TEXT;
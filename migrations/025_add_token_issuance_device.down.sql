@@ -0,0 +1 @@
+ALTER TABLE token_issuances DROP COLUMN device;
@@ -0,0 +1,3 @@
+-- The device label of the client a token was issued to, see useragent.Label.
+-- Empty for the tokens issued before.
+ALTER TABLE token_issuances ADD COLUMN device TEXT NOT NULL DEFAULT '';
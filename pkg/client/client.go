@@ -0,0 +1,234 @@
+// Package client is the Go client of the SSO for the services that
+// authenticate their users with it. It wraps the gRPC connection with
+// dial defaults, per-call timeouts and retries of Unavailable calls, and
+// maps the statuses of the server back to the errors of this package:
+//
+//	c, err := client.New("sso:44044", client.WithAppCredentials(1, secret))
+//	...
+//	token, err := c.Login(ctx, email, password, 1)
+//	if errors.Is(err, client.ErrInvalidCredentials) {
+//		...
+//	}
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// APIVersion is the API version of the server the client speaks, sent
+// with every call.
+const APIVersion = 1
+
+// Metadata keys of the server.
+const (
+	apiVersionHeader = "x-api-version"
+	appIDHeader      = "x-app-id"
+	appSecretHeader  = "x-app-secret"
+	tosVersionHeader = "x-tos-version"
+	inviteHeader     = "x-invite-code"
+
+	whoAmIMethod = "/sso.session.v1.Session/WhoAmI"
+)
+
+// SSO is the interface of Client, for consumers to mock.
+type SSO interface {
+	Login(ctx context.Context, email, password string, appID int32) (string, error)
+	Register(ctx context.Context, reg Registration) (int64, error)
+	UserRole(ctx context.Context, userID int64) (string, error)
+	ValidateToken(ctx context.Context, token string) (Identity, error)
+}
+
+var _ SSO = (*Client)(nil)
+
+// Registration is a user to register, see Client.Register.
+type Registration struct {
+	Email     string
+	Password  string
+	FirstName string
+	LastName  string
+	// MiddleName is optional. Blank means none.
+	MiddleName string
+	// TOSVersion is the version of the terms of service the user
+	// accepted, required if the server requires one.
+	TOSVersion string
+	// InviteCode is required while registration is invite-only.
+	InviteCode string
+}
+
+// Identity is the holder of a token, see Client.ValidateToken.
+type Identity struct {
+	UserID    int64
+	Email     string
+	FirstName string
+	LastName  string
+	// MiddleName is empty if the user has none.
+	MiddleName string
+	Roles      []string
+	AppID      int32
+	AppName    string
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// Client is a connection to the SSO. It is safe for concurrent use.
+type Client struct {
+	conn *grpc.ClientConn
+	auth ssov1.AuthClient
+	opts options
+}
+
+// New returns a client of the SSO at addr, a host:port or any target
+// grpc.NewClient takes. The connection is made on the first call.
+func New(addr string, opts ...Option) (*Client, error) {
+	const op = "client.New"
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	conn, err := grpc.NewClient(addr, o.dial()...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Client{conn: conn, auth: ssov1.NewAuthClient(conn), opts: o}, nil
+}
+
+// Close closes the connection. Calls in flight fail.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Login returns a token of the user for the app.
+//
+// Wrong credentials give ErrInvalidCredentials.
+func (c *Client) Login(ctx context.Context, email, password string, appID int32) (string, error) {
+	resp, err := c.auth.Login(c.outgoing(ctx), &ssov1.LoginRequest{
+		Email:    email,
+		Password: password,
+		AppId:    appID,
+	})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+
+	return resp.GetToken(), nil
+}
+
+// Register registers the user and returns its ID, which is zero if the
+// server conceals whether emails are registered. With
+// WithAppCredentials, the user is attributed to that app.
+//
+// A taken email gives ErrUserExists.
+func (c *Client) Register(ctx context.Context, reg Registration) (int64, error) {
+	ctx = c.outgoing(ctx)
+	if reg.TOSVersion != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, tosVersionHeader, reg.TOSVersion)
+	}
+	if reg.InviteCode != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, inviteHeader, reg.InviteCode)
+	}
+
+	resp, err := c.auth.Register(ctx, &ssov1.RegisterRequest{
+		Email:      reg.Email,
+		Password:   reg.Password,
+		FirstName:  reg.FirstName,
+		LastName:   reg.LastName,
+		MiddleName: reg.MiddleName,
+	})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+
+	return resp.GetUserId(), nil
+}
+
+// UserRole returns the role of the user, empty if it has none.
+//
+// An unknown user gives ErrUserNotFound.
+func (c *Client) UserRole(ctx context.Context, userID int64) (string, error) {
+	resp, err := c.auth.UserRole(c.outgoing(ctx), &ssov1.UserRoleRequest{UserId: userID})
+	if err != nil {
+		return "", fromStatus(err)
+	}
+
+	return resp.GetRole(), nil
+}
+
+// ValidateToken returns the holder of the token, as the server sees it:
+// a token of a deleted user or of revoked sessions is refused even if it
+// has not expired.
+//
+// An invalid token gives ErrInvalidToken.
+func (c *Client) ValidateToken(ctx context.Context, token string) (Identity, error) {
+	ctx = metadata.AppendToOutgoingContext(c.outgoing(ctx), "authorization", "Bearer "+token)
+
+	var resp structpb.Struct
+	if err := c.conn.Invoke(ctx, whoAmIMethod, &emptypb.Empty{}, &resp); err != nil {
+		err = fromStatus(err)
+		// Whatever the reason, the token does not authenticate.
+		if e := (*Error)(nil); errors.As(err, &e) && e.status.Code() == codes.Unauthenticated {
+			e.err = ErrInvalidToken
+		}
+
+		return Identity{}, err
+	}
+
+	return identityOf(&resp), nil
+}
+
+// outgoing adds the metadata of every call to ctx.
+func (c *Client) outgoing(ctx context.Context) context.Context {
+	kv := []string{apiVersionHeader, strconv.Itoa(APIVersion)}
+	if c.opts.appID != 0 {
+		kv = append(kv,
+			appIDHeader, strconv.Itoa(int(c.opts.appID)),
+			appSecretHeader, c.opts.appSecret,
+		)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+func identityOf(s *structpb.Struct) Identity {
+	fields := s.GetFields()
+	str := func(key string) string { return fields[key].GetStringValue() }
+	num := func(key string) float64 { return fields[key].GetNumberValue() }
+	at := func(key string) time.Time {
+		t, _ := time.Parse(time.RFC3339, str(key))
+		return t
+	}
+
+	var roles []string
+	for _, v := range fields["roles"].GetListValue().GetValues() {
+		roles = append(roles, v.GetStringValue())
+	}
+
+	return Identity{
+		UserID:     int64(num("user_id")),
+		Email:      str("email"),
+		FirstName:  str("first_name"),
+		LastName:   str("last_name"),
+		MiddleName: str("middle_name"),
+		Roles:      roles,
+		AppID:      int32(num("app_id")),
+		AppName:    str("app_name"),
+		IssuedAt:   at("issued_at"),
+		ExpiresAt:  at("expires_at"),
+	}
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"sso/internal/domain/errs"
+	"sso/internal/domain/models"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/grpc/grpcerr"
+	"sso/internal/grpc/interceptors"
+	sessiongrpc "sso/internal/grpc/session"
+	"sso/internal/services/auth"
+	"sso/internal/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serveSSO serves the Auth and Session services of an auth service over
+// memory storage, the way the server registers them, and returns a client
+// of it.
+func serveSSO(t *testing.T, opts ...Option) (*Client, *memory.Storage) {
+	t.Helper()
+
+	storage := memory.New()
+	storage.SaveApp(models.App{ID: 1, Name: "lms", Secret: "test-secret"})
+	a, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		auth.WithHasher(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+		auth.WithTokenTTL(time.Hour),
+	)
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		interceptors.Authorize(map[string]interceptors.Policy{sessiongrpc.WhoAmIMethod: {}}, a, nil),
+	))
+	authgrpc.Register(srv, a)
+	sessiongrpc.Register(srv, a, nil, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	c, err := New(l.Addr().String(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c, storage
+}
+
+func TestClient(t *testing.T) {
+	ctx := t.Context()
+	c, storage := serveSSO(t)
+
+	id, err := c.Register(ctx, Registration{
+		Email:     "student@example.com",
+		Password:  "correct-password",
+		FirstName: "Jane",
+		LastName:  "Doe",
+	})
+	require.NoError(t, err)
+	assert.Positive(t, id)
+
+	_, err = c.Register(ctx, Registration{
+		Email:     "student@example.com",
+		Password:  "correct-password",
+		FirstName: "Jane",
+		LastName:  "Doe",
+	})
+	assert.ErrorIs(t, err, ErrUserExists)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = c.Login(ctx, "student@example.com", "wrong-password", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	var e *Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, string(errs.InvalidCredentials), e.Reason)
+
+	token, err := c.Login(ctx, "student@example.com", "correct-password", 1)
+	require.NoError(t, err)
+
+	identity, err := c.ValidateToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, id, identity.UserID)
+	assert.Equal(t, "student@example.com", identity.Email)
+	assert.Equal(t, int32(1), identity.AppID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), identity.ExpiresAt, time.Minute)
+
+	_, err = c.ValidateToken(ctx, token+"x")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = c.ValidateToken(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	storage.SetUserRole(id, "teacher")
+	role, err := c.UserRole(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "teacher", role)
+
+	_, err = c.UserRole(ctx, id+1)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+// TestReasons keeps the reasons mapped in step with the codes of the
+// server.
+func TestReasons(t *testing.T) {
+	for _, err := range []*errs.Error{
+		errs.ErrInvalidCredentials,
+		errs.ErrUserExists,
+		errs.ErrUserNotFound,
+		errs.ErrInvalidToken,
+	} {
+		mapped := fromStatus(grpcerr.Status(err))
+		var e *Error
+		require.True(t, errors.As(mapped, &e), err.Code)
+		assert.Contains(t, reasons, e.Reason, err.Code)
+	}
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	for _, opt := range []Option{
+		WithCallTimeout(-time.Second),
+		WithRetries(0, time.Millisecond, time.Second),
+		WithRetries(3, time.Second, time.Millisecond),
+		WithAppCredentials(-1, "secret"),
+	} {
+		_, err := New("127.0.0.1:0", opt)
+		assert.Error(t, err)
+	}
+}
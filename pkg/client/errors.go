@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors the statuses of the server are mapped back to. Match them with
+// errors.Is; Error carries the status itself.
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrUnavailable        = errors.New("sso unavailable")
+)
+
+// reasons map the ErrorInfo reasons of the server, its error codes, to
+// the errors of this package. Statuses without one of them are mapped by
+// their gRPC code, see fromStatus.
+var reasons = map[string]error{
+	"INVALID_CREDENTIALS": ErrInvalidCredentials,
+	"USER_EXISTS":         ErrUserExists,
+	"USER_NOT_FOUND":      ErrUserNotFound,
+	"INVALID_TOKEN":       ErrInvalidToken,
+}
+
+var codeErrors = map[codes.Code]error{
+	codes.InvalidArgument:  ErrInvalidArgument,
+	codes.Unauthenticated:  ErrUnauthenticated,
+	codes.PermissionDenied: ErrPermissionDenied,
+	codes.Unavailable:      ErrUnavailable,
+}
+
+// Error is a call the server failed. It unwraps to the error of this
+// package its status maps to, if any.
+type Error struct {
+	// Reason is the ErrorInfo reason of the status, empty if it has none.
+	Reason string
+
+	status *status.Status
+	err    error
+}
+
+func (e *Error) Error() string {
+	return "sso: " + e.status.Message()
+}
+
+func (e *Error) Unwrap() error { return e.err }
+
+// GRPCStatus returns the status of the server, so status.Code and
+// status.Convert see through Error.
+func (e *Error) GRPCStatus() *status.Status { return e.status }
+
+// fromStatus maps the error of a call to an Error. Errors without a status,
+// context ones among them, are returned unchanged.
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	e := &Error{status: st, err: codeErrors[st.Code()]}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			e.Reason = info.GetReason()
+			if mapped, ok := reasons[e.Reason]; ok {
+				e.err = mapped
+			}
+
+			break
+		}
+	}
+
+	return e
+}
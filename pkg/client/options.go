@@ -0,0 +1,125 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Defaults of New.
+const (
+	DefaultCallTimeout = 5 * time.Second
+	DefaultMaxAttempts = 3
+	DefaultBaseBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff  = 2 * time.Second
+)
+
+// DefaultKeepalive pings an idle connection once a minute, well above the
+// grpc.keepalive.min_time the server enforces by default.
+var DefaultKeepalive = keepalive.ClientParameters{
+	Time:    time.Minute,
+	Timeout: 10 * time.Second,
+}
+
+type options struct {
+	tls         *tls.Config
+	keepalive   keepalive.ClientParameters
+	callTimeout time.Duration
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	appID       int32
+	appSecret   string
+	dialOptions []grpc.DialOption
+}
+
+func defaultOptions() options {
+	return options{
+		keepalive:   DefaultKeepalive,
+		callTimeout: DefaultCallTimeout,
+		maxAttempts: DefaultMaxAttempts,
+		baseBackoff: DefaultBaseBackoff,
+		maxBackoff:  DefaultMaxBackoff,
+	}
+}
+
+// Option configures Client, see New.
+type Option func(*options)
+
+// WithTLS connects over TLS configured by cfg. Default plaintext, for
+// servers on a private network.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) { o.tls = cfg }
+}
+
+// WithKeepalive sets the keepalive pings of the connection. Default
+// DefaultKeepalive.
+func WithKeepalive(params keepalive.ClientParameters) Option {
+	return func(o *options) { o.keepalive = params }
+}
+
+// WithCallTimeout bounds every attempt of a call unless its context ends
+// sooner, zero for no bound. Default DefaultCallTimeout.
+func WithCallTimeout(d time.Duration) Option {
+	return func(o *options) { o.callTimeout = d }
+}
+
+// WithRetries sets how many times a call is attempted in all, one to
+// never retry, and the bounds of the jittered exponential backoff between
+// attempts. Only Unavailable calls are retried. Defaults
+// DefaultMaxAttempts, DefaultBaseBackoff and DefaultMaxBackoff.
+func WithRetries(maxAttempts int, base, max time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = maxAttempts
+		o.baseBackoff = base
+		o.maxBackoff = max
+	}
+}
+
+// WithAppCredentials sends the credentials of the app with every call,
+// which the server requires for the methods of grpc.app_credentials.
+func WithAppCredentials(appID int32, secret string) Option {
+	return func(o *options) {
+		o.appID = appID
+		o.appSecret = secret
+	}
+}
+
+// WithDialOptions adds opts to those of the connection, after the
+// defaults, so they can override them.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) { o.dialOptions = append(o.dialOptions, opts...) }
+}
+
+func (o options) validate() error {
+	switch {
+	case o.callTimeout < 0:
+		return errors.New("call timeout must not be negative")
+	case o.maxAttempts < 1:
+		return errors.New("max attempts must be at least one")
+	case o.baseBackoff <= 0 || o.maxBackoff < o.baseBackoff:
+		return errors.New("backoff must be positive and its max at least its base")
+	case o.appID < 0:
+		return errors.New("app id must not be negative")
+	}
+
+	return nil
+}
+
+func (o options) dial() []grpc.DialOption {
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+
+	return append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(o.keepalive),
+		grpc.WithChainUnaryInterceptor(retry(o)),
+	}, o.dialOptions...)
+}
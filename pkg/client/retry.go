@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retry returns an interceptor bounding each attempt of a call with the
+// call timeout and attempting Unavailable calls again after a jittered
+// exponential backoff, or the RetryInfo delay of the server if longer, up
+// to the max backoff.
+//
+// Unavailable is the only code retried: the server returns it for
+// failures that may pass, such as a storage that is down, while every
+// other code, InvalidArgument and AlreadyExists included, would come back
+// the same. A retried Register that had in fact gone through comes back
+// as ErrUserExists.
+func retry(o options) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply any,
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		callOpts ...grpc.CallOption,
+	) error {
+		backoff := o.baseBackoff
+		for attempt := 1; ; attempt++ {
+			err := invokeOnce(ctx, o.callTimeout, method, req, reply, cc, invoker, callOpts...)
+			if err == nil || attempt >= o.maxAttempts || status.Code(err) != codes.Unavailable {
+				return err
+			}
+
+			// Full jitter, so that clients failed at once do not come
+			// back at once.
+			delay := rand.N(backoff) + 1
+			if hint := retryDelay(err); hint > delay {
+				delay = min(hint, o.maxBackoff)
+			}
+			backoff = min(backoff*2, o.maxBackoff)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func invokeOnce(
+	ctx context.Context,
+	timeout time.Duration,
+	method string,
+	req, reply any,
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	callOpts ...grpc.CallOption,
+) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return invoker(ctx, method, req, reply, cc, callOpts...)
+}
+
+// retryDelay returns the RetryInfo delay of the status of err, zero if it
+// has none.
+func retryDelay(err error) time.Duration {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyAuth fails the first failures calls of UserRole with code.
+type flakyAuth struct {
+	ssov1.UnimplementedAuthServer
+
+	code     codes.Code
+	failures int32
+	calls    atomic.Int32
+	delay    time.Duration
+}
+
+func (f *flakyAuth) UserRole(ctx context.Context, _ *ssov1.UserRoleRequest) (*ssov1.UserRoleResponse, error) {
+	if f.calls.Add(1) <= f.failures {
+		return nil, status.Error(f.code, "failing")
+	}
+	if f.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(f.delay):
+		}
+	}
+
+	return &ssov1.UserRoleResponse{Role: "teacher"}, nil
+}
+
+func serveFlaky(t *testing.T, f *flakyAuth, opts ...Option) *Client {
+	t.Helper()
+
+	srv := grpc.NewServer()
+	ssov1.RegisterAuthServer(srv, f)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	c, err := New(l.Addr().String(), append([]Option{WithRetries(3, time.Millisecond, 5*time.Millisecond)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+func TestRetry_Unavailable(t *testing.T) {
+	f := &flakyAuth{code: codes.Unavailable, failures: 2}
+	c := serveFlaky(t, f)
+
+	role, err := c.UserRole(t.Context(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, "teacher", role)
+	assert.Equal(t, int32(3), f.calls.Load())
+}
+
+func TestRetry_GivesUp(t *testing.T) {
+	f := &flakyAuth{code: codes.Unavailable, failures: 10}
+	c := serveFlaky(t, f)
+
+	_, err := c.UserRole(t.Context(), 1)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Equal(t, int32(3), f.calls.Load())
+}
+
+func TestRetry_NotRetried(t *testing.T) {
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.AlreadyExists, codes.Internal} {
+		t.Run(code.String(), func(t *testing.T) {
+			f := &flakyAuth{code: code, failures: 1}
+			c := serveFlaky(t, f)
+
+			_, err := c.UserRole(t.Context(), 1)
+			assert.Equal(t, code, status.Code(err))
+			assert.Equal(t, int32(1), f.calls.Load())
+		})
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	f := &flakyAuth{delay: time.Second}
+	c := serveFlaky(t, f, WithCallTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	_, err := c.UserRole(t.Context(), 1)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, int32(1), f.calls.Load(), "deadlines are not retried")
+}
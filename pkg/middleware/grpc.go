@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"sso/internal/lib/clientcert"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Rule is what a method requires of its callers.
+type Rule struct {
+	// Public methods take calls without a token.
+	Public bool
+	// Roles, when set, are the roles one of which the caller must have.
+	Roles []string
+}
+
+// UnaryServerInterceptor returns an interceptor requiring a token that v
+// verifies for every method, bar those rules make public, and the roles
+// rules require. rules are keyed by full method name, "/pkg.Service/Method",
+// or by bare method name. Calls without a valid token fail with
+// Unauthenticated, callers without the role with PermissionDenied and
+// failures of v with Unavailable. Handlers see the claims in their
+// context.
+func UnaryServerInterceptor(v Verifier, rules map[string]Rule) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, v, ruleOf(rules, info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams.
+func StreamServerInterceptor(v Verifier, rules map[string]Rule) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), v, ruleOf(rules, info.FullMethod))
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &verifiedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type verifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *verifiedStream) Context() context.Context { return s.ctx }
+
+func authorize(ctx context.Context, v Verifier, rule Rule) (context.Context, error) {
+	if rule.Public {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if values := md.Get("authorization"); len(values) > 0 {
+		token = bearer(values[0])
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, ErrNoToken.Error())
+	}
+
+	claims, err := v.Verify(withPeerCert(ctx), token)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return nil, status.Error(codes.Unauthenticated, ErrInvalidToken.Error())
+		}
+
+		return nil, status.Error(codes.Unavailable, "token verification unavailable")
+	}
+	if !hasAnyRole(claims, rule.Roles) {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+
+	return NewContext(ctx, claims), nil
+}
+
+// withPeerCert returns ctx carrying the client certificate of the call,
+// for tokens bound to it. Only certificates the TLS handshake verified
+// count.
+func withPeerCert(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if ok && len(tlsInfo.State.VerifiedChains) > 0 && len(tlsInfo.State.PeerCertificates) > 0 {
+		ctx = clientcert.NewContext(ctx, clientcert.Thumbprint(tlsInfo.State.PeerCertificates[0]))
+	}
+
+	return ctx
+}
+
+// ruleOf returns the rule of fullMethod, looked up by full name, then by
+// bare method name.
+func ruleOf(rules map[string]Rule, fullMethod string) Rule {
+	if rule, ok := rules[fullMethod]; ok {
+		return rule
+	}
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return rules[fullMethod[i+1:]]
+	}
+
+	return Rule{}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"sso/internal/lib/clientcert"
+)
+
+// Handler returns next wrapped to require a token that v verifies and,
+// if roles are given, a caller with one of them. Requests without a valid
+// token get 401 with a Bearer challenge, callers without the role 403 and
+// failures of v 503. next sees the claims in the request context.
+func Handler(v Verifier, next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearer(r.Header.Get("Authorization"))
+		if token == "" {
+			unauthorized(w, ErrNoToken)
+
+			return
+		}
+
+		claims, err := v.Verify(withClientCert(r), token)
+		if err != nil {
+			if errors.Is(err, ErrInvalidToken) {
+				unauthorized(w, ErrInvalidToken)
+
+				return
+			}
+			http.Error(w, "token verification unavailable", http.StatusServiceUnavailable)
+
+			return
+		}
+		if !hasAnyRole(claims, roles) {
+			http.Error(w, "permission denied", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+	})
+}
+
+// withClientCert returns the context of r carrying the client certificate
+// of the connection, for tokens bound to it. Only certificates the TLS
+// handshake verified count.
+func withClientCert(r *http.Request) context.Context {
+	ctx := r.Context()
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.PeerCertificates) > 0 {
+		ctx = clientcert.NewContext(ctx, clientcert.Thumbprint(r.TLS.PeerCertificates[0]))
+	}
+
+	return ctx
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"sso/internal/oidc"
+)
+
+// jwksRefreshInterval bounds how often an unknown kid refetches the keys,
+// so tokens with made up kids cannot hammer the SSO.
+const jwksRefreshInterval = time.Minute
+
+// JWKS is the key set the SSO publishes at its JWKS URL. It is fetched on
+// first use and again when a token names a key it does not hold, so a
+// rotation is picked up. It is safe for concurrent use.
+type JWKS struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKS returns the key set at url, the jwks_uri of the SSO, fetched
+// with httpClient, http.DefaultClient if nil.
+func NewJWKS(url string, httpClient *http.Client) *JWKS {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &JWKS{url: url, client: httpClient}
+}
+
+// PublicKey returns the key of kid, fetching the keys if it is unknown
+// and they were not fetched within the last minute.
+func (j *JWKS) PublicKey(ctx context.Context, kid string) (*rsa.PublicKey, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if key, ok := j.keys[kid]; ok {
+		return key, true
+	}
+	if !j.fetchedAt.IsZero() && time.Since(j.fetchedAt) < jwksRefreshInterval {
+		return nil, false
+	}
+
+	// A failed fetch keeps the keys held, and is retried once the
+	// interval has passed.
+	j.fetchedAt = time.Now()
+	if keys, err := j.fetch(ctx); err == nil {
+		j.keys = keys
+	}
+	key, ok := j.keys[kid]
+
+	return key, ok
+}
+
+// Refresh fetches the keys now, for instance to fail fast on startup.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	keys, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.keys = keys
+	j.fetchedAt = time.Now()
+
+	return nil
+}
+
+func (j *JWKS) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	const op = "middleware.JWKS.fetch"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %d", op, resp.StatusCode)
+	}
+
+	var jwks oidc.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		key, err := publicKey(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", op, jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func publicKey(jwk oidc.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid e")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
@@ -0,0 +1,332 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	authgrpc "sso/internal/grpc/auth"
+	"sso/internal/grpc/interceptors"
+	sessiongrpc "sso/internal/grpc/session"
+	"sso/internal/lib/clientcert"
+	"sso/internal/lib/jwt"
+	"sso/internal/oidc"
+	"sso/internal/services/auth"
+	"sso/internal/storage/memory"
+	"sso/pkg/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testApp = models.App{ID: 1, Name: "lms", Secret: "test-secret"}
+
+func hsToken(t *testing.T, userID int64, ttl time.Duration) string {
+	t.Helper()
+
+	token, err := jwt.GenerateNewToken(nil, models.User{ID: userID, Email: "student@example.com"}, testApp, ttl, "")
+	require.NoError(t, err)
+
+	return token
+}
+
+// whoami answers with the user ID and roles of the claims.
+var whoami = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	userID, _ := UserID(r.Context())
+	if HasRole(r.Context(), "teacher") {
+		w.Header().Set("X-Teacher", "1")
+	}
+	_, _ = io.WriteString(w, strconv.FormatInt(userID, 10))
+})
+
+func get(t *testing.T, h http.Handler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHandler_Local(t *testing.T) {
+	v := Local(LocalOptions{Secrets: map[int32]string{testApp.ID: testApp.Secret}})
+	h := Handler(v, whoami)
+
+	rec := get(t, h, "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+
+	rec = get(t, h, hsToken(t, 7, time.Hour)+"x")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = get(t, h, hsToken(t, 7, -time.Hour))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "expired")
+
+	rec = get(t, h, hsToken(t, 7, time.Hour))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Body.String())
+
+	// Other apps are not trusted.
+	other, err := jwt.GenerateNewToken(nil, models.User{ID: 7}, models.App{ID: 2, Secret: "other"}, time.Hour, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, get(t, h, other).Code)
+}
+
+func TestHandler_AppID(t *testing.T) {
+	wiki := models.App{ID: 2, Secret: "wiki-secret"}
+	secrets := map[int32]string{testApp.ID: testApp.Secret, wiki.ID: wiki.Secret}
+	wikiToken, err := jwt.GenerateNewToken(nil, models.User{ID: 7}, wiki, time.Hour, "")
+	require.NoError(t, err)
+
+	h := Handler(Local(LocalOptions{Secrets: secrets, AppID: testApp.ID}), whoami)
+	assert.Equal(t, http.StatusOK, get(t, h, hsToken(t, 7, time.Hour)).Code)
+	assert.Equal(t, http.StatusUnauthorized, get(t, h, wikiToken).Code, "token of another app")
+
+	// Without an app, tokens of every app with a secret pass.
+	h = Handler(Local(LocalOptions{Secrets: secrets}), whoami)
+	assert.Equal(t, http.StatusOK, get(t, h, wikiToken).Code)
+}
+
+func TestHandler_CertBinding(t *testing.T) {
+	h := Handler(Local(LocalOptions{Secrets: map[int32]string{testApp.ID: testApp.Secret}}), whoami)
+	cert := &x509.Certificate{Raw: []byte("client-a")}
+	bound, err := jwt.GenerateNewToken(nil, models.User{ID: 7}, testApp, time.Hour, "", jwt.CertBinding(clientcert.Thumbprint(cert)))
+	require.NoError(t, err)
+	withCert := func(cert *x509.Certificate, verified bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+bound)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, get(t, h, bound).Code, "without a certificate")
+	assert.Equal(t, http.StatusUnauthorized, withCert(&x509.Certificate{Raw: []byte("client-b")}, true).Code, "another certificate")
+	assert.Equal(t, http.StatusUnauthorized, withCert(cert, false).Code, "unverified certificate")
+	rec := withCert(cert, true)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Body.String())
+
+	// Unbound tokens pass with or without one.
+	assert.Equal(t, http.StatusOK, get(t, h, hsToken(t, 7, time.Hour)).Code)
+}
+
+func TestHandler_Roles(t *testing.T) {
+	secrets := map[int32]string{testApp.ID: testApp.Secret}
+
+	// Without a role lookup, nobody has a role.
+	h := Handler(Local(LocalOptions{Secrets: secrets}), whoami, "teacher")
+	assert.Equal(t, http.StatusForbidden, get(t, h, hsToken(t, 7, time.Hour)).Code)
+
+	roles := func(_ context.Context, userID int64) (string, error) {
+		switch userID {
+		case 7:
+			return "teacher", nil
+		case 8:
+			return "student", nil
+		case 9:
+			return "", client.ErrUserNotFound
+		}
+
+		return "", client.ErrUnavailable
+	}
+	h = Handler(Local(LocalOptions{Secrets: secrets, Roles: roles}), whoami, "admin", "teacher")
+
+	rec := get(t, h, hsToken(t, 7, time.Hour))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("X-Teacher"))
+	assert.Equal(t, http.StatusForbidden, get(t, h, hsToken(t, 8, time.Hour)).Code)
+	assert.Equal(t, http.StatusUnauthorized, get(t, h, hsToken(t, 9, time.Hour)).Code, "token outlived its user")
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, h, hsToken(t, 10, time.Hour)).Code)
+}
+
+func TestLocal_JWKS(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key := jwt.NewKey(private)
+	keys := jwt.NewKeySet()
+	keys.Set(key, jwt.Key{})
+
+	var fetches atomic.Int32
+	jwksHandler := oidc.Handler("", keys)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		jwksHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	v := Local(LocalOptions{JWKS: NewJWKS(srv.URL+oidc.JWKSPath, nil)})
+
+	token, err := jwt.GenerateNewRS256Token(nil, models.User{ID: 7}, testApp, time.Hour, "", key)
+	require.NoError(t, err)
+	claims, err := v.Verify(t.Context(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	_, err = v.Verify(t.Context(), token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load(), "keys are cached")
+
+	// An unknown key refetches, though not more than once a minute.
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	forged, err := jwt.GenerateNewRS256Token(nil, models.User{ID: 7}, testApp, time.Hour, "", jwt.NewKey(other))
+	require.NoError(t, err)
+	for range 3 {
+		_, err = v.Verify(t.Context(), forged)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// HS256 tokens need the secret of their app.
+	_, err = v.Verify(t.Context(), hsToken(t, 7, time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestJWKS_Refresh(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	assert.Error(t, NewJWKS(srv.URL, nil).Refresh(t.Context()))
+}
+
+func callUnary(t *testing.T, interceptor grpc.UnaryServerInterceptor, method, token string) (Claims, error) {
+	t.Helper()
+
+	ctx := t.Context()
+	if token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+	}
+
+	var claims Claims
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, _ any) (any, error) {
+		claims, _ = ClaimsFromContext(ctx)
+
+		return nil, nil
+	})
+
+	return claims, err
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	v := Local(LocalOptions{
+		Secrets: map[int32]string{testApp.ID: testApp.Secret},
+		Roles: func(context.Context, int64) (string, error) {
+			return "student", nil
+		},
+	})
+	interceptor := UnaryServerInterceptor(v, map[string]Rule{
+		"/lms.Courses/ListCourses": {Public: true},
+		"SetGrade":                 {Roles: []string{"teacher"}},
+	})
+	token := hsToken(t, 7, time.Hour)
+
+	_, err := callUnary(t, interceptor, "/lms.Courses/ListCourses", "")
+	require.NoError(t, err)
+
+	_, err = callUnary(t, interceptor, "/lms.Courses/Enroll", "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = callUnary(t, interceptor, "/lms.Courses/Enroll", token+"x")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	claims, err := callUnary(t, interceptor, "/lms.Courses/Enroll", token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, []string{"student"}, claims.Roles)
+
+	_, err = callUnary(t, interceptor, "/lms.Grades/SetGrade", token)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+type failingVerifier struct{}
+
+func (failingVerifier) Verify(context.Context, string) (Claims, error) {
+	return Claims{}, errors.New("sso is down")
+}
+
+func TestUnaryServerInterceptor_VerifierFailure(t *testing.T) {
+	_, err := callUnary(t, UnaryServerInterceptor(failingVerifier{}, nil), "/lms.Courses/Enroll", "token")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// serveSSO serves the Auth and Session services of an auth service over
+// memory storage and returns a client of it.
+func serveSSO(t *testing.T) (*client.Client, *memory.Storage) {
+	t.Helper()
+
+	storage := memory.New()
+	storage.SaveApp(testApp)
+	a, err := auth.NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), storage, storage, storage,
+		auth.WithHasher(auth.BcryptHasher{Cost: bcrypt.MinCost}),
+	)
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		interceptors.Authorize(map[string]interceptors.Policy{sessiongrpc.WhoAmIMethod: {}}, a, nil),
+	))
+	authgrpc.Register(srv, a)
+	sessiongrpc.Register(srv, a, nil, nil)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	c, err := client.New(l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c, storage
+}
+
+func TestHandler_Remote(t *testing.T) {
+	ctx := t.Context()
+	c, storage := serveSSO(t)
+
+	id, err := c.Register(ctx, client.Registration{
+		Email:     "teacher@example.com",
+		Password:  "correct-password",
+		FirstName: "John",
+		LastName:  "Doe",
+	})
+	require.NoError(t, err)
+	storage.SetUserRole(id, "teacher")
+	token, err := c.Login(ctx, "teacher@example.com", "correct-password", testApp.ID)
+	require.NoError(t, err)
+
+	h := Handler(Remote(c), whoami, "teacher")
+
+	rec := get(t, h, token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, strconv.FormatInt(id, 10), rec.Body.String())
+	assert.Equal(t, "1", rec.Header().Get("X-Teacher"))
+
+	assert.Equal(t, http.StatusUnauthorized, get(t, h, token+"x").Code)
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"sso/internal/lib/clientcert"
+	"sso/internal/lib/jwt"
+	"sso/pkg/client"
+)
+
+// LocalOptions configure Local.
+type LocalOptions struct {
+	// Secrets are the secrets of the apps by ID, which HS256 tokens are
+	// signed with. Tokens of other apps are refused.
+	Secrets map[int32]string
+	// JWKS holds the keys RS256 tokens are signed with. Nil refuses them.
+	JWKS *JWKS
+	// Issuer, when set, must be the iss of tokens.
+	Issuer string
+	// AppID, when set, is the app tokens must be for: their app_id must be
+	// AppID and, unless Audience is set, their aud must name it. Without
+	// either, tokens of any app with a known secret or key pass.
+	AppID int32
+	// Audience, when set, must be in the aud of tokens.
+	Audience string
+	// Leeway is the clock skew tolerated in the expiry checks.
+	Leeway time.Duration
+	// Roles, when set, looks up the role of the user, which tokens do not
+	// carry, such as client.Client.UserRole. Without it, Claims have no
+	// roles and routes requiring one are forbidden.
+	Roles func(ctx context.Context, userID int64) (string, error)
+}
+
+type localVerifier struct {
+	opts LocalOptions
+}
+
+// Local returns a Verifier checking the signature and claims of tokens in
+// process. Tokens are trusted until they expire: those of deleted users
+// or revoked sessions pass, see Remote.
+//
+// A token bound to a client certificate (RFC 8705) passes only in requests
+// made over mTLS with that certificate, as Handler and the interceptors
+// find in the TLS state of the connection; see clientcert.
+func Local(opts LocalOptions) Verifier {
+	return localVerifier{opts: opts}
+}
+
+func (v localVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	parseOpts := jwt.ParseOptions{
+		AppSecret: func(appID int32) (string, error) {
+			secret, ok := v.opts.Secrets[appID]
+			if !ok {
+				return "", fmt.Errorf("%w: unknown app %d", ErrInvalidToken, appID)
+			}
+
+			return secret, nil
+		},
+		Issuer:   jwt.Issuer{Name: v.opts.Issuer},
+		AppID:    v.opts.AppID,
+		Audience: v.opts.Audience,
+		Leeway:   v.opts.Leeway,
+	}
+	if v.opts.JWKS != nil {
+		parseOpts.PublicKey = func(kid string) (*rsa.PublicKey, bool) {
+			return v.opts.JWKS.PublicKey(ctx, kid)
+		}
+	}
+
+	parsed, err := jwt.ParseToken(token, parseOpts)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			return Claims{}, err
+		}
+
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	if parsed.CertThumbprint != "" {
+		thumbprint, ok := clientcert.FromContext(ctx)
+		if !ok || subtle.ConstantTimeCompare([]byte(thumbprint), []byte(parsed.CertThumbprint)) != 1 {
+			return Claims{}, fmt.Errorf("%w: token is bound to another client certificate", ErrInvalidToken)
+		}
+	}
+
+	claims := Claims{
+		UserID:      parsed.UserID,
+		Email:       parsed.Email,
+		AppID:       parsed.AppID,
+		Permissions: parsed.Permissions,
+		ExpiresAt:   parsed.ExpiresAt,
+	}
+	if v.opts.Roles != nil {
+		role, err := v.opts.Roles(ctx, claims.UserID)
+		if err != nil {
+			if errors.Is(err, client.ErrUserNotFound) {
+				return Claims{}, fmt.Errorf("%w: user not found", ErrInvalidToken)
+			}
+
+			return Claims{}, fmt.Errorf("failed to look up role: %w", err)
+		}
+		if role != "" {
+			claims.Roles = []string{role}
+		}
+	}
+
+	return claims, nil
+}
+
+type remoteVerifier struct {
+	sso client.SSO
+}
+
+// Remote returns a Verifier asking the SSO through sso, which refuses the
+// tokens of deleted users and revoked sessions too, and tells the roles of
+// the user. It costs a call per request.
+//
+// The SSO checks a token bound to a client certificate against the
+// certificate of the call, which is that of the service rather than of
+// its caller, so bound tokens do not pass; verify them with Local.
+func Remote(sso client.SSO) Verifier {
+	return remoteVerifier{sso: sso}
+}
+
+func (v remoteVerifier) Verify(ctx context.Context, token string) (Claims, error) {
+	identity, err := v.sso.ValidateToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, client.ErrInvalidToken) {
+			return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+		}
+
+		return Claims{}, fmt.Errorf("failed to validate token: %w", err)
+	}
+
+	return Claims{
+		UserID:    identity.UserID,
+		Email:     identity.Email,
+		AppID:     identity.AppID,
+		Roles:     slices.DeleteFunc(identity.Roles, func(role string) bool { return role == "" }),
+		ExpiresAt: identity.ExpiresAt,
+	}, nil
+}
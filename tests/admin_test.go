@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"testing"
+
+	"sso/tests/suite"
+
+	ssov1 "github.com/Kaptoshka/course-work-protos/gen/go/sso"
+	"github.com/brianvoe/gofakeit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	getServerInfoMethod   = "/sso.admin.v1.Admin/GetServerInfo"
+	lookupTokenMethod     = "/sso.admin.v1.Admin/LookupToken"
+	assignRoleBulkMethod  = "/sso.admin.v1.Admin/AssignRoleBulk"
+	listUsersMethod       = "/sso.admin.v1.Admin/ListUsers"
+	usersExistMethod      = "/sso.admin.v1.Admin/UsersExist"
+	userRolesMethod       = "/sso.admin.v1.Admin/UserRoles"
+	setUserTokenTTLMethod = "/sso.admin.v1.Admin/SetUserTokenTTL"
+	createInviteMethod    = "/sso.admin.v1.Admin/CreateInvite"
+	getAppQuotaMethod     = "/sso.quota.v1.Quotas/GetAppQuota"
+	getAppUsageMethod     = "/sso.quota.v1.Quotas/GetAppUsage"
+	elevateMethod         = "/sso.session.v1.Session/ElevatePrivileges"
+
+	// The admin seeded by the test migrations.
+	adminEmail    = "admin@sso.test"
+	adminPassword = "admin-password"
+)
+
+func TestAdmin_RequiresAdminToken(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     email,
+		Password:  pass,
+		FirstName: gofakeit.FirstName(),
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	quotaReq, err := structpb.NewStruct(map[string]any{"app_id": appID})
+	require.NoError(t, err)
+	usageReq, err := structpb.NewStruct(map[string]any{"app_id": appID, "from": "2024-03-01"})
+	require.NoError(t, err)
+	rolesReq, err := structpb.NewStruct(map[string]any{"role": "admin", "user_ids": []any{1}})
+	require.NoError(t, err)
+	batchReq, err := structpb.NewStruct(map[string]any{"user_ids": []any{1}})
+	require.NoError(t, err)
+	ttlReq, err := structpb.NewStruct(map[string]any{"user_id": 1, "ttl_seconds": 60})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		req    any
+	}{
+		{name: "server info", method: getServerInfoMethod, req: &emptypb.Empty{}},
+		{name: "token lookup", method: lookupTokenMethod, req: wrapperspb.String(respLogin.GetToken())},
+		{name: "app quota", method: getAppQuotaMethod, req: quotaReq},
+		{name: "app usage", method: getAppUsageMethod, req: usageReq},
+		{name: "bulk role assignment", method: assignRoleBulkMethod, req: rolesReq},
+		{name: "user listing", method: listUsersMethod, req: &structpb.Struct{}},
+		{name: "users exist", method: usersExistMethod, req: batchReq},
+		{name: "user roles", method: userRolesMethod, req: batchReq},
+		{name: "token ttl override", method: setUserTokenTTLMethod, req: ttlReq},
+		{name: "invite creation", method: createInviteMethod, req: &structpb.Struct{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp structpb.Struct
+
+			err := st.AdminConn.Invoke(ctx, tt.method, tt.req, &resp)
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+			withToken := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+			err = st.AdminConn.Invoke(withToken, tt.method, tt.req, &resp)
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		})
+	}
+}
+
+func TestAdmin_ScheduleDeletion(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     gofakeit.Email(),
+		Password:  randomFakePassword(),
+		FirstName: gofakeit.FirstName(),
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: adminEmail, Password: adminPassword, AppId: appID})
+	require.NoError(t, err)
+
+	// Admins may delete any account, and get the token that cancels it.
+	req, err := structpb.NewStruct(map[string]any{"user_id": respReg.GetUserId()})
+	require.NoError(t, err)
+	var deletion structpb.Struct
+	withToken := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+	require.NoError(t, st.Conn.Invoke(withToken, scheduleDeletionMethod, req, &deletion))
+	assert.Equal(t, float64(respReg.GetUserId()), deletion.AsMap()["user_id"])
+
+	cancel, err := structpb.NewStruct(map[string]any{
+		"user_id":            respReg.GetUserId(),
+		"reactivation_token": deletion.AsMap()["reactivation_token"],
+	})
+	require.NoError(t, err)
+	require.NoError(t, st.Conn.Invoke(ctx, cancelDeletionMethod, cancel, &emptypb.Empty{}))
+}
+
+func TestAdmin_ElevatePrivileges(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     gofakeit.Email(),
+		Password:  randomFakePassword(),
+		FirstName: gofakeit.FirstName(),
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: adminEmail, Password: adminPassword, AppId: appID})
+	require.NoError(t, err)
+
+	rolesReq, err := structpb.NewStruct(map[string]any{"role": "teacher", "user_ids": []any{respReg.GetUserId()}})
+	require.NoError(t, err)
+
+	// The admin role is not enough.
+	var resp structpb.Struct
+	withToken := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+	err = st.AdminConn.Invoke(withToken, assignRoleBulkMethod, rolesReq, &resp)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	var reasons []string
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			reasons = append(reasons, info.GetReason())
+		}
+	}
+	assert.Equal(t, []string{"STEP_UP_REQUIRED"}, reasons)
+
+	var elevated wrapperspb.StringValue
+	err = st.Conn.Invoke(withToken, elevateMethod, wrapperspb.String("wrong-password"), &elevated)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.NoError(t, st.Conn.Invoke(withToken, elevateMethod, wrapperspb.String(adminPassword), &elevated))
+
+	withElevated := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+elevated.GetValue())
+	require.NoError(t, st.AdminConn.Invoke(withElevated, assignRoleBulkMethod, rolesReq, &resp))
+	assert.Equal(t, []any{map[string]any{
+		"user_id": float64(respReg.GetUserId()),
+		"outcome": "assigned",
+	}}, resp.AsMap()["results"])
+}
@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,12 +13,27 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
-	emptyAppID = 0
-	appID      = 1
-	appSecret  = "test-secret"
+	emptyAppID   = 0
+	appID        = 1
+	unknownAppID = 9999
+	appSecret    = "test-secret"
+	appName      = "test"
+	whoAmIMethod = "/sso.session.v1.Session/WhoAmI"
+	logoutMethod = "/sso.session.v1.Session/Logout"
+
+	scheduleDeletionMethod = "/sso.session.v1.Session/ScheduleDeletion"
+	cancelDeletionMethod   = "/sso.session.v1.Session/CancelDeletion"
+	linkIdentityMethod     = "/sso.identity.v1.Identities/LinkIdentity"
 
 	passDefaultLen = 10
 )
@@ -30,16 +47,28 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	lastName := gofakeit.LastName()
 	middleName := gofakeit.FirstName()
 
+	var header metadata.MD
 	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
 		Email:      email,
 		Password:   pass,
 		FirstName:  firstName,
 		LastName:   lastName,
 		MiddleName: middleName,
-	})
+	}, grpc.Header(&header))
 	require.NoError(t, err)
 	assert.NotEmpty(t, respReg.GetUserId())
 
+	profiles := header.Get("x-user-profile-bin")
+	require.Len(t, profiles, 1)
+	var profile structpb.Struct
+	require.NoError(t, proto.Unmarshal([]byte(profiles[0]), &profile))
+	fields := profile.AsMap()
+	assert.Equal(t, float64(respReg.GetUserId()), fields["user_id"])
+	assert.Equal(t, strings.ToLower(email), fields["email"])
+	assert.Equal(t, firstName, fields["first_name"])
+	assert.Equal(t, middleName, fields["middle_name"])
+	assert.NotEmpty(t, fields["created_at"])
+
 	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
 		Email:    email,
 		Password: pass,
@@ -69,6 +98,187 @@ func TestRegisterLogin_Login_HappyPath(t *testing.T) {
 	assert.InDelta(t, loginTime.Add(st.Cfg.TokenTTL).Unix(), claims["exp"], deltaSeconds)
 }
 
+func TestLogin_ByAppName(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     email,
+		Password:  pass,
+		FirstName: gofakeit.FirstName(),
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+
+	byName := metadata.AppendToOutgoingContext(ctx, "x-app-name", appName)
+	respLogin, err := st.AuthClient.Login(byName, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+	})
+	require.NoError(t, err)
+
+	tokenParsed, err := jwt.Parse(respLogin.GetToken(), func(token *jwt.Token) (interface{}, error) {
+		return []byte(appSecret), nil
+	})
+	require.NoError(t, err)
+	claims, ok := tokenParsed.Claims.(jwt.MapClaims)
+	require.True(t, ok)
+	assert.Equal(t, appID, int(claims["app_id"].(float64)))
+
+	_, err = st.AuthClient.Login(byName, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    unknownAppID,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWhoAmI(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+	firstName := gofakeit.FirstName()
+
+	respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     email,
+		Password:  pass,
+		FirstName: firstName,
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	var identity structpb.Struct
+	withToken := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+	require.NoError(t, st.Conn.Invoke(withToken, whoAmIMethod, &emptypb.Empty{}, &identity))
+
+	fields := identity.AsMap()
+	assert.Equal(t, float64(respReg.GetUserId()), fields["user_id"])
+	assert.Equal(t, email, fields["email"])
+	assert.Equal(t, firstName, fields["first_name"])
+	assert.Equal(t, appName, fields["app_name"])
+	assert.NotEmpty(t, fields["issued_at"])
+	assert.NotEmpty(t, fields["expires_at"])
+
+	withToken = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer forged")
+	err = st.Conn.Invoke(withToken, whoAmIMethod, &emptypb.Empty{}, &identity)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestLogout(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+		Email:     email,
+		Password:  pass,
+		FirstName: gofakeit.FirstName(),
+		LastName:  gofakeit.LastName(),
+	})
+	require.NoError(t, err)
+
+	respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{
+		Email:    email,
+		Password: pass,
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	withToken := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken())
+	require.NoError(t, st.Conn.Invoke(withToken, logoutMethod, &emptypb.Empty{}, &emptypb.Empty{}))
+
+	var identity structpb.Struct
+	err = st.Conn.Invoke(withToken, whoAmIMethod, &emptypb.Empty{}, &identity)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestScheduleDeletion(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	register := func() (int64, string, string) {
+		email, pass := gofakeit.Email(), randomFakePassword()
+		respReg, err := st.AuthClient.Register(ctx, &ssov1.RegisterRequest{
+			Email:     email,
+			Password:  pass,
+			FirstName: gofakeit.FirstName(),
+			LastName:  gofakeit.LastName(),
+		})
+		require.NoError(t, err)
+
+		return respReg.GetUserId(), email, pass
+	}
+	userID, email, pass := register()
+	_, otherEmail, otherPass := register()
+	login := func(email, pass string) (context.Context, error) {
+		respLogin, err := st.AuthClient.Login(ctx, &ssov1.LoginRequest{Email: email, Password: pass, AppId: appID})
+
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+respLogin.GetToken()), err
+	}
+	withToken, err := login(email, pass)
+	require.NoError(t, err)
+	withOtherToken, err := login(otherEmail, otherPass)
+	require.NoError(t, err)
+
+	var deletion structpb.Struct
+	err = st.Conn.Invoke(ctx, scheduleDeletionMethod, &structpb.Struct{}, &deletion)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ofUser, err := structpb.NewStruct(map[string]any{"user_id": userID})
+	require.NoError(t, err)
+	err = st.Conn.Invoke(withOtherToken, scheduleDeletionMethod, ofUser, &deletion)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "another user's account")
+
+	require.NoError(t, st.Conn.Invoke(withToken, scheduleDeletionMethod, &structpb.Struct{}, &deletion))
+	fields := deletion.AsMap()
+	assert.Equal(t, float64(userID), fields["user_id"])
+	assert.NotEmpty(t, fields["delete_after"])
+	reactivationToken, _ := fields["reactivation_token"].(string)
+	require.NotEmpty(t, reactivationToken)
+
+	// Pending: the token is revoked, logins are refused.
+	var identity structpb.Struct
+	err = st.Conn.Invoke(withToken, whoAmIMethod, &emptypb.Empty{}, &identity)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = login(email, pass)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	cancel := func(token string) error {
+		req, err := structpb.NewStruct(map[string]any{"user_id": userID, "reactivation_token": token})
+		require.NoError(t, err)
+
+		return st.Conn.Invoke(ctx, cancelDeletionMethod, req, &emptypb.Empty{})
+	}
+	assert.Equal(t, codes.InvalidArgument, status.Code(cancel("not-the-token")))
+	require.NoError(t, cancel(reactivationToken))
+
+	// Tokens issued within the second of the revocation count as revoked.
+	time.Sleep(time.Second)
+	withToken, err = login(email, pass)
+	require.NoError(t, err)
+	require.NoError(t, st.Conn.Invoke(withToken, whoAmIMethod, &emptypb.Empty{}, &identity))
+}
+
+func TestIdentities_NotServed(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	// No identity provider can be configured, so there is nothing to
+	// link to.
+	req, err := structpb.NewStruct(map[string]any{"provider": "google", "code": "code"})
+	require.NoError(t, err)
+	err = st.Conn.Invoke(ctx, linkIdentityMethod, req, &structpb.Struct{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
 func TestRegisterLogin_DuplicateRegistration(t *testing.T) {
 	ctx, st := suite.New(t)
 
@@ -236,6 +446,13 @@ func TestLogin_FailCases(t *testing.T) {
 			appID:       emptyAppID,
 			expectedErr: "app_id is required",
 		},
+		{
+			name:        "Login with Unknown AppID",
+			email:       gofakeit.Email(),
+			password:    randomFakePassword(),
+			appID:       unknownAppID,
+			expectedErr: "invalid app_id",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -0,0 +1,14 @@
+-- The admin of the e2e tests, with the password admin-password.
+INSERT INTO users (email, first_name, last_name, pass_hash, created_at, updated_at)
+VALUES ('admin@sso.test', 'Ada', 'Admin', CAST('$2a$10$47oh./MkIe2EgxKBD4yDSO4Zu8AlQxHp8C69zDRX4UnK..APmQf1q' AS BLOB), unixepoch() * 1000, unixepoch() * 1000)
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles (role)
+SELECT role FROM (SELECT 'admin' AS role UNION ALL SELECT 'teacher')
+WHERE role NOT IN (SELECT role FROM roles);
+
+INSERT INTO enrollments (user_id, role_id)
+SELECT u.id, r.id
+FROM users u, roles r
+WHERE u.email = 'admin@sso.test' AND r.role = 'admin'
+AND NOT EXISTS (SELECT 1 FROM enrollments WHERE user_id = u.id AND org_unit_id IS NULL);
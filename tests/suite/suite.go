@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"strconv"
+	"strings"
 	"testing"
 
 	"sso/internal/config"
@@ -17,6 +18,11 @@ type Suite struct {
 	*testing.T
 	Cfg        *config.Config
 	AuthClient ssov1.AuthClient
+	// Conn reaches the services that have no generated client yet.
+	Conn grpc.ClientConnInterface
+	// AdminConn reaches the admin services: Conn, unless they have
+	// listeners of their own, see grpc.admin.listen.
+	AdminConn grpc.ClientConnInterface
 }
 
 const (
@@ -45,13 +51,37 @@ func New(t *testing.T) (context.Context, *Suite) {
 		t.Fatalf("grpc server connection failed: %v", err)
 	}
 
+	adminConn := cc
+	if len(cfg.GRPC.Admin.Listen) > 0 {
+		adminConn, err = grpc.DialContext(context.Background(),
+			adminAddress(cfg),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		if err != nil {
+			t.Fatalf("admin grpc server connection failed: %v", err)
+		}
+	}
+
 	return ctx, &Suite{
 		T:          t,
 		Cfg:        cfg,
 		AuthClient: ssov1.NewAuthClient(cc),
+		Conn:       cc,
+		AdminConn:  adminConn,
 	}
 }
 
 func grpcAddress(cfg *config.Config) string {
 	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
 }
+
+// adminAddress returns the address of the first admin listener, which
+// must be a TCP one.
+func adminAddress(cfg *config.Config) string {
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(cfg.GRPC.Admin.Listen[0], "tcp://"))
+	if host == "" {
+		host = grpcHost
+	}
+
+	return net.JoinHostPort(host, port)
+}